@@ -0,0 +1,36 @@
+package vertex
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// requireAdmin protects server level endpoints (metrics, admin, etc) with the basic auth credentials from the
+// auth section of the config
+func requireAdmin(h http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		user, pass, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(Config.Auth.User)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(pass), []byte(Config.Auth.Password)) != 1 {
+
+			w.Header().Set("WWW-Authenticate", `Basic realm="vertex"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// requireAdminHandle protects an httprouter handle like requireAdmin
+func requireAdminHandle(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		requireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h(w, r, p)
+		})).ServeHTTP(w, r)
+	}
+}
@@ -29,10 +29,71 @@ type API struct {
 	DefaultSecurityScheme SecurityScheme
 	Renderer              Renderer
 	Routes                Routes
+	Scenarios             []*Scenario
 	Middleware            []Middleware
 	TestMiddleware        []Middleware
 	SwaggerMiddleware     []Middleware
 	AllowInsecure         bool
+
+	// What to do with plain http requests if AllowInsecure is false. Defaults to Reject
+	InsecurePolicy InsecurePolicy
+
+	// Optional custom classification of rendered errors for metrics. See ErrorClassifier
+	ErrorClassifier ErrorClassifier
+
+	// Maximum concurrent requests of the API when it shares a server with other APIs. 0 means no quota.
+	// Requests over the quota wait in a queue, served fairly with the queues of the other APIs
+	MaxConcurrency int
+
+	// Maximum number of requests waiting in the API's queue. 0 means unlimited
+	MaxQueued int
+
+	// How long requests wait in the queue before failing. Defaults to DefaultQueueTimeout
+	QueueTimeout time.Duration
+
+	// If set, the API's swagger spec and console require this security scheme. Defaults to the docs security of
+	// the server, see Server.SetDocsSecurity
+	DocsSecurity SecurityScheme
+
+	// Minimum versions of client apps allowed to call the API by client name. See Route.MinClientVersions
+	MinClientVersions map[string]MinClientVersion
+
+	// Messages localizable responses and error messages are rendered with, in the locale of the request
+	Catalog *Catalog
+
+	// Custom negotiators of response variants. See Negotiator
+	Negotiators []Negotiator
+
+	// Dot separated fields ignored when replaying test fixtures, e.g. "id" or "items.*.created". See FixturesReplay
+	FixtureIgnore []string
+
+	// Test data seeded by testers with TestContext.Seed and cleaned after the tests ran
+	TestFixtures Fixtures
+
+	// Setup and teardown hooks of the tests by category. See TestHooks
+	TestHooks map[string]TestHooks
+
+	// If set, request headers are screened by the policy before they reach the middleware
+	RequestHeaders *RequestHeaderPolicy
+
+	// If set, default response headers, removed headers and the header size budget are applied to all responses
+	ResponseHeaders *ResponseHeaderPolicy
+
+	// Directories of static files served by the API, e.g. its web frontend. See StaticDir
+	Static []StaticDir
+
+	// If set, JSON responses and errors are wrapped in an envelope, e.g. a StandardEnvelope. By default responses
+	// are rendered as they are
+	Envelope EnvelopeBuilder
+
+	// If set, errors are rendered as RFC 7807 problem details by all renderers. See ProblemDetails
+	Problems *ProblemDetails
+
+	scheduler          *scheduler
+	router             *httprouter.Router
+	serverDocsSecurity SecurityScheme
+	plugins            []Plugin
+	ingestRoutes       []ingestRoute
 }
 
 // return an httprouter compliant handler function for a route
@@ -45,6 +106,22 @@ func (a *API) handler(route Route) func(w http.ResponseWriter, r *http.Request,
 	}
 
 	validator := NewRequestValidator(route.requestInfo)
+	sanitizers := paramSanitizers(route.requestInfo.Params, route.Sanitize)
+	injected := injections(T)
+
+	if route.Queue != nil {
+		if T.Kind() != reflect.Struct || !reflect.PtrTo(T).Implements(reflect.TypeOf((*Ingester)(nil)).Elem()) {
+			logging.Error("Handler of ingestion route %s does not implement Ingester", route.Path)
+		} else {
+			a.ingestRoutes = append(a.ingestRoutes, ingestRoute{
+				path:      a.FullPath(route.Path),
+				queue:     route.Queue,
+				handler:   T,
+				injected:  injected,
+				consumers: route.Consumers,
+			})
+		}
+	}
 
 	security := route.Security
 	if security == nil {
@@ -52,8 +129,14 @@ func (a *API) handler(route Route) func(w http.ResponseWriter, r *http.Request,
 	}
 
 	// Build the middleware chain for the API middleware and the rout middleware.
-	// The route middleware comes after the API middleware
-	chain := buildChain(append(a.Middleware, route.Middleware...)...)
+	// The route middleware comes after the API middleware, and security is validated before both
+	// unless the middleware places it elsewhere. Relations are expanded right after the handler
+	mws := append(append([]Middleware{}, a.Middleware...), route.Middleware...)
+	mws = withSecurityStage(mws, security, a.FullPath(route.Path))
+	if route.Expand != nil {
+		mws = append(mws, expandMiddleware(route.Expand))
+	}
+	chain := buildChain(mws...)
 
 	// add the handler itself as the final middleware
 	handlerMW := MiddlewareFunc(func(w http.ResponseWriter, r *Request, next HandlerFunc) (interface{}, error) {
@@ -66,12 +149,37 @@ func (a *API) handler(route Route) func(w http.ResponseWriter, r *http.Request,
 			reqHandler = route.Handler
 		}
 
+		if err := checkClientVersion(r, a.MinClientVersions, route.MinClientVersions); err != nil {
+			return nil, err
+		}
+
+		if route.RequireIfMatch && isMutating(r.Method) && r.Header.Get("If-Match") == "" {
+			return nil, PreconditionRequiredError("The current entity version must be sent in an If-Match header")
+		}
+
+		// raw handlers parse the request themselves
+		if route.isRaw() {
+			return route.Handler.Handle(w, r)
+		}
+
 		//read params
-		if err := parseInput(r.Request, reqHandler, validator); err != nil {
+		if err := parseInput(r, reqHandler, validator); err != nil {
 			logging.Error("Error reading input: %s", err)
 			return nil, NewError(err)
 		}
+		sanitizeParams(reqHandler, sanitizers)
+
+		if route.mocked() {
+			return serveMock(w, &route)
+		}
 
+		if err := inject(reqHandler, injected); err != nil {
+			return nil, err
+		}
+
+		if route.Queue != nil {
+			return enqueue(w, r, route.Queue, reqHandler, injected)
+		}
 		return reqHandler.Handle(w, r)
 	})
 
@@ -83,56 +191,176 @@ func (a *API) handler(route Route) func(w http.ResponseWriter, r *http.Request,
 		chain.append(handlerMW)
 	}
 
-	return a.middlewareHandler(chain, security, route.Renderer)
+	return a.middlewareHandler(chain, security, route.Renderer, &route)
 }
 
-func (a *API) middlewareHandler(chain *step, security SecurityScheme, renderer Renderer) func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+// middlewareHandler returns an httprouter handler running a middleware chain. The security scheme is validated by
+// the chain - it is passed only to tell if the route is secured
+func (a *API) middlewareHandler(chain *step, security SecurityScheme, renderer Renderer, route *Route) func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 
 	// allow overriding the API's default renderer with a per-route one
 	if renderer == nil {
 		renderer = a.Renderer
 	}
 
+	routePath := a.FullPath(route.Path)
+	negotiators := a.negotiators()
+	if route.SLO != nil {
+		trackSLO(routePath, *route.SLO)
+	}
+	var trustedProxies []*net.IPNet
+	if a.RequestHeaders != nil {
+		trustedProxies = a.RequestHeaders.trustedNetworks()
+	}
+
 	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 
+		// screen the headers before anything reads them, the client address and scheme included
+		if a.RequestHeaders != nil {
+			if status, reason := a.RequestHeaders.apply(r, trustedProxies); status != 0 {
+				countErrorClass(routePath, SecurityError)
+				http.Error(w, reason, status)
+				return
+			}
+		}
+
+		sw := &statsWriter{ResponseWriter: w}
+		w = sw
+		if a.ResponseHeaders != nil {
+			w = newHeaderPolicyWriter(w, a.ResponseHeaders, routePath)
+		}
+
+		// in-process calls and batch loads made while handling the request are cached for its lifetime
+		ctx, slot := withRequestSlot(withBatches(withDispatchMemo(r.Context())))
+		r = r.WithContext(ctx)
+
 		req := NewRequest(r)
+		req.route = routePath
+		req.api = a
+		slot.req = req
+
+		// the request id is echoed before anything can fail the request, so every response carries it
+		w.Header().Set(HeaderRequestId, req.RequestId)
+		w.Header().Set(HeaderXRequestId, req.RequestId)
+
+		// in-process calls are as secure as the process, and run as the principal they were dispatched with
+		call := dispatchCallOf(r)
+		if call != nil {
+			req.Secure = true
+			if call.principal != nil {
+				req.SetPrincipal(call.principal)
+			}
+		}
+		defer trackRequest(req)()
+		// deferred work is handed to the pool before the request stops counting as in flight, so the drain status
+		// never misses it
+		defer req.runDeferred()
+		defer func() {
+			// in-process calls write nothing, their status is their error's
+			status := sw.status
+			if call != nil {
+				status = http.StatusOK
+				if call.err != nil {
+					status = errorStatus(call.err)
+				}
+			} else if status == 0 {
+				status = http.StatusOK
+			}
+			recordStats(r.Method, routePath, time.Now(), time.Since(req.StartTime), status, sw.bytes)
+			recordSLO(routePath, time.Now(), time.Since(req.StartTime), status)
+		}()
+		recordUsage(r.Method, routePath)
+		negotiate(negotiators, w, req)
 
 		if !a.AllowInsecure && !req.Secure {
 			// local requests bypass security
-			if req.RemoteIP != "127.0.0.1" {
-				http.Error(w, insecureAccessMessage, http.StatusForbidden)
+			if req.RemoteIP != "127.0.0.1" && !a.checkInsecure(w, req, routePath) {
 				return
 			}
 		}
 
-		r.ParseForm()
-		// Copy values from the router params to the request params
-		for _, v := range p {
-			r.Form.Set(v.Key, v.Value)
+		if route.isRaw() {
+			// raw routes parse their params themselves
+			req.params = p
+		} else {
+			r.ParseForm()
+			// Copy values from the router params to the request params
+			for _, v := range p {
+				r.Form.Set(v.Key, v.Value)
+			}
 		}
 
 		var ret interface{}
 		var err error
 
-		if security != nil {
-			if err = security.Validate(req); err != nil {
-				logging.Warning("Error validating security scheme: %s", err)
-
-				if e, ok := err.(*internalError); ok {
-					e.Code = ErrUnauthorized
-					err = e
-				}
+		// routes disabled, in maintenance or over their rate limit by ops overrides are rejected before taking a slot.
+		// In-process calls run within the slot of their caller, waiting for another one could deadlock it
+		err = checkRouteOverride(routePath)
+		var release func()
+		if err == nil && a.scheduler != nil && call == nil {
+			if release, err = a.scheduler.acquire(a); err == nil {
+				defer func() {
+					if release != nil {
+						release()
+					}
+				}()
 			}
 		}
 		if err == nil {
-			ret, err = chain.handle(w, req)
+			withProfiling(req, routePath, func() {
+				if route.Timeout > 0 {
+					// the chain may outlive a timeout, and releases the slot once it returns
+					ret, err = handleWithTimeout(route.Timeout, chain, w, req, release)
+					release = nil
+				} else {
+					ret, err = handleRecovering(chain, w, req)
+				}
+			})
+		}
+
+		if err != nil && !IsHijacked(err) {
+			a.countError(routePath, err)
+			emitErrorEvent(req, err)
+			recordRequestLog(req.RequestId, fmt.Sprintf("Request failed: %s", err))
+		}
+		countClient(req, err)
+
+		if err == nil {
+			w, ret = unwrapResponse(w, ret, security != nil)
+			ret = localize(req, ret)
+		} else if !IsHijacked(err) {
+			err = localizeError(req, err)
+		}
+
+		// in-process calls return the response rather than render it
+		if call != nil {
+			call.value, call.err = ret, err
+			return
+		}
+
+		if s, ok := ret.(Streamer); ok && err == nil {
+			// streams write the response themselves, unless they failed right away
+			if err = a.streamResponse(w, req, s); err == nil {
+				return
+			}
+			ret = nil
 		}
 
 		if err != Hijacked {
 
-			if err = renderer.Render(ret, err, w, req); err != nil {
+			if route.hasSizeLimits() {
+				err = a.renderLimited(renderer, route, routePath, ret, err, w, req)
+			} else {
+				err = renderer.Render(ret, err, w, req)
+			}
+			if err != nil {
 				logging.Error("Error rendering response: %s", err)
 			}
+
+			// renderers may write nothing for empty responses, the status is sent anyway
+			if sw, ok := w.(*statusWriter); ok && !sw.wroteHeader {
+				sw.WriteHeader(sw.status)
+			}
 		} else {
 			logging.Debug("Not rendering hijacked request %s", r.RequestURI)
 		}
@@ -141,6 +369,31 @@ func (a *API) middlewareHandler(chain *step, security SecurityScheme, renderer R
 
 }
 
+// unwrapResponse writes the headers of response wrappers returned by handlers, and returns the actual response
+// and the writer to render it with. secured is true if the route has a security scheme
+func unwrapResponse(w http.ResponseWriter, ret interface{}, secured bool) (http.ResponseWriter, interface{}) {
+
+	for {
+		switch v := ret.(type) {
+		case *CachedResponse:
+			v.writeHeaders(w, secured)
+			ret = v.Value
+		case *VersionedResponse:
+			w.Header().Set("ETag", ETag(v.Version))
+			ret = v.Value
+		case *Response:
+			w = v.writeHeaders(w)
+			ret = v.Body
+		case *BulkResponse, *CompositeResponse:
+			return &statusWriter{ResponseWriter: w, status: http.StatusMultiStatus}, ret
+		case *IngestReceipt:
+			return &statusWriter{ResponseWriter: w, status: http.StatusAccepted}, ret
+		default:
+			return w, ret
+		}
+	}
+}
+
 var routeRe = regexp.MustCompile("\\{([a-zA-Z_\\.0-9]+)\\}")
 
 func (a *API) root() string {
@@ -170,6 +423,8 @@ func (a *API) configure(router *httprouter.Router) *httprouter.Router {
 	if router == nil {
 		router = httprouter.New()
 	}
+	a.router = router
+	a.ingestRoutes = nil
 
 	for i, route := range a.Routes {
 
@@ -181,19 +436,21 @@ func (a *API) configure(router *httprouter.Router) *httprouter.Router {
 
 		pth := a.FullPath(route.Path)
 
-		if route.Methods&GET == GET {
-			logging.Info("Registering GET handler %v to path %s", h, pth)
-			router.Handle("GET", pth, h)
+		for _, m := range methodFlags {
+			if route.Methods&m.flag == m.flag {
+				logging.Info("Registering %s handler %v to path %s", m.name, h, pth)
+				router.Handle(m.name, pth, h)
+			}
 		}
-		if route.Methods&POST == POST {
-			logging.Info("Registering POST handler %v to path %s", h, pth)
-			router.Handle("POST", pth, h)
 
-		}
+	}
 
+	docsSecurity := a.DocsSecurity
+	if docsSecurity == nil {
+		docsSecurity = a.serverDocsSecurity
 	}
 
-	chain := buildChain(a.SwaggerMiddleware...)
+	chain := buildChain(withSecurityStage(a.SwaggerMiddleware, docsSecurity, a.FullPath("/swagger"))...)
 	if chain == nil {
 		chain = buildChain(a.swaggerHandler())
 	} else {
@@ -201,7 +458,7 @@ func (a *API) configure(router *httprouter.Router) *httprouter.Router {
 	}
 
 	// Server the API documentation swagger
-	router.GET(a.FullPath("/swagger"), a.middlewareHandler(chain, nil, nil))
+	router.GET(a.FullPath("/swagger"), a.middlewareHandler(chain, docsSecurity, nil, &Route{Path: "/swagger"}))
 
 	chain = buildChain(a.TestMiddleware...)
 	if chain == nil {
@@ -210,20 +467,38 @@ func (a *API) configure(router *httprouter.Router) *httprouter.Router {
 		chain.append(a.testHandler())
 	}
 
-	router.GET(path.Join("/test", a.root(), ":category"), a.middlewareHandler(chain, nil, nil))
+	// running the tests is an admin operation - it can target configured environments with their credentials
+	router.GET(path.Join("/test", a.root(), ":category"), requireAdminHandle(a.middlewareHandler(chain, nil, nil, &Route{Path: "/test"})))
+
+	a.registerStatic(router)
 
 	// Redirect /$api/$version/console => /console?url=/$api/$version/swagger
 	uiPath := fmt.Sprintf("/console?url=%s", url.QueryEscape(a.FullPath("/swagger")))
-	router.Handler("GET", a.FullPath("/console"), http.RedirectHandler(uiPath, 301))
+	router.Handler("GET", a.FullPath("/console"), secureDocs(docsSecurity, a.FullPath("/console"), http.RedirectHandler(uiPath, 301)))
 
 	return router
 
 }
 
-// swaggerHandler handles the swagger description request for the API
+// swaggerHandler handles the swagger description request for the API. The document is cached and pre-compressed,
+// since large APIs have multi-megabyte specs
 func (a *API) swaggerHandler() MiddlewareFunc {
+
+	docs := &swaggerDocs{}
+
 	return MiddlewareFunc(func(w http.ResponseWriter, r *Request, next HandlerFunc) (interface{}, error) {
-		apiDesc := a.ToSwagger(r.Host)
+
+		host := swaggerHost(r)
+
+		// JSONP responses are rendered as usual
+		if r.Callback == "" {
+			if doc := docs.get(a, host); doc != nil {
+				doc.serve(w, r)
+				return nil, Hijacked
+			}
+		}
+
+		apiDesc := a.ToSwagger(host)
 		return apiDesc, nil
 	})
 }
@@ -241,6 +516,18 @@ func (a *API) testHandler() MiddlewareFunc {
 
 		category := r.FormValue("category")
 
+		baseURL, forwardSecure, err := a.testBaseURL(r, serverAddr)
+		if err != nil {
+			return nil, err
+		}
+
+		var env *TestEnvironment
+		if name := r.FormValue("env"); name != "" {
+			if env, err = testEnvironment(name); err != nil {
+				return nil, InvalidParamError("%s", err)
+			}
+		}
+
 		format := r.FormValue("format")
 		switch format {
 		case TestFormatJson:
@@ -252,7 +539,14 @@ func (a *API) testHandler() MiddlewareFunc {
 
 		buf := bytes.NewBuffer(nil)
 
-		runner := newTestRunner(buf, a, fmt.Sprintf("http://%s", serverAddr), category, format)
+		runner := newTestRunner(buf, a, baseURL, category, format)
+		runner.forwardSecure = forwardSecure
+		runner.fixtures = fixtureConfig{mode: r.FormValue("fixtures"), dir: Config.Server.TestFixturesDir}
+		runner.requireCoverage = r.FormValue("require_coverage") == "true"
+		runner.fuzz = r.FormValue("fuzz") == "true"
+		if env != nil {
+			runner.setEnvironment(env)
+		}
 
 		st := time.Now()
 		success := runner.Run()
@@ -268,6 +562,45 @@ func (a *API) testHandler() MiddlewareFunc {
 	})
 }
 
+// retryResponse documents an error response carrying a Retry-After header
+func retryResponse(description string) swagger.Response {
+	return swagger.Response{
+		Description: description,
+		Headers: map[string]swagger.Header{
+			"Retry-After": {Type: swagger.Integer, Description: "Seconds to wait before retrying the request"},
+		},
+	}
+}
+
+// validationErrorResponse documents the body of validation errors, see validationErrorBody
+func validationErrorResponse() (swagger.Response, swagger.Schema) {
+
+	str := &jsonschema.Type{Type: "string"}
+	definition := swagger.Schema(&jsonschema.Schema{Type: &jsonschema.Type{
+		Type: "object",
+		Properties: map[string]*jsonschema.Type{
+			"message": str,
+			"violations": {
+				Type: "array",
+				Items: &jsonschema.Type{
+					Type: "object",
+					Properties: map[string]*jsonschema.Type{
+						"field":      str,
+						"constraint": str,
+						"value":      {},
+						"message":    str,
+					},
+				},
+			},
+		},
+	}})
+
+	return swagger.Response{
+		Description: "Invalid or missing params",
+		Schema:      swagger.Schema(&jsonschema.Schema{Type: &jsonschema.Type{Ref: "#/definitions/ValidationError"}}),
+	}, definition
+}
+
 // ToSwagger Converts an API definition into a swagger API object for serialization
 func (a API) ToSwagger(serverUrl string) *swagger.API {
 
@@ -280,6 +613,8 @@ func (a API) ToSwagger(serverUrl string) *swagger.API {
 	ret := swagger.NewAPI(serverUrl, a.Title, a.Doc, a.Version, a.FullPath(""), schemes)
 	ret.Consumes = []string{"text/json"}
 	ret.Produces = a.Renderer.ContentTypes()
+	ret.ErrorCodes = errorCodesSwagger()
+	ret.Extensions = a.pluginsSwagger()
 	for _, route := range a.Routes {
 
 		ri := route.requestInfo
@@ -302,6 +637,86 @@ func (a API) ToSwagger(serverUrl string) *swagger.API {
 			}
 		}
 
+		// and the definitions of body params
+		for _, parm := range method.Parameters {
+			if parm.Schema != nil && parm.Schema.Definitions != nil {
+				for k, v := range parm.Schema.Definitions {
+					ret.Definitions[k] = swagger.Schema(&jsonschema.Schema{Type: v})
+				}
+				parm.Schema.Definitions = nil
+			}
+		}
+
+		// routes may render with a different renderer than the API's
+		if route.Renderer != nil {
+			method.Produces = route.Renderer.ContentTypes()
+		}
+
+		method.Since = route.Since
+		method.ChangedIn = route.ChangedIn
+		if changelog := route.changelog(); changelog != "" {
+			if method.Description != "" {
+				method.Description += "\n\n"
+			}
+			method.Description += changelog
+		}
+
+		// requests with params may fail validation
+		if len(ri.Params) > 0 {
+			method.Responses["400"], ret.Definitions["ValidationError"] = validationErrorResponse()
+		}
+
+		// any route may ask clients to back off and retry later
+		method.Responses["429"] = retryResponse("Too many requests")
+		method.Responses["503"] = retryResponse("Temporarily unavailable")
+
+		if route.Example != nil {
+			resp := method.Responses["default"]
+			resp.Examples = map[string]interface{}{"application/json": route.Example}
+			method.Responses["default"] = resp
+		}
+
+		if isBulkResponse(route.Returns) {
+			method.Responses["207"] = swagger.Response{
+				Description: "Per item results. Items may succeed or fail independently",
+				Schema:      method.Responses["default"].Schema,
+			}
+		}
+		if isCompositeResponse(route.Returns) {
+			method.Responses["207"] = swagger.Response{
+				Description: "Per part results. Parts may succeed or fail independently",
+				Schema:      method.Responses["default"].Schema,
+			}
+		}
+
+		if len(a.MinClientVersions) > 0 || len(route.MinClientVersions) > 0 {
+			method.Responses["426"] = swagger.Response{
+				Description: "The client app version is no longer supported",
+				Headers: map[string]swagger.Header{
+					HeaderMinClientVersion: {Type: swagger.String, Description: "The minimum supported version"},
+					HeaderUpgradeURL:       {Type: swagger.String, Description: "Where to upgrade the app"},
+				},
+			}
+		}
+
+		if route.Expand != nil {
+			method.Parameters = append(method.Parameters, swagger.Param{
+				Name:        ExpandParam,
+				In:          "query",
+				Type:        swagger.String,
+				Description: route.Expand.describe(),
+			})
+		}
+
+		if route.Timeout > 0 {
+			method.Responses["504"] = swagger.Response{Description: fmt.Sprintf("The request did not finish within %v", route.Timeout)}
+		}
+
+		if route.RequireIfMatch {
+			method.Responses["412"] = swagger.Response{Description: "The If-Match version is not the current version"}
+			method.Responses["428"] = swagger.Response{Description: "Modifications require an If-Match header"}
+		}
+
 		// copy global param definitions to param definitions
 		for i, parm := range method.Parameters {
 			if parm.Global {
@@ -312,14 +727,10 @@ func (a API) ToSwagger(serverUrl string) *swagger.API {
 		}
 
 		// register methods
-		if route.Methods&POST == POST {
-			p["post"] = method
-		}
-		if route.Methods&GET == GET {
-			p["get"] = method
-		}
-		if route.Methods&PUT == PUT {
-			p["put"] = method
+		for _, m := range methodFlags {
+			if route.Methods&m.flag == m.flag {
+				p[strings.ToLower(m.name)] = method
+			}
 		}
 	}
 
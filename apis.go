@@ -0,0 +1,76 @@
+package vertex
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// APIsPath lists the APIs served by the server as JSON, for service discovery and documentation tools
+const APIsPath = "/apis"
+
+// Health states of listed APIs
+const (
+	HealthOK          = "ok"
+	HealthNotReady    = "not_ready"
+	HealthUnavailable = "unavailable"
+)
+
+// APIListing describes an API served by the server
+type APIListing struct {
+	Name    string `json:"name"`
+	Title   string `json:"title,omitempty"`
+	Version string `json:"version"`
+	Root    string `json:"root"`
+	Doc     string `json:"doc,omitempty"`
+	Spec    string `json:"spec"`
+	Console string `json:"console"`
+
+	// HealthOK, HealthNotReady if the server is draining or not serving yet, or HealthUnavailable if resources the
+	// server depends on are unhealthy
+	Health string `json:"health"`
+}
+
+// health returns the health state shared by the APIs of the server
+func (s *Server) health() string {
+	if !s.IsReady() {
+		return HealthNotReady
+	}
+	if errs := checkResources(); len(errs) > 0 {
+		return HealthUnavailable
+	}
+	return HealthOK
+}
+
+// listAPIs describes the APIs of the server, in the order they were added
+func (s *Server) listAPIs() []APIListing {
+
+	health := s.health()
+
+	ret := make([]APIListing, 0, len(s.apis))
+	for _, a := range s.apis {
+		ret = append(ret, APIListing{
+			Name:    a.Name,
+			Title:   a.Title,
+			Version: a.Version,
+			Root:    a.root(),
+			Doc:     a.Doc,
+			Spec:    a.FullPath("/swagger"),
+			Console: a.FullPath("/console"),
+			Health:  health,
+		})
+	}
+	return ret
+}
+
+// apisHandler lists the APIs of the server
+func (s *Server) apisHandler(w http.ResponseWriter, r *http.Request) {
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s.listAPIs()); err != nil {
+		logging.Error("Could not write API listing: %s", err)
+	}
+}
@@ -0,0 +1,66 @@
+package vertex
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIListing(t *testing.T) {
+
+	srv := NewServer(":9965")
+	srv.AddAPI(&API{
+		Name:          "listed",
+		Title:         "Listed API",
+		Version:       "1.0",
+		Doc:           "An API that is listed",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+	})
+	srv.AddAPI(&API{
+		Name:          "listed",
+		Version:       "2.0",
+		Root:          "/v2",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+	})
+
+	list := func() []APIListing {
+		hr, _ := http.NewRequest("GET", APIsPath, nil)
+		w := httptest.NewRecorder()
+		srv.apisHandler(w, hr)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var ret []APIListing
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &ret))
+		return ret
+	}
+
+	apis := list()
+	if assert.Len(t, apis, 2) {
+		assert.Equal(t, APIListing{
+			Name:    "listed",
+			Title:   "Listed API",
+			Version: "1.0",
+			Root:    "/listed/1.0",
+			Doc:     "An API that is listed",
+			Spec:    "/listed/1.0/swagger",
+			Console: "/listed/1.0/console",
+			Health:  HealthNotReady,
+		}, apis[0])
+		assert.Equal(t, "/v2", apis[1].Root)
+		assert.Equal(t, "/v2/swagger", apis[1].Spec)
+	}
+
+	srv.setReady(true)
+	assert.Equal(t, HealthOK, list()[0].Health)
+
+	RegisterResource("listed-db", func(ctx context.Context) error { return errors.New("down") })
+	defer RegisterResource("listed-db", nil)
+	assert.Equal(t, HealthUnavailable, list()[1].Health)
+}
@@ -0,0 +1,204 @@
+package vertex
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// AuditRecord is a single entry in the audit log, tagged with the principal it relates to
+type AuditRecord struct {
+	Time        time.Time              `json:"time"`
+	RequestId   string                 `json:"request_id,omitempty"`
+	PrincipalID string                 `json:"principal_id,omitempty"`
+	RemoteIP    string                 `json:"remote_ip,omitempty"`
+	Client      string                 `json:"client,omitempty"`
+	Method      string                 `json:"method,omitempty"`
+	Path        string                 `json:"path,omitempty"`
+	Event       string                 `json:"event"`
+	Details     map[string]interface{} `json:"details,omitempty"`
+}
+
+// NewAuditRecord creates an audit record for an event in the context of a request
+func NewAuditRecord(r *Request, event string, details map[string]interface{}) AuditRecord {
+	return AuditRecord{
+		Time:        time.Now(),
+		RequestId:   r.RequestId,
+		PrincipalID: r.PrincipalID(),
+		RemoteIP:    r.RemoteIP,
+		Client:      r.Client().String(),
+		Method:      r.Method,
+		Path:        r.URL.Path,
+		Event:       event,
+		Details:     details,
+	}
+}
+
+// SubjectDataStore is implemented by every store that persists request data tagged with principal ids,
+// so data subject requests can be served for all of them at once. See ExportSubjectData and PurgeSubjectData
+//
+// The principal id passed to stores is never empty
+type SubjectDataStore interface {
+	// ExportSubject returns all the data stored about a principal
+	ExportSubject(principalID string) (interface{}, error)
+
+	// PurgeSubject deletes all the data stored about a principal, returning the number of deleted records
+	PurgeSubject(principalID string) (int, error)
+}
+
+// AuditStore persists audit records
+type AuditStore interface {
+	SubjectDataStore
+
+	Record(AuditRecord) error
+}
+
+var subjectStores = struct {
+	sync.RWMutex
+	stores map[string]SubjectDataStore
+}{
+	stores: map[string]SubjectDataStore{},
+}
+
+// RegisterSubjectStore registers a store holding data about principals, so it is included in data subject
+// exports and purges
+func RegisterSubjectStore(name string, store SubjectDataStore) {
+	subjectStores.Lock()
+	defer subjectStores.Unlock()
+	subjectStores.stores[name] = store
+}
+
+// AddSubjectStore registers a store holding data about principals under a name not taken yet - the given name, or
+// the name suffixed with a sequence number - and returns it. Stores created more than once, e.g. two audit loggers,
+// are registered alongside each other instead of replacing each other
+func AddSubjectStore(name string, store SubjectDataStore) string {
+	subjectStores.Lock()
+	defer subjectStores.Unlock()
+
+	unique := name
+	for i := 2; subjectStores.stores[unique] != nil; i++ {
+		unique = fmt.Sprintf("%s#%d", name, i)
+	}
+	subjectStores.stores[unique] = store
+	return unique
+}
+
+// ExportSubjectData collects the data stored about a principal from all the registered stores, keyed by store name.
+// The principal id must not be empty, since requests of anonymous principals are not a data subject
+func ExportSubjectData(principalID string) (map[string]interface{}, error) {
+	if principalID == "" {
+		return nil, MissingParamError("No principal id given")
+	}
+
+	subjectStores.RLock()
+	defer subjectStores.RUnlock()
+
+	ret := make(map[string]interface{}, len(subjectStores.stores))
+	for name, store := range subjectStores.stores {
+		data, err := store.ExportSubject(principalID)
+		if err != nil {
+			return nil, logging.Errorf("Could not export subject data from %s: %s", name, err)
+		}
+		ret[name] = data
+	}
+
+	return ret, nil
+}
+
+// PurgeSubjectData deletes the data stored about a principal from all the registered stores, and returns the
+// number of records deleted from each store. The principal id must not be empty
+func PurgeSubjectData(principalID string) (map[string]int, error) {
+	if principalID == "" {
+		return nil, MissingParamError("No principal id given")
+	}
+
+	subjectStores.RLock()
+	defer subjectStores.RUnlock()
+
+	ret := make(map[string]int, len(subjectStores.stores))
+	for name, store := range subjectStores.stores {
+		n, err := store.PurgeSubject(principalID)
+		if err != nil {
+			return ret, logging.Errorf("Could not purge subject data from %s: %s", name, err)
+		}
+		ret[name] = n
+	}
+
+	logging.Info("Purged data of subject %s: %v", principalID, ret)
+	return ret, nil
+}
+
+// MemoryAuditStore is an in-memory AuditStore, keeping up to a maximum number of the latest records, or all of them
+type MemoryAuditStore struct {
+	mtx     sync.RWMutex
+	records []AuditRecord
+	max     int
+}
+
+// NewMemoryAuditStore creates a new in-memory audit store that keeps up to max records. If max is 0 or less, all
+// records are kept
+func NewMemoryAuditStore(max int) *MemoryAuditStore {
+	return &MemoryAuditStore{
+		max: max,
+	}
+}
+
+// Record adds a record to the store, evicting the oldest record if the store is full
+func (s *MemoryAuditStore) Record(rec AuditRecord) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.max > 0 && len(s.records) >= s.max {
+		s.records = s.records[1:]
+	}
+	s.records = append(s.records, rec)
+	return nil
+}
+
+// Records returns the records of a principal, or all records if the principal id is empty, sorted by time
+func (s *MemoryAuditStore) Records(principalID string) []AuditRecord {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	ret := make([]AuditRecord, 0)
+	for _, rec := range s.records {
+		if principalID == "" || rec.PrincipalID == principalID {
+			ret = append(ret, rec)
+		}
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].Time.Before(ret[j].Time) })
+	return ret
+}
+
+// ExportSubject returns the audit records of a principal. Unlike Records, an empty principal id matches no records
+func (s *MemoryAuditStore) ExportSubject(principalID string) (interface{}, error) {
+	if principalID == "" {
+		return []AuditRecord{}, nil
+	}
+	return s.Records(principalID), nil
+}
+
+// PurgeSubject deletes the audit records of a principal. An empty principal id matches no records
+func (s *MemoryAuditStore) PurgeSubject(principalID string) (int, error) {
+	if principalID == "" {
+		return 0, nil
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	kept := make([]AuditRecord, 0, len(s.records))
+	for _, rec := range s.records {
+		if rec.PrincipalID != principalID {
+			kept = append(kept, rec)
+		}
+	}
+
+	n := len(s.records) - len(kept)
+	s.records = kept
+	return n, nil
+}
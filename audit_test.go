@@ -0,0 +1,101 @@
+package vertex
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditStore(t *testing.T) {
+
+	store := NewMemoryAuditStore(3)
+	RegisterSubjectStore("test_audit", store)
+	defer func() {
+		subjectStores.Lock()
+		delete(subjectStores.stores, "test_audit")
+		subjectStores.Unlock()
+	}()
+
+	hr, _ := http.NewRequest("GET", "/foo", nil)
+	r := NewRequest(hr)
+
+	assert.NoError(t, store.Record(NewAuditRecord(r, "anonymous", nil)))
+
+	r.SetPrincipal(&Principal{ID: "alice"})
+	assert.Equal(t, "alice", r.PrincipalID())
+	assert.NoError(t, store.Record(NewAuditRecord(r, "first", nil)))
+	assert.NoError(t, store.Record(NewAuditRecord(r, "second", nil)))
+
+	r.SetPrincipal(&Principal{ID: "bob"})
+	assert.NoError(t, store.Record(NewAuditRecord(r, "third", nil)))
+
+	// the anonymous record was evicted
+	assert.Len(t, store.Records(""), 3)
+	assert.Len(t, store.Records("alice"), 2)
+	assert.Equal(t, "/foo", store.Records("bob")[0].Path)
+
+	data, err := ExportSubjectData("alice")
+	assert.NoError(t, err)
+	assert.Len(t, data["test_audit"], 2)
+
+	n, err := PurgeSubjectData("alice")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n["test_audit"])
+	assert.Len(t, store.Records("alice"), 0)
+	assert.Len(t, store.Records("bob"), 1)
+
+	// an empty principal id is no subject, and never matches every record
+	data, err = ExportSubjectData("")
+	assert.Error(t, err)
+	assert.Nil(t, data)
+	n, err = PurgeSubjectData("")
+	assert.Error(t, err)
+	assert.Nil(t, n)
+	records, err := store.ExportSubject("")
+	assert.NoError(t, err)
+	assert.Len(t, records, 0)
+	purged, err := store.PurgeSubject("")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, purged)
+	assert.Len(t, store.Records(""), 1)
+
+	// stores without a maximum keep all the records
+	for _, max := range []int{0, -1} {
+		unbounded := NewMemoryAuditStore(max)
+		for i := 0; i < 5; i++ {
+			assert.NoError(t, unbounded.Record(NewAuditRecord(r, "action", nil)))
+		}
+		assert.Len(t, unbounded.Records(""), 5)
+	}
+}
+
+func TestAddSubjectStore(t *testing.T) {
+
+	first, second := NewMemoryAuditStore(0), NewMemoryAuditStore(0)
+	name1, name2 := AddSubjectStore("test_add", first), AddSubjectStore("test_add", second)
+	defer func() {
+		subjectStores.Lock()
+		delete(subjectStores.stores, name1)
+		delete(subjectStores.stores, name2)
+		subjectStores.Unlock()
+	}()
+
+	// stores of the same kind do not replace each other
+	assert.Equal(t, "test_add", name1)
+	assert.Equal(t, "test_add#2", name2)
+	subjectStores.RLock()
+	assert.True(t, subjectStores.stores[name1] == first)
+	assert.True(t, subjectStores.stores[name2] == second)
+	subjectStores.RUnlock()
+}
+
+func TestPrincipal(t *testing.T) {
+
+	var p *Principal
+	assert.False(t, p.HasScope("admin"))
+
+	p = &Principal{ID: "alice", Scopes: []string{"read", "admin"}}
+	assert.True(t, p.HasScope("admin"))
+	assert.False(t, p.HasScope("write"))
+}
@@ -0,0 +1,163 @@
+package vertex
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// Startup banner formats
+const (
+	BannerNone = ""
+	BannerText = "text"
+	BannerJSON = "json"
+)
+
+type routeInfo struct {
+	Path       string   `json:"path"`
+	Methods    []string `json:"methods"`
+	Handler    string   `json:"handler"`
+	Middleware []string `json:"middleware,omitempty"`
+	Security   string   `json:"security,omitempty"`
+	Renderer   string   `json:"renderer,omitempty"`
+}
+
+type apiInfo struct {
+	Name     string      `json:"name"`
+	Version  string      `json:"version"`
+	Root     string      `json:"root"`
+	Insecure bool        `json:"allow_insecure"`
+	Routes   []routeInfo `json:"routes"`
+}
+
+// routeTable describes everything a server serves, for dumping on startup
+type routeTable struct {
+	Listen string    `json:"listen"`
+	APIs   []apiInfo `json:"apis"`
+}
+
+// describe returns a human readable name for a handler, middleware, renderer or security scheme
+func describe(v interface{}) string {
+
+	if v == nil {
+		return ""
+	}
+
+	val := reflect.ValueOf(v)
+	if val.Kind() == reflect.Func {
+		if f := runtime.FuncForPC(val.Pointer()); f != nil {
+			return f.Name()
+		}
+	}
+
+	return fmt.Sprintf("%T", v)
+}
+
+func describeAll(mws []Middleware) []string {
+	ret := make([]string, 0, len(mws))
+	for _, mw := range mws {
+		ret = append(ret, describe(mw))
+	}
+	return ret
+}
+
+// names returns the names of the http methods set in the flag
+func (m MethodFlag) names() []string {
+	ret := []string{}
+	for _, method := range methodFlags {
+		if m&method.flag == method.flag {
+			ret = append(ret, method.name)
+		}
+	}
+	return ret
+}
+
+func (s *Server) routeTable(listenAddr string) routeTable {
+
+	ret := routeTable{
+		Listen: listenAddr,
+		APIs:   make([]apiInfo, 0, len(s.apis)),
+	}
+
+	for _, a := range s.apis {
+
+		ai := apiInfo{
+			Name:     a.Name,
+			Version:  a.Version,
+			Root:     a.root(),
+			Insecure: a.AllowInsecure,
+			Routes:   make([]routeInfo, 0, len(a.Routes)),
+		}
+
+		for _, route := range a.Routes {
+
+			security := route.Security
+			if security == nil {
+				security = a.DefaultSecurityScheme
+			}
+			renderer := route.Renderer
+			if renderer == nil {
+				renderer = a.Renderer
+			}
+
+			ai.Routes = append(ai.Routes, routeInfo{
+				Path:       a.FullPath(route.Path),
+				Methods:    route.Methods.names(),
+				Handler:    describe(route.Handler),
+				Middleware: describeAll(append(a.Middleware, route.Middleware...)),
+				Security:   describe(security),
+				Renderer:   describe(renderer),
+			})
+		}
+
+		ret.APIs = append(ret.APIs, ai)
+	}
+
+	return ret
+}
+
+// write renders the route table as an aligned text table
+func (t routeTable) write(out io.Writer) error {
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Vertex server listening on %s\n", t.Listen)
+	for _, a := range t.APIs {
+		fmt.Fprintf(w, "\nAPI %s %s (root: %s, allow insecure: %v)\n", a.Name, a.Version, a.Root, a.Insecure)
+		fmt.Fprintln(w, "METHODS\tPATH\tHANDLER\tSECURITY\tRENDERER\tMIDDLEWARE")
+		for _, r := range a.Routes {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", strings.Join(r.Methods, ","), r.Path, r.Handler,
+				r.Security, r.Renderer, strings.Join(r.Middleware, ","))
+		}
+	}
+
+	return w.Flush()
+}
+
+// printBanner dumps the route table on startup, according to the configured banner format
+func (s *Server) printBanner(out io.Writer, listenAddr string) {
+
+	table := s.routeTable(listenAddr)
+
+	switch Config.Server.StartupBanner {
+	case BannerNone:
+		return
+	case BannerJSON:
+		b, err := json.Marshal(table)
+		if err != nil {
+			logging.Error("Could not encode route table: %s", err)
+			return
+		}
+		logging.Info("Route table: %s", string(b))
+	default:
+		if err := table.write(out); err != nil {
+			logging.Error("Could not write route table: %s", err)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package vertex
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteTable(t *testing.T) {
+
+	srv := NewServer(":9947")
+	srv.AddAPI(mockAPI)
+
+	table := srv.routeTable("127.0.0.1:9947")
+	assert.Equal(t, "127.0.0.1:9947", table.Listen)
+	if assert.Len(t, table.APIs, 1) {
+		assert.Len(t, table.APIs[0].Routes, len(mockAPI.Routes))
+		assert.Equal(t, "/mock/test", table.APIs[0].Routes[0].Path)
+		assert.Equal(t, []string{"GET"}, table.APIs[0].Routes[0].Methods)
+		assert.Equal(t, "vertex.MockHandler", table.APIs[0].Routes[0].Handler)
+		assert.Len(t, table.APIs[0].Routes[0].Middleware, 2)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	assert.NoError(t, table.write(buf))
+	assert.True(t, strings.Contains(buf.String(), "/mock/testvoid"))
+}
@@ -0,0 +1,225 @@
+package vertex
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// DefaultBatchWait is how long batch loaders collect keys before fetching them, if not set in the loader
+const DefaultBatchWait = time.Millisecond
+
+// BatchFunc fetches the values of a batch of keys in a single call, returning them by key. Keys missing from the
+// result have no value
+type BatchFunc func(r *Request, keys []interface{}) (map[interface{}]interface{}, error)
+
+// BatchLoader collects the keys loaded concurrently while handling a request and fetches them together, caching the
+// values for the rest of the request. It solves the N+1 problem of loading a relation for every item of a list one by
+// one:
+//
+//	var users = &vertex.BatchLoader{
+//		Fetch: func(r *vertex.Request, keys []interface{}) (map[interface{}]interface{}, error) {
+//			return db.UsersByIds(keys)
+//		},
+//		MaxBatch: 100,
+//	}
+//
+//	owner, err := users.Load(r, post.OwnerId)
+//
+// Loaders are declared once and shared by all requests. Keys must be comparable, e.g. numbers or strings. Relations
+// loaded by batch loaders are fetched for all the items of a response at once when they are expanded, see Relation
+type BatchLoader struct {
+	Fetch BatchFunc
+
+	// Maximum number of keys fetched in a single call. 0 means unlimited
+	MaxBatch int
+
+	// How long to collect keys before fetching them. Defaults to DefaultBatchWait
+	Wait time.Duration
+}
+
+// batchResult is the value of a key, available when done is closed
+type batchResult struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// batch is the keys collected for a single fetch
+type batch struct {
+	keys    []interface{}
+	results []*batchResult
+}
+
+// batchState is the state of a batch loader in a single request: the values of the keys loaded so far, and the batch
+// collecting keys
+type batchState struct {
+	mtx     sync.Mutex
+	results map[interface{}]*batchResult
+	pending *batch
+}
+
+// requestBatches holds the state of the batch loaders used while handling a request
+type requestBatches struct {
+	mtx    sync.Mutex
+	states map[*BatchLoader]*batchState
+}
+
+type batchesKey struct{}
+
+// withBatches returns a context the state of batch loaders is kept in, unless it already has one
+func withBatches(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(batchesKey{}).(*requestBatches); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, batchesKey{}, &requestBatches{states: map[*BatchLoader]*batchState{}})
+}
+
+// state returns the state of the loader in a request, or nil if the request does not keep batch state
+func (b *BatchLoader) state(r *Request) *batchState {
+
+	batches, ok := r.Context().Value(batchesKey{}).(*requestBatches)
+	if !ok {
+		return nil
+	}
+
+	batches.mtx.Lock()
+	defer batches.mtx.Unlock()
+	s := batches.states[b]
+	if s == nil {
+		s = &batchState{results: map[interface{}]*batchResult{}}
+		batches.states[b] = s
+	}
+	return s
+}
+
+// Load loads the value of a key, waiting for the batch it is fetched in. Keys already loaded in the request are
+// returned from the cache
+func (b *BatchLoader) Load(r *Request, key interface{}) (interface{}, error) {
+
+	s := b.state(r)
+	if s == nil {
+		// outside of requests every key is a batch of its own
+		values, err := b.LoadMany(r, []interface{}{key})
+		if err != nil {
+			return nil, err
+		}
+		return values[0], nil
+	}
+
+	s.mtx.Lock()
+	if res, found := s.results[key]; found {
+		s.mtx.Unlock()
+		<-res.done
+		return res.value, res.err
+	}
+
+	res := &batchResult{done: make(chan struct{})}
+	s.results[key] = res
+
+	if s.pending == nil {
+		s.pending = &batch{}
+		wait := b.Wait
+		if wait <= 0 {
+			wait = DefaultBatchWait
+		}
+		p := s.pending
+		time.AfterFunc(wait, func() {
+			s.mtx.Lock()
+			if s.pending != p {
+				// it was fetched when it filled up
+				s.mtx.Unlock()
+				return
+			}
+			s.pending = nil
+			s.mtx.Unlock()
+			b.fetch(r, p)
+		})
+	}
+
+	p := s.pending
+	p.keys = append(p.keys, key)
+	p.results = append(p.results, res)
+	if b.MaxBatch > 0 && len(p.keys) >= b.MaxBatch {
+		s.pending = nil
+		go b.fetch(r, p)
+	}
+	s.mtx.Unlock()
+
+	<-res.done
+	return res.value, res.err
+}
+
+// LoadMany loads the values of several keys, fetching the keys not loaded yet in the request right away, in batches
+// of up to MaxBatch keys. It returns the values in the order of the keys
+func (b *BatchLoader) LoadMany(r *Request, keys []interface{}) ([]interface{}, error) {
+
+	s := b.state(r)
+	if s == nil {
+		s = &batchState{results: map[interface{}]*batchResult{}}
+	}
+
+	results := make([]*batchResult, len(keys))
+	batches := []*batch{}
+	var p *batch
+
+	s.mtx.Lock()
+	for i, key := range keys {
+		if res, found := s.results[key]; found {
+			results[i] = res
+			continue
+		}
+
+		res := &batchResult{done: make(chan struct{})}
+		s.results[key] = res
+		results[i] = res
+
+		if p == nil || b.MaxBatch > 0 && len(p.keys) >= b.MaxBatch {
+			p = &batch{}
+			batches = append(batches, p)
+		}
+		p.keys = append(p.keys, key)
+		p.results = append(p.results, res)
+	}
+	s.mtx.Unlock()
+
+	for _, p := range batches {
+		go b.fetch(r, p)
+	}
+
+	ret := make([]interface{}, len(keys))
+	for i, res := range results {
+		<-res.done
+		if res.err != nil {
+			return nil, res.err
+		}
+		ret[i] = res.value
+	}
+	return ret, nil
+}
+
+// fetch fetches a batch and sets the results of its keys, converting panics of the fetch function to errors
+func (b *BatchLoader) fetch(r *Request, p *batch) {
+
+	var values map[interface{}]interface{}
+	var err error
+
+	defer func() {
+		if e := recover(); e != nil {
+			logging.Error("Panic fetching batch of %d keys: %v", len(p.keys), e)
+			values, err = nil, NewErrorf("PANIC fetching batch: %v", e)
+		}
+		for i, res := range p.results {
+			if err != nil {
+				res.err = err
+			} else {
+				res.value = values[p.keys[i]]
+			}
+			close(res.done)
+		}
+	}()
+
+	values, err = b.Fetch(r, p.keys)
+}
@@ -0,0 +1,142 @@
+package vertex
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchLoader(t *testing.T) {
+
+	var calls int32
+	var sizes []int
+	lock := sync.Mutex{}
+	users := &BatchLoader{
+		Fetch: func(r *Request, keys []interface{}) (map[interface{}]interface{}, error) {
+			atomic.AddInt32(&calls, 1)
+			lock.Lock()
+			sizes = append(sizes, len(keys))
+			lock.Unlock()
+			ret := map[interface{}]interface{}{}
+			for _, k := range keys {
+				if k.(int) != 13 {
+					ret[k] = fmt.Sprintf("user%d", k)
+				}
+			}
+			return ret, nil
+		},
+		MaxBatch: 4,
+		Wait:     50 * time.Millisecond,
+	}
+
+	hr, _ := http.NewRequest("GET", "/", nil)
+	r := NewRequest(hr.WithContext(withBatches(hr.Context())))
+
+	results := make([]interface{}, 10)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// every key is loaded twice
+			v, err := users.Load(r, 10+i/2)
+			assert.NoError(t, err)
+			results[i] = v
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, "user10", results[0])
+	assert.Equal(t, "user14", results[9])
+	assert.Nil(t, results[6])
+	assert.Equal(t, int32(2), calls)
+	assert.Equal(t, 5, sizes[0]+sizes[1])
+
+	// loaded keys are cached for the request
+	v, err := users.Load(r, 11)
+	assert.NoError(t, err)
+	assert.Equal(t, "user11", v)
+	assert.Equal(t, int32(2), calls)
+
+	// without batch state every load is fetched
+	r = NewRequest(hr)
+	v, err = users.Load(r, 11)
+	assert.NoError(t, err)
+	assert.Equal(t, "user11", v)
+	assert.Equal(t, int32(3), calls)
+
+	failing := &BatchLoader{Fetch: func(r *Request, keys []interface{}) (map[interface{}]interface{}, error) {
+		return nil, errors.New("db down")
+	}}
+	_, err = failing.Load(r, 1)
+	assert.EqualError(t, err, "db down")
+
+	panicking := &BatchLoader{Fetch: func(r *Request, keys []interface{}) (map[interface{}]interface{}, error) {
+		panic("oops")
+	}}
+	_, err = panicking.Load(r, 1)
+	assert.Error(t, err)
+}
+
+func TestBatchExpansion(t *testing.T) {
+
+	var calls int32
+	users := &BatchLoader{
+		Fetch: func(r *Request, keys []interface{}) (map[interface{}]interface{}, error) {
+			atomic.AddInt32(&calls, 1)
+			ret := map[interface{}]interface{}{}
+			for _, k := range keys {
+				ret[k] = expandUser{Id: k.(int), Name: fmt.Sprintf("user%d", k)}
+			}
+			return ret, nil
+		},
+	}
+
+	posts := make([]expandPost, 100)
+	for i := range posts {
+		posts[i] = expandPost{i, i % 10}
+	}
+
+	a := &API{
+		Name:          "batch",
+		Version:       "1.0",
+		Root:          "/batch",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{
+				Path:        "/posts",
+				Description: "Posts",
+				Methods:     GET,
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					return posts, nil
+				}),
+				Returns: []expandPost{},
+				Expand: &Expansions{
+					Relations: []Relation{
+						{Name: "owner", Batch: users, Key: func(item interface{}) interface{} {
+							return item.(expandPost).OwnerId
+						}},
+					},
+				},
+			},
+		},
+	}
+	srv := NewServer(":9987")
+	srv.AddAPI(a)
+
+	hr, _ := http.NewRequest("GET", a.FullPath("/posts")+"?expand=owner", nil)
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, hr)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"owner":{"id":7,"name":"user7","teamId":0}`)
+	assert.Equal(t, int32(1), calls)
+}
@@ -0,0 +1,197 @@
+package vertex
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/EverythingMe/vertex/schema"
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// bodyValidator binds the JSON body of requests to the handler field tagged in:"body", and validates the decoded
+// value by the validation tags of the fields of its type:
+//
+//	type AddUserHandler struct {
+//		User NewUser `in:"body" required:"true" doc:"The user to add"`
+//	}
+//
+//	type NewUser struct {
+//		Name  string `json:"name" required:"true" maxlen:"64"`
+//		Email string `json:"email" pattern:"^[^@]+@[^@]+$"`
+//		Age   int    `json:"age" min:"13"`
+//	}
+//
+// Violations are reported by the JSON path of the field, e.g. user.name
+type bodyValidator struct {
+	schema.ParamInfo
+}
+
+func newBodyValidator(pi schema.ParamInfo) *bodyValidator {
+	return &bodyValidator{ParamInfo: pi}
+}
+
+// bind decodes a request body into the body field of a handler, returning the violations of the body
+func (v *bodyValidator) bind(val reflect.Value, body []byte) []error {
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		if v.Required {
+			return []error{violationError(v.Name, "required", nil, "missing required body '%s'", v.Name)}
+		}
+		return nil
+	}
+
+	field := val.FieldByName(v.StructKey)
+	ptr := reflect.New(field.Type())
+	if err := json.Unmarshal(body, ptr.Interface()); err != nil {
+		if e, ok := err.(*json.UnmarshalTypeError); ok {
+			path := v.Name
+			if e.Field != "" {
+				path += "." + e.Field
+			}
+			return []error{violationError(path, "type", nil, "%s should be %s, not %s", path, e.Type, e.Value)}
+		}
+		return []error{violationError(v.Name, "format", nil, "Malformed JSON body: %s", err)}
+	}
+	field.Set(ptr.Elem())
+
+	return validateBodyValue(v.Name, ptr.Elem())
+}
+
+// validateBodyValue validates the fields of a decoded body value, and of the values nested in it
+func validateBodyValue(path string, val reflect.Value) (errs []error) {
+
+	for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			return nil
+		}
+		val = val.Elem()
+	}
+
+	switch val.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < val.Len(); i++ {
+			errs = append(errs, validateBodyValue(fmt.Sprintf("%s[%d]", path, i), val.Index(i))...)
+		}
+
+	case reflect.Map:
+		for _, k := range val.MapKeys() {
+			errs = append(errs, validateBodyValue(fmt.Sprintf("%s.%v", path, k.Interface()), val.MapIndex(k))...)
+		}
+
+	case reflect.Struct:
+		T := val.Type()
+		for i := 0; i < T.NumField(); i++ {
+
+			f := T.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			name := jsonFieldName(f)
+			if name == "-" {
+				continue
+			}
+
+			// embedded structs are flattened into their parent, like encoding/json does
+			fieldPath := path + "." + name
+			if f.Anonymous && name == f.Name {
+				fieldPath = path
+			}
+
+			if err := validateBodyField(fieldPath, f, val.Field(i)); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			errs = append(errs, validateBodyValue(fieldPath, val.Field(i))...)
+		}
+	}
+
+	return errs
+}
+
+// validateBodyField validates a field of a body value by its validation tags
+func validateBodyField(path string, f reflect.StructField, field reflect.Value) error {
+
+	if f.Tag.Get(schema.RequiredTag) == "true" && field.IsZero() {
+		return violationError(path, "required", nil, "missing required field '%s'", path)
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		s := field.String()
+		if max, ok := intTagValue(f, schema.MaxLenTag); ok && len(s) > max {
+			return violationError(path, "maxLength", s, "%s is too long", path)
+		}
+		if min, ok := intTagValue(f, schema.MinLenTag); ok && len(s) < min {
+			return violationError(path, "minLength", s, "%s is too short", path)
+		}
+		if re := bodyPattern(f.Tag.Get(schema.PatternTag)); re != nil && !re.MatchString(s) {
+			return violationError(path, "pattern", s, "%s does not match regex pattern", path)
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+
+		n := field.Convert(reflect.TypeOf(float64(0))).Float()
+		if min, err := strconv.ParseFloat(f.Tag.Get(schema.MinTag), 64); err == nil && n < min {
+			return violationError(path, "min", field.Interface(), "Value too small for %s", path)
+		}
+		if max, err := strconv.ParseFloat(f.Tag.Get(schema.MaxTag), 64); err == nil && n > max {
+			return violationError(path, "max", field.Interface(), "Value too large for %s", path)
+		}
+	}
+
+	return nil
+}
+
+// jsonFieldName returns the name of a struct field in JSON, "-" if it is omitted
+func jsonFieldName(f reflect.StructField) string {
+	if name := strings.Split(f.Tag.Get("json"), ",")[0]; name != "" {
+		return name
+	}
+	return f.Name
+}
+
+func intTagValue(f reflect.StructField, tag string) (int, bool) {
+	n, err := strconv.Atoi(f.Tag.Get(tag))
+	return n, err == nil
+}
+
+// bodyPatterns caches the compiled patterns of body fields
+var bodyPatterns = struct {
+	sync.RWMutex
+	patterns map[string]*regexp.Regexp
+}{
+	patterns: map[string]*regexp.Regexp{},
+}
+
+// bodyPattern returns the compiled regexp of a pattern tag, or nil if there is none or it is invalid
+func bodyPattern(pattern string) *regexp.Regexp {
+
+	if pattern == "" {
+		return nil
+	}
+
+	bodyPatterns.RLock()
+	re, found := bodyPatterns.patterns[pattern]
+	bodyPatterns.RUnlock()
+	if found {
+		return re
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		logging.Error("Could not create regexp validator - invalid regexp: %s - %s", pattern, err)
+	}
+
+	bodyPatterns.Lock()
+	bodyPatterns.patterns[pattern] = re
+	bodyPatterns.Unlock()
+	return re
+}
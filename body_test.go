@@ -0,0 +1,123 @@
+package vertex
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type bodyAddress struct {
+	City string `json:"city" required:"true"`
+}
+
+type bodyUser struct {
+	Name      string        `json:"name" required:"true" maxlen:"8"`
+	Email     string        `json:"email,omitempty" pattern:"^[^@]+@[^@]+$"`
+	Age       int           `json:"age" min:"13"`
+	Addresses []bodyAddress `json:"addresses"`
+	Internal  string        `json:"-" required:"true"`
+}
+
+type bodyHandler struct {
+	User   bodyUser `schema:"user" in:"body" required:"true" doc:"The user to add"`
+	Notify bool     `schema:"notify"`
+	Source string   `schema:"source" maxlen:"4"`
+}
+
+func (h bodyHandler) Handle(w http.ResponseWriter, r *Request) (interface{}, error) {
+	return h, nil
+}
+
+func TestBodyBinding(t *testing.T) {
+
+	a := &API{
+		Name:          "body",
+		Version:       "1.0",
+		Root:          "/body",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{Path: "/users", Description: "Add user", Methods: POST, Handler: bodyHandler{}},
+		},
+	}
+	srv := NewServer(":9941")
+	srv.AddAPI(a)
+
+	post := func(query, body string) *httptest.ResponseRecorder {
+		hr, _ := http.NewRequest("POST", a.FullPath("/users")+query, strings.NewReader(body))
+		hr.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, hr)
+		return w
+	}
+	violations := func(w *httptest.ResponseRecorder) []Violation {
+		var body validationErrorBody
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body), w.Body.String())
+		return body.Violations
+	}
+
+	// the body is bound along with the query params
+	w := post("?notify=true", `{"name":"bob","email":"bob@example.com","age":30,"addresses":[{"city":"Paris"}]}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var ret bodyHandler
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &ret))
+	assert.Equal(t, "bob", ret.User.Name)
+	assert.Equal(t, 30, ret.User.Age)
+	assert.Equal(t, "Paris", ret.User.Addresses[0].City)
+	assert.True(t, ret.Notify)
+
+	// the fields of the body are validated by their tags, and reported by their path
+	w = post("?source=toolong", `{"name":"bartholomew","email":"nope","age":7,"addresses":[{"city":"Paris"},{}]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	v := violations(w)
+	fields := []string{}
+	for _, violation := range v {
+		fields = append(fields, violation.Field+":"+violation.Constraint)
+	}
+	assert.Equal(t, []string{"user.name:maxLength", "user.email:pattern", "user.age:min", "user.addresses[1].city:required", "source:maxLength"}, fields)
+
+	// missing required fields are missing params
+	w = post("", `{"age":20}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "required", violations(w)[0].Constraint)
+	assert.Equal(t, "user.name", violations(w)[0].Field)
+
+	w = post("", ``)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "user", violations(w)[0].Field)
+	assert.Equal(t, "required", violations(w)[0].Constraint)
+
+	// bodies that cannot be decoded are invalid
+	w = post("", `{"name":`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "format", violations(w)[0].Constraint)
+
+	w = post("", `{"name":"bob","age":"old"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "user.age", violations(w)[0].Field)
+	assert.Equal(t, "type", violations(w)[0].Constraint)
+
+	// the body is documented as a body param with the schema of its type
+	sw := a.ToSwagger("example.com")
+	var body, notify bool
+	for _, p := range sw.Paths["/users"]["post"].Parameters {
+		switch p.Name {
+		case "user":
+			body = true
+			assert.Equal(t, "body", p.In)
+			assert.True(t, p.Required)
+			assert.Equal(t, "#/definitions/bodyUser", p.Schema.Ref)
+			assert.Nil(t, p.Schema.Definitions)
+		case "notify":
+			notify = true
+			assert.Equal(t, "query", p.In)
+		}
+	}
+	assert.True(t, body)
+	assert.True(t, notify)
+	assert.NotNil(t, sw.Definitions["bodyUser"])
+}
@@ -0,0 +1,153 @@
+package vertex
+
+import (
+	"net/http"
+	"reflect"
+	"sync"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// DefaultBulkConcurrency is the number of bulk items processed concurrently, if not set in the BulkOptions
+const DefaultBulkConcurrency = 8
+
+// BulkItemResult is the outcome of processing a single item of a bulk request
+type BulkItemResult struct {
+	// The index of the item in the request
+	Index int `json:"index"`
+
+	// The http status of the item, as if it was sent in a request of its own
+	Status int `json:"status"`
+
+	// The error message if the item failed
+	Error string `json:"error,omitempty"`
+
+	// The result returned for the item by the processing callback
+	Result interface{} `json:"result,omitempty"`
+}
+
+// BulkResponse is the standard response of bulk endpoints, listing the outcome of every item. It is rendered with
+// a 207 Multi-Status http status, since items may succeed or fail independently.
+//
+// Routes returning it should set Returns to BulkResponse{} for the documentation
+type BulkResponse struct {
+	Succeeded int              `json:"succeeded"`
+	Failed    int              `json:"failed"`
+	Items     []BulkItemResult `json:"items"`
+}
+
+// BulkOptions configure the processing of bulk items
+type BulkOptions struct {
+	// Maximum number of items processed concurrently. Defaults to DefaultBulkConcurrency
+	Concurrency int
+
+	// Maximum number of items in a request. 0 means unlimited
+	MaxItems int
+
+	// Optional validation of each item before it is processed. Items failing validation are not processed
+	Validate func(item interface{}) error
+}
+
+// ProcessBulk validates and processes the items of a bulk request concurrently, using a user callback for each
+// item. items must be a slice. It returns an error only if the request as a whole is invalid - item failures are
+// reported in the response:
+//
+//	func (h *AddUsersHandler) Handle(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+//		return vertex.ProcessBulk(h.Users, vertex.BulkOptions{MaxItems: 100}, func(i int, item interface{}) (interface{}, error) {
+//			return addUser(item.(User))
+//		})
+//	}
+func ProcessBulk(items interface{}, opts BulkOptions,
+	process func(index int, item interface{}) (interface{}, error)) (*BulkResponse, error) {
+
+	v := reflect.ValueOf(items)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, InvalidRequestError("Bulk items must be a list, got %T", items)
+	}
+
+	n := v.Len()
+	if opts.MaxItems > 0 && n > opts.MaxItems {
+		return nil, InvalidParamError("Too many items in request: %d, maximum is %d", n, opts.MaxItems)
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultBulkConcurrency
+	}
+
+	ret := &BulkResponse{
+		Items: make([]BulkItemResult, n),
+	}
+
+	sem := make(chan struct{}, concurrency)
+	wg := sync.WaitGroup{}
+	for i := 0; i < n; i++ {
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, item interface{}) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			ret.Items[i] = processBulkItem(i, item, opts.Validate, process)
+		}(i, v.Index(i).Interface())
+	}
+	wg.Wait()
+
+	for _, res := range ret.Items {
+		if res.Error == "" {
+			ret.Succeeded++
+		} else {
+			ret.Failed++
+		}
+	}
+
+	return ret, nil
+}
+
+// processBulkItem validates and processes a single item, converting errors and panics to an item result
+func processBulkItem(i int, item interface{}, validate func(interface{}) error,
+	process func(int, interface{}) (interface{}, error)) (res BulkItemResult) {
+
+	res.Index = i
+
+	defer func() {
+		if e := recover(); e != nil {
+			logging.Error("Panic processing bulk item %d: %v", i, e)
+			res.Result = nil
+			res.Status, res.Error = httpError(NewErrorf("PANIC processing bulk item %d: %v", i, e))
+		}
+	}()
+
+	var err error
+	if validate != nil {
+		if err = validate(item); err != nil {
+			if _, ok := err.(*internalError); !ok {
+				err = InvalidParamError("%s", err)
+			}
+		}
+	}
+
+	if err == nil {
+		res.Result, err = process(i, item)
+	}
+
+	if err != nil {
+		res.Result = nil
+		res.Status, res.Error = httpError(err)
+		return
+	}
+
+	res.Status = http.StatusOK
+	return
+}
+
+// isBulkResponse checks whether a route's declared return value is a bulk response
+func isBulkResponse(v interface{}) bool {
+	switch v.(type) {
+	case BulkResponse, *BulkResponse:
+		return true
+	}
+	return false
+}
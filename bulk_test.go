@@ -0,0 +1,95 @@
+package vertex
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessBulk(t *testing.T) {
+
+	items := []int{1, 2, 3, 4, 5}
+	opts := BulkOptions{
+		Concurrency: 2,
+		Validate: func(item interface{}) error {
+			if item.(int) == 2 {
+				return errors.New("two is not allowed")
+			}
+			return nil
+		},
+	}
+
+	res, err := ProcessBulk(items, opts, func(i int, item interface{}) (interface{}, error) {
+		switch item.(int) {
+		case 4:
+			return nil, errors.New("internal failure")
+		case 5:
+			panic("boom")
+		}
+		return item.(int) * 10, nil
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, res.Succeeded)
+	assert.Equal(t, 3, res.Failed)
+	assert.Len(t, res.Items, 5)
+
+	assert.Equal(t, http.StatusOK, res.Items[0].Status)
+	assert.Equal(t, 10, res.Items[0].Result)
+	assert.Equal(t, http.StatusBadRequest, res.Items[1].Status)
+	assert.Equal(t, "two is not allowed", res.Items[1].Error)
+	assert.Equal(t, 30, res.Items[2].Result)
+	assert.Equal(t, http.StatusInternalServerError, res.Items[3].Status)
+	assert.Equal(t, http.StatusInternalServerError, res.Items[4].Status)
+	assert.Equal(t, 4, res.Items[4].Index)
+
+	_, err = ProcessBulk(items, BulkOptions{MaxItems: 3}, nil)
+	assert.Error(t, err)
+
+	_, err = ProcessBulk("foo", BulkOptions{}, nil)
+	assert.Error(t, err)
+}
+
+func TestBulkRoute(t *testing.T) {
+
+	a := &API{
+		Name:          "bulk",
+		Version:       "1.0",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{
+				Path:    "/items",
+				Methods: POST,
+				Returns: BulkResponse{},
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					return ProcessBulk([]string{"a", "b"}, BulkOptions{}, func(i int, item interface{}) (interface{}, error) {
+						return item, nil
+					})
+				}),
+			},
+		},
+	}
+
+	srv := NewServer(":9950")
+	srv.AddAPI(a)
+
+	req, _ := http.NewRequest("POST", a.FullPath("/items"), nil)
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+
+	var res BulkResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, 2, res.Succeeded)
+	assert.Equal(t, "b", res.Items[1].Result)
+
+	_, found := a.ToSwagger("localhost").Paths["/items"]["post"].Responses["207"]
+	assert.True(t, found)
+}
@@ -0,0 +1,102 @@
+package vertex
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CachedResponse wraps a handler's response with caching hints. The framework renders the wrapped value, and sets
+// the Cache-Control, Expires and Vary headers according to the hints and the route's security.
+//
+// Handlers create it with Cacheable:
+//
+//	return vertex.Cacheable(user, 5*time.Minute, vertex.Vary("Accept-Language")), nil
+type CachedResponse struct {
+	// The actual response object
+	Value interface{}
+
+	// How long the response may be cached. Zero or negative values mean the response must not be stored
+	MaxAge time.Duration
+
+	vary           []string
+	private        bool
+	mustRevalidate bool
+}
+
+// CacheOption customizes the caching hints of a cacheable response
+type CacheOption func(*CachedResponse)
+
+// Vary adds request headers that the response varies by
+func Vary(headers ...string) CacheOption {
+	return func(c *CachedResponse) {
+		c.vary = append(c.vary, headers...)
+	}
+}
+
+// Private marks the response as cacheable only by the client, even if the route is not secured
+func Private() CacheOption {
+	return func(c *CachedResponse) {
+		c.private = true
+	}
+}
+
+// MustRevalidate forbids caches from serving the response once it is stale
+func MustRevalidate() CacheOption {
+	return func(c *CachedResponse) {
+		c.mustRevalidate = true
+	}
+}
+
+// Cacheable wraps a response object with caching hints. Responses of routes that have a security scheme are
+// always cached privately
+func Cacheable(v interface{}, maxAge time.Duration, opts ...CacheOption) *CachedResponse {
+
+	ret := &CachedResponse{
+		Value:  v,
+		MaxAge: maxAge,
+	}
+	for _, opt := range opts {
+		opt(ret)
+	}
+	return ret
+}
+
+// cacheControl returns the Cache-Control header value for the response
+func (c *CachedResponse) cacheControl(secured bool) string {
+
+	if c.MaxAge <= 0 {
+		return "no-store"
+	}
+
+	parts := []string{"public"}
+	if secured || c.private {
+		parts[0] = "private"
+	}
+	parts = append(parts, fmt.Sprintf("max-age=%d", int(c.MaxAge.Seconds())))
+	if c.mustRevalidate {
+		parts = append(parts, "must-revalidate")
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// writeHeaders sets the caching headers of the response. secured is true if the route has a security scheme
+func (c *CachedResponse) writeHeaders(w http.ResponseWriter, secured bool) {
+
+	h := w.Header()
+	h.Set("Cache-Control", c.cacheControl(secured))
+
+	if c.MaxAge > 0 {
+		h.Set("Expires", time.Now().Add(c.MaxAge).UTC().Format(http.TimeFormat))
+	} else {
+		h.Set("Expires", "0")
+	}
+
+	vary := c.vary
+	if secured {
+		vary = append(vary, "Authorization")
+	}
+	AddVary(h, vary...)
+}
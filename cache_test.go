@@ -0,0 +1,52 @@
+package vertex
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheable(t *testing.T) {
+
+	c := Cacheable("foo", time.Minute, Vary("Accept"))
+	assert.Equal(t, "public, max-age=60", c.cacheControl(false))
+	assert.Equal(t, "private, max-age=60", c.cacheControl(true))
+
+	c = Cacheable("foo", time.Minute, Private(), MustRevalidate())
+	assert.Equal(t, "private, max-age=60, must-revalidate", c.cacheControl(false))
+
+	c = Cacheable("foo", 0)
+	assert.Equal(t, "no-store", c.cacheControl(false))
+
+	a := &API{
+		Name:          "cache",
+		Version:       "1.0",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{
+				Path:    "/cached",
+				Methods: GET,
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					return Cacheable("bar", 5*time.Minute, Vary("Accept-Language")), nil
+				}),
+			},
+		},
+	}
+
+	srv := NewServer(":9948")
+	srv.AddAPI(a)
+
+	req, _ := http.NewRequest("GET", a.FullPath("/cached"), nil)
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"bar"`, w.Body.String())
+	assert.Equal(t, "public, max-age=300", w.Header().Get("Cache-Control"))
+	assert.Equal(t, "Accept-Language", w.Header().Get("Vary"))
+	assert.NotEmpty(t, w.Header().Get("Expires"))
+}
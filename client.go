@@ -0,0 +1,197 @@
+package vertex
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// HeaderClient identifies the client app sending a request, as "name/version (platform)", e.g.
+// "shopper-ios/3.2.1 (iOS 14.2)". The platform is optional
+const HeaderClient = "X-Vertex-Client"
+
+// Client identifies the client app sending a request
+type Client struct {
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Platform string `json:"platform,omitempty"`
+}
+
+// Headers of upgrade required errors, telling clients the minimum supported version and where to upgrade
+const (
+	HeaderMinClientVersion = "X-Vertex-Min-Client-Version"
+	HeaderUpgradeURL       = "X-Vertex-Upgrade-URL"
+)
+
+// MinClientVersion is the minimum version of a client app allowed to call a route. Older versions fail with a 426
+// Upgrade Required error carrying the minimum version and the upgrade URL in the HeaderMinClientVersion and
+// HeaderUpgradeURL headers
+type MinClientVersion struct {
+	Version string
+
+	// Where users can upgrade the app, e.g. its app store page. Optional
+	UpgradeURL string
+
+	// A message to show users. Optional
+	Message string
+}
+
+// UpgradeInfo tells clients of an upgrade required error which version to upgrade to, and where
+type UpgradeInfo struct {
+	MinVersion string
+	URL        string
+}
+
+// ParseClient parses a client identification in the HeaderClient format, or the first product of a User-Agent
+// header. It returns nil if the value has no name and version
+func ParseClient(value string) *Client {
+
+	value = strings.TrimSpace(value)
+
+	var platform string
+	if i := strings.Index(value, "("); i >= 0 {
+		if j := strings.Index(value[i:], ")"); j >= 0 {
+			platform = strings.TrimSpace(value[i+1 : i+j])
+		}
+		value = value[:i]
+	}
+
+	// a User-Agent may list several products, the first one is the app
+	if fields := strings.Fields(value); len(fields) > 0 {
+		value = fields[0]
+	}
+
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil
+	}
+
+	return &Client{
+		Name:     parts[0],
+		Version:  parts[1],
+		Platform: platform,
+	}
+}
+
+// String formats the client in the HeaderClient format. A nil client is formatted as an empty string
+func (c *Client) String() string {
+	if c == nil {
+		return ""
+	}
+	if c.Platform == "" {
+		return fmt.Sprintf("%s/%s", c.Name, c.Version)
+	}
+	return fmt.Sprintf("%s/%s (%s)", c.Name, c.Version, c.Platform)
+}
+
+// Client returns the identified client app of the request, or nil if it was not identified. Clients are identified
+// by the ClientIdentifier middleware
+func (r *Request) Client() *Client {
+	return r.client
+}
+
+// SetClient sets the identified client app of the request
+func (r *Request) SetClient(c *Client) {
+	r.client = c
+}
+
+// OtherClients is the name the requests of client apps that were not registered are counted under, see
+// RegisterClients
+const OtherClients = "other"
+
+// maximum number of versions of a registered client app counted apart. Requests of later versions are counted
+// under OtherClients as their version
+const maxClientVersions = 100
+
+// name/version => requests/errors => count
+var clientMetrics = newCounterMap("vertex.clients")
+
+// registered client app name => versions counted apart
+var registeredClients = struct {
+	sync.Mutex
+	versions map[string]map[string]bool
+}{
+	versions: map[string]map[string]bool{},
+}
+
+// RegisterClients registers the client apps counted by name and version in the "vertex.clients" metrics. Client
+// identification is controlled by the client, so the requests of apps that were not registered are counted together
+// under OtherClients, rather than let clients grow the metrics without bounds
+func RegisterClients(names ...string) {
+	registeredClients.Lock()
+	defer registeredClients.Unlock()
+	for _, name := range names {
+		if registeredClients.versions[name] == nil {
+			registeredClients.versions[name] = map[string]bool{}
+		}
+	}
+}
+
+// clientKey returns the key the requests of a client app are counted by
+func clientKey(name, version string) string {
+
+	registeredClients.Lock()
+	defer registeredClients.Unlock()
+
+	versions, found := registeredClients.versions[name]
+	if !found {
+		return OtherClients
+	}
+	if !versions[version] {
+		if len(versions) >= maxClientVersions {
+			return name + "/" + OtherClients
+		}
+		versions[version] = true
+	}
+	return name + "/" + version
+}
+
+// countClient counts a handled request by its client app, if it was identified
+func countClient(r *Request, err error) {
+
+	if r.client == nil {
+		return
+	}
+
+	key := clientKey(r.client.Name, r.client.Version)
+	clientMetrics.Add(key, "requests", 1)
+	if err != nil && !IsHijacked(err) {
+		clientMetrics.Add(key, "errors", 1)
+	}
+}
+
+// ClientStats returns the number of requests from a version of a registered client app, and how many of them failed.
+// The requests of apps that were not registered are returned for the name OtherClients and an empty version
+func ClientStats(name, version string) (requests, errors int64) {
+	key := OtherClients
+	if name != OtherClients {
+		key = name + "/" + version
+	}
+	return clientMetrics.Value(key, "requests"), clientMetrics.Value(key, "errors")
+}
+
+// checkClientVersion fails requests from client apps older than the minimum versions of a route, which override the
+// minimum versions of its API. Requests of unidentified clients are allowed
+func checkClientVersion(r *Request, api, route map[string]MinClientVersion) error {
+
+	if r.client == nil {
+		return nil
+	}
+
+	min, found := route[r.client.Name]
+	if !found {
+		if min, found = api[r.client.Name]; !found {
+			return nil
+		}
+	}
+	if compareVersions(r.client.Version, min.Version) >= 0 {
+		return nil
+	}
+
+	msg := min.Message
+	if msg == "" {
+		msg = fmt.Sprintf("%s %s is no longer supported, please upgrade to %s or later", r.client.Name,
+			r.client.Version, min.Version)
+	}
+	return WithUpgradeInfo(UpgradeRequiredError("%s", msg), UpgradeInfo{MinVersion: min.Version, URL: min.UpgradeURL})
+}
@@ -0,0 +1,161 @@
+package vertex
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseClient(t *testing.T) {
+
+	assert.Equal(t, &Client{Name: "shopper-ios", Version: "3.2.1", Platform: "iOS 14.2"},
+		ParseClient("shopper-ios/3.2.1 (iOS 14.2)"))
+	assert.Equal(t, &Client{Name: "shopper-android", Version: "3.2"}, ParseClient(" shopper-android/3.2 "))
+	assert.Equal(t, &Client{Name: "okhttp", Version: "4.9.0"}, ParseClient("okhttp/4.9.0"))
+	assert.Equal(t, &Client{Name: "Mozilla", Version: "5.0", Platform: "X11; Linux x86_64"},
+		ParseClient("Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36"))
+
+	assert.Nil(t, ParseClient(""))
+	assert.Nil(t, ParseClient("curl"))
+	assert.Nil(t, ParseClient("shopper/"))
+
+	assert.Equal(t, "shopper-ios/3.2.1 (iOS 14.2)", ParseClient("shopper-ios/3.2.1 (iOS 14.2)").String())
+	assert.Equal(t, "okhttp/4.9.0", ParseClient("okhttp/4.9.0").String())
+	var c *Client
+	assert.Equal(t, "", c.String())
+}
+
+func TestClientStats(t *testing.T) {
+
+	a := &API{
+		Name:          "clients",
+		Version:       "1.0",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Middleware: []Middleware{
+			MiddlewareFunc(func(w http.ResponseWriter, r *Request, next HandlerFunc) (interface{}, error) {
+				r.SetClient(ParseClient(r.Header.Get(HeaderClient)))
+				return next(w, r)
+			}),
+		},
+		Routes: Routes{
+			{
+				Path:        "/foo",
+				Description: "Foo",
+				Methods:     GET,
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					if r.FormValue("fail") != "" {
+						return nil, InvalidRequestError("fail")
+					}
+					return r.Client().Name, nil
+				}),
+			},
+		},
+	}
+	srv := NewServer(":9967")
+	srv.AddAPI(a)
+
+	call := func(client, query string) {
+		hr, _ := http.NewRequest("GET", a.FullPath("/foo")+query, nil)
+		hr.Header.Set(HeaderClient, client)
+		srv.Handler().ServeHTTP(httptest.NewRecorder(), hr)
+	}
+
+	RegisterClients("statsapp")
+	others, _ := ClientStats(OtherClients, "")
+
+	call("statsapp/1.0 (android)", "")
+	call("statsapp/1.0", "?fail=1")
+	call("statsapp/1.1", "")
+	call("", "")
+	call("spoofed/9.9", "")
+
+	requests, errors := ClientStats("statsapp", "1.0")
+	assert.Equal(t, int64(2), requests)
+	assert.Equal(t, int64(1), errors)
+	requests, errors = ClientStats("statsapp", "1.1")
+	assert.Equal(t, int64(1), requests)
+	assert.Equal(t, int64(0), errors)
+
+	// clients that were not registered are counted together
+	requests, _ = ClientStats("spoofed", "9.9")
+	assert.Equal(t, int64(0), requests)
+	requests, _ = ClientStats(OtherClients, "")
+	assert.Equal(t, others+1, requests)
+}
+
+func TestMinClientVersion(t *testing.T) {
+
+	handler := HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+		return "ok", nil
+	})
+	a := &API{
+		Name:          "minclient",
+		Version:       "1.0",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Middleware: []Middleware{
+			MiddlewareFunc(func(w http.ResponseWriter, r *Request, next HandlerFunc) (interface{}, error) {
+				r.SetClient(ParseClient(r.Header.Get(HeaderClient)))
+				return next(w, r)
+			}),
+		},
+		MinClientVersions: map[string]MinClientVersion{
+			"shopper-ios": {Version: "3.2", UpgradeURL: "https://apps.apple.com/app/shopper"},
+		},
+		Routes: Routes{
+			{Path: "/foo", Description: "Foo", Methods: GET, Handler: handler},
+			{
+				Path:        "/bar",
+				Description: "Bar",
+				Methods:     GET,
+				Handler:     handler,
+				MinClientVersions: map[string]MinClientVersion{
+					"shopper-ios":     {Version: "3.10"},
+					"shopper-android": {Version: "2.0", Message: "Please upgrade"},
+				},
+			},
+		},
+	}
+	srv := NewServer(":9968")
+	srv.AddAPI(a)
+
+	call := func(path, client string) *httptest.ResponseRecorder {
+		hr, _ := http.NewRequest("GET", a.FullPath(path), nil)
+		hr.Header.Set(HeaderClient, client)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, hr)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, call("/foo", "shopper-ios/3.2").Code)
+	assert.Equal(t, http.StatusOK, call("/foo", "shopper-android/1.0").Code)
+	assert.Equal(t, http.StatusOK, call("/foo", "").Code)
+
+	w := call("/foo", "shopper-ios/3.1.9")
+	assert.Equal(t, http.StatusUpgradeRequired, w.Code)
+	assert.Equal(t, "3.2", w.Header().Get(HeaderMinClientVersion))
+	assert.Equal(t, "https://apps.apple.com/app/shopper", w.Header().Get(HeaderUpgradeURL))
+	assert.Contains(t, w.Body.String(), "shopper-ios 3.1.9 is no longer supported, please upgrade to 3.2 or later")
+
+	// route versions override the API versions, and are compared numerically
+	assert.Equal(t, http.StatusOK, call("/bar", "shopper-ios/3.10.1").Code)
+	w = call("/bar", "shopper-ios/3.9")
+	assert.Equal(t, http.StatusUpgradeRequired, w.Code)
+	assert.Equal(t, "3.10", w.Header().Get(HeaderMinClientVersion))
+	assert.Equal(t, "", w.Header().Get(HeaderUpgradeURL))
+
+	w = call("/bar", "shopper-android/1.9")
+	assert.Equal(t, http.StatusUpgradeRequired, w.Code)
+	assert.Contains(t, w.Body.String(), "Please upgrade")
+
+	sw := a.ToSwagger("example.com")
+	assert.Contains(t, sw.Paths["/bar"]["get"].Responses, "426")
+
+	// upgrade info is attached to a copy of the error
+	shared := UpgradeRequiredError("Please upgrade")
+	assert.NotNil(t, WithUpgradeInfo(shared, UpgradeInfo{MinVersion: "2.0"}).(*internalError).Upgrade)
+	assert.Nil(t, shared.(*internalError).Upgrade)
+}
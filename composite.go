@@ -0,0 +1,207 @@
+package vertex
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// CompositePart is a route of the API a composite route calls
+type CompositePart struct {
+	// The name of the part in the response
+	Name string
+
+	// The method of the call. Defaults to GET
+	Method string
+
+	// The path of the route inside the API, with its path params filled in, e.g. /users/me
+	Path string
+
+	// Query or form params of the call
+	Params url.Values
+}
+
+// CompositePartResult is the outcome of a part of a composite route
+type CompositePartResult struct {
+	// The http status the route responded with
+	Status int `json:"status"`
+
+	// The response of the route, if it succeeded. JSON responses are embedded as is, others as strings
+	Body json.RawMessage `json:"body,omitempty"`
+
+	// The error message if the route failed
+	Error string `json:"error,omitempty"`
+}
+
+// CompositeResponse is the response of composite routes, with the outcome of every part by name. Like bulk
+// responses, it is rendered with a 207 Multi-Status http status, since parts succeed or fail independently.
+//
+// Routes returning it should set Returns to CompositeResponse{} for the documentation
+type CompositeResponse struct {
+	Succeeded int                             `json:"succeeded"`
+	Failed    int                             `json:"failed"`
+	Parts     map[string]*CompositePartResult `json:"parts"`
+}
+
+// Composite returns a handler calling several routes of the API in-process and aggregating their responses, e.g. for
+// endpoints bootstrapping dashboards in a single round trip:
+//
+//	{
+//		Path:        "/dashboard",
+//		Description: "Everything the dashboard loads on startup",
+//		Methods:     vertex.GET,
+//		Returns:     vertex.CompositeResponse{},
+//		Handler: vertex.Composite(
+//			vertex.CompositePart{Name: "user", Path: "/users/me"},
+//			vertex.CompositePart{Name: "feed", Path: "/feed", Params: url.Values{"limit": {"20"}}},
+//		),
+//	}
+//
+// The parts are called concurrently through the full routing table and middleware of the API, with the headers of
+// the composite request, so they are authenticated as the caller
+func Composite(parts ...CompositePart) RequestHandler {
+	return HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+
+		if r.api == nil || r.api.router == nil {
+			return nil, NewErrorf("Composite route %s is not served by an API", r.route)
+		}
+
+		ret := &CompositeResponse{Parts: make(map[string]*CompositePartResult, len(parts))}
+		results := make([]*CompositePartResult, len(parts))
+
+		wg := sync.WaitGroup{}
+		for i, part := range parts {
+			wg.Add(1)
+			go func(i int, part CompositePart) {
+				defer wg.Done()
+				results[i] = r.api.memoizedPart(r, part)
+			}(i, part)
+		}
+		wg.Wait()
+
+		for i, part := range parts {
+			ret.Parts[part.Name] = results[i]
+			if results[i].Status < 400 {
+				ret.Succeeded++
+			} else {
+				ret.Failed++
+			}
+		}
+		return ret, nil
+	})
+}
+
+// memoizedPart calls a part of a composite route, sharing the result of identical calls made while handling the
+// request
+func (a *API) memoizedPart(r *Request, part CompositePart) *CompositePartResult {
+
+	method := part.Method
+	if method == "" {
+		method = "GET"
+	}
+	key := "part " + a.Name + " " + dispatchKeyOf(method, part.Path, part.Params, r.Principal())
+	ret, _ := memoize(r.Context(), method, key, func() (interface{}, error) {
+		return a.callPart(r, part), nil
+	})
+	return ret.(*CompositePartResult)
+}
+
+// callPart calls a part of a composite route through the router of the API
+func (a *API) callPart(r *Request, part CompositePart) *CompositePartResult {
+
+	method := part.Method
+	if method == "" {
+		method = "GET"
+	}
+
+	u := a.FullPath(part.Path)
+	var body *strings.Reader
+	if method == "POST" || method == "PUT" || method == "PATCH" {
+		body = strings.NewReader(part.Params.Encode())
+	} else {
+		if len(part.Params) > 0 {
+			u += "?" + part.Params.Encode()
+		}
+		body = strings.NewReader("")
+	}
+
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return &CompositePartResult{Status: http.StatusBadRequest, Error: err.Error()}
+	}
+	req = req.WithContext(r.Context())
+
+	// the parts are called as the caller, in JSON
+	for k, v := range r.Header {
+		switch k {
+		case "Content-Length", "Content-Type", "Accept", "Accept-Encoding", "If-None-Match", "If-Match":
+		default:
+			req.Header[k] = v
+		}
+	}
+	if body.Len() > 0 {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.Header.Set("Accept", "application/json")
+	if r.Secure {
+		req.Header.Set("X-Forwarded-Proto", "https")
+	}
+	req.RemoteAddr = r.Request.RemoteAddr
+	req.Host = r.Host
+
+	rec := newPartRecorder()
+	a.router.ServeHTTP(rec, req)
+
+	ret := &CompositePartResult{Status: rec.status}
+	if rec.status >= 400 {
+		ret.Error = strings.TrimSpace(rec.body.String())
+		return ret
+	}
+
+	if json.Valid(rec.body.Bytes()) {
+		ret.Body = json.RawMessage(rec.body.Bytes())
+	} else if rec.body.Len() > 0 {
+		ret.Body, _ = json.Marshal(rec.body.String())
+	}
+	return ret
+}
+
+// partRecorder records the response of a part of a composite route
+type partRecorder struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func newPartRecorder() *partRecorder {
+	return &partRecorder{header: http.Header{}, status: http.StatusOK}
+}
+
+func (p *partRecorder) Header() http.Header {
+	return p.header
+}
+
+func (p *partRecorder) WriteHeader(status int) {
+	if !p.wroteHeader {
+		p.wroteHeader = true
+		p.status = status
+	}
+}
+
+func (p *partRecorder) Write(b []byte) (int, error) {
+	p.WriteHeader(http.StatusOK)
+	return p.body.Write(b)
+}
+
+// isCompositeResponse checks whether a route's declared return value is a composite response
+func isCompositeResponse(v interface{}) bool {
+	switch v.(type) {
+	case CompositeResponse, *CompositeResponse:
+		return true
+	}
+	return false
+}
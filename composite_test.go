@@ -0,0 +1,68 @@
+package vertex
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type compositeUserHandler struct{}
+
+func (compositeUserHandler) Handle(w http.ResponseWriter, r *Request) (interface{}, error) {
+	return map[string]string{"name": r.Header.Get("X-User")}, nil
+}
+
+type compositeFeedHandler struct {
+	Limit int `schema:"limit" required:"true"`
+}
+
+func (h compositeFeedHandler) Handle(w http.ResponseWriter, r *Request) (interface{}, error) {
+	return make([]int, h.Limit), nil
+}
+
+func TestComposite(t *testing.T) {
+
+	a := &API{
+		Name:          "composite",
+		Version:       "1.0",
+		Root:          "/composite",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{Path: "/user", Description: "User", Methods: GET, Handler: compositeUserHandler{}},
+			{Path: "/feed", Description: "Feed", Methods: GET, Handler: compositeFeedHandler{}},
+			{Path: "/dashboard", Description: "Dashboard", Methods: GET, Returns: CompositeResponse{},
+				Handler: Composite(
+					CompositePart{Name: "user", Path: "/user"},
+					CompositePart{Name: "feed", Path: "/feed", Params: url.Values{"limit": {"2"}}},
+					CompositePart{Name: "broken", Path: "/feed"},
+					CompositePart{Name: "missing", Path: "/nope"},
+				)},
+		},
+	}
+	srv := NewServer(":9982")
+	srv.AddAPI(a)
+
+	hr, _ := http.NewRequest("GET", a.FullPath("/dashboard"), nil)
+	hr.Header.Set("X-User", "alice")
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, hr)
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+
+	var resp CompositeResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 2, resp.Succeeded)
+	assert.Equal(t, 2, resp.Failed)
+	assert.Equal(t, http.StatusOK, resp.Parts["user"].Status)
+	assert.Equal(t, `{"name":"alice"}`, string(resp.Parts["user"].Body))
+	assert.Equal(t, `[0,0]`, string(resp.Parts["feed"].Body))
+	assert.Equal(t, http.StatusBadRequest, resp.Parts["broken"].Status)
+	assert.Contains(t, resp.Parts["broken"].Error, "limit")
+	assert.Equal(t, http.StatusNotFound, resp.Parts["missing"].Status)
+
+	assert.Contains(t, a.ToSwagger("example.com").Paths["/dashboard"]["get"].Responses, "207")
+}
@@ -23,6 +23,48 @@ type serverConfig struct {
 
 	// Disconnect idle clients after T seconds
 	ClientTimeout int `yaml:"client_timeout_sec"`
+
+	// How long to keep serving with failing readiness checks before shutting down, so load balancers can take us out of rotation
+	DrainDelay int `yaml:"drain_delay_sec"`
+
+	// How long to wait for in-flight requests to finish when shutting down
+	ShutdownGrace int `yaml:"shutdown_grace_sec"`
+
+	// Number of workers running the work deferred by requests, and the number of tasks queued for them. See
+	// Request.Defer
+	DeferWorkers int `yaml:"defer_workers"`
+	DeferQueue   int `yaml:"defer_queue"`
+
+	// Dump a table of all registered APIs and routes on startup [text | json]. Empty for no dump
+	StartupBanner string `yaml:"startup_banner"`
+
+	// How long to wait for an upgraded process to become ready before giving up on the upgrade
+	UpgradeTimeout int `yaml:"upgrade_timeout_sec"`
+
+	// Maximum number of in-flight requests tracked for the live dump. Requests beyond it are counted but not tracked
+	MaxTrackedRequests int `yaml:"max_tracked_requests"`
+
+	// Maximum concurrent requests across all the APIs of the server. 0 means unlimited. See API.MaxConcurrency
+	MaxConcurrency int `yaml:"max_concurrency"`
+
+	// Directory of the swagger spec snapshots compared by the spec diff endpoint
+	SpecSnapshotDir string `yaml:"spec_snapshot_dir"`
+
+	// Serve the examples of all routes instead of invoking their handlers. See Route.Mock
+	Mock bool `yaml:"mock"`
+
+	// Directory of the fixtures recorded and replayed by the test runner. See FixturesRecord
+	TestFixturesDir string `yaml:"test_fixtures_dir"`
+
+	// Environments the test runner can run the tests against. See TestEnvironment
+	TestEnvironments []TestEnvironment `yaml:"test_environments"`
+
+	// Ops owned file of per-route overrides, reloaded when it changes. See RouteOverride
+	RouteOverridesFile string `yaml:"route_overrides_file"`
+
+	// The host clients reach the server at, e.g. api.example.com, set as the host of the swagger specs. Defaults to
+	// the Host header of the spec request
+	PublicHost string `yaml:"public_host"`
 }
 
 // General-purpose to just protect some urls
@@ -45,11 +87,18 @@ var Config = struct {
 	apiconfs map[string]interface{}
 }{
 	Server: serverConfig{
-		ListenAddr:       ":9944",
-		AllowInsecure:    false,
-		ConsoleFilesPath: "../console",
-		LoggingLevel:     "INFO",
-		ClientTimeout:    60,
+		ListenAddr:         ":9944",
+		AllowInsecure:      false,
+		ConsoleFilesPath:   "../console",
+		LoggingLevel:       "INFO",
+		ClientTimeout:      60,
+		DrainDelay:         5,
+		ShutdownGrace:      30,
+		DeferWorkers:       DefaultDeferWorkers,
+		DeferQueue:         DefaultDeferQueue,
+		UpgradeTimeout:     30,
+		MaxTrackedRequests: 10000,
+		TestFixturesDir:    "fixtures",
 	},
 
 	Auth: authConfig{
@@ -78,6 +127,11 @@ func ReadConfigs() error {
 	}
 	logging.Info("Read configs: %#v", &Config)
 
+	// secrets are resolved after logging the configs, so they never reach the logs
+	if err := resolveSecrets(&Config); err != nil {
+		return err
+	}
+
 	for k, m := range Config.APIConfigs {
 
 		if conf, found := Config.apiconfs[k]; found && conf != nil {
@@ -89,6 +143,9 @@ func ReadConfigs() error {
 					logging.Error("Error reading config for API %s: %s", k, err)
 				} else {
 					logging.Debug("Unmarshaled API config for %s: %#v", k, conf)
+					if err := resolveSecrets(conf); err != nil {
+						return err
+					}
 				}
 
 			} else {
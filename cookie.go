@@ -0,0 +1,295 @@
+package vertex
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// Cookie declares a typed cookie, along with how it is protected. Values are JSON encoded, so any type can be stored:
+//
+//	var sessionCookie = vertex.Cookie{
+//		Name:      "session",
+//		MaxAge:    24 * time.Hour,
+//		Encrypted: true,
+//		Keys:      [][]byte{newKey, oldKey},
+//	}
+//
+//	sessionCookie.Set(w, r, session)
+//	err := sessionCookie.Get(r, &session)
+//
+// The Secure flag is set if the request is secure, or if the API handling it does not allow insecure access
+type Cookie struct {
+	Name   string
+	Path   string
+	Domain string
+
+	// How long the cookie lives. 0 means a session cookie. Signed and encrypted values carry the time they were
+	// issued, and are rejected once they are older than MaxAge, since browsers are only asked to drop them
+	MaxAge time.Duration
+
+	// Defaults to SameSite=Lax
+	SameSite http.SameSite
+
+	// Cookies are HttpOnly unless set to allow scripts to read them
+	AllowScripts bool
+
+	// Sign the cookie with HMAC-SHA256, so clients cannot modify it
+	Signed bool
+
+	// Encrypt the cookie with AES-GCM, so clients can neither read nor modify it
+	Encrypted bool
+
+	// Secret keys for signing and encryption. The first key is used for new cookies, and the rest are only used to
+	// read cookies written with them, so keys can be rotated by prepending a new key
+	Keys [][]byte
+
+	// A key ring to take the keys from instead of Keys, so keys rotated in the ring are picked up
+	KeyRing *KeyRing
+}
+
+var errInvalidCookie = errors.New("invalid cookie value")
+
+// protectedValue is the payload of signed and encrypted cookies, authenticated along with the time it was issued
+type protectedValue struct {
+	IssuedAt int64           `json:"iat"`
+	Value    json.RawMessage `json:"v"`
+}
+
+// secrets returns the cookie's keys, newest key first
+func (c Cookie) secrets() [][]byte {
+	if c.KeyRing != nil {
+		return c.KeyRing.Secrets()
+	}
+	return c.Keys
+}
+
+// deriveKey derives a key for a specific purpose from a secret, so signing and encryption never share keys
+func deriveKey(secret []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func (c Cookie) signature(key []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, deriveKey(key, "vertex.cookie.sign"))
+	mac.Write([]byte(c.Name + "|" + payload))
+	return mac.Sum(nil)
+}
+
+func (c Cookie) gcm(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(deriveKey(key, "vertex.cookie.encrypt"))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encode serializes, encrypts and signs a value according to the cookie's settings, as issued at the given time
+func (c Cookie) encode(v interface{}, now time.Time) (string, error) {
+
+	keys := c.secrets()
+	if (c.Signed || c.Encrypted) && len(keys) == 0 {
+		return "", logging.Errorf("No keys set for protected cookie %s", c.Name)
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	if c.Signed || c.Encrypted {
+		if data, err = json.Marshal(protectedValue{IssuedAt: now.Unix(), Value: data}); err != nil {
+			return "", err
+		}
+	}
+
+	if c.Encrypted {
+		aead, err := c.gcm(keys[0])
+		if err != nil {
+			return "", err
+		}
+
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+
+		// the cookie name is authenticated too, so values cannot be moved between cookies
+		data = aead.Seal(nonce, nonce, data, []byte(c.Name))
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	if c.Signed {
+		payload += "." + base64.RawURLEncoding.EncodeToString(c.signature(keys[0], payload))
+	}
+
+	return payload, nil
+}
+
+// decode verifies, decrypts and deserializes a cookie value, trying all the cookie's keys. Protected values older
+// than the cookie's MaxAge at the given time are rejected
+func (c Cookie) decode(value string, v interface{}, now time.Time) error {
+
+	keys := c.secrets()
+	payload := value
+	if c.Signed {
+		idx := strings.LastIndexByte(value, '.')
+		if idx < 0 {
+			return errInvalidCookie
+		}
+		payload = value[:idx]
+
+		sig, err := base64.RawURLEncoding.DecodeString(value[idx+1:])
+		if err != nil {
+			return errInvalidCookie
+		}
+
+		valid := false
+		for _, key := range keys {
+			if hmac.Equal(sig, c.signature(key, payload)) {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return errInvalidCookie
+		}
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return errInvalidCookie
+	}
+
+	if c.Encrypted {
+		var plain []byte
+		for _, key := range keys {
+			aead, err := c.gcm(key)
+			if err != nil || len(data) < aead.NonceSize() {
+				continue
+			}
+			if plain, err = aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(c.Name)); err == nil {
+				break
+			}
+			plain = nil
+		}
+		if plain == nil {
+			return errInvalidCookie
+		}
+		data = plain
+	}
+
+	if c.Signed || c.Encrypted {
+		var pv protectedValue
+		if err := json.Unmarshal(data, &pv); err != nil || pv.Value == nil {
+			return errInvalidCookie
+		}
+		if c.MaxAge > 0 && now.Sub(time.Unix(pv.IssuedAt, 0)) > c.MaxAge {
+			return errInvalidCookie
+		}
+		data = pv.Value
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return errInvalidCookie
+	}
+	return nil
+}
+
+// httpCookie creates the http cookie to send for a request, applying the defaults
+func (c Cookie) httpCookie(r *Request, value string) *http.Cookie {
+
+	ret := &http.Cookie{
+		Name:     c.Name,
+		Value:    value,
+		Path:     c.Path,
+		Domain:   c.Domain,
+		HttpOnly: !c.AllowScripts,
+		SameSite: c.SameSite,
+		Secure:   r.Secure || (r.api != nil && !r.api.AllowInsecure),
+	}
+
+	if ret.Path == "" {
+		ret.Path = "/"
+	}
+	if ret.SameSite == 0 {
+		ret.SameSite = http.SameSiteLaxMode
+	}
+	if c.MaxAge > 0 {
+		ret.MaxAge = int(c.MaxAge.Seconds())
+		ret.Expires = time.Now().Add(c.MaxAge)
+	}
+
+	return ret
+}
+
+// Set encodes a value and sets it as the cookie in the response
+func (c Cookie) Set(w http.ResponseWriter, r *Request, v interface{}) error {
+
+	value, err := c.encode(v, time.Now())
+	if err != nil {
+		return logging.Errorf("Could not encode cookie %s: %s", c.Name, err)
+	}
+
+	c.unset(w)
+	http.SetCookie(w, c.httpCookie(r, value))
+	return nil
+}
+
+// unset removes the cookie from the response if it was already set while handling the request
+func (c Cookie) unset(w http.ResponseWriter) {
+
+	prefix := c.Name + "="
+	cookies := w.Header()["Set-Cookie"]
+	kept := make([]string, 0, len(cookies))
+	for _, v := range cookies {
+		if !strings.HasPrefix(v, prefix) {
+			kept = append(kept, v)
+		}
+	}
+	if len(kept) < len(cookies) {
+		w.Header()["Set-Cookie"] = kept
+	}
+}
+
+// Get reads the cookie from the request into v. It returns http.ErrNoCookie if the request does not have the cookie,
+// and an InvalidParamError if the cookie was tampered with or cannot be decoded
+func (c Cookie) Get(r *Request, v interface{}) error {
+
+	hc, err := r.Request.Cookie(c.Name)
+	if err != nil {
+		return err
+	}
+
+	if err := c.decode(hc.Value, v, time.Now()); err != nil {
+		logging.Warning("Invalid value for cookie %s in %s", c.Name, r)
+		if c.Signed || c.Encrypted {
+			e := NewSecurityEvent(r, EventSignatureMismatch, "Invalid cookie")
+			e.Details = map[string]interface{}{"cookie": c.Name}
+			EmitSecurityEvent(e)
+		}
+		return InvalidParamError("Invalid value for cookie %s", c.Name)
+	}
+	return nil
+}
+
+// Delete tells the client to delete the cookie
+func (c Cookie) Delete(w http.ResponseWriter, r *Request) {
+
+	c.unset(w)
+	hc := c.httpCookie(r, "")
+	hc.MaxAge = -1
+	hc.Expires = time.Unix(0, 0)
+	http.SetCookie(w, hc)
+}
@@ -0,0 +1,124 @@
+package vertex
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type cookieSession struct {
+	User  string
+	Admin bool
+}
+
+// roundTrip sets a cookie on a response, and returns a request carrying it back
+func roundTrip(t *testing.T, c Cookie, v interface{}) (*Request, *http.Cookie) {
+
+	hr, _ := http.NewRequest("GET", "/foo", nil)
+	w := httptest.NewRecorder()
+	assert.NoError(t, c.Set(w, NewRequest(hr), v))
+
+	cookies := (&http.Response{Header: w.Header()}).Cookies()
+	if !assert.Len(t, cookies, 1) {
+		t.FailNow()
+	}
+
+	hr, _ = http.NewRequest("GET", "/foo", nil)
+	hr.AddCookie(cookies[0])
+	return NewRequest(hr), cookies[0]
+}
+
+func TestCookies(t *testing.T) {
+
+	sess := cookieSession{User: "alice", Admin: true}
+
+	// plain typed cookies
+	plain := Cookie{Name: "plain", MaxAge: time.Hour}
+	r, hc := roundTrip(t, plain, sess)
+	assert.True(t, hc.HttpOnly)
+	assert.Equal(t, http.SameSiteLaxMode, hc.SameSite)
+	assert.Equal(t, 3600, hc.MaxAge)
+
+	var out cookieSession
+	assert.NoError(t, plain.Get(r, &out))
+	assert.Equal(t, sess, out)
+
+	// missing cookies
+	hr, _ := http.NewRequest("GET", "/foo", nil)
+	assert.Equal(t, http.ErrNoCookie, plain.Get(NewRequest(hr), &out))
+
+	// signed cookies can be read but not modified
+	oldKey, newKey := []byte("old secret"), []byte("new secret")
+	signed := Cookie{Name: "signed", Signed: true, Keys: [][]byte{oldKey}}
+	r, hc = roundTrip(t, signed, sess)
+	out = cookieSession{}
+	assert.NoError(t, signed.Get(r, &out))
+	assert.Equal(t, sess, out)
+
+	hr, _ = http.NewRequest("GET", "/foo", nil)
+	hr.AddCookie(&http.Cookie{Name: "signed", Value: "x" + hc.Value})
+	assert.Error(t, signed.Get(NewRequest(hr), &out))
+
+	// key rotation - cookies signed with the old key are still valid
+	rotated := signed
+	rotated.Keys = [][]byte{newKey, oldKey}
+	assert.NoError(t, rotated.Get(r, &out))
+
+	retired := signed
+	retired.Keys = [][]byte{newKey}
+	assert.Error(t, retired.Get(r, &out))
+
+	// encrypted cookies cannot be read by clients
+	encrypted := Cookie{Name: "encrypted", Encrypted: true, Keys: [][]byte{newKey, oldKey}}
+	r, hc = roundTrip(t, encrypted, sess)
+	assert.False(t, strings.Contains(hc.Value, "alice"))
+	out = cookieSession{}
+	assert.NoError(t, encrypted.Get(r, &out))
+	assert.Equal(t, sess, out)
+
+	// values cannot be moved between cookies
+	moved := encrypted
+	moved.Name = "other"
+	assert.Error(t, moved.decode(hc.Value, &out, time.Now()))
+
+	// protected values expire with the cookie, even if the client keeps sending them
+	expiring := Cookie{Name: "expiring", MaxAge: time.Hour, Signed: true, Keys: [][]byte{newKey}}
+	for _, c := range []Cookie{expiring, {Name: "expiring", MaxAge: time.Hour, Encrypted: true, Keys: [][]byte{newKey}}} {
+		value, err := c.encode(sess, time.Now())
+		assert.NoError(t, err)
+		assert.NoError(t, c.decode(value, &out, time.Now().Add(59*time.Minute)))
+		assert.Equal(t, errInvalidCookie, c.decode(value, &out, time.Now().Add(61*time.Minute)))
+	}
+
+	// the issue time is authenticated, so it cannot be refreshed by clients
+	r, hc = roundTrip(t, expiring, sess)
+	assert.NoError(t, expiring.Get(r, &out))
+	payload := hc.Value[:strings.LastIndexByte(hc.Value, '.')]
+	forged := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"iat":%d,"v":{"User":"alice"}}`, time.Now().Add(time.Hour).Unix())))
+	assert.Equal(t, errInvalidCookie, expiring.decode(forged+hc.Value[len(payload):], &out, time.Now()))
+
+	// protected cookies require keys
+	hr, _ = http.NewRequest("GET", "/foo", nil)
+	assert.Error(t, Cookie{Name: "nokeys", Signed: true}.Set(httptest.NewRecorder(), NewRequest(hr), sess))
+}
+
+func TestSecureCookieDefaults(t *testing.T) {
+
+	hr, _ := http.NewRequest("GET", "/foo", nil)
+	r := NewRequest(hr)
+	c := Cookie{Name: "foo"}
+
+	assert.False(t, c.httpCookie(r, "").Secure)
+
+	r.api = &API{AllowInsecure: false}
+	assert.True(t, c.httpCookie(r, "").Secure)
+
+	r.api = &API{AllowInsecure: true}
+	assert.False(t, c.httpCookie(r, "").Secure)
+}
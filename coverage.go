@@ -0,0 +1,107 @@
+package vertex
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// testCoverage collects the requests testers make, to tell which routes of the API they exercised. Only requests
+// made with TestContext.GetJSON are counted
+type testCoverage struct {
+	mtx  sync.Mutex
+	hits map[string]bool
+}
+
+func newTestCoverage() *testCoverage {
+	return &testCoverage{hits: map[string]bool{}}
+}
+
+// record counts a request made by a tester
+func (c *testCoverage) record(r *http.Request) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.hits[r.Method+" "+r.URL.Path] = true
+}
+
+// CoverageReport lists the routes of an API exercised by its tests, as "METHOD path"
+type CoverageReport struct {
+	Covered   []string `json:"covered"`
+	Uncovered []string `json:"uncovered"`
+}
+
+// Complete returns true if all the routes were exercised
+func (r CoverageReport) Complete() bool {
+	return len(r.Uncovered) == 0
+}
+
+// report matches the requests made by the testers to the routes of an API
+func (c *testCoverage) report(a *API) CoverageReport {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	ret := CoverageReport{Covered: []string{}, Uncovered: []string{}}
+	for _, route := range a.Routes {
+		pth := a.FullPath(route.Path)
+		for _, m := range methodFlags {
+			if route.Methods&m.flag != m.flag {
+				continue
+			}
+
+			key := m.name + " " + pth
+			covered := false
+			for hit := range c.hits {
+				if strings.HasPrefix(hit, m.name+" ") && matchRoutePath(pth, strings.TrimPrefix(hit, m.name+" ")) {
+					covered = true
+					break
+				}
+			}
+			if covered {
+				ret.Covered = append(ret.Covered, key)
+			} else {
+				ret.Uncovered = append(ret.Uncovered, key)
+			}
+		}
+	}
+
+	sort.Strings(ret.Covered)
+	sort.Strings(ret.Uncovered)
+	return ret
+}
+
+// matchRoutePath returns true if a request path matches a route path with :param and *catchall segments
+func matchRoutePath(route, pth string) bool {
+
+	rparts := strings.Split(strings.Trim(route, "/"), "/")
+	parts := strings.Split(strings.Trim(pth, "/"), "/")
+
+	for i, rp := range rparts {
+		if strings.HasPrefix(rp, "*") {
+			return true
+		}
+		if i >= len(parts) {
+			return false
+		}
+		if !strings.HasPrefix(rp, ":") && rp != parts[i] {
+			return false
+		}
+	}
+	return len(rparts) == len(parts)
+}
+
+// writeCoverage writes a coverage report as JSON to a file
+func writeCoverage(report CoverageReport, fileName string) error {
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(fileName, data, 0644); err != nil {
+		return fmt.Errorf("Could not write coverage report: %s", err)
+	}
+	return nil
+}
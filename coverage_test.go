@@ -0,0 +1,72 @@
+package vertex
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchRoutePath(t *testing.T) {
+	assert.True(t, matchRoutePath("/api/1.0/users", "/api/1.0/users"))
+	assert.True(t, matchRoutePath("/api/1.0/users/:id", "/api/1.0/users/42"))
+	assert.True(t, matchRoutePath("/api/1.0/static/*file", "/api/1.0/static/js/app.js"))
+	assert.False(t, matchRoutePath("/api/1.0/users/:id", "/api/1.0/users"))
+	assert.False(t, matchRoutePath("/api/1.0/users", "/api/1.0/users/42"))
+	assert.False(t, matchRoutePath("/api/1.0/users", "/api/1.0/orders"))
+}
+
+func TestCoverage(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer ts.Close()
+
+	get := CriticalTest(func(ctx *TestContext) {
+		req, _ := ctx.NewRequest("GET", nil, Params{"id": "42"})
+		ctx.GetJSON(req, &map[string]interface{}{})
+	})
+
+	a := &API{
+		Name: "coverage",
+		Root: "/coverage",
+		Routes: Routes{
+			{Path: "/users/{id}", Methods: GET | POST, Handler: VoidHandler{}, Test: get},
+			{Path: "/orders", Methods: GET, Handler: VoidHandler{}, Test: WarningTest(func(*TestContext) {})},
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "coverage")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	buf := bytes.NewBuffer(nil)
+	runner := newTestRunner(buf, a, ts.URL, AllTests, TestFormatText)
+	runner.coverageFile = filepath.Join(dir, "coverage.json")
+	assert.True(t, runner.Run())
+	assert.Contains(t, buf.String(), "COVERAGE: 1/3 routes")
+	assert.Contains(t, buf.String(), " UNCOVERED: GET /coverage/orders")
+	assert.Contains(t, buf.String(), " UNCOVERED: POST /coverage/users/:id")
+
+	data, err := ioutil.ReadFile(runner.coverageFile)
+	assert.NoError(t, err)
+	var report CoverageReport
+	assert.NoError(t, json.Unmarshal(data, &report))
+	assert.Equal(t, []string{"GET /coverage/users/:id"}, report.Covered)
+	assert.Equal(t, []string{"GET /coverage/orders", "POST /coverage/users/:id"}, report.Uncovered)
+	assert.False(t, report.Complete())
+
+	// requiring coverage fails the run
+	runner = newTestRunner(bytes.NewBuffer(nil), a, ts.URL, AllTests, TestFormatText)
+	runner.requireCoverage = true
+	assert.False(t, runner.Run())
+}
@@ -0,0 +1,239 @@
+package vertex
+
+import (
+	"encoding"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// CSVContentType is the content type of CSV responses
+const CSVContentType = "text/csv"
+
+// CSVRenderer renders slices of structs as CSV, one row per element, so reporting endpoints can be opened directly by
+// spreadsheets and BI tools. The header row names the columns by the csv tags of the struct fields, falling back to
+// their json tags and names. Fields tagged with "-" are skipped:
+//
+//	type ReportRow struct {
+//		Date   time.Time `csv:"date"`
+//		Visits int       `csv:"visits"`
+//		Notes  string    `csv:"-"`
+//	}
+//
+// Times are rendered as RFC 3339, and values other than strings, numbers and bools as JSON. Errors are rendered like
+// in JSON responses.
+//
+// Strings starting with =, +, -, @, a tab or a carriage return are prefixed with a ', so spreadsheets do not run
+// them as formulas
+type CSVRenderer struct {
+	// If set, the response is a download of a file by this name
+	FileName string
+
+	// If set, strings are rendered as they are, even if spreadsheets would run them as formulas
+	AllowFormulas bool
+}
+
+func (c CSVRenderer) Render(v interface{}, e error, w http.ResponseWriter, r *Request) error {
+
+	w.Header().Set(HeaderProcessingTime, fmt.Sprintf("%.03f", time.Since(r.StartTime).Seconds()*1000))
+	w.Header().Set(HeaderRequestId, r.RequestId)
+
+	if e != nil {
+		renderError(w, r, e)
+		return nil
+	}
+
+	records, err := csvRecords(v, !c.AllowFormulas)
+	if err != nil {
+		logging.Error("Could not encode csv response: %s", err)
+		writeError(w, "Error sending response")
+		return nil
+	}
+
+	w.Header().Set("Content-Type", CSVContentType+"; charset=utf-8")
+	if c.FileName != "" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", c.FileName))
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.WriteAll(records); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (CSVRenderer) ContentTypes() []string {
+	return []string{CSVContentType}
+}
+
+// csvColumn is a column of a CSV response, and the index of its field in the row struct
+type csvColumn struct {
+	name  string
+	index []int
+}
+
+// csvRecords converts a slice of structs, or a single struct, to CSV records including the header row. If
+// escapeFormulas is set, strings spreadsheets would run as formulas are escaped
+func csvRecords(v interface{}, escapeFormulas bool) ([][]string, error) {
+
+	val := reflect.ValueOf(v)
+	for val.Kind() == reflect.Ptr && !val.IsNil() {
+		val = val.Elem()
+	}
+
+	rows := []reflect.Value{}
+	var rowType reflect.Type
+	switch val.Kind() {
+	case reflect.Slice, reflect.Array:
+		rowType = val.Type().Elem()
+		for i := 0; i < val.Len(); i++ {
+			rows = append(rows, val.Index(i))
+		}
+	case reflect.Struct:
+		rowType = val.Type()
+		rows = append(rows, val)
+	default:
+		return nil, fmt.Errorf("csv: cannot render %T, only slices of structs", v)
+	}
+
+	if rowType.Kind() == reflect.Ptr {
+		rowType = rowType.Elem()
+	}
+	if rowType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("csv: cannot render rows of %s, only structs", rowType)
+	}
+
+	columns := csvColumns(rowType, nil)
+	header := make([]string, len(columns))
+	for i, c := range columns {
+		header[i] = c.name
+	}
+
+	records := [][]string{header}
+	for _, row := range rows {
+		if row.Kind() == reflect.Ptr {
+			if row.IsNil() {
+				continue
+			}
+			row = row.Elem()
+		}
+
+		record := make([]string, len(columns))
+		for i, c := range columns {
+			field, ok := csvField(row, c.index)
+			if !ok {
+				continue
+			}
+			s, err := csvValue(field, escapeFormulas)
+			if err != nil {
+				return nil, fmt.Errorf("csv: column %s: %s", c.name, err)
+			}
+			record[i] = s
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// csvColumns returns the columns of a row struct, including the fields of embedded structs
+func csvColumns(t reflect.Type, index []int) []csvColumn {
+
+	ret := []csvColumn{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		idx := append(append([]int{}, index...), i)
+
+		name := strings.Split(f.Tag.Get("csv"), ",")[0]
+		if name == "" {
+			name = strings.Split(f.Tag.Get("json"), ",")[0]
+		}
+		if name == "-" {
+			continue
+		}
+
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			ret = append(ret, csvColumns(ft, idx)...)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		ret = append(ret, csvColumn{name, idx})
+	}
+	return ret
+}
+
+// csvField returns the field of a row by its index, or false if it is in a nil embedded struct
+func csvField(row reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && row.Kind() == reflect.Ptr {
+			if row.IsNil() {
+				return reflect.Value{}, false
+			}
+			row = row.Elem()
+		}
+		row = row.Field(x)
+	}
+	return row, true
+}
+
+// csvValue formats a field as a CSV cell
+func csvValue(v reflect.Value, escapeFormulas bool) (string, error) {
+
+	if v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return "", nil
+		}
+		v = v.Elem()
+	}
+
+	switch x := v.Interface().(type) {
+	case time.Time:
+		if x.IsZero() {
+			return "", nil
+		}
+		return x.Format(time.RFC3339), nil
+	case encoding.TextMarshaler:
+		b, err := x.MarshalText()
+		return csvString(string(b), escapeFormulas), err
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return csvString(v.String(), escapeFormulas), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64), nil
+	}
+
+	b, err := json.Marshal(v.Interface())
+	return string(b), err
+}
+
+// csvString formats a string cell, prefixing strings that start like a formula with a ' if escapeFormulas is set,
+// so spreadsheets show them as text
+func csvString(s string, escapeFormulas bool) string {
+	if escapeFormulas && s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
@@ -0,0 +1,78 @@
+package vertex
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type csvBase struct {
+	Id int `json:"id"`
+}
+
+type csvRow struct {
+	csvBase
+	Date    time.Time         `csv:"date"`
+	Name    string            `json:"name,omitempty"`
+	Score   float64           `csv:"score"`
+	Active  bool              `csv:"active"`
+	Manager *string           `csv:"manager"`
+	Meta    map[string]string `csv:"meta"`
+	Secret  string            `csv:"-"`
+	hidden  string
+}
+
+func TestCSVRenderer(t *testing.T) {
+
+	hr, _ := http.NewRequest("GET", "/report", nil)
+	r := NewRequest(hr)
+
+	boss := "alice"
+	rows := []csvRow{
+		{csvBase: csvBase{1}, Date: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC), Name: "foo, bar", Score: 1.5,
+			Active: true, Manager: &boss, Meta: map[string]string{"a": "b"}, Secret: "x"},
+		{csvBase: csvBase{2}, Name: `say "hi"`},
+	}
+
+	w := httptest.NewRecorder()
+	assert.NoError(t, CSVRenderer{FileName: "report.csv"}.Render(rows, nil, w, r))
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="report.csv"`, w.Header().Get("Content-Disposition"))
+	assert.Equal(t, "id,date,name,score,active,manager,meta\n"+
+		"1,2020-01-02T00:00:00Z,\"foo, bar\",1.5,true,alice,\"{\"\"a\"\":\"\"b\"\"}\"\n"+
+		"2,,\"say \"\"hi\"\"\",0,false,,null\n", w.Body.String())
+
+	// empty slices still have a header
+	w = httptest.NewRecorder()
+	CSVRenderer{}.Render([]*csvBase{}, nil, w, r)
+	assert.Equal(t, "id\n", w.Body.String())
+	assert.Equal(t, "", w.Header().Get("Content-Disposition"))
+
+	w = httptest.NewRecorder()
+	CSVRenderer{}.Render([]int{1}, nil, w, r)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	w = httptest.NewRecorder()
+	CSVRenderer{}.Render(nil, ForbiddenError("no report"), w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// strings spreadsheets would run as formulas are escaped, numbers are not
+	formulas := []csvRow{{csvBase: csvBase{-1}, Name: "=HYPERLINK(\"http://evil\")", Manager: &boss}, {Name: "@SUM(A1)"},
+		{Name: "+1"}, {Name: "-1"}, {Name: "\tx"}, {Name: "a=b"}}
+	w = httptest.NewRecorder()
+	assert.NoError(t, CSVRenderer{}.Render(formulas, nil, w, r))
+	assert.Equal(t, "id,date,name,score,active,manager,meta\n"+
+		"-1,,\"'=HYPERLINK(\"\"http://evil\"\")\",0,false,alice,null\n"+
+		"0,,'@SUM(A1),0,false,,null\n"+
+		"0,,'+1,0,false,,null\n"+
+		"0,,'-1,0,false,,null\n"+
+		"0,,'\tx,0,false,,null\n"+
+		"0,,a=b,0,false,,null\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	assert.NoError(t, CSVRenderer{AllowFormulas: true}.Render(formulas[1:2], nil, w, r))
+	assert.Equal(t, "id,date,name,score,active,manager,meta\n0,,@SUM(A1),0,false,,null\n", w.Body.String())
+}
@@ -0,0 +1,151 @@
+package vertex
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// Default sizes of the pool running deferred work
+const (
+	DefaultDeferWorkers = 8
+	DefaultDeferQueue   = 1000
+)
+
+// Defer schedules work to run after the response is rendered, e.g. sending a welcome email or warming a cache,
+// so it does not delay the client:
+//
+//	r.Defer(func(ctx context.Context) {
+//		h.Mailer.Send(nil, welcome)
+//	})
+//
+// Deferred work runs on a bounded pool of workers, so it may run concurrently with the work deferred by other
+// requests. When the server shuts down, it waits for deferred work to finish after it stopped serving requests,
+// up to the shutdown grace, and cancels the context of work still running after it
+func (r *Request) Defer(f func(ctx context.Context)) {
+	r.deferred = append(r.deferred, f)
+}
+
+// runDeferred hands the work deferred by the request to the pool
+func (r *Request) runDeferred() {
+	for _, f := range r.deferred {
+		deferredWork.submit(r.route, f)
+	}
+	r.deferred = nil
+}
+
+type deferredTask struct {
+	route string
+	f     func(ctx context.Context)
+}
+
+// deferPool runs deferred work. It starts with the first deferred task, and can be restarted after it is flushed
+type deferPool struct {
+	// tasks submitted and not finished yet, queued or running
+	pending int64
+
+	mtx    sync.RWMutex
+	tasks  chan deferredTask
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// every run of the pool has its own wait group, since work canceled by a flush may still be running after it
+	wg *sync.WaitGroup
+}
+
+var deferredWork = &deferPool{}
+
+// start starts the workers of the pool if they are not running
+func (p *deferPool) start() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.tasks != nil {
+		return
+	}
+
+	workers, queue := Config.Server.DeferWorkers, Config.Server.DeferQueue
+	if workers <= 0 {
+		workers = DefaultDeferWorkers
+	}
+	if queue <= 0 {
+		queue = DefaultDeferQueue
+	}
+
+	p.tasks = make(chan deferredTask, queue)
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	p.wg = &sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go p.work(p.ctx, p.tasks, p.wg)
+	}
+}
+
+func (p *deferPool) work(ctx context.Context, tasks chan deferredTask, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for t := range tasks {
+		p.run(ctx, t)
+	}
+}
+
+func (p *deferPool) run(ctx context.Context, t deferredTask) {
+	defer func() {
+		atomic.AddInt64(&p.pending, -1)
+		if e := recover(); e != nil {
+			logging.Error("Deferred work of %s panicked: %v", t.route, e)
+		}
+	}()
+	t.f(ctx)
+}
+
+// submit queues a task, waiting for room in the queue if it is full. The response was already rendered, so only
+// the connection of the request waits
+func (p *deferPool) submit(route string, f func(ctx context.Context)) {
+
+	atomic.AddInt64(&p.pending, 1)
+
+	p.mtx.RLock()
+	for p.tasks == nil {
+		p.mtx.RUnlock()
+		p.start()
+		p.mtx.RLock()
+	}
+	defer p.mtx.RUnlock()
+
+	p.tasks <- deferredTask{route: route, f: f}
+}
+
+// pendingCount returns the number of tasks queued or running
+func (p *deferPool) pendingCount() int64 {
+	return atomic.LoadInt64(&p.pending)
+}
+
+// flush waits up to grace for the queued and running work to finish, and cancels the work still running after it
+func (p *deferPool) flush(grace time.Duration) {
+
+	p.mtx.Lock()
+	tasks, cancel, wg := p.tasks, p.cancel, p.wg
+	p.tasks = nil
+	p.mtx.Unlock()
+
+	if tasks == nil {
+		return
+	}
+	close(tasks)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(grace):
+		logging.Warning("Deferred work did not finish within %v", grace)
+	}
+	cancel()
+}
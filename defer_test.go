@@ -0,0 +1,85 @@
+package vertex
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefer(t *testing.T) {
+
+	var mtx sync.Mutex
+	var done []string
+	rendered := make(chan struct{})
+
+	a := &API{
+		Name:          "deferred",
+		Version:       "1.0",
+		Root:          "/deferred",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{Path: "/welcome", Description: "Welcome", Methods: GET, Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+				r.Defer(func(ctx context.Context) {
+					// the response is rendered before deferred work runs
+					<-rendered
+					time.Sleep(20 * time.Millisecond)
+					mtx.Lock()
+					done = append(done, "email")
+					mtx.Unlock()
+				})
+				r.Defer(func(ctx context.Context) {
+					panic("boom")
+				})
+				return "welcome", nil
+			})},
+		},
+	}
+	srv := NewServer(":9997")
+	srv.AddAPI(a)
+
+	hr, _ := http.NewRequest("GET", a.FullPath("/welcome"), nil)
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, hr)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "welcome")
+	close(rendered)
+
+	// flushing waits for the deferred work, and panics do not kill the pool
+	deferredWork.flush(time.Second)
+	mtx.Lock()
+	assert.Equal(t, []string{"email"}, done)
+	mtx.Unlock()
+}
+
+func TestDeferFlushGrace(t *testing.T) {
+
+	canceled := make(chan struct{})
+	deferredWork.submit("/slow", func(ctx context.Context) {
+		<-ctx.Done()
+		close(canceled)
+	})
+
+	// work still running after the grace is canceled
+	deferredWork.flush(10 * time.Millisecond)
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("deferred work was not canceled")
+	}
+
+	// the pool restarts with the next deferred task
+	ran := make(chan struct{})
+	deferredWork.submit("/next", func(ctx context.Context) { close(ran) })
+	deferredWork.flush(time.Second)
+	select {
+	case <-ran:
+	default:
+		t.Fatal("deferred work did not run")
+	}
+}
@@ -0,0 +1,223 @@
+package vertex
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// Limits of the diagnostics attached to failed tests
+const (
+	maxLoggedRequests     = 1000
+	maxRequestLogMessages = 100
+	maxDiagnosticBody     = 4096
+	maxDiagnosticRequests = 5
+)
+
+// headers masked in the diagnostics of failed tests
+var secretHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// requestLogs keeps the log messages of the latest requests by request id, so failed tests can show what the
+// server logged while handling their requests
+var requestLogs = struct {
+	sync.Mutex
+	ids  []string
+	logs map[string][]string
+}{
+	logs: map[string][]string{},
+}
+
+// recordRequestLog keeps a log message of a request, forgetting the oldest request beyond maxLoggedRequests. Request
+// ids may be sent by clients, so the messages kept per id are limited to maxRequestLogMessages
+func recordRequestLog(id, msg string) {
+	requestLogs.Lock()
+	defer requestLogs.Unlock()
+
+	if _, found := requestLogs.logs[id]; !found {
+		if len(requestLogs.ids) >= maxLoggedRequests {
+			delete(requestLogs.logs, requestLogs.ids[0])
+			requestLogs.ids = requestLogs.ids[1:]
+		}
+		requestLogs.ids = append(requestLogs.ids, id)
+	}
+
+	switch n := len(requestLogs.logs[id]); {
+	case n > maxRequestLogMessages:
+		return
+	case n == maxRequestLogMessages:
+		msg = "Too many messages, the rest are not kept"
+	}
+	requestLogs.logs[id] = append(requestLogs.logs[id], fmt.Sprintf("%v> %s", time.Now().Format("15:04:05.000"), msg))
+}
+
+// requestLog returns the log messages of a request, if it is one of the latest requests of this process
+func requestLog(id string) []string {
+	requestLogs.Lock()
+	defer requestLogs.Unlock()
+
+	return append([]string(nil), requestLogs.logs[id]...)
+}
+
+// Logf logs a message of the request. The messages of the latest requests are kept, and shown in the diagnostics of
+// tests failing on them
+func (r *Request) Logf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	logging.Info("%s: %s", r, msg)
+	recordRequestLog(r.RequestId, msg)
+}
+
+// testExchange is a request made by a tester and its response, shown if the test fails
+type testExchange struct {
+	Method          string        `json:"method"`
+	URL             string        `json:"url"`
+	RequestHeaders  http.Header   `json:"request_headers,omitempty"`
+	RequestBody     string        `json:"request_body,omitempty"`
+	Status          int           `json:"status,omitempty"`
+	ResponseHeaders http.Header   `json:"response_headers,omitempty"`
+	ResponseBody    string        `json:"response_body,omitempty"`
+	Error           string        `json:"error,omitempty"`
+	Duration        time.Duration `json:"duration"`
+	RequestId       string        `json:"request_id,omitempty"`
+	ServerLog       []string      `json:"server_log,omitempty"`
+}
+
+// newTestExchange captures a request before it is sent, since sending it consumes its body
+func newTestExchange(r *http.Request) *testExchange {
+
+	ret := &testExchange{
+		Method:         r.Method,
+		URL:            r.URL.String(),
+		RequestHeaders: maskHeaders(r.Header),
+	}
+
+	if r.GetBody != nil {
+		if body, err := r.GetBody(); err == nil {
+			b, _ := ioutil.ReadAll(body)
+			body.Close()
+			ret.RequestBody = truncateBody(b)
+		}
+	}
+	return ret
+}
+
+// setResponse captures the response of the exchange
+func (e *testExchange) setResponse(resp *http.Response, body []byte, err error, start time.Time) {
+
+	e.Duration = time.Since(start)
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if resp == nil {
+		return
+	}
+
+	e.Status = resp.StatusCode
+	e.ResponseHeaders = maskHeaders(resp.Header)
+	e.ResponseBody = truncateBody(body)
+	e.RequestId = resp.Header.Get(HeaderRequestId)
+}
+
+// maskHeaders returns a copy of headers with their secrets masked
+func maskHeaders(h http.Header) http.Header {
+
+	if len(h) == 0 {
+		return nil
+	}
+
+	ret := make(http.Header, len(h))
+	for k, v := range h {
+		ret[k] = append([]string(nil), v...)
+	}
+	for _, k := range secretHeaders {
+		if ret.Get(k) != "" {
+			ret.Set(k, PIIMask)
+		}
+	}
+	return ret
+}
+
+// truncateBody returns a body as a string, truncated to maxDiagnosticBody bytes
+func truncateBody(b []byte) string {
+	if len(b) > maxDiagnosticBody {
+		return fmt.Sprintf("%s... (%d bytes)", b[:maxDiagnosticBody], len(b))
+	}
+	return string(b)
+}
+
+// recordExchange keeps an exchange of the test, forgetting the oldest ones beyond maxDiagnosticRequests
+func (t *TestContext) recordExchange(e *testExchange) {
+	t.exchangesMtx.Lock()
+	defer t.exchangesMtx.Unlock()
+
+	t.exchanges = append(t.exchanges, e)
+	if len(t.exchanges) > maxDiagnosticRequests {
+		t.exchanges = t.exchanges[1:]
+	}
+}
+
+// diagnostics returns the latest exchanges of a test, with the server logs of their requests. Server logs are only
+// found if the server runs in the process of the test runner, i.e. tests run through the API's test endpoint
+func (t *TestContext) diagnostics() []testExchange {
+	t.exchangesMtx.Lock()
+	defer t.exchangesMtx.Unlock()
+
+	ret := make([]testExchange, 0, len(t.exchanges))
+	for _, e := range t.exchanges {
+		x := *e
+		if x.RequestId != "" {
+			x.ServerLog = requestLog(x.RequestId)
+		}
+		ret = append(ret, x)
+	}
+	return ret
+}
+
+// formatExchanges writes the diagnostics of a failed test in the text format
+func formatExchanges(w io.Writer, exchanges []testExchange) {
+
+	for i, e := range exchanges {
+		fmt.Fprintf(w, "  Request #%d: %s %s (%v)\n", i+1, e.Method, e.URL, e.Duration)
+		writeHeaders(w, e.RequestHeaders)
+		if e.RequestBody != "" {
+			fmt.Fprintf(w, "    %s\n", e.RequestBody)
+		}
+		if e.Error != "" {
+			fmt.Fprintf(w, "  Error: %s\n", e.Error)
+		}
+		if e.Status != 0 {
+			fmt.Fprintf(w, "  Response: %d %s\n", e.Status, http.StatusText(e.Status))
+			writeHeaders(w, e.ResponseHeaders)
+			if e.ResponseBody != "" {
+				fmt.Fprintf(w, "    %s\n", e.ResponseBody)
+			}
+		}
+		if len(e.ServerLog) > 0 {
+			fmt.Fprintf(w, "  Server log of request %s:\n", e.RequestId)
+			for _, msg := range e.ServerLog {
+				fmt.Fprintf(w, "    %s\n", msg)
+			}
+		}
+	}
+}
+
+// writeHeaders writes headers in the text format
+func writeHeaders(w io.Writer, h http.Header) {
+
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		for _, value := range h[k] {
+			fmt.Fprintf(w, "    %s: %s\n", k, value)
+		}
+	}
+}
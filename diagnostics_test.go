@@ -0,0 +1,105 @@
+package vertex
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type failingOrderHandler struct {
+	Item string `schema:"item"`
+}
+
+func (h failingOrderHandler) Handle(w http.ResponseWriter, r *Request) (interface{}, error) {
+	r.Logf("Looking up item %s", h.Item)
+	return nil, NewErrorf("No such item %s", h.Item)
+}
+
+func TestRequestLog(t *testing.T) {
+
+	recordRequestLog("req1", "foo")
+	recordRequestLog("req1", "bar")
+	log := requestLog("req1")
+	assert.Len(t, log, 2)
+	assert.True(t, strings.HasSuffix(log[1], "> bar"))
+
+	// the messages of a request are limited
+	for i := 0; i < 2*maxRequestLogMessages; i++ {
+		recordRequestLog("chatty", "baz")
+	}
+	log = requestLog("chatty")
+	assert.Len(t, log, maxRequestLogMessages+1)
+	assert.True(t, strings.HasSuffix(log[maxRequestLogMessages], "> Too many messages, the rest are not kept"))
+
+	// the oldest requests are forgotten
+	for i := 0; i < maxLoggedRequests; i++ {
+		recordRequestLog(fmt.Sprintf("other%d", i), "baz")
+	}
+	assert.Empty(t, requestLog("req1"))
+	assert.Len(t, requestLog(fmt.Sprintf("other%d", maxLoggedRequests-1)), 1)
+}
+
+func TestFailureDiagnostics(t *testing.T) {
+
+	tester := CriticalTest(func(ctx *TestContext) {
+		req, _ := ctx.NewRequest("POST", url.Values{"item": {"shoe"}}, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		if _, err := ctx.GetJSON(req, &map[string]interface{}{}); err != nil {
+			ctx.Fail("Could not order: %s", err)
+		}
+	})
+
+	a := &API{
+		Name:          "diag",
+		Version:       "1.0",
+		Root:          "/diag",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{Path: "/order", Description: "Order", Methods: POST, Handler: failingOrderHandler{}, Test: tester},
+		},
+	}
+	srv := NewServer(":9972")
+	srv.AddAPI(a)
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	buf := bytes.NewBuffer(nil)
+	assert.False(t, newTestRunner(buf, a, ts.URL, AllTests, TestFormatText).Run())
+	out := buf.String()
+	assert.Contains(t, out, "Request #1: POST "+ts.URL+"/diag/order")
+	assert.Contains(t, out, "item=shoe")
+	assert.Contains(t, out, "Authorization: "+PIIMask)
+	assert.NotContains(t, out, "secret")
+	assert.Contains(t, out, "Response: 500")
+	assert.Contains(t, out, "Looking up item shoe")
+	assert.Contains(t, out, "Request failed: No such item shoe")
+
+	buf.Reset()
+	assert.False(t, newTestRunner(buf, a, ts.URL, AllTests, TestFormatJson).Run())
+	var res testResult
+	assert.NoError(t, json.NewDecoder(buf).Decode(&res))
+	if assert.Len(t, res.Requests, 1) {
+		e := res.Requests[0]
+		assert.Equal(t, "POST", e.Method)
+		assert.Equal(t, "item=shoe", e.RequestBody)
+		assert.Equal(t, http.StatusInternalServerError, e.Status)
+		assert.NotEmpty(t, e.RequestId)
+		assert.Len(t, e.ServerLog, 2)
+	}
+
+	// passing tests have no diagnostics
+	a.Routes[0].Test = CriticalTest(func(ctx *TestContext) {})
+	res = testResult{}
+	buf.Reset()
+	newTestRunner(buf, a, ts.URL, AllTests, TestFormatJson).Run()
+	assert.NoError(t, json.NewDecoder(buf).Decode(&res))
+	assert.Empty(t, res.Requests)
+}
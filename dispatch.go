@@ -0,0 +1,150 @@
+package vertex
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// dispatchCall is the state of a route called in-process, capturing the response of the route
+type dispatchCall struct {
+	principal *Principal
+	value     interface{}
+	err       error
+}
+
+type dispatchKey struct{}
+
+// dispatchCallOf returns the in-process call of a request, or nil if it came from the network
+func dispatchCallOf(r *http.Request) *dispatchCall {
+	call, _ := r.Context().Value(dispatchKey{}).(*dispatchCall)
+	return call
+}
+
+// Dispatch calls a route of the API in-process and returns its response, rather than rendering it. The call runs
+// through the full pipeline of the route - param binding and validation, middleware, and the handler - so composite
+// handlers, batch endpoints and background jobs can reuse route logic without calling the server over http:
+//
+//	user, err := api.Dispatch("GET", "/users/42", nil, r.Principal())
+//
+// path is the path of the route inside the API with its path params filled in. params are the query or form params
+// of the call. If a principal is passed, the call runs as the principal and the security scheme of the route is not
+// validated, since the caller already authenticated it. Otherwise the call is anonymous
+func (a *API) Dispatch(method, path string, params url.Values, principal *Principal) (interface{}, error) {
+	return a.DispatchContext(context.Background(), method, path, params, principal)
+}
+
+// DispatchContext is Dispatch with a context. Calls with the context of a request are memoized for the lifetime of
+// the request: identical GET and HEAD calls, made as the same principal, run once and share their result
+func (a *API) DispatchContext(ctx context.Context, method, path string, params url.Values,
+	principal *Principal) (interface{}, error) {
+
+	if a.router == nil {
+		return nil, NewErrorf("API %s is not served by a server", a.Name)
+	}
+
+	method = strings.ToUpper(method)
+	return memoize(ctx, method, a.Name+" "+dispatchKeyOf(method, path, params, principal), func() (interface{}, error) {
+		return a.dispatch(ctx, method, path, params, principal)
+	})
+}
+
+// dispatch calls a route in-process
+func (a *API) dispatch(ctx context.Context, method, path string, params url.Values,
+	principal *Principal) (ret interface{}, err error) {
+
+	u := a.FullPath(path)
+	body := ""
+	if method == "POST" || method == "PUT" || method == "PATCH" {
+		body = params.Encode()
+	} else if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+
+	call := &dispatchCall{principal: principal}
+	req, e := http.NewRequest(method, u, strings.NewReader(body))
+	if e != nil {
+		return nil, InvalidRequestError("Invalid call to %s %s: %s", method, path, e)
+	}
+	req = req.WithContext(context.WithValue(ctx, dispatchKey{}, call))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.RemoteAddr = "127.0.0.1:0"
+
+	handle, routeParams, _ := a.router.Lookup(method, req.URL.Path)
+	if handle == nil {
+		return nil, InvalidRequestError("No route for %s %s", method, path)
+	}
+
+	// panics are returned to the caller like the server renders them to clients
+	defer func() {
+		if e := recover(); e != nil {
+			ret, err = nil, NewErrorf("Unhandled panic in %s %s: %v", method, path, e)
+		}
+	}()
+
+	// the response of the call is captured, anything the pipeline writes is discarded
+	handle(newPartRecorder(), req, routeParams)
+	return call.value, call.err
+}
+
+// dispatchMemo memoizes the in-process calls made while handling a request, so identical calls - e.g. composite parts
+// needing the same sub-resource - run once. Only GET and HEAD calls are memoized, since other methods change state
+type dispatchMemo struct {
+	mtx   sync.Mutex
+	calls map[string]*memoCall
+}
+
+// memoCall is a memoized call, done when its result is set
+type memoCall struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+type memoKey struct{}
+
+// withDispatchMemo returns a context memoizing in-process calls, unless it already does
+func withDispatchMemo(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(memoKey{}).(*dispatchMemo); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, memoKey{}, &dispatchMemo{calls: map[string]*memoCall{}})
+}
+
+// memoize runs a call once per context and key, sharing its result with identical calls, including concurrent ones.
+// Calls of contexts without a memo, or of methods changing state, always run
+func memoize(ctx context.Context, method, key string, f func() (interface{}, error)) (interface{}, error) {
+
+	memo, ok := ctx.Value(memoKey{}).(*dispatchMemo)
+	if !ok || (method != "GET" && method != "HEAD") {
+		return f()
+	}
+
+	memo.mtx.Lock()
+	if call, found := memo.calls[key]; found {
+		memo.mtx.Unlock()
+		<-call.done
+		return call.value, call.err
+	}
+	call := &memoCall{done: make(chan struct{})}
+	memo.calls[key] = call
+	memo.mtx.Unlock()
+
+	defer close(call.done)
+	call.value, call.err = f()
+	return call.value, call.err
+}
+
+// dispatchKeyOf returns the memo key of a call
+func dispatchKeyOf(method, path string, params url.Values, principal *Principal) string {
+	id := ""
+	if principal != nil {
+		id = principal.ID
+	}
+	return fmt.Sprintf("%s %s?%s as %s", method, path, params.Encode(), id)
+}
@@ -0,0 +1,175 @@
+package vertex
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type dispatchUser struct {
+	Id   string
+	Name string
+}
+
+type dispatchUserHandler struct {
+	Id string `schema:"id" in:"path"`
+}
+
+func (h dispatchUserHandler) Handle(w http.ResponseWriter, r *Request) (interface{}, error) {
+	if h.Id == "crash" {
+		panic("boom")
+	}
+	return &dispatchUser{Id: h.Id, Name: r.PrincipalID()}, nil
+}
+
+type dispatchRenameHandler struct {
+	Name string `schema:"name" required:"true"`
+}
+
+func (h dispatchRenameHandler) Handle(w http.ResponseWriter, r *Request) (interface{}, error) {
+	return h.Name, nil
+}
+
+func TestDispatch(t *testing.T) {
+
+	scheme := SecuritySchemeFunc(func(r *Request) error {
+		return UnauthorizedError("no credentials")
+	})
+
+	a := &API{
+		Name:                  "dispatch",
+		Version:               "1.0",
+		Root:                  "/dispatch",
+		Renderer:              JSONRenderer{},
+		DefaultSecurityScheme: scheme,
+		Routes: Routes{
+			{Path: "/users/{id}", Description: "User", Methods: GET, Handler: dispatchUserHandler{}},
+			{Path: "/rename", Description: "Rename", Methods: POST, Handler: dispatchRenameHandler{}},
+		},
+	}
+
+	_, err := a.Dispatch("GET", "/users/42", nil, nil)
+	assert.Error(t, err)
+
+	srv := NewServer(":9983")
+	srv.AddAPI(a)
+
+	// calls with a principal run as the principal, and return typed results
+	v, err := a.Dispatch("get", "/users/42", nil, &Principal{ID: "alice"})
+	assert.NoError(t, err)
+	assert.Equal(t, &dispatchUser{Id: "42", Name: "alice"}, v)
+
+	// anonymous calls go through the security scheme
+	_, err = a.Dispatch("GET", "/users/42", nil, nil)
+	assert.Equal(t, http.StatusUnauthorized, errorStatus(err))
+
+	v, err = a.Dispatch("POST", "/rename", url.Values{"name": {"bob"}}, &Principal{ID: "alice"})
+	assert.NoError(t, err)
+	assert.Equal(t, "bob", v)
+
+	_, err = a.Dispatch("POST", "/rename", nil, &Principal{ID: "alice"})
+	assert.Equal(t, http.StatusBadRequest, errorStatus(err))
+
+	_, err = a.Dispatch("GET", "/nope", nil, &Principal{ID: "alice"})
+	assert.Equal(t, http.StatusBadRequest, errorStatus(err))
+
+	_, err = a.Dispatch("GET", "/users/crash", nil, &Principal{ID: "alice"})
+	assert.Error(t, err)
+}
+
+type countingHandler struct{}
+
+var countingCalls int32
+
+func (countingHandler) Handle(w http.ResponseWriter, r *Request) (interface{}, error) {
+	atomic.AddInt32(&countingCalls, 1)
+	return "counted", nil
+}
+
+type twiceHandler struct{}
+
+func (twiceHandler) Handle(w http.ResponseWriter, r *Request) (interface{}, error) {
+	for i := 0; i < 2; i++ {
+		if _, err := r.api.DispatchContext(r.Context(), "GET", "/count", nil, r.Principal()); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+func TestDispatchMemoization(t *testing.T) {
+
+	a := &API{
+		Name:          "memo",
+		Version:       "1.0",
+		Root:          "/memo",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{Path: "/count", Description: "Count", Methods: GET, Handler: countingHandler{}},
+			{Path: "/twice", Description: "Twice", Methods: GET, Handler: twiceHandler{}},
+			{Path: "/composite", Description: "Composite", Methods: GET, Handler: Composite(
+				CompositePart{Name: "a", Path: "/count"},
+				CompositePart{Name: "b", Path: "/count"},
+			)},
+		},
+	}
+	srv := NewServer(":9984")
+	srv.AddAPI(a)
+
+	call := func(path string) {
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, httptest.NewRequest("GET", a.FullPath(path), nil))
+		assert.True(t, w.Code < 300, w.Body.String())
+	}
+
+	// identical calls within a request run once
+	atomic.StoreInt32(&countingCalls, 0)
+	call("/twice")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&countingCalls))
+
+	atomic.StoreInt32(&countingCalls, 0)
+	call("/composite")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&countingCalls))
+
+	// but not across requests
+	call("/twice")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&countingCalls))
+
+	// calls outside of requests are not memoized
+	atomic.StoreInt32(&countingCalls, 0)
+	a.Dispatch("GET", "/count", nil, nil)
+	a.Dispatch("GET", "/count", nil, nil)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&countingCalls))
+}
+
+func TestDispatchWithinQuota(t *testing.T) {
+
+	a := &API{
+		Name:           "quota",
+		Version:        "1.0",
+		Root:           "/quota",
+		Renderer:       JSONRenderer{},
+		AllowInsecure:  true,
+		MaxConcurrency: 1,
+		QueueTimeout:   time.Second,
+		Routes: Routes{
+			{Path: "/count", Description: "Count", Methods: GET, Handler: countingHandler{}},
+			{Path: "/twice", Description: "Twice", Methods: GET, Handler: twiceHandler{}},
+		},
+	}
+	srv := NewServer(":9934")
+	srv.AddAPI(a)
+
+	// calls dispatched by a request run in its slot rather than wait for the one it holds
+	start := time.Now()
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest("GET", a.FullPath("/twice"), nil))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.True(t, time.Since(start) < a.QueueTimeout)
+}
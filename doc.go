@@ -174,6 +174,10 @@
 // Security Schemes are used to validate requests. The scheme simply receives the request, and returns an error if it is not valid.
 // It can be used to authenticate the user, validate the API key, etc.
 //
+// Security is validated as a stage of the middleware chain, in front of all the API and route middleware. Use
+// vertex.SecurityStage to place it elsewhere, e.g. after logging and metrics middleware that should see authentication
+// failures.
+//
 // Middleware
 //
 // Vertex comes with some middleware modules included. Currently implemented middleware include:
@@ -0,0 +1,155 @@
+package vertex
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// DrainPath is the admin endpoint taking the instance out of rotation and back, for load balancer rotation scripts
+// during blue/green deploys:
+//
+//	GET    /debug/vertex/drain                        returns the drain status
+//	POST   /debug/vertex/drain[?close_keepalive=true]  starts draining
+//	DELETE /debug/vertex/drain                        stops draining, putting the instance back in rotation
+//
+// All of them answer with the drain status. Scripts poll it until the state is "drained", and then stop or switch
+// the instance
+const DrainPath = "/debug/vertex/drain"
+
+// Drain states
+const (
+	DrainServing  = "serving"
+	DrainDraining = "draining"
+	DrainDrained  = "drained"
+)
+
+// DrainStatus is the drain progress of an instance
+type DrainStatus struct {
+	// DrainServing, DrainDraining while requests, chains of timed out requests or deferred work are still running,
+	// or DrainDrained once none are
+	State string `json:"state"`
+
+	// When draining started, if draining
+	Since *time.Time `json:"since,omitempty"`
+
+	// Requests being handled
+	Inflight int64 `json:"inflight"`
+
+	// Chains still running after their request timed out
+	TimedOut int64 `json:"timed_out"`
+
+	// Deferred work queued or running
+	Deferred int64 `json:"deferred"`
+
+	// Whether the readiness check passes
+	Ready bool `json:"ready"`
+
+	// Whether connections are kept alive between requests
+	KeepAlive bool `json:"keepalive"`
+}
+
+// drainState is the drain state of a server. It is independent of readiness, so an instance can be put back in
+// rotation after a canceled deploy
+type drainState struct {
+	sync.Mutex
+	draining       bool
+	since          time.Time
+	closeKeepAlive bool
+}
+
+// Drain takes the server out of rotation: readiness checks fail so load balancers stop sending it new requests,
+// and requests in flight keep being served. If closeKeepAlive is set, idle connections are closed and responses
+// close their connections, so clients holding keep-alive connections move to other instances too
+func (s *Server) Drain(closeKeepAlive bool) {
+
+	s.drain.Lock()
+	defer s.drain.Unlock()
+
+	if !s.drain.draining {
+		s.drain.draining = true
+		s.drain.since = time.Now()
+		logging.Info("Draining the server")
+	}
+	if closeKeepAlive && !s.drain.closeKeepAlive {
+		s.drain.closeKeepAlive = true
+		s.applyKeepAlive()
+	}
+}
+
+// Undrain puts a drained server back in rotation
+func (s *Server) Undrain() {
+
+	s.drain.Lock()
+	defer s.drain.Unlock()
+
+	if s.drain.draining {
+		logging.Info("Stopped draining the server after %v", time.Since(s.drain.since))
+	}
+	s.drain.draining = false
+	s.drain.since = time.Time{}
+	s.drain.closeKeepAlive = false
+	s.applyKeepAlive()
+}
+
+// isDraining tells if the server was drained
+func (s *Server) isDraining() bool {
+	s.drain.Lock()
+	defer s.drain.Unlock()
+	return s.drain.draining
+}
+
+// applyKeepAlive applies the keep-alive setting of the drain state to the http server, if it is running. It must be
+// called with the drain state locked
+func (s *Server) applyKeepAlive() {
+	if s.srv != nil {
+		s.srv.SetKeepAlivesEnabled(!s.drain.closeKeepAlive)
+	}
+}
+
+// DrainStatus returns the drain progress of the server
+func (s *Server) DrainStatus() DrainStatus {
+
+	ready := s.IsReady() && len(checkResources()) == 0
+
+	s.drain.Lock()
+	defer s.drain.Unlock()
+
+	ret := DrainStatus{
+		State:     DrainServing,
+		Inflight:  inflightCount(),
+		TimedOut:  atomic.LoadInt64(&timedOutChains),
+		Deferred:  deferredWork.pendingCount(),
+		Ready:     ready,
+		KeepAlive: !s.drain.closeKeepAlive,
+	}
+	if s.drain.draining {
+		since := s.drain.since
+		ret.Since = &since
+		ret.State = DrainDraining
+		if ret.Inflight == 0 && ret.TimedOut == 0 && ret.Deferred == 0 {
+			ret.State = DrainDrained
+		}
+	}
+	return ret
+}
+
+// drainHandler serves the drain endpoint, see DrainPath
+func (s *Server) drainHandler(w http.ResponseWriter, r *http.Request) {
+
+	switch r.Method {
+	case "POST":
+		s.Drain(r.FormValue("close_keepalive") == "true")
+	case "DELETE":
+		s.Undrain()
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(s.DrainStatus()); err != nil {
+		logging.Error("Could not write drain status: %s", err)
+	}
+}
@@ -0,0 +1,155 @@
+package vertex
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrain(t *testing.T) {
+
+	started, release := make(chan struct{}), make(chan struct{})
+	a := &API{
+		Name:          "drain",
+		Version:       "1.0",
+		Root:          "/drain",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{Path: "/slow", Description: "Slow", Methods: GET, Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+				close(started)
+				<-release
+				return "ok", nil
+			})},
+		},
+	}
+	srv := NewServer(":9942")
+	srv.AddAPI(a)
+	srv.registerHealthChecks()
+	srv.srv = &http.Server{}
+	srv.setReady(true)
+
+	drain := func(method, query string) DrainStatus {
+		hr, _ := http.NewRequest(method, DrainPath+query, nil)
+		w := httptest.NewRecorder()
+		srv.drainHandler(w, hr)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var status DrainStatus
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+		return status
+	}
+	ready := func() int {
+		hr, _ := http.NewRequest("GET", ReadinessPath, nil)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, hr)
+		return w.Code
+	}
+
+	status := drain("GET", "")
+	assert.Equal(t, DrainServing, status.State)
+	assert.True(t, status.Ready)
+	assert.True(t, status.KeepAlive)
+	assert.Nil(t, status.Since)
+
+	// requests in flight keep being served while draining
+	done := make(chan int)
+	go func() {
+		hr, _ := http.NewRequest("GET", a.FullPath("/slow"), nil)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, hr)
+		done <- w.Code
+	}()
+	<-started
+
+	status = drain("POST", "?close_keepalive=true")
+	assert.Equal(t, DrainDraining, status.State)
+	assert.Equal(t, int64(1), status.Inflight)
+	assert.False(t, status.Ready)
+	assert.False(t, status.KeepAlive)
+	assert.NotNil(t, status.Since)
+	assert.Equal(t, http.StatusServiceUnavailable, ready())
+
+	close(release)
+	assert.Equal(t, http.StatusOK, <-done)
+	status = drain("GET", "")
+	assert.Equal(t, DrainDrained, status.State)
+	assert.Equal(t, int64(0), status.Inflight)
+
+	// draining again keeps the drain start
+	since := *status.Since
+	time.Sleep(time.Millisecond)
+	assert.Equal(t, since, *drain("POST", "").Since)
+
+	// a canceled deploy puts the instance back in rotation
+	status = drain("DELETE", "")
+	assert.Equal(t, DrainServing, status.State)
+	assert.True(t, status.Ready)
+	assert.True(t, status.KeepAlive)
+	assert.Equal(t, http.StatusOK, ready())
+}
+
+func TestDrainBackgroundWork(t *testing.T) {
+
+	started, release := make(chan struct{}), make(chan struct{})
+	deferred, finish := make(chan struct{}), make(chan struct{})
+	a := &API{
+		Name:          "drainbg",
+		Version:       "1.0",
+		Root:          "/drainbg",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{Path: "/slow", Description: "Slow", Methods: GET, Timeout: 10 * time.Millisecond,
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					close(started)
+					<-release
+					r.Defer(func(ctx context.Context) {
+						close(deferred)
+						<-finish
+					})
+					return "ok", nil
+				})},
+		},
+	}
+	srv := NewServer(":9943")
+	srv.AddAPI(a)
+	srv.Drain(false)
+	defer srv.Undrain()
+
+	hr, _ := http.NewRequest("GET", a.FullPath("/slow"), nil)
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, hr)
+	<-started
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+
+	// the chain of the timed out request is still running
+	status := srv.DrainStatus()
+	assert.Equal(t, DrainDraining, status.State)
+	assert.Equal(t, int64(0), status.Inflight)
+	assert.Equal(t, int64(1), status.TimedOut)
+
+	// and then the work it deferred, which is counted before the chain stops counting
+	close(release)
+	<-deferred
+	for status.TimedOut > 0 {
+		status = srv.DrainStatus()
+		assert.Equal(t, DrainDraining, status.State)
+		assert.Equal(t, int64(1), status.Deferred)
+		time.Sleep(time.Millisecond)
+	}
+	status = srv.DrainStatus()
+	assert.Equal(t, DrainDraining, status.State)
+	assert.Equal(t, int64(1), status.Deferred)
+
+	close(finish)
+	deferredWork.flush(time.Second)
+	status = srv.DrainStatus()
+	assert.Equal(t, DrainDrained, status.State)
+	assert.Equal(t, int64(0), status.Deferred)
+}
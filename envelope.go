@@ -0,0 +1,100 @@
+package vertex
+
+import (
+	"time"
+)
+
+// EnvelopeBuilder wraps the responses the JSON renderer renders for an API, e.g. so all responses carry a status
+// and request id for clients to read without looking at headers. APIs without an envelope builder render responses
+// as they are, and errors as plain text
+type EnvelopeBuilder interface {
+	// Success returns the value rendered for the response of a successful request
+	Success(r *Request, v interface{}) interface{}
+
+	// Failure returns the value rendered for a failed request, given the http status and message the error is
+	// rendered with. The http status of the response is still the error's
+	Failure(r *Request, err error, status int, message string) interface{}
+}
+
+// StandardEnvelope is a configurable envelope with the status of the request and the response in fields of an
+// object:
+//
+//	{"errorCode": 0, "errorString": "", "response": {...}}
+//
+// Field names default to the above. The request id and processing time are added if their field names are set:
+//
+//	Envelope: vertex.StandardEnvelope{BodyField: "data", RequestIdField: "requestId", TimingField: "ms"},
+type StandardEnvelope struct {
+	// The field of the error code, 0 for successful requests. Defaults to errorCode
+	CodeField string
+
+	// The field of the error message, empty for successful requests. Defaults to errorString
+	MessageField string
+
+	// The field of the response. Defaults to response
+	BodyField string
+
+	// If set, the field of the request id
+	RequestIdField string
+
+	// If set, the field of the processing time in milliseconds
+	TimingField string
+
+	// The field of the param violations of validation errors, only set for them. Defaults to violations
+	ViolationsField string
+
+	// The field of the public code of coded and mapped errors, only set for them. Defaults to publicCode. The code
+	// is also sent in the X-Vertex-ErrorCode header
+	PublicCodeField string
+
+	// The field of the seconds to wait before retrying, only set for errors carrying a retry hint. Defaults to
+	// retryAfter
+	RetryAfterField string
+}
+
+func (e StandardEnvelope) Success(r *Request, v interface{}) interface{} {
+	return e.build(r, Ok, "", v)
+}
+
+func (e StandardEnvelope) Failure(r *Request, err error, status int, message string) interface{} {
+
+	// mapped errors are rendered like the errors they are mapped to, and carry the same public code as the headers
+	code, publicCode := ErrGeneralFailure, ""
+	if ie, ok := resolveError(err).(*internalError); ok {
+		code, publicCode = ie.Code, ie.PublicCode
+	}
+	ret := e.build(r, code, message, nil)
+	if publicCode != "" {
+		ret[orDefault(e.PublicCodeField, "publicCode")] = publicCode
+	}
+	if violations := Violations(err); len(violations) > 0 {
+		ret[orDefault(e.ViolationsField, "violations")] = violations
+	}
+	if retry := RetryAfter(err); retry > 0 {
+		ret[orDefault(e.RetryAfterField, "retryAfter")] = retryAfterSeconds(retry)
+	}
+	return ret
+}
+
+func (e StandardEnvelope) build(r *Request, code int, message string, v interface{}) map[string]interface{} {
+
+	ret := map[string]interface{}{
+		orDefault(e.CodeField, "errorCode"):      code,
+		orDefault(e.MessageField, "errorString"): message,
+		orDefault(e.BodyField, "response"):       v,
+	}
+	if e.RequestIdField != "" {
+		ret[e.RequestIdField] = r.RequestId
+	}
+	if e.TimingField != "" {
+		ret[e.TimingField] = float64(time.Since(r.StartTime).Nanoseconds()/1000) / 1000
+	}
+	return ret
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
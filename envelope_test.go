@@ -0,0 +1,113 @@
+package vertex
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type rawEnvelope struct{}
+
+func (rawEnvelope) Success(r *Request, v interface{}) interface{} {
+	return v
+}
+
+func (rawEnvelope) Failure(r *Request, err error, status int, message string) interface{} {
+	return map[string]interface{}{"error": message, "status": status}
+}
+
+func TestEnvelopes(t *testing.T) {
+
+	conflict := RegisterErrorCode("envelope_conflict", http.StatusConflict, "The item was modified")
+	errModified := errors.New("modified concurrently")
+	RegisterError(errModified, ErrorMapping{Code: conflict.Code, Expose: true})
+
+	routes := Routes{
+		{Path: "/ok", Description: "Ok", Methods: GET, Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+			return map[string]int{"a": 1}, nil
+		})},
+		{Path: "/fail", Description: "Fail", Methods: GET, Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+			return nil, InvalidParamError("bad a")
+		})},
+		{Path: "/busy", Description: "Busy", Methods: GET, Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+			return nil, TooManyRequestsError(1500*time.Millisecond, "slow down")
+		})},
+		{Path: "/mapped", Description: "Mapped", Methods: GET, Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+			return nil, fmt.Errorf("saving: %w", errModified)
+		})},
+	}
+
+	standard := &API{
+		Name: "enveloped", Version: "1.0", Root: "/enveloped", Renderer: JSONRenderer{}, AllowInsecure: true,
+		Routes:   routes,
+		Envelope: StandardEnvelope{BodyField: "data", RequestIdField: "requestId", TimingField: "ms"},
+	}
+	custom := &API{
+		Name: "custom", Version: "1.0", Root: "/custom", Renderer: JSONRenderer{}, AllowInsecure: true,
+		Routes:   append(Routes{}, routes...),
+		Envelope: rawEnvelope{},
+	}
+	plain := &API{
+		Name: "plain", Version: "1.0", Root: "/plain", Renderer: JSONRenderer{}, AllowInsecure: true,
+		Routes: append(Routes{}, routes...),
+	}
+	srv := NewServer(":9991")
+	srv.AddAPI(standard)
+	srv.AddAPI(custom)
+	srv.AddAPI(plain)
+
+	get := func(path string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		hr, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, hr)
+		var body map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w, body
+	}
+
+	w, body := get(standard.FullPath("/ok"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(0), body["errorCode"])
+	assert.Equal(t, "", body["errorString"])
+	assert.Equal(t, map[string]interface{}{"a": float64(1)}, body["data"])
+	assert.Equal(t, w.Header().Get(HeaderRequestId), body["requestId"])
+	assert.NotNil(t, body["ms"])
+
+	w, body = get(standard.FullPath("/fail"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, float64(ErrInvalidParam), body["errorCode"])
+	assert.Equal(t, "bad a", body["errorString"])
+	assert.Nil(t, body["data"])
+	assert.Nil(t, body["retryAfter"])
+
+	w, body = get(standard.FullPath("/busy"))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, float64(ErrTooManyRequests), body["errorCode"])
+	assert.Equal(t, float64(2), body["retryAfter"])
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+
+	// mapped errors carry the public code of their mapping in the body, like in the headers
+	w, body = get(standard.FullPath("/mapped"))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "envelope_conflict", w.Header().Get(HeaderErrorCode))
+	assert.Equal(t, "envelope_conflict", body["publicCode"])
+	assert.Equal(t, "envelope_conflict: saving: modified concurrently", body["errorString"])
+	_, body = get(standard.FullPath("/fail"))
+	assert.Nil(t, body["publicCode"])
+
+	w, body = get(custom.FullPath("/ok"))
+	assert.Equal(t, map[string]interface{}{"a": float64(1)}, body)
+	w, body = get(custom.FullPath("/fail"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "bad a", body["error"])
+
+	w, _ = get(plain.FullPath("/fail"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "bad a\n", w.Body.String())
+}
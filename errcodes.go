@@ -0,0 +1,142 @@
+package vertex
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/EverythingMe/vertex/swagger"
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// HeaderErrorCode is the response header carrying the public error code of failed requests
+const HeaderErrorCode = "X-Vertex-ErrorCode"
+
+// ErrorCode is a stable, machine readable public error code (e.g. USER_NOT_FOUND) that clients can rely on,
+// instead of matching error strings.
+//
+// Error codes are registered once, usually as package level vars:
+//
+//	var ErrUserNotFound = vertex.RegisterErrorCode("USER_NOT_FOUND", http.StatusNotFound, "The user does not exist")
+//
+// And then returned by handlers:
+//
+//	return nil, vertex.NewCodedError(ErrUserNotFound, "No user with id %s", h.Id)
+type ErrorCode struct {
+	// The public code string
+	Code string
+
+	// The http status the error is rendered with
+	Status int
+
+	// Documentation of the error code
+	Description string
+}
+
+var errorCodes = struct {
+	sync.RWMutex
+	codes      map[string]ErrorCode
+	duplicates []string
+}{
+	codes: map[string]ErrorCode{},
+}
+
+// RegisterErrorCode registers a new public error code. Codes must be unique - registering the same code twice
+// makes the server fail on startup
+func RegisterErrorCode(code string, status int, description string) ErrorCode {
+
+	ec := ErrorCode{
+		Code:        code,
+		Status:      status,
+		Description: description,
+	}
+
+	errorCodes.Lock()
+	defer errorCodes.Unlock()
+
+	if _, found := errorCodes.codes[code]; found {
+		logging.Error("Error code %s registered more than once", code)
+		errorCodes.duplicates = append(errorCodes.duplicates, code)
+	}
+	errorCodes.codes[code] = ec
+
+	return ec
+}
+
+// lookupErrorCode returns the registration of a public error code
+func lookupErrorCode(code string) (ErrorCode, bool) {
+	errorCodes.RLock()
+	defer errorCodes.RUnlock()
+
+	ec, found := errorCodes.codes[code]
+	return ec, found
+}
+
+// checkErrorCodes makes sure no error code was registered more than once
+func checkErrorCodes() error {
+	errorCodes.RLock()
+	defer errorCodes.RUnlock()
+
+	if len(errorCodes.duplicates) > 0 {
+		return fmt.Errorf("Duplicate error codes registered: %s", strings.Join(errorCodes.duplicates, ", "))
+	}
+	return nil
+}
+
+// errorCodesSwagger enumerates the registered error codes for the API documentation
+func errorCodesSwagger() map[string]swagger.ErrorCode {
+	errorCodes.RLock()
+	defer errorCodes.RUnlock()
+
+	ret := make(map[string]swagger.ErrorCode, len(errorCodes.codes))
+	for k, ec := range errorCodes.codes {
+		ret[k] = swagger.ErrorCode{Status: ec.Status, Description: ec.Description}
+	}
+	return ret
+}
+
+// ErrorCodes returns all the registered public error codes, sorted by code
+func ErrorCodes() []ErrorCode {
+	errorCodes.RLock()
+	defer errorCodes.RUnlock()
+
+	ret := make([]ErrorCode, 0, len(errorCodes.codes))
+	for _, ec := range errorCodes.codes {
+		ret = append(ret, ec)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Code < ret[j].Code })
+	return ret
+}
+
+// NewCodedError returns an error carrying a registered public error code. The formatted message is returned
+// to the client alongside the code, so it should not contain sensitive data
+func NewCodedError(code ErrorCode, format string, args ...interface{}) error {
+
+	if _, found := lookupErrorCode(code.Code); !found {
+		logging.Warning("Returning unregistered error code %s", code.Code)
+	}
+
+	return &internalError{
+		Message:    fmt.Sprintf(format, args...),
+		Code:       ErrGeneralFailure,
+		PublicCode: code.Code,
+	}
+}
+
+// PublicErrorCode returns the public error code carried by an error, or an empty string if it has none
+func PublicErrorCode(err error) string {
+	if e, ok := resolveError(err).(*internalError); ok {
+		return e.PublicCode
+	}
+	return ""
+}
+
+// codedErrorStatus returns the http status of a coded error. Unregistered codes are treated as server errors
+func codedErrorStatus(code string) int {
+	if ec, found := lookupErrorCode(code); found && ec.Status != 0 {
+		return ec.Status
+	}
+	return http.StatusInternalServerError
+}
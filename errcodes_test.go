@@ -0,0 +1,41 @@
+package vertex
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodedErrors(t *testing.T) {
+
+	code := RegisterErrorCode("TEST_NOT_FOUND", http.StatusNotFound, "Testing error code")
+
+	err := NewCodedError(code, "no such %s", "thing")
+	assert.Equal(t, "TEST_NOT_FOUND", PublicErrorCode(err))
+	assert.Equal(t, "", PublicErrorCode(NewErrorf("wat")))
+
+	status, msg := httpError(err)
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, "TEST_NOT_FOUND: no such thing", msg)
+
+	// unregistered codes are server errors
+	assert.Equal(t, http.StatusInternalServerError, errorStatus(NewCodedError(ErrorCode{Code: "TEST_UNREGISTERED"}, "wat")))
+
+	w := httptest.NewRecorder()
+	hr, _ := http.NewRequest("GET", "/foo", nil)
+	assert.NoError(t, JSONRenderer{}.Render(nil, err, w, NewRequest(hr)))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "TEST_NOT_FOUND", w.Header().Get(HeaderErrorCode))
+
+	assert.NoError(t, checkErrorCodes())
+	assert.Contains(t, errorCodesSwagger(), "TEST_NOT_FOUND")
+
+	RegisterErrorCode("TEST_NOT_FOUND", http.StatusNotFound, "Duplicate")
+	assert.Error(t, checkErrorCodes())
+
+	errorCodes.Lock()
+	errorCodes.duplicates = nil
+	errorCodes.Unlock()
+}
@@ -1,6 +1,8 @@
 package vertex
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -12,6 +14,31 @@ import (
 type internalError struct {
 	Message string
 	Code    int
+
+	// Optional hint for clients on when to retry the request. Rendered as a Retry-After header
+	RetryAfter time.Duration
+
+	// Optional registered public error code. See NewCodedError
+	PublicCode string
+
+	// Optional authentication challenge for missing credentials. Rendered as a WWW-Authenticate header
+	Challenge string
+
+	// Optional upgrade info of upgrade required errors. Rendered as the HeaderMinClientVersion and HeaderUpgradeURL
+	// headers
+	Upgrade *UpgradeInfo
+
+	// Optional http status overriding the status of the code, for errors mapped by the error registry
+	Status int
+
+	// Return the message to the client
+	Expose bool
+
+	// The param violations of validation errors, rendered in the body of the error
+	Violations []Violation
+
+	// Optional incident id the error was already logged under, e.g. with the stack of a panic
+	Incident string
 }
 
 const (
@@ -45,54 +72,145 @@ const (
 	// Some middleware took over the request, and the renderer should not render the response
 	ErrHijacked
 
+	// The client is sending too many requests, and should retry later
+	ErrTooManyRequests
+
+	// We cannot serve the request right now, but the client may retry later
+	ErrTemporarilyUnavailable
+
+	// The entity version the client sent does not match the current version
+	ErrPreconditionFailed
+
+	// The route requires the client to send the entity version it is modifying
+	ErrPreconditionRequired
+
+	// The request carries no credentials. The client should authenticate and retry
+	ErrMissingCredentials
+
+	// The request carries credentials that are wrong, expired or revoked
+	ErrInvalidCredentials
+
+	// The client is authenticated, but not allowed to perform the request
+	ErrForbidden
+
+	// The client app version is no longer supported, and must be upgraded
+	ErrUpgradeRequired
+
+	// The rendered response is over the maximum response size of its route
+	ErrResponseTooLarge
+
+	// The request did not finish before its deadline
+	ErrTimeout
+
+	// The client closed the connection before the request finished
+	ErrCanceled
+
 	insecureAccessMessage = "Insecure http Access not allowed"
 )
 
+// StatusClientClosedRequest is the non standard status requests canceled by their client are counted and logged with
+const StatusClientClosedRequest = 499
+
+// errorStatus maps an error to the http status code it should be rendered with
+func errorStatus(err error) int {
+
+	if err == nil {
+		return http.StatusOK
+	}
+
+	e, ok := resolveError(err).(*internalError)
+	if !ok {
+		return http.StatusInternalServerError
+	}
+
+	if e.Status != 0 {
+		return e.Status
+	}
+	if e.PublicCode != "" {
+		return codedErrorStatus(e.PublicCode)
+	}
+
+	switch e.Code {
+	case Ok, ErrHijacked:
+		return http.StatusOK
+	case ErrInvalidRequest, ErrInvalidParam, ErrMissingParam:
+		return http.StatusBadRequest
+	case ErrUnauthorized, ErrMissingCredentials, ErrInvalidCredentials:
+		return http.StatusUnauthorized
+	case ErrInsecureAccessDenied, ErrForbidden:
+		return http.StatusForbidden
+	case ErrTooManyRequests:
+		return http.StatusTooManyRequests
+	case ErrPreconditionFailed:
+		return http.StatusPreconditionFailed
+	case ErrPreconditionRequired:
+		return http.StatusPreconditionRequired
+	case ErrUpgradeRequired:
+		return http.StatusUpgradeRequired
+	case ErrResourceUnavailable, ErrBackOff, ErrTemporarilyUnavailable:
+		return http.StatusServiceUnavailable
+	case ErrTimeout:
+		return http.StatusGatewayTimeout
+	case ErrCanceled:
+		return StatusClientClosedRequest
+	case ErrGeneralFailure:
+		fallthrough
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 // ErrorString converts an error code to a user "friendly" string
 func httpError(err error) (re int, rm string) {
+	return requestError(nil, err)
+}
+
+// requestError converts the error of a request to its http status and message, logging it with the request id
+func requestError(r *Request, err error) (re int, rm string) {
 
 	if err == nil {
 		return http.StatusOK, http.StatusText(http.StatusOK)
 	}
 
 	incidentId := uuid.New()
+	if e, ok := err.(*internalError); ok && e.Incident != "" {
+		incidentId = e.Incident
+	}
 	if err != Hijacked {
-		logging.Error("[%s] Error processing request: %s", incidentId, err)
+		if r != nil {
+			logging.Error("[%s] Error processing request %s: %s", incidentId, r.RequestId, err)
+		} else {
+			logging.Error("[%s] Error processing request: %s", incidentId, err)
+		}
 	}
 
-	statusFunc := func(i int) (int, string) {
-		return i, fmt.Sprintf("[%s] %s", incidentId, http.StatusText(i))
-	}
+	err = resolveError(err)
+	status := errorStatus(err)
 
-	if e, ok := err.(*internalError); !ok {
-		return statusFunc(http.StatusInternalServerError)
-	} else {
+	if e, ok := err.(*internalError); ok {
+
+		// coded errors are public, we return their message to the client
+		if e.PublicCode != "" {
+			return status, fmt.Sprintf("%s: %s", e.PublicCode, e.Message)
+		}
+		if e.Expose {
+			return status, e.Message
+		}
 
 		switch e.Code {
 		case Ok:
-			return http.StatusOK, "OK"
+			return status, "OK"
 		case ErrHijacked:
-			return http.StatusOK, "Request Hijacked By Handler"
-		case ErrInvalidRequest:
-			return statusFunc(http.StatusBadRequest)
-		case ErrInvalidParam, ErrMissingParam:
-			return http.StatusBadRequest, e.Message
-		case ErrUnauthorized:
-			return statusFunc(http.StatusUnauthorized)
-		case ErrInsecureAccessDenied:
-			return statusFunc(http.StatusForbidden)
-		case ErrResourceUnavailable:
-			return statusFunc(http.StatusServiceUnavailable)
-		case ErrBackOff:
-			return statusFunc(http.StatusServiceUnavailable)
-		case ErrGeneralFailure:
-			fallthrough
-		default:
-			return statusFunc(http.StatusInternalServerError)
-
+			return status, "Request Hijacked By Handler"
+		case ErrCanceled:
+			return status, "Client Closed Request"
+		case ErrInvalidParam, ErrMissingParam, ErrPreconditionFailed, ErrPreconditionRequired, ErrUpgradeRequired:
+			return status, e.Message
 		}
 	}
 
+	return status, fmt.Sprintf("[%s] %s", incidentId, http.StatusText(status))
+
 }
 
 // A special error that should be returned when hijacking a request, taking over response rendering from the renderer
@@ -181,6 +299,36 @@ func UnauthorizedError(msg string, args ...interface{}) error {
 	return newErrorfCode(ErrUnauthorized, msg, args...)
 }
 
+// MissingCredentialsError returns an error signifying the request carries no credentials. If the security scheme
+// rejecting the request is a Challenger, its challenge is sent to the client in a WWW-Authenticate header
+func MissingCredentialsError(msg string, args ...interface{}) error {
+	return newErrorfCode(ErrMissingCredentials, msg, args...)
+}
+
+// InvalidCredentialsError returns an error signifying the request carries wrong, expired or revoked credentials
+func InvalidCredentialsError(msg string, args ...interface{}) error {
+	return newErrorfCode(ErrInvalidCredentials, msg, args...)
+}
+
+// ForbiddenError returns an error signifying the client is authenticated, but not allowed to perform the request
+func ForbiddenError(msg string, args ...interface{}) error {
+	return newErrorfCode(ErrForbidden, msg, args...)
+}
+
+// WithChallenge attaches an authentication challenge to an error, rendered as a WWW-Authenticate header,
+// e.g. for middleware authenticating requests outside a security scheme. The error itself is not changed, so shared
+// errors can be passed
+func WithChallenge(err error, challenge string) error {
+	var e internalError
+	if ie, ok := err.(*internalError); ok {
+		e = *ie
+	} else {
+		e = internalError{Message: err.Error(), Code: ErrMissingCredentials}
+	}
+	e.Challenge = challenge
+	return &e
+}
+
 // InsecureAccessDenied returns an error signifying the client has no access to the requested resource
 func InsecureAccessDenied(msg string, args ...interface{}) error {
 	return newErrorfCode(ErrInsecureAccessDenied, msg, args...)
@@ -194,6 +342,97 @@ func ResourceUnavailableError(msg string, args ...interface{}) error {
 // BackOff returns a back-off error with a message formatted for the given amount of backoff time
 func BackOffError(duration time.Duration) error {
 
-	return newErrorfCode(ErrBackOff, fmt.Sprintf("Retry-Seconds: %.02f", duration.Seconds()))
+	return &internalError{
+		Message:    fmt.Sprintf("Retry-Seconds: %.02f", duration.Seconds()),
+		Code:       ErrBackOff,
+		RetryAfter: duration,
+	}
+
+}
 
+// TooManyRequestsError returns an error signifying the client is sending too many requests, and should retry
+// after the given delay
+func TooManyRequestsError(retryAfter time.Duration, msg string, args ...interface{}) error {
+	return &internalError{
+		Message:    fmt.Sprintf(msg, args...),
+		Code:       ErrTooManyRequests,
+		RetryAfter: retryAfter,
+	}
+}
+
+// TemporarilyUnavailableError returns an error signifying we cannot serve the request right now, but the client
+// may retry after the given delay
+func TemporarilyUnavailableError(retryAfter time.Duration, msg string, args ...interface{}) error {
+	return &internalError{
+		Message:    fmt.Sprintf(msg, args...),
+		Code:       ErrTemporarilyUnavailable,
+		RetryAfter: retryAfter,
+	}
+}
+
+// PreconditionFailedError returns an error signifying the entity version the client sent is not the current one.
+//
+// NOTE: The message will be returned to the client directly
+func PreconditionFailedError(msg string, args ...interface{}) error {
+	return newErrorfCode(ErrPreconditionFailed, msg, args...)
+}
+
+// PreconditionRequiredError returns an error signifying the client must send the entity version it is modifying.
+//
+// NOTE: The message will be returned to the client directly
+func PreconditionRequiredError(msg string, args ...interface{}) error {
+	return newErrorfCode(ErrPreconditionRequired, msg, args...)
+}
+
+// UpgradeRequiredError returns an error signifying the client app version is no longer supported, and the user
+// must upgrade the app.
+//
+// NOTE: The message will be returned to the client directly
+func UpgradeRequiredError(msg string, args ...interface{}) error {
+	return newErrorfCode(ErrUpgradeRequired, msg, args...)
+}
+
+// TimeoutError returns an error signifying the request did not finish before its deadline
+func TimeoutError(msg string, args ...interface{}) error {
+	return newErrorfCode(ErrTimeout, msg, args...)
+}
+
+// contextError converts the errors of canceled and expired contexts, and errors wrapping them, to the errors of
+// canceled and timed out requests. Other errors are returned as they are
+func contextError(err error) error {
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return newErrorCode(ErrTimeout, err.Error())
+	case errors.Is(err, context.Canceled):
+		return newErrorCode(ErrCanceled, err.Error())
+	}
+	return err
+}
+
+// ResponseTooLargeError returns an error signifying the rendered response is over the maximum response size of its
+// route. It is a server error, since the handler should have limited the response
+func ResponseTooLargeError(msg string, args ...interface{}) error {
+	return newErrorfCode(ErrResponseTooLarge, msg, args...)
+}
+
+// WithUpgradeInfo attaches the minimum client version and upgrade URL to an upgrade required error. The error itself
+// is not changed, so shared errors can be passed
+func WithUpgradeInfo(err error, info UpgradeInfo) error {
+	var e internalError
+	if ie, ok := err.(*internalError); ok {
+		e = *ie
+	} else {
+		e = internalError{Message: err.Error(), Code: ErrUpgradeRequired}
+	}
+	e.Upgrade = &info
+	return &e
+}
+
+// RetryAfter returns the retry hint carried by an error, or 0 if it has none
+func RetryAfter(err error) time.Duration {
+	if e, ok := err.(*internalError); ok {
+		return e.RetryAfter
+	}
+	return 0
 }
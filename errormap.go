@@ -0,0 +1,114 @@
+package vertex
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"sync"
+)
+
+// ErrorMapping tells how application errors are rendered
+type ErrorMapping struct {
+	// The http status of the error. Defaults to 500
+	Status int
+
+	// Message returned to clients instead of the error's own message
+	Message string
+
+	// Return the error's own message to clients. Errors exposing neither message are rendered with an incident id
+	// and the status text, like any other error
+	Expose bool
+
+	// Optional public error code of the error. See RegisterErrorCode. Coded errors exposing neither message are
+	// rendered with the code and the status text
+	Code string
+}
+
+// errorMappings are the registered mappings of errors and error types
+var errorMappings = struct {
+	sync.RWMutex
+	errors []mappedError
+	types  map[reflect.Type]ErrorMapping
+}{
+	types: map[reflect.Type]ErrorMapping{},
+}
+
+type mappedError struct {
+	target  error
+	mapping ErrorMapping
+}
+
+// RegisterError maps an application error value, and errors wrapping it, to how it is rendered by all renderers, e.g.
+// for sentinel errors of a data layer:
+//
+//	vertex.RegisterError(sql.ErrNoRows, vertex.ErrorMapping{Status: http.StatusNotFound, Message: "Not found"})
+func RegisterError(target error, m ErrorMapping) {
+	errorMappings.Lock()
+	defer errorMappings.Unlock()
+	errorMappings.errors = append(errorMappings.errors, mappedError{target, m})
+}
+
+// RegisterErrorType maps an application error type, and errors wrapping it, to how it is rendered by all renderers.
+// The type is given by an example value:
+//
+//	vertex.RegisterErrorType(&ValidationError{}, vertex.ErrorMapping{Status: http.StatusUnprocessableEntity, Expose: true})
+func RegisterErrorType(example error, m ErrorMapping) {
+	errorMappings.Lock()
+	defer errorMappings.Unlock()
+	errorMappings.types[reflect.TypeOf(example)] = m
+}
+
+// lookupErrorMapping finds the mapping of an error, by the error values first and then by the error types
+func lookupErrorMapping(err error) (ErrorMapping, bool) {
+	errorMappings.RLock()
+	defer errorMappings.RUnlock()
+
+	for _, mapped := range errorMappings.errors {
+		if errors.Is(err, mapped.target) {
+			return mapped.mapping, true
+		}
+	}
+
+	if len(errorMappings.types) > 0 {
+		for e := err; e != nil; e = errors.Unwrap(e) {
+			if m, found := errorMappings.types[reflect.TypeOf(e)]; found {
+				return m, true
+			}
+		}
+	}
+	return ErrorMapping{}, false
+}
+
+// resolveError converts a registered application error to an internal error rendered by its mapping, and errors of
+// canceled or expired request contexts to the errors of canceled and timed out requests. Other errors are returned
+// as they are
+func resolveError(err error) error {
+
+	if _, ok := err.(*internalError); ok || err == nil {
+		return err
+	}
+
+	m, found := lookupErrorMapping(err)
+	if !found {
+		return contextError(err)
+	}
+
+	ret := &internalError{
+		Message:    err.Error(),
+		Code:       ErrGeneralFailure,
+		Status:     m.Status,
+		Expose:     m.Expose,
+		PublicCode: m.Code,
+	}
+	if m.Message != "" {
+		ret.Message, ret.Expose = m.Message, true
+	} else if m.Code != "" && !m.Expose {
+		// coded errors are rendered with their message, which is not the error's own unless exposed
+		status := m.Status
+		if status == 0 {
+			status = codedErrorStatus(m.Code)
+		}
+		ret.Message = http.StatusText(status)
+	}
+	return ret
+}
@@ -0,0 +1,113 @@
+package vertex
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errMappedNotFound = errors.New("no rows in result set")
+
+type mappedConflictError struct {
+	Id string
+}
+
+func (e *mappedConflictError) Error() string {
+	return fmt.Sprintf("item %s was modified concurrently", e.Id)
+}
+
+func TestErrorMappings(t *testing.T) {
+
+	RegisterError(errMappedNotFound, ErrorMapping{Status: http.StatusNotFound, Message: "Not found"})
+	RegisterErrorType(&mappedConflictError{}, ErrorMapping{Status: http.StatusConflict, Expose: true})
+
+	// values and wrapped values
+	st, msg := httpError(errMappedNotFound)
+	assert.Equal(t, http.StatusNotFound, st)
+	assert.Equal(t, "Not found", msg)
+
+	st, msg = httpError(fmt.Errorf("loading user: %w", errMappedNotFound))
+	assert.Equal(t, http.StatusNotFound, st)
+	assert.Equal(t, "Not found", msg)
+
+	// types expose their own message
+	st, msg = httpError(fmt.Errorf("saving: %w", &mappedConflictError{Id: "12"}))
+	assert.Equal(t, http.StatusConflict, st)
+	assert.Equal(t, "saving: item 12 was modified concurrently", msg)
+
+	// unmapped errors are not exposed
+	st, msg = httpError(errors.New("secret"))
+	assert.Equal(t, http.StatusInternalServerError, st)
+	assert.NotContains(t, msg, "secret")
+
+	// internal errors are not remapped
+	assert.Equal(t, http.StatusBadRequest, errorStatus(InvalidParamError("bad")))
+}
+
+func TestErrorMappingCodes(t *testing.T) {
+
+	code := RegisterErrorCode("mapped_gone", http.StatusGone, "The item was deleted")
+	errGone := errors.New("item deleted")
+	RegisterError(errGone, ErrorMapping{Code: code.Code, Expose: true})
+
+	assert.Equal(t, code.Code, PublicErrorCode(fmt.Errorf("get: %w", errGone)))
+	st, msg := httpError(errGone)
+	assert.Equal(t, http.StatusGone, st)
+	assert.Equal(t, "mapped_gone: item deleted", msg)
+
+	// errors that are not exposed are rendered with the status text
+	errPurged := errors.New("purged by job 42")
+	RegisterError(errPurged, ErrorMapping{Code: code.Code})
+	st, msg = httpError(errPurged)
+	assert.Equal(t, http.StatusGone, st)
+	assert.Equal(t, "mapped_gone: Gone", msg)
+}
+
+func TestErrorMappingRenderers(t *testing.T) {
+
+	errMissing := errors.New("missing")
+	RegisterError(errMissing, ErrorMapping{Status: http.StatusNotFound, Message: "No such item"})
+
+	h := HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+		return nil, fmt.Errorf("fetching: %w", errMissing)
+	})
+	a := &API{
+		Name:          "errormap",
+		Version:       "1.0",
+		Root:          "/errormap",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{Path: "/json", Description: "JSON", Methods: GET, Handler: h},
+			{Path: "/csv", Description: "CSV", Methods: GET, Renderer: CSVRenderer{}, Handler: h},
+		},
+	}
+	srv := NewServer(":9996")
+	srv.AddAPI(a)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		hr, _ := http.NewRequest("GET", a.FullPath(path), nil)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, hr)
+		return w
+	}
+
+	for _, path := range []string{"/json", "/csv"} {
+		w := get(path)
+		assert.Equal(t, http.StatusNotFound, w.Code, path)
+		assert.Contains(t, w.Body.String(), "No such item", path)
+	}
+
+	a.Problems = &ProblemDetails{}
+	w := get("/json")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	var p Problem
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+	assert.Equal(t, http.StatusNotFound, p.Status)
+	assert.Equal(t, "No such item", p.Detail)
+}
@@ -0,0 +1,225 @@
+package vertex
+
+import (
+	"encoding/json"
+	"expvar"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// Security event types
+const (
+	// A user logged in, or authenticated with a login flow
+	EventAuthSuccess = "auth_success"
+
+	// A request failed authentication
+	EventAuthFailure = "auth_failure"
+
+	// An authenticated client was denied permission to perform a request
+	EventPermissionDenied = "permission_denied"
+
+	// A client was throttled for sending too many requests
+	EventRateLimited = "rate_limited"
+
+	// A signed value (cookie, webhook, assertion) failed signature verification - it was forged or tampered with
+	EventSignatureMismatch = "signature_mismatch"
+
+	// A client was locked out after too many failed authentication attempts
+	EventLockout = "lockout"
+)
+
+// SecurityEvent is a structured record of a security relevant occurrence
+type SecurityEvent struct {
+	Time        time.Time              `json:"time"`
+	Type        string                 `json:"type"`
+	Reason      string                 `json:"reason,omitempty"`
+	RequestId   string                 `json:"request_id,omitempty"`
+	PrincipalID string                 `json:"principal_id,omitempty"`
+	RemoteIP    string                 `json:"remote_ip,omitempty"`
+	Route       string                 `json:"route,omitempty"`
+	Details     map[string]interface{} `json:"details,omitempty"`
+
+	// The pod, namespace and node of the server, when running in kubernetes
+	Pod map[string]string `json:"pod,omitempty"`
+}
+
+// NewSecurityEvent creates a security event in the context of a request
+func NewSecurityEvent(r *Request, typ, reason string) SecurityEvent {
+	return SecurityEvent{
+		Time:        time.Now(),
+		Type:        typ,
+		Reason:      reason,
+		RequestId:   r.RequestId,
+		PrincipalID: r.PrincipalID(),
+		RemoteIP:    r.RemoteIP,
+		Route:       r.route,
+		Pod:         podLabels(),
+	}
+}
+
+// SecurityEventSink is the dedicated destination of security events, e.g. a file or a SIEM collector.
+// The default sink writes events to the log
+type SecurityEventSink interface {
+	Emit(SecurityEvent) error
+}
+
+// SecurityEventSinkFunc wraps a func as a SecurityEventSink
+type SecurityEventSinkFunc func(SecurityEvent) error
+
+// Emit calls the func
+func (f SecurityEventSinkFunc) Emit(e SecurityEvent) error {
+	return f(e)
+}
+
+// logSink writes security events to the log
+var logSink = SecurityEventSinkFunc(func(e SecurityEvent) error {
+	logging.Warning("Security event %s: %s (principal: %s, ip: %s)", e.Type, e.Reason, e.PrincipalID, e.RemoteIP)
+	return nil
+})
+
+// jsonSink writes security events as JSON lines
+type jsonSink struct {
+	mtx sync.Mutex
+	enc *json.Encoder
+}
+
+func (s *jsonSink) Emit(e SecurityEvent) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.enc.Encode(e)
+}
+
+// NewJSONEventSink creates a sink writing security events to w as JSON lines
+func NewJSONEventSink(w io.Writer) SecurityEventSink {
+	return &jsonSink{enc: json.NewEncoder(w)}
+}
+
+// subscriptionBuffer is the number of events a subscriber may lag behind before events are dropped for it
+const subscriptionBuffer = 1000
+
+type eventSubscription struct {
+	types map[string]bool
+	ch    chan SecurityEvent
+	// closed when the subscription is cancelled. ch is never closed, since events may still be sent to it after
+	// the subscription is removed
+	done chan struct{}
+}
+
+var securityEvents = struct {
+	sync.RWMutex
+	sink          SecurityEventSink
+	subscriptions map[*eventSubscription]struct{}
+}{
+	sink:          logSink,
+	subscriptions: map[*eventSubscription]struct{}{},
+}
+
+// event type => count, and dropped => count of events dropped for slow subscribers
+var securityEventCounts = expvar.NewMap("vertex.security_events")
+
+// SetSecurityEventSink replaces the security events sink. A nil sink restores the default log sink
+func SetSecurityEventSink(sink SecurityEventSink) {
+	if sink == nil {
+		sink = logSink
+	}
+	securityEvents.Lock()
+	securityEvents.sink = sink
+	securityEvents.Unlock()
+}
+
+// SubscribeSecurityEvents calls a handler for every security event of the given types, or of all types if none
+// are given, e.g. to detect anomalies or alert on them. Handlers are called in order on a dedicated goroutine,
+// so slow handlers do not slow down requests; events are dropped for handlers lagging too far behind.
+//
+// It returns a func that cancels the subscription
+func SubscribeSecurityEvents(handler func(SecurityEvent), types ...string) func() {
+
+	sub := &eventSubscription{
+		ch:   make(chan SecurityEvent, subscriptionBuffer),
+		done: make(chan struct{}),
+	}
+	if len(types) > 0 {
+		sub.types = make(map[string]bool, len(types))
+		for _, t := range types {
+			sub.types[t] = true
+		}
+	}
+
+	securityEvents.Lock()
+	securityEvents.subscriptions[sub] = struct{}{}
+	securityEvents.Unlock()
+
+	go func() {
+		for {
+			select {
+			case e := <-sub.ch:
+				handler(e)
+			case <-sub.done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			securityEvents.Lock()
+			delete(securityEvents.subscriptions, sub)
+			securityEvents.Unlock()
+			close(sub.done)
+		})
+	}
+}
+
+// EmitSecurityEvent sends a security event to the sink and to the subscribers. The sink is called without holding
+// any lock, so a slow sink only slows down its own emitters, and sinks may emit events or subscribe themselves
+func EmitSecurityEvent(e SecurityEvent) {
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	securityEventCounts.Add(e.Type, 1)
+
+	securityEvents.RLock()
+	sink := securityEvents.sink
+	subs := make([]*eventSubscription, 0, len(securityEvents.subscriptions))
+	for sub := range securityEvents.subscriptions {
+		if sub.types == nil || sub.types[e.Type] {
+			subs = append(subs, sub)
+		}
+	}
+	securityEvents.RUnlock()
+
+	if err := sink.Emit(e); err != nil {
+		logging.Error("Could not emit security event %s: %s", e.Type, err)
+	}
+
+	for _, sub := range subs {
+		select {
+		case <-sub.done:
+		case sub.ch <- e:
+		default:
+			securityEventCounts.Add("dropped", 1)
+		}
+	}
+}
+
+// emitErrorEvent emits the security event of an error rendered for a request, if it has one
+func emitErrorEvent(r *Request, err error) {
+
+	e, ok := err.(*internalError)
+	if !ok {
+		return
+	}
+
+	switch {
+	case e.Code == ErrForbidden:
+		EmitSecurityEvent(NewSecurityEvent(r, EventPermissionDenied, e.Message))
+	case errorStatus(err) == http.StatusTooManyRequests:
+		EmitSecurityEvent(NewSecurityEvent(r, EventRateLimited, e.Message))
+	}
+}
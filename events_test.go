@@ -0,0 +1,98 @@
+package vertex
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecurityEvents(t *testing.T) {
+
+	buf := &bytes.Buffer{}
+	SetSecurityEventSink(NewJSONEventSink(buf))
+	defer SetSecurityEventSink(nil)
+
+	all := make(chan SecurityEvent, 10)
+	failures := make(chan SecurityEvent, 10)
+	unsubscribe := SubscribeSecurityEvents(func(e SecurityEvent) { all <- e })
+	defer SubscribeSecurityEvents(func(e SecurityEvent) { failures <- e }, EventAuthFailure)()
+
+	hr, _ := http.NewRequest("GET", "/foo", nil)
+	hr.RemoteAddr = "10.0.0.1:1234"
+	r := NewRequest(hr)
+
+	EmitSecurityEvent(NewSecurityEvent(r, EventAuthSuccess, "password"))
+	EmitSecurityEvent(NewSecurityEvent(r, EventAuthFailure, "invalid_credentials"))
+
+	// the sink gets all events as json lines
+	dec := json.NewDecoder(buf)
+	var e SecurityEvent
+	assert.NoError(t, dec.Decode(&e))
+	assert.Equal(t, EventAuthSuccess, e.Type)
+	assert.Equal(t, "10.0.0.1", e.RemoteIP)
+	assert.NoError(t, dec.Decode(&e))
+	assert.Equal(t, EventAuthFailure, e.Type)
+
+	next := func(ch chan SecurityEvent) string {
+		select {
+		case e := <-ch:
+			return e.Type
+		case <-time.After(time.Second):
+			return ""
+		}
+	}
+
+	// subscribers get the events of their types
+	assert.Equal(t, EventAuthSuccess, next(all))
+	assert.Equal(t, EventAuthFailure, next(all))
+	assert.Equal(t, EventAuthFailure, next(failures))
+
+	unsubscribe()
+	unsubscribe()
+	EmitSecurityEvent(NewSecurityEvent(r, EventAuthFailure, "missing_credentials"))
+	assert.Equal(t, EventAuthFailure, next(failures))
+	assert.Equal(t, "", next(all))
+
+	// errors rendered for requests emit their events
+	emitErrorEvent(r, ForbiddenError("Not an admin"))
+	emitErrorEvent(r, TooManyRequestsError(time.Second, "Slow down"))
+	emitErrorEvent(r, InvalidParamError("Bad param"))
+	assert.NoError(t, dec.Decode(&e))
+	assert.NoError(t, dec.Decode(&e))
+	assert.Equal(t, EventPermissionDenied, e.Type)
+	assert.NoError(t, dec.Decode(&e))
+	assert.Equal(t, EventRateLimited, e.Type)
+	assert.Error(t, dec.Decode(&e))
+}
+
+type reentrantSink struct {
+	emitted chan SecurityEvent
+}
+
+func (s reentrantSink) Emit(e SecurityEvent) error {
+	// sinks may subscribe and replace the sink without deadlocking emitters
+	SubscribeSecurityEvents(func(SecurityEvent) {})()
+	SetSecurityEventSink(s)
+	s.emitted <- e
+	return nil
+}
+
+func TestSecurityEventSinkReentrant(t *testing.T) {
+
+	sink := reentrantSink{emitted: make(chan SecurityEvent, 1)}
+	SetSecurityEventSink(sink)
+	defer SetSecurityEventSink(nil)
+
+	go EmitSecurityEvent(SecurityEvent{Type: EventAuthFailure})
+
+	select {
+	case e := <-sink.emitted:
+		assert.Equal(t, EventAuthFailure, e.Type)
+	case <-time.After(time.Second):
+		t.Fatal("emitting a security event deadlocked")
+	}
+}
@@ -0,0 +1,388 @@
+package vertex
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// ExpandParam is the request param listing the relations to expand
+const ExpandParam = "expand"
+
+// Defaults of the expansion limits, if not set in the Expansions of a route
+const (
+	DefaultMaxExpandDepth    = 2
+	DefaultMaxExpandLoads    = 200
+	DefaultExpandConcurrency = 8
+)
+
+// Relation is a resource related to the items a route returns, that clients can ask to embed in the response rather
+// than fetch it in another request
+type Relation struct {
+	// The name of the relation in the expand param, and of the field it is embedded in
+	Name string
+
+	// Description of the relation for the documentation
+	Description string
+
+	// Load loads the related resource of a single item of the response, as returned by the handler
+	Load func(r *Request, item interface{}) (interface{}, error)
+
+	// Alternatively, the related resources of all the items are loaded together by a batch loader, by the key Key
+	// returns for every item. See BatchLoader
+	Batch *BatchLoader
+	Key   func(item interface{}) interface{}
+
+	// Relations of the loaded resource, expanded with dotted names, e.g. owner.team
+	Relations []Relation
+}
+
+// Expansions declare the relations of a route clients can expand with the expand param, e.g.
+// ?expand=owner,comments.author:
+//
+//	{
+//		Path:    "/posts",
+//		Handler: ListPostsHandler{},
+//		Returns: []Post{},
+//		Expand: &vertex.Expansions{
+//			Relations: []vertex.Relation{
+//				{Name: "owner", Load: loadPostOwner},
+//				{Name: "comments", Load: loadPostComments, Relations: []vertex.Relation{
+//					{Name: "author", Load: loadCommentAuthor},
+//				}},
+//			},
+//		},
+//	}
+//
+// Handlers return their items as usual, either a single item or a slice of them. The requested relations are loaded
+// concurrently for every item, and embedded in it by name before it is rendered
+type Expansions struct {
+	Relations []Relation
+
+	// Maximum nesting of expanded relations. Defaults to DefaultMaxExpandDepth
+	MaxDepth int
+
+	// Maximum number of relations loaded for a request, over all its items. Defaults to DefaultMaxExpandLoads
+	MaxLoads int
+
+	// Maximum number of relations loaded concurrently, except by batch loaders. Defaults to DefaultExpandConcurrency
+	Concurrency int
+}
+
+// expandTree is the parsed expand param: the relations to expand by name, and their own relations to expand
+type expandTree map[string]expandTree
+
+// parse parses and validates an expand param against the relations
+func (e *Expansions) parse(param string) (expandTree, error) {
+
+	maxDepth := e.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxExpandDepth
+	}
+
+	tree := expandTree{}
+	for _, path := range strings.Split(param, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+
+		names := strings.Split(path, ".")
+		if len(names) > maxDepth {
+			return nil, InvalidParamError("Cannot expand %s, relations can be nested up to %d levels", path, maxDepth)
+		}
+
+		relations, node := e.Relations, tree
+		for _, name := range names {
+			rel := findRelation(relations, name)
+			if rel == nil {
+				return nil, InvalidParamError("Cannot expand %s, %s is not a relation. Expandable relations: %s",
+					path, name, strings.Join(relationNames(relations), ", "))
+			}
+			if node[name] == nil {
+				node[name] = expandTree{}
+			}
+			relations, node = rel.Relations, node[name]
+		}
+	}
+	return tree, nil
+}
+
+func findRelation(relations []Relation, name string) *Relation {
+	for i := range relations {
+		if relations[i].Name == name {
+			return &relations[i]
+		}
+	}
+	return nil
+}
+
+func relationNames(relations []Relation) []string {
+	ret := make([]string, len(relations))
+	for i, rel := range relations {
+		ret[i] = rel.Name
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// describe documents the expandable relations, nested ones with their dotted names
+func (e *Expansions) describe() string {
+
+	lines := []string{"Comma separated relations to embed in the response:"}
+	var walk func(prefix string, relations []Relation)
+	walk = func(prefix string, relations []Relation) {
+		for _, rel := range relations {
+			line := "* " + prefix + rel.Name
+			if rel.Description != "" {
+				line += " - " + rel.Description
+			}
+			lines = append(lines, line)
+			walk(prefix+rel.Name+".", rel.Relations)
+		}
+	}
+	walk("", e.Relations)
+	return strings.Join(lines, "\n")
+}
+
+// expander loads the relations of a single request
+type expander struct {
+	r        *Request
+	maxLoads int64
+	loads    int64
+	sem      chan struct{}
+}
+
+// expandedItem is an item of a response with its expanded relations, rendered as the JSON object of the item with
+// the relations embedded in it. The item and its relations keep their types, so the middleware of the route still see
+// their field tags, e.g. to redact PII
+type expandedItem struct {
+	Item      interface{}
+	Relations map[string]interface{}
+}
+
+// MarshalJSON renders the item with its relations embedded by name
+func (x expandedItem) MarshalJSON() ([]byte, error) {
+
+	b, err := json.Marshal(x.Item)
+	if err != nil {
+		return nil, err
+	}
+	var ret map[string]interface{}
+	if err := json.Unmarshal(b, &ret); err != nil || ret == nil {
+		return nil, NewErrorf("Cannot expand relations of %T, only of objects", x.Item)
+	}
+	for name, v := range x.Relations {
+		ret[name] = v
+	}
+	return json.Marshal(ret)
+}
+
+// expandMiddleware expands the relations a request asked for in the response of the handler. It is the last step of
+// the chain of a route before the handler, so the rest of the middleware see the expanded response
+func expandMiddleware(e *Expansions) Middleware {
+	return MiddlewareFunc(func(w http.ResponseWriter, r *Request, next HandlerFunc) (interface{}, error) {
+		ret, err := next(w, r)
+		if err != nil {
+			return ret, err
+		}
+		return expandResponse(r, e, ret)
+	})
+}
+
+// expandResponse expands the relations a request asked for in the response of its handler. Responses without
+// requested relations are returned as is, and wrapped responses have their value expanded
+func expandResponse(r *Request, e *Expansions, v interface{}) (interface{}, error) {
+
+	if e == nil || v == nil {
+		return v, nil
+	}
+
+	var err error
+	switch w := v.(type) {
+	case *Response:
+		ret := *w
+		ret.Body, err = expandResponse(r, e, w.Body)
+		return &ret, err
+	case *VersionedResponse:
+		ret := *w
+		ret.Value, err = expandResponse(r, e, w.Value)
+		return &ret, err
+	case *CachedResponse:
+		ret := *w
+		ret.Value, err = expandResponse(r, e, w.Value)
+		return &ret, err
+	}
+	if _, ok := v.(Streamer); ok {
+		return v, nil
+	}
+	param := r.FormValue(ExpandParam)
+	if param == "" {
+		return v, nil
+	}
+
+	tree, err := e.parse(param)
+	if err != nil || len(tree) == 0 {
+		return v, err
+	}
+
+	x := &expander{r: r, maxLoads: int64(e.MaxLoads)}
+	if x.maxLoads <= 0 {
+		x.maxLoads = DefaultMaxExpandLoads
+	}
+	concurrency := e.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultExpandConcurrency
+	}
+	x.sem = make(chan struct{}, concurrency)
+
+	return x.expand(v, e.Relations, tree)
+}
+
+// expand expands the relations of a single item or a slice of items, returning them with the relations embedded
+func (x *expander) expand(v interface{}, relations []Relation, tree expandTree) (interface{}, error) {
+
+	val := reflect.ValueOf(v)
+	if !val.IsValid() || (val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface) && val.IsNil() {
+		return nil, nil
+	}
+
+	if val.Kind() != reflect.Slice && val.Kind() != reflect.Array {
+		return x.expandItem(v, relations, tree)
+	}
+
+	if err := x.prime(val, relations, tree); err != nil {
+		return nil, err
+	}
+
+	ret := make([]interface{}, val.Len())
+	errs := make([]error, val.Len())
+	wg := sync.WaitGroup{}
+	for i := 0; i < val.Len(); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ret[i], errs[i] = x.expandItem(val.Index(i).Interface(), relations, tree)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// prime loads the batch loaded relations of a slice of items for all the items at once, so every item finds its
+// relation in the cache of its batch loader
+func (x *expander) prime(items reflect.Value, relations []Relation, tree expandTree) error {
+
+	errs := make(chan error, len(tree))
+	wg := sync.WaitGroup{}
+	for name := range tree {
+		rel := findRelation(relations, name)
+		if rel.Batch == nil || rel.Key == nil {
+			continue
+		}
+
+		keys := make([]interface{}, items.Len())
+		for i := range keys {
+			keys[i] = rel.Key(items.Index(i).Interface())
+		}
+		wg.Add(1)
+		go func(rel *Relation) {
+			defer wg.Done()
+			if _, err := rel.Batch.LoadMany(x.r, keys); err != nil {
+				errs <- err
+			}
+		}(rel)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// expandItem loads the relations of a single item concurrently, and embeds them in it
+func (x *expander) expandItem(item interface{}, relations []Relation, tree expandTree) (interface{}, error) {
+
+	// only objects have fields to embed the relations in
+	if k := reflect.Indirect(reflect.ValueOf(item)).Kind(); k != reflect.Struct && k != reflect.Map {
+		return nil, NewErrorf("Cannot expand relations of %T, only of objects", item)
+	}
+	ret := expandedItem{Item: item, Relations: map[string]interface{}{}}
+
+	lock := sync.Mutex{}
+	var firstErr error
+	wg := sync.WaitGroup{}
+	for name, sub := range tree {
+		if atomic.AddInt64(&x.loads, 1) > x.maxLoads {
+			lock.Lock()
+			firstErr = InvalidParamError("Too many relations to expand, the limit is %d per request", x.maxLoads)
+			lock.Unlock()
+			break
+		}
+
+		wg.Add(1)
+		go func(rel *Relation, sub expandTree) {
+			defer wg.Done()
+			loaded, err := x.load(rel, item)
+			if err == nil && len(sub) > 0 {
+				loaded, err = x.expand(loaded, rel.Relations, sub)
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			ret.Relations[rel.Name] = loaded
+		}(findRelation(relations, name), sub)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return ret, nil
+}
+
+// load loads a relation of an item, converting panics of the loader to errors
+func (x *expander) load(rel *Relation, item interface{}) (ret interface{}, err error) {
+
+	defer func() {
+		if e := recover(); e != nil {
+			logging.Error("Panic loading relation %s: %v", rel.Name, e)
+			ret, err = nil, NewErrorf("PANIC loading relation %s: %v", rel.Name, e)
+		}
+	}()
+
+	switch {
+	case rel.Batch != nil && rel.Key != nil:
+		// batch loads are not limited by the concurrency, so all the items make it into the same batch
+		ret, err = rel.Batch.Load(x.r, rel.Key(item))
+	case rel.Load != nil:
+		x.sem <- struct{}{}
+		ret, err = func() (interface{}, error) {
+			defer func() { <-x.sem }()
+			return rel.Load(x.r, item)
+		}()
+	default:
+		return nil, NewErrorf("Relation %s has no loader", rel.Name)
+	}
+	if err != nil {
+		recordRequestLog(x.r.RequestId, fmt.Sprintf("Loading relation %s failed: %s", rel.Name, err))
+	}
+	return ret, err
+}
@@ -0,0 +1,186 @@
+package vertex
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type expandPost struct {
+	Id      int `json:"id"`
+	OwnerId int `json:"ownerId"`
+}
+
+type expandUser struct {
+	Id     int    `json:"id"`
+	Name   string `json:"name"`
+	TeamId int    `json:"teamId"`
+}
+
+type listPostsHandler struct{}
+
+func (h listPostsHandler) Handle(w http.ResponseWriter, r *Request) (interface{}, error) {
+	return []expandPost{{1, 10}, {2, 20}}, nil
+}
+
+func TestExpandParse(t *testing.T) {
+
+	e := &Expansions{
+		Relations: []Relation{
+			{Name: "owner", Description: "The author", Relations: []Relation{{Name: "team"}}},
+			{Name: "comments"},
+		},
+	}
+
+	tree, err := e.parse("owner.team, comments,owner")
+	assert.NoError(t, err)
+	assert.Equal(t, expandTree{"owner": {"team": {}}, "comments": {}}, tree)
+
+	_, err = e.parse("likes")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "comments, owner")
+
+	_, err = e.parse("owner.team.members")
+	assert.Error(t, err)
+
+	assert.Equal(t, "Comma separated relations to embed in the response:\n* owner - The author\n* owner.team\n* comments",
+		e.describe())
+}
+
+func TestExpandResponse(t *testing.T) {
+
+	loadOwner := func(r *Request, item interface{}) (interface{}, error) {
+		p := item.(expandPost)
+		return expandUser{p.OwnerId, fmt.Sprintf("user%d", p.OwnerId), p.OwnerId * 10}, nil
+	}
+	loadTeam := func(r *Request, item interface{}) (interface{}, error) {
+		return map[string]interface{}{"id": item.(expandUser).TeamId}, nil
+	}
+
+	a := &API{
+		Name:          "expand",
+		Version:       "1.0",
+		Root:          "/expand",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{
+				Path:        "/posts",
+				Description: "Posts",
+				Methods:     GET,
+				Handler:     listPostsHandler{},
+				Returns:     []expandPost{},
+				Expand: &Expansions{
+					Relations: []Relation{
+						{Name: "owner", Load: loadOwner, Relations: []Relation{{Name: "team", Load: loadTeam}}},
+						{Name: "avatar", Load: func(r *Request, item interface{}) (interface{}, error) {
+							return "avatar.png", nil
+						}},
+						{Name: "broken", Load: func(r *Request, item interface{}) (interface{}, error) {
+							panic("oops")
+						}},
+					},
+					MaxLoads: 4,
+				},
+			},
+		},
+	}
+	srv := NewServer(":9985")
+	srv.AddAPI(a)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		hr, _ := http.NewRequest("GET", a.FullPath("/posts")+query, nil)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, hr)
+		return w
+	}
+
+	w := get("")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "owner\":{")
+
+	w = get("?expand=owner")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"owner":{"id":10,"name":"user10","teamId":100}`)
+	assert.Contains(t, w.Body.String(), `"owner":{"id":20,"name":"user20","teamId":200}`)
+
+	w = get("?expand=owner.team")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"team":{"id":100}`)
+	assert.Contains(t, w.Body.String(), `"team":{"id":200}`)
+
+	w = get("?expand=nope")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = get("?expand=broken")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	// 2 posts with 3 relations each are over the limit of 4 loads
+	w = get("?expand=owner.team,avatar")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "Too many relations")
+
+	spec := a.ToSwagger("localhost")
+	found := false
+	for _, p := range spec.Paths["/posts"]["get"].Parameters {
+		if p.Name == ExpandParam {
+			found = true
+			assert.True(t, strings.Contains(p.Description, "* owner.team"))
+		}
+	}
+	assert.True(t, found)
+}
+
+type expandContact struct {
+	Id    int    `json:"id"`
+	Email string `json:"email" pii:"true"`
+}
+
+func TestExpandThroughMiddleware(t *testing.T) {
+
+	redact := MiddlewareFunc(func(w http.ResponseWriter, r *Request, next HandlerFunc) (interface{}, error) {
+		ret, err := next(w, r)
+		ret, _ = RedactPII(ret)
+		return ret, err
+	})
+
+	a := &API{
+		Name:          "expandmw",
+		Version:       "1.0",
+		Root:          "/expandmw",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{
+				Path:        "/contacts",
+				Description: "Contacts",
+				Methods:     GET,
+				Middleware:  []Middleware{redact},
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					return WithStatus(http.StatusCreated, []expandContact{{1, "alice@example.com"}}), nil
+				}),
+				Expand: &Expansions{
+					Relations: []Relation{
+						{Name: "manager", Load: func(r *Request, item interface{}) (interface{}, error) {
+							return expandContact{2, "bob@example.com"}, nil
+						}},
+					},
+				},
+			},
+		},
+	}
+	srv := NewServer(":9933")
+	srv.AddAPI(a)
+
+	// middleware see the expanded response with its types, so PII in related resources is redacted too
+	hr, _ := http.NewRequest("GET", a.FullPath("/contacts")+"?expand=manager", nil)
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, hr)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"manager":{"id":2,"email":"`+PIIMask+`"}`)
+	assert.NotContains(t, w.Body.String(), "example.com")
+}
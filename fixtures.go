@@ -0,0 +1,251 @@
+package vertex
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Fixture modes of the test runner. In record mode, the responses testers get with TestContext.GetJSON are saved
+// as fixtures. In replay mode, the responses are asserted to still match the recorded fixtures, turning the testers
+// into regression snapshots. Fields that change between runs, e.g. ids and timestamps, are ignored with
+// API.FixtureIgnore and TestContext.IgnoreFields
+const (
+	FixturesOff    = ""
+	FixturesRecord = "record"
+	FixturesReplay = "replay"
+)
+
+// fixture is a recorded response
+type fixture struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body"`
+}
+
+// fixtureConfig configures the fixture mode of a test runner
+type fixtureConfig struct {
+	mode string
+	dir  string
+}
+
+// path returns the fixtures file of a route's tester
+func (c fixtureConfig) path(a *API, route string) string {
+	name := strings.Trim(strings.NewReplacer("/", "_", ":", "", "{", "", "}", "").Replace(route), "_")
+	if name == "" {
+		name = "root"
+	}
+	return filepath.Join(c.dir, a.Name, a.Version, name+".json")
+}
+
+// fixtureSession records or replays the responses of a single tester run
+type fixtureSession struct {
+	config   fixtureConfig
+	path     string
+	mtx      sync.Mutex
+	recorded []fixture
+	next     int
+	ignore   [][]string
+}
+
+// newFixtureSession starts a session for a route's tester, loading its fixtures in replay mode
+func newFixtureSession(config fixtureConfig, a *API, route string) (*fixtureSession, error) {
+
+	s := &fixtureSession{
+		config: config,
+		path:   config.path(a, route),
+	}
+	for _, field := range a.FixtureIgnore {
+		s.ignoreField(field)
+	}
+
+	if config.mode == FixturesReplay {
+		data, err := ioutil.ReadFile(s.path)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read fixtures: %s", err)
+		}
+		if err := json.Unmarshal(data, &s.recorded); err != nil {
+			return nil, fmt.Errorf("Invalid fixtures in %s: %s", s.path, err)
+		}
+	}
+	return s, nil
+}
+
+// ignoreField ignores a dot separated field path when comparing responses. "*" matches any key or array element,
+// e.g. "items.*.created"
+func (s *fixtureSession) ignoreField(path string) {
+	s.ignore = append(s.ignore, strings.Split(path, "."))
+}
+
+// check records a response, or compares it to the next recorded one, returning a description of the mismatch
+func (s *fixtureSession) check(status int, body []byte) (string, error) {
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.config.mode == FixturesRecord {
+		raw := json.RawMessage(append([]byte(nil), body...))
+		if !json.Valid(body) {
+			raw, _ = json.Marshal(string(body))
+		}
+		s.recorded = append(s.recorded, fixture{Status: status, Body: raw})
+		return "", nil
+	}
+
+	if s.next >= len(s.recorded) {
+		return fmt.Sprintf("unexpected request #%d, only %d were recorded", s.next+1, len(s.recorded)), nil
+	}
+	expected := s.recorded[s.next]
+	s.next++
+
+	if expected.Status != status {
+		return fmt.Sprintf("response #%d: expected status %d, got %d", s.next, expected.Status, status), nil
+	}
+
+	var want, got interface{}
+	if err := json.Unmarshal(expected.Body, &want); err != nil {
+		return "", err
+	}
+	if err := json.Unmarshal(body, &got); err != nil {
+		got = string(body)
+	}
+
+	for _, path := range s.ignore {
+		want = stripField(want, path)
+		got = stripField(got, path)
+	}
+
+	if diff := diffJSON(want, got, ""); diff != "" {
+		return fmt.Sprintf("response #%d: %s", s.next, diff), nil
+	}
+	return "", nil
+}
+
+// save writes the recorded fixtures, or fails if fewer requests were made than recorded in replay mode
+func (s *fixtureSession) save() error {
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.config.mode == FixturesReplay {
+		if s.next < len(s.recorded) {
+			return fmt.Errorf("only %d of %d recorded requests were made", s.next, len(s.recorded))
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.recorded, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path, data, 0644)
+}
+
+// stripField removes a field path from a decoded JSON value
+func stripField(v interface{}, path []string) interface{} {
+
+	if len(path) == 0 {
+		return nil
+	}
+
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, child := range x {
+			if path[0] != "*" && path[0] != k {
+				continue
+			}
+			if len(path) == 1 {
+				delete(x, k)
+			} else {
+				x[k] = stripField(child, path[1:])
+			}
+		}
+	case []interface{}:
+		for i, child := range x {
+			if path[0] != "*" && path[0] != strconv.Itoa(i) {
+				continue
+			}
+			if len(path) == 1 {
+				x[i] = nil
+			} else {
+				x[i] = stripField(child, path[1:])
+			}
+		}
+	}
+	return v
+}
+
+// diffJSON returns a description of the first difference between two decoded JSON values, or an empty string if
+// they are equal
+func diffJSON(want, got interface{}, path string) string {
+
+	field := path
+	if field == "" {
+		field = "body"
+	}
+
+	switch w := want.(type) {
+	case map[string]interface{}:
+		g, ok := got.(map[string]interface{})
+		if !ok {
+			return fmt.Sprintf("%s: expected an object, got %v", field, got)
+		}
+		keys := make([]string, 0, len(w)+len(g))
+		for k := range w {
+			keys = append(keys, k)
+		}
+		for k := range g {
+			if _, found := w[k]; !found {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			child := k
+			if path != "" {
+				child = path + "." + k
+			}
+			wv, wfound := w[k]
+			gv, gfound := g[k]
+			if !wfound {
+				return fmt.Sprintf("%s: unexpected field", child)
+			}
+			if !gfound {
+				return fmt.Sprintf("%s: missing field", child)
+			}
+			if diff := diffJSON(wv, gv, child); diff != "" {
+				return diff
+			}
+		}
+		return ""
+
+	case []interface{}:
+		g, ok := got.([]interface{})
+		if !ok {
+			return fmt.Sprintf("%s: expected an array, got %v", field, got)
+		}
+		if len(w) != len(g) {
+			return fmt.Sprintf("%s: expected %d elements, got %d", field, len(w), len(g))
+		}
+		for i := range w {
+			if diff := diffJSON(w[i], g[i], fmt.Sprintf("%s.%d", strings.TrimPrefix(path, "."), i)); diff != "" {
+				return diff
+			}
+		}
+		return ""
+	}
+
+	if !reflect.DeepEqual(want, got) {
+		return fmt.Sprintf("%s: expected %v, got %v", field, want, got)
+	}
+	return ""
+}
@@ -0,0 +1,102 @@
+package vertex
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffJSON(t *testing.T) {
+
+	decode := func(s string) interface{} {
+		var v interface{}
+		assert.NoError(t, json.Unmarshal([]byte(s), &v))
+		return v
+	}
+
+	assert.Equal(t, "", diffJSON(decode(`{"a":1,"b":[1,2]}`), decode(`{"b":[1,2],"a":1}`), ""))
+	assert.Equal(t, "a: expected 1, got 2", diffJSON(decode(`{"a":1}`), decode(`{"a":2}`), ""))
+	assert.Equal(t, "b: missing field", diffJSON(decode(`{"a":1,"b":1}`), decode(`{"a":1}`), ""))
+	assert.Equal(t, "c: unexpected field", diffJSON(decode(`{"a":1}`), decode(`{"a":1,"c":1}`), ""))
+	assert.Equal(t, "items.1.name: expected x, got y",
+		diffJSON(decode(`{"items":[{"name":"x"},{"name":"x"}]}`), decode(`{"items":[{"name":"x"},{"name":"y"}]}`), ""))
+	assert.Equal(t, "items: expected 2 elements, got 1", diffJSON(decode(`{"items":[1,2]}`), decode(`{"items":[1]}`), ""))
+
+	v := stripField(decode(`{"id":1,"items":[{"id":2,"name":"x"}]}`), []string{"items", "*", "id"})
+	assert.Equal(t, decode(`{"id":1,"items":[{"name":"x"}]}`), v)
+}
+
+func TestFixtures(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "fixtures")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	calls := 0
+	name := "foo"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprintf(w, `{"id":%d,"name":%q}`, calls, name)
+	}))
+	defer ts.Close()
+
+	tester := CriticalTest(func(ctx *TestContext) {
+		req, err := ctx.NewRequest("GET", nil, nil)
+		if err != nil {
+			ctx.Fatal("Could not create request: %s", err)
+		}
+		v := map[string]interface{}{}
+		if _, err := ctx.GetJSON(req, &v); err != nil {
+			ctx.Fail("Request failed: %s", err)
+		}
+	})
+
+	a := &API{
+		Name:          "fixtung",
+		Version:       "1.0",
+		Root:          "/fixtung",
+		FixtureIgnore: []string{"id"},
+		Routes: Routes{
+			{Path: "/user/{id}", Methods: GET, Handler: VoidHandler{}, Test: tester},
+		},
+	}
+
+	run := func(mode string) (bool, string) {
+		buf := bytes.NewBuffer(nil)
+		runner := newTestRunner(buf, a, ts.URL, AllTests, TestFormatText)
+		runner.fixtures = fixtureConfig{mode: mode, dir: dir}
+		return runner.Run(), buf.String()
+	}
+
+	// replaying without recorded fixtures fails
+	ok, _ := run(FixturesReplay)
+	assert.False(t, ok)
+
+	ok, _ = run(FixturesRecord)
+	assert.True(t, ok)
+	_, err = os.Stat(filepath.Join(dir, "fixtung", "1.0", "user_id.json"))
+	assert.NoError(t, err)
+
+	// the id changes between calls but is ignored
+	ok, out := run(FixturesReplay)
+	assert.True(t, ok, out)
+
+	name = "bar"
+	ok, out = run(FixturesReplay)
+	assert.False(t, ok)
+	assert.Contains(t, out, "name: expected foo, got bar")
+
+	// fixtures are not checked when off
+	ok, _ = run(FixturesOff)
+	assert.True(t, ok)
+}
@@ -0,0 +1,75 @@
+package vertex
+
+import (
+	"net/http"
+	"time"
+)
+
+// Flash message levels
+const (
+	FlashInfo    = "info"
+	FlashSuccess = "success"
+	FlashWarning = "warning"
+	FlashError   = "error"
+)
+
+// Flash is a one-shot message shown to a browser user on the next page they see, e.g. after a form submission
+type Flash struct {
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+// FlashCookie is the signed cookie holding pending flash messages. Its keys or key ring must be set before flash
+// messages are used:
+//
+//	vertex.FlashCookie.KeyRing = vertex.GetKeyRing("flash")
+var FlashCookie = Cookie{
+	Name:   "vertex_flash",
+	MaxAge: 5 * time.Minute,
+	Signed: true,
+}
+
+// attrFlashes holds the flash messages added while handling a request, so multiple messages can be added
+const attrFlashes = "vertex.flashes"
+
+// AddFlash adds a flash message to show on the next page the user sees
+func AddFlash(w http.ResponseWriter, r *Request, level, message string) error {
+
+	var flashes []Flash
+	if v, found := r.Attribute(attrFlashes); found {
+		flashes = v.([]Flash)
+	} else if err := FlashCookie.Get(r, &flashes); err != nil {
+		flashes = nil
+	}
+
+	flashes = append(flashes, Flash{Level: level, Message: message})
+	r.SetAttribute(attrFlashes, flashes)
+
+	return FlashCookie.Set(w, r, flashes)
+}
+
+// Flashes returns the pending flash messages of the user and clears them, so each message is shown once
+func Flashes(w http.ResponseWriter, r *Request) []Flash {
+
+	var flashes []Flash
+	if err := FlashCookie.Get(r, &flashes); err != nil {
+		return nil
+	}
+
+	FlashCookie.Delete(w, r)
+	return flashes
+}
+
+// RedirectWithFlash adds a flash message and redirects the user to another page with a 303 See Other, the standard
+// way of ending a browser form submission. It returns Hijacked, so handlers can return its result directly:
+//
+//	return nil, vertex.RedirectWithFlash(w, r, "/users", vertex.FlashSuccess, "User created")
+func RedirectWithFlash(w http.ResponseWriter, r *Request, url, level, message string) error {
+
+	if err := AddFlash(w, r, level, message); err != nil {
+		return err
+	}
+
+	http.Redirect(w, r.Request, url, http.StatusSeeOther)
+	return Hijacked
+}
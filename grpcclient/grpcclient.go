@@ -0,0 +1,174 @@
+// Package grpcclient provides a pool of gRPC client connections for vertex handlers calling internal gRPC services.
+//
+// Connections are shared by all requests, closed when the server stops, and reported as resources: call latencies
+// are broken down by route in the resource latency metrics, and the state of connections to targets marked with
+// Pool.Require feeds the readiness endpoint.
+// Calls made with OutgoingContext carry the request id, principal and trace context of the vertex request, and
+// are bounded by its deadline:
+//
+//	var users = grpcclient.NewPool(grpc.WithTransportCredentials(creds))
+//
+//	func (h GetUserHandler) Handle(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+//		conn, err := users.Conn("users.internal:443")
+//		if err != nil {
+//			return nil, err
+//		}
+//		ctx, cancel := grpcclient.OutgoingContext(r)
+//		defer cancel()
+//		return userspb.NewUsersClient(conn).GetUser(ctx, &userspb.GetUserRequest{Id: h.Id})
+//	}
+package grpcclient
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/EverythingMe/vertex"
+)
+
+// Metadata keys propagating the context of the calling request
+const (
+	MetadataRequestId   = "x-request-id"
+	MetadataPrincipal   = "x-vertex-principal"
+	MetadataTraceParent = "traceparent"
+)
+
+// DefaultTimeout bounds calls made in the context of requests without a deadline
+const DefaultTimeout = 10 * time.Second
+
+type requestKey struct{}
+
+// OutgoingContext returns the context for gRPC calls made while handling a request. It is canceled with the request,
+// expires at the request's deadline or after DefaultTimeout, and carries the request's id, principal and trace
+// context as metadata
+func OutgoingContext(r *vertex.Request) (context.Context, context.CancelFunc) {
+
+	ctx := context.WithValue(r.Context(), requestKey{}, r)
+
+	kv := []string{MetadataRequestId, r.RequestId}
+	if id := r.PrincipalID(); id != "" {
+		kv = append(kv, MetadataPrincipal, id)
+	}
+	if tp := r.Header.Get("Traceparent"); tp != "" {
+		kv = append(kv, MetadataTraceParent, tp)
+	}
+	ctx = metadata.AppendToOutgoingContext(ctx, kv...)
+
+	if !r.Deadline.IsZero() {
+		return context.WithDeadline(ctx, r.Deadline)
+	}
+	return context.WithTimeout(ctx, DefaultTimeout)
+}
+
+// resourceName is the name connections are reported as resources under
+func resourceName(target string) string {
+	return "grpc:" + target
+}
+
+// observe is a client interceptor reporting the latency of calls made with OutgoingContext to the calling request
+func observe(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+
+	if r, ok := ctx.Value(requestKey{}).(*vertex.Request); ok {
+		defer r.TimeResource(resourceName(cc.Target()))()
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// Pool is a pool of client connections, one per target
+type Pool struct {
+	mtx      sync.Mutex
+	opts     []grpc.DialOption
+	conns    map[string]*grpc.ClientConn
+	required map[string]bool
+}
+
+// NewPool creates a connection pool dialing with the given options, and closes it when the server stops
+func NewPool(opts ...grpc.DialOption) *Pool {
+
+	p := &Pool{
+		opts:     append(opts, grpc.WithChainUnaryInterceptor(observe)),
+		conns:    map[string]*grpc.ClientConn{},
+		required: map[string]bool{},
+	}
+	vertex.CloseOnShutdown("grpc connection pool", p)
+	return p
+}
+
+// Conn returns the connection to a target, dialing it on first use. Dialing does not wait for the connection to be
+// established
+func (p *Pool) Conn(target string) (*grpc.ClientConn, error) {
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.conns == nil {
+		return nil, vertex.ResourceUnavailableError("Connection pool is closed")
+	}
+	if conn, found := p.conns[target]; found {
+		return conn, nil
+	}
+
+	conn, err := grpc.Dial(target, p.opts...)
+	if err != nil {
+		return nil, logging.Errorf("Could not dial %s: %s", target, err)
+	}
+	p.conns[target] = conn
+	p.register(target, conn)
+
+	logging.Info("Connected to gRPC service %s", target)
+	return conn, nil
+}
+
+// Require makes the readiness of the server depend on the connection to a target, taking the server out of
+// rotation while it is down. Connections do not affect readiness otherwise, since an outage of a downstream
+// service would take every server calling it out of rotation
+func (p *Pool) Require(target string) {
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.required[target] = true
+	if conn, found := p.conns[target]; found {
+		p.register(target, conn)
+	}
+}
+
+// register reports a connection as a resource, checking its state only if the target is required
+func (p *Pool) register(target string, conn *grpc.ClientConn) {
+
+	var check vertex.HealthCheck
+	if p.required[target] {
+		check = func(ctx context.Context) error {
+			if state := conn.GetState(); state == connectivity.TransientFailure || state == connectivity.Shutdown {
+				return fmt.Errorf("connection is %s", state)
+			}
+			return nil
+		}
+	}
+	vertex.RegisterResource(resourceName(target), check)
+}
+
+// Close closes all the connections of the pool. Later calls to Conn fail
+func (p *Pool) Close() error {
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	var ret error
+	for target, conn := range p.conns {
+		if err := conn.Close(); err != nil {
+			logging.Error("Error closing connection to %s: %s", target, err)
+			ret = err
+		}
+	}
+	p.conns = nil
+	return ret
+}
@@ -0,0 +1,72 @@
+package grpcclient
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/EverythingMe/vertex"
+)
+
+func TestOutgoingContext(t *testing.T) {
+
+	hr, _ := http.NewRequest("GET", "/grpc/users", nil)
+	hr.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r := vertex.NewRequest(hr)
+	r.SetPrincipal(&vertex.Principal{ID: "alice"})
+
+	ctx, cancel := OutgoingContext(r)
+	defer cancel()
+
+	md, ok := metadata.FromOutgoingContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, []string{r.RequestId}, md.Get(MetadataRequestId))
+	assert.Equal(t, []string{"alice"}, md.Get(MetadataPrincipal))
+	assert.Equal(t, []string{hr.Header.Get("Traceparent")}, md.Get(MetadataTraceParent))
+
+	deadline, ok := ctx.Deadline()
+	assert.True(t, ok)
+	assert.True(t, time.Until(deadline) <= DefaultTimeout)
+
+	r.Deadline = time.Now().Add(time.Second)
+	ctx, cancel = OutgoingContext(r)
+	defer cancel()
+	deadline, _ = ctx.Deadline()
+	assert.Equal(t, r.Deadline, deadline)
+}
+
+func TestPool(t *testing.T) {
+
+	p := NewPool(grpc.WithInsecure())
+	conn, err := p.Conn("users.internal:443")
+	assert.NoError(t, err)
+	again, _ := p.Conn("users.internal:443")
+	assert.True(t, conn == again)
+
+	// calls are reported as resource latencies of the calling request
+	hr, _ := http.NewRequest("GET", "/grpc/pool", nil)
+	r := vertex.NewRequest(hr)
+	ctx, cancel := OutgoingContext(r)
+	defer cancel()
+	err = observe(ctx, "/users.Users/GetUser", nil, nil, conn,
+		func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			return nil
+		})
+	assert.NoError(t, err)
+	calls, _ := vertex.ResourceLatency("/grpc/pool", "grpc:users.internal:443")
+	assert.Equal(t, int64(1), calls)
+
+	// only required targets feed readiness
+	assert.False(t, p.required["users.internal:443"])
+	p.Require("users.internal:443")
+	assert.True(t, p.required["users.internal:443"])
+
+	assert.NoError(t, p.Close())
+	_, err = p.Conn("users.internal:443")
+	assert.Error(t, err)
+}
@@ -0,0 +1,287 @@
+package vertex
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// HopByHopHeaders are headers meant for a single connection, that proxies should have removed. Connection and
+// Upgrade are not included, since websocket handshakes depend on them
+var HopByHopHeaders = []string{"Keep-Alive", "Proxy-Authorization", "Proxy-Connection", "Te", "Trailer"}
+
+// ForwardingHeaders are headers proxies set to describe the original request. Clients can spoof them to fake their
+// address or scheme, so they are only accepted from trusted proxies
+var ForwardingHeaders = []string{
+	"Forwarded",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Port",
+	"X-Forwarded-Proto",
+	"X-Real-Ip",
+	"X-Scheme",
+}
+
+// RequestHeaderPolicy screens the headers of requests to an API before they reach its middleware:
+//
+//	RequestHeaders: &vertex.RequestHeaderPolicy{
+//		Strip:          vertex.HopByHopHeaders,
+//		TrustedProxies: []string{"10.0.0.0/8"},
+//		MaxHeaders:     50,
+//		MaxHeaderSize:  8192,
+//	},
+//
+// Requests breaking the limits are rejected with 431 Request Header Fields Too Large
+type RequestHeaderPolicy struct {
+	// Headers removed from all requests
+	Strip []string
+
+	// Headers requests are rejected for with 400 Bad Request
+	Deny []string
+
+	// Networks in CIDR notation, or single addresses, of the proxies trusted to set forwarding headers. Forwarding
+	// headers of requests from other peers are removed, or rejected if RejectSpoofed is set
+	TrustedProxies []string
+
+	// Reject requests with forwarding headers from untrusted peers with 400 Bad Request, rather than removing them
+	RejectSpoofed bool
+
+	// Maximum number of header fields of a request. 0 means unlimited
+	MaxHeaders int
+
+	// Maximum size of a single header in bytes, its name and values included. 0 means unlimited
+	MaxHeaderSize int
+}
+
+// trustedNetworks parses the trusted proxies of the policy, logging and skipping invalid ones
+func (p *RequestHeaderPolicy) trustedNetworks() []*net.IPNet {
+
+	ret := []*net.IPNet{}
+	for _, proxy := range p.TrustedProxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			logging.Error("Invalid trusted proxy %s: %s", proxy, err)
+			continue
+		}
+		ret = append(ret, network)
+	}
+	return ret
+}
+
+// isTrusted tells if a peer address is in the trusted networks
+func isTrusted(remoteAddr string, trusted []*net.IPNet) bool {
+
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply screens the headers of a request from a peer, removing the stripped headers. It returns the status and reason
+// to reject the request with, or 0 if the request may go on
+func (p *RequestHeaderPolicy) apply(r *http.Request, trusted []*net.IPNet) (int, string) {
+
+	if p.MaxHeaders > 0 && len(r.Header) > p.MaxHeaders {
+		return http.StatusRequestHeaderFieldsTooLarge, fmt.Sprintf("Too many headers, the limit is %d", p.MaxHeaders)
+	}
+	if p.MaxHeaderSize > 0 {
+		for name, values := range r.Header {
+			size := len(name)
+			for _, v := range values {
+				size += len(v)
+			}
+			if size > p.MaxHeaderSize {
+				return http.StatusRequestHeaderFieldsTooLarge, fmt.Sprintf("Header %s is too large", name)
+			}
+		}
+	}
+
+	for _, name := range p.Deny {
+		if _, found := r.Header[http.CanonicalHeaderKey(name)]; found {
+			return http.StatusBadRequest, fmt.Sprintf("Header %s is not allowed", http.CanonicalHeaderKey(name))
+		}
+	}
+
+	if !isTrusted(r.RemoteAddr, trusted) {
+		for _, name := range ForwardingHeaders {
+			if _, found := r.Header[name]; !found {
+				continue
+			}
+			if p.RejectSpoofed {
+				return http.StatusBadRequest, fmt.Sprintf("Header %s is only accepted from trusted proxies", name)
+			}
+			logging.Debug("Removing %s header of untrusted peer %s", name, r.RemoteAddr)
+			r.Header.Del(name)
+		}
+	}
+
+	for _, name := range p.Strip {
+		r.Header.Del(name)
+	}
+	return 0, ""
+}
+
+// essentialHeaders are never dropped to keep responses within the header budget, since clients cannot read the
+// response correctly without them
+var essentialHeaders = map[string]bool{
+	"Content-Type":     true,
+	"Content-Length":   true,
+	"Content-Encoding": true,
+	"Location":         true,
+	"Www-Authenticate": true,
+	"Retry-After":      true,
+	"Vary":             true,
+	"Set-Cookie":       true,
+	HeaderRequestId:    true,
+	HeaderXRequestId:   true,
+}
+
+// responses that went over their header budget
+var headerBudgetOverflows = newCounterMap("vertex.header_budget_overflows")
+
+// ResponseHeaderPolicy is the central place for the headers of an API's responses, rather than having each middleware
+// append headers on its own:
+//
+//	ResponseHeaders: &vertex.ResponseHeaderPolicy{
+//		Defaults: map[string]string{"X-Api-Version": "1.2"},
+//		Remove:   []string{"Server", "X-Powered-By"},
+//		MaxSize:  16 << 10,
+//	},
+type ResponseHeaderPolicy struct {
+	// Headers set on all responses before the middleware runs. Middleware and handlers may override them
+	Defaults map[string]string
+
+	// Headers removed from all responses right before they are written, e.g. server tokens like Server and
+	// X-Powered-By
+	Remove []string
+
+	// Budget of the total size of the response headers in bytes, names and values included. Responses going over it
+	// have their largest headers dropped until they fit, except for essential headers like Content-Type and
+	// Location. Overflows are logged and counted. 0 means unlimited
+	MaxSize int
+}
+
+// headerPolicyWriter applies a response header policy right before the headers are written
+type headerPolicyWriter struct {
+	http.ResponseWriter
+	policy      *ResponseHeaderPolicy
+	route       string
+	wroteHeader bool
+}
+
+// newHeaderPolicyWriter sets the default headers of a policy on a response, and wraps the writer to apply the rest
+// of the policy
+func newHeaderPolicyWriter(w http.ResponseWriter, policy *ResponseHeaderPolicy, route string) *headerPolicyWriter {
+	for k, v := range policy.Defaults {
+		w.Header().Set(k, v)
+	}
+	return &headerPolicyWriter{ResponseWriter: w, policy: policy, route: route}
+}
+
+func (w *headerPolicyWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.apply()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *headerPolicyWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush lets streaming handlers flush through the policy
+func (w *headerPolicyWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets websocket handlers take over the connection through the policy
+func (w *headerPolicyWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("The response writer does not support hijacking")
+}
+
+// apply removes the removed headers, and drops headers until the response is within its budget
+func (w *headerPolicyWriter) apply() {
+
+	h := w.Header()
+	for _, name := range w.policy.Remove {
+		h.Del(name)
+	}
+
+	if w.policy.MaxSize <= 0 {
+		return
+	}
+
+	size := headerSize(h)
+	if size <= w.policy.MaxSize {
+		return
+	}
+
+	logging.Error("Response headers of %s are %d bytes, over the budget of %d bytes", w.route, size, w.policy.MaxSize)
+	headerBudgetOverflows.Add(w.route, "overflows", 1)
+
+	names := make([]string, 0, len(h))
+	for name := range h {
+		if !essentialHeaders[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		si, sj := headerSize(http.Header{names[i]: h[names[i]]}), headerSize(http.Header{names[j]: h[names[j]]})
+		return si > sj || si == sj && names[i] < names[j]
+	})
+
+	for _, name := range names {
+		if size <= w.policy.MaxSize {
+			break
+		}
+		size -= headerSize(http.Header{name: h[name]})
+		h.Del(name)
+		logging.Warning("Dropped response header %s of %s to keep within the header budget", name, w.route)
+	}
+}
+
+// headerSize returns the size of headers on the wire
+func headerSize(h http.Header) int {
+	size := 0
+	for name, values := range h {
+		for _, v := range values {
+			// name, colon, space, value and CRLF
+			size += len(name) + len(v) + 4
+		}
+	}
+	return size
+}
@@ -0,0 +1,142 @@
+package vertex
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestHeaderPolicy(t *testing.T) {
+
+	p := &RequestHeaderPolicy{
+		Strip:          HopByHopHeaders,
+		Deny:           []string{"x-debug"},
+		TrustedProxies: []string{"10.0.0.0/8", "192.0.2.7", "bad"},
+		MaxHeaders:     5,
+		MaxHeaderSize:  100,
+	}
+	trusted := p.trustedNetworks()
+	assert.Len(t, trusted, 2)
+
+	newReq := func(remote string, headers map[string]string) *http.Request {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remote
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		return r
+	}
+
+	// forwarding headers of untrusted peers are removed, hop-by-hop headers of all requests
+	r := newReq("192.0.2.1:1234", map[string]string{"X-Forwarded-For": "1.2.3.4", "X-Forwarded-Proto": "https", "Keep-Alive": "300"})
+	status, _ := p.apply(r, trusted)
+	assert.Equal(t, 0, status)
+	assert.Equal(t, "", r.Header.Get("X-Forwarded-For"))
+	assert.Equal(t, "", r.Header.Get("X-Forwarded-Proto"))
+	assert.Equal(t, "", r.Header.Get("Keep-Alive"))
+
+	r = newReq("10.1.2.3:1234", map[string]string{"X-Forwarded-For": "1.2.3.4"})
+	p.apply(r, trusted)
+	assert.Equal(t, "1.2.3.4", r.Header.Get("X-Forwarded-For"))
+	r = newReq("192.0.2.7:1234", map[string]string{"X-Real-Ip": "1.2.3.4"})
+	p.apply(r, trusted)
+	assert.Equal(t, "1.2.3.4", r.Header.Get("X-Real-Ip"))
+
+	status, _ = p.apply(newReq("10.1.2.3:1", map[string]string{"X-Debug": "1"}), trusted)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = p.apply(newReq("10.1.2.3:1", map[string]string{"X-Big": strings.Repeat("a", 100)}), trusted)
+	assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, status)
+
+	status, reason := p.apply(newReq("10.1.2.3:1", map[string]string{"A": "1", "B": "1", "C": "1", "D": "1", "E": "1", "F": "1"}), trusted)
+	assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, status)
+	assert.Contains(t, reason, "Too many headers")
+
+	p.RejectSpoofed = true
+	status, _ = p.apply(newReq("192.0.2.1:1234", map[string]string{"Forwarded": "for=1.2.3.4"}), trusted)
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+type remoteIPHandler struct{}
+
+func (remoteIPHandler) Handle(w http.ResponseWriter, r *Request) (interface{}, error) {
+	return r.RemoteIP, nil
+}
+
+func TestRequestHeaderPolicyAPI(t *testing.T) {
+
+	a := &API{
+		Name:           "headerpolicy",
+		Version:        "1.0",
+		Root:           "/headerpolicy",
+		Renderer:       JSONRenderer{},
+		AllowInsecure:  true,
+		RequestHeaders: &RequestHeaderPolicy{Deny: []string{"X-Debug"}},
+		Routes: Routes{
+			{Path: "/ip", Description: "IP", Methods: GET, Handler: remoteIPHandler{}},
+		},
+	}
+	srv := NewServer(":9979")
+	srv.AddAPI(a)
+
+	hr := httptest.NewRequest("GET", a.FullPath("/ip"), nil)
+	hr.RemoteAddr = "192.0.2.1:1234"
+	hr.Header.Set("X-Forwarded-For", "1.2.3.4")
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, hr)
+	assert.Equal(t, `"192.0.2.1"`, w.Body.String())
+
+	hr.Header.Set("X-Debug", "1")
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, hr)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, int64(1), ErrorCount(a.FullPath("/ip"), SecurityError))
+}
+
+type headerHandler struct{}
+
+func (headerHandler) Handle(w http.ResponseWriter, r *Request) (interface{}, error) {
+	w.Header().Set("Server", "vertex/1.0")
+	w.Header().Set("X-Api-Build", "override")
+	w.Header().Set("X-Debug-Trace", strings.Repeat("a", 200))
+	w.Header().Set("Location", strings.Repeat("b", 100))
+	return "ok", nil
+}
+
+func TestResponseHeaderPolicy(t *testing.T) {
+
+	a := &API{
+		Name:          "respheaders",
+		Version:       "1.0",
+		Root:          "/respheaders",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		ResponseHeaders: &ResponseHeaderPolicy{
+			Defaults: map[string]string{"X-Api-Name": "respheaders", "X-Api-Build": "1"},
+			Remove:   []string{"Server"},
+			MaxSize:  300,
+		},
+		Routes: Routes{
+			{Path: "/item", Description: "Item", Methods: GET, Handler: headerHandler{}},
+		},
+	}
+	srv := NewServer(":9980")
+	srv.AddAPI(a)
+
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest("GET", a.FullPath("/item"), nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "respheaders", w.Header().Get("X-Api-Name"))
+	assert.Equal(t, "override", w.Header().Get("X-Api-Build"))
+	assert.Equal(t, "", w.Header().Get("Server"))
+
+	// the largest headers are dropped to keep within the budget, but not essential ones
+	assert.Equal(t, "", w.Header().Get("X-Debug-Trace"))
+	assert.NotEmpty(t, w.Header().Get("Location"))
+	assert.NotEmpty(t, w.Header().Get("Content-Type"))
+	assert.True(t, headerSize(w.Header()) <= 300)
+	assert.Equal(t, int64(1), headerBudgetOverflows.Value(a.FullPath("/item"), "overflows"))
+}
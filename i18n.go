@@ -0,0 +1,169 @@
+package vertex
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Catalog holds the messages of an API by locale and message key. Handlers return localizable responses (see
+// Localizable and Message) and errors with message keys, and they are rendered in the locale of the request:
+//
+//	catalog := vertex.NewCatalog().
+//		Add("en", map[string]string{"greeting": "Hello %s", "errors.no_such_user": "No such user"}).
+//		Add("fr", map[string]string{"greeting": "Bonjour %s", "errors.no_such_user": "Utilisateur inconnu"})
+//
+//	func (h HelloHandler) Handle(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+//		user, found := users[h.Id]
+//		if !found {
+//			return nil, vertex.InvalidParamError("errors.no_such_user")
+//		}
+//		return vertex.Msg("greeting", user.Name), nil
+//	}
+type Catalog struct {
+	mtx      sync.RWMutex
+	messages map[string]map[string]string
+}
+
+// NewCatalog creates a new empty message catalog
+func NewCatalog() *Catalog {
+	return &Catalog{
+		messages: map[string]map[string]string{},
+	}
+}
+
+// Add adds messages of a locale, as fmt format strings by message key
+func (c *Catalog) Add(locale string, messages map[string]string) *Catalog {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	locale = strings.ToLower(locale)
+	if c.messages[locale] == nil {
+		c.messages[locale] = map[string]string{}
+	}
+	for k, v := range messages {
+		c.messages[locale][k] = v
+	}
+	return c
+}
+
+// fallbacks returns the locales a message is looked up in: the locale, its language (pt-BR => pt) and the default
+// locale and its language
+func fallbacks(locale string) []string {
+
+	ret := []string{}
+	for _, l := range []string{locale, DefaultLocale} {
+		l = strings.ToLower(l)
+		ret = append(ret, l)
+		if i := strings.IndexAny(l, "-_"); i > 0 {
+			ret = append(ret, l[:i])
+		}
+	}
+	return ret
+}
+
+// Lookup returns the message of a key in a locale, falling back to the locale's language and the default locale.
+// It returns false if the key is not found
+func (c *Catalog) Lookup(locale, key string) (string, bool) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	for _, l := range fallbacks(locale) {
+		if msg, found := c.messages[l][key]; found {
+			return msg, true
+		}
+	}
+	return "", false
+}
+
+// Match returns the catalog locale serving the messages of a locale, falling back like Lookup, and false if the
+// catalog has no messages for the locale, its language or the default locale
+func (c *Catalog) Match(locale string) (string, bool) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	for _, l := range fallbacks(locale) {
+		if len(c.messages[l]) > 0 {
+			return l, true
+		}
+	}
+	return "", false
+}
+
+// Translate formats the message of a key in a locale with args. Keys not found in the catalog are returned as is
+func (c *Catalog) Translate(locale, key string, args ...interface{}) string {
+
+	msg, found := c.Lookup(locale, key)
+	if !found {
+		return key
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// Translator translates message keys to a locale
+type Translator func(key string, args ...interface{}) string
+
+// Localizable is implemented by responses with localized content. Localize is called before rendering the
+// response with a translator to the locale of the request, and returns the value to render instead
+type Localizable interface {
+	Localize(tr Translator) interface{}
+}
+
+// Message is a localizable message key with format args, rendered as the message in the request's locale
+type Message struct {
+	Key  string
+	Args []interface{}
+}
+
+// Msg creates a localizable message
+func Msg(key string, args ...interface{}) Message {
+	return Message{Key: key, Args: args}
+}
+
+// Localize translates the message
+func (m Message) Localize(tr Translator) interface{} {
+	return tr(m.Key, m.Args...)
+}
+
+// Translate translates a message key to the locale of the request, using the catalog of the request's API. Keys
+// are returned as is if the API has no catalog
+func (r *Request) Translate(key string, args ...interface{}) string {
+
+	if r.api == nil || r.api.Catalog == nil {
+		if len(args) > 0 {
+			return fmt.Sprintf(key, args...)
+		}
+		return key
+	}
+	return r.api.Catalog.Translate(r.Locale, key, args...)
+}
+
+// localize resolves a localizable response in the locale of the request
+func localize(r *Request, v interface{}) interface{} {
+	if l, ok := v.(Localizable); ok {
+		return l.Localize(r.Translate)
+	}
+	return v
+}
+
+// localizeError translates the message of an error if it is a key of the catalog of the request's API. The error
+// is copied, since errors may be shared
+func localizeError(r *Request, err error) error {
+
+	e, ok := err.(*internalError)
+	if !ok || r.api == nil || r.api.Catalog == nil {
+		return err
+	}
+
+	msg, found := r.api.Catalog.Lookup(r.Locale, e.Message)
+	if !found {
+		return err
+	}
+
+	localized := *e
+	localized.Message = msg
+	return &localized
+}
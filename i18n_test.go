@@ -0,0 +1,95 @@
+package vertex
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCatalog(t *testing.T) {
+
+	c := NewCatalog().
+		Add("en", map[string]string{"greeting": "Hello %s", "bye": "Bye"}).
+		Add("fr", map[string]string{"greeting": "Bonjour %s"}).
+		Add("pt-BR", map[string]string{"greeting": "Olá %s"})
+
+	assert.Equal(t, "Bonjour Bob", c.Translate("fr-CA", "greeting", "Bob"))
+	assert.Equal(t, "Olá Bob", c.Translate("pt-BR", "greeting", "Bob"))
+	assert.Equal(t, "Hello Bob", c.Translate("pt-PT", "greeting", "Bob"))
+	assert.Equal(t, "Bye", c.Translate("fr", "bye"))
+	assert.Equal(t, "nope", c.Translate("fr", "nope"))
+
+	_, found := c.Lookup("fr", "nope")
+	assert.False(t, found)
+}
+
+type greeting struct {
+	Text string `json:"text"`
+	Name string `json:"name"`
+}
+
+func (g greeting) Localize(tr Translator) interface{} {
+	g.Text = tr(g.Text, g.Name)
+	return g
+}
+
+func TestLocalization(t *testing.T) {
+
+	a := &API{
+		Name:          "i18n",
+		Version:       "1.0",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Catalog: NewCatalog().
+			Add("en", map[string]string{"greeting": "Hello %s", "errors.no_user": "No such user"}).
+			Add("fr", map[string]string{"greeting": "Bonjour %s", "errors.no_user": "Utilisateur inconnu"}),
+		Routes: Routes{
+			{
+				Path:        "/msg",
+				Description: "Message",
+				Methods:     GET,
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					return Msg("greeting", "Bob"), nil
+				}),
+			},
+			{
+				Path:        "/struct",
+				Description: "Struct",
+				Methods:     GET,
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					return greeting{Text: "greeting", Name: "Bob"}, nil
+				}),
+			},
+			{
+				Path:        "/error",
+				Description: "Error",
+				Methods:     GET,
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					return nil, InvalidParamError("errors.no_user")
+				}),
+			},
+		},
+	}
+	srv := NewServer(":9969")
+	srv.AddAPI(a)
+
+	call := func(path, lang string) *httptest.ResponseRecorder {
+		hr, _ := http.NewRequest("GET", a.FullPath(path), nil)
+		hr.Header.Set("Accept-Language", lang)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, hr)
+		return w
+	}
+
+	assert.Equal(t, `"Bonjour Bob"`, call("/msg", "fr-FR,fr;q=0.9").Body.String())
+	assert.Equal(t, `"Hello Bob"`, call("/msg", "").Body.String())
+	assert.Equal(t, `"Hello Bob"`, call("/msg", "de").Body.String())
+	assert.Equal(t, `{"text":"Bonjour Bob","name":"Bob"}`, call("/struct", "fr").Body.String())
+
+	w := call("/error", "fr")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "Utilisateur inconnu\n", w.Body.String())
+	assert.Equal(t, "No such user\n", call("/error", "en-GB").Body.String())
+}
@@ -0,0 +1,130 @@
+package vertex
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// InflightPath is the admin endpoint dumping the requests currently being handled, oldest first
+const InflightPath = "/debug/vertex/inflight"
+
+// inflightRequest is a request tracked by the in-flight registry
+type inflightRequest struct {
+	Id         string    `json:"id"`
+	Route      string    `json:"route"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	RemoteAddr string    `json:"remote_addr"`
+	Principal  string    `json:"principal,omitempty"`
+	Start      time.Time `json:"start"`
+	Age        string    `json:"age"`
+}
+
+func (r *inflightRequest) setPrincipal(id string) {
+	inflight.Lock()
+	defer inflight.Unlock()
+	r.Principal = id
+}
+
+// inflight is the registry of the requests currently being handled. It tracks up to
+// Config.Server.MaxTrackedRequests requests, and only counts the ones beyond that
+var inflight = struct {
+	sync.Mutex
+	requests  map[*inflightRequest]struct{}
+	untracked int64
+}{
+	requests: map[*inflightRequest]struct{}{},
+}
+
+func init() {
+	expvar.Publish("vertex.inflight", expvar.Func(func() interface{} {
+		return inflightCount()
+	}))
+}
+
+// inflightCount returns the number of requests currently being handled, tracked or not
+func inflightCount() int64 {
+	inflight.Lock()
+	defer inflight.Unlock()
+	return int64(len(inflight.requests)) + inflight.untracked
+}
+
+// trackRequest adds a request to the in-flight registry, and returns a function removing it when the request is done
+func trackRequest(r *Request) func() {
+
+	inflight.Lock()
+	defer inflight.Unlock()
+
+	if len(inflight.requests) >= Config.Server.MaxTrackedRequests {
+		inflight.untracked++
+		return func() {
+			inflight.Lock()
+			inflight.untracked--
+			inflight.Unlock()
+		}
+	}
+
+	entry := &inflightRequest{
+		Id:         r.RequestId,
+		Route:      r.route,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		RemoteAddr: r.RemoteAddr,
+		Principal:  r.PrincipalID(),
+		Start:      r.StartTime,
+	}
+	inflight.requests[entry] = struct{}{}
+	r.inflight = entry
+
+	return func() {
+		inflight.Lock()
+		delete(inflight.requests, entry)
+		inflight.Unlock()
+	}
+}
+
+// inflightDump is a snapshot of the in-flight registry
+type inflightDump struct {
+	Total     int               `json:"total"`
+	Untracked int64             `json:"untracked"`
+	Requests  []inflightRequest `json:"requests"`
+}
+
+// dumpInflight returns a snapshot of the requests currently being handled, oldest first
+func dumpInflight() inflightDump {
+
+	inflight.Lock()
+	defer inflight.Unlock()
+
+	now := time.Now()
+	ret := inflightDump{
+		Untracked: inflight.untracked,
+		Requests:  make([]inflightRequest, 0, len(inflight.requests)),
+	}
+	for r := range inflight.requests {
+		entry := *r
+		entry.Age = now.Sub(r.Start).String()
+		ret.Requests = append(ret.Requests, entry)
+	}
+	ret.Total = len(ret.Requests) + int(ret.Untracked)
+
+	sort.Slice(ret.Requests, func(i, j int) bool { return ret.Requests[i].Start.Before(ret.Requests[j].Start) })
+	return ret
+}
+
+// inflightHandler dumps the in-flight registry as JSON
+func inflightHandler(w http.ResponseWriter, r *http.Request) {
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(dumpInflight()); err != nil {
+		logging.Error("Could not dump in-flight requests: %s", err)
+	}
+}
@@ -0,0 +1,81 @@
+package vertex
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInflightRegistry(t *testing.T) {
+
+	release := make(chan struct{})
+	a := &API{
+		Name:          "inflight",
+		Version:       "1.0",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{
+				Path:    "/stuck",
+				Methods: GET,
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					r.SetPrincipal(&Principal{ID: "alice"})
+					<-release
+					return "ok", nil
+				}),
+			},
+		},
+	}
+
+	srv := NewServer(":9954")
+	srv.AddAPI(a)
+
+	done := make(chan struct{})
+	go func() {
+		req, _ := http.NewRequest("GET", a.FullPath("/stuck"), nil)
+		srv.Handler().ServeHTTP(httptest.NewRecorder(), req)
+		close(done)
+	}()
+
+	find := func() *inflightRequest {
+		for _, r := range dumpInflight().Requests {
+			if r.Route == a.FullPath("/stuck") {
+				return &r
+			}
+		}
+		return nil
+	}
+
+	var r *inflightRequest
+	for i := 0; i < 100 && (r == nil || r.Principal == ""); i++ {
+		time.Sleep(5 * time.Millisecond)
+		r = find()
+	}
+
+	if assert.NotNil(t, r) {
+		assert.Equal(t, "GET", r.Method)
+		assert.Equal(t, "alice", r.Principal)
+		assert.NotEmpty(t, r.Age)
+	}
+
+	w := httptest.NewRecorder()
+	inflightHandler(w, nil)
+	assert.Contains(t, w.Body.String(), a.FullPath("/stuck"))
+
+	close(release)
+	<-done
+	assert.Nil(t, find())
+
+	// requests beyond the limit are only counted
+	defer func(max int) { Config.Server.MaxTrackedRequests = max }(Config.Server.MaxTrackedRequests)
+	Config.Server.MaxTrackedRequests = 0
+
+	hr, _ := http.NewRequest("GET", "/foo", nil)
+	untrack := trackRequest(NewRequest(hr))
+	assert.EqualValues(t, 1, dumpInflight().Untracked)
+	untrack()
+	assert.EqualValues(t, 0, dumpInflight().Untracked)
+}
@@ -0,0 +1,267 @@
+package vertex
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"code.google.com/p/go-uuid/uuid"
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// Queue is the queue requests of ingestion routes are pushed to. ChanQueue is an in-process implementation;
+// implementations backed by redis, kafka etc. let other instances or services consume the requests.
+//
+// Implementations must be comparable (e.g. pointers), since routes sharing a queue share its consumers
+type Queue interface {
+	// Push enqueues a message. It is called while handling a request, so it must never block for long - if the
+	// queue is full it should fail right away
+	Push(msg []byte) error
+
+	// Consume calls handle for queued messages until the context is canceled. It is called concurrently by all the
+	// consumers of the queue. Messages whose handling fails may be redelivered
+	Consume(ctx context.Context, handle func(msg []byte) error) error
+}
+
+// IngestMessage is a request accepted by an ingestion route, as it is pushed to the queue
+type IngestMessage struct {
+	ID        string          `json:"id"`
+	Route     string          `json:"route"`
+	RequestId string          `json:"request_id"`
+	Principal string          `json:"principal,omitempty"`
+	Received  time.Time       `json:"received"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// Ingester is implemented by the request handlers of ingestion routes. The handler's Handle method is called while
+// handling the request, to validate it beyond the field tags - returning an error rejects the request. If it
+// succeeds, the bound handler is queued and the request is answered with 202 Accepted right away. A consumer later
+// decodes the handler from the queue and calls Ingest.
+//
+// Ingest is called with a context that is not canceled when the server stops, so messages drained from the queue
+// on shutdown are still processed within the shutdown grace period
+type Ingester interface {
+	Ingest(ctx context.Context, msg *IngestMessage) error
+}
+
+// IngestReceipt is the response of ingestion routes, rendered with a 202 Accepted status
+type IngestReceipt struct {
+	ID string `json:"id"`
+}
+
+// route => enqueued/rejected/consumed/failed => count
+var ingestMetrics = newCounterMap("vertex.ingest")
+
+// ChanQueue is an in-process Queue backed by a buffered channel. Messages still queued when the server stops are
+// drained by the consumers before they stop
+type ChanQueue struct {
+	ch chan []byte
+}
+
+// NewChanQueue creates a channel queue holding up to size messages
+func NewChanQueue(size int) *ChanQueue {
+	return &ChanQueue{
+		ch: make(chan []byte, size),
+	}
+}
+
+// Push enqueues a message, failing with a 503 if the queue is full
+func (q *ChanQueue) Push(msg []byte) error {
+	select {
+	case q.ch <- msg:
+		return nil
+	default:
+		return TemporarilyUnavailableError(time.Second, "Ingestion queue is full")
+	}
+}
+
+// Consume handles queued messages until the context is canceled, and then drains the queue
+func (q *ChanQueue) Consume(ctx context.Context, handle func(msg []byte) error) error {
+	for {
+		select {
+		case msg := <-q.ch:
+			handle(msg)
+		case <-ctx.Done():
+			for {
+				select {
+				case msg := <-q.ch:
+					handle(msg)
+				default:
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// Len returns the number of queued messages
+func (q *ChanQueue) Len() int {
+	return len(q.ch)
+}
+
+// ingestRoute is an ingestion route of an API, collected when the API is configured
+type ingestRoute struct {
+	path      string
+	queue     Queue
+	handler   reflect.Type
+	injected  []injection
+	consumers int
+}
+
+// enqueue validates a request with its bound handler, and pushes the handler to the route's queue
+func enqueue(w http.ResponseWriter, r *Request, queue Queue, handler RequestHandler, injected []injection) (interface{}, error) {
+
+	if _, err := handler.Handle(w, r); err != nil {
+		return nil, err
+	}
+
+	// dependencies are injected again when the request is consumed
+	v := reflect.ValueOf(handler).Elem()
+	for _, f := range injected {
+		v.Field(f.field).Set(reflect.Zero(v.Field(f.field).Type()))
+	}
+
+	payload, err := json.Marshal(handler)
+	if err != nil {
+		return nil, NewErrorf("Could not encode request: %s", err)
+	}
+
+	msg := IngestMessage{
+		ID:        uuid.New(),
+		Route:     r.route,
+		RequestId: r.RequestId,
+		Principal: r.PrincipalID(),
+		Received:  r.StartTime,
+		Payload:   payload,
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, NewErrorf("Could not encode request: %s", err)
+	}
+
+	if err := queue.Push(data); err != nil {
+		logging.Error("Could not enqueue request %s: %s", r, err)
+		ingestMetrics.Add(r.route, "rejected", 1)
+		if _, ok := err.(*internalError); ok {
+			return nil, err
+		}
+		return nil, TemporarilyUnavailableError(time.Second, "Could not enqueue request")
+	}
+
+	ingestMetrics.Add(r.route, "enqueued", 1)
+	return &IngestReceipt{ID: msg.ID}, nil
+}
+
+// ingestConsumer consumes a queue shared by one or more ingestion routes, dispatching messages to the handler
+// types of their routes
+type ingestConsumer struct {
+	queue     Queue
+	mtx       sync.RWMutex
+	routes    map[string]ingestRoute
+	consumers int
+}
+
+func newIngestConsumer(queue Queue) *ingestConsumer {
+	return &ingestConsumer{
+		queue:     queue,
+		routes:    map[string]ingestRoute{},
+		consumers: 1,
+	}
+}
+
+func (c *ingestConsumer) addRoute(r ingestRoute) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.routes[r.path] = r
+	if r.consumers > c.consumers {
+		c.consumers = r.consumers
+	}
+}
+
+// Run runs the consumers of the queue
+func (c *ingestConsumer) Run(ctx context.Context) error {
+
+	c.mtx.RLock()
+	n := c.consumers
+	c.mtx.RUnlock()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.queue.Consume(ctx, c.handle); err != nil {
+				logging.Error("Ingestion consumer failed: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// handle decodes a queued message into a new instance of its route's handler and ingests it
+func (c *ingestConsumer) handle(data []byte) (err error) {
+
+	var msg IngestMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return logging.Errorf("Could not decode queued message: %s", err)
+	}
+
+	c.mtx.RLock()
+	route, found := c.routes[msg.Route]
+	c.mtx.RUnlock()
+	if !found {
+		return logging.Errorf("No ingestion route %s for message %s", msg.Route, msg.ID)
+	}
+
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("PANIC ingesting message %s: %v", msg.ID, e)
+		}
+		if err != nil {
+			logging.Error("Error ingesting message %s of request %s: %s", msg.ID, msg.RequestId, err)
+			ingestMetrics.Add(msg.Route, "failed", 1)
+		} else {
+			ingestMetrics.Add(msg.Route, "consumed", 1)
+		}
+	}()
+
+	handler := reflect.New(route.handler).Interface()
+	if err := json.Unmarshal(msg.Payload, handler); err != nil {
+		return fmt.Errorf("Could not decode payload: %s", err)
+	}
+	if err := inject(handler, route.injected); err != nil {
+		return err
+	}
+
+	return handler.(Ingester).Ingest(context.Background(), &msg)
+}
+
+// addIngestRoutes starts consuming the queues of an API's ingestion routes as workers of the server
+func (s *Server) addIngestRoutes(a *API) {
+
+	for _, r := range a.ingestRoutes {
+		c, found := s.ingest[r.queue]
+		if !found {
+			if s.ingest == nil {
+				s.ingest = map[Queue]*ingestConsumer{}
+			}
+			c = newIngestConsumer(r.queue)
+			s.ingest[r.queue] = c
+			s.AddWorker("ingest:"+r.path, c)
+		}
+		c.addRoute(r)
+	}
+}
+
+// IngestStats returns the number of requests to an ingestion route that were enqueued, rejected because the queue
+// was unavailable, consumed successfully and failed when consumed
+func IngestStats(route string) (enqueued, rejected, consumed, failed int64) {
+	return ingestMetrics.Value(route, "enqueued"), ingestMetrics.Value(route, "rejected"),
+		ingestMetrics.Value(route, "consumed"), ingestMetrics.Value(route, "failed")
+}
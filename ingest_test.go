@@ -0,0 +1,101 @@
+package vertex
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type eventIngester struct {
+	Name  string `schema:"name" required:"true"`
+	Value int    `schema:"value"`
+}
+
+var ingested = make(chan eventIngester, 10)
+
+func (h eventIngester) Handle(w http.ResponseWriter, r *Request) (interface{}, error) {
+	if h.Value < 0 {
+		return nil, InvalidParamError("Negative value")
+	}
+	return nil, nil
+}
+
+func (h eventIngester) Ingest(ctx context.Context, msg *IngestMessage) error {
+	if h.Name == "bad" {
+		return errors.New("bad event")
+	}
+	ingested <- h
+	return nil
+}
+
+func TestIngestRoutes(t *testing.T) {
+
+	queue := NewChanQueue(2)
+	a := &API{
+		Name:          "ingest",
+		Version:       "1.0",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{
+				Path:      "/events",
+				Methods:   POST,
+				Handler:   eventIngester{},
+				Queue:     queue,
+				Consumers: 2,
+			},
+		},
+	}
+
+	srv := NewServer(":9959")
+	srv.AddAPI(a)
+
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		hr, _ := http.NewRequest("POST", "/ingest/1.0/events", strings.NewReader(form.Encode()))
+		hr.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, hr)
+		return w
+	}
+
+	// requests are validated and accepted without being processed
+	w := post(url.Values{"name": {"foo"}, "value": {"3"}})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var receipt IngestReceipt
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &receipt))
+	assert.NotEmpty(t, receipt.ID)
+	assert.Equal(t, http.StatusBadRequest, post(url.Values{"value": {"3"}}).Code)
+	assert.Equal(t, http.StatusBadRequest, post(url.Values{"name": {"foo"}, "value": {"-1"}}).Code)
+	assert.Equal(t, 1, queue.Len())
+
+	// a full queue fails right away
+	assert.Equal(t, http.StatusAccepted, post(url.Values{"name": {"bad"}}).Code)
+	assert.Equal(t, http.StatusServiceUnavailable, post(url.Values{"name": {"bar"}}).Code)
+
+	// consumers process the queue while the server runs, and drain it when it stops
+	srv.workers.start()
+	select {
+	case e := <-ingested:
+		assert.Equal(t, eventIngester{Name: "foo", Value: 3}, e)
+	case <-time.After(time.Second):
+		t.Fatal("Request was not ingested")
+	}
+
+	srv.workers.stop(time.Second)
+	assert.Equal(t, 0, queue.Len())
+
+	route := a.FullPath("/events")
+	enqueued, rejected, consumed, failed := IngestStats(route)
+	assert.Equal(t, int64(2), enqueued)
+	assert.Equal(t, int64(1), rejected)
+	assert.Equal(t, int64(1), consumed)
+	assert.Equal(t, int64(1), failed)
+}
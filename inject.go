@@ -0,0 +1,86 @@
+package vertex
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/dvirsky/go-pylog/logging"
+
+	"github.com/EverythingMe/vertex/schema"
+)
+
+// InjectTag is the struct tag of request handler fields set to dependencies registered with Provide
+const InjectTag = schema.InjectTag
+
+var dependencies = struct {
+	sync.RWMutex
+	m map[string]interface{}
+}{
+	m: map[string]interface{}{},
+}
+
+// Provide registers a named dependency, e.g. a database or a publisher, for injection into request handlers.
+// Handler fields tagged with the dependency's name are set to it before the handler is called:
+//
+//	type AddUserHandler struct {
+//		Name   string            `schema:"name" required:"true"`
+//		Events *vertex.Publisher `inject:"events"`
+//	}
+//
+// Injected fields are not request params - they are left out of the documentation, and set after the request is
+// bound
+func Provide(name string, v interface{}) {
+	dependencies.Lock()
+	defer dependencies.Unlock()
+	dependencies.m[name] = v
+}
+
+// injection is a handler field set to a dependency
+type injection struct {
+	field int
+	name  string
+}
+
+// injections returns the injected fields of a handler type
+func injections(T reflect.Type) []injection {
+
+	if T.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var ret []injection
+	for i := 0; i < T.NumField(); i++ {
+		if name := T.Field(i).Tag.Get(InjectTag); name != "" {
+			ret = append(ret, injection{field: i, name: name})
+		}
+	}
+	return ret
+}
+
+// inject sets the injected fields of a handler instance. handler must be a pointer to a struct
+func inject(handler interface{}, fields []injection) error {
+
+	if len(fields) == 0 {
+		return nil
+	}
+
+	v := reflect.ValueOf(handler).Elem()
+
+	dependencies.RLock()
+	defer dependencies.RUnlock()
+
+	for _, f := range fields {
+		dep, found := dependencies.m[f.name]
+		if !found {
+			return logging.Errorf("Dependency %s was not provided", f.name)
+		}
+
+		field := v.Field(f.field)
+		dv := reflect.ValueOf(dep)
+		if !dv.Type().AssignableTo(field.Type()) {
+			return logging.Errorf("Dependency %s is a %s, not assignable to %s", f.name, dv.Type(), field.Type())
+		}
+		field.Set(dv)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package vertex
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/EverythingMe/vertex/schema"
+)
+
+type greeter struct {
+	greeting string
+}
+
+type injectedHandler struct {
+	Name    string   `schema:"name"`
+	Greeter *greeter `inject:"test.greeter"`
+}
+
+func (h injectedHandler) Handle(w http.ResponseWriter, r *Request) (interface{}, error) {
+	return h.Greeter.greeting + " " + h.Name, nil
+}
+
+func TestInject(t *testing.T) {
+
+	T := reflect.TypeOf(injectedHandler{})
+	fields := injections(T)
+	assert.Len(t, fields, 1)
+
+	// injected fields are not params
+	ri, err := schema.NewRequestInfo(T, "/greet", "", nil)
+	assert.NoError(t, err)
+	assert.Len(t, ri.Params, 1)
+
+	h := &injectedHandler{}
+	assert.Error(t, inject(h, fields))
+
+	Provide("test.greeter", "not a greeter")
+	assert.Error(t, inject(h, fields))
+
+	Provide("test.greeter", &greeter{greeting: "hello"})
+	assert.NoError(t, inject(h, fields))
+	assert.Equal(t, "hello", h.Greeter.greeting)
+
+	a := &API{
+		Name:          "inject",
+		Version:       "1.0",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{
+				Path:    "/greet",
+				Methods: GET,
+				Handler: injectedHandler{},
+			},
+		},
+	}
+	srv := NewServer(":9961")
+	srv.AddAPI(a)
+
+	hr, _ := http.NewRequest("GET", "/inject/1.0/greet?name=world", nil)
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, hr)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "hello world")
+}
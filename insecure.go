@@ -0,0 +1,95 @@
+package vertex
+
+import (
+	"net/http"
+	"path"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// InsecureAction is what to do with a request that arrived over plain http to an API that does not allow insecure
+// access
+type InsecureAction int
+
+const (
+	// Deny the request with a 403
+	InsecureReject InsecureAction = iota
+
+	// Redirect the client to the same URL over https
+	InsecureRedirect
+
+	// Serve the request anyway
+	InsecureAllow
+)
+
+// InsecurePolicy decides what to do with plain http requests to an API that does not allow insecure access.
+// The default policy is Reject
+type InsecurePolicy func(r *Request) InsecureAction
+
+// Reject denies all plain http requests
+var Reject InsecurePolicy = func(r *Request) InsecureAction {
+	return InsecureReject
+}
+
+// RedirectToHTTPS redirects all plain http requests to https
+var RedirectToHTTPS InsecurePolicy = func(r *Request) InsecureAction {
+	return InsecureRedirect
+}
+
+// AllowForPaths allows plain http requests to paths matching any of the given globs (see path.Match), and rejects
+// all others. Globs are matched against the full request path, e.g. "/myapi/1.0/health*".
+//
+// This is useful for health check infrastructure that can only probe over plain http
+func AllowForPaths(globs ...string) InsecurePolicy {
+
+	for _, glob := range globs {
+		if _, err := path.Match(glob, ""); err != nil {
+			logging.Error("Invalid insecure path glob %s: %s", glob, err)
+		}
+	}
+
+	return func(r *Request) InsecureAction {
+		for _, glob := range globs {
+			if matched, _ := path.Match(glob, r.URL.Path); matched {
+				return InsecureAllow
+			}
+		}
+		return InsecureReject
+	}
+}
+
+// redirectToHTTPS redirects a request to the same URL over https
+func redirectToHTTPS(w http.ResponseWriter, r *Request) {
+
+	target := "https://" + r.Host + r.URL.RequestURI()
+
+	// GET and HEAD can be safely redirected with a 301, other methods must be told to keep the method and body
+	status := http.StatusPermanentRedirect
+	if r.Method == "GET" || r.Method == "HEAD" {
+		status = http.StatusMovedPermanently
+	}
+
+	http.Redirect(w, r.Request, target, status)
+}
+
+// checkInsecure applies the API's insecure access policy to a plain http request. It returns false if the request
+// was rejected or redirected, and the response was already written
+func (a *API) checkInsecure(w http.ResponseWriter, r *Request, routePath string) bool {
+
+	policy := a.InsecurePolicy
+	if policy == nil {
+		policy = Reject
+	}
+
+	switch policy(r) {
+	case InsecureAllow:
+		return true
+	case InsecureRedirect:
+		redirectToHTTPS(w, r)
+		return false
+	default:
+		countErrorClass(routePath, SecurityError)
+		http.Error(w, insecureAccessMessage, http.StatusForbidden)
+		return false
+	}
+}
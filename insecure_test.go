@@ -0,0 +1,57 @@
+package vertex
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInsecurePolicy(t *testing.T) {
+
+	a := &API{
+		Name:     "insecure",
+		Version:  "1.0",
+		Renderer: JSONRenderer{},
+		Routes: Routes{
+			{
+				Path:    "/health",
+				Methods: GET | POST,
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					return "ok", nil
+				}),
+			},
+			{
+				Path:    "/data",
+				Methods: GET,
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					return "data", nil
+				}),
+			},
+		},
+	}
+
+	srv := NewServer(":9955")
+	srv.AddAPI(a)
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, "http://example.com"+a.FullPath(path)+"?foo=bar", nil)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, req)
+		return w
+	}
+
+	// the default policy rejects
+	assert.Equal(t, http.StatusForbidden, do("GET", "/health").Code)
+
+	a.InsecurePolicy = RedirectToHTTPS
+	w := do("GET", "/data")
+	assert.Equal(t, http.StatusMovedPermanently, w.Code)
+	assert.Equal(t, "https://example.com"+a.FullPath("/data")+"?foo=bar", w.Header().Get("Location"))
+	assert.Equal(t, http.StatusPermanentRedirect, do("POST", "/health").Code)
+
+	a.InsecurePolicy = AllowForPaths(a.FullPath("/heal*"))
+	assert.Equal(t, http.StatusOK, do("GET", "/health").Code)
+	assert.Equal(t, http.StatusForbidden, do("GET", "/data").Code)
+}
@@ -0,0 +1,332 @@
+package vertex
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// Key is a versioned secret key of a KeyRing
+type Key struct {
+	// ID identifies the key version, and is embedded in signed data where the format allows it, e.g. as a JWT kid
+	ID      string
+	Secret  []byte
+	Created time.Time
+}
+
+// MinKeySize is the minimum size of key secrets. Shorter secrets, and empty ones in particular, would let anyone forge
+// the signatures made with them
+const MinKeySize = 32
+
+// validate checks that the key's secret is long enough to sign and encrypt with
+func (key Key) validate() error {
+	if len(key.Secret) < MinKeySize {
+		return fmt.Errorf("Secret of key %s is %d bytes long, at least %d are required", key.ID, len(key.Secret), MinKeySize)
+	}
+	return nil
+}
+
+// KeySource loads the keys of a key ring from an external store, newest key first
+type KeySource interface {
+	LoadKeys(ring string) ([]Key, error)
+}
+
+// KeyRing holds the versioned keys of one purpose, e.g. session cookies or token signing. New data is always
+// protected with the primary (newest) key, while older keys are kept to read data protected before a rotation.
+//
+// Key rings are registered by name, so crypto-using components and configs can reference them:
+//
+//	ring, err := vertex.LoadKeyRing("sessions", vertex.EnvKeySource{})
+//	...
+//	vertex.RegisterKeyRing(ring)
+//
+//	cookie := vertex.Cookie{Name: "session", Encrypted: true, KeyRing: vertex.GetKeyRing("sessions")}
+type KeyRing struct {
+	name   string
+	source KeySource
+
+	mtx  sync.RWMutex
+	keys []Key
+}
+
+// NewKeyRing creates a key ring from static keys, newest key first. It panics if a secret is shorter than MinKeySize
+func NewKeyRing(name string, keys ...Key) *KeyRing {
+	for _, key := range keys {
+		if err := key.validate(); err != nil {
+			panic(fmt.Sprintf("Invalid key for key ring %s: %s", name, err))
+		}
+	}
+	return &KeyRing{
+		name: name,
+		keys: keys,
+	}
+}
+
+// LoadKeyRing creates a key ring loaded from a key source. The ring can later be reloaded from the source to pick up
+// rotated keys
+func LoadKeyRing(name string, source KeySource) (*KeyRing, error) {
+
+	k := &KeyRing{
+		name:   name,
+		source: source,
+	}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Name returns the name of the key ring
+func (k *KeyRing) Name() string {
+	return k.name
+}
+
+// Reload reloads the keys from the ring's source
+func (k *KeyRing) Reload() error {
+
+	if k.source == nil {
+		return nil
+	}
+
+	keys, err := k.source.LoadKeys(k.name)
+	if err != nil {
+		return logging.Errorf("Could not load keys of key ring %s: %s", k.name, err)
+	}
+	if len(keys) == 0 {
+		return logging.Errorf("No keys found for key ring %s", k.name)
+	}
+	for _, key := range keys {
+		if err := key.validate(); err != nil {
+			return logging.Errorf("Invalid key for key ring %s: %s", k.name, err)
+		}
+	}
+
+	k.mtx.Lock()
+	k.keys = keys
+	k.mtx.Unlock()
+
+	logging.Info("Loaded %d keys for key ring %s, primary key %s", len(keys), k.name, keys[0].ID)
+	return nil
+}
+
+// Primary returns the key used to protect new data. It returns false if the ring has no keys
+func (k *KeyRing) Primary() (Key, bool) {
+	k.mtx.RLock()
+	defer k.mtx.RUnlock()
+
+	if len(k.keys) == 0 {
+		return Key{}, false
+	}
+	return k.keys[0], true
+}
+
+// Key returns a key by its id
+func (k *KeyRing) Key(id string) (Key, bool) {
+	k.mtx.RLock()
+	defer k.mtx.RUnlock()
+
+	for _, key := range k.keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return Key{}, false
+}
+
+// Keys returns all the keys of the ring, newest key first
+func (k *KeyRing) Keys() []Key {
+	k.mtx.RLock()
+	defer k.mtx.RUnlock()
+
+	return append([]Key(nil), k.keys...)
+}
+
+// Secrets returns the secrets of all the keys, newest key first
+func (k *KeyRing) Secrets() [][]byte {
+	k.mtx.RLock()
+	defer k.mtx.RUnlock()
+
+	ret := make([][]byte, len(k.keys))
+	for i, key := range k.keys {
+		ret[i] = key.Secret
+	}
+	return ret
+}
+
+// Rotate makes a new key the primary key. Older keys are kept for reading until they are retired
+func (k *KeyRing) Rotate(key Key) error {
+	if err := key.validate(); err != nil {
+		return err
+	}
+
+	k.mtx.Lock()
+	defer k.mtx.Unlock()
+
+	for _, existing := range k.keys {
+		if existing.ID == key.ID {
+			return fmt.Errorf("Key %s already exists in key ring %s", key.ID, k.name)
+		}
+	}
+	if key.Created.IsZero() {
+		key.Created = time.Now()
+	}
+
+	k.keys = append([]Key{key}, k.keys...)
+	logging.Info("Rotated key ring %s to key %s", k.name, key.ID)
+	return nil
+}
+
+// Retire removes an old key from the ring, once no data protected with it is still in use. The primary key cannot be
+// retired
+func (k *KeyRing) Retire(id string) error {
+	k.mtx.Lock()
+	defer k.mtx.Unlock()
+
+	for i, key := range k.keys {
+		if key.ID == id {
+			if i == 0 {
+				return fmt.Errorf("Cannot retire the primary key of key ring %s", k.name)
+			}
+			k.keys = append(k.keys[:i], k.keys[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("Key %s not found in key ring %s", id, k.name)
+}
+
+var keyRings = struct {
+	sync.RWMutex
+	rings map[string]*KeyRing
+}{
+	rings: map[string]*KeyRing{},
+}
+
+// RegisterKeyRing registers a key ring by its name
+func RegisterKeyRing(ring *KeyRing) {
+	keyRings.Lock()
+	defer keyRings.Unlock()
+	keyRings.rings[ring.name] = ring
+}
+
+// GetKeyRing returns a registered key ring by name, or nil if no such ring was registered
+func GetKeyRing(name string) *KeyRing {
+	keyRings.RLock()
+	defer keyRings.RUnlock()
+	return keyRings.rings[name]
+}
+
+// ReloadKeyRings reloads all the registered key rings from their sources, e.g. on SIGHUP after keys were rotated
+func ReloadKeyRings() error {
+	keyRings.RLock()
+	defer keyRings.RUnlock()
+
+	for _, ring := range keyRings.rings {
+		if err := ring.Reload(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseKeys parses keys in the form id:base64secret, separated by commas or newlines. Empty lines and lines starting
+// with # are ignored. Secrets shorter than MinKeySize are rejected
+func parseKeys(text string) ([]Key, error) {
+
+	keys := make([]Key, 0)
+	for _, line := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == '\n' }) {
+
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Invalid key entry, expected id:base64secret")
+		}
+
+		secret, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid secret for key %s: %s", parts[0], err)
+		}
+		key := Key{ID: parts[0], Secret: secret}
+		if err := key.validate(); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// EnvKeySource loads keys from environment variables named <Prefix><RING NAME>, containing comma separated
+// id:base64secret entries, newest key first. The prefix defaults to VERTEX_KEYRING_
+type EnvKeySource struct {
+	Prefix string
+}
+
+// LoadKeys loads the keys of a ring from its environment variable
+func (s EnvKeySource) LoadKeys(ring string) ([]Key, error) {
+
+	prefix := s.Prefix
+	if prefix == "" {
+		prefix = "VERTEX_KEYRING_"
+	}
+	name := prefix + strings.ToUpper(strings.Replace(ring, "-", "_", -1))
+
+	value := os.Getenv(name)
+	if value == "" {
+		return nil, fmt.Errorf("Environment variable %s is not set", name)
+	}
+	return parseKeys(value)
+}
+
+// FileKeySource loads keys from files named <ring name>.keys in a directory, with one id:base64secret entry per
+// line, newest key first
+type FileKeySource struct {
+	Dir string
+}
+
+// LoadKeys loads the keys of a ring from its file
+func (s FileKeySource) LoadKeys(ring string) ([]Key, error) {
+
+	b, err := ioutil.ReadFile(s.Dir + string(os.PathSeparator) + ring + ".keys")
+	if err != nil {
+		return nil, err
+	}
+	return parseKeys(string(b))
+}
+
+// KMS is a key management service that decrypts secrets encrypted with a master key it holds
+type KMS interface {
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+// KMSKeySource loads keys whose secrets are encrypted by a KMS from another source, and decrypts them. This way only
+// encrypted keys are kept in the environment or in files
+type KMSKeySource struct {
+	KMS    KMS
+	Source KeySource
+}
+
+// LoadKeys loads the encrypted keys of a ring and decrypts them
+func (s KMSKeySource) LoadKeys(ring string) ([]Key, error) {
+
+	keys, err := s.Source.LoadKeys(ring)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range keys {
+		if keys[i].Secret, err = s.KMS.Decrypt(keys[i].Secret); err != nil {
+			return nil, fmt.Errorf("Could not decrypt key %s: %s", keys[i].ID, err)
+		}
+	}
+	return keys, nil
+}
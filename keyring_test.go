@@ -0,0 +1,156 @@
+package vertex
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type reverseKMS struct{}
+
+func (reverseKMS) Decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) == 0 {
+		return nil, errors.New("empty ciphertext")
+	}
+	ret := make([]byte, len(ciphertext))
+	for i, b := range ciphertext {
+		ret[len(ciphertext)-1-i] = b
+	}
+	return ret, nil
+}
+
+// keyList is a key source of fixed keys
+type keyList []Key
+
+func (l keyList) LoadKeys(ring string) ([]Key, error) {
+	return l, nil
+}
+
+// testSecret pads a short name to a secret long enough for key rings
+func testSecret(name string) []byte {
+	return []byte(name + strings.Repeat(".", MinKeySize-len(name)))
+}
+
+// reverse reverses a secret, encrypting it for reverseKMS
+func reverse(b []byte) []byte {
+	ret, _ := reverseKMS{}.Decrypt(b)
+	return ret
+}
+
+func TestKeyRing(t *testing.T) {
+
+	ring := NewKeyRing("test", Key{ID: "k1", Secret: testSecret("one")})
+
+	key, ok := ring.Primary()
+	assert.True(t, ok)
+	assert.Equal(t, "k1", key.ID)
+
+	assert.NoError(t, ring.Rotate(Key{ID: "k2", Secret: testSecret("two")}))
+	assert.Error(t, ring.Rotate(Key{ID: "k2", Secret: testSecret("again")}))
+
+	// short secrets are rejected
+	assert.Error(t, ring.Rotate(Key{ID: "k3", Secret: []byte("short")}))
+	assert.Error(t, ring.Rotate(Key{ID: "k3"}))
+	assert.Panics(t, func() { NewKeyRing("weak", Key{ID: "k1", Secret: []byte("short")}) })
+
+	key, _ = ring.Primary()
+	assert.Equal(t, "k2", key.ID)
+	assert.False(t, key.Created.IsZero())
+	assert.Len(t, ring.Keys(), 2)
+
+	key, ok = ring.Key("k1")
+	assert.True(t, ok)
+	assert.Equal(t, testSecret("one"), key.Secret)
+
+	assert.Error(t, ring.Retire("k2"))
+	assert.Error(t, ring.Retire("nope"))
+	assert.NoError(t, ring.Retire("k1"))
+	_, ok = ring.Key("k1")
+	assert.False(t, ok)
+
+	RegisterKeyRing(ring)
+	assert.True(t, GetKeyRing("test") == ring)
+	assert.Nil(t, GetKeyRing("nope"))
+}
+
+func TestKeySources(t *testing.T) {
+
+	enc := base64.StdEncoding.EncodeToString
+
+	os.Setenv("VERTEX_KEYRING_MY_RING", "new:"+enc(testSecret("newkey"))+",old:"+enc(testSecret("oldkey")))
+	defer os.Unsetenv("VERTEX_KEYRING_MY_RING")
+
+	ring, err := LoadKeyRing("my-ring", EnvKeySource{})
+	if assert.NoError(t, err) {
+		key, _ := ring.Primary()
+		assert.Equal(t, "new", key.ID)
+		assert.Equal(t, testSecret("newkey"), key.Secret)
+		assert.Len(t, ring.Keys(), 2)
+	}
+
+	_, err = LoadKeyRing("missing", EnvKeySource{})
+	assert.Error(t, err)
+
+	dir, err := ioutil.TempDir("", "keyring")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	// secrets encrypted by the KMS - reversed, for the test
+	contents := "# session keys\nk2:" + enc(reverse(testSecret("two"))) + "\n\nk1:" + enc(reverse(testSecret("one"))) + "\n"
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "sessions.keys"), []byte(contents), 0600))
+
+	ring, err = LoadKeyRing("sessions", KMSKeySource{KMS: reverseKMS{}, Source: FileKeySource{Dir: dir}})
+	if assert.NoError(t, err) {
+		assert.Equal(t, [][]byte{testSecret("two"), testSecret("one")}, ring.Secrets())
+	}
+
+	// reloading picks up rotated keys
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "sessions.keys"), []byte("k3:"+enc(reverse(testSecret("three")))+"\n"+contents), 0600))
+	assert.NoError(t, ring.Reload())
+	key, _ := ring.Primary()
+	assert.Equal(t, "k3", key.ID)
+	assert.Equal(t, testSecret("three"), key.Secret)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "bad.keys"), []byte("k1:not base64!"), 0600))
+	_, err = LoadKeyRing("bad", FileKeySource{Dir: dir})
+	assert.Error(t, err)
+
+	// so do empty or short secrets, before or after decryption
+	os.Setenv("VERTEX_KEYRING_WEAK", "k1:")
+	defer os.Unsetenv("VERTEX_KEYRING_WEAK")
+	_, err = LoadKeyRing("weak", EnvKeySource{})
+	assert.Error(t, err)
+
+	os.Setenv("VERTEX_KEYRING_WEAK", "k1:"+enc([]byte("short")))
+	_, err = LoadKeyRing("weak", EnvKeySource{})
+	assert.Error(t, err)
+
+	_, err = LoadKeyRing("weak", keyList{{ID: "k1", Secret: []byte("short")}})
+	assert.Error(t, err)
+}
+
+func TestKeyRingCookie(t *testing.T) {
+
+	ring := NewKeyRing("cookies", Key{ID: "k1", Secret: testSecret("one")})
+	c := Cookie{Name: "session", Encrypted: true, KeyRing: ring}
+
+	r, _ := roundTrip(t, c, cookieSession{User: "alice"})
+
+	// cookies written before a rotation are still readable
+	assert.NoError(t, ring.Rotate(Key{ID: "k2", Secret: testSecret("two")}))
+	var sess cookieSession
+	assert.NoError(t, c.Get(r, &sess))
+	assert.Equal(t, "alice", sess.User)
+
+	// until their key is retired
+	assert.NoError(t, ring.Retire("k1"))
+	assert.Error(t, c.Get(r, &sess))
+}
@@ -0,0 +1,181 @@
+package vertex
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Paths of the server's built in health check endpoints
+const (
+	LivenessPath  = "/healthz"
+	ReadinessPath = "/readyz"
+)
+
+// PodInfo is the pod metadata kubernetes exposes to the container via the downward API.
+// It is read from the POD_NAME, POD_NAMESPACE, NODE_NAME and POD_IP environment variables
+type PodInfo struct {
+	Name      string `json:"name,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Node      string `json:"node,omitempty"`
+	IP        string `json:"ip,omitempty"`
+}
+
+func readPodInfo() PodInfo {
+	return PodInfo{
+		Name:      os.Getenv("POD_NAME"),
+		Namespace: os.Getenv("POD_NAMESPACE"),
+		Node:      os.Getenv("NODE_NAME"),
+		IP:        os.Getenv("POD_IP"),
+	}
+}
+
+// Labels returns the pod metadata as the labels of metrics and log records, without the fields that are not set
+func (p PodInfo) Labels() map[string]string {
+	ret := map[string]string{}
+	for k, v := range map[string]string{"pod": p.Name, "namespace": p.Namespace, "node": p.Node} {
+		if v != "" {
+			ret[k] = v
+		}
+	}
+	return ret
+}
+
+// logPrefix returns the prefix of log lines, e.g. "[pod=api-7d9f namespace=prod node=n1] "
+func (p PodInfo) logPrefix() string {
+	labels := p.Labels()
+	ret := ""
+	for _, k := range []string{"pod", "namespace", "node"} {
+		if v, found := labels[k]; found {
+			if ret != "" {
+				ret += " "
+			}
+			ret += k + "=" + v
+		}
+	}
+	if ret == "" {
+		return ""
+	}
+	return "[" + ret + "] "
+}
+
+// podInfo is the metadata of the pod the server runs in, set by EnableKubernetes
+var podInfo atomic.Value
+
+// setPodInfo labels the metrics and log records of the process with the metadata of its pod
+func setPodInfo(pod PodInfo) {
+	podInfo.Store(pod)
+	log.SetPrefix(pod.logPrefix())
+}
+
+// podLabels returns the labels of the pod the server runs in, or nil if it does not run in kubernetes
+func podLabels() map[string]string {
+	pod, ok := podInfo.Load().(PodInfo)
+	if !ok {
+		return nil
+	}
+	if labels := pod.Labels(); len(labels) > 0 {
+		return labels
+	}
+	return nil
+}
+
+// IsReady returns true if the server is serving and not draining
+func (s *Server) IsReady() bool {
+	return atomic.LoadInt32(&s.ready) == 1 && !s.isDraining()
+}
+
+func (s *Server) setReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&s.ready, v)
+}
+
+// livenessHandler answers as long as the process is able to serve requests at all
+func (s *Server) livenessHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	fmt.Fprintln(w, "OK")
+}
+
+// readinessHandler fails if the server is not ready to receive traffic, e.g. while draining, or if any of the
+// registered resources is unhealthy
+func (s *Server) readinessHandler(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	if !s.IsReady() {
+		if progress := s.warmupProgress(); progress != "" {
+			http.Error(w, "Not Ready\n"+progress, http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(w, "Not Ready", http.StatusServiceUnavailable)
+		return
+	}
+
+	if errs := checkResources(); len(errs) > 0 {
+		logging.Warning("Unhealthy resources: %v", errs)
+		http.Error(w, "Not Ready\n"+formatResourceErrors(errs), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "OK")
+}
+
+// registerHealthChecks adds the liveness and readiness endpoints to the server's router
+func (s *Server) registerHealthChecks() {
+	s.router.GET(LivenessPath, s.livenessHandler)
+	s.router.GET(ReadinessPath, s.readinessHandler)
+}
+
+// GracefulStop stops the server the way load balancers expect: readiness checks start failing, we keep serving for
+// the configured drain delay so we are taken out of rotation, and then we stop accepting connections and wait up
+// to the shutdown grace period for in-flight requests to finish
+func (s *Server) GracefulStop() error {
+
+	s.setReady(false)
+
+	if delay := time.Duration(Config.Server.DrainDelay) * time.Second; delay > 0 {
+		logging.Info("Draining for %v before shutting down", delay)
+		time.Sleep(delay)
+	}
+
+	return s.shutdown(time.Duration(Config.Server.ShutdownGrace)*time.Second, false)
+}
+
+// EnableKubernetes sets the server up for running as a kubernetes pod in one call:
+//   - SIGTERM (and SIGINT) trigger GracefulStop, so the pod fails readiness, drains and finishes in-flight requests.
+//     Set the pod's terminationGracePeriodSeconds above drain_delay_sec + shutdown_grace_sec
+//   - Downward API pod metadata is logged and published as the "vertex.pod" expvar. The pod, namespace and node
+//     label the counters published as expvars, the stats endpoint, security events and the log lines
+//
+// Point the pod's liveness and readiness probes at /healthz and /readyz, which every server serves
+func (s *Server) EnableKubernetes() {
+
+	pod := readPodInfo()
+	setPodInfo(pod)
+	logging.Info("Running in kubernetes pod %s/%s on node %s (%s)", pod.Namespace, pod.Name, pod.Node, pod.IP)
+
+	if expvar.Get("vertex.pod") == nil {
+		expvar.Publish("vertex.pod", expvar.Func(func() interface{} {
+			return pod
+		}))
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGTERM, os.Interrupt)
+
+	go func() {
+		sig := <-ch
+		logging.Info("Got signal %s, shutting down gracefully", sig)
+		if err := s.GracefulStop(); err != nil {
+			logging.Error("Error shutting down server: %s", err)
+		}
+	}()
+}
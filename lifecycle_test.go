@@ -0,0 +1,172 @@
+package vertex
+
+import (
+	"encoding/json"
+	"expvar"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthChecks(t *testing.T) {
+
+	srv := NewServer(":9947")
+	srv.registerHealthChecks()
+
+	check := func(path string) int {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, check(LivenessPath))
+	assert.Equal(t, http.StatusServiceUnavailable, check(ReadinessPath))
+
+	srv.setReady(true)
+	assert.Equal(t, http.StatusOK, check(ReadinessPath))
+
+	srv.setReady(false)
+	assert.Equal(t, http.StatusServiceUnavailable, check(ReadinessPath))
+	assert.Equal(t, http.StatusOK, check(LivenessPath))
+}
+
+func TestRunWaitsForShutdown(t *testing.T) {
+
+	defer func(grace, delay int) {
+		Config.Server.ShutdownGrace, Config.Server.DrainDelay = grace, delay
+	}(Config.Server.ShutdownGrace, Config.Server.DrainDelay)
+	Config.Server.ShutdownGrace, Config.Server.DrainDelay = 5, 0
+
+	sock := path.Join(os.TempDir(), "vertex-shutdown.sock")
+	os.Remove(sock)
+	notifications, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sock, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer notifications.Close()
+	defer os.Remove(sock)
+	os.Setenv("NOTIFY_SOCKET", sock)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+
+	// notified returns the notifications sent to systemd until it is quiet
+	notified := func() (ret []string) {
+		buf := make([]byte, 256)
+		for {
+			notifications.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			n, err := notifications.Read(buf)
+			if err != nil {
+				return
+			}
+			ret = append(ret, string(buf[:n]))
+		}
+	}
+
+	run := func(stop func(*Server) error) {
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := l.Addr().String()
+		l.Close()
+
+		started := make(chan struct{})
+		srv := NewServer(addr)
+		srv.AddAPI(&API{
+			Name:          "shutdown",
+			Version:       "1.0",
+			Renderer:      JSONRenderer{},
+			AllowInsecure: true,
+			Routes: Routes{
+				{Path: "/slow", Description: "Slow", Methods: GET, Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					close(started)
+					time.Sleep(300 * time.Millisecond)
+					return "done", nil
+				})},
+			},
+		})
+
+		stopped := make(chan error, 1)
+		go func() { stopped <- srv.Run() }()
+		for i := 0; i < 100 && !srv.IsReady(); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.True(t, srv.IsReady())
+
+		responded := make(chan string, 1)
+		go func() {
+			res, err := http.Get("http://" + addr + "/shutdown/1.0/slow")
+			if err != nil {
+				responded <- err.Error()
+				return
+			}
+			defer res.Body.Close()
+			body, _ := ioutil.ReadAll(res.Body)
+			responded <- string(body)
+		}()
+		<-started
+		go stop(srv)
+
+		// Run returns only after the request in flight was answered
+		select {
+		case err := <-stopped:
+			t.Fatalf("Run returned during shutdown: %v", err)
+		case body := <-responded:
+			assert.Contains(t, body, "done")
+		}
+		select {
+		case err := <-stopped:
+			assert.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Run did not return after shutdown")
+		}
+	}
+
+	// the shutdown grace period is honoured when stopping gracefully
+	run((*Server).GracefulStop)
+	assert.Contains(t, notified(), sdStopping)
+
+	// when the server hands over to an upgraded process, systemd is not told it is stopping
+	run(func(srv *Server) error {
+		return srv.shutdown(time.Duration(Config.Server.ShutdownGrace)*time.Second, true)
+	})
+	assert.NotContains(t, notified(), sdStopping)
+}
+
+func TestPodLabels(t *testing.T) {
+
+	setPodInfo(PodInfo{Name: "api-7d9f", Namespace: "prod", Node: "node-1", IP: "10.0.0.7"})
+	defer setPodInfo(PodInfo{})
+
+	labels := map[string]string{"pod": "api-7d9f", "namespace": "prod", "node": "node-1"}
+	assert.Equal(t, "[pod=api-7d9f namespace=prod node=node-1] ", log.Prefix())
+
+	// published counters carry the labels, and are still valid JSON
+	countErrorClass("/pod/labeled", ServerError)
+	var vars map[string]interface{}
+	if assert.NoError(t, json.Unmarshal([]byte(expvar.Get("vertex.errors").String()), &vars)) {
+		assert.Equal(t, map[string]interface{}{"pod": "api-7d9f", "namespace": "prod", "node": "node-1"}, vars["_labels"])
+		assert.NotNil(t, vars["/pod/labeled"])
+	}
+
+	srv := NewServer(":9948")
+	assert.Equal(t, labels, srv.stats(time.Now(), time.Minute).Labels)
+
+	hr, _ := http.NewRequest("GET", "/foo", nil)
+	assert.Equal(t, labels, NewSecurityEvent(NewRequest(hr), EventLockout, "test").Pod)
+
+	// outside kubernetes nothing is labeled
+	setPodInfo(PodInfo{})
+	assert.Equal(t, "", log.Prefix())
+	assert.Nil(t, NewSecurityEvent(NewRequest(hr), EventLockout, "test").Pod)
+	assert.NoError(t, json.Unmarshal([]byte(expvar.Get("vertex.errors").String()), &vars))
+}
@@ -0,0 +1,312 @@
+package vertex
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/smtp"
+	"net/textproto"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// Default Mailer settings
+const (
+	DefaultMailRetries = 3
+	DefaultMailBackoff = time.Second
+)
+
+// ErrMailerClosed is returned when sending emails after the server stopped
+var ErrMailerClosed = errors.New("Mailer is closed")
+
+// MailMessage is an email. Messages with both a text and an html body are sent as multipart/alternative
+type MailMessage struct {
+	// The sender. Defaults to the From address of the mailer
+	From string
+
+	To      []string
+	Cc      []string
+	Bcc     []string
+	ReplyTo string
+	Subject string
+
+	Text string
+	HTML string
+
+	// Extra headers of the message
+	Headers map[string]string
+}
+
+// recipients returns the envelope recipients of the message
+func (m *MailMessage) recipients() []string {
+	ret := make([]string, 0, len(m.To)+len(m.Cc)+len(m.Bcc))
+	ret = append(ret, m.To...)
+	ret = append(ret, m.Cc...)
+	return append(ret, m.Bcc...)
+}
+
+// Bytes encodes the message in MIME format, as sent to mail servers. Bcc recipients are left out
+func (m *MailMessage) Bytes() ([]byte, error) {
+
+	buf := &bytes.Buffer{}
+
+	headers := map[string]string{
+		"From":         m.From,
+		"To":           strings.Join(m.To, ", "),
+		"Cc":           strings.Join(m.Cc, ", "),
+		"Reply-To":     m.ReplyTo,
+		"Subject":      mime.QEncoding.Encode("utf-8", m.Subject),
+		"Date":         time.Now().Format(time.RFC1123Z),
+		"MIME-Version": "1.0",
+	}
+	for k, v := range m.Headers {
+		headers[textproto.CanonicalMIMEHeaderKey(k)] = v
+	}
+
+	keys := make([]string, 0, len(headers))
+	for k, v := range headers {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(buf, "%s: %s\r\n", k, headers[k])
+	}
+
+	switch {
+	case m.Text != "" && m.HTML != "":
+		mw := multipart.NewWriter(buf)
+		fmt.Fprintf(buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+		for _, part := range []struct{ contentType, body string }{
+			{"text/plain", m.Text},
+			{"text/html", m.HTML},
+		} {
+			pw, err := mw.CreatePart(textproto.MIMEHeader{
+				"Content-Type":              {part.contentType + "; charset=utf-8"},
+				"Content-Transfer-Encoding": {"quoted-printable"},
+			})
+			if err != nil {
+				return nil, err
+			}
+			if err := writeQuotedPrintable(pw, part.body); err != nil {
+				return nil, err
+			}
+		}
+		if err := mw.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		contentType, body := "text/plain", m.Text
+		if m.HTML != "" {
+			contentType, body = "text/html", m.HTML
+		}
+		fmt.Fprintf(buf, "Content-Type: %s; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n", contentType)
+		if err := writeQuotedPrintable(buf, body); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, s string) error {
+	qw := quotedprintable.NewWriter(w)
+	if _, err := qw.Write([]byte(s)); err != nil {
+		return err
+	}
+	return qw.Close()
+}
+
+// MailTransport delivers emails, e.g. to an SMTP server or an email API. The Mailer wrapping it adds retries,
+// metrics, templates and lifecycle
+type MailTransport interface {
+	// Send delivers a message, returning once it was accepted or the context is done
+	Send(ctx context.Context, msg *MailMessage) error
+
+	// Close closes the connection to the mail server
+	Close() error
+}
+
+// SMTPTransport delivers emails to an SMTP server, with a connection per message
+type SMTPTransport struct {
+	// The host:port of the server
+	Addr string
+
+	// Optional authentication, e.g. smtp.PlainAuth
+	Auth smtp.Auth
+}
+
+func (t SMTPTransport) Send(ctx context.Context, msg *MailMessage) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	data, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(t.Addr, t.Auth, msg.From, msg.recipients(), data)
+}
+
+func (SMTPTransport) Close() error {
+	return nil
+}
+
+// Mailer is a lifecycle managed wrapper of a MailTransport, for handlers sending emails. Mailers are provided for
+// injection by name, so handlers do not manage their own SMTP clients:
+//
+//	mailer := vertex.NewMailer("mail", vertex.SMTPTransport{Addr: "smtp.example.com:587", Auth: auth})
+//	mailer.From = "Example <noreply@example.com>"
+//	mailer.Templates = vertex.NewHTMLRendererFiles(nil, "templates/mail.html")
+//
+//	type InviteHandler struct {
+//		Email  string         `schema:"email" required:"true"`
+//		Mailer *vertex.Mailer `inject:"mail"`
+//	}
+//
+//	func (h InviteHandler) Handle(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+//		msg := &vertex.MailMessage{To: []string{h.Email}, Subject: "You're invited"}
+//		return nil, h.Mailer.SendTemplate(r, msg, "invite", invite)
+//	}
+//
+// Mailers are closed when the server stops, after it stopped serving requests and its workers stopped, so emails
+// sent while shutting down are not lost
+type Mailer struct {
+	// The default sender of messages
+	From string
+
+	// Optional templates of html bodies, rendered by SendTemplate. Templates are defined with {{define "name"}} in
+	// the renderer's source, like the templates of html responses
+	Templates *HTMLRenderer
+
+	// Failed sends are retried up to MaxRetries times, waiting Backoff before the first retry and doubling it for
+	// every retry after it
+	MaxRetries int
+	Backoff    time.Duration
+
+	name      string
+	transport MailTransport
+	mtx       sync.RWMutex
+	closed    bool
+}
+
+// name => sent/failed/retries/micros => value
+var mailerMetrics = newCounterMap("vertex.mailers")
+
+// NewMailer wraps a mail transport with a mailer, provides it for injection by name and closes it on shutdown
+func NewMailer(name string, transport MailTransport) *Mailer {
+
+	m := &Mailer{
+		MaxRetries: DefaultMailRetries,
+		Backoff:    DefaultMailBackoff,
+		name:       name,
+		transport:  transport,
+	}
+
+	Provide(name, m)
+	CloseOnShutdown("mailer "+name, m)
+	return m
+}
+
+// Send sends a message, in the context of a request: the send is canceled if the request is, and the message
+// carries the request id for tracing. r may be nil when sending outside of a request, e.g. from a worker
+func (m *Mailer) Send(r *Request, msg *MailMessage) error {
+
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	if m.closed {
+		return ErrMailerClosed
+	}
+
+	if msg.From == "" {
+		msg.From = m.From
+	}
+	if len(msg.recipients()) == 0 {
+		return NewErrorf("Email %q has no recipients", msg.Subject)
+	}
+
+	ctx := context.Background()
+	if r != nil {
+		ctx = r.Context()
+		if msg.Headers == nil {
+			msg.Headers = map[string]string{}
+		}
+		msg.Headers[HeaderRequestId] = r.RequestId
+	}
+
+	st := time.Now()
+	backoff := m.Backoff
+	err := m.transport.Send(ctx, msg)
+	for i := 0; err != nil && i < m.MaxRetries && ctx.Err() == nil; i++ {
+
+		logging.Warning("Could not send email %q with %s, retrying in %v: %s", msg.Subject, m.name, backoff, err)
+		select {
+		case <-time.After(backoff):
+			mailerMetrics.Add(m.name, "retries", 1)
+			backoff *= 2
+			err = m.transport.Send(ctx, msg)
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	mailerMetrics.Add(m.name, "micros", int64(time.Since(st)/time.Microsecond))
+	if err != nil {
+		mailerMetrics.Add(m.name, "failed", 1)
+		return logging.Errorf("Could not send email %q with %s: %s", msg.Subject, m.name, err)
+	}
+	mailerMetrics.Add(m.name, "sent", 1)
+	return nil
+}
+
+// SendTemplate renders the html body of a message with a template of the mailer and sends it
+func (m *Mailer) SendTemplate(r *Request, msg *MailMessage, template string, data interface{}) error {
+
+	if m.Templates == nil {
+		return NewErrorf("Mailer %s has no templates", m.name)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := m.Templates.template.ExecuteTemplate(buf, template, data); err != nil {
+		return logging.Errorf("Could not render email template %s: %s", template, err)
+	}
+	msg.HTML = buf.String()
+
+	return m.Send(r, msg)
+}
+
+// Close closes the transport. Sends in progress finish first, and later sends fail with ErrMailerClosed
+func (m *Mailer) Close() error {
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	return m.transport.Close()
+}
+
+// MailerStats returns the number of emails a mailer sent and failed to send, the number of retries and the average
+// send latency, including retries
+func MailerStats(name string) (sent, failed, retries int64, latency time.Duration) {
+
+	sent = mailerMetrics.Value(name, "sent")
+	failed = mailerMetrics.Value(name, "failed")
+	retries = mailerMetrics.Value(name, "retries")
+	if calls := sent + failed; calls > 0 {
+		latency = time.Duration(mailerMetrics.Value(name, "micros")/calls) * time.Microsecond
+	}
+	return
+}
@@ -0,0 +1,132 @@
+package vertex
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockTransport struct {
+	mtx      sync.Mutex
+	failures int
+	sent     []*MailMessage
+	closed   bool
+}
+
+func (t *mockTransport) Send(ctx context.Context, msg *MailMessage) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.failures > 0 {
+		t.failures--
+		return errors.New("connection refused")
+	}
+	t.sent = append(t.sent, msg)
+	return nil
+}
+
+func (t *mockTransport) Close() error {
+	t.closed = true
+	return nil
+}
+
+func TestMailer(t *testing.T) {
+
+	mt := &mockTransport{failures: 1}
+	m := NewMailer("test.mail", mt)
+	m.From = "noreply@example.com"
+	m.Backoff = time.Millisecond
+	m.Templates = NewHTMLRenderer(`{{define "invite"}}<p>Hi {{.}}</p>{{end}}`, nil)
+
+	hr, _ := http.NewRequest("POST", "/invites", nil)
+	r := NewRequest(hr)
+
+	// failures are retried, templates rendered and the message carries the request id
+	msg := &MailMessage{To: []string{"bob@example.com"}, Subject: "Invite"}
+	assert.NoError(t, m.SendTemplate(r, msg, "invite", "<bob>"))
+	if assert.Len(t, mt.sent, 1) {
+		assert.Equal(t, "noreply@example.com", mt.sent[0].From)
+		assert.Equal(t, "<p>Hi &lt;bob&gt;</p>", mt.sent[0].HTML)
+		assert.Equal(t, r.RequestId, mt.sent[0].Headers[HeaderRequestId])
+	}
+
+	assert.Error(t, m.SendTemplate(nil, &MailMessage{To: []string{"bob@example.com"}}, "nope", nil))
+	assert.Error(t, m.Send(nil, &MailMessage{Subject: "Nobody"}))
+
+	mt.failures = 10
+	assert.Error(t, m.Send(nil, &MailMessage{To: []string{"bob@example.com"}}))
+	mt.failures = 0
+
+	sent, failed, retries, _ := MailerStats("test.mail")
+	assert.Equal(t, int64(1), sent)
+	assert.Equal(t, int64(1), failed)
+	assert.Equal(t, int64(4), retries)
+
+	// mailers are provided for injection
+	var h struct {
+		Mailer *Mailer `inject:"test.mail"`
+	}
+	assert.NoError(t, inject(&h, []injection{{field: 0, name: "test.mail"}}))
+	assert.True(t, h.Mailer == m)
+
+	// and closed when the server stops
+	closeResources()
+	assert.True(t, mt.closed)
+	assert.Equal(t, ErrMailerClosed, m.Send(nil, &MailMessage{To: []string{"bob@example.com"}}))
+}
+
+func TestMailMessageBytes(t *testing.T) {
+
+	msg := &MailMessage{
+		From:    "noreply@example.com",
+		To:      []string{"bob@example.com", "alice@example.com"},
+		Bcc:     []string{"audit@example.com"},
+		Subject: "Héllo",
+		Text:    "Hello Bob",
+		HTML:    "<p>Hello Bob</p>",
+		Headers: map[string]string{"x-campaign": "welcome"},
+	}
+	assert.Len(t, msg.recipients(), 3)
+
+	b, err := msg.Bytes()
+	assert.NoError(t, err)
+	assert.NotContains(t, string(b), "audit@example.com")
+
+	parsed, err := mail.ReadMessage(strings.NewReader(string(b)))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "bob@example.com, alice@example.com", parsed.Header.Get("To"))
+	assert.Equal(t, "welcome", parsed.Header.Get("X-Campaign"))
+	subject, _ := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	assert.Equal(t, "Héllo", subject)
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	assert.NoError(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+
+	var bodies []string
+	mr := multipart.NewReader(parsed.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		body, _ := ioutil.ReadAll(p)
+		bodies = append(bodies, p.Header.Get("Content-Type")+" "+string(body))
+	}
+	assert.Equal(t, []string{"text/plain; charset=utf-8 Hello Bob", "text/html; charset=utf-8 <p>Hello Bob</p>"}, bodies)
+
+	// single bodies are not multipart
+	b, _ = (&MailMessage{To: []string{"bob@example.com"}, Text: "plain"}).Bytes()
+	assert.Contains(t, string(b), "Content-Type: text/plain; charset=utf-8\r\n")
+}
@@ -0,0 +1,155 @@
+package vertex
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Metrics are published as expvars, and served by the server on /debug/vars
+const MetricsPath = "/debug/vars"
+
+// ErrorClass classifies rendered errors, so alerting can tell genuine server errors from client mistakes
+type ErrorClass string
+
+// Error classes
+const (
+	// The client sent a bad request
+	ClientError ErrorClass = "client"
+
+	// The request failed input validation
+	ValidationError ErrorClass = "validation"
+
+	// The request was denied by a security scheme or security middleware
+	SecurityError ErrorClass = "security"
+
+	// We failed handling the request. These are the only errors worth waking someone up for
+	ServerError ErrorClass = "server"
+)
+
+// ErrorClassifier lets APIs customize error classification. It receives the error and the http status it will be
+// rendered with. Returning an empty class falls back to the default classification
+type ErrorClassifier func(err error, status int) ErrorClass
+
+// classifyError is the default error classification, based on the error code and http status
+func classifyError(err error, status int) ErrorClass {
+
+	if e, ok := err.(*internalError); ok {
+		switch e.Code {
+		case ErrUnauthorized, ErrInsecureAccessDenied, ErrMissingCredentials, ErrInvalidCredentials, ErrForbidden:
+			return SecurityError
+		case ErrInvalidParam, ErrMissingParam, ErrInvalidRequest:
+			return ValidationError
+		}
+	}
+
+	if status >= 500 {
+		return ServerError
+	}
+	return ClientError
+}
+
+// classifyError classifies an error using the API's custom classifier if it has one
+func (a *API) classifyError(err error) ErrorClass {
+
+	status := errorStatus(err)
+	if a.ErrorClassifier != nil {
+		if class := a.ErrorClassifier(err, status); class != "" {
+			return class
+		}
+	}
+
+	return classifyError(err, status)
+}
+
+// counterMap is a two level expvar map of counters, e.g. route => error class => count. When running in
+// kubernetes, the published map is labeled with the pod metadata in its "_labels" key
+type counterMap struct {
+	*expvar.Map
+	mtx sync.Mutex
+}
+
+func newCounterMap(name string) *counterMap {
+	m := &counterMap{Map: new(expvar.Map).Init()}
+	expvar.Publish(name, m)
+	return m
+}
+
+// String renders the counters as JSON for the metrics endpoint, along with the pod labels if there are any
+func (m *counterMap) String() string {
+
+	labels := podLabels()
+	if labels == nil {
+		return m.Map.String()
+	}
+
+	var b strings.Builder
+	data, _ := json.Marshal(labels)
+	fmt.Fprintf(&b, "{%q: %s", "_labels", data)
+	m.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(&b, ", %q: %s", kv.Key, kv.Value)
+	})
+	b.WriteString("}")
+	return b.String()
+}
+
+// inner returns the second level map for a key, creating it if needed
+func (m *counterMap) inner(key string) *expvar.Map {
+
+	if v, ok := m.Get(key).(*expvar.Map); ok {
+		return v
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if v, ok := m.Get(key).(*expvar.Map); ok {
+		return v
+	}
+
+	v := new(expvar.Map).Init()
+	m.Set(key, v)
+	return v
+}
+
+// Add increments the counter for key/subkey
+func (m *counterMap) Add(key, subkey string, delta int64) {
+	m.inner(key).Add(subkey, delta)
+}
+
+// Value returns the current value of the counter for key/subkey
+func (m *counterMap) Value(key, subkey string) int64 {
+	if v, ok := m.inner(key).Get(subkey).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+var (
+	// route => error class => count
+	routeErrors = newCounterMap("vertex.errors")
+
+	// error class => count, across all routes
+	classErrors = expvar.NewMap("vertex.error_classes")
+)
+
+// countError classifies an error rendered for a route, and increments the counters for its class
+func (a *API) countError(route string, err error) ErrorClass {
+
+	class := a.classifyError(err)
+	countErrorClass(route, class)
+
+	return class
+}
+
+func countErrorClass(route string, class ErrorClass) {
+	routeErrors.Add(route, string(class), 1)
+	classErrors.Add(string(class), 1)
+}
+
+// ErrorCount returns the number of errors of a class rendered for a route. The route is its full path in the API
+func ErrorCount(route string, class ErrorClass) int64 {
+	return routeErrors.Value(route, string(class))
+}
@@ -0,0 +1,64 @@
+package vertex
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorClassification(t *testing.T) {
+
+	a := &API{}
+	assert.Equal(t, ServerError, a.classifyError(errors.New("wat")))
+	assert.Equal(t, ServerError, a.classifyError(NewErrorf("wat")))
+	assert.Equal(t, ServerError, a.classifyError(ResourceUnavailableError("wat")))
+	assert.Equal(t, ValidationError, a.classifyError(MissingParamError("wat")))
+	assert.Equal(t, ValidationError, a.classifyError(InvalidParamError("wat")))
+	assert.Equal(t, SecurityError, a.classifyError(UnauthorizedError("wat")))
+	assert.Equal(t, SecurityError, a.classifyError(InsecureAccessDenied("wat")))
+
+	// custom classification with fallback to the default
+	a.ErrorClassifier = func(err error, status int) ErrorClass {
+		if status == http.StatusServiceUnavailable {
+			return ClientError
+		}
+		return ""
+	}
+	assert.Equal(t, ClientError, a.classifyError(BackOffError(0)))
+	assert.Equal(t, ServerError, a.classifyError(errors.New("wat")))
+}
+
+func TestErrorCounters(t *testing.T) {
+
+	a := &API{
+		Name:          "metrics",
+		Version:       "1.0",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{
+				Path:    "/fail",
+				Methods: GET,
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					return nil, errors.New("boom")
+				}),
+			},
+		},
+	}
+
+	srv := NewServer(":9947")
+	srv.AddAPI(a)
+
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("GET", a.FullPath("/fail"), nil)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, req)
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	}
+
+	assert.EqualValues(t, 3, ErrorCount(a.FullPath("/fail"), ServerError))
+	assert.EqualValues(t, 0, ErrorCount(a.FullPath("/fail"), ClientError))
+}
@@ -35,8 +35,12 @@ func (v *APIKeyValidator) Add(keys ...string) {
 
 func (v *APIKeyValidator) Handle(w http.ResponseWriter, r *vertex.Request, next vertex.HandlerFunc) (interface{}, error) {
 
-	if _, found := v.validKeys[r.FormValue(v.paramName)]; !found {
-		return nil, vertex.UnauthorizedError("missing or invalid api key '%s'", r.FormValue(v.paramName))
+	key := r.FormValue(v.paramName)
+	if key == "" {
+		return nil, vertex.MissingCredentialsError("missing api key")
+	}
+	if _, found := v.validKeys[key]; !found {
+		return nil, vertex.InvalidCredentialsError("invalid api key '%s'", key)
 	}
 
 	return next(w, r)
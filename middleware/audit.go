@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/dvirsky/go-pylog/logging"
+
+	"github.com/EverythingMe/vertex"
+)
+
+// AuditLogger is a middleware that writes an audit record for every request, tagged with the request's principal.
+//
+// The audit store is registered as a subject data store, so its records are included in data subject exports
+// and purges (see vertex.ExportSubjectData and vertex.PurgeSubjectData)
+type AuditLogger struct {
+	store vertex.AuditStore
+}
+
+// NewAuditLogger creates a new audit logging middleware writing to the given store
+func NewAuditLogger(store vertex.AuditStore) *AuditLogger {
+
+	vertex.AddSubjectStore("audit", store)
+	return &AuditLogger{
+		store: store,
+	}
+}
+
+// Handle records the request after it has been handled, so principals set by auth middleware are included
+func (a *AuditLogger) Handle(w http.ResponseWriter, r *vertex.Request, next vertex.HandlerFunc) (interface{}, error) {
+
+	ret, err := next(w, r)
+
+	var details map[string]interface{}
+	if err != nil && !vertex.IsHijacked(err) {
+		details = map[string]interface{}{"error": err.Error()}
+	}
+
+	if e := a.store.Record(vertex.NewAuditRecord(r, "request", details)); e != nil {
+		logging.Error("Could not write audit record for %s: %s", r, e)
+	}
+
+	return ret, err
+}
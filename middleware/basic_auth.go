@@ -39,6 +39,8 @@ func (b BasicAuth) Handle(w http.ResponseWriter, r *vertex.Request, next vertex.
 			b.requireAuth(w)
 			return nil, vertex.Hijacked
 		}
+
+		r.SetPrincipal(&vertex.Principal{ID: user})
 	}
 
 	return next(w, r)
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/EverythingMe/vertex"
+)
+
+// ClientIdentifier is a middleware identifying the client app of requests from the X-Vertex-Client header, falling
+// back to the User-Agent header. The client is set on the request (see vertex.Request.Client), so it is included
+// in audit records and in the "vertex.clients" metrics of the apps registered with vertex.RegisterClients.
+//
+// Known bad versions of client apps can be blocked, failing their requests with a 426 Upgrade Required error:
+//
+//	middleware.NewClientIdentifier().Block("shopper-ios", "3.2.0", "This version loses carts, please upgrade")
+type ClientIdentifier struct {
+	// client name => version => message
+	blocked map[string]map[string]string
+}
+
+// NewClientIdentifier creates a new client identification middleware
+func NewClientIdentifier() *ClientIdentifier {
+	return &ClientIdentifier{
+		blocked: map[string]map[string]string{},
+	}
+}
+
+// Block rejects requests from a version of a client app with an upgrade required error carrying a message to the user
+func (c *ClientIdentifier) Block(name, version, message string) *ClientIdentifier {
+
+	if c.blocked[name] == nil {
+		c.blocked[name] = map[string]string{}
+	}
+	c.blocked[name][version] = message
+	return c
+}
+
+func (c *ClientIdentifier) Handle(w http.ResponseWriter, r *vertex.Request, next vertex.HandlerFunc) (interface{}, error) {
+
+	client := vertex.ParseClient(r.Header.Get(vertex.HeaderClient))
+	if client == nil {
+		client = vertex.ParseClient(r.UserAgent)
+	}
+	r.SetClient(client)
+
+	if client != nil {
+		if msg, found := c.blocked[client.Name][client.Version]; found {
+			return nil, vertex.UpgradeRequiredError("%s", msg)
+		}
+	}
+
+	return next(w, r)
+}
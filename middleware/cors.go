@@ -53,7 +53,7 @@ func (c *CORS) Default() *CORS {
 	c.ExposeHeaders("WWW-Authenticate", "Authorization")
 	c.AllowHeaders(c.exposeHeaders...)
 	c.allowCredentials = true
-	c.AllowMethods("GET", "POST", "OPTIONS", "PUT")
+	c.AllowMethods("GET", "POST", "OPTIONS", "PUT", "DELETE", "PATCH", "HEAD")
 	return c
 }
 
@@ -10,11 +10,11 @@ import (
 // RequestLogger is a middleware that logs the paths and return values of all requests
 var RequestLogger = vertex.MiddlewareFunc(func(w http.ResponseWriter, r *vertex.Request, next vertex.HandlerFunc) (interface{}, error) {
 
-	logging.Info("Handling %s %s", r.Method, r.URL.String())
+	logging.Info("Handling %s %s (request id: %s)", r.Method, r.URL.String(), r.RequestId)
 
 	ret, err := next(w, r)
 
-	logging.Info("Return value was %v %v", ret, err)
+	logging.Info("Return value was %v %v (principal: %s, request id: %s)", ret, err, r.PrincipalID(), r.RequestId)
 	return ret, err
 })
 
@@ -1,9 +1,16 @@
 package middleware
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -52,3 +59,335 @@ func TestAPIKeyValidator(t *testing.T) {
 	assert.Error(t, check("sdfsdfsd"))
 
 }
+
+func TestAuditLogger(t *testing.T) {
+
+	store := vertex.NewMemoryAuditStore(10)
+	mw := NewAuditLogger(store)
+
+	hr, _ := http.NewRequest("GET", "/foo", nil)
+	r := vertex.NewRequest(hr)
+	_, err := mw.Handle(httptest.NewRecorder(), r, vertex.HandlerFunc(func(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+		r.SetPrincipal(&vertex.Principal{ID: "alice"})
+		return nil, nil
+	}))
+	assert.NoError(t, err)
+
+	recs := store.Records("alice")
+	if assert.Len(t, recs, 1) {
+		assert.Equal(t, "request", recs[0].Event)
+		assert.Equal(t, "/foo", recs[0].Path)
+	}
+
+	data, err := vertex.ExportSubjectData("alice")
+	assert.NoError(t, err)
+	assert.Len(t, data["audit"], 1)
+}
+
+func TestPIIRedactor(t *testing.T) {
+
+	type user struct {
+		Id    string
+		Email string `pii:"true"`
+	}
+
+	store := vertex.NewMemoryAuditStore(10)
+	mw := NewPIIRedactor(store, "support")
+	h := vertex.HandlerFunc(func(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+		return user{Id: "1", Email: "alice@example.com"}, nil
+	})
+
+	hr, _ := http.NewRequest("GET", "/foo", nil)
+	r := vertex.NewRequest(hr)
+	r.SetPrincipal(&vertex.Principal{ID: "bob"})
+
+	ret, err := mw.Handle(httptest.NewRecorder(), r, h)
+	assert.NoError(t, err)
+	assert.Equal(t, vertex.PIIMask, ret.(user).Email)
+	assert.Len(t, store.Records(""), 0)
+
+	r.SetPrincipal(&vertex.Principal{ID: "bob", Scopes: []string{"support"}})
+	ret, err = mw.Handle(httptest.NewRecorder(), r, h)
+	assert.NoError(t, err)
+	assert.Equal(t, "alice@example.com", ret.(user).Email)
+
+	recs := store.Records("bob")
+	if assert.Len(t, recs, 1) {
+		assert.Equal(t, "pii_access", recs[0].Event)
+	}
+}
+
+type mockTx struct {
+	committed, rolledBack bool
+}
+
+func (t *mockTx) Commit() error {
+	t.committed = true
+	return nil
+}
+
+func (t *mockTx) Rollback() error {
+	t.rolledBack = true
+	return nil
+}
+
+func TestTransaction(t *testing.T) {
+
+	var tx *mockTx
+	mw := NewTransaction(func(r *vertex.Request) (Tx, error) {
+		tx = &mockTx{}
+		return tx, nil
+	})
+
+	hr, _ := http.NewRequest("POST", "/foo", nil)
+
+	_, err := mw.Handle(httptest.NewRecorder(), vertex.NewRequest(hr), vertex.HandlerFunc(func(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+		assert.Equal(t, tx, TransactionFrom(r))
+		// the transaction is carried by the context, for calls made with it
+		assert.Equal(t, tx, r.Context().Value(transactionKey{}))
+		return nil, nil
+	}))
+	assert.NoError(t, err)
+	assert.True(t, tx.committed)
+	assert.False(t, tx.rolledBack)
+
+	_, err = mw.Handle(httptest.NewRecorder(), vertex.NewRequest(hr), vertex.HandlerFunc(func(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+		return nil, vertex.InvalidParamError("bad")
+	}))
+	assert.Error(t, err)
+	assert.False(t, tx.committed)
+	assert.True(t, tx.rolledBack)
+
+	// panics roll back and propagate to the recovery middleware
+	_, err = AutoRecover.Handle(httptest.NewRecorder(), vertex.NewRequest(hr), vertex.HandlerFunc(func(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+		return mw.Handle(w, r, vertex.HandlerFunc(func(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+			panic("boom")
+		}))
+	}))
+	assert.Error(t, err)
+	assert.True(t, tx.rolledBack)
+	assert.False(t, tx.committed)
+}
+
+func TestTimeout(t *testing.T) {
+
+	mw := NewTimeout(20 * time.Millisecond)
+	hr, _ := http.NewRequest("GET", "/foo", nil)
+
+	// handlers waiting on the request's context are cut short at the deadline
+	r := vertex.NewRequest(hr)
+	_, err := mw.Handle(httptest.NewRecorder(), r, vertex.HandlerFunc(func(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+		deadline, ok := r.Context().Deadline()
+		assert.True(t, ok)
+		assert.Equal(t, r.Deadline, deadline)
+
+		select {
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		case <-time.After(time.Second):
+			return nil, nil
+		}
+	}))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "timed out")
+
+	// handlers finishing in time are unaffected
+	ret, err := mw.Handle(httptest.NewRecorder(), vertex.NewRequest(hr), vertex.HandlerFunc(func(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+		return "ok", nil
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", ret)
+
+	// a longer timeout does not extend an earlier deadline
+	r = vertex.NewRequest(hr)
+	cancel := r.SetDeadline(time.Now().Add(time.Millisecond))
+	defer cancel()
+	deadline := r.Deadline
+	_, err = NewTimeout(time.Hour).Handle(httptest.NewRecorder(), r, vertex.HandlerFunc(func(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+		assert.Equal(t, deadline, r.Deadline)
+		return nil, nil
+	}))
+	assert.NoError(t, err)
+}
+
+func TestWebhookSignature(t *testing.T) {
+
+	const body = `{"action":"opened"}`
+	// secrets are padded to the minimal key ring secret size
+	secret := func(name string) []byte {
+		return []byte(name + strings.Repeat(".", vertex.MinKeySize-len(name)))
+	}
+	sign := func(name, payload string) string {
+		mac := hmac.New(sha256.New, secret(name))
+		mac.Write([]byte(payload))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	check := func(s *WebhookSignature, headers map[string]string) error {
+		hr, _ := http.NewRequest("POST", "/hook", strings.NewReader(body))
+		hr.Header.Set("Content-Type", "application/json")
+		for k, v := range headers {
+			hr.Header.Set(k, v)
+		}
+		r := vertex.NewRequest(hr)
+		if err := s.Validate(r); err != nil {
+			return err
+		}
+		// the handler can still read the body
+		if raw, _ := r.RawBody(); string(raw) != body {
+			return fmt.Errorf("body consumed")
+		}
+		return nil
+	}
+
+	ring := vertex.NewKeyRing("github", vertex.Key{ID: "k1", Secret: secret("old")})
+	assert.NoError(t, ring.Rotate(vertex.Key{ID: "k2", Secret: secret("secret")}))
+	gh := NewGitHubSignature(ring)
+	assert.NoError(t, check(gh, map[string]string{"X-Hub-Signature-256": "sha256=" + sign("secret", body)}))
+	assert.NoError(t, check(gh, map[string]string{"X-Hub-Signature-256": "sha256=" + sign("old", body)}))
+	assert.Error(t, check(gh, map[string]string{"X-Hub-Signature-256": "sha256=" + sign("wrong", body)}))
+	assert.Error(t, check(gh, map[string]string{"X-Hub-Signature-256": sign("secret", body)}))
+	err := check(gh, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Missing X-Hub-Signature-256")
+
+	// retired keys are no longer accepted
+	assert.NoError(t, ring.Retire("k1"))
+	assert.Error(t, check(gh, map[string]string{"X-Hub-Signature-256": "sha256=" + sign("old", body)}))
+	assert.NoError(t, check(gh, map[string]string{"X-Hub-Signature-256": "sha256=" + sign("secret", body)}))
+
+	now := fmt.Sprint(time.Now().Unix())
+	stale := fmt.Sprint(time.Now().Add(-time.Hour).Unix())
+
+	stripe := NewStripeSignature(vertex.NewKeyRing("stripe", vertex.Key{ID: "k1", Secret: secret("whsec")}))
+	assert.NoError(t, check(stripe, map[string]string{
+		"Stripe-Signature": "t=" + now + ",v1=" + sign("other", now+"."+body) + ",v1=" + sign("whsec", now+"."+body),
+	}))
+	assert.Error(t, check(stripe, map[string]string{"Stripe-Signature": "t=" + now + ",v1=" + sign("whsec", body)}))
+	assert.Error(t, check(stripe, map[string]string{"Stripe-Signature": "t=" + stale + ",v1=" + sign("whsec", stale+"."+body)}))
+
+	slack := NewSlackSignature(vertex.NewKeyRing("slack", vertex.Key{ID: "k1", Secret: secret("shh")}))
+	assert.NoError(t, check(slack, map[string]string{
+		"X-Slack-Request-Timestamp": now,
+		"X-Slack-Signature":         "v0=" + sign("shh", "v0:"+now+":"+body),
+	}))
+	assert.Error(t, check(slack, map[string]string{
+		"X-Slack-Request-Timestamp": stale,
+		"X-Slack-Signature":         "v0=" + sign("shh", "v0:"+stale+":"+body),
+	}))
+	assert.Error(t, check(slack, map[string]string{"X-Slack-Signature": "v0=" + sign("shh", body)}))
+}
+
+func TestWebhookDedup(t *testing.T) {
+
+	calls := 0
+	var fail error
+	handler := vertex.HandlerFunc(func(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+		calls++
+		return "ok", fail
+	})
+
+	d := NewWebhookDedup("stripe", vertex.NewMemoryStore(), JSONEventID("id"))
+	deliver := func(body string) (interface{}, error) {
+		hr, _ := http.NewRequest("POST", "/hook", strings.NewReader(body))
+		hr.Header.Set("Content-Type", "application/json")
+		return d.Handle(httptest.NewRecorder(), vertex.NewRequest(hr), handler)
+	}
+
+	ret, err := deliver(`{"id":"evt_1"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", ret)
+
+	// redeliveries are acknowledged without processing
+	ret, err = deliver(`{"id":"evt_1"}`)
+	assert.NoError(t, err)
+	assert.Nil(t, ret)
+	assert.Equal(t, 1, calls)
+
+	// failed events are processed again
+	fail = errors.New("boom")
+	_, err = deliver(`{"id":"evt_2"}`)
+	assert.Error(t, err)
+	fail = nil
+	_, err = deliver(`{"id":"evt_2"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	// events without ids are always processed
+	deliver(`{}`)
+	deliver(`{}`)
+	assert.Equal(t, 5, calls)
+
+	// redeliveries while the first is processing are retried later
+	assert.NoError(t, d.store.Set(d.key("evt_3"), []byte(dedupProcessing), time.Minute))
+	_, err = deliver(`{"id":"evt_3"}`)
+	assert.Error(t, err)
+	assert.Equal(t, 5, calls)
+
+	// the claim of a handler running longer than its ttl is renewed, so redeliveries still wait for it
+	d.ProcessingTTL = 20 * time.Millisecond
+	started, release := make(chan struct{}), make(chan struct{})
+	slow := vertex.HandlerFunc(func(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+		close(started)
+		<-release
+		return "ok", nil
+	})
+	slowDone := make(chan error)
+	go func() {
+		hr, _ := http.NewRequest("POST", "/hook", strings.NewReader(`{"id":"evt_4"}`))
+		hr.Header.Set("Content-Type", "application/json")
+		_, err := d.Handle(httptest.NewRecorder(), vertex.NewRequest(hr), slow)
+		slowDone <- err
+	}()
+	<-started
+	time.Sleep(100 * time.Millisecond)
+	_, err = deliver(`{"id":"evt_4"}`)
+	assert.Error(t, err)
+	close(release)
+	assert.NoError(t, <-slowDone)
+	ret, err = deliver(`{"id":"evt_4"}`)
+	assert.NoError(t, err)
+	assert.Nil(t, ret)
+	assert.Equal(t, 5, calls)
+
+	// claims last until the request's deadline
+	hr, _ := http.NewRequest("POST", "/hook", nil)
+	r := vertex.NewRequest(hr)
+	assert.Equal(t, 20*time.Millisecond, d.processingTTL(r))
+	defer r.SetDeadline(time.Now().Add(time.Hour))()
+	assert.True(t, d.processingTTL(r) > 59*time.Minute)
+}
+
+func TestClientIdentifier(t *testing.T) {
+
+	ci := NewClientIdentifier().Block("shopper-ios", "3.2.0", "This version loses carts, please upgrade")
+	identify := func(client, ua string) (*vertex.Request, error) {
+		hr, _ := http.NewRequest("GET", "/foo", nil)
+		hr.Header.Set(vertex.HeaderClient, client)
+		hr.Header.Set("User-Agent", ua)
+		r := vertex.NewRequest(hr)
+		_, err := ci.Handle(httptest.NewRecorder(), r, mockkHandler)
+		return r, err
+	}
+
+	r, err := identify("shopper-ios/3.2.1 (iOS 14.2)", "okhttp/4.9.0")
+	assert.NoError(t, err)
+	assert.Equal(t, &vertex.Client{Name: "shopper-ios", Version: "3.2.1", Platform: "iOS 14.2"}, r.Client())
+
+	// the user agent identifies clients without the client header
+	r, err = identify("", "okhttp/4.9.0")
+	assert.NoError(t, err)
+	assert.Equal(t, "okhttp", r.Client().Name)
+
+	r, err = identify("", "")
+	assert.NoError(t, err)
+	assert.Nil(t, r.Client())
+
+	_, err = identify("shopper-ios/3.2.0 (iOS 14.2)", "")
+	if assert.Error(t, err) {
+		assert.Equal(t, "This version loses carts, please upgrade", err.Error())
+	}
+
+	// audit records carry the client
+	r, _ = identify("shopper-ios/3.2.1", "")
+	assert.Equal(t, "shopper-ios/3.2.1", vertex.NewAuditRecord(r, "request", nil).Client)
+}
@@ -0,0 +1,459 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/dvirsky/go-pylog/logging"
+
+	"github.com/EverythingMe/vertex"
+)
+
+// Default token lifetimes of a TokenIssuer
+const (
+	DefaultAccessTTL  = 15 * time.Minute
+	DefaultRefreshTTL = 30 * 24 * time.Hour
+)
+
+// TokenPath is the path of the token route, relative to the API
+const TokenPath = "/token"
+
+const (
+	typeAccess  = "access"
+	typeRefresh = "refresh"
+)
+
+// TokenPair is the response of the token route, in the format of an OAuth2 token response
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// LoginFunc checks the credentials of a user logging in to the token route, and returns the user's id and scopes
+type LoginFunc func(r *vertex.Request, username, password string) (subject string, scopes []string, err error)
+
+// RefreshToken is a live refresh token, kept in a RefreshStore until it is used, revoked or expires
+type RefreshToken struct {
+	ID      string
+	Subject string
+	Scopes  []string
+
+	// The id of the login the token descends from, shared by all the tokens rotated from it
+	Family string
+
+	Expires time.Time
+}
+
+// RefreshStore stores the live refresh tokens of a TokenIssuer, e.g. in redis or a database table, so tokens survive
+// restarts and can be refreshed on any instance of a service
+type RefreshStore interface {
+	// Save stores a token
+	Save(t RefreshToken) error
+
+	// Delete deletes a token by id and returns it. It returns false if there was no such token, so of concurrent
+	// refreshes with a token only one succeeds
+	Delete(id string) (RefreshToken, bool, error)
+
+	// DeleteFamily deletes all the tokens of a family
+	DeleteFamily(family string) error
+
+	// DeleteSubject deletes all the tokens of a subject
+	DeleteSubject(subject string) error
+}
+
+// TokenIssuer mints and verifies HS256 signed JWT access tokens, and rotating refresh tokens to renew them.
+//
+// Refresh tokens can be used only once - each refresh returns a new refresh token. If a used refresh token is
+// presented again, it was probably stolen, so all the tokens descending from the same login are revoked.
+//
+// Live refresh tokens are kept in memory, unless the issuer is given a shared Store
+type TokenIssuer struct {
+	// The issuer (iss) claim of the tokens
+	Issuer string
+
+	// Lifetime of access and refresh tokens
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+
+	// Claims, if set, adds custom claims to the access tokens of a subject. It is called again on every refresh,
+	// so changes to the user are picked up
+	Claims func(subject string) (map[string]interface{}, error)
+
+	// Store keeps the live refresh tokens. Defaults to a MemoryRefreshStore
+	Store RefreshStore
+
+	keys *vertex.KeyRing
+}
+
+// NewTokenIssuer creates a token issuer signing with the given key. It panics if the key is shorter than
+// vertex.MinKeySize, since anyone could forge tokens signed with an empty or short key
+func NewTokenIssuer(key []byte, issuer string) *TokenIssuer {
+	if len(key) < vertex.MinKeySize {
+		panic(fmt.Sprintf("Token signing key is %d bytes long, at least %d are required", len(key), vertex.MinKeySize))
+	}
+	return NewKeyRingTokenIssuer(vertex.NewKeyRing("token", vertex.Key{ID: "default", Secret: key}), issuer)
+}
+
+// NewKeyRingTokenIssuer creates a token issuer signing with the primary key of a key ring. Tokens carry the id of
+// their key, so tokens signed before a rotation are verified as long as their key is in the ring
+//
+// The issuer is registered as a subject data store, so data subject purges revoke the refresh tokens of a subject
+func NewKeyRingTokenIssuer(keys *vertex.KeyRing, issuer string) *TokenIssuer {
+	t := &TokenIssuer{
+		Issuer:     issuer,
+		AccessTTL:  DefaultAccessTTL,
+		RefreshTTL: DefaultRefreshTTL,
+		Store:      NewMemoryRefreshStore(),
+		keys:       keys,
+	}
+	vertex.AddSubjectStore("refresh_tokens", t)
+	return t
+}
+
+func newTokenID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func (t *TokenIssuer) sign(claims map[string]interface{}) (string, error) {
+
+	key, ok := t.keys.Primary()
+	if !ok {
+		return "", logging.Errorf("No signing key in key ring %s", t.keys.Name())
+	}
+
+	token := jwt.New(jwt.SigningMethodHS256)
+	token.Header["kid"] = key.ID
+	for k, v := range claims {
+		token.Claims[k] = v
+	}
+	return token.SignedString(key.Secret)
+}
+
+// Issue mints a new access and refresh token pair for a subject, e.g. after the user logged in
+func (t *TokenIssuer) Issue(subject string, scopes []string) (*TokenPair, error) {
+	return t.issue(subject, scopes, newTokenID())
+}
+
+func (t *TokenIssuer) issue(subject string, scopes []string, family string) (*TokenPair, error) {
+
+	now := time.Now()
+
+	claims := map[string]interface{}{}
+	if t.Claims != nil {
+		custom, err := t.Claims(subject)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range custom {
+			claims[k] = v
+		}
+	}
+
+	scope := strings.Join(scopes, " ")
+	claims["iss"] = t.Issuer
+	claims["sub"] = subject
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(t.AccessTTL).Unix()
+	claims["scope"] = scope
+	claims["typ"] = typeAccess
+
+	access, err := t.sign(claims)
+	if err != nil {
+		return nil, logging.Errorf("Could not sign access token: %s", err)
+	}
+
+	jti := newTokenID()
+	expires := now.Add(t.RefreshTTL)
+	refresh, err := t.sign(map[string]interface{}{
+		"iss": t.Issuer,
+		"sub": subject,
+		"iat": now.Unix(),
+		"exp": expires.Unix(),
+		"jti": jti,
+		"fam": family,
+		"typ": typeRefresh,
+	})
+	if err != nil {
+		return nil, logging.Errorf("Could not sign refresh token: %s", err)
+	}
+
+	err = t.Store.Save(RefreshToken{ID: jti, Subject: subject, Scopes: scopes, Family: family, Expires: expires})
+	if err != nil {
+		return nil, logging.Errorf("Could not save refresh token: %s", err)
+	}
+
+	return &TokenPair{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(t.AccessTTL.Seconds()),
+		RefreshToken: refresh,
+		Scope:        scope,
+	}, nil
+}
+
+// parse verifies a token's signature, expiration, issuer and type, and returns its claims
+func (t *TokenIssuer) parse(raw, typ string) (map[string]interface{}, error) {
+
+	token, err := jwt.Parse(raw, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, logging.Errorf("Unexpected signing method %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		key, found := t.keys.Key(kid)
+		if !found {
+			return nil, logging.Errorf("Unknown signing key '%s'", kid)
+		}
+		return key.Secret, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, vertex.InvalidCredentialsError("Invalid token: %s", err)
+	}
+
+	if iss, _ := token.Claims["iss"].(string); iss != t.Issuer {
+		return nil, vertex.InvalidCredentialsError("Invalid token issuer")
+	}
+	if tt, _ := token.Claims["typ"].(string); tt != typ {
+		return nil, vertex.InvalidCredentialsError("Invalid token type")
+	}
+	if sub, _ := token.Claims["sub"].(string); sub == "" {
+		return nil, vertex.InvalidCredentialsError("Token has no subject")
+	}
+
+	return token.Claims, nil
+}
+
+// Refresh exchanges a refresh token for a new token pair. The refresh token is consumed
+func (t *TokenIssuer) Refresh(refreshToken string) (*TokenPair, error) {
+
+	claims, err := t.parse(refreshToken, typeRefresh)
+	if err != nil {
+		return nil, err
+	}
+	jti, _ := claims["jti"].(string)
+	family, _ := claims["fam"].(string)
+
+	entry, found, err := t.Store.Delete(jti)
+	if err != nil {
+		return nil, logging.Errorf("Could not load refresh token: %s", err)
+	}
+	if !found {
+		// a valid token we don't know was either already used or revoked - revoke its descendants too
+		logging.Warning("Reuse of refresh token of %s, revoking its tokens", claims["sub"])
+		if err := t.Store.DeleteFamily(family); err != nil {
+			logging.Error("Could not revoke refresh tokens of %s: %s", claims["sub"], err)
+		}
+		return nil, vertex.InvalidCredentialsError("Refresh token was already used or revoked")
+	}
+
+	return t.issue(entry.Subject, entry.Scopes, entry.Family)
+}
+
+// Revoke revokes all the refresh tokens of a subject, e.g. when the user logs out or changes a password.
+// Issued access tokens remain valid until they expire
+func (t *TokenIssuer) Revoke(subject string) error {
+	return t.Store.DeleteSubject(subject)
+}
+
+// ExportSubject returns the live refresh tokens of a subject, if the store can list them
+func (t *TokenIssuer) ExportSubject(subject string) (interface{}, error) {
+	if s, ok := t.Store.(vertex.SubjectDataStore); ok {
+		return s.ExportSubject(subject)
+	}
+	return []RefreshToken{}, nil
+}
+
+// PurgeSubject revokes all the refresh tokens of a subject. The number of revoked tokens is only returned if the
+// store can count them
+func (t *TokenIssuer) PurgeSubject(subject string) (int, error) {
+	if s, ok := t.Store.(vertex.SubjectDataStore); ok {
+		return s.PurgeSubject(subject)
+	}
+	return 0, t.Store.DeleteSubject(subject)
+}
+
+// Verify verifies an access token and returns its principal
+func (t *TokenIssuer) Verify(accessToken string) (*vertex.Principal, error) {
+
+	claims, err := t.parse(accessToken, typeAccess)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &vertex.Principal{
+		Attributes: map[string]interface{}{},
+	}
+	for k, v := range claims {
+		switch k {
+		case "sub":
+			p.ID = v.(string)
+		case "scope":
+			s, _ := v.(string)
+			p.Scopes = strings.Fields(s)
+		case "iss", "iat", "exp", "typ":
+		default:
+			p.Attributes[k] = v
+		}
+	}
+	return p, nil
+}
+
+// Validate authenticates requests by the bearer access token in their Authorization header, making the issuer
+// a SecurityScheme
+func (t *TokenIssuer) Validate(r *vertex.Request) error {
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return vertex.MissingCredentialsError("Missing bearer token")
+	}
+
+	p, err := t.Verify(strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")))
+	if err != nil {
+		return err
+	}
+
+	r.SetPrincipal(p)
+	return nil
+}
+
+// Challenge asks clients without a token to authenticate with a bearer token, making the issuer a vertex.Challenger
+func (t *TokenIssuer) Challenge(r *vertex.Request) string {
+	return `Bearer realm="` + t.Issuer + `"`
+}
+
+// TokenRoute returns a reference token route, supporting the OAuth2 password and refresh_token grants.
+// login checks the credentials of the password grant
+func (t *TokenIssuer) TokenRoute(login LoginFunc) vertex.Route {
+
+	handler := func(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+
+		// tokens must never be cached
+		w.Header().Set("Cache-Control", "no-store")
+
+		switch grant := r.FormValue("grant_type"); grant {
+		case "password":
+			subject, scopes, err := login(r, r.FormValue("username"), r.FormValue("password"))
+			if err != nil {
+				logging.Warning("Failed login of %s: %s", r.FormValue("username"), err)
+				e := vertex.NewSecurityEvent(r, vertex.EventAuthFailure, "invalid_credentials")
+				e.PrincipalID = r.FormValue("username")
+				vertex.EmitSecurityEvent(e)
+				return nil, vertex.InvalidCredentialsError("Invalid credentials")
+			}
+
+			e := vertex.NewSecurityEvent(r, vertex.EventAuthSuccess, "password")
+			e.PrincipalID = subject
+			vertex.EmitSecurityEvent(e)
+			return t.Issue(subject, scopes)
+
+		case "refresh_token":
+			return t.Refresh(r.FormValue("refresh_token"))
+
+		case "":
+			return nil, vertex.MissingParamError("Missing grant_type")
+		default:
+			return nil, vertex.InvalidParamError("Unsupported grant type %s", grant)
+		}
+	}
+
+	return vertex.Route{
+		Path:        TokenPath,
+		Description: "Issue access tokens with a password or refresh token",
+		Handler:     vertex.HandlerFunc(handler),
+		Methods:     vertex.POST,
+		Security:    vertex.NopSecurity,
+		Returns:     TokenPair{},
+	}
+}
+
+// MemoryRefreshStore is a refresh token store keeping tokens in memory, for tests and single instance services
+type MemoryRefreshStore struct {
+	mtx    sync.Mutex
+	tokens map[string]RefreshToken
+}
+
+// NewMemoryRefreshStore creates an empty memory refresh token store
+func NewMemoryRefreshStore() *MemoryRefreshStore {
+	return &MemoryRefreshStore{tokens: map[string]RefreshToken{}}
+}
+
+func (s *MemoryRefreshStore) Save(t RefreshToken) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	// expired tokens are purged as new ones are issued
+	now := time.Now()
+	for id, existing := range s.tokens {
+		if now.After(existing.Expires) {
+			delete(s.tokens, id)
+		}
+	}
+	s.tokens[t.ID] = t
+	return nil
+}
+
+func (s *MemoryRefreshStore) Delete(id string) (RefreshToken, bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	t, found := s.tokens[id]
+	delete(s.tokens, id)
+	return t, found, nil
+}
+
+func (s *MemoryRefreshStore) DeleteFamily(family string) error {
+	s.deleteWhere(func(t RefreshToken) bool { return t.Family == family })
+	return nil
+}
+
+func (s *MemoryRefreshStore) DeleteSubject(subject string) error {
+	s.deleteWhere(func(t RefreshToken) bool { return t.Subject == subject })
+	return nil
+}
+
+// ExportSubject returns the live refresh tokens of a subject, without their ids
+func (s *MemoryRefreshStore) ExportSubject(subject string) (interface{}, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	ret := []RefreshToken{}
+	for _, t := range s.tokens {
+		if t.Subject == subject {
+			t.ID = ""
+			ret = append(ret, t)
+		}
+	}
+	return ret, nil
+}
+
+// PurgeSubject deletes all the tokens of a subject, returning their number
+func (s *MemoryRefreshStore) PurgeSubject(subject string) (int, error) {
+	return s.deleteWhere(func(t RefreshToken) bool { return t.Subject == subject }), nil
+}
+
+// deleteWhere deletes the matching tokens, returning their number
+func (s *MemoryRefreshStore) deleteWhere(match func(RefreshToken) bool) int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	n := 0
+	for id, t := range s.tokens {
+		if match(t) {
+			delete(s.tokens, id)
+			n++
+		}
+	}
+	return n
+}
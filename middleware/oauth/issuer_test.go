@@ -0,0 +1,228 @@
+package oauth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/EverythingMe/vertex"
+)
+
+// test signing keys, as long as key rings require
+var (
+	testKey  = []byte("vertex-token-test-signing-key-01")
+	otherKey = []byte("vertex-token-test-signing-key-02")
+)
+
+func TestTokenIssuer(t *testing.T) {
+
+	issuer := NewTokenIssuer(testKey, "vertex")
+	issuer.Claims = func(subject string) (map[string]interface{}, error) {
+		return map[string]interface{}{"email": subject + "@example.com"}, nil
+	}
+
+	pair, err := issuer.Issue("alice", []string{"read", "write"})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, "Bearer", pair.TokenType)
+	assert.Equal(t, int(DefaultAccessTTL.Seconds()), pair.ExpiresIn)
+
+	p, err := issuer.Verify(pair.AccessToken)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "alice", p.ID)
+		assert.True(t, p.HasScope("write"))
+		assert.Equal(t, "alice@example.com", p.Attributes["email"])
+	}
+
+	// refresh tokens are not access tokens and vice versa
+	_, err = issuer.Verify(pair.RefreshToken)
+	assert.Error(t, err)
+	_, err = issuer.Refresh(pair.AccessToken)
+	assert.Error(t, err)
+
+	// tokens of other keys and issuers are rejected
+	_, err = NewTokenIssuer(otherKey, "vertex").Verify(pair.AccessToken)
+	assert.Error(t, err)
+	_, err = NewTokenIssuer(testKey, "other").Verify(pair.AccessToken)
+	assert.Error(t, err)
+
+	// refreshing rotates the refresh token
+	renewed, err := issuer.Refresh(pair.RefreshToken)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.True(t, renewed.RefreshToken != pair.RefreshToken)
+	p, err = issuer.Verify(renewed.AccessToken)
+	if assert.NoError(t, err) {
+		assert.True(t, p.HasScope("read"))
+	}
+
+	// reusing a refresh token revokes the whole family
+	_, err = issuer.Refresh(pair.RefreshToken)
+	assert.Error(t, err)
+	_, err = issuer.Refresh(renewed.RefreshToken)
+	assert.Error(t, err)
+
+	// revoking a subject
+	pair, _ = issuer.Issue("bob", nil)
+	assert.NoError(t, issuer.Revoke("bob"))
+	_, err = issuer.Refresh(pair.RefreshToken)
+	assert.Error(t, err)
+
+	// expired access tokens
+	issuer.AccessTTL = -time.Minute
+	pair, _ = issuer.Issue("alice", nil)
+	_, err = issuer.Verify(pair.AccessToken)
+	assert.Error(t, err)
+}
+
+func TestRefreshStore(t *testing.T) {
+
+	// issuers sharing a store refresh each other's tokens
+	store := NewMemoryRefreshStore()
+	one, other := NewTokenIssuer(testKey, "vertex"), NewTokenIssuer(testKey, "vertex")
+	one.Store, other.Store = store, store
+
+	pair, err := one.Issue("alice", []string{"read"})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	renewed, err := other.Refresh(pair.RefreshToken)
+	assert.NoError(t, err)
+
+	// and see the tokens they used
+	_, err = one.Refresh(pair.RefreshToken)
+	assert.Error(t, err)
+	_, err = one.Refresh(renewed.RefreshToken)
+	assert.Error(t, err)
+}
+
+func TestTokenIssuerKey(t *testing.T) {
+
+	// tokens signed with empty or short keys could be forged by anyone
+	assert.Panics(t, func() { NewTokenIssuer(nil, "vertex") })
+	assert.Panics(t, func() { NewTokenIssuer([]byte("secret"), "vertex") })
+	assert.NotPanics(t, func() { NewTokenIssuer(testKey, "vertex") })
+}
+
+func TestTokenIssuerRotation(t *testing.T) {
+
+	ring := vertex.NewKeyRing("tokens", vertex.Key{ID: "k1", Secret: testKey})
+	issuer := NewKeyRingTokenIssuer(ring, "vertex")
+
+	old, err := issuer.Issue("alice", nil)
+	assert.NoError(t, err)
+
+	assert.NoError(t, ring.Rotate(vertex.Key{ID: "k2", Secret: otherKey}))
+	renewed, err := issuer.Issue("alice", nil)
+	assert.NoError(t, err)
+
+	// tokens of both keys are valid until the old key is retired
+	_, err = issuer.Verify(old.AccessToken)
+	assert.NoError(t, err)
+	_, err = issuer.Verify(renewed.AccessToken)
+	assert.NoError(t, err)
+
+	assert.NoError(t, ring.Retire("k1"))
+	_, err = issuer.Verify(old.AccessToken)
+	assert.Error(t, err)
+	_, err = issuer.Verify(renewed.AccessToken)
+	assert.NoError(t, err)
+}
+
+func TestTokenRoute(t *testing.T) {
+
+	issuer := NewTokenIssuer(testKey, "vertex")
+	route := issuer.TokenRoute(func(r *vertex.Request, username, password string) (string, []string, error) {
+		if username == "alice" && password == "pass" {
+			return "alice", []string{"read"}, nil
+		}
+		return "", nil, errors.New("bad password")
+	})
+
+	call := func(form url.Values) (*httptest.ResponseRecorder, interface{}, error) {
+		hr, _ := http.NewRequest("POST", "/api/1.0"+TokenPath, strings.NewReader(form.Encode()))
+		hr.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		v, err := route.Handler.Handle(w, vertex.NewRequest(hr))
+		return w, v, err
+	}
+
+	_, _, err := call(url.Values{"grant_type": {"password"}, "username": {"alice"}, "password": {"wrong"}})
+	assert.Error(t, err)
+	_, _, err = call(url.Values{"grant_type": {"client_credentials"}})
+	assert.Error(t, err)
+
+	w, v, err := call(url.Values{"grant_type": {"password"}, "username": {"alice"}, "password": {"pass"}})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+	b, _ := json.Marshal(v)
+	assert.Contains(t, string(b), `"token_type":"Bearer"`)
+
+	// the access token authenticates requests
+	hr, _ := http.NewRequest("GET", "/api/1.0/foo", nil)
+	hr.Header.Set("Authorization", "Bearer "+v.(*TokenPair).AccessToken)
+	r := vertex.NewRequest(hr)
+	assert.NoError(t, issuer.Validate(r))
+	assert.Equal(t, "alice", r.PrincipalID())
+
+	hr, _ = http.NewRequest("GET", "/api/1.0/foo", nil)
+	r = vertex.NewRequest(hr)
+	assert.Error(t, issuer.Validate(r))
+	assert.Equal(t, `Bearer realm="vertex"`, issuer.Challenge(r))
+
+	_, v, err = call(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {v.(*TokenPair).RefreshToken}})
+	assert.NoError(t, err)
+	assert.NotNil(t, v)
+}
+
+func TestPurgeSubject(t *testing.T) {
+
+	issuer := NewTokenIssuer(testKey, "vertex")
+	throttle := vertex.NewLoginThrottle("purge", vertex.NewMemoryStore())
+	throttle.MaxFailures = 1
+	resets := vertex.OneTimeTokens{Purpose: "password_reset", Store: vertex.NewMemoryTokenStore()}
+
+	pair, err := issuer.Issue("alice", []string{"read"})
+	assert.NoError(t, err)
+	bobs, err := issuer.Issue("bob", []string{"read"})
+	assert.NoError(t, err)
+
+	hr, _ := http.NewRequest("POST", "/login", nil)
+	r := vertex.NewRequest(hr)
+	throttle.Failed(r, "alice")
+	r.RemoteIP = "192.0.2.1"
+	assert.Error(t, throttle.Check(r, "alice"))
+
+	reset, err := resets.Issue("alice", nil)
+	assert.NoError(t, err)
+
+	data, err := vertex.ExportSubjectData("alice")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, data)
+
+	_, err = vertex.PurgeSubjectData("alice")
+	assert.NoError(t, err)
+
+	// refresh tokens, lockouts and one-time tokens of the subject are gone
+	_, err = issuer.Refresh(pair.RefreshToken)
+	assert.Error(t, err)
+	assert.NoError(t, throttle.Check(r, "alice"))
+	_, err = resets.Verify(reset)
+	assert.Error(t, err)
+
+	// and other subjects keep theirs
+	_, err = issuer.Refresh(bobs.RefreshToken)
+	assert.NoError(t, err)
+}
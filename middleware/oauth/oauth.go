@@ -219,6 +219,9 @@ func (o *OAuthMiddleware) Handle(w http.ResponseWriter, r *vertex.Request, next
 
 	logging.Info("Request authenticated. Continuing!")
 	r.SetAttribute(AttrUser, user)
+	if id, ok := user.(string); ok {
+		r.SetPrincipal(&vertex.Principal{ID: id})
+	}
 
 	return next(w, r)
 }
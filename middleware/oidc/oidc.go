@@ -0,0 +1,388 @@
+// Package oidc implements the OpenID Connect login flow for browser based apps, such as internal dashboards.
+//
+// A Provider adds /login, /callback and /logout routes to an API, keeps the logged in user in an encrypted session
+// cookie, and authenticates requests from that session, either as a SecurityScheme or as a middleware redirecting
+// anonymous users to the login page:
+//
+//	provider, err := oidc.New(&config, sessionKey)
+//	...
+//	api := &vertex.API{
+//		DefaultSecurityScheme: provider,
+//		Routes: append(routes, provider.Routes()...),
+//	}
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+	"golang.org/x/oauth2"
+
+	"github.com/EverythingMe/vertex"
+)
+
+// Paths of the login flow routes, relative to the API
+const (
+	LoginPath    = "/login"
+	CallbackPath = "/callback"
+	LogoutPath   = "/logout"
+)
+
+// AttrSession is the request attribute holding the Session of authenticated requests
+const AttrSession = "oidc_session"
+
+// Config configures an OpenID Connect provider
+type Config struct {
+	// The issuer URL of the provider. Endpoints not set below are discovered from it
+	Issuer string `yaml:"issuer"`
+
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+
+	// The full URL of the API's callback route. The paths of the other login flow routes are derived from it
+	RedirectURL string `yaml:"redirect_url"`
+
+	// Requested scopes. openid is always requested
+	Scopes []string `yaml:"scopes"`
+
+	AuthURL       string `yaml:"auth_url"`
+	TokenURL      string `yaml:"token_url"`
+	JWKSURL       string `yaml:"jwks_url"`
+	EndSessionURL string `yaml:"end_session_url"`
+
+	// Where to send users after logging out
+	PostLogoutURL string `yaml:"post_logout_url"`
+
+	// How long sessions last. Defaults to 8 hours
+	SessionTTL int `yaml:"session_ttl_sec"`
+
+	// The name of a registered vertex.KeyRing encrypting the session cookies, if no session keys are given to New
+	KeyRing string `yaml:"key_ring"`
+}
+
+// Session is the logged in user, stored in the session cookie
+type Session struct {
+	Subject string    `json:"sub"`
+	Email   string    `json:"email,omitempty"`
+	Name    string    `json:"name,omitempty"`
+	Expires time.Time `json:"exp"`
+}
+
+// loginState is kept in a cookie during the login flow, to validate the callback
+type loginState struct {
+	State string `json:"state"`
+	Nonce string `json:"nonce"`
+	Next  string `json:"next"`
+}
+
+// Provider runs the login flow against an OpenID Connect provider, and authenticates requests from the
+// resulting sessions
+type Provider struct {
+	config  Config
+	oauth   *oauth2.Config
+	keys    *keySet
+	state   vertex.Cookie
+	session vertex.Cookie
+
+	// path => method of the login flow requests the middleware lets through without a session
+	public map[string]string
+}
+
+// New creates a provider, discovering the provider's endpoints if needed. The session keys encrypt the session
+// and login state cookies - see vertex.Cookie for key rotation
+func New(config *Config, sessionKeys ...[]byte) (*Provider, error) {
+
+	var ring *vertex.KeyRing
+	if len(sessionKeys) == 0 {
+		if ring = vertex.GetKeyRing(config.KeyRing); ring == nil {
+			return nil, logging.Errorf("No session keys given, and key ring '%s' is not registered", config.KeyRing)
+		}
+	}
+
+	redirect, err := url.Parse(config.RedirectURL)
+	if err != nil || !strings.HasSuffix(redirect.Path, CallbackPath) {
+		return nil, logging.Errorf("Redirect URL '%s' is not the URL of the %s route", config.RedirectURL, CallbackPath)
+	}
+	base := strings.TrimSuffix(redirect.Path, CallbackPath)
+
+	conf := *config
+	if conf.AuthURL == "" || conf.TokenURL == "" || conf.JWKSURL == "" {
+		if err := discover(&conf); err != nil {
+			return nil, logging.Errorf("Could not discover OpenID configuration of %s: %s", conf.Issuer, err)
+		}
+	}
+
+	ttl := time.Duration(conf.SessionTTL) * time.Second
+	if ttl <= 0 {
+		ttl = 8 * time.Hour
+	}
+
+	scopes := []string{"openid"}
+	for _, s := range conf.Scopes {
+		if s != "openid" {
+			scopes = append(scopes, s)
+		}
+	}
+
+	return &Provider{
+		config: conf,
+		oauth: &oauth2.Config{
+			ClientID:     conf.ClientID,
+			ClientSecret: conf.ClientSecret,
+			RedirectURL:  conf.RedirectURL,
+			Scopes:       scopes,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  conf.AuthURL,
+				TokenURL: conf.TokenURL,
+			},
+		},
+		keys: newKeySet(conf.JWKSURL),
+		state: vertex.Cookie{
+			Name:      "oidc_state",
+			MaxAge:    10 * time.Minute,
+			Encrypted: true,
+			Keys:      sessionKeys,
+			KeyRing:   ring,
+		},
+		session: vertex.Cookie{
+			Name:      "oidc_session",
+			MaxAge:    ttl,
+			Encrypted: true,
+			Keys:      sessionKeys,
+			KeyRing:   ring,
+		},
+		public: map[string]string{
+			base + LoginPath:    "GET",
+			base + CallbackPath: "GET",
+			base + LogoutPath:   "POST",
+		},
+	}, nil
+}
+
+// discover fills the missing endpoints of a config from the provider's discovery document
+func discover(conf *Config) error {
+
+	resp, err := http.Get(strings.TrimSuffix(conf.Issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Discovery failed: %s", resp.Status)
+	}
+
+	var doc struct {
+		Issuer        string `json:"issuer"`
+		AuthURL       string `json:"authorization_endpoint"`
+		TokenURL      string `json:"token_endpoint"`
+		JWKSURL       string `json:"jwks_uri"`
+		EndSessionURL string `json:"end_session_endpoint"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return err
+	}
+
+	if doc.Issuer != conf.Issuer {
+		return fmt.Errorf("Issuer mismatch: %s", doc.Issuer)
+	}
+
+	fill := func(dst *string, v string) {
+		if *dst == "" {
+			*dst = v
+		}
+	}
+	fill(&conf.AuthURL, doc.AuthURL)
+	fill(&conf.TokenURL, doc.TokenURL)
+	fill(&conf.JWKSURL, doc.JWKSURL)
+	fill(&conf.EndSessionURL, doc.EndSessionURL)
+
+	return nil
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// safeNext makes sure we only redirect to local paths after logging in
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// startLogin redirects the user to the provider, remembering where to send them back when they are logged in
+func (p *Provider) startLogin(w http.ResponseWriter, r *vertex.Request, next string) error {
+
+	ls := loginState{
+		State: randomString(),
+		Nonce: randomString(),
+		Next:  safeNext(next),
+	}
+	if err := p.state.Set(w, r, ls); err != nil {
+		return err
+	}
+
+	http.Redirect(w, r.Request, p.oauth.AuthCodeURL(ls.State, oauth2.SetAuthURLParam("nonce", ls.Nonce)), http.StatusFound)
+	return vertex.Hijacked
+}
+
+func (p *Provider) login(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+	return nil, p.startLogin(w, r, r.FormValue("next"))
+}
+
+func (p *Provider) callback(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+
+	if e := r.FormValue("error"); e != "" {
+		return nil, vertex.UnauthorizedError("Login failed: %s", e)
+	}
+
+	var ls loginState
+	if err := p.state.Get(r, &ls); err != nil {
+		return nil, vertex.UnauthorizedError("Missing or invalid login state")
+	}
+	p.state.Delete(w, r)
+
+	if subtle.ConstantTimeCompare([]byte(ls.State), []byte(r.FormValue("state"))) != 1 {
+		return nil, vertex.UnauthorizedError("Invalid login state")
+	}
+
+	tok, err := p.oauth.Exchange(r.Context(), r.FormValue("code"))
+	if err != nil {
+		logging.Warning("Could not exchange the login code: %s", err)
+		return nil, vertex.UnauthorizedError("Could not log you in")
+	}
+
+	raw, ok := tok.Extra("id_token").(string)
+	if !ok {
+		return nil, vertex.UnauthorizedError("No ID token returned by the provider")
+	}
+
+	claims, err := p.verify(raw, ls.Nonce)
+	if err != nil {
+		logging.Warning("Invalid ID token: %s", err)
+		return nil, vertex.UnauthorizedError("Invalid ID token")
+	}
+
+	sess := Session{
+		Subject: claims.Subject,
+		Email:   claims.Email,
+		Name:    claims.Name,
+		Expires: time.Now().Add(p.session.MaxAge),
+	}
+	if err := p.session.Set(w, r, sess); err != nil {
+		return nil, err
+	}
+
+	logging.Info("User %s logged in", claims.Subject)
+	e := vertex.NewSecurityEvent(r, vertex.EventAuthSuccess, "oidc")
+	e.PrincipalID = claims.Subject
+	vertex.EmitSecurityEvent(e)
+	http.Redirect(w, r.Request, ls.Next, http.StatusSeeOther)
+	return nil, vertex.Hijacked
+}
+
+func (p *Provider) logout(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+
+	p.session.Delete(w, r)
+
+	target := p.config.PostLogoutURL
+	if p.config.EndSessionURL != "" {
+		v := url.Values{"client_id": {p.config.ClientID}}
+		if target != "" {
+			v.Set("post_logout_redirect_uri", target)
+		}
+		target = p.config.EndSessionURL + "?" + v.Encode()
+	} else if target == "" {
+		target = "/"
+	}
+
+	http.Redirect(w, r.Request, target, http.StatusSeeOther)
+	return nil, vertex.Hijacked
+}
+
+// Routes returns the login flow routes, to be added to the API
+func (p *Provider) Routes() vertex.Routes {
+	return vertex.Routes{
+		{
+			Path:        LoginPath,
+			Description: "OpenID Connect login",
+			Handler:     vertex.HandlerFunc(p.login),
+			Methods:     vertex.GET,
+			Security:    vertex.NopSecurity,
+		},
+		{
+			Path:        CallbackPath,
+			Description: "OpenID Connect login callback",
+			Handler:     vertex.HandlerFunc(p.callback),
+			Methods:     vertex.GET,
+			Security:    vertex.NopSecurity,
+		},
+		{
+			Path:        LogoutPath,
+			Description: "Log out",
+			Handler:     vertex.HandlerFunc(p.logout),
+			Methods:     vertex.POST,
+			Security:    vertex.NopSecurity,
+		},
+	}
+}
+
+// Session returns the logged in session of a request
+func (p *Provider) Session(r *vertex.Request) (*Session, error) {
+
+	sess := &Session{}
+	if err := p.session.Get(r, sess); err != nil {
+		return nil, err
+	}
+	if time.Now().After(sess.Expires) {
+		return nil, fmt.Errorf("Session of %s expired", sess.Subject)
+	}
+	return sess, nil
+}
+
+// Validate authenticates a request from its session, making the provider a SecurityScheme
+func (p *Provider) Validate(r *vertex.Request) error {
+
+	sess, err := p.Session(r)
+	if err != nil {
+		return vertex.MissingCredentialsError("Not logged in")
+	}
+
+	r.SetAttribute(AttrSession, sess)
+	r.SetPrincipal(&vertex.Principal{
+		ID: sess.Subject,
+		Attributes: map[string]interface{}{
+			"email": sess.Email,
+			"name":  sess.Name,
+		},
+	})
+	return nil
+}
+
+// Handle authenticates requests as a middleware, redirecting anonymous users to log in instead of failing
+func (p *Provider) Handle(w http.ResponseWriter, r *vertex.Request, next vertex.HandlerFunc) (interface{}, error) {
+
+	if method, found := p.public[r.URL.Path]; found && r.Method == method {
+		return next(w, r)
+	}
+
+	if err := p.Validate(r); err != nil {
+		return nil, p.startLogin(w, r, r.RequestURI)
+	}
+
+	return next(w, r)
+}
@@ -0,0 +1,253 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/EverythingMe/vertex"
+)
+
+// mockProvider is a minimal OpenID Connect provider
+type mockProvider struct {
+	*httptest.Server
+	key   *rsa.PrivateKey
+	nonce string
+}
+
+func (m *mockProvider) sign(claims map[string]interface{}) string {
+
+	enc := func(v interface{}) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	payload := enc(map[string]string{"alg": "RS256", "kid": "k1"}) + "." + enc(claims)
+	hash := sha256.Sum256([]byte(payload))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, hash[:])
+	return payload + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.URL,
+			"authorization_endpoint": m.URL + "/auth",
+			"token_endpoint":         m.URL + "/token",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k1",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "good-code" {
+			http.Error(w, "bad code", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token": m.sign(map[string]interface{}{
+				"iss":   m.URL,
+				"sub":   "user-1",
+				"aud":   "client",
+				"exp":   time.Now().Add(time.Hour).Unix(),
+				"nonce": m.nonce,
+				"email": "alice@example.com",
+			}),
+		})
+	})
+
+	m.Server = httptest.NewServer(mux)
+	return m
+}
+
+func TestLoginFlow(t *testing.T) {
+
+	m := newMockProvider(t)
+	defer m.Close()
+
+	p, err := New(&Config{Issuer: m.URL, ClientID: "client", ClientSecret: "secret",
+		RedirectURL: "https://example.com/api/1.0/callback"}, []byte("session key"))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	routes := map[string]vertex.Route{}
+	for _, r := range p.Routes() {
+		routes[r.Path] = r
+	}
+
+	call := func(path string, query string, cookies []*http.Cookie) (*httptest.ResponseRecorder, error) {
+		hr, _ := http.NewRequest("GET", "/api/1.0"+path+"?"+query, nil)
+		for _, c := range cookies {
+			hr.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		_, err := routes[path].Handler.Handle(w, vertex.NewRequest(hr))
+		return w, err
+	}
+	cookies := func(w *httptest.ResponseRecorder) []*http.Cookie {
+		return (&http.Response{Header: w.Header()}).Cookies()
+	}
+
+	// login redirects to the provider
+	w, err := call(LoginPath, "next=/dashboard", nil)
+	assert.True(t, vertex.IsHijacked(err))
+	loc, _ := url.Parse(w.Header().Get("Location"))
+	assert.Equal(t, m.URL+"/auth", loc.Scheme+"://"+loc.Host+loc.Path)
+	assert.Equal(t, "openid", loc.Query().Get("scope"))
+	state := loc.Query().Get("state")
+	m.nonce = loc.Query().Get("nonce")
+	stateCookies := cookies(w)
+
+	// forged state
+	_, err = call(CallbackPath, "code=good-code&state=forged", stateCookies)
+	assert.Error(t, err)
+	assert.False(t, vertex.IsHijacked(err))
+
+	// failed code exchanges are not echoed to the user
+	_, err = call(CallbackPath, url.Values{"code": {"bad-code"}, "state": {state}}.Encode(), stateCookies)
+	if assert.Error(t, err) {
+		assert.NotContains(t, err.Error(), "bad code")
+	}
+
+	// the callback creates a session and redirects back
+	w, err = call(CallbackPath, url.Values{"code": {"good-code"}, "state": {state}}.Encode(), stateCookies)
+	assert.True(t, vertex.IsHijacked(err))
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/dashboard", w.Header().Get("Location"))
+
+	var session *http.Cookie
+	for _, c := range cookies(w) {
+		if c.Name == "oidc_session" {
+			session = c
+		}
+	}
+	if !assert.NotNil(t, session) {
+		t.FailNow()
+	}
+
+	// the session authenticates requests
+	hr, _ := http.NewRequest("GET", "/api/1.0/foo", nil)
+	hr.AddCookie(session)
+	r := vertex.NewRequest(hr)
+	assert.NoError(t, p.Validate(r))
+	assert.Equal(t, "user-1", r.PrincipalID())
+	assert.Equal(t, "alice@example.com", r.Principal().Attributes["email"])
+
+	// anonymous requests are redirected to log in by the middleware
+	hr, _ = http.NewRequest("GET", "/api/1.0/foo", nil)
+	r = vertex.NewRequest(hr)
+	assert.Error(t, p.Validate(r))
+	w = httptest.NewRecorder()
+	_, err = p.Handle(w, r, func(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+		t.Fatal("anonymous request reached the handler")
+		return nil, nil
+	})
+	assert.True(t, vertex.IsHijacked(err))
+	assert.Equal(t, http.StatusFound, w.Code)
+
+	// only the login flow routes themselves are let through without a session
+	through := func(method, path string) bool {
+		hr, _ := http.NewRequest(method, path, nil)
+		reached := false
+		p.Handle(httptest.NewRecorder(), vertex.NewRequest(hr), func(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+			reached = true
+			return nil, nil
+		})
+		return reached
+	}
+	assert.True(t, through("GET", "/api/1.0/login"))
+	assert.True(t, through("GET", "/api/1.0/callback"))
+	assert.True(t, through("POST", "/api/1.0/logout"))
+	assert.False(t, through("GET", "/api/1.0/logout"))
+	assert.False(t, through("GET", "/api/1.0/reports/login"))
+	assert.False(t, through("POST", "/api/1.0/users/logout"))
+
+	// logging out clears the session
+	w, _ = call(LogoutPath, "", []*http.Cookie{session})
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, -1, cookies(w)[0].MaxAge)
+}
+
+func TestRedirectURL(t *testing.T) {
+
+	m := newMockProvider(t)
+	defer m.Close()
+
+	_, err := New(&Config{Issuer: m.URL, ClientID: "client", RedirectURL: "https://example.com/api/1.0/auth"},
+		[]byte("session key"))
+	assert.Error(t, err)
+}
+
+func TestVerifyIDToken(t *testing.T) {
+
+	m := newMockProvider(t)
+	defer m.Close()
+
+	p, err := New(&Config{Issuer: m.URL, ClientID: "client", RedirectURL: "https://example.com/callback"},
+		[]byte("session key"))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	claims := func(mod func(map[string]interface{})) string {
+		c := map[string]interface{}{
+			"iss":   m.URL,
+			"sub":   "user-1",
+			"aud":   []string{"other", "client"},
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": "n",
+		}
+		if mod != nil {
+			mod(c)
+		}
+		return m.sign(c)
+	}
+
+	c, err := p.verify(claims(nil), "n")
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", c.Subject)
+
+	_, err = p.verify(claims(nil), "other nonce")
+	assert.Error(t, err)
+	_, err = p.verify(claims(func(c map[string]interface{}) { c["aud"] = "other" }), "n")
+	assert.Error(t, err)
+	_, err = p.verify(claims(func(c map[string]interface{}) { c["iss"] = "https://evil.com" }), "n")
+	assert.Error(t, err)
+	_, err = p.verify(claims(func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() }), "n")
+	assert.Error(t, err)
+
+	tok := claims(nil)
+	_, err = p.verify(tok[:len(tok)-4]+"AAAA", "n")
+	assert.Error(t, err)
+}
@@ -0,0 +1,225 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// allowed clock skew between us and the identity provider
+const clockSkew = time.Minute
+
+// Claims are the claims of an ID token we care about
+type Claims struct {
+	Issuer   string   `json:"iss"`
+	Subject  string   `json:"sub"`
+	Audience audience `json:"aud"`
+	Expiry   int64    `json:"exp"`
+	IssuedAt int64    `json:"iat"`
+	Nonce    string   `json:"nonce"`
+	Email    string   `json:"email,omitempty"`
+	Name     string   `json:"name,omitempty"`
+}
+
+// audience is a JWT audience claim, which may be either a string or a list of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+
+	var l []string
+	if err := json.Unmarshal(b, &l); err != nil {
+		return err
+	}
+	*a = audience(l)
+	return nil
+}
+
+func (a audience) contains(s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// keySet holds the provider's public signing keys, fetched from its JWKS endpoint
+type keySet struct {
+	url       string
+	mtx       sync.RWMutex
+	keys      map[string]*rsa.PublicKey
+	lastFetch time.Time
+}
+
+func newKeySet(url string) *keySet {
+	return &keySet{
+		url:  url,
+		keys: map[string]*rsa.PublicKey{},
+	}
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// refresh fetches the current keys of the provider
+func (k *keySet) refresh() error {
+
+	resp, err := http.Get(k.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Could not fetch keys from %s: %s", k.url, resp.Status)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return err
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, key := range set.Keys {
+		if key.Kty != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			return err
+		}
+
+		keys[key.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	k.mtx.Lock()
+	k.keys = keys
+	k.lastFetch = time.Now()
+	k.mtx.Unlock()
+
+	logging.Info("Fetched %d signing keys from %s", len(keys), k.url)
+	return nil
+}
+
+// get returns the key with the given id. Unknown keys trigger a refresh, since the provider may have rotated its keys
+func (k *keySet) get(kid string) (*rsa.PublicKey, error) {
+
+	k.mtx.RLock()
+	key, found := k.keys[kid]
+	stale := time.Since(k.lastFetch) > 10*time.Second
+	k.mtx.RUnlock()
+
+	if found {
+		return key, nil
+	}
+
+	if stale {
+		if err := k.refresh(); err != nil {
+			return nil, err
+		}
+
+		k.mtx.RLock()
+		key, found = k.keys[kid]
+		k.mtx.RUnlock()
+		if found {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Unknown signing key %s", kid)
+}
+
+// verify validates the signature and claims of a raw ID token
+func (p *Provider) verify(raw, nonce string) (*Claims, error) {
+
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("Malformed ID token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("Unsupported ID token algorithm %s", header.Alg)
+	}
+
+	key, err := p.keys.get(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig); err != nil {
+		return nil, errors.New("Invalid ID token signature")
+	}
+
+	claims := &Claims{}
+	if err := decodeSegment(parts[1], claims); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	switch {
+	case claims.Issuer != p.config.Issuer:
+		return nil, fmt.Errorf("Invalid ID token issuer %s", claims.Issuer)
+	case !claims.Audience.contains(p.config.ClientID):
+		return nil, errors.New("ID token was not issued for us")
+	case now.Add(-clockSkew).After(time.Unix(claims.Expiry, 0)):
+		return nil, errors.New("ID token expired")
+	case claims.Nonce != nonce:
+		return nil, errors.New("Invalid ID token nonce")
+	case claims.Subject == "":
+		return nil, errors.New("ID token has no subject")
+	}
+
+	return claims, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
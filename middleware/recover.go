@@ -3,21 +3,19 @@ package middleware
 import (
 	"net/http"
 
-	"github.com/dvirsky/go-pylog/logging"
-
 	"github.com/EverythingMe/vertex"
 )
 
-// AutoRecover is a middleware that recovers automatically from panics inside request handlers
+// AutoRecover is a middleware that recovers automatically from panics inside request handlers. The middleware chain
+// of every route recovers panics by itself; AutoRecover recovers them earlier, so the middleware before it still
+// sees the failure of the request
 var AutoRecover = vertex.MiddlewareFunc(func(w http.ResponseWriter, r *vertex.Request, next vertex.HandlerFunc) (ret interface{}, err error) {
 
 	defer func() {
 
 		e := recover()
 		if e != nil {
-			logging.Critical("Caught panic: %v", e)
-
-			err = vertex.NewErrorf("PANIC handling %s: %s", r.URL.Path, e)
+			err = vertex.PanicError(r, e)
 			return
 		}
 	}()
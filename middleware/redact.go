@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/dvirsky/go-pylog/logging"
+
+	"github.com/EverythingMe/vertex"
+)
+
+// PIIRedactor is a middleware that masks response fields tagged with `pii:"true"`, unless the request's principal
+// was granted one of the redactor's scopes. See vertex.RedactPII.
+//
+// If an audit store is set, every response returning unredacted PII is recorded in it
+type PIIRedactor struct {
+	scopes []string
+	audit  vertex.AuditStore
+}
+
+// NewPIIRedactor creates a new redactor that allows principals with any of the given scopes to see PII.
+// The audit store may be nil
+func NewPIIRedactor(audit vertex.AuditStore, scopes ...string) *PIIRedactor {
+	return &PIIRedactor{
+		scopes: scopes,
+		audit:  audit,
+	}
+}
+
+func (p *PIIRedactor) allowed(r *vertex.Request) (string, bool) {
+	principal := r.Principal()
+	for _, scope := range p.scopes {
+		if principal.HasScope(scope) {
+			return scope, true
+		}
+	}
+	return "", false
+}
+
+// Handle redacts the response returned by the rest of the chain
+func (p *PIIRedactor) Handle(w http.ResponseWriter, r *vertex.Request, next vertex.HandlerFunc) (interface{}, error) {
+
+	ret, err := next(w, r)
+	if err != nil || ret == nil {
+		return ret, err
+	}
+
+	redacted, found := vertex.RedactPII(ret)
+	if !found {
+		return ret, nil
+	}
+
+	scope, ok := p.allowed(r)
+	if !ok {
+		logging.Debug("Redacting PII from response to %s", r)
+		return redacted, nil
+	}
+
+	if p.audit != nil {
+		rec := vertex.NewAuditRecord(r, "pii_access", map[string]interface{}{"scope": scope})
+		if e := p.audit.Record(rec); e != nil {
+			logging.Error("Could not write audit record for %s: %s", r, e)
+		}
+	}
+
+	return ret, nil
+}
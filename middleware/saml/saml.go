@@ -0,0 +1,586 @@
+// Package saml implements a SAML 2.0 service provider, for authenticating users of enterprise customers against
+// their identity provider.
+//
+// A ServiceProvider serves its metadata, starts logins with the HTTP-Redirect binding, consumes signed assertions
+// posted to its assertion consumer service (ACS), and keeps the logged in user in an encrypted session cookie.
+// It authenticates requests from that session as a SecurityScheme, or as a middleware redirecting anonymous users to
+// log in:
+//
+//	sp, err := saml.New(&config, sessionKey)
+//	...
+//	api := &vertex.API{
+//		DefaultSecurityScheme: sp,
+//		Routes: append(routes, sp.Routes()...),
+//	}
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/dvirsky/go-pylog/logging"
+
+	"github.com/EverythingMe/vertex"
+)
+
+// SAML namespaces and identifiers
+const (
+	nsAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+	nsProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+
+	statusSuccess = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	bindingPOST   = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	methodBearer  = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+)
+
+// Paths of the service provider routes, relative to the API
+const (
+	MetadataPath = "/saml/metadata"
+	ACSPath      = "/saml/acs"
+	LoginPath    = "/saml/login"
+	LogoutPath   = "/saml/logout"
+)
+
+// AttrSession is the request attribute holding the Session of authenticated requests
+const AttrSession = "saml_session"
+
+// allowed clock skew between us and the identity provider
+const clockSkew = 2 * time.Minute
+
+// Config configures a SAML service provider
+type Config struct {
+	// Our entity id, usually the URL of the metadata route
+	EntityID string `yaml:"entity_id"`
+
+	// The full URL of the ACS route. The paths of the other service provider routes are derived from it
+	ACSURL string `yaml:"acs_url"`
+
+	// The identity provider's entity id, single sign-on URL (HTTP-Redirect binding) and PEM encoded signing certificate
+	IdPEntityID    string `yaml:"idp_entity_id"`
+	IdPSSOURL      string `yaml:"idp_sso_url"`
+	IdPCertificate string `yaml:"idp_certificate"`
+
+	// An assertion attribute whose values become the principal's scopes, e.g. groups or roles
+	RoleAttribute string `yaml:"role_attribute"`
+
+	// Accept unsolicited assertions, for logins started from the identity provider's portal
+	AllowIdPInitiated bool `yaml:"allow_idp_initiated"`
+
+	// How long sessions last. Defaults to 8 hours
+	SessionTTL int `yaml:"session_ttl_sec"`
+
+	// The name of a registered vertex.KeyRing encrypting the session cookies, if no session keys are given to New
+	KeyRing string `yaml:"key_ring"`
+}
+
+// Session is the logged in user, stored in the session cookie
+type Session struct {
+	NameID     string              `json:"name_id"`
+	Attributes map[string][]string `json:"attributes,omitempty"`
+	Roles      []string            `json:"roles,omitempty"`
+	Expires    time.Time           `json:"exp"`
+}
+
+// pendingLogin is kept in a cookie while the user logs in, to match the assertion to our request
+type pendingLogin struct {
+	RequestID string `json:"id"`
+	Next      string `json:"next"`
+}
+
+// ServiceProvider is a SAML 2.0 service provider
+type ServiceProvider struct {
+	config  Config
+	cert    *x509.Certificate
+	pending vertex.Cookie
+	session vertex.Cookie
+	replays *replayCache
+
+	// path => method of the login flow requests the middleware lets through without a session
+	public map[string]string
+}
+
+// New creates a service provider. The session keys encrypt the session cookies - see vertex.Cookie for key rotation
+func New(config *Config, sessionKeys ...[]byte) (*ServiceProvider, error) {
+
+	var ring *vertex.KeyRing
+	if len(sessionKeys) == 0 {
+		if ring = vertex.GetKeyRing(config.KeyRing); ring == nil {
+			return nil, logging.Errorf("No session keys given, and key ring '%s' is not registered", config.KeyRing)
+		}
+	}
+
+	acs, err := url.Parse(config.ACSURL)
+	if err != nil || !strings.HasSuffix(acs.Path, ACSPath) {
+		return nil, logging.Errorf("ACS URL '%s' is not the URL of the %s route", config.ACSURL, ACSPath)
+	}
+	base := strings.TrimSuffix(acs.Path, ACSPath)
+
+	block, _ := pem.Decode([]byte(config.IdPCertificate))
+	if block == nil {
+		return nil, logging.Errorf("Could not decode identity provider certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, logging.Errorf("Invalid identity provider certificate: %s", err)
+	}
+
+	ttl := time.Duration(config.SessionTTL) * time.Second
+	if ttl <= 0 {
+		ttl = 8 * time.Hour
+	}
+
+	return &ServiceProvider{
+		config: *config,
+		cert:   cert,
+		pending: vertex.Cookie{
+			Name:      "saml_request",
+			MaxAge:    10 * time.Minute,
+			Encrypted: true,
+			Keys:      sessionKeys,
+			KeyRing:   ring,
+			// the assertion is posted to us from the identity provider's site
+			SameSite: http.SameSiteNoneMode,
+		},
+		session: vertex.Cookie{
+			Name:      "saml_session",
+			MaxAge:    ttl,
+			Encrypted: true,
+			Keys:      sessionKeys,
+			KeyRing:   ring,
+		},
+		replays: newReplayCache(),
+		public: map[string]string{
+			base + MetadataPath: "GET",
+			base + LoginPath:    "GET",
+			base + ACSPath:      "POST",
+			base + LogoutPath:   "POST",
+		},
+	}, nil
+}
+
+func randomID() string {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	// ids must not start with a digit
+	return "_" + hex.EncodeToString(b)
+}
+
+// safeNext makes sure we only redirect to local paths after logging in
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+var metadataTemplate = template.Must(template.New("metadata").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" entityID="{{.EntityID}}">
+  <md:SPSSODescriptor AuthnRequestsSigned="false" WantAssertionsSigned="true" protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">
+    <md:NameIDFormat>urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified</md:NameIDFormat>
+    <md:AssertionConsumerService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST" Location="{{.ACSURL}}" index="0" isDefault="true"/>
+  </md:SPSSODescriptor>
+</md:EntityDescriptor>
+`))
+
+var authnRequestTemplate = template.Must(template.New("authn").Parse(`<samlp:AuthnRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="{{.ID}}" Version="2.0" IssueInstant="{{.Instant}}" Destination="{{.Destination}}" AssertionConsumerServiceURL="{{.ACSURL}}" ProtocolBinding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"><saml:Issuer>{{.EntityID}}</saml:Issuer></samlp:AuthnRequest>`))
+
+// xmlEscape escapes config values for the xml templates
+func xmlEscape(s string) string {
+	buf := &bytes.Buffer{}
+	template.HTMLEscape(buf, []byte(s))
+	return buf.String()
+}
+
+func (sp *ServiceProvider) metadata(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	err := metadataTemplate.Execute(w, map[string]string{
+		"EntityID": xmlEscape(sp.config.EntityID),
+		"ACSURL":   xmlEscape(sp.config.ACSURL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nil, vertex.Hijacked
+}
+
+// startLogin redirects the user to the identity provider with an authentication request
+func (sp *ServiceProvider) startLogin(w http.ResponseWriter, r *vertex.Request, next string) error {
+
+	id := randomID()
+
+	buf := &bytes.Buffer{}
+	err := authnRequestTemplate.Execute(buf, map[string]string{
+		"ID":          id,
+		"Instant":     time.Now().UTC().Format(time.RFC3339),
+		"Destination": xmlEscape(sp.config.IdPSSOURL),
+		"ACSURL":      xmlEscape(sp.config.ACSURL),
+		"EntityID":    xmlEscape(sp.config.EntityID),
+	})
+	if err != nil {
+		return err
+	}
+
+	// HTTP-Redirect binding: raw deflate, base64 and url encoding
+	deflated := &bytes.Buffer{}
+	fw, _ := flate.NewWriter(deflated, flate.DefaultCompression)
+	fw.Write(buf.Bytes())
+	fw.Close()
+
+	if err := sp.pending.Set(w, r, pendingLogin{RequestID: id, Next: safeNext(next)}); err != nil {
+		return err
+	}
+
+	sep := "?"
+	if strings.Contains(sp.config.IdPSSOURL, "?") {
+		sep = "&"
+	}
+	target := sp.config.IdPSSOURL + sep + url.Values{
+		"SAMLRequest": {base64.StdEncoding.EncodeToString(deflated.Bytes())},
+	}.Encode()
+
+	http.Redirect(w, r.Request, target, http.StatusFound)
+	return vertex.Hijacked
+}
+
+func (sp *ServiceProvider) login(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+	return nil, sp.startLogin(w, r, r.FormValue("next"))
+}
+
+func (sp *ServiceProvider) acs(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+
+	data, err := base64.StdEncoding.DecodeString(r.FormValue("SAMLResponse"))
+	if err != nil {
+		return nil, vertex.InvalidRequestError("Invalid SAML response encoding")
+	}
+
+	var pending pendingLogin
+	if err := sp.pending.Get(r, &pending); err != nil {
+		if !sp.config.AllowIdPInitiated {
+			return nil, vertex.UnauthorizedError("No login in progress")
+		}
+		pending = pendingLogin{Next: "/"}
+	}
+	sp.pending.Delete(w, r)
+
+	sess, err := sp.consume(data, pending.RequestID, time.Now())
+	if err != nil {
+		logging.Warning("Rejected SAML assertion: %s", err)
+		if _, ok := err.(signatureError); ok {
+			vertex.EmitSecurityEvent(vertex.NewSecurityEvent(r, vertex.EventSignatureMismatch, "Invalid SAML assertion signature"))
+		}
+		return nil, vertex.UnauthorizedError("Invalid SAML assertion")
+	}
+
+	if err := sp.session.Set(w, r, sess); err != nil {
+		return nil, err
+	}
+
+	logging.Info("User %s logged in with SAML", sess.NameID)
+	e := vertex.NewSecurityEvent(r, vertex.EventAuthSuccess, "saml")
+	e.PrincipalID = sess.NameID
+	vertex.EmitSecurityEvent(e)
+	http.Redirect(w, r.Request, pending.Next, http.StatusSeeOther)
+	return nil, vertex.Hijacked
+}
+
+func (sp *ServiceProvider) logout(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+	sp.session.Delete(w, r)
+	http.Redirect(w, r.Request, "/", http.StatusSeeOther)
+	return nil, vertex.Hijacked
+}
+
+// signatureError is returned by consume for responses failing signature verification
+type signatureError struct {
+	error
+}
+
+// parseTime parses an optional SAML timestamp attribute
+func parseTime(v string) (time.Time, bool, error) {
+	if v == "" {
+		return time.Time{}, false, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	return t, err == nil, err
+}
+
+// consume validates a SAML response and creates a session from its assertion. requestID is the id of our
+// authentication request, or empty for identity provider initiated logins
+func (sp *ServiceProvider) consume(data []byte, requestID string, now time.Time) (*Session, error) {
+
+	root, err := parseXML(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if !is(root, nsProtocol, "Response") {
+		return nil, errors.New("Not a SAML response")
+	}
+	if dest := attr(root, "Destination"); dest != "" && dest != sp.config.ACSURL {
+		return nil, fmt.Errorf("Response destined to %s", dest)
+	}
+	if attr(root, "InResponseTo") != requestID {
+		return nil, errors.New("Response is not for our request")
+	}
+
+	if status := child(root, nsProtocol, "Status"); status == nil ||
+		child(status, nsProtocol, "StatusCode") == nil ||
+		attr(child(status, nsProtocol, "StatusCode"), "Value") != statusSuccess {
+		return nil, errors.New("Login failed at the identity provider")
+	}
+
+	if child(root, nsAssertion, "EncryptedAssertion") != nil {
+		return nil, errors.New("Encrypted assertions are not supported")
+	}
+
+	assertion, err := singleAssertion(root)
+	if err != nil {
+		return nil, err
+	}
+
+	// either the assertion or the whole response must be signed. From here on we only read the verified copy
+	verified, err := verifySignature(assertion, sp.cert, now)
+	if err == errNotSigned {
+		if verified, err = verifySignature(root, sp.cert, now); err == nil {
+			verified, err = singleAssertion(verified)
+		}
+	}
+	if err != nil {
+		return nil, signatureError{err}
+	}
+	assertion = verified
+
+	if issuer := child(assertion, nsAssertion, "Issuer"); issuer == nil || textContent(issuer) != sp.config.IdPEntityID {
+		return nil, errors.New("Assertion was not issued by our identity provider")
+	}
+
+	subject := child(assertion, nsAssertion, "Subject")
+	if subject == nil || child(subject, nsAssertion, "NameID") == nil {
+		return nil, errors.New("Assertion has no subject")
+	}
+	nameID := textContent(child(subject, nsAssertion, "NameID"))
+
+	confirmed := false
+	for _, sc := range subject.ChildElements() {
+		if !is(sc, nsAssertion, "SubjectConfirmation") || attr(sc, "Method") != methodBearer {
+			continue
+		}
+		scd := child(sc, nsAssertion, "SubjectConfirmationData")
+		if scd == nil {
+			continue
+		}
+		if attr(scd, "Recipient") != sp.config.ACSURL || attr(scd, "InResponseTo") != requestID {
+			continue
+		}
+		if until, ok, _ := parseTime(attr(scd, "NotOnOrAfter")); !ok || !now.Add(-clockSkew).Before(until) {
+			continue
+		}
+		confirmed = true
+	}
+	if !confirmed {
+		return nil, errors.New("Subject confirmation failed")
+	}
+
+	expires := now.Add(sp.session.MaxAge)
+	notOnOrAfter := expires
+	if cond := child(assertion, nsAssertion, "Conditions"); cond != nil {
+
+		if from, ok, err := parseTime(attr(cond, "NotBefore")); err != nil || (ok && now.Add(clockSkew).Before(from)) {
+			return nil, errors.New("Assertion is not valid yet")
+		}
+		if until, ok, err := parseTime(attr(cond, "NotOnOrAfter")); err != nil || (ok && !now.Add(-clockSkew).Before(until)) {
+			return nil, errors.New("Assertion expired")
+		} else if ok {
+			notOnOrAfter = until
+		}
+
+		for _, ar := range cond.ChildElements() {
+			if !is(ar, nsAssertion, "AudienceRestriction") {
+				continue
+			}
+			found := false
+			for _, aud := range ar.ChildElements() {
+				if is(aud, nsAssertion, "Audience") && textContent(aud) == sp.config.EntityID {
+					found = true
+				}
+			}
+			if !found {
+				return nil, errors.New("Assertion is not intended for us")
+			}
+		}
+	}
+
+	if !sp.replays.add(attr(assertion, "ID"), notOnOrAfter.Add(clockSkew), now) {
+		return nil, errors.New("Assertion was already used")
+	}
+
+	sess := &Session{
+		NameID:     nameID,
+		Attributes: map[string][]string{},
+		Expires:    expires,
+	}
+	if stmt := child(assertion, nsAssertion, "AttributeStatement"); stmt != nil {
+		for _, a := range stmt.ChildElements() {
+			if !is(a, nsAssertion, "Attribute") {
+				continue
+			}
+			name := attr(a, "Name")
+			for _, v := range a.ChildElements() {
+				if is(v, nsAssertion, "AttributeValue") {
+					sess.Attributes[name] = append(sess.Attributes[name], textContent(v))
+				}
+			}
+		}
+	}
+	if sp.config.RoleAttribute != "" {
+		sess.Roles = sess.Attributes[sp.config.RoleAttribute]
+	}
+
+	return sess, nil
+}
+
+// singleAssertion returns the only assertion of a response
+func singleAssertion(response *etree.Element) (*etree.Element, error) {
+
+	var assertion *etree.Element
+	for _, c := range response.ChildElements() {
+		if is(c, nsAssertion, "Assertion") {
+			if assertion != nil {
+				return nil, errors.New("Multiple assertions in response")
+			}
+			assertion = c
+		}
+	}
+	if assertion == nil {
+		return nil, errors.New("No assertion in response")
+	}
+	return assertion, nil
+}
+
+// Routes returns the service provider routes, to be added to the API
+func (sp *ServiceProvider) Routes() vertex.Routes {
+	return vertex.Routes{
+		{
+			Path:        MetadataPath,
+			Description: "SAML service provider metadata",
+			Handler:     vertex.HandlerFunc(sp.metadata),
+			Methods:     vertex.GET,
+			Security:    vertex.NopSecurity,
+		},
+		{
+			Path:        LoginPath,
+			Description: "SAML login",
+			Handler:     vertex.HandlerFunc(sp.login),
+			Methods:     vertex.GET,
+			Security:    vertex.NopSecurity,
+		},
+		{
+			Path:        ACSPath,
+			Description: "SAML assertion consumer service",
+			Handler:     vertex.HandlerFunc(sp.acs),
+			Methods:     vertex.POST,
+			Security:    vertex.NopSecurity,
+		},
+		{
+			Path:        LogoutPath,
+			Description: "Log out",
+			Handler:     vertex.HandlerFunc(sp.logout),
+			Methods:     vertex.POST,
+			Security:    vertex.NopSecurity,
+		},
+	}
+}
+
+// Session returns the logged in session of a request
+func (sp *ServiceProvider) Session(r *vertex.Request) (*Session, error) {
+
+	sess := &Session{}
+	if err := sp.session.Get(r, sess); err != nil {
+		return nil, err
+	}
+	if time.Now().After(sess.Expires) {
+		return nil, fmt.Errorf("Session of %s expired", sess.NameID)
+	}
+	return sess, nil
+}
+
+// Validate authenticates a request from its session, making the service provider a SecurityScheme
+func (sp *ServiceProvider) Validate(r *vertex.Request) error {
+
+	sess, err := sp.Session(r)
+	if err != nil {
+		return vertex.MissingCredentialsError("Not logged in")
+	}
+
+	attrs := make(map[string]interface{}, len(sess.Attributes))
+	for k, v := range sess.Attributes {
+		attrs[k] = v
+	}
+
+	r.SetAttribute(AttrSession, sess)
+	r.SetPrincipal(&vertex.Principal{
+		ID:         sess.NameID,
+		Scopes:     sess.Roles,
+		Attributes: attrs,
+	})
+	return nil
+}
+
+// Handle authenticates requests as a middleware, redirecting anonymous users to log in instead of failing
+func (sp *ServiceProvider) Handle(w http.ResponseWriter, r *vertex.Request, next vertex.HandlerFunc) (interface{}, error) {
+
+	if method, found := sp.public[r.URL.Path]; found && r.Method == method {
+		return next(w, r)
+	}
+
+	if err := sp.Validate(r); err != nil {
+		return nil, sp.startLogin(w, r, r.RequestURI)
+	}
+
+	return next(w, r)
+}
+
+// replayCache remembers consumed assertion ids until they expire, so assertions cannot be replayed
+type replayCache struct {
+	mtx sync.Mutex
+	ids map[string]time.Time
+}
+
+func newReplayCache() *replayCache {
+	return &replayCache{ids: map[string]time.Time{}}
+}
+
+// add records an assertion id, returning false if it was already used
+func (c *replayCache) add(id string, expires, now time.Time) bool {
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for k, exp := range c.ids {
+		if now.After(exp) {
+			delete(c.ids, k)
+		}
+	}
+
+	if _, found := c.ids[id]; found {
+		return false
+	}
+	c.ids[id] = expires
+	return true
+}
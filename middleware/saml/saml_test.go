@@ -0,0 +1,405 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/russellhaering/goxmldsig/etreeutils"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/EverythingMe/vertex"
+)
+
+const (
+	testEntityID = "https://sp.example.com/saml/metadata"
+	testACSURL   = "https://sp.example.com/api/1.0/saml/acs"
+	testIdP      = "https://idp.example.com"
+)
+
+// mockIdP signs SAML responses
+type mockIdP struct {
+	key  *rsa.PrivateKey
+	cert string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &mockIdP{
+		key:  key,
+		cert: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+	}
+}
+
+type assertionParams struct {
+	ID           string
+	InResponseTo string
+	Issuer       string
+	Audience     string
+	NameID       string
+	NotOnOrAfter time.Time
+}
+
+func (m *mockIdP) params(requestID string) assertionParams {
+	return assertionParams{
+		ID:           randomID(),
+		InResponseTo: requestID,
+		Issuer:       testIdP,
+		Audience:     testEntityID,
+		NameID:       "alice@example.com",
+		NotOnOrAfter: time.Now().Add(5 * time.Minute),
+	}
+}
+
+// GetKeyPair makes the mock a goxmldsig key store
+func (m *mockIdP) GetKeyPair() (*rsa.PrivateKey, []byte, error) {
+	block, _ := pem.Decode([]byte(m.cert))
+	return m.key, block.Bytes, nil
+}
+
+// sign adds an enveloped signature to an element of a document, after its issuer like identity providers do
+func (m *mockIdP) sign(t *testing.T, el *etree.Element) {
+
+	ctx := dsig.NewDefaultSigningContext(m)
+	ctx.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+
+	nsCtx, err := etreeutils.NSBuildParentContext(el)
+	if err != nil {
+		t.Fatal(err)
+	}
+	detached, err := etreeutils.NSDetatch(nsCtx, el)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := ctx.ConstructSignature(detached, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	el.InsertChildAt(1, sig)
+}
+
+// response creates a SAML response with a signed assertion. The assertion namespace is declared on the response, as
+// many identity providers do, so the signature covers namespace declarations of an enclosing element
+func (m *mockIdP) response(t *testing.T, p assertionParams) string {
+
+	until := p.NotOnOrAfter.UTC().Format(time.RFC3339)
+	response := fmt.Sprintf(`<samlp:Response xmlns:samlp="%s" xmlns:saml="%s" ID="%s" Version="2.0" InResponseTo="%s" Destination="%s">`+
+		`<samlp:Status><samlp:StatusCode Value="%s"/></samlp:Status>`+
+		`<saml:Assertion ID="%s" Version="2.0">`+
+		`<saml:Issuer>%s</saml:Issuer>`+
+		`<saml:Subject><saml:NameID>%s</saml:NameID>`+
+		`<saml:SubjectConfirmation Method="%s"><saml:SubjectConfirmationData InResponseTo="%s" Recipient="%s" NotOnOrAfter="%s"/></saml:SubjectConfirmation>`+
+		`</saml:Subject>`+
+		`<saml:Conditions NotOnOrAfter="%s"><saml:AudienceRestriction><saml:Audience>%s</saml:Audience></saml:AudienceRestriction></saml:Conditions>`+
+		`<saml:AttributeStatement>`+
+		`<saml:Attribute Name="groups"><saml:AttributeValue>admin</saml:AttributeValue><saml:AttributeValue>dev</saml:AttributeValue></saml:Attribute>`+
+		`</saml:AttributeStatement>`+
+		`</saml:Assertion></samlp:Response>`,
+		nsProtocol, nsAssertion, randomID(), p.InResponseTo, testACSURL, statusSuccess,
+		p.ID, p.Issuer, p.NameID, methodBearer, p.InResponseTo, testACSURL, until, until, p.Audience)
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(response); err != nil {
+		t.Fatal(err)
+	}
+	m.sign(t, doc.Root().SelectElement("saml:Assertion"))
+
+	signed, err := doc.WriteToString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func newTestSP(t *testing.T, m *mockIdP) *ServiceProvider {
+	sp, err := New(&Config{
+		EntityID:       testEntityID,
+		ACSURL:         testACSURL,
+		IdPEntityID:    testIdP,
+		IdPSSOURL:      testIdP + "/sso",
+		IdPCertificate: m.cert,
+		RoleAttribute:  "groups",
+	}, []byte("session key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sp
+}
+
+func TestLoginFlow(t *testing.T) {
+
+	m := newMockIdP(t)
+	sp := newTestSP(t, m)
+
+	routes := map[string]vertex.Route{}
+	for _, r := range sp.Routes() {
+		routes[r.Path] = r
+	}
+	cookies := func(w *httptest.ResponseRecorder) []*http.Cookie {
+		return (&http.Response{Header: w.Header()}).Cookies()
+	}
+
+	// metadata
+	hr, _ := http.NewRequest("GET", "/api/1.0"+MetadataPath, nil)
+	w := httptest.NewRecorder()
+	_, err := routes[MetadataPath].Handler.Handle(w, vertex.NewRequest(hr))
+	assert.True(t, vertex.IsHijacked(err))
+	assert.Contains(t, w.Body.String(), `entityID="`+testEntityID+`"`)
+	assert.Contains(t, w.Body.String(), `Location="`+testACSURL+`"`)
+
+	// login redirects to the identity provider with a deflated authentication request
+	hr, _ = http.NewRequest("GET", "/api/1.0"+LoginPath+"?next=/dashboard", nil)
+	w = httptest.NewRecorder()
+	_, err = routes[LoginPath].Handler.Handle(w, vertex.NewRequest(hr))
+	assert.True(t, vertex.IsHijacked(err))
+	assert.Equal(t, http.StatusFound, w.Code)
+
+	loc, _ := url.Parse(w.Header().Get("Location"))
+	assert.Equal(t, testIdP+"/sso", loc.Scheme+"://"+loc.Host+loc.Path)
+	deflated, err := base64.StdEncoding.DecodeString(loc.Query().Get("SAMLRequest"))
+	assert.NoError(t, err)
+	raw, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	assert.NoError(t, err)
+	authn, err := parseXML(raw)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.True(t, is(authn, nsProtocol, "AuthnRequest"))
+	requestID := attr(authn, "ID")
+	pending := cookies(w)
+
+	post := func(response string, cookies []*http.Cookie) (*httptest.ResponseRecorder, error) {
+		form := url.Values{"SAMLResponse": {base64.StdEncoding.EncodeToString([]byte(response))}}
+		hr, _ := http.NewRequest("POST", "/api/1.0"+ACSPath, strings.NewReader(form.Encode()))
+		hr.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, c := range cookies {
+			hr.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		_, err := routes[ACSPath].Handler.Handle(w, vertex.NewRequest(hr))
+		return w, err
+	}
+
+	// unsolicited responses are rejected
+	_, err = post(m.response(t, m.params("")), nil)
+	assert.Error(t, err)
+	assert.False(t, vertex.IsHijacked(err))
+
+	// the assertion creates a session and redirects back
+	w, err = post(m.response(t, m.params(requestID)), pending)
+	assert.True(t, vertex.IsHijacked(err))
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/dashboard", w.Header().Get("Location"))
+
+	var session *http.Cookie
+	for _, c := range cookies(w) {
+		if c.Name == "saml_session" {
+			session = c
+		}
+	}
+	if !assert.NotNil(t, session) {
+		t.FailNow()
+	}
+
+	// the session authenticates requests
+	hr, _ = http.NewRequest("GET", "/api/1.0/foo", nil)
+	hr.AddCookie(session)
+	r := vertex.NewRequest(hr)
+	assert.NoError(t, sp.Validate(r))
+	assert.Equal(t, "alice@example.com", r.PrincipalID())
+	assert.True(t, r.Principal().HasScope("admin"))
+	assert.Equal(t, []string{"admin", "dev"}, r.Principal().Attributes["groups"])
+
+	// anonymous requests are redirected to log in by the middleware
+	hr, _ = http.NewRequest("GET", "/api/1.0/foo", nil)
+	r = vertex.NewRequest(hr)
+	w = httptest.NewRecorder()
+	_, err = sp.Handle(w, r, func(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+		t.Fatal("anonymous request reached the handler")
+		return nil, nil
+	})
+	assert.True(t, vertex.IsHijacked(err))
+	assert.Equal(t, http.StatusFound, w.Code)
+
+	// only the service provider routes themselves are let through without a session
+	through := func(method, path string) bool {
+		hr, _ := http.NewRequest(method, path, nil)
+		reached := false
+		sp.Handle(httptest.NewRecorder(), vertex.NewRequest(hr), func(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+			reached = true
+			return nil, nil
+		})
+		return reached
+	}
+	assert.True(t, through("GET", "/api/1.0"+MetadataPath))
+	assert.True(t, through("GET", "/api/1.0"+LoginPath))
+	assert.True(t, through("POST", "/api/1.0"+ACSPath))
+	assert.True(t, through("POST", "/api/1.0"+LogoutPath))
+	assert.False(t, through("GET", "/api/1.0"+LogoutPath))
+	assert.False(t, through("GET", "/api/1.0"+ACSPath))
+	assert.False(t, through("GET", "/static/x"+LoginPath))
+	assert.False(t, through("GET", "/api/1.0/files"+MetadataPath))
+	assert.False(t, through("POST", "/api/1.0/reports"+ACSPath))
+	assert.False(t, through("POST", "/api/1.0/users"+LogoutPath))
+}
+
+func TestACSURL(t *testing.T) {
+
+	m := newMockIdP(t)
+	_, err := New(&Config{
+		EntityID:       testEntityID,
+		ACSURL:         "https://sp.example.com/api/1.0/saml/consume",
+		IdPEntityID:    testIdP,
+		IdPSSOURL:      testIdP + "/sso",
+		IdPCertificate: m.cert,
+	}, []byte("session key"))
+	assert.Error(t, err)
+}
+
+func TestConsume(t *testing.T) {
+
+	m := newMockIdP(t)
+	sp := newTestSP(t, m)
+
+	p := m.params("req1")
+	resp := m.response(t, p)
+
+	sess, err := sp.consume([]byte(resp), "req1", time.Now())
+	if assert.NoError(t, err) {
+		assert.Equal(t, "alice@example.com", sess.NameID)
+		assert.Equal(t, []string{"admin", "dev"}, sess.Roles)
+	}
+
+	// replayed assertion
+	_, err = sp.consume([]byte(resp), "req1", time.Now())
+	assert.Error(t, err)
+
+	// response to another request
+	_, err = sp.consume([]byte(m.response(t, m.params("req1"))), "req2", time.Now())
+	assert.Error(t, err)
+
+	// tampered assertion
+	tampered := strings.Replace(m.response(t, m.params("req1")), "alice@example.com", "mallory@example.com", 1)
+	_, err = sp.consume([]byte(tampered), "req1", time.Now())
+	assert.Error(t, err)
+
+	// wrong audience and issuer
+	p = m.params("req1")
+	p.Audience = "https://other.example.com"
+	_, err = sp.consume([]byte(m.response(t, p)), "req1", time.Now())
+	assert.Error(t, err)
+
+	p = m.params("req1")
+	p.Issuer = "https://evil.example.com"
+	_, err = sp.consume([]byte(m.response(t, p)), "req1", time.Now())
+	assert.Error(t, err)
+
+	// expired assertion
+	p = m.params("req1")
+	p.NotOnOrAfter = time.Now().Add(-time.Hour)
+	_, err = sp.consume([]byte(m.response(t, p)), "req1", time.Now())
+	assert.Error(t, err)
+
+	// signed by another identity provider
+	_, err = sp.consume([]byte(newMockIdP(t).response(t, m.params("req1"))), "req1", time.Now())
+	assert.Error(t, err)
+
+	// signature wrapping: a forged assertion next to the signed one
+	p = m.params("req1")
+	wrapped := strings.Replace(m.response(t, p), "<saml:Assertion",
+		`<saml:Assertion xmlns:saml="`+nsAssertion+`" ID="forged"><saml:Issuer>`+testIdP+`</saml:Issuer></saml:Assertion><saml:Assertion`, 1)
+	_, err = sp.consume([]byte(wrapped), "req1", time.Now())
+	assert.Error(t, err)
+
+	// the response is signed instead of the assertion
+	doc := etree.NewDocument()
+	assert.NoError(t, doc.ReadFromString(m.response(t, m.params("req1"))))
+	assertion := doc.Root().SelectElement("saml:Assertion")
+	assertion.RemoveChild(assertion.SelectElement("ds:Signature"))
+	m.sign(t, doc.Root())
+	resp, _ = doc.WriteToString()
+	_, err = sp.consume([]byte(resp), "req1", time.Now())
+	assert.NoError(t, err)
+
+	// no signature at all
+	doc = etree.NewDocument()
+	assert.NoError(t, doc.ReadFromString(m.response(t, m.params("req1"))))
+	assertion = doc.Root().SelectElement("saml:Assertion")
+	assertion.RemoveChild(assertion.SelectElement("ds:Signature"))
+	resp, _ = doc.WriteToString()
+	_, err = sp.consume([]byte(resp), "req1", time.Now())
+	assert.Error(t, err)
+}
+
+func TestConsumeNamespaces(t *testing.T) {
+
+	m := newMockIdP(t)
+	sp := newTestSP(t, m)
+
+	// redeclaring the assertion namespace on the assertion itself does not change what was signed
+	resp := strings.Replace(m.response(t, m.params("req1")), `<saml:Assertion ID=`,
+		`<saml:Assertion xmlns:saml="`+nsAssertion+`" ID=`, 1)
+	sess, err := sp.consume([]byte(resp), "req1", time.Now())
+	if assert.NoError(t, err) {
+		assert.Equal(t, "alice@example.com", sess.NameID)
+	}
+
+	// redeclaring the prefix to another namespace outside the signed assertion breaks the signature
+	resp = strings.Replace(m.response(t, m.params("req1")), `xmlns:saml="`+nsAssertion+`"`,
+		`xmlns:saml="urn:evil"`, 1)
+	_, err = sp.consume([]byte(resp), "req1", time.Now())
+	assert.Error(t, err)
+
+	// moving the signed content to the default namespace breaks the signature
+	resp = strings.NewReplacer(`<saml:`, `<`, `</saml:`, `</`).Replace(m.response(t, m.params("req1")))
+	resp = strings.Replace(resp, `<Assertion ID=`, `<Assertion xmlns="`+nsAssertion+`" ID=`, 1)
+	_, err = sp.consume([]byte(resp), "req1", time.Now())
+	assert.Error(t, err)
+}
+
+func TestConsumeCommentInjection(t *testing.T) {
+
+	m := newMockIdP(t)
+	sp := newTestSP(t, m)
+
+	// comments are not signed, so one inserted into a signed value must not truncate it
+	p := m.params("req1")
+	p.NameID = "alice@example.com.evil.com"
+	resp := strings.Replace(m.response(t, p), "alice@example.com.evil.com", "alice@example.com<!---->.evil.com", 1)
+
+	sess, err := sp.consume([]byte(resp), "req1", time.Now())
+	if assert.NoError(t, err) {
+		assert.Equal(t, "alice@example.com.evil.com", sess.NameID)
+	}
+}
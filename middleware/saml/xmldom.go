@@ -0,0 +1,74 @@
+package saml
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	"github.com/beevik/etree"
+	xrv "github.com/mattermost/xml-roundtrip-validator"
+)
+
+// parseXML parses a document into an element tree. DTDs are rejected, since they are not used by SAML and are a
+// common attack vector, and so are documents that do not survive an encoding/xml round trip unchanged, since the
+// signature would then cover something else than what we read
+func parseXML(data []byte) (*etree.Element, error) {
+
+	if err := xrv.Validate(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(data); err != nil {
+		return nil, err
+	}
+
+	for _, t := range doc.Child {
+		if _, ok := t.(*etree.Directive); ok {
+			return nil, errors.New("DTDs are not allowed")
+		}
+	}
+
+	root := doc.Root()
+	if root == nil {
+		return nil, errors.New("Incomplete document")
+	}
+	return root, nil
+}
+
+// is checks the namespace and local name of an element
+func is(el *etree.Element, namespace, local string) bool {
+	return el.Tag == local && el.NamespaceURI() == namespace
+}
+
+// attr returns the value of an unprefixed attribute
+func attr(el *etree.Element, local string) string {
+	for _, a := range el.Attr {
+		if a.Space == "" && a.Key == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// child returns the first child element with the given namespace and name
+func child(el *etree.Element, namespace, local string) *etree.Element {
+	for _, c := range el.ChildElements() {
+		if is(c, namespace, local) {
+			return c
+		}
+	}
+	return nil
+}
+
+// textContent returns the concatenated text of the element's text children. Unlike etree's Text, it does not stop at
+// the first comment, which canonicalization drops, so a comment cannot truncate a signed value
+func textContent(el *etree.Element) string {
+	var buf strings.Builder
+	for _, t := range el.Child {
+		if cd, ok := t.(*etree.CharData); ok {
+			buf.WriteString(cd.Data)
+		}
+	}
+	return strings.TrimSpace(buf.String())
+}
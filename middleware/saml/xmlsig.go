@@ -0,0 +1,47 @@
+package saml
+
+import (
+	"crypto/x509"
+	"errors"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/russellhaering/goxmldsig/etreeutils"
+)
+
+// XML signature namespace
+const nsDSig = "http://www.w3.org/2000/09/xmldsig#"
+
+var errNotSigned = errors.New("Element is not signed")
+
+// verifySignature verifies the enveloped XML signature of an element with the given certificate, using goxmldsig.
+// The signature must be a child of the element and reference the element itself by its ID.
+//
+// It returns a verified copy of the element without its signature. Only that copy may be read from, since its
+// content is exactly what the signature covers - the element in the original document may be surrounded or wrapped
+// by unsigned content
+func verifySignature(el *etree.Element, cert *x509.Certificate, now time.Time) (*etree.Element, error) {
+
+	if child(el, nsDSig, "Signature") == nil {
+		return nil, errNotSigned
+	}
+
+	ctx := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	})
+	ctx.IdAttribute = "ID"
+	ctx.Clock = dsig.NewFakeClockAt(now)
+
+	// carry the namespace declarations in scope over to the detached element, so canonicalization sees them
+	nsCtx, err := etreeutils.NSBuildParentContext(el)
+	if err != nil {
+		return nil, err
+	}
+	detached, err := etreeutils.NSDetatch(nsCtx, el)
+	if err != nil {
+		return nil, err
+	}
+
+	return ctx.Validate(detached)
+}
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/EverythingMe/vertex"
+)
+
+// Timeout is a middleware bounding the rest of the chain by a deadline. The deadline is set on the request's context,
+// so downstream calls made with r.Context() are canceled once it passes, and requests failing after it are answered
+// with 504 Gateway Timeout.
+//
+// Handlers run to completion, so they must pass the request's context to their downstream calls to be cut short.
+// A handler that succeeds despite the deadline has its response rendered.
+//
+// If applied to both the API and a route, the earliest deadline wins
+type Timeout struct {
+	timeout time.Duration
+}
+
+// NewTimeout creates a new timeout middleware with the given timeout
+func NewTimeout(timeout time.Duration) *Timeout {
+	return &Timeout{
+		timeout: timeout,
+	}
+}
+
+func (t *Timeout) Handle(w http.ResponseWriter, r *vertex.Request, next vertex.HandlerFunc) (interface{}, error) {
+
+	cancel := r.SetDeadline(time.Now().Add(t.timeout))
+	defer cancel()
+
+	ret, err := next(w, r)
+	if err != nil && !vertex.IsHijacked(err) && r.Context().Err() == context.DeadlineExceeded {
+		return nil, vertex.TimeoutError("Request timed out after %v: %s", t.timeout, err)
+	}
+	return ret, err
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/dvirsky/go-pylog/logging"
+
+	"github.com/EverythingMe/vertex"
+)
+
+// transactionKey is the context key of the transaction opened by the Transaction middleware
+type transactionKey struct{}
+
+// Tx is a transaction that can be committed or rolled back, e.g. a *sql.Tx
+type Tx interface {
+	Commit() error
+	Rollback() error
+}
+
+// BeginFunc opens a new transaction for a request
+type BeginFunc func(r *vertex.Request) (Tx, error)
+
+// Transaction is a middleware that wraps the rest of the chain in a transaction. The transaction is opened before
+// the handler, committed if the handler succeeds, and rolled back if it fails or panics.
+//
+// Handlers get the transaction with TransactionFrom
+type Transaction struct {
+	begin BeginFunc
+}
+
+// NewTransaction creates a new transaction middleware opening transactions with the given function
+func NewTransaction(begin BeginFunc) *Transaction {
+	return &Transaction{
+		begin: begin,
+	}
+}
+
+// TransactionFrom returns the transaction opened for a request, or nil if there is none
+func TransactionFrom(r *vertex.Request) Tx {
+	if tx, ok := r.Value(transactionKey{}).(Tx); ok {
+		return tx
+	}
+	return nil
+}
+
+func rollback(r *vertex.Request, tx Tx) {
+	if err := tx.Rollback(); err != nil {
+		logging.Error("Could not roll back transaction of %s: %s", r, err)
+	}
+}
+
+func (t *Transaction) Handle(w http.ResponseWriter, r *vertex.Request, next vertex.HandlerFunc) (interface{}, error) {
+
+	tx, err := t.begin(r)
+	if err != nil {
+		return nil, vertex.NewErrorf("Could not begin transaction: %s", err)
+	}
+	r.WithValue(transactionKey{}, tx)
+
+	// roll back on panics, and let the recovery middleware handle the panic itself
+	defer func() {
+		if e := recover(); e != nil {
+			logging.Warning("Rolling back transaction of %s after panic", r)
+			rollback(r, tx)
+			panic(e)
+		}
+	}()
+
+	ret, err := next(w, r)
+	if err != nil && !vertex.IsHijacked(err) {
+		logging.Debug("Rolling back transaction of %s: %s", r, err)
+		rollback(r, tx)
+		return ret, err
+	}
+
+	if e := tx.Commit(); e != nil {
+		return nil, vertex.NewErrorf("Could not commit transaction: %s", e)
+	}
+
+	return ret, err
+}
@@ -0,0 +1,231 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+
+	"github.com/EverythingMe/vertex"
+)
+
+// DefaultWebhookTolerance is how old a timestamped webhook may be before it is rejected as a replay
+const DefaultWebhookTolerance = 5 * time.Minute
+
+// SignatureFormat is the way a webhook provider signs its requests
+type SignatureFormat interface {
+	// Name of the provider, for logs and security events
+	Name() string
+
+	// Parse extracts the signed payload, the candidate signatures and the signing time (zero if the provider does not
+	// timestamp requests) from a request and its raw body. It returns a MissingCredentialsError if the request is
+	// not signed
+	Parse(r *vertex.Request, body []byte) (payload []byte, signatures [][]byte, timestamp time.Time, err error)
+
+	// Hash is the hash function of the HMAC
+	Hash() func() hash.Hash
+}
+
+// WebhookSignature is a security scheme verifying the HMAC signatures webhook providers sign their requests with.
+// Requests signed with any of the keys of the ring are accepted, so secrets can be rotated without downtime
+type WebhookSignature struct {
+	Format SignatureFormat
+	Keys   *vertex.KeyRing
+
+	// How old a timestamped request may be. Defaults to DefaultWebhookTolerance
+	Tolerance time.Duration
+}
+
+// NewWebhookSignature creates a webhook signature security scheme for a signature format, verifying with the keys of
+// a key ring
+func NewWebhookSignature(format SignatureFormat, keys *vertex.KeyRing) *WebhookSignature {
+	return &WebhookSignature{
+		Format:    format,
+		Keys:      keys,
+		Tolerance: DefaultWebhookTolerance,
+	}
+}
+
+// NewGitHubSignature verifies GitHub's X-Hub-Signature-256 header
+func NewGitHubSignature(keys *vertex.KeyRing) *WebhookSignature {
+	return NewWebhookSignature(GitHubFormat{}, keys)
+}
+
+// NewStripeSignature verifies Stripe's timestamped Stripe-Signature header
+func NewStripeSignature(keys *vertex.KeyRing) *WebhookSignature {
+	return NewWebhookSignature(StripeFormat{}, keys)
+}
+
+// NewSlackSignature verifies Slack's X-Slack-Signature and X-Slack-Request-Timestamp headers
+func NewSlackSignature(keys *vertex.KeyRing) *WebhookSignature {
+	return NewWebhookSignature(SlackFormat{}, keys)
+}
+
+// Validate verifies the signature of a request, setting a principal named after the provider
+func (s *WebhookSignature) Validate(r *vertex.Request) error {
+
+	body, err := r.RawBody()
+	if err != nil {
+		return vertex.InvalidRequestError("Could not read request body: %s", err)
+	}
+
+	payload, signatures, ts, err := s.Format.Parse(r, body)
+	if err != nil {
+		return err
+	}
+
+	if !ts.IsZero() {
+		tolerance := s.Tolerance
+		if tolerance <= 0 {
+			tolerance = DefaultWebhookTolerance
+		}
+		if d := time.Since(ts); d > tolerance || d < -tolerance {
+			logging.Warning("Rejected %s webhook signed at %s", s.Format.Name(), ts)
+			return vertex.InvalidCredentialsError("Webhook timestamp out of tolerance")
+		}
+	}
+
+	for _, secret := range s.Keys.Secrets() {
+		mac := hmac.New(s.Format.Hash(), secret)
+		mac.Write(payload)
+		expected := mac.Sum(nil)
+		for _, sig := range signatures {
+			if hmac.Equal(sig, expected) {
+				r.SetPrincipal(&vertex.Principal{ID: "webhook:" + s.Format.Name()})
+				return nil
+			}
+		}
+	}
+
+	e := vertex.NewSecurityEvent(r, vertex.EventSignatureMismatch, "Invalid webhook signature")
+	e.Details = map[string]interface{}{"provider": s.Format.Name()}
+	vertex.EmitSecurityEvent(e)
+	return vertex.InvalidCredentialsError("Invalid webhook signature")
+}
+
+// decodeHex decodes a hex signature with an optional prefix, e.g. sha256=abcd
+func decodeHex(value, prefix string) ([]byte, error) {
+	if !strings.HasPrefix(value, prefix) {
+		return nil, vertex.InvalidCredentialsError("Invalid signature format")
+	}
+	sig, err := hex.DecodeString(value[len(prefix):])
+	if err != nil {
+		return nil, vertex.InvalidCredentialsError("Invalid signature encoding")
+	}
+	return sig, nil
+}
+
+// parseUnix parses a unix timestamp header
+func parseUnix(value string) (time.Time, error) {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, vertex.InvalidCredentialsError("Invalid webhook timestamp")
+	}
+	return time.Unix(n, 0), nil
+}
+
+// GitHubFormat signs the body with HMAC-SHA256 in the X-Hub-Signature-256 header, formatted as sha256=<signature>
+type GitHubFormat struct{}
+
+func (GitHubFormat) Name() string {
+	return "github"
+}
+
+func (GitHubFormat) Parse(r *vertex.Request, body []byte) ([]byte, [][]byte, time.Time, error) {
+
+	header := r.Header.Get("X-Hub-Signature-256")
+	if header == "" {
+		return nil, nil, time.Time{}, vertex.MissingCredentialsError("Missing X-Hub-Signature-256 header")
+	}
+
+	sig, err := decodeHex(header, "sha256=")
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	return body, [][]byte{sig}, time.Time{}, nil
+}
+
+func (GitHubFormat) Hash() func() hash.Hash {
+	return sha256.New
+}
+
+// StripeFormat signs "<timestamp>.<body>" with HMAC-SHA256 in the Stripe-Signature header, formatted as
+// t=<timestamp>,v1=<signature>. There may be several v1 signatures while a secret is rolled
+type StripeFormat struct{}
+
+func (StripeFormat) Name() string {
+	return "stripe"
+}
+
+func (StripeFormat) Parse(r *vertex.Request, body []byte) ([]byte, [][]byte, time.Time, error) {
+
+	header := r.Header.Get("Stripe-Signature")
+	if header == "" {
+		return nil, nil, time.Time{}, vertex.MissingCredentialsError("Missing Stripe-Signature header")
+	}
+
+	var ts string
+	var sigs [][]byte
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			ts = kv[1]
+		case "v1":
+			if sig, err := hex.DecodeString(kv[1]); err == nil {
+				sigs = append(sigs, sig)
+			}
+		}
+	}
+	if ts == "" || len(sigs) == 0 {
+		return nil, nil, time.Time{}, vertex.InvalidCredentialsError("Invalid Stripe-Signature header")
+	}
+
+	t, err := parseUnix(ts)
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	return append([]byte(ts+"."), body...), sigs, t, nil
+}
+
+func (StripeFormat) Hash() func() hash.Hash {
+	return sha256.New
+}
+
+// SlackFormat signs "v0:<timestamp>:<body>" with HMAC-SHA256 in the X-Slack-Signature header, formatted as
+// v0=<signature>, with the timestamp in the X-Slack-Request-Timestamp header
+type SlackFormat struct{}
+
+func (SlackFormat) Name() string {
+	return "slack"
+}
+
+func (SlackFormat) Parse(r *vertex.Request, body []byte) ([]byte, [][]byte, time.Time, error) {
+
+	header, ts := r.Header.Get("X-Slack-Signature"), r.Header.Get("X-Slack-Request-Timestamp")
+	if header == "" || ts == "" {
+		return nil, nil, time.Time{}, vertex.MissingCredentialsError("Missing X-Slack-Signature header")
+	}
+
+	sig, err := decodeHex(header, "v0=")
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	t, err := parseUnix(ts)
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	return append([]byte("v0:"+ts+":"), body...), [][]byte{sig}, t, nil
+}
+
+func (SlackFormat) Hash() func() hash.Hash {
+	return sha256.New
+}
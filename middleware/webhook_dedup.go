@@ -0,0 +1,182 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+
+	"github.com/EverythingMe/vertex"
+)
+
+// DefaultDedupWindow is how long webhook event ids are remembered. Providers retry failed deliveries for up to a
+// few days, but redeliveries of successful ones usually come within hours
+const DefaultDedupWindow = 24 * time.Hour
+
+// DefaultDedupProcessingTTL is how long an event is claimed while its first delivery is processed. The claim is
+// renewed while the handler runs, so it only bounds how long retries wait after an instance dies mid-processing
+const DefaultDedupProcessingTTL = time.Minute
+
+const (
+	dedupProcessing = "processing"
+	dedupDone       = "done"
+)
+
+// EventIDFunc extracts the provider's unique event id from a webhook request. It returns an empty id if the request
+// does not have one
+type EventIDFunc func(r *vertex.Request) (string, error)
+
+// HeaderEventID extracts the event id from a header, e.g. X-GitHub-Delivery
+func HeaderEventID(header string) EventIDFunc {
+	return func(r *vertex.Request) (string, error) {
+		return r.Header.Get(header), nil
+	}
+}
+
+// JSONEventID extracts the event id from a top level field of a JSON body, e.g. "id" for Stripe events or
+// "event_id" for Slack events
+func JSONEventID(field string) EventIDFunc {
+	return func(r *vertex.Request) (string, error) {
+
+		body, err := r.RawBody()
+		if err != nil {
+			return "", err
+		}
+
+		var fields map[string]interface{}
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return "", err
+		}
+		if v, found := fields[field]; found && v != nil {
+			return fmt.Sprint(v), nil
+		}
+		return "", nil
+	}
+}
+
+// WebhookDedup is a middleware that processes every webhook event once, even if the provider delivers it several
+// times. Event ids are remembered in a shared Store for a window, so all the instances of a service see them.
+//
+// A redelivery of a processed event is acknowledged without calling the handler. A redelivery while the first one
+// is still processing is answered with 503, so the provider retries it later. If the handler fails, the event is
+// forgotten so the provider's retry processes it. The claim of a processing event lasts until the request's deadline
+// or for ProcessingTTL, whichever is longer, and is renewed for as long as the handler runs.
+//
+// Each webhook route configures its own dedup middleware, with the id extractor of its provider:
+//
+//	Middleware: vertex.MiddlewareChain(middleware.NewWebhookDedup("github", store, middleware.HeaderEventID("X-GitHub-Delivery")))
+type WebhookDedup struct {
+	Window        time.Duration
+	ProcessingTTL time.Duration
+
+	name    string
+	store   vertex.Store
+	eventID EventIDFunc
+}
+
+// NewWebhookDedup creates a dedup middleware. name separates the event ids of different providers in the store
+func NewWebhookDedup(name string, store vertex.Store, eventID EventIDFunc) *WebhookDedup {
+	return &WebhookDedup{
+		Window:        DefaultDedupWindow,
+		ProcessingTTL: DefaultDedupProcessingTTL,
+		name:          name,
+		store:         store,
+		eventID:       eventID,
+	}
+}
+
+func (d *WebhookDedup) key(id string) string {
+	return "vertex.webhook." + d.name + "." + id
+}
+
+func (d *WebhookDedup) Handle(w http.ResponseWriter, r *vertex.Request, next vertex.HandlerFunc) (interface{}, error) {
+
+	id, err := d.eventID(r)
+	if err != nil || id == "" {
+		logging.Warning("No %s event id in %s, not deduplicating: %v", d.name, r, err)
+		return next(w, r)
+	}
+
+	key := d.key(id)
+	ttl := d.processingTTL(r)
+	claimed, err := d.store.SetIfAbsent(key, []byte(dedupProcessing), ttl)
+	if err != nil {
+		// failing open - processing an event twice is better than dropping it
+		logging.Error("Could not deduplicate %s event %s: %s", d.name, id, err)
+		return next(w, r)
+	}
+
+	if !claimed {
+		state, _, _ := d.store.Get(key)
+		if string(state) == dedupProcessing {
+			logging.Info("%s event %s is already processing", d.name, id)
+			return nil, vertex.TemporarilyUnavailableError(ttl, "Event %s is already processing", id)
+		}
+		logging.Info("Skipping duplicate %s event %s", d.name, id)
+		return nil, nil
+	}
+
+	stop := d.renewClaim(key, id, ttl)
+	ret, err := next(w, r)
+	stop()
+
+	if err != nil && !vertex.IsHijacked(err) {
+		if e := d.store.Delete(key); e != nil {
+			logging.Error("Could not release %s event %s: %s", d.name, id, e)
+		}
+		return ret, err
+	}
+
+	if e := d.store.Set(key, []byte(dedupDone), d.Window); e != nil {
+		logging.Error("Could not mark %s event %s as processed: %s", d.name, id, e)
+	}
+	return ret, err
+}
+
+// processingTTL returns how long to claim an event processed by a request - until its deadline, but at least for
+// ProcessingTTL
+func (d *WebhookDedup) processingTTL(r *vertex.Request) time.Duration {
+
+	ttl := d.ProcessingTTL
+	if ttl <= 0 {
+		ttl = DefaultDedupProcessingTTL
+	}
+	if !r.Deadline.IsZero() {
+		if left := time.Until(r.Deadline); left > ttl {
+			ttl = left
+		}
+	}
+	return ttl
+}
+
+// renewClaim keeps extending the claim of a processing event until the returned func is called, so redeliveries of
+// events processed for longer than the claim's ttl are not processed again. The returned func waits for renewals
+// to stop, so they do not overwrite the event's final state
+func (d *WebhookDedup) renewClaim(key, id string, ttl time.Duration) func() {
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(ttl / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := d.store.Set(key, []byte(dedupProcessing), ttl); err != nil {
+					logging.Error("Could not renew the claim of %s event %s: %s", d.name, id, err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
@@ -0,0 +1,88 @@
+package vertex
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// HeaderMock marks responses served by the mock mode instead of the route's handler
+const HeaderMock = "X-Vertex-Mock"
+
+// maxExampleDepth bounds the nesting of generated examples, so recursive response types terminate
+const maxExampleDepth = 5
+
+// exampleTime is the value of time fields in generated examples
+var exampleTime = time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+
+// mocked returns true if a route serves examples instead of invoking its handler, because the route or the whole
+// server is in mock mode
+func (r *Route) mocked() bool {
+	return r.Mock || Config.Server.Mock
+}
+
+// example returns the example response of a route: its declared example, or an example generated from the type of
+// its Returns value
+func (r *Route) example() interface{} {
+
+	if r.Example != nil {
+		return r.Example
+	}
+	if r.Returns == nil {
+		return nil
+	}
+	return exampleValue(reflect.TypeOf(r.Returns), 0).Interface()
+}
+
+// serveMock answers a request of a mocked route with its example
+func serveMock(w http.ResponseWriter, route *Route) (interface{}, error) {
+	w.Header().Set(HeaderMock, "true")
+	return route.example(), nil
+}
+
+// exampleValue generates a value of a type with every field filled with a sample value, so mock responses have the
+// shape of real ones
+func exampleValue(t reflect.Type, depth int) reflect.Value {
+
+	v := reflect.New(t).Elem()
+	if depth > maxExampleDepth {
+		return v
+	}
+
+	if t == reflect.TypeOf(exampleTime) {
+		v.Set(reflect.ValueOf(exampleTime))
+		return v
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString("string")
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(1)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(1.5)
+	case reflect.Ptr:
+		v.Set(exampleValue(t.Elem(), depth+1).Addr())
+	case reflect.Slice:
+		v.Set(reflect.Append(reflect.MakeSlice(t, 0, 1), exampleValue(t.Elem(), depth+1)))
+	case reflect.Map:
+		if t.Key().Kind() == reflect.String {
+			v.Set(reflect.MakeMap(t))
+			v.SetMapIndex(reflect.ValueOf("key").Convert(t.Key()), exampleValue(t.Elem(), depth+1))
+		}
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" || strings.HasPrefix(f.Tag.Get("json"), "-") {
+				continue
+			}
+			v.Field(i).Set(exampleValue(f.Type, depth+1))
+		}
+	}
+	return v
+}
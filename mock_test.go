@@ -0,0 +1,109 @@
+package vertex
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockUser struct {
+	Id      int            `json:"id"`
+	Name    string         `json:"name"`
+	Admin   bool           `json:"admin"`
+	Score   float64        `json:"score"`
+	Created time.Time      `json:"created"`
+	Tags    []string       `json:"tags"`
+	Props   map[string]int `json:"props"`
+	Friend  *mockUser      `json:"friend,omitempty"`
+	Secret  string         `json:"-"`
+	private string
+}
+
+type mockUserHandler struct {
+	Id int `schema:"id" required:"true"`
+}
+
+func (h mockUserHandler) Handle(w http.ResponseWriter, r *Request) (interface{}, error) {
+	return "real", nil
+}
+
+func TestExampleValue(t *testing.T) {
+
+	u := exampleValue(reflect.TypeOf(mockUser{}), 0).Interface().(mockUser)
+	assert.Equal(t, 1, u.Id)
+	assert.Equal(t, "string", u.Name)
+	assert.True(t, u.Admin)
+	assert.Equal(t, 1.5, u.Score)
+	assert.Equal(t, exampleTime, u.Created)
+	assert.Equal(t, []string{"string"}, u.Tags)
+	assert.Equal(t, map[string]int{"key": 1}, u.Props)
+	assert.Equal(t, "", u.Secret)
+	assert.Equal(t, "", u.private)
+
+	// recursive types terminate
+	depth := 0
+	for f := &u; f != nil; f = f.Friend {
+		depth++
+	}
+	assert.True(t, depth > 1 && depth <= maxExampleDepth)
+}
+
+func TestMockMode(t *testing.T) {
+
+	a := &API{
+		Name:          "mock",
+		Version:       "1.0",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{Path: "/real", Description: "Real", Methods: GET, Handler: mockUserHandler{}, Returns: mockUser{}},
+			{
+				Path:        "/example",
+				Description: "Example",
+				Methods:     GET,
+				Handler:     mockUserHandler{},
+				Example:     map[string]string{"name": "Bob"},
+				Mock:        true,
+			},
+			{Path: "/generated", Description: "Generated", Methods: GET, Handler: mockUserHandler{}, Returns: mockUser{}, Mock: true},
+		},
+	}
+	srv := NewServer(":9971")
+	srv.AddAPI(a)
+
+	call := func(path string) *httptest.ResponseRecorder {
+		hr, _ := http.NewRequest("GET", a.FullPath(path), nil)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, hr)
+		return w
+	}
+
+	w := call("/real?id=1")
+	assert.Equal(t, `"real"`, w.Body.String())
+	assert.Equal(t, "", w.Header().Get(HeaderMock))
+
+	w = call("/example?id=1")
+	assert.Equal(t, `{"name":"Bob"}`, w.Body.String())
+	assert.Equal(t, "true", w.Header().Get(HeaderMock))
+
+	// mocked requests are still validated
+	assert.Equal(t, http.StatusBadRequest, call("/example").Code)
+
+	w = call("/generated?id=1")
+	assert.Contains(t, w.Body.String(), `"name":"string"`)
+
+	// the whole server can be mocked
+	defer func(mock bool) { Config.Server.Mock = mock }(Config.Server.Mock)
+	Config.Server.Mock = true
+	w = call("/real?id=1")
+	assert.Equal(t, "true", w.Header().Get(HeaderMock))
+	assert.Contains(t, w.Body.String(), `"tags":["string"]`)
+
+	sw := a.ToSwagger("example.com")
+	assert.Equal(t, map[string]interface{}{"application/json": map[string]string{"name": "Bob"}},
+		sw.Paths["/example"]["get"].Responses["default"].Examples)
+}
@@ -0,0 +1,367 @@
+package vertex
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// MsgPackContentType is the content type of MessagePack responses
+const MsgPackContentType = "application/msgpack"
+
+// MsgPackRenderer renders responses as MessagePack, a compact binary equivalent of JSON for internal services.
+// Responses are encoded like the JSONRenderer encodes them - struct fields are named by their json tags, times are
+// RFC 3339 strings and json.Marshaler values are encoded as the JSON they marshal to - and errors, envelopes and
+// response headers are the same as in JSON responses
+type MsgPackRenderer struct{}
+
+func (MsgPackRenderer) Render(v interface{}, e error, w http.ResponseWriter, r *Request) error {
+
+	w.Header().Set(HeaderProcessingTime, fmt.Sprintf("%.03f", time.Since(r.StartTime).Seconds()*1000))
+	w.Header().Set(HeaderRequestId, r.RequestId)
+
+	w, v, ok := envelopeResponse(w, r, v, e)
+	if !ok {
+		return nil
+	}
+
+	b, err := MarshalMsgPack(v)
+	if err != nil {
+		logging.Error("Could not encode msgpack response: %s", err)
+		writeError(w, "Error sending response")
+		return nil
+	}
+
+	w.Header().Set("Content-Type", MsgPackContentType)
+	_, err = w.Write(b)
+	return err
+}
+
+func (MsgPackRenderer) ContentTypes() []string {
+	return []string{MsgPackContentType}
+}
+
+// MarshalMsgPack encodes a value as MessagePack, the way the MsgPackRenderer encodes responses
+func MarshalMsgPack(v interface{}) ([]byte, error) {
+	enc := &msgpackEncoder{}
+	if err := enc.encode(reflect.ValueOf(v), 0); err != nil {
+		return nil, err
+	}
+	return enc.buf.Bytes(), nil
+}
+
+type msgpackEncoder struct {
+	buf bytes.Buffer
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	timeType          = reflect.TypeOf(time.Time{})
+)
+
+// encoding stops at this depth, like encoding/json fails on cyclic values
+const maxMsgPackDepth = 1000
+
+func (e *msgpackEncoder) encode(v reflect.Value, depth int) error {
+
+	if depth > maxMsgPackDepth {
+		return fmt.Errorf("msgpack: value too deep, is it cyclic?")
+	}
+	if !v.IsValid() {
+		e.buf.WriteByte(0xc0)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			e.buf.WriteByte(0xc0)
+			return nil
+		}
+	}
+
+	// the same custom encodings as JSON
+	if v.Type() == timeType {
+		e.encodeString(v.Interface().(time.Time).Format(time.RFC3339Nano))
+		return nil
+	}
+	if v.Type().Implements(jsonMarshalerType) {
+		b, err := v.Interface().(json.Marshaler).MarshalJSON()
+		if err != nil {
+			return err
+		}
+		var decoded interface{}
+		if err := json.Unmarshal(b, &decoded); err != nil {
+			return err
+		}
+		return e.encode(reflect.ValueOf(decoded), depth+1)
+	}
+	if v.Type().Implements(textMarshalerType) {
+		b, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return err
+		}
+		e.encodeString(string(b))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return e.encode(v.Elem(), depth+1)
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf.WriteByte(0xc3)
+		} else {
+			e.buf.WriteByte(0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.encodeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.encodeUint(v.Uint())
+	case reflect.Float32:
+		e.buf.WriteByte(0xca)
+		binary.Write(&e.buf, binary.BigEndian, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		e.buf.WriteByte(0xcb)
+		binary.Write(&e.buf, binary.BigEndian, math.Float64bits(v.Float()))
+	case reflect.String:
+		e.encodeString(v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			e.buf.WriteByte(0xc0)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.encodeBinary(v.Bytes())
+			return nil
+		}
+		fallthrough
+	case reflect.Array:
+		e.encodeLength(v.Len(), 0x90, 0xdc, 0xdd, 16)
+		for i := 0; i < v.Len(); i++ {
+			if err := e.encode(v.Index(i), depth+1); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			e.buf.WriteByte(0xc0)
+			return nil
+		}
+		return e.encodeMap(v, depth)
+	case reflect.Struct:
+		return e.encodeStruct(v, depth)
+	default:
+		return fmt.Errorf("msgpack: unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) encodeInt(i int64) {
+	switch {
+	case i >= 0:
+		e.encodeUint(uint64(i))
+	case i >= -32:
+		e.buf.WriteByte(byte(int8(i)))
+	case i >= math.MinInt8:
+		e.buf.WriteByte(0xd0)
+		e.buf.WriteByte(byte(int8(i)))
+	case i >= math.MinInt16:
+		e.buf.WriteByte(0xd1)
+		binary.Write(&e.buf, binary.BigEndian, int16(i))
+	case i >= math.MinInt32:
+		e.buf.WriteByte(0xd2)
+		binary.Write(&e.buf, binary.BigEndian, int32(i))
+	default:
+		e.buf.WriteByte(0xd3)
+		binary.Write(&e.buf, binary.BigEndian, i)
+	}
+}
+
+func (e *msgpackEncoder) encodeUint(u uint64) {
+	switch {
+	case u < 128:
+		e.buf.WriteByte(byte(u))
+	case u <= math.MaxUint8:
+		e.buf.WriteByte(0xcc)
+		e.buf.WriteByte(byte(u))
+	case u <= math.MaxUint16:
+		e.buf.WriteByte(0xcd)
+		binary.Write(&e.buf, binary.BigEndian, uint16(u))
+	case u <= math.MaxUint32:
+		e.buf.WriteByte(0xce)
+		binary.Write(&e.buf, binary.BigEndian, uint32(u))
+	default:
+		e.buf.WriteByte(0xcf)
+		binary.Write(&e.buf, binary.BigEndian, u)
+	}
+}
+
+// encodeLength writes the header of a string, array or map: a fix header for short lengths, and 16 or 32 bit
+// lengths otherwise
+func (e *msgpackEncoder) encodeLength(n int, fix, len16, len32 byte, fixMax int) {
+	switch {
+	case n < fixMax:
+		e.buf.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		e.buf.WriteByte(len16)
+		binary.Write(&e.buf, binary.BigEndian, uint16(n))
+	default:
+		e.buf.WriteByte(len32)
+		binary.Write(&e.buf, binary.BigEndian, uint32(n))
+	}
+}
+
+func (e *msgpackEncoder) encodeString(s string) {
+	if len(s) < 32 {
+		e.buf.WriteByte(0xa0 | byte(len(s)))
+	} else if len(s) <= math.MaxUint8 {
+		e.buf.WriteByte(0xd9)
+		e.buf.WriteByte(byte(len(s)))
+	} else {
+		e.encodeLength(len(s), 0, 0xda, 0xdb, 0)
+	}
+	e.buf.WriteString(s)
+}
+
+func (e *msgpackEncoder) encodeBinary(b []byte) {
+	if len(b) <= math.MaxUint8 {
+		e.buf.WriteByte(0xc4)
+		e.buf.WriteByte(byte(len(b)))
+	} else {
+		e.encodeLength(len(b), 0, 0xc5, 0xc6, 0)
+	}
+	e.buf.Write(b)
+}
+
+// encodeMap encodes a map with its keys sorted, so responses are deterministic like JSON responses
+func (e *msgpackEncoder) encodeMap(v reflect.Value, depth int) error {
+
+	keys := v.MapKeys()
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = fmt.Sprint(k.Interface())
+	}
+	sort.Sort(mapKeys{keys, names})
+
+	e.encodeLength(len(keys), 0x80, 0xde, 0xdf, 16)
+	for i, k := range keys {
+		if k.Kind() == reflect.String {
+			e.encodeString(k.String())
+		} else {
+			e.encodeString(names[i])
+		}
+		if err := e.encode(v.MapIndex(k), depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type mapKeys struct {
+	keys  []reflect.Value
+	names []string
+}
+
+func (m mapKeys) Len() int           { return len(m.keys) }
+func (m mapKeys) Less(i, j int) bool { return m.names[i] < m.names[j] }
+func (m mapKeys) Swap(i, j int) {
+	m.keys[i], m.keys[j] = m.keys[j], m.keys[i]
+	m.names[i], m.names[j] = m.names[j], m.names[i]
+}
+
+// structField is an encoded field of a struct
+type structField struct {
+	name  string
+	value reflect.Value
+}
+
+// encodeStruct encodes a struct as a map of its exported fields, named and omitted by their json tags
+func (e *msgpackEncoder) encodeStruct(v reflect.Value, depth int) error {
+
+	fields := structFields(v)
+	e.encodeLength(len(fields), 0x80, 0xde, 0xdf, 16)
+	for _, f := range fields {
+		e.encodeString(f.name)
+		if err := e.encode(f.value, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// structFields returns the encoded fields of a struct, including the fields of embedded structs
+func structFields(v reflect.Value) []structField {
+
+	ret := []structField{}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		parts := strings.Split(tag, ",")
+		name := parts[0]
+		omitEmpty := false
+		for _, opt := range parts[1:] {
+			omitEmpty = omitEmpty || opt == "omitempty"
+		}
+
+		fv := v.Field(i)
+		if f.Anonymous && name == "" {
+			if fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				ret = append(ret, structFields(fv)...)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		ret = append(ret, structField{name, fv})
+	}
+	return ret
+}
+
+// isEmptyValue tells if a value is omitted by omitempty, like encoding/json does
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package vertex
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type msgpackUser struct {
+	Id     int               `json:"id"`
+	Name   string            `json:"name,omitempty"`
+	Secret string            `json:"-"`
+	Raw    json.RawMessage   `json:"raw,omitempty"`
+	Props  map[string]string `json:"props,omitempty"`
+}
+
+func TestMarshalMsgPack(t *testing.T) {
+
+	check := func(v interface{}, expected ...byte) {
+		b, err := MarshalMsgPack(v)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, b)
+	}
+
+	check(nil, 0xc0)
+	check(true, 0xc3)
+	check(false, 0xc2)
+	check(7, 0x07)
+	check(-3, 0xfd)
+	check(200, 0xcc, 0xc8)
+	check(-200, 0xd1, 0xff, 0x38)
+	check(70000, 0xce, 0x00, 0x01, 0x11, 0x70)
+	check(1.5, 0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0)
+	check("hi", 0xa2, 'h', 'i')
+	check([]byte{1, 2}, 0xc4, 0x02, 1, 2)
+	check([]int{1, 2}, 0x92, 0x01, 0x02)
+	check(map[string]int{"b": 2, "a": 1}, 0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0x02)
+
+	// struct fields are encoded like JSON
+	check(msgpackUser{Id: 1, Secret: "x"}, 0x81, 0xa2, 'i', 'd', 0x01)
+	check(&msgpackUser{Id: 1, Raw: json.RawMessage(`[true]`)}, 0x82, 0xa2, 'i', 'd', 0x01, 0xa3, 'r', 'a', 'w', 0x91, 0xc3)
+
+	b, err := MarshalMsgPack(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, "2020-01-02T03:04:05Z", string(b[1:]))
+
+	long := strings.Repeat("a", 300)
+	b, _ = MarshalMsgPack(long)
+	assert.Equal(t, []byte{0xda, 0x01, 0x2c}, b[:3])
+	assert.Len(t, b, 303)
+
+	_, err = MarshalMsgPack(make(chan int))
+	assert.Error(t, err)
+}
+
+type msgpackHandler struct {
+	Fail bool `schema:"fail"`
+}
+
+func (h msgpackHandler) Handle(w http.ResponseWriter, r *Request) (interface{}, error) {
+	if h.Fail {
+		return nil, InvalidParamError("bad")
+	}
+	return msgpackUser{Id: 1}, nil
+}
+
+func TestMsgPackRenderer(t *testing.T) {
+
+	a := &API{
+		Name:          "msgpack",
+		Version:       "1.0",
+		Root:          "/msgpack",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{Path: "/user", Description: "User", Methods: GET, Handler: msgpackHandler{}, Renderer: MsgPackRenderer{}},
+		},
+	}
+	srv := NewServer(":9975")
+	srv.AddAPI(a)
+
+	call := func(path string) *httptest.ResponseRecorder {
+		hr, _ := http.NewRequest("GET", a.FullPath(path), nil)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, hr)
+		return w
+	}
+
+	w := call("/user")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, MsgPackContentType, w.Header().Get("Content-Type"))
+	assert.NotEmpty(t, w.Header().Get(HeaderRequestId))
+	assert.Equal(t, []byte{0x81, 0xa2, 'i', 'd', 0x01}, w.Body.Bytes())
+
+	// errors are rendered like in JSON responses
+	w = call("/user?fail=true")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "bad")
+
+	sw := a.ToSwagger("example.com")
+	assert.Equal(t, []string{MsgPackContentType}, sw.Paths["/user"]["get"].Produces)
+
+	// responses and errors are enveloped like JSON responses
+	enveloped := &API{
+		Name:          "msgpackenv",
+		Version:       "1.0",
+		Root:          "/msgpackenv",
+		Renderer:      MsgPackRenderer{},
+		AllowInsecure: true,
+		Envelope:      rawEnvelope{},
+		Routes: Routes{
+			{Path: "/user", Description: "User", Methods: GET, Handler: msgpackHandler{}},
+		},
+	}
+	srv.AddAPI(enveloped)
+
+	hr, _ := http.NewRequest("GET", enveloped.FullPath("/user?fail=true"), nil)
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, hr)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, MsgPackContentType, w.Header().Get("Content-Type"))
+	expected, _ := MarshalMsgPack(map[string]interface{}{"error": "bad", "status": http.StatusBadRequest})
+	assert.Equal(t, expected, w.Body.Bytes())
+}
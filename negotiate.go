@@ -0,0 +1,232 @@
+package vertex
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Negotiator picks the variant of responses for a request by its headers, e.g. their format or language.
+// Negotiators of an API run before the request is handled, and the framework adds the headers they depend on to the
+// Vary header of the response, so caches keep the variants apart.
+//
+// APIs with a Catalog negotiate the language of responses by the Accept-Language header, setting the
+// Content-Language header
+type Negotiator interface {
+	// Vary returns the request headers the negotiation depends on
+	Vary() []string
+
+	// Negotiate picks the variant for a request, and sets the response headers describing it. Negotiators may set
+	// request attributes for handlers and renderers to render the variant by
+	Negotiate(w http.ResponseWriter, r *Request)
+}
+
+// AddVary adds request headers to the Vary header of a response, keeping the headers already in it and skipping
+// duplicates. The framework always adds to the Vary header rather than setting it, so negotiators, caching hints
+// and middleware do not override each other
+func AddVary(h http.Header, headers ...string) {
+
+	current := []string{}
+	for _, v := range h["Vary"] {
+		for _, header := range strings.Split(v, ",") {
+			if header = strings.TrimSpace(header); header != "" {
+				current = append(current, header)
+			}
+		}
+	}
+
+	vary := current
+	for _, header := range headers {
+		found := false
+		for _, v := range vary {
+			if v == "*" || strings.EqualFold(v, header) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			vary = append(vary, header)
+		}
+	}
+
+	if len(vary) > 0 {
+		h.Set("Vary", strings.Join(vary, ", "))
+	}
+}
+
+// languageNegotiator negotiates the language of responses of APIs with a message catalog
+type languageNegotiator struct {
+	catalog *Catalog
+}
+
+func (n languageNegotiator) Vary() []string {
+	return []string{"Accept-Language"}
+}
+
+func (n languageNegotiator) Negotiate(w http.ResponseWriter, r *Request) {
+	if locale, found := n.catalog.Match(r.Locale); found {
+		w.Header().Set("Content-Language", locale)
+	}
+}
+
+// negotiators returns the negotiators of the API, including the built in ones
+func (a *API) negotiators() []Negotiator {
+
+	ret := a.Negotiators
+	if a.Catalog != nil {
+		ret = append([]Negotiator{languageNegotiator{a.Catalog}}, ret...)
+	}
+	return ret
+}
+
+// negotiate runs the negotiators of the API for a request
+func negotiate(negotiators []Negotiator, w http.ResponseWriter, r *Request) {
+	for _, n := range negotiators {
+		AddVary(w.Header(), n.Vary()...)
+		n.Negotiate(w, r)
+	}
+}
+
+// NegotiatingRenderer renders each response with the renderer the Accept header of the request prefers, by the
+// content types the renderers advertise and the q-values of the header:
+//
+//	Renderer: vertex.NewNegotiatingRenderer(vertex.JSONRenderer{}, vertex.XMLRenderer{}, vertex.MsgPackRenderer{}),
+//
+// The renderers are in order of preference: the first one renders requests without an Accept header, and renderers
+// accepted with the same quality are picked in order. Requests accepting none of the renderers are answered with
+// 406 Not Acceptable, unless FallbackToDefault is set
+type NegotiatingRenderer struct {
+	Renderers []Renderer
+
+	// Render requests accepting none of the renderers with the first renderer rather than failing them
+	FallbackToDefault bool
+}
+
+// NewNegotiatingRenderer creates a renderer negotiating between renderers, in order of preference
+func NewNegotiatingRenderer(renderers ...Renderer) *NegotiatingRenderer {
+	return &NegotiatingRenderer{Renderers: renderers}
+}
+
+// contentTypeAliases are other names clients accept content types by. JSONRenderer advertises text/json but writes
+// application/json
+var contentTypeAliases = map[string][]string{
+	"text/json":        {"application/json"},
+	MsgPackContentType: {"application/x-msgpack"},
+	"application/xml":  {"text/xml"},
+}
+
+func (n *NegotiatingRenderer) Render(v interface{}, e error, w http.ResponseWriter, r *Request) error {
+
+	AddVary(w.Header(), "Accept")
+
+	renderer := n.negotiate(r.Header.Get("Accept"))
+	if renderer == nil {
+		w.Header().Set(HeaderRequestId, r.RequestId)
+		http.Error(w, "None of the accepted content types can be rendered. Available types: "+
+			strings.Join(n.ContentTypes(), ", "), http.StatusNotAcceptable)
+		return nil
+	}
+	return renderer.Render(v, e, w, r)
+}
+
+// negotiate returns the renderer an Accept header prefers, or nil if it accepts none of them
+func (n *NegotiatingRenderer) negotiate(accept string) Renderer {
+
+	if len(n.Renderers) == 0 {
+		return nil
+	}
+	if strings.TrimSpace(accept) == "" {
+		return n.Renderers[0]
+	}
+
+	ranges := parseAccept(accept)
+	var best Renderer
+	bestQ := 0.0
+	for _, renderer := range n.Renderers {
+
+		// the most specific range matching any of the renderer's types decides its quality, so excluding a type
+		// explicitly overrides accepting anything
+		q, specificity := 0.0, -1
+		for _, ct := range renderer.ContentTypes() {
+			for _, name := range append([]string{ct}, contentTypeAliases[mediaType(ct)]...) {
+				if tq, ts := acceptQuality(ranges, mediaType(name)); ts > specificity || ts == specificity && tq > q {
+					q, specificity = tq, ts
+				}
+			}
+		}
+		if q > bestQ {
+			best, bestQ = renderer, q
+		}
+	}
+
+	if best == nil && n.FallbackToDefault {
+		return n.Renderers[0]
+	}
+	return best
+}
+
+// ContentTypes returns the content types of all the renderers
+func (n *NegotiatingRenderer) ContentTypes() []string {
+	ret := []string{}
+	for _, renderer := range n.Renderers {
+		ret = append(ret, renderer.ContentTypes()...)
+	}
+	return ret
+}
+
+// acceptRange is a media range of an Accept header and its quality
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept parses the media ranges of an Accept header. Ranges without a valid q-value have a quality of 1
+func parseAccept(accept string) []acceptRange {
+
+	ret := []acceptRange{}
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		rng := acceptRange{mediaType: strings.ToLower(strings.TrimSpace(params[0])), q: 1}
+		if rng.mediaType == "" {
+			continue
+		}
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.TrimSpace(kv[0]) == "q" {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil && q >= 0 && q <= 1 {
+					rng.q = q
+				}
+			}
+		}
+		ret = append(ret, rng)
+	}
+	return ret
+}
+
+// acceptQuality returns the quality of a media type by the most specific range of an Accept header matching it, and
+// the specificity of the range: 2 for the media type itself, 1 for type/* and 0 for */*. If no range matches, the
+// quality is 0 and the specificity -1
+func acceptQuality(ranges []acceptRange, mediaType string) (float64, int) {
+
+	q, specificity := 0.0, -1
+	for _, rng := range ranges {
+		s := -1
+		switch {
+		case rng.mediaType == mediaType:
+			s = 2
+		case strings.HasSuffix(rng.mediaType, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(rng.mediaType, "*")):
+			s = 1
+		case rng.mediaType == "*/*" || rng.mediaType == "*":
+			s = 0
+		}
+		if s > specificity {
+			q, specificity = rng.q, s
+		}
+	}
+	return q, specificity
+}
+
+// mediaType strips the parameters of a content type
+func mediaType(contentType string) string {
+	return strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+}
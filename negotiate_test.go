@@ -0,0 +1,156 @@
+package vertex
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddVary(t *testing.T) {
+
+	h := http.Header{}
+	AddVary(h)
+	assert.Equal(t, "", h.Get("Vary"))
+
+	AddVary(h, "Accept-Encoding")
+	AddVary(h, "accept-encoding", "Accept-Language")
+	assert.Equal(t, "Accept-Encoding, Accept-Language", h.Get("Vary"))
+
+	h = http.Header{"Vary": {"Origin, Accept", "Cookie"}}
+	AddVary(h, "Accept", "Authorization")
+	assert.Equal(t, "Origin, Accept, Cookie, Authorization", h.Get("Vary"))
+
+	h = http.Header{"Vary": {"*"}}
+	AddVary(h, "Accept")
+	assert.Equal(t, "*", h.Get("Vary"))
+}
+
+type formatNegotiator struct{}
+
+func (formatNegotiator) Vary() []string {
+	return []string{"Accept"}
+}
+
+func (formatNegotiator) Negotiate(w http.ResponseWriter, r *Request) {
+	r.SetAttribute("format", r.Header.Get("Accept"))
+}
+
+func TestNegotiation(t *testing.T) {
+
+	a := &API{
+		Name:          "negotiate",
+		Version:       "1.0",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Catalog:       NewCatalog().Add("en", map[string]string{"hi": "Hi"}).Add("fr", map[string]string{"hi": "Salut"}),
+		Negotiators:   []Negotiator{formatNegotiator{}},
+		Routes: Routes{
+			{
+				Path:        "/hi",
+				Description: "Hi",
+				Methods:     GET,
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					format, _ := r.Attribute("format")
+					return Cacheable(Msg("hi"), time.Minute, Vary("Accept-Language", format.(string))), nil
+				}),
+			},
+		},
+	}
+	srv := NewServer(":9970")
+	srv.AddAPI(a)
+
+	hr, _ := http.NewRequest("GET", a.FullPath("/hi"), nil)
+	hr.Header.Set("Accept-Language", "fr-CA")
+	hr.Header.Set("Accept", "X-Custom")
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, hr)
+
+	assert.Equal(t, `"Salut"`, w.Body.String())
+	assert.Equal(t, "fr", w.Header().Get("Content-Language"))
+	assert.Equal(t, "Accept-Language, Accept, X-Custom", w.Header().Get("Vary"))
+
+	hr.Header.Set("Accept-Language", "de")
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, hr)
+	assert.Equal(t, `"Hi"`, w.Body.String())
+	assert.Equal(t, "en", w.Header().Get("Content-Language"))
+}
+
+func TestNegotiatingRenderer(t *testing.T) {
+
+	n := NewNegotiatingRenderer(JSONRenderer{}, XMLRenderer{}, MsgPackRenderer{})
+	assert.Equal(t, []string{"text/json", "application/xml", MsgPackContentType}, n.ContentTypes())
+
+	check := func(accept string, expected Renderer) {
+		assert.Equal(t, expected, n.negotiate(accept), accept)
+	}
+
+	check("", JSONRenderer{})
+	check("*/*", JSONRenderer{})
+	check("application/json", JSONRenderer{})
+	check("text/xml", XMLRenderer{})
+	check("application/msgpack", MsgPackRenderer{})
+	check("application/*", JSONRenderer{})
+	check("application/json;q=0.5, application/xml", XMLRenderer{})
+	check("application/xml;q=0.9, application/msgpack;q=0.9, */*;q=0.1", XMLRenderer{})
+	check("application/*;q=0.2, application/msgpack;q=0.8", MsgPackRenderer{})
+	check("application/json;q=0, */*", XMLRenderer{})
+	check("text/html", nil)
+
+	n.FallbackToDefault = true
+	check("text/html", JSONRenderer{})
+}
+
+type negotiatedItem struct {
+	Id   int    `json:"id" xml:"id"`
+	Name string `json:"name" xml:"name"`
+}
+
+type negotiatedHandler struct{}
+
+func (negotiatedHandler) Handle(w http.ResponseWriter, r *Request) (interface{}, error) {
+	return negotiatedItem{Id: 1, Name: "foo"}, nil
+}
+
+func TestNegotiatedRendering(t *testing.T) {
+
+	a := &API{
+		Name:          "negotiated",
+		Version:       "1.0",
+		Root:          "/negotiated",
+		Renderer:      NewNegotiatingRenderer(JSONRenderer{}, XMLRenderer{}),
+		AllowInsecure: true,
+		Routes: Routes{
+			{Path: "/item", Description: "Item", Methods: GET, Handler: negotiatedHandler{}},
+		},
+	}
+	srv := NewServer(":9977")
+	srv.AddAPI(a)
+
+	call := func(accept string) *httptest.ResponseRecorder {
+		hr, _ := http.NewRequest("GET", a.FullPath("/item"), nil)
+		hr.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, hr)
+		return w
+	}
+
+	w := call("application/xml")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/xml; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "<negotiatedItem><id>1</id><name>foo</name></negotiatedItem>")
+	assert.Contains(t, w.Header().Get("Vary"), "Accept")
+
+	w = call("application/json, application/xml;q=0.5")
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), `"name":"foo"`)
+
+	w = call("text/html")
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+	assert.Contains(t, w.Body.String(), "application/xml")
+
+	assert.Equal(t, []string{"text/json", "application/xml"}, a.ToSwagger("example.com").Produces)
+}
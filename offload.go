@@ -0,0 +1,188 @@
+package vertex
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// DefaultOffloadTTL is how long offloaded responses can be downloaded, if not set in the offload options
+const DefaultOffloadTTL = 15 * time.Minute
+
+// BlobStore stores offloaded responses for clients to download, e.g. in S3 or GCS
+type BlobStore interface {
+	// Put stores a blob by key, and returns the URL clients download it from. The blob must be available for at least
+	// the ttl, and stores may sign the URL so it expires with it
+	Put(key, contentType string, data []byte, ttl time.Duration) (string, error)
+}
+
+// OffloadOptions configure offloading the large responses of a route to a blob store. Responses over the threshold
+// are rendered as usual, stored, and answered with a 303 See Other redirect to their URL, rather than sent through
+// the API:
+//
+//	Offload: &vertex.OffloadOptions{Store: s3Store, Threshold: 10 << 20, TTL: time.Hour},
+//
+// Only successful responses are offloaded. If storing a response fails, it is sent as usual
+type OffloadOptions struct {
+	Store BlobStore
+
+	// Responses larger than the threshold in bytes are offloaded
+	Threshold int
+
+	// How long offloaded responses can be downloaded. Defaults to DefaultOffloadTTL
+	TTL time.Duration
+}
+
+// offload stores a rendered response and redirects the client to it
+func (o *OffloadOptions) offload(rec *partRecorder, routePath string, w http.ResponseWriter, r *Request) error {
+
+	ttl := o.TTL
+	if ttl <= 0 {
+		ttl = DefaultOffloadTTL
+	}
+
+	// request ids may come from clients, so blobs are stored under keys nobody can guess or choose
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+
+	u, err := o.Store.Put(base64.RawURLEncoding.EncodeToString(b), rec.header.Get("Content-Type"), rec.body.Bytes(), ttl)
+	if err != nil {
+		logging.Error("Could not offload response of %s: %s", routePath, err)
+		responseSizeLimits.Add(routePath, "offload_failed", 1)
+		return err
+	}
+
+	logging.Debug("Offloaded response of %s, %d bytes, to %s", routePath, rec.body.Len(), u)
+	responseSizeLimits.Add(routePath, "offloaded", 1)
+	recordRequestLog(r.RequestId, fmt.Sprintf("Offloaded response of %d bytes", rec.body.Len()))
+
+	for _, k := range []string{HeaderProcessingTime, HeaderRequestId} {
+		w.Header().Set(k, rec.header.Get(k))
+	}
+	w.Header().Set("Location", u)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusSeeOther)
+	return nil
+}
+
+// MemoryBlobStore is a blob store keeping blobs in memory and serving them itself, for development and tests, or for
+// single instance deployments. Mount it with a raw route under the base URL:
+//
+//	store := vertex.NewMemoryBlobStore("https://api.example.com/myapi/1.0/blobs", vertex.GetKeyRing("blobs"))
+//	...
+//	{Path: "/blobs/:key", Description: "Offloaded responses", Methods: vertex.GET, Handler: vertex.Raw(store)},
+//
+// If the store has a key ring, the URLs of blobs are signed with its primary key, so they cannot be guessed or used
+// after they expire. URLs stay valid across key rotations until their key is retired
+type MemoryBlobStore struct {
+	baseURL string
+	keys    *KeyRing
+
+	mtx   sync.Mutex
+	blobs map[string]memoryBlob
+}
+
+type memoryBlob struct {
+	contentType string
+	data        []byte
+	expires     time.Time
+}
+
+// NewMemoryBlobStore creates a memory blob store serving blobs under a base URL, signing their URLs with a key ring
+// if it is not nil
+func NewMemoryBlobStore(baseURL string, keys *KeyRing) *MemoryBlobStore {
+	return &MemoryBlobStore{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		keys:    keys,
+		blobs:   map[string]memoryBlob{},
+	}
+}
+
+func (s *MemoryBlobStore) Put(key, contentType string, data []byte, ttl time.Duration) (string, error) {
+
+	expires := time.Now().Add(ttl)
+
+	var signing Key
+	if s.keys != nil {
+		var ok bool
+		if signing, ok = s.keys.Primary(); !ok {
+			return "", NewErrorf("Key ring %s has no keys", s.keys.Name())
+		}
+	}
+
+	s.mtx.Lock()
+	now := time.Now()
+	for k, b := range s.blobs {
+		if now.After(b.expires) {
+			delete(s.blobs, k)
+		}
+	}
+	s.blobs[key] = memoryBlob{contentType, data, expires}
+	s.mtx.Unlock()
+
+	u := s.baseURL + "/" + url.PathEscape(key)
+	if s.keys != nil {
+		exp := strconv.FormatInt(expires.Unix(), 10)
+		u += "?" + url.Values{
+			ExpiresParam:   {exp},
+			KeyIdParam:     {signing.ID},
+			SignatureParam: {signBlob(signing.Secret, key, exp)},
+		}.Encode()
+	}
+	return u, nil
+}
+
+// signBlob signs the key and expiration time of a blob
+func signBlob(secret []byte, key, expires string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(key + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ServeHTTP serves a blob by the last element of the request path
+func (s *MemoryBlobStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	key := path.Base(r.URL.Path)
+
+	if s.keys != nil {
+		q := r.URL.Query()
+		exp := q.Get(ExpiresParam)
+		signing, found := s.keys.Key(q.Get(KeyIdParam))
+		if !found || !hmac.Equal([]byte(signBlob(signing.Secret, key, exp)), []byte(q.Get(SignatureParam))) {
+			http.Error(w, "Invalid signature", http.StatusForbidden)
+			return
+		}
+		if ts, err := strconv.ParseInt(exp, 10, 64); err != nil || time.Now().After(time.Unix(ts, 0)) {
+			http.Error(w, "Link expired", http.StatusGone)
+			return
+		}
+	}
+
+	s.mtx.Lock()
+	b, found := s.blobs[key]
+	s.mtx.Unlock()
+	if !found || time.Now().After(b.expires) {
+		http.NotFound(w, r)
+		return
+	}
+
+	if b.contentType != "" {
+		w.Header().Set("Content-Type", b.contentType)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(b.data)))
+	w.Write(b.data)
+}
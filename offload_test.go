@@ -0,0 +1,78 @@
+package vertex
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type failingBlobStore struct{}
+
+func (failingBlobStore) Put(key, contentType string, data []byte, ttl time.Duration) (string, error) {
+	return "", errors.New("store down")
+}
+
+func TestOffloadResponses(t *testing.T) {
+
+	a := &API{
+		Name:          "offload",
+		Version:       "1.0",
+		Root:          "/offload",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+	}
+	ring := NewKeyRing("blobs", Key{ID: "k1", Secret: testSecret("s3cr3t")})
+	store := NewMemoryBlobStore(a.FullPath("/blobs"), ring)
+	a.Routes = Routes{
+		{Path: "/export", Description: "Export", Methods: GET, Handler: sizedHandler{}, Offload: &OffloadOptions{Store: store, Threshold: 100}},
+		{Path: "/fallback", Description: "Fallback", Methods: GET, Handler: sizedHandler{}, Offload: &OffloadOptions{Store: failingBlobStore{}, Threshold: 100}},
+		{Path: "/blobs/:key", Description: "Blobs", Methods: GET, Handler: Raw(store)},
+	}
+	srv := NewServer(":9992")
+	srv.AddAPI(a)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		hr, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, hr)
+		return w
+	}
+
+	w := get(a.FullPath("/export?size=10"))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = get(a.FullPath("/export?size=500"))
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Empty(t, w.Body.String())
+	location := w.Header().Get("Location")
+	assert.Contains(t, location, "signature=")
+	assert.NotContains(t, location, w.Header().Get(HeaderRequestId))
+
+	w = get(location)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "application/json")
+	assert.Contains(t, w.Body.String(), strings.Repeat("x", 500))
+
+	assert.Equal(t, http.StatusForbidden, get(strings.Replace(location, "signature=", "signature=0", 1)).Code)
+	assert.Equal(t, int64(1), responseSizeLimits.Value(a.FullPath("/export"), "offloaded"))
+
+	// links stay valid after a key rotation, until their key is retired
+	assert.NoError(t, ring.Rotate(Key{ID: "k2", Secret: testSecret("n3w")}))
+	assert.Equal(t, http.StatusOK, get(location).Code)
+	assert.NoError(t, ring.Retire("k1"))
+	assert.Equal(t, http.StatusForbidden, get(location).Code)
+
+	// expired links are gone
+	u, _ := store.Put("old", "text/plain", []byte("old"), -time.Minute)
+	assert.Equal(t, http.StatusGone, get(u).Code)
+
+	// responses are sent as usual if they cannot be offloaded
+	w = get(a.FullPath("/fallback?size=500"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), strings.Repeat("x", 500))
+}
@@ -0,0 +1,254 @@
+package vertex
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultTokenTTL is how long one-time tokens are valid, if not set
+const DefaultTokenTTL = 24 * time.Hour
+
+// TokenParam is the request param one-time tokens are sent in
+const TokenParam = "token"
+
+// OneTimeToken is a token issued for a single use, e.g. a password reset or an invite
+type OneTimeToken struct {
+	// The id of the token in its store. It is a hash of the token, so tokens cannot be recovered from the store
+	ID string
+
+	// What the token is for, e.g. password_reset
+	Purpose string
+
+	// Who the token was issued for, e.g. a user id or an email address
+	Subject string
+
+	// Data attached to the token when it was issued
+	Data map[string]string
+
+	Expires time.Time
+}
+
+// TokenStore stores issued one-time tokens until they are used or expire, e.g. in redis or a database table.
+// Tokens are issued for subjects, so stores should also be registered with AddSubjectStore, implementing
+// SubjectDataStore, as MemoryTokenStore does
+type TokenStore interface {
+	// Save stores a token
+	Save(t OneTimeToken) error
+
+	// Get loads a token by id. It returns false if there is no such token
+	Get(id string) (OneTimeToken, bool, error)
+
+	// Delete deletes a token by id. It returns false if there was no such token, so of concurrent deletes of a
+	// token only one succeeds
+	Delete(id string) (bool, error)
+}
+
+// OneTimeTokens issues and redeems one-time tokens of a purpose, for flows like password resets, email verification
+// and invites:
+//
+//	var resets = vertex.OneTimeTokens{Purpose: "password_reset", Store: tokenStore, TTL: time.Hour, Signer: &signer}
+//
+//	// when a reset is requested
+//	link, err := resets.IssueURL("https://example.com/reset", user.Id, nil)
+//
+//	// when the link is followed
+//	token, err := resets.RedeemRequest(r)
+//
+// Tokens are burned when they are redeemed, so each works once
+type OneTimeTokens struct {
+	Purpose string
+	Store   TokenStore
+
+	// How long tokens are valid. Defaults to DefaultTokenTTL
+	TTL time.Duration
+
+	// If set, the URLs of tokens are signed, so they are rejected before the store is queried if tampered with
+	Signer *URLSigner
+}
+
+func (o OneTimeTokens) ttl() time.Duration {
+	if o.TTL <= 0 {
+		return DefaultTokenTTL
+	}
+	return o.TTL
+}
+
+// tokenId returns the id of a token in its store
+func tokenId(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// Issue issues a token for a subject, with optional data attached, and returns it
+func (o OneTimeTokens) Issue(subject string, data map[string]string) (string, error) {
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	err := o.Store.Save(OneTimeToken{
+		ID:      tokenId(token),
+		Purpose: o.Purpose,
+		Subject: subject,
+		Data:    data,
+		Expires: time.Now().Add(o.ttl()),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// IssueURL issues a token and returns a URL with the token in its token param, signed if the tokens have a signer
+func (o OneTimeTokens) IssueURL(rawurl, subject string, data map[string]string) (string, error) {
+
+	token, err := o.Issue(subject, data)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set(TokenParam, token)
+	u.RawQuery = q.Encode()
+
+	if o.Signer != nil {
+		return o.Signer.Sign(u.String(), o.ttl())
+	}
+	return u.String(), nil
+}
+
+// Verify checks a token is valid without burning it, e.g. to show the form a reset link leads to
+func (o OneTimeTokens) Verify(token string) (*OneTimeToken, error) {
+
+	t, found, err := o.Store.Get(tokenId(token))
+	if err != nil {
+		return nil, err
+	}
+	if !found || t.Purpose != o.Purpose || time.Now().After(t.Expires) {
+		return nil, InvalidParamError("The token is invalid, expired or was already used")
+	}
+	return &t, nil
+}
+
+// Redeem verifies a token and burns it
+func (o OneTimeTokens) Redeem(token string) (*OneTimeToken, error) {
+
+	t, err := o.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	deleted, err := o.Store.Delete(t.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !deleted {
+		return nil, InvalidParamError("The token is invalid, expired or was already used")
+	}
+	return t, nil
+}
+
+// RedeemRequest redeems the token in the token param of a request, checking the signature of its URL first if the
+// tokens have a signer
+func (o OneTimeTokens) RedeemRequest(r *Request) (*OneTimeToken, error) {
+
+	if o.Signer != nil {
+		if err := o.Signer.Verify(r.URL); err != nil {
+			return nil, err
+		}
+	}
+
+	token := r.FormValue(TokenParam)
+	if token == "" {
+		return nil, MissingParamError("Missing %s", TokenParam)
+	}
+	return o.Redeem(token)
+}
+
+// MemoryTokenStore is a token store keeping tokens in memory, for tests and single instance services
+type MemoryTokenStore struct {
+	mtx    sync.Mutex
+	tokens map[string]OneTimeToken
+}
+
+// NewMemoryTokenStore creates an empty memory token store. Tokens are issued for subjects, so the store is
+// registered as a subject data store
+func NewMemoryTokenStore() *MemoryTokenStore {
+	s := &MemoryTokenStore{tokens: map[string]OneTimeToken{}}
+	AddSubjectStore("one_time_tokens", s)
+	return s
+}
+
+func (s *MemoryTokenStore) Save(t OneTimeToken) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	// expired tokens are purged as new ones are issued
+	now := time.Now()
+	for id, existing := range s.tokens {
+		if now.After(existing.Expires) {
+			delete(s.tokens, id)
+		}
+	}
+	s.tokens[t.ID] = t
+	return nil
+}
+
+func (s *MemoryTokenStore) Get(id string) (OneTimeToken, bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	t, found := s.tokens[id]
+	return t, found, nil
+}
+
+func (s *MemoryTokenStore) Delete(id string) (bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	_, found := s.tokens[id]
+	delete(s.tokens, id)
+	return found, nil
+}
+
+// ExportSubject returns the live tokens of a subject. Token ids are hashes, so the tokens cannot be used from exports
+func (s *MemoryTokenStore) ExportSubject(subject string) (interface{}, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	ret := []OneTimeToken{}
+	for _, t := range s.tokens {
+		if t.Subject == subject {
+			ret = append(ret, t)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Expires.Before(ret[j].Expires) })
+	return ret, nil
+}
+
+// PurgeSubject deletes the tokens of a subject
+func (s *MemoryTokenStore) PurgeSubject(subject string) (int, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	n := 0
+	for id, t := range s.tokens {
+		if t.Subject == subject {
+			delete(s.tokens, id)
+			n++
+		}
+	}
+	return n, nil
+}
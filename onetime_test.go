@@ -0,0 +1,90 @@
+package vertex
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOneTimeTokens(t *testing.T) {
+
+	store := NewMemoryTokenStore()
+	resets := OneTimeTokens{Purpose: "password_reset", Store: store, TTL: time.Hour}
+	invites := OneTimeTokens{Purpose: "invite", Store: store}
+
+	token, err := resets.Issue("user12", map[string]string{"email": "a@b.c"})
+	assert.NoError(t, err)
+
+	// tokens are stored by their hash
+	_, found, _ := store.Get(token)
+	assert.False(t, found)
+
+	tok, err := resets.Verify(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "user12", tok.Subject)
+	assert.Equal(t, "a@b.c", tok.Data["email"])
+
+	_, err = invites.Redeem(token)
+	assert.Error(t, err)
+
+	tok, err = resets.Redeem(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "user12", tok.Subject)
+
+	_, err = resets.Redeem(token)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, errorStatus(err))
+
+	// concurrent redemptions of a token succeed once
+	token, _ = invites.Issue("bob@example.com", nil)
+	var redeemed int32
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := invites.Redeem(token); err == nil {
+				atomic.AddInt32(&redeemed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), redeemed)
+
+	expired := OneTimeTokens{Purpose: "expired", Store: store, TTL: time.Nanosecond}
+	token, _ = expired.Issue("user12", nil)
+	time.Sleep(time.Millisecond)
+	_, err = expired.Redeem(token)
+	assert.Error(t, err)
+}
+
+func TestOneTimeTokenURLs(t *testing.T) {
+
+	signer := &URLSigner{KeyRing: NewKeyRing("tokens", Key{ID: "k1", Secret: testSecret("one")})}
+	verifications := OneTimeTokens{Purpose: "verify_email", Store: NewMemoryTokenStore(), Signer: signer}
+
+	link, err := verifications.IssueURL("https://example.com/verify?lang=en", "user12", nil)
+	assert.NoError(t, err)
+	assert.Contains(t, link, "token=")
+	assert.Contains(t, link, "signature=")
+
+	request := func(link string) *Request {
+		hr, _ := http.NewRequest("GET", link, nil)
+		return NewRequest(hr)
+	}
+
+	_, err = verifications.RedeemRequest(request(strings.Replace(link, "lang=en", "lang=fr", 1)))
+	assert.Error(t, err)
+
+	tok, err := verifications.RedeemRequest(request(link))
+	assert.NoError(t, err)
+	assert.Equal(t, "user12", tok.Subject)
+
+	_, err = verifications.RedeemRequest(request(link))
+	assert.Error(t, err)
+}
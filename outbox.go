@@ -0,0 +1,215 @@
+package vertex
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"code.google.com/p/go-uuid/uuid"
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// Default Outbox settings
+const (
+	DefaultOutboxInterval = 5 * time.Second
+	DefaultOutboxBatch    = 100
+)
+
+// OutboxEvent is an event recorded in an outbox, waiting to be delivered
+type OutboxEvent struct {
+	ID      string
+	Topic   string
+	Key     string
+	Value   []byte
+	Headers map[string]string
+	Created time.Time
+}
+
+// OutboxStore stores the events of an outbox in the application's database, e.g. in an outbox table. Events are
+// inserted in the transaction of the change they describe, so they are committed or rolled back with it
+type OutboxStore interface {
+	// Insert records events in a transaction of the application, e.g. the *sql.Tx opened by the transaction middleware
+	Insert(tx interface{}, events ...OutboxEvent) error
+
+	// Pending returns up to limit committed events that were not delivered yet, oldest first
+	Pending(ctx context.Context, limit int) ([]OutboxEvent, error)
+
+	// Delivered marks events as delivered, e.g. by deleting them
+	Delivered(ctx context.Context, ids ...string) error
+}
+
+// Outbox delivers events reliably after the transactions recording them commit, with the transactional outbox
+// pattern: handlers record events in their own transaction, and a worker publishes the committed ones. Events of
+// transactions that roll back are never published, and events are published until delivered, even if the server
+// crashes right after a commit:
+//
+//	outbox := vertex.NewOutbox("outbox", outboxTable, vertex.NewPublisher("events", kafkaProducer))
+//	srv.AddWorker("outbox", outbox)
+//
+// Routes wrapped with the transaction middleware record events in the transaction it opened, and have them
+// published if it commits:
+//
+//	func (h AddUserHandler) Handle(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+//		tx := middleware.TransactionFrom(r)
+//		...
+//		return user, h.Outbox.Record(r, tx, "users", user.Id, UserAdded{user})
+//	}
+//
+// Events recorded by a request are published as soon as its response is rendered, and the store is polled for
+// events left over from failures every Interval. Delivery is at least once, so consumers should deduplicate events
+// by their id, sent in the MessageHeaderEventId header
+type Outbox struct {
+	// How often to poll the store for pending events. Defaults to DefaultOutboxInterval
+	Interval time.Duration
+
+	// The maximum number of events published at a time. Defaults to DefaultOutboxBatch
+	BatchSize int
+
+	name      string
+	store     OutboxStore
+	publisher *Publisher
+	wakeup    chan struct{}
+}
+
+// MessageHeaderEventId is the header of messages published by an outbox carrying the id of their event
+const MessageHeaderEventId = "vertex-event-id"
+
+// name => recorded/delivered/failed => value
+var outboxMetrics = newCounterMap("vertex.outboxes")
+
+// NewOutbox creates an outbox publishing the events of a store with a publisher, and provides it for injection by
+// name. The outbox is a worker, and must be added to the server to deliver events
+func NewOutbox(name string, store OutboxStore, publisher *Publisher) *Outbox {
+
+	o := &Outbox{
+		Interval:  DefaultOutboxInterval,
+		BatchSize: DefaultOutboxBatch,
+		name:      name,
+		store:     store,
+		publisher: publisher,
+		wakeup:    make(chan struct{}, 1),
+	}
+
+	Provide(name, o)
+	return o
+}
+
+// Record encodes a value as JSON and records it as an event in a transaction, to be published to a topic after
+// the transaction commits. The event carries the request id, principal and route of r for tracing. r may be nil when
+// recording outside of a request
+func (o *Outbox) Record(r *Request, tx interface{}, topic, key string, v interface{}) error {
+
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	e := OutboxEvent{
+		ID:      uuid.New(),
+		Topic:   topic,
+		Key:     key,
+		Value:   value,
+		Headers: messageHeaders(r),
+		Created: time.Now(),
+	}
+	if err := o.store.Insert(tx, e); err != nil {
+		return logging.Errorf("Could not record event in outbox %s: %s", o.name, err)
+	}
+	outboxMetrics.Add(o.name, "recorded", 1)
+
+	// the transaction is committed by the time the response is rendered, so we publish right after it
+	if r != nil {
+		r.Defer(func(ctx context.Context) {
+			o.Wake()
+		})
+	}
+	return nil
+}
+
+// Wake makes the outbox publish pending events now rather than at its next poll
+func (o *Outbox) Wake() {
+	select {
+	case o.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// Run publishes pending events until the context is canceled, and publishes the events left when it is
+func (o *Outbox) Run(ctx context.Context) error {
+
+	interval := o.Interval
+	if interval <= 0 {
+		interval = DefaultOutboxInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// events recorded by the last requests are published before the publisher closes
+			o.dispatch(context.Background())
+			return nil
+		case <-ticker.C:
+		case <-o.wakeup:
+		}
+		o.dispatch(ctx)
+	}
+}
+
+// dispatch publishes pending events in batches, in order. It stops at the first failure, so events are not
+// published out of order, and retries from it at the next poll
+func (o *Outbox) dispatch(ctx context.Context) {
+
+	limit := o.BatchSize
+	if limit <= 0 {
+		limit = DefaultOutboxBatch
+	}
+
+	for {
+		events, err := o.store.Pending(ctx, limit)
+		if err != nil {
+			logging.Error("Could not load pending events of outbox %s: %s", o.name, err)
+			return
+		}
+
+		delivered := make([]string, 0, len(events))
+		for _, e := range events {
+
+			headers := map[string]string{MessageHeaderEventId: e.ID}
+			for k, v := range e.Headers {
+				headers[k] = v
+			}
+
+			err = o.publisher.Send(ctx, &ProducerMessage{
+				Topic:   e.Topic,
+				Key:     []byte(e.Key),
+				Value:   e.Value,
+				Headers: headers,
+			})
+			if err != nil {
+				outboxMetrics.Add(o.name, "failed", 1)
+				break
+			}
+			delivered = append(delivered, e.ID)
+		}
+
+		if len(delivered) > 0 {
+			if err := o.store.Delivered(ctx, delivered...); err != nil {
+				// the events are published again at the next poll
+				logging.Error("Could not mark events of outbox %s delivered: %s", o.name, err)
+				return
+			}
+			outboxMetrics.Add(o.name, "delivered", int64(len(delivered)))
+		}
+
+		if err != nil || len(events) < limit {
+			return
+		}
+	}
+}
+
+// OutboxStats returns the number of events recorded in an outbox, delivered, and failed delivery attempts
+func OutboxStats(name string) (recorded, delivered, failed int64) {
+	return outboxMetrics.Value(name, "recorded"), outboxMetrics.Value(name, "delivered"), outboxMetrics.Value(name, "failed")
+}
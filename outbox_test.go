@@ -0,0 +1,170 @@
+package vertex
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockOutboxTx struct {
+	events []OutboxEvent
+}
+
+type mockOutboxStore struct {
+	mtx            sync.Mutex
+	committed      []OutboxEvent
+	failDelivered  bool
+	deliveredCalls int
+}
+
+func (s *mockOutboxStore) Insert(tx interface{}, events ...OutboxEvent) error {
+	t, ok := tx.(*mockOutboxTx)
+	if !ok {
+		return errors.New("not a transaction")
+	}
+	t.events = append(t.events, events...)
+	return nil
+}
+
+func (s *mockOutboxStore) commit(tx *mockOutboxTx) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.committed = append(s.committed, tx.events...)
+}
+
+func (s *mockOutboxStore) Pending(ctx context.Context, limit int) ([]OutboxEvent, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if len(s.committed) < limit {
+		limit = len(s.committed)
+	}
+	return append([]OutboxEvent{}, s.committed[:limit]...), nil
+}
+
+func (s *mockOutboxStore) Delivered(ctx context.Context, ids ...string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.deliveredCalls++
+	if s.failDelivered {
+		return errors.New("database unavailable")
+	}
+	done := map[string]bool{}
+	for _, id := range ids {
+		done[id] = true
+	}
+	pending := s.committed[:0]
+	for _, e := range s.committed {
+		if !done[e.ID] {
+			pending = append(pending, e)
+		}
+	}
+	s.committed = pending
+	return nil
+}
+
+func (s *mockOutboxStore) pending() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return len(s.committed)
+}
+
+func TestOutbox(t *testing.T) {
+
+	mp := &mockProducer{}
+	p := NewPublisher("test.outbox.events", mp)
+	p.MaxRetries = 0
+	store := &mockOutboxStore{}
+	o := NewOutbox("test.outbox", store, p)
+	o.BatchSize = 2
+
+	hr, _ := http.NewRequest("POST", "/users", nil)
+	r := NewRequest(hr)
+
+	// events of rolled back transactions are never published
+	rolledBack := &mockOutboxTx{}
+	assert.NoError(t, o.Record(r, rolledBack, "users", "u0", "rolled back"))
+
+	tx := &mockOutboxTx{}
+	for _, key := range []string{"u1", "u2", "u3"} {
+		assert.NoError(t, o.Record(r, tx, "users", key, map[string]string{"id": key}))
+	}
+	assert.Error(t, o.Record(r, "not a tx", "users", "u4", nil))
+
+	// nothing is published before commit
+	o.dispatch(context.Background())
+	assert.Len(t, mp.sent, 0)
+	store.commit(tx)
+
+	// failures stop the dispatch so events stay in order, and are retried later
+	mp.failures = 1
+	o.dispatch(context.Background())
+	assert.Len(t, mp.sent, 0)
+	assert.Equal(t, 3, store.pending())
+
+	o.dispatch(context.Background())
+	if assert.Len(t, mp.sent, 3) {
+		assert.Equal(t, "u1", string(mp.sent[0].Key))
+		assert.Equal(t, "u3", string(mp.sent[2].Key))
+		assert.Equal(t, `{"id":"u1"}`, string(mp.sent[0].Value))
+		assert.Equal(t, r.RequestId, mp.sent[0].Headers[MessageHeaderRequestId])
+		assert.Equal(t, tx.events[0].ID, mp.sent[0].Headers[MessageHeaderEventId])
+	}
+	assert.Equal(t, 0, store.pending())
+
+	recorded, delivered, failed := OutboxStats("test.outbox")
+	assert.Equal(t, int64(4), recorded)
+	assert.Equal(t, int64(3), delivered)
+	assert.Equal(t, int64(1), failed)
+
+	// outboxes are provided for injection
+	var h struct {
+		Outbox *Outbox `inject:"test.outbox"`
+	}
+	assert.NoError(t, inject(&h, []injection{{field: 0, name: "test.outbox"}}))
+	assert.True(t, h.Outbox == o)
+}
+
+func TestOutboxWorker(t *testing.T) {
+
+	mp := &mockProducer{}
+	store := &mockOutboxStore{}
+	o := NewOutbox("test.outbox.worker", store, NewPublisher("test.outbox.worker.events", mp))
+	o.Interval = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		o.Run(ctx)
+		close(done)
+	}()
+
+	// requests wake the worker after their response, rather than waiting for the next poll
+	hr, _ := http.NewRequest("POST", "/users", nil)
+	r := NewRequest(hr)
+	tx := &mockOutboxTx{}
+	assert.NoError(t, o.Record(r, tx, "users", "u1", "x"))
+	store.commit(tx)
+	r.runDeferred()
+	deferredWork.flush(time.Second)
+
+	for i := 0; i < 100 && store.pending() > 0; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Equal(t, 0, store.pending())
+
+	// events left when the worker stops are published before it returns
+	tx = &mockOutboxTx{}
+	assert.NoError(t, o.Record(nil, tx, "users", "u2", "x"))
+	store.commit(tx)
+	cancel()
+	<-done
+	assert.Equal(t, 0, store.pending())
+	mp.mtx.Lock()
+	assert.Len(t, mp.sent, 2)
+	mp.mtx.Unlock()
+}
@@ -0,0 +1,254 @@
+package vertex
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultOverridesInterval is how often the route overrides file is checked for changes
+const DefaultOverridesInterval = 5 * time.Second
+
+// RouteOverride tweaks the settings of a route at runtime, without code changes or redeploys. Overrides are loaded
+// from an ops owned YAML file, set in the route_overrides_file server config, and matched by route name - the full
+// path pattern of the route, as returned by Request.Route:
+//
+//	routes:
+//	  /myapi/users/:id:
+//	    rate_limit: 50
+//	  /myapi/reports:
+//	    maintenance: Reports are being migrated, please try again in an hour
+//	    retry_after_sec: 3600
+//	  /myapi/legacy/search:
+//	    disabled: true
+//
+// The file is reloaded when it changes, so overrides take effect within DefaultOverridesInterval. Removing a route
+// from the file restores its settings
+type RouteOverride struct {
+	// Reject all requests of the route as unavailable, telling clients not to retry
+	Disabled bool `yaml:"disabled"`
+
+	// Answer requests with a 503 and this message, telling clients to retry after RetryAfter
+	Maintenance string `yaml:"maintenance"`
+	RetryAfter  int    `yaml:"retry_after_sec"`
+
+	// Maximum requests per second of the route on each instance. Requests over it are rejected with 429. 0 means
+	// unlimited
+	RateLimit float64 `yaml:"rate_limit"`
+}
+
+type routeOverridesFile struct {
+	Routes map[string]RouteOverride `yaml:"routes"`
+}
+
+// routeOverride is a loaded override, with the rate limiter of its route
+type routeOverride struct {
+	RouteOverride
+	limiter *tokenBucket
+}
+
+// routeOverrides are the overrides loaded from the overrides file, by route
+var routeOverrides = struct {
+	sync.RWMutex
+	path    string
+	modTime time.Time
+	routes  map[string]*routeOverride
+
+	// incremented on every load, so caches derived from the overrides know to expire
+	generation uint64
+}{
+	routes: map[string]*routeOverride{},
+}
+
+// route => disabled/maintenance/limited => value
+var overrideMetrics = newCounterMap("vertex.overrides")
+
+// LoadRouteOverrides loads the route overrides from a file, replacing the overrides loaded before. If the file is
+// invalid, the loaded overrides are kept
+func LoadRouteOverrides(path string) error {
+
+	st, err := os.Stat(path)
+	if err != nil {
+		return logging.Errorf("Could not read route overrides: %s", err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return logging.Errorf("Could not read route overrides: %s", err)
+	}
+
+	var f routeOverridesFile
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return logging.Errorf("Could not parse route overrides %s: %s", path, err)
+	}
+
+	routeOverrides.Lock()
+	defer routeOverrides.Unlock()
+
+	routes := make(map[string]*routeOverride, len(f.Routes))
+	for route, o := range f.Routes {
+		ro := &routeOverride{RouteOverride: o}
+
+		// rate limiters keep their tokens across reloads, unless their rate changed
+		if prev, found := routeOverrides.routes[route]; found && prev.limiter != nil && prev.RateLimit == o.RateLimit {
+			ro.limiter = prev.limiter
+		} else if o.RateLimit > 0 {
+			ro.limiter = newTokenBucket(o.RateLimit)
+		}
+		routes[route] = ro
+	}
+
+	routeOverrides.path = path
+	routeOverrides.modTime = st.ModTime()
+	routeOverrides.routes = routes
+	routeOverrides.generation++
+
+	logging.Info("Loaded overrides of %d routes from %s", len(routes), path)
+	return nil
+}
+
+// routeOverridesGeneration returns the number of times the route overrides were loaded
+func routeOverridesGeneration() uint64 {
+	routeOverrides.RLock()
+	defer routeOverrides.RUnlock()
+
+	return routeOverrides.generation
+}
+
+// ReloadRouteOverrides reloads the route overrides from the file they were loaded from, e.g. on SIGHUP
+func ReloadRouteOverrides() error {
+
+	routeOverrides.RLock()
+	path := routeOverrides.path
+	routeOverrides.RUnlock()
+
+	if path == "" {
+		return nil
+	}
+	return LoadRouteOverrides(path)
+}
+
+// WatchRouteOverrides returns a worker reloading the route overrides file whenever it changes
+func WatchRouteOverrides(path string, interval time.Duration) Worker {
+
+	if interval <= 0 {
+		interval = DefaultOverridesInterval
+	}
+
+	return WorkerFunc(func(ctx context.Context) error {
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+
+			st, err := os.Stat(path)
+			if err != nil {
+				logging.Warning("Could not check route overrides %s: %s", path, err)
+				continue
+			}
+
+			routeOverrides.RLock()
+			changed := path != routeOverrides.path || !st.ModTime().Equal(routeOverrides.modTime)
+			routeOverrides.RUnlock()
+
+			if changed {
+				// failures are logged, and the previous overrides kept until the file is fixed
+				LoadRouteOverrides(path)
+			}
+		}
+	})
+}
+
+// RouteOverrides returns the overrides loaded for a route, if any
+func RouteOverrides(route string) (RouteOverride, bool) {
+	routeOverrides.RLock()
+	defer routeOverrides.RUnlock()
+
+	if o, found := routeOverrides.routes[route]; found {
+		return o.RouteOverride, true
+	}
+	return RouteOverride{}, false
+}
+
+// RouteOverrideStats returns the number of requests of a route rejected because it was disabled, in maintenance or
+// over its rate limit
+func RouteOverrideStats(route string) (disabled, maintenance, limited int64) {
+	return overrideMetrics.Value(route, "disabled"), overrideMetrics.Value(route, "maintenance"), overrideMetrics.Value(route, "limited")
+}
+
+// checkRouteOverride returns the error rejecting a request of a route by its override, or nil if it is allowed
+func checkRouteOverride(route string) error {
+
+	routeOverrides.RLock()
+	o, found := routeOverrides.routes[route]
+	routeOverrides.RUnlock()
+	if !found {
+		return nil
+	}
+
+	if o.Disabled {
+		overrideMetrics.Add(route, "disabled", 1)
+		return ResourceUnavailableError("Route %s is disabled", route)
+	}
+
+	if o.Maintenance != "" {
+		overrideMetrics.Add(route, "maintenance", 1)
+		e := TemporarilyUnavailableError(time.Duration(o.RetryAfter)*time.Second, "%s", o.Maintenance).(*internalError)
+		e.Expose = true
+		return e
+	}
+
+	if o.limiter != nil {
+		if wait := o.limiter.take(); wait > 0 {
+			overrideMetrics.Add(route, "limited", 1)
+			return TooManyRequestsError(wait, "Rate limit of %s exceeded", route)
+		}
+	}
+	return nil
+}
+
+// tokenBucket allows rate events per second, in bursts of up to a second's worth of events
+type tokenBucket struct {
+	mtx    sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	burst := math.Max(1, math.Ceil(rate))
+	return &tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// take takes a token, returning 0 if one was available or how long until one is
+func (b *tokenBucket) take() time.Duration {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	}
+	b.tokens--
+	return 0
+}
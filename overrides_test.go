@@ -0,0 +1,119 @@
+package vertex
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteOverrides(t *testing.T) {
+
+	a := &API{
+		Name:          "overrides",
+		Version:       "1.0",
+		Root:          "/overrides",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{Path: "/ok", Description: "Ok", Methods: GET, Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+				return "ok", nil
+			})},
+		},
+	}
+	srv := NewServer(":9944")
+	srv.AddAPI(a)
+	route := a.FullPath("/ok")
+
+	call := func() *httptest.ResponseRecorder {
+		hr, _ := http.NewRequest("GET", "http://example.com"+route, nil)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, hr)
+		return w
+	}
+
+	dir, err := ioutil.TempDir("", "overrides")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "overrides.yaml")
+	write := func(text string, mtime time.Time) {
+		assert.NoError(t, ioutil.WriteFile(path, []byte(text), 0644))
+		assert.NoError(t, os.Chtimes(path, mtime, mtime))
+	}
+	defer func() {
+		routeOverrides.Lock()
+		routeOverrides.path, routeOverrides.routes = "", map[string]*routeOverride{}
+		routeOverrides.Unlock()
+	}()
+
+	assert.Equal(t, http.StatusOK, call().Code)
+
+	// disabled routes are unavailable
+	write("routes:\n  "+route+":\n    disabled: true\n", time.Now().Add(-time.Hour))
+	assert.NoError(t, LoadRouteOverrides(path))
+	o, found := RouteOverrides(route)
+	assert.True(t, found)
+	assert.True(t, o.Disabled)
+	assert.Equal(t, http.StatusServiceUnavailable, call().Code)
+
+	// routes in maintenance tell clients when to retry
+	write("routes:\n  "+route+":\n    maintenance: Back soon\n    retry_after_sec: 120\n", time.Now().Add(-time.Minute))
+	assert.NoError(t, ReloadRouteOverrides())
+	w := call()
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "120", w.Header().Get("Retry-After"))
+	assert.True(t, strings.Contains(w.Body.String(), "Back soon"))
+
+	// invalid files keep the loaded overrides
+	write("routes: [", time.Now())
+	assert.Error(t, LoadRouteOverrides(path))
+	assert.Equal(t, http.StatusServiceUnavailable, call().Code)
+
+	// changes are picked up by the watcher
+	write("routes:\n  "+route+":\n    rate_limit: 2\n", time.Now().Add(time.Minute))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- WatchRouteOverrides(path, 5*time.Millisecond).Run(ctx)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	assert.NoError(t, <-done)
+
+	// rate limited routes allow bursts of a second's worth of requests
+	assert.Equal(t, http.StatusOK, call().Code)
+	assert.Equal(t, http.StatusOK, call().Code)
+	w = call()
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	disabled, maintenance, limited := RouteOverrideStats(route)
+	assert.Equal(t, int64(1), disabled)
+	assert.Equal(t, int64(2), maintenance)
+	assert.Equal(t, int64(1), limited)
+
+	// removing the route restores it
+	write("routes: {}\n", time.Now().Add(2*time.Minute))
+	assert.NoError(t, ReloadRouteOverrides())
+	assert.Equal(t, http.StatusOK, call().Code)
+}
+
+func TestTokenBucket(t *testing.T) {
+
+	b := newTokenBucket(100)
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, time.Duration(0), b.take())
+	}
+	wait := b.take()
+	assert.True(t, wait > 0 && wait <= 10*time.Millisecond)
+
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, time.Duration(0), b.take())
+}
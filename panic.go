@@ -0,0 +1,62 @@
+package vertex
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"code.google.com/p/go-uuid/uuid"
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// route => panics => value
+var panicMetrics = newCounterMap("vertex.panics")
+
+// PanicError converts a value recovered from a panic while handling a request to an error, logging the stack of the
+// panic under an incident id. The error renders as a 500 with the incident id, so reports of clients can be matched
+// with the log:
+//
+//	defer func() {
+//		if e := recover(); e != nil {
+//			err = vertex.PanicError(r, e)
+//		}
+//	}()
+//
+// Handlers do not have to recover panics themselves - the middleware chain of every route recovers them
+func PanicError(r *Request, e interface{}) error {
+
+	incident := uuid.New()
+	path := ""
+	if r != nil && r.URL != nil {
+		path = r.URL.Path
+		panicMetrics.Add(r.route, "panics", 1)
+	}
+	logging.Critical("[%s] Caught panic handling %s: %v\n%s", incident, path, e, debug.Stack())
+
+	return &internalError{
+		Message:  fmt.Sprintf("PANIC handling %s: %v", path, e),
+		Code:     ErrGeneralFailure,
+		Incident: incident,
+	}
+}
+
+// handleRecovering runs a middleware chain, converting panics to errors. Panics with http.ErrAbortHandler are
+// passed on, so the http server aborts the response
+func handleRecovering(chain *step, w http.ResponseWriter, r *Request) (ret interface{}, err error) {
+
+	defer func() {
+		if e := recover(); e != nil {
+			if e == http.ErrAbortHandler {
+				panic(e)
+			}
+			ret, err = nil, PanicError(r, e)
+		}
+	}()
+
+	return chain.handle(w, r)
+}
+
+// PanicStats returns the number of panics recovered while handling requests of a route
+func PanicStats(route string) int64 {
+	return panicMetrics.Value(route, "panics")
+}
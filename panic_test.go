@@ -0,0 +1,82 @@
+package vertex
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPanicRecovery(t *testing.T) {
+
+	a := &API{
+		Name:          "panics",
+		Version:       "1.0",
+		Root:          "/panics",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{Path: "/boom", Description: "Boom", Methods: GET, Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+				var m map[string]int
+				m["boom"] = 1
+				return nil, nil
+			})},
+			{Path: "/ok", Description: "Ok", Methods: GET, Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+				return "ok", nil
+			})},
+			{Path: "/abort", Description: "Abort", Methods: GET, Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+				panic(http.ErrAbortHandler)
+			})},
+			{Path: "/abort/timed", Description: "Abort with a timeout", Methods: GET, Timeout: time.Second, Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+				panic(http.ErrAbortHandler)
+			})},
+		},
+	}
+	srv := NewServer(":9999")
+	srv.AddAPI(a)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		hr, _ := http.NewRequest("GET", a.FullPath(path), nil)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, hr)
+		return w
+	}
+
+	// panics render as a 500 with an incident id, hiding the panic from the client
+	w := get("/boom")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.True(t, strings.HasPrefix(w.Body.String(), "["))
+	assert.Contains(t, w.Body.String(), "] Internal Server Error")
+	assert.NotContains(t, w.Body.String(), "nil map")
+	assert.Equal(t, int64(1), PanicStats(a.FullPath("/boom")))
+
+	// and the server keeps serving
+	assert.Equal(t, http.StatusOK, get("/ok").Code)
+
+	// aborting panics are left to the http server, to drop the connection
+	for _, path := range []string{"/abort", "/abort/timed"} {
+		func() {
+			defer func() {
+				assert.Equal(t, http.ErrAbortHandler, recover(), path)
+			}()
+			get(path)
+		}()
+	}
+}
+
+func TestPanicError(t *testing.T) {
+
+	hr, _ := http.NewRequest("GET", "/things", nil)
+	err := PanicError(NewRequest(hr), "boom")
+	assert.Equal(t, "PANIC handling /things: boom", err.Error())
+
+	// the incident id the panic was logged under is the one clients get
+	incident := err.(*internalError).Incident
+	assert.NotEmpty(t, incident)
+	status, message := httpError(err)
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Equal(t, "["+incident+"] Internal Server Error", message)
+}
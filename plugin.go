@@ -0,0 +1,91 @@
+package vertex
+
+import "github.com/dvirsky/go-pylog/logging"
+
+// Plugin is a third party extension that hooks into an API, e.g. metrics, auth providers or admin panels.
+// Plugins are added to an API with API.Use:
+//
+//	api := &vertex.API{...}
+//	if err := api.Use(adminpanel.New(conf)); err != nil {
+//		...
+//	}
+//
+// Embed BasePlugin to implement only the hooks a plugin needs
+type Plugin interface {
+	// Name identifies the plugin in logs and in the API documentation
+	Name() string
+
+	// Configure is called when the plugin is added to an API. It can inspect and modify the API, including the
+	// routes added before the plugin
+	Configure(api *API) error
+
+	// Routes returns the plugin's routes, added to the API after Configure
+	Routes() Routes
+
+	// Middleware returns middleware appended to the API's middleware chain
+	Middleware() []Middleware
+
+	// SwaggerExtensions returns vendor extensions added to the API's swagger document. Keys are prefixed with x- if
+	// they are not already
+	SwaggerExtensions() map[string]interface{}
+}
+
+// BasePlugin implements all the Plugin hooks as no-ops, to be embedded in plugins
+type BasePlugin struct{}
+
+// Configure does nothing
+func (BasePlugin) Configure(*API) error { return nil }
+
+// Routes returns no routes
+func (BasePlugin) Routes() Routes { return nil }
+
+// Middleware returns no middleware
+func (BasePlugin) Middleware() []Middleware { return nil }
+
+// SwaggerExtensions returns no extensions
+func (BasePlugin) SwaggerExtensions() map[string]interface{} { return nil }
+
+// Use adds a plugin to the API. It must be called before the API is added to a server
+func (a *API) Use(p Plugin) error {
+
+	for _, existing := range a.plugins {
+		if existing.Name() == p.Name() {
+			return logging.Errorf("Plugin %s is already used by API %s", p.Name(), a.Name)
+		}
+	}
+
+	if err := p.Configure(a); err != nil {
+		return logging.Errorf("Could not configure plugin %s for API %s: %s", p.Name(), a.Name, err)
+	}
+
+	a.Routes = append(a.Routes, p.Routes()...)
+	a.Middleware = append(a.Middleware, p.Middleware()...)
+	a.plugins = append(a.plugins, p)
+
+	logging.Info("API %s uses plugin %s", a.Name, p.Name())
+	return nil
+}
+
+// Plugins returns the plugins used by the API
+func (a *API) Plugins() []Plugin {
+	return a.plugins
+}
+
+// pluginsSwagger collects the swagger extensions of the API's plugins, along with the list of plugins
+func (a API) pluginsSwagger() map[string]interface{} {
+
+	if len(a.plugins) == 0 {
+		return nil
+	}
+
+	ret := map[string]interface{}{}
+	names := make([]string, 0, len(a.plugins))
+	for _, p := range a.plugins {
+		names = append(names, p.Name())
+		for k, v := range p.SwaggerExtensions() {
+			ret[k] = v
+		}
+	}
+	ret["x-plugins"] = names
+	return ret
+}
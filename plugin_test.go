@@ -0,0 +1,97 @@
+package vertex
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testPlugin struct {
+	BasePlugin
+	name       string
+	configured []string
+}
+
+func (p *testPlugin) Name() string { return p.name }
+
+func (p *testPlugin) Configure(a *API) error {
+	if a.Name == "broken" {
+		return errors.New("unsupported API")
+	}
+	for _, r := range a.Routes {
+		p.configured = append(p.configured, r.Path)
+	}
+	return nil
+}
+
+func (p *testPlugin) Routes() Routes {
+	return Routes{{
+		Path:    "/plugin/status",
+		Methods: GET,
+		Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+			return "plugin ok", nil
+		}),
+	}}
+}
+
+func (p *testPlugin) Middleware() []Middleware {
+	return []Middleware{MiddlewareFunc(func(w http.ResponseWriter, r *Request, next HandlerFunc) (interface{}, error) {
+		w.Header().Set("X-Plugin", p.name)
+		return next(w, r)
+	})}
+}
+
+func (p *testPlugin) SwaggerExtensions() map[string]interface{} {
+	return map[string]interface{}{"test-plugin": map[string]string{"version": "1"}}
+}
+
+func TestPlugins(t *testing.T) {
+
+	a := &API{
+		Name:          "plugins",
+		Version:       "1.0",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{
+				Path:    "/foo",
+				Methods: GET,
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					return "foo", nil
+				}),
+			},
+		},
+	}
+
+	p := &testPlugin{name: "test"}
+	assert.NoError(t, a.Use(p))
+	assert.Equal(t, []string{"/foo"}, p.configured)
+	assert.Error(t, a.Use(&testPlugin{name: "test"}))
+	assert.Len(t, a.Plugins(), 1)
+	assert.Len(t, a.Routes, 2)
+
+	assert.Error(t, (&API{Name: "broken"}).Use(&testPlugin{name: "test"}))
+
+	srv := NewServer(":9956")
+	srv.AddAPI(a)
+
+	for _, pth := range []string{"/foo", "/plugin/status"} {
+		req, _ := http.NewRequest("GET", a.FullPath(pth), nil)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "test", w.Header().Get("X-Plugin"))
+	}
+
+	b, err := json.Marshal(a.ToSwagger("localhost"))
+	assert.NoError(t, err)
+	doc := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(b, &doc))
+	assert.Equal(t, []interface{}{"test"}, doc["x-plugins"])
+	assert.Equal(t, map[string]interface{}{"version": "1"}, doc["x-test-plugin"])
+	assert.NotNil(t, doc["paths"])
+}
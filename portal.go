@@ -0,0 +1,77 @@
+package vertex
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// PortalPath is the read-only documentation portal of the server, listing all its APIs with links to their
+// consoles and specs
+const PortalPath = "/docs"
+
+var portalTemplate = template.Must(template.New("portal").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>API Documentation</title>
+</head>
+<body>
+<h1>API Documentation</h1>
+<table>
+<tr><th>API</th><th>Version</th><th>Description</th><th></th><th></th></tr>
+{{range .}}<tr>
+<td>{{if .Title}}{{.Title}}{{else}}{{.Name}}{{end}}</td>
+<td>{{.Version}}</td>
+<td>{{.Doc}}</td>
+<td><a href="{{.Console}}">Console</a></td>
+<td><a href="{{.Spec}}">Spec</a></td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// SetDocsSecurity makes the documentation of the server require a security scheme: the console, the portal, and
+// the specs and console links of APIs without their own DocsSecurity. Call it before adding APIs to the server
+func (s *Server) SetDocsSecurity(scheme SecurityScheme) {
+	s.docsSecurity = scheme
+}
+
+// docsHandler wraps a server level documentation handler with the docs security scheme of the server. The scheme
+// is looked up per request, since it may be set after the handlers are registered
+func (s *Server) docsHandler(route string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secureDocs(s.docsSecurity, route, h).ServeHTTP(w, r)
+	})
+}
+
+// secureDocs wraps a documentation handler with a security scheme, if it is not nil
+func secureDocs(scheme SecurityScheme, route string, h http.Handler) http.Handler {
+
+	if scheme == nil {
+		return h
+	}
+
+	stage := securityStage{scheme: scheme, route: route}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := NewRequest(r)
+		_, err := stage.Handle(w, req, func(w http.ResponseWriter, req *Request) (interface{}, error) {
+			h.ServeHTTP(w, req.Request)
+			return nil, nil
+		})
+		if err != nil {
+			renderError(w, req, err)
+		}
+	})
+}
+
+// portalHandler renders the documentation portal
+func (s *Server) portalHandler(w http.ResponseWriter, r *http.Request) {
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := portalTemplate.Execute(w, s.listAPIs()); err != nil {
+		logging.Error("Could not render the docs portal: %s", err)
+	}
+}
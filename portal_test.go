@@ -0,0 +1,84 @@
+package vertex
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDocsPortal(t *testing.T) {
+
+	scheme := SecuritySchemeFunc(func(r *Request) error {
+		if r.Header.Get("X-Docs-Token") != "s3cr3t" {
+			return MissingCredentialsError("No docs token")
+		}
+		return nil
+	})
+
+	newAPI := func(name string) *API {
+		return &API{
+			Name:          name,
+			Title:         strings.Title(name) + " API",
+			Version:       "1.0",
+			Doc:           "The " + name + " API",
+			Renderer:      JSONRenderer{},
+			AllowInsecure: true,
+			Routes: Routes{
+				{
+					Path:        "/foo",
+					Description: "Foo",
+					Methods:     GET,
+					Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+						return "foo", nil
+					}),
+				},
+			},
+		}
+	}
+
+	srv := NewServer(":9964")
+	srv.SetDocsSecurity(scheme)
+	srv.AddAPI(newAPI("portalone"))
+
+	// an API's own docs security overrides the server's
+	public := newAPI("portaltwo")
+	public.DocsSecurity = NopSecurity
+	srv.AddAPI(public)
+
+	portal := srv.docsHandler(PortalPath, http.HandlerFunc(srv.portalHandler))
+	get := func(h http.Handler, path, token string) *httptest.ResponseRecorder {
+		hr, _ := http.NewRequest("GET", path, nil)
+		if token != "" {
+			hr.Header.Set("X-Docs-Token", token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, hr)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, get(portal, PortalPath, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, get(portal, PortalPath, "wat").Code)
+
+	w := get(portal, PortalPath, "s3cr3t")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Portalone API")
+	assert.Contains(t, w.Body.String(), `href="/portalone/1.0/console"`)
+	assert.Contains(t, w.Body.String(), `href="/portaltwo/1.0/swagger"`)
+
+	// specs and console links of APIs are secured too
+	assert.Equal(t, http.StatusUnauthorized, get(srv.Handler(), "/portalone/1.0/swagger", "").Code)
+	assert.Equal(t, http.StatusOK, get(srv.Handler(), "/portalone/1.0/swagger", "s3cr3t").Code)
+	assert.Equal(t, http.StatusUnauthorized, get(srv.Handler(), "/portalone/1.0/console", "").Code)
+	assert.Equal(t, http.StatusMovedPermanently, get(srv.Handler(), "/portalone/1.0/console", "s3cr3t").Code)
+	assert.Equal(t, http.StatusOK, get(srv.Handler(), "/portaltwo/1.0/swagger", "").Code)
+
+	// routes are not affected
+	assert.Equal(t, http.StatusOK, get(srv.Handler(), "/portalone/1.0/foo", "").Code)
+
+	// without docs security the portal is public
+	srv.SetDocsSecurity(nil)
+	assert.Equal(t, http.StatusOK, get(portal, PortalPath, "").Code)
+}
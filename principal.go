@@ -0,0 +1,51 @@
+package vertex
+
+// Principal is the authenticated identity behind a request, as determined by the security scheme or by
+// authentication middleware. Its ID is used to tag logs and audit records with the data subject
+type Principal struct {
+	// A stable identifier of the subject, e.g. a user id
+	ID string
+
+	// Roles or scopes granted to the principal
+	Scopes []string
+
+	// Any extra data about the principal
+	Attributes map[string]interface{}
+}
+
+// HasScope returns true if the principal was granted the given role or scope
+func (p *Principal) HasScope(scope string) bool {
+
+	if p == nil {
+		return false
+	}
+
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Principal returns the authenticated principal of the request, or nil if the request is anonymous
+func (r *Request) Principal() *Principal {
+	return r.principal
+}
+
+// SetPrincipal sets the authenticated principal of the request. Security schemes and auth middleware should
+// call it once the request is authenticated
+func (r *Request) SetPrincipal(p *Principal) {
+	r.principal = p
+	if r.inflight != nil {
+		r.inflight.setPrincipal(r.PrincipalID())
+	}
+}
+
+// PrincipalID returns the id of the request's principal, or an empty string for anonymous requests
+func (r *Request) PrincipalID() string {
+	if r.principal == nil {
+		return ""
+	}
+	return r.principal.ID
+}
@@ -0,0 +1,81 @@
+package vertex
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ProblemContentType is the content type of RFC 7807 problem details
+const ProblemContentType = "application/problem+json"
+
+// ProblemDetails renders the errors of an API as RFC 7807 problem details documents rather than plain text:
+//
+//	{
+//		"type": "https://example.com/problems/quota_exceeded",
+//		"title": "The daily quota of the account was exceeded",
+//		"status": 429,
+//		"detail": "Daily quota of 1000 requests exceeded",
+//		"instance": "/myapi/1.0/search",
+//		"requestId": "..."
+//	}
+type ProblemDetails struct {
+	// Base URI of problem types. Errors with a public error code are of type <TypeBase><code>, titled by the
+	// description the code was registered with. Other errors are of type about:blank, meaning their status tells what
+	// went wrong
+	TypeBase string
+}
+
+// Problem is an RFC 7807 problem details document
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestId string `json:"requestId,omitempty"`
+
+	// The invalid params of validation errors
+	Violations []Violation `json:"violations,omitempty"`
+}
+
+// problem builds the problem details of an error, given the status and message it is rendered with
+func (p *ProblemDetails) problem(r *Request, e error, status int, message string) Problem {
+
+	ret := Problem{
+		Type:       "about:blank",
+		Title:      http.StatusText(status),
+		Status:     status,
+		Detail:     message,
+		RequestId:  r.RequestId,
+		Violations: Violations(e),
+	}
+	// coded errors are problem types of their own, described by their registration
+	if ie, ok := resolveError(e).(*internalError); ok && ie.PublicCode != "" {
+		if p.TypeBase != "" {
+			ret.Type = p.TypeBase + ie.PublicCode
+		}
+		if ec, found := lookupErrorCode(ie.PublicCode); found && ec.Description != "" {
+			ret.Title = ec.Description
+		}
+		ret.Detail = ie.Message
+	}
+	if r.URL != nil {
+		ret.Instance = r.URL.Path
+	}
+	return ret
+}
+
+// write writes the problem details of an error
+func (p *ProblemDetails) write(w http.ResponseWriter, r *Request, e error, status int, message string) {
+
+	buf, err := json.Marshal(p.problem(r, e, status, message))
+	if err != nil {
+		http.Error(w, message, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(buf)
+}
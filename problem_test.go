@@ -0,0 +1,72 @@
+package vertex
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProblemDetails(t *testing.T) {
+
+	quota := RegisterErrorCode("problem_quota", http.StatusTooManyRequests, "The daily quota was exceeded")
+
+	a := &API{
+		Name:          "problems",
+		Version:       "1.0",
+		Root:          "/problems",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Envelope:      StandardEnvelope{},
+		Problems:      &ProblemDetails{TypeBase: "https://example.com/problems/"},
+		Routes: Routes{
+			{Path: "/invalid", Description: "Invalid", Methods: GET, Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+				return nil, InvalidParamError("bad limit")
+			})},
+			{Path: "/quota", Description: "Quota", Methods: GET, Renderer: CSVRenderer{}, Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+				return nil, NewCodedError(quota, "Daily quota of %d requests exceeded", 1000)
+			})},
+			{Path: "/ok", Description: "Ok", Methods: GET, Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+				return "ok", nil
+			})},
+		},
+	}
+	srv := NewServer(":9995")
+	srv.AddAPI(a)
+
+	get := func(path string) (*httptest.ResponseRecorder, Problem) {
+		hr, _ := http.NewRequest("GET", a.FullPath(path), nil)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, hr)
+		var p Problem
+		json.Unmarshal(w.Body.Bytes(), &p)
+		return w, p
+	}
+
+	w, p := get("/invalid")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, ProblemContentType, w.Header().Get("Content-Type"))
+	assert.Equal(t, Problem{
+		Type:      "about:blank",
+		Title:     "Bad Request",
+		Status:    http.StatusBadRequest,
+		Detail:    "bad limit",
+		Instance:  a.FullPath("/invalid"),
+		RequestId: w.Header().Get(HeaderRequestId),
+	}, p)
+
+	// all renderers render problems
+	w, p = get("/quota")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "https://example.com/problems/problem_quota", p.Type)
+	assert.Equal(t, "The daily quota was exceeded", p.Title)
+	assert.Equal(t, "Daily quota of 1000 requests exceeded", p.Detail)
+	assert.Equal(t, "problem_quota", w.Header().Get(HeaderErrorCode))
+
+	// successful responses are still enveloped
+	w, _ = get("/ok")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"response":"ok"`)
+}
@@ -0,0 +1,190 @@
+package vertex
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// Default Publisher settings
+const (
+	DefaultPublishRetries = 3
+	DefaultPublishBackoff = 100 * time.Millisecond
+)
+
+// Headers propagating the context of the request that published a message
+const (
+	MessageHeaderRequestId = "vertex-request-id"
+	MessageHeaderPrincipal = "vertex-principal"
+	MessageHeaderRoute     = "vertex-route"
+)
+
+// ErrPublisherClosed is returned when publishing after the server stopped
+var ErrPublisherClosed = errors.New("Publisher is closed")
+
+// ProducerMessage is a message sent to a message broker
+type ProducerMessage struct {
+	Topic   string
+	Key     []byte
+	Value   []byte
+	Headers map[string]string
+}
+
+// Producer is a client of a message broker, e.g. a kafka producer or an AMQP channel. Implementations wrap the
+// broker's client library; the Publisher wrapping them adds retries, metrics, context propagation and lifecycle
+type Producer interface {
+	// Send sends a message, returning once the broker acknowledged it or the context is done
+	Send(ctx context.Context, msg *ProducerMessage) error
+
+	// Close flushes pending messages and closes the connection to the broker
+	Close() error
+}
+
+// Publisher is a lifecycle managed wrapper of a Producer, for handlers publishing events. Publishers are provided
+// for injection by name, so handlers do not manage their own connections:
+//
+//	vertex.NewPublisher("events", kafkaProducer)
+//
+//	type AddUserHandler struct {
+//		Name   string            `schema:"name" required:"true"`
+//		Events *vertex.Publisher `inject:"events"`
+//	}
+//
+//	func (h AddUserHandler) Handle(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+//		...
+//		return user, h.Events.Publish(r, "users", user.Id, UserAdded{user})
+//	}
+//
+// Publishers are closed when the server stops, after it stopped serving requests and its workers stopped, so
+// messages published while shutting down are not lost
+type Publisher struct {
+	// Failed sends are retried up to MaxRetries times, waiting Backoff before the first retry and doubling it for
+	// every retry after it
+	MaxRetries int
+	Backoff    time.Duration
+
+	name     string
+	producer Producer
+	mtx      sync.RWMutex
+	closed   bool
+}
+
+// name => sent/failed/retries/micros => value
+var publisherMetrics = newCounterMap("vertex.publishers")
+
+// NewPublisher wraps a producer with a publisher, provides it for injection by name and closes it on shutdown
+func NewPublisher(name string, producer Producer) *Publisher {
+
+	p := &Publisher{
+		MaxRetries: DefaultPublishRetries,
+		Backoff:    DefaultPublishBackoff,
+		name:       name,
+		producer:   producer,
+	}
+
+	Provide(name, p)
+	CloseOnShutdown("publisher "+name, p)
+	return p
+}
+
+// Send sends a message, retrying failures
+func (p *Publisher) Send(ctx context.Context, msg *ProducerMessage) error {
+
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+	if p.closed {
+		return ErrPublisherClosed
+	}
+
+	st := time.Now()
+	backoff := p.Backoff
+	err := p.producer.Send(ctx, msg)
+	for i := 0; err != nil && i < p.MaxRetries && ctx.Err() == nil; i++ {
+
+		logging.Warning("Could not publish to %s/%s, retrying in %v: %s", p.name, msg.Topic, backoff, err)
+		select {
+		case <-time.After(backoff):
+			publisherMetrics.Add(p.name, "retries", 1)
+			backoff *= 2
+			err = p.producer.Send(ctx, msg)
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	publisherMetrics.Add(p.name, "micros", int64(time.Since(st)/time.Microsecond))
+	if err != nil {
+		publisherMetrics.Add(p.name, "failed", 1)
+		return logging.Errorf("Could not publish to %s/%s: %s", p.name, msg.Topic, err)
+	}
+	publisherMetrics.Add(p.name, "sent", 1)
+	return nil
+}
+
+// Publish encodes a value as JSON and sends it to a topic, in the context of a request: the send is canceled if
+// the request is, and the message headers carry the request id, principal and route for tracing. r may be nil
+// when publishing outside of a request, e.g. from an ingestion consumer
+func (p *Publisher) Publish(r *Request, topic, key string, v interface{}) error {
+
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	msg := &ProducerMessage{
+		Topic:   topic,
+		Key:     []byte(key),
+		Value:   value,
+		Headers: messageHeaders(r),
+	}
+
+	ctx := context.Background()
+	if r != nil {
+		ctx = r.Context()
+	}
+
+	return p.Send(ctx, msg)
+}
+
+// messageHeaders returns the headers propagating the context of the request publishing a message
+func messageHeaders(r *Request) map[string]string {
+
+	ret := map[string]string{}
+	if r != nil {
+		ret[MessageHeaderRequestId] = r.RequestId
+		ret[MessageHeaderRoute] = r.route
+		if id := r.PrincipalID(); id != "" {
+			ret[MessageHeaderPrincipal] = id
+		}
+	}
+	return ret
+}
+
+// Close closes the producer. Sends in progress finish first, and later sends fail with ErrPublisherClosed
+func (p *Publisher) Close() error {
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	return p.producer.Close()
+}
+
+// PublisherStats returns the number of messages a publisher sent and failed to send, the number of retries and
+// the average send latency, including retries
+func PublisherStats(name string) (sent, failed, retries int64, latency time.Duration) {
+
+	sent = publisherMetrics.Value(name, "sent")
+	failed = publisherMetrics.Value(name, "failed")
+	retries = publisherMetrics.Value(name, "retries")
+	if calls := sent + failed; calls > 0 {
+		latency = time.Duration(publisherMetrics.Value(name, "micros")/calls) * time.Microsecond
+	}
+	return
+}
@@ -0,0 +1,80 @@
+package vertex
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockProducer struct {
+	mtx      sync.Mutex
+	failures int
+	sent     []*ProducerMessage
+	closed   bool
+}
+
+func (p *mockProducer) Send(ctx context.Context, msg *ProducerMessage) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if p.failures > 0 {
+		p.failures--
+		return errors.New("broker unavailable")
+	}
+	p.sent = append(p.sent, msg)
+	return nil
+}
+
+func (p *mockProducer) Close() error {
+	p.closed = true
+	return nil
+}
+
+func TestPublisher(t *testing.T) {
+
+	mp := &mockProducer{failures: 2}
+	p := NewPublisher("test.events", mp)
+	p.Backoff = time.Millisecond
+
+	hr, _ := http.NewRequest("POST", "/users", nil)
+	r := NewRequest(hr)
+	r.route = "/api/1.0/users"
+	r.SetPrincipal(&Principal{ID: "alice"})
+
+	// failures are retried, and the message carries the request context
+	assert.NoError(t, p.Publish(r, "users", "u1", map[string]string{"name": "bob"}))
+	if assert.Len(t, mp.sent, 1) {
+		msg := mp.sent[0]
+		assert.Equal(t, "users", msg.Topic)
+		assert.Equal(t, "u1", string(msg.Key))
+		assert.Equal(t, `{"name":"bob"}`, string(msg.Value))
+		assert.Equal(t, r.RequestId, msg.Headers[MessageHeaderRequestId])
+		assert.Equal(t, "alice", msg.Headers[MessageHeaderPrincipal])
+		assert.Equal(t, "/api/1.0/users", msg.Headers[MessageHeaderRoute])
+	}
+
+	mp.failures = 10
+	assert.Error(t, p.Publish(nil, "users", "u2", "x"))
+	mp.failures = 0
+
+	sent, failed, retries, _ := PublisherStats("test.events")
+	assert.Equal(t, int64(1), sent)
+	assert.Equal(t, int64(1), failed)
+	assert.Equal(t, int64(5), retries)
+
+	// publishers are provided for injection
+	var h struct {
+		Events *Publisher `inject:"test.events"`
+	}
+	assert.NoError(t, inject(&h, []injection{{field: 0, name: "test.events"}}))
+	assert.True(t, h.Events == p)
+
+	// and closed when the server stops
+	closeResources()
+	assert.True(t, mp.closed)
+	assert.Equal(t, ErrPublisherClosed, p.Publish(nil, "users", "u3", "x"))
+}
@@ -0,0 +1,166 @@
+package vertex
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// ProfilePath is the admin endpoint capturing profiles scoped to a single route.
+//
+// It takes the full route path, the profile type (cpu, trace, goroutine or heap) and the duration in seconds:
+//
+//	curl -u admin:pass 'localhost:9944/debug/vertex/profile?route=/myapi/1.0/users/:id&type=cpu&seconds=30' > cpu.prof
+//
+// While profiling, requests of the route run with the pprof label vertex_route set to the route path, so samples
+// of the route can be isolated with:
+//
+//	go tool pprof -tagfocus vertex_route=/myapi/1.0/users/:id cpu.prof
+//
+// Traces mark every request of the route as a task named after the route. Heap profiles cannot be scoped by labels,
+// and cover the whole process
+const ProfilePath = "/debug/vertex/profile"
+
+// ProfileLabel is the pprof label carrying the route path of profiled requests
+const ProfileLabel = "vertex_route"
+
+const (
+	defaultProfileSeconds = 30
+	maxProfileSeconds     = 300
+)
+
+// profiling holds the route currently being profiled. Only one profile is captured at a time
+var profiling = struct {
+	sync.RWMutex
+	route  string
+	active bool
+}{}
+
+// profiledRoute returns the route currently being profiled, if any
+func profiledRoute() (string, bool) {
+	profiling.RLock()
+	defer profiling.RUnlock()
+	return profiling.route, profiling.active
+}
+
+// startProfiling marks a route as profiled, and returns false if another profile is already being captured
+func startProfiling(route string) bool {
+	profiling.Lock()
+	defer profiling.Unlock()
+
+	if profiling.active {
+		return false
+	}
+	profiling.route = route
+	profiling.active = true
+	return true
+}
+
+func stopProfiling() {
+	profiling.Lock()
+	defer profiling.Unlock()
+	profiling.route = ""
+	profiling.active = false
+}
+
+// withProfiling runs the handling of a request, labeling it for the profiler if its route is being profiled
+func withProfiling(req *Request, route string, f func()) {
+
+	if r, active := profiledRoute(); !active || r != route {
+		f()
+		return
+	}
+
+	ctx, task := trace.NewTask(req.Context(), route)
+	defer task.End()
+
+	pprof.Do(ctx, pprof.Labels(ProfileLabel, route, "request_id", req.RequestId), func(ctx context.Context) {
+		// expose the labels to handlers starting goroutines of their own
+		req.Request = req.Request.WithContext(ctx)
+		f()
+	})
+}
+
+// profileHandler captures a profile scoped to a route for the requested duration, and writes it to the response
+func profileHandler(w http.ResponseWriter, r *http.Request) {
+
+	route := r.FormValue("route")
+	if route == "" {
+		http.Error(w, "Missing route", http.StatusBadRequest)
+		return
+	}
+
+	seconds := defaultProfileSeconds
+	if s := r.FormValue("seconds"); s != "" {
+		var err error
+		if seconds, err = strconv.Atoi(s); err != nil || seconds <= 0 || seconds > maxProfileSeconds {
+			http.Error(w, fmt.Sprintf("Invalid seconds, must be 1-%d", maxProfileSeconds), http.StatusBadRequest)
+			return
+		}
+	}
+	duration := time.Duration(seconds) * time.Second
+
+	typ := r.FormValue("type")
+	if typ == "" {
+		typ = "cpu"
+	}
+
+	var start func() error
+	var stop func() error
+	switch typ {
+	case "cpu":
+		start = func() error { return pprof.StartCPUProfile(w) }
+		stop = func() error { pprof.StopCPUProfile(); return nil }
+	case "trace":
+		start = func() error { return trace.Start(w) }
+		stop = func() error { trace.Stop(); return nil }
+	case "goroutine":
+		start = func() error { return nil }
+		stop = func() error { return pprof.Lookup("goroutine").WriteTo(w, 0) }
+	case "heap":
+		start = func() error { return nil }
+		stop = func() error {
+			runtime.GC()
+			return pprof.Lookup("heap").WriteTo(w, 0)
+		}
+	default:
+		http.Error(w, "Invalid profile type "+typ, http.StatusBadRequest)
+		return
+	}
+
+	if !startProfiling(route) {
+		http.Error(w, "A profile is already being captured", http.StatusConflict)
+		return
+	}
+	defer stopProfiling()
+
+	// profiles may outlive the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(duration + 10*time.Second))
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.prof"`, typ))
+
+	if err := start(); err != nil {
+		http.Error(w, "Could not start profiling: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	logging.Info("Capturing %s profile of route %s for %v", typ, route, duration)
+	select {
+	case <-time.After(duration):
+	case <-r.Context().Done():
+		logging.Warning("Profiling of route %s cancelled by client", route)
+	}
+
+	if err := stop(); err != nil {
+		logging.Error("Could not write %s profile: %s", typ, err)
+	}
+}
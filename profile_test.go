@@ -0,0 +1,80 @@
+package vertex
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"runtime/pprof"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteProfiling(t *testing.T) {
+
+	labels := make(chan string, 1)
+	a := &API{
+		Name:          "profile",
+		Version:       "1.0",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{
+				Path:    "/hot",
+				Methods: GET,
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					label, _ := pprof.Label(r.Context(), ProfileLabel)
+					labels <- label
+					return "ok", nil
+				}),
+			},
+		},
+	}
+
+	srv := NewServer(":9953")
+	srv.AddAPI(a)
+	route := a.FullPath("/hot")
+
+	call := func() string {
+		req, _ := http.NewRequest("GET", route, nil)
+		srv.Handler().ServeHTTP(httptest.NewRecorder(), req)
+		return <-labels
+	}
+
+	// not profiling - no labels
+	assert.Equal(t, "", call())
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		req, _ := http.NewRequest("GET", ProfilePath+"?type=goroutine&seconds=1&route="+route, nil)
+		w := httptest.NewRecorder()
+		profileHandler(w, req)
+		done <- w
+	}()
+
+	for i := 0; i < 100; i++ {
+		if _, active := profiledRoute(); active {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	assert.Equal(t, route, call())
+
+	// only one profile at a time
+	req, _ := http.NewRequest("GET", ProfilePath+"?type=goroutine&route="+route, nil)
+	w := httptest.NewRecorder()
+	profileHandler(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = <-done
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, w.Body.Bytes())
+
+	assert.Equal(t, "", call())
+
+	req, _ = http.NewRequest("GET", ProfilePath+"?type=foo&route="+route, nil)
+	w = httptest.NewRecorder()
+	profileHandler(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
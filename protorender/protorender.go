@@ -0,0 +1,76 @@
+// Package protorender provides a vertex renderer serializing responses as protocol buffers.
+//
+// Handlers returning a proto.Message are answered with its binary encoding, and anything else - including errors -
+// is rendered as JSON, so a route can move to protobuf without changing how it fails. Routes may declare the
+// message type they return, which is documented in the content types of their spec:
+//
+//	{
+//		Path:     "/user/:id",
+//		Handler:  GetUserHandler{},
+//		Methods:  vertex.GET,
+//		Renderer: protorender.For(&userpb.User{}),
+//	}
+package protorender
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+	"github.com/golang/protobuf/proto"
+
+	"github.com/EverythingMe/vertex"
+)
+
+// ContentType is the content type of protobuf responses
+const ContentType = "application/x-protobuf"
+
+// Renderer renders proto.Message responses as protocol buffers, and any other response as JSON
+type Renderer struct {
+	// The fully qualified name of the message type the route returns, e.g. users.User. Optional, for documentation
+	MessageType string
+}
+
+// For returns a renderer of a route returning messages of the type of msg
+func For(msg proto.Message) Renderer {
+	return Renderer{MessageType: proto.MessageName(msg)}
+}
+
+func (p Renderer) Render(v interface{}, e error, w http.ResponseWriter, r *vertex.Request) error {
+
+	msg, ok := v.(proto.Message)
+	if e != nil || !ok {
+		return vertex.JSONRenderer{}.Render(v, e, w, r)
+	}
+
+	w.Header().Set(vertex.HeaderProcessingTime, fmt.Sprintf("%.03f", time.Since(r.StartTime).Seconds()*1000))
+	w.Header().Set(vertex.HeaderRequestId, r.RequestId)
+
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		logging.Error("Could not encode protobuf response: %s", err)
+		http.Error(w, "Error sending response", http.StatusInternalServerError)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", p.contentType(msg))
+	_, err = w.Write(b)
+	return err
+}
+
+// contentType returns the content type of a message, naming its type in a messageType parameter
+func (p Renderer) contentType(msg proto.Message) string {
+	if name := proto.MessageName(msg); name != "" {
+		return fmt.Sprintf("%s; messageType=%s", ContentType, name)
+	}
+	return ContentType
+}
+
+func (p Renderer) ContentTypes() []string {
+	ct := ContentType
+	if p.MessageType != "" {
+		ct = fmt.Sprintf("%s; messageType=%s", ContentType, p.MessageType)
+	}
+	return append([]string{ct}, vertex.JSONRenderer{}.ContentTypes()...)
+}
@@ -0,0 +1,42 @@
+package protorender
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/EverythingMe/vertex"
+)
+
+func TestRenderer(t *testing.T) {
+
+	hr, _ := http.NewRequest("GET", "/users/1", nil)
+	r := vertex.NewRequest(hr)
+
+	// messages are rendered as protobuf
+	w := httptest.NewRecorder()
+	assert.NoError(t, For(&wrappers.StringValue{}).Render(&wrappers.StringValue{Value: "alice"}, nil, w, r))
+	assert.Equal(t, "application/x-protobuf; messageType=google.protobuf.StringValue", w.Header().Get("Content-Type"))
+	assert.Equal(t, r.RequestId, w.Header().Get(vertex.HeaderRequestId))
+	assert.Equal(t, []byte("\x0a\x05alice"), w.Body.Bytes())
+
+	// anything else falls back to JSON
+	w = httptest.NewRecorder()
+	assert.NoError(t, Renderer{}.Render(map[string]string{"name": "bob"}, nil, w, r))
+	assert.Contains(t, w.Header().Get("Content-Type"), "application/json")
+	assert.Contains(t, w.Body.String(), `"name":"bob"`)
+
+	w = httptest.NewRecorder()
+	assert.NoError(t, Renderer{}.Render(nil, vertex.InvalidParamError("bad id"), w, r))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestContentTypes(t *testing.T) {
+
+	assert.Equal(t, []string{ContentType, "text/json"}, Renderer{}.ContentTypes())
+	assert.Equal(t, []string{"application/x-protobuf; messageType=google.protobuf.StringValue", "text/json"},
+		For(&wrappers.StringValue{}).ContentTypes())
+}
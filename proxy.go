@@ -0,0 +1,166 @@
+package vertex
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// DefaultProxyTimeout is the timeout of upstream requests of proxy routes, if not set in their options
+const DefaultProxyTimeout = 30 * time.Second
+
+// ProxyOptions configure a proxy route. See Proxy
+type ProxyOptions struct {
+	// The URL of the upstream service, e.g. http://legacy:8080/api. Forwarded paths are appended to its path
+	Upstream string
+
+	// The route param with the path forwarded to the upstream, e.g. path for a route of /legacy/*path. If not set,
+	// the full path of the request is forwarded
+	PathParam string
+
+	// Headers set on upstream requests by name. Empty values remove the header, e.g. to not forward the credentials
+	// the API authenticated the request with
+	RequestHeaders map[string]string
+
+	// Headers set on the responses of the upstream by name. Empty values remove the header
+	ResponseHeaders map[string]string
+
+	// Send the Host header of the request to the upstream, rather than the upstream host
+	PreserveHost bool
+
+	// Timeout of upstream requests, their response body included. Defaults to DefaultProxyTimeout
+	Timeout time.Duration
+}
+
+// Proxy returns a handler forwarding the requests of a route to an upstream service, e.g. to gradually migrate a
+// legacy service behind an API:
+//
+//	{
+//		Path:        "/legacy/*path",
+//		Description: "Not migrated yet",
+//		Methods:     vertex.GET | vertex.POST,
+//		Handler: vertex.Proxy(vertex.ProxyOptions{
+//			Upstream:       "http://legacy:8080/api",
+//			PathParam:      "path",
+//			RequestHeaders: map[string]string{"Authorization": ""},
+//		}),
+//	}
+//
+// Like raw routes, proxy routes go through the middleware and security scheme of the API. The upstream gets the
+// request id in the X-Vertex-RequestId header, and the original scheme and host in the X-Forwarded-Proto and
+// X-Forwarded-Host headers. Upstream failures are answered with 502 Bad Gateway, and timeouts with 504 Gateway Timeout
+func Proxy(opts ProxyOptions) RequestHandler {
+
+	target, err := url.Parse(opts.Upstream)
+	if err == nil && (target.Scheme == "" || target.Host == "") {
+		err = fmt.Errorf("missing scheme or host")
+	}
+	if err != nil {
+		logging.Error("Invalid proxy upstream %s: %s", opts.Upstream, err)
+		return rawHandler{func(w http.ResponseWriter, r *Request) {
+			http.Error(w, "Invalid upstream", http.StatusBadGateway)
+		}}
+	}
+
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultProxyTimeout
+	}
+
+	return rawHandler{func(w http.ResponseWriter, r *Request) {
+
+		forwarded := r.URL.Path
+		if opts.PathParam != "" {
+			forwarded = r.params.ByName(opts.PathParam)
+		}
+		upstreamPath, ok := joinProxyPath(target.Path, forwarded)
+		if !ok {
+			logging.Warning("Rejected proxying %s outside of upstream path %s", forwarded, target.Path)
+			http.Error(w, "Invalid path", http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		proxy := &httputil.ReverseProxy{
+			Director: func(req *http.Request) {
+				req.URL.Scheme = target.Scheme
+				req.URL.Host = target.Host
+				req.URL.Path = upstreamPath
+				req.URL.RawPath = ""
+				if target.RawQuery != "" {
+					if req.URL.RawQuery == "" {
+						req.URL.RawQuery = target.RawQuery
+					} else {
+						req.URL.RawQuery = target.RawQuery + "&" + req.URL.RawQuery
+					}
+				}
+				if !opts.PreserveHost {
+					req.Host = target.Host
+				}
+
+				proto := "http"
+				if r.Secure {
+					proto = "https"
+				}
+				req.Header.Set("X-Forwarded-Proto", proto)
+				req.Header.Set("X-Forwarded-Host", r.Host)
+				req.Header.Set(HeaderRequestId, r.RequestId)
+				req.Header.Set(HeaderXRequestId, r.RequestId)
+				setHeaders(req.Header, opts.RequestHeaders)
+			},
+			ModifyResponse: func(resp *http.Response) error {
+				setHeaders(resp.Header, opts.ResponseHeaders)
+				return nil
+			},
+			ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+				logging.Error("Proxying %s to %s failed: %s", r.route, opts.Upstream, err)
+				recordRequestLog(r.RequestId, fmt.Sprintf("Upstream failed: %s", err))
+				if ctx.Err() == context.DeadlineExceeded {
+					http.Error(w, "Upstream timed out", http.StatusGatewayTimeout)
+					return
+				}
+				http.Error(w, "Upstream failed", http.StatusBadGateway)
+			},
+		}
+		proxy.ServeHTTP(w, r.Request.WithContext(ctx))
+	}}
+}
+
+// joinProxyPath appends a forwarded path to the path of the upstream. It returns false if the cleaned path escapes
+// the path of the upstream, e.g. with ../
+func joinProxyPath(base, forwarded string) (string, bool) {
+	if forwarded == "" {
+		return base, true
+	}
+
+	joined := path.Clean("/" + strings.TrimSuffix(base, "/") + "/" + forwarded)
+	if strings.HasSuffix(forwarded, "/") && joined != "/" {
+		joined += "/"
+	}
+
+	root := path.Clean("/" + base)
+	if joined != root && !strings.HasPrefix(joined, strings.TrimSuffix(root, "/")+"/") {
+		return "", false
+	}
+	return joined, true
+}
+
+// setHeaders sets headers by name, removing those with empty values
+func setHeaders(h http.Header, headers map[string]string) {
+	for k, v := range headers {
+		if v == "" {
+			h.Del(k)
+		} else {
+			h.Set(k, v)
+		}
+	}
+}
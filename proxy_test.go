@@ -0,0 +1,107 @@
+package vertex
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyRoutes(t *testing.T) {
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Server", "legacy")
+		w.Header().Set("X-Auth", r.Header.Get("Authorization"))
+		w.Header().Set("X-Upstream-Request", r.Header.Get(HeaderRequestId))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(r.Method + " " + r.URL.Path + "?" + r.URL.RawQuery + " " + string(body)))
+	}))
+	defer upstream.Close()
+
+	a := &API{
+		Name:          "proxy",
+		Version:       "1.0",
+		Root:          "/proxy",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{
+				Path:        "/legacy/*path",
+				Description: "Legacy",
+				Methods:     GET | POST,
+				Handler: Proxy(ProxyOptions{
+					Upstream:        upstream.URL + "/api",
+					PathParam:       "path",
+					RequestHeaders:  map[string]string{"Authorization": "", "X-Via": "vertex"},
+					ResponseHeaders: map[string]string{"Server": ""},
+					Timeout:         50 * time.Millisecond,
+				}),
+				Security: hookTokenScheme{},
+			},
+			{Path: "/down", Description: "Down", Methods: GET, Handler: Proxy(ProxyOptions{Upstream: "http://127.0.0.1:1"})},
+			{Path: "/invalid", Description: "Invalid", Methods: GET, Handler: Proxy(ProxyOptions{Upstream: "legacy"})},
+		},
+	}
+	srv := NewServer(":9990")
+	srv.AddAPI(a)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		hr, _ := http.NewRequest(method, a.FullPath(path), strings.NewReader(body))
+		hr.Header.Set("X-Hook-Token", "secret")
+		hr.Header.Set("Authorization", "Bearer s3cr3t")
+		hr.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, hr)
+		return w
+	}
+
+	w := do("POST", "/legacy/users/12?full=true", "a=1")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "POST /api/users/12?full=true a=1", w.Body.String())
+	assert.Empty(t, w.Header().Get("X-Auth"))
+	assert.Empty(t, w.Header().Get("Server"))
+	assert.NotEmpty(t, w.Header().Get("X-Upstream-Request"))
+
+	assert.Equal(t, http.StatusGatewayTimeout, do("GET", "/legacy/slow", "").Code)
+	assert.Equal(t, http.StatusBadGateway, do("GET", "/down", "").Code)
+	assert.Equal(t, http.StatusBadGateway, do("GET", "/invalid", "").Code)
+
+	// forwarded paths cannot escape the path of the upstream
+	assert.Equal(t, http.StatusBadRequest, do("GET", "/legacy/..%2F..%2Fadmin", "").Code)
+
+	// the security scheme of the route still applies
+	hr, _ := http.NewRequest("GET", a.FullPath("/legacy/users"), nil)
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, hr)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestJoinProxyPath(t *testing.T) {
+
+	for _, c := range []struct {
+		base, forwarded, joined string
+		ok                      bool
+	}{
+		{"/api", "users/12", "/api/users/12", true},
+		{"/api/", "/users/", "/api/users/", true},
+		{"/api", "", "/api", true},
+		{"", "users", "/users", true},
+		{"/api", "a/../b", "/api/b", true},
+		{"/api", "..", "", false},
+		{"/api", "../admin", "", false},
+		{"/api", "a/../../admin", "", false},
+		{"/api", "/../apix", "", false},
+	} {
+		joined, ok := joinProxyPath(c.base, c.forwarded)
+		assert.Equal(t, c.ok, ok, c.forwarded)
+		assert.Equal(t, c.joined, joined, c.forwarded)
+	}
+}
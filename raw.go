@@ -0,0 +1,55 @@
+package vertex
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// rawHandler serves a route with a plain http handler
+type rawHandler struct {
+	h func(w http.ResponseWriter, r *Request)
+}
+
+// Raw mounts a plain http.Handler as the handler of a route, e.g. to integrate third party webhook receivers:
+//
+//	{
+//		Path:        "/webhooks/github",
+//		Description: "GitHub webhook receiver",
+//		Methods:     vertex.POST,
+//		Handler:     vertex.Raw(githubHook),
+//		Security:    webhookSignature{},
+//	}
+//
+// Raw routes still go through the middleware and security scheme of the API, but their params are not parsed or
+// validated, and their responses are not rendered - the handler writes them itself. The handler reads the body of the
+// request from the start, even if it is a form that was already parsed
+func Raw(h http.Handler) RequestHandler {
+	return rawHandler{func(w http.ResponseWriter, r *Request) {
+		h.ServeHTTP(w, r.Request)
+	}}
+}
+
+// RawRouter mounts a plain httprouter handler as the handler of a route, getting the path params of the route. See Raw
+func RawRouter(h httprouter.Handle) RequestHandler {
+	return rawHandler{func(w http.ResponseWriter, r *Request) {
+		h(w, r.Request, r.params)
+	}}
+}
+
+func (h rawHandler) Handle(w http.ResponseWriter, r *Request) (interface{}, error) {
+	// form bodies were buffered before they were parsed
+	if r.rawBody != nil {
+		r.Body = ioutil.NopCloser(bytes.NewReader(r.rawBody))
+	}
+	h.h(w, r)
+	return nil, Hijacked
+}
+
+// isRaw tells if a route is served by a plain http handler
+func (r *Route) isRaw() bool {
+	_, ok := r.Handler.(rawHandler)
+	return ok
+}
@@ -0,0 +1,79 @@
+package vertex
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+)
+
+type hookTokenScheme struct{}
+
+func (hookTokenScheme) Validate(r *Request) error {
+	if r.Header.Get("X-Hook-Token") != "secret" {
+		return UnauthorizedError("bad token")
+	}
+	return nil
+}
+
+func TestRawRoutes(t *testing.T) {
+
+	hook := RawRouter(func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(p.ByName("source") + ":" + string(body)))
+	})
+	ping := Raw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	}))
+
+	a := &API{
+		Name:          "raw",
+		Version:       "1.0",
+		Root:          "/raw",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Middleware: []Middleware{
+			MiddlewareFunc(func(w http.ResponseWriter, r *Request, next HandlerFunc) (interface{}, error) {
+				w.Header().Set("X-Middleware", "yes")
+				return next(w, r)
+			}),
+		},
+		Routes: Routes{
+			{Path: "/hooks/:source", Description: "Webhooks", Methods: POST, Handler: hook, Security: hookTokenScheme{}},
+			{Path: "/ping", Description: "Ping", Methods: GET, Handler: ping},
+		},
+	}
+	srv := NewServer(":9986")
+	srv.AddAPI(a)
+
+	post := func(token string) *httptest.ResponseRecorder {
+		hr, _ := http.NewRequest("POST", a.FullPath("/hooks/github"), strings.NewReader("a=1&b=2"))
+		hr.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		hr.Header.Set("X-Hook-Token", token)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, hr)
+		return w
+	}
+
+	// the handler reads the body even though it is a form
+	w := post("secret")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "github:a=1&b=2", w.Body.String())
+	assert.Equal(t, "yes", w.Header().Get("X-Middleware"))
+
+	w = post("wrong")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	hr, _ := http.NewRequest("GET", a.FullPath("/ping"), nil)
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, hr)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "pong", w.Body.String())
+	assert.Equal(t, "yes", w.Header().Get("X-Middleware"))
+}
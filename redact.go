@@ -0,0 +1,190 @@
+package vertex
+
+import "reflect"
+
+// PIIMask replaces the value of redacted string fields
+const PIIMask = "***"
+
+// redacting stops descending into values deeper than this, to avoid looping on cyclic structures
+const maxRedactDepth = 32
+
+// RedactPII returns a copy of v where all the struct fields tagged with `pii:"true"` are masked. String fields are
+// replaced with PIIMask, and other fields are zeroed, so they are stripped if they are also tagged as omitempty.
+//
+// The second return value is true if v contained any PII fields. If it did not, v itself is returned. The original
+// value is never modified.
+func RedactPII(v interface{}) (interface{}, bool) {
+
+	if v == nil {
+		return nil, false
+	}
+
+	ret, changed := redactValue(reflect.ValueOf(v), 0)
+	if !changed {
+		return v, false
+	}
+	return ret.Interface(), true
+}
+
+// maskValue returns the masked value for a PII field
+func maskValue(v reflect.Value) reflect.Value {
+	if v.Kind() == reflect.String {
+		return reflect.ValueOf(PIIMask).Convert(v.Type())
+	}
+	return reflect.Zero(v.Type())
+}
+
+// redactValue masks PII fields inside v, copying values on the way only if something inside them was masked
+func redactValue(v reflect.Value, depth int) (reflect.Value, bool) {
+
+	if depth > maxRedactDepth {
+		return v, false
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v, false
+		}
+		inner, changed := redactValue(v.Elem(), depth+1)
+		if !changed {
+			return v, false
+		}
+		ret := reflect.New(v.Elem().Type())
+		ret.Elem().Set(inner)
+		return ret, true
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return v, false
+		}
+		inner, changed := redactValue(v.Elem(), depth+1)
+		if !changed {
+			return v, false
+		}
+		ret := reflect.New(v.Type()).Elem()
+		ret.Set(inner)
+		return ret, true
+
+	case reflect.Struct:
+		var ret reflect.Value
+		target := func() reflect.Value {
+			if !ret.IsValid() {
+				ret = reflect.New(v.Type()).Elem()
+				ret.Set(v)
+			}
+			return ret
+		}
+
+		if !redactFields(v, target, depth) {
+			return v, false
+		}
+		return ret, true
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return v, false
+		}
+
+		var ret reflect.Value
+		changed := false
+
+		for i := 0; i < v.Len(); i++ {
+
+			nv, c := redactValue(v.Index(i), depth+1)
+			if !c {
+				continue
+			}
+
+			if !changed {
+				if v.Kind() == reflect.Slice {
+					ret = reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+					reflect.Copy(ret, v)
+				} else {
+					ret = reflect.New(v.Type()).Elem()
+					ret.Set(v)
+				}
+				changed = true
+			}
+			ret.Index(i).Set(nv)
+		}
+
+		if !changed {
+			return v, false
+		}
+		return ret, true
+
+	case reflect.Map:
+		if v.IsNil() {
+			return v, false
+		}
+
+		var ret reflect.Value
+		changed := false
+
+		for _, k := range v.MapKeys() {
+
+			nv, c := redactValue(v.MapIndex(k), depth+1)
+			if !c {
+				continue
+			}
+
+			if !changed {
+				ret = reflect.MakeMapWithSize(v.Type(), v.Len())
+				for _, k2 := range v.MapKeys() {
+					ret.SetMapIndex(k2, v.MapIndex(k2))
+				}
+				changed = true
+			}
+			ret.SetMapIndex(k, nv)
+		}
+
+		if !changed {
+			return v, false
+		}
+		return ret, true
+	}
+
+	return v, false
+}
+
+// redactFields masks the PII fields of the struct v into the addressable copy returned by target, which is only
+// called once something needs to be masked.
+//
+// The exported fields of embedded structs of unexported types are promoted when serializing, so they are redacted as
+// well. Such an embedded struct cannot be set as a whole, so its fields are set one by one through the embedded field
+// of the parent's copy
+func redactFields(v reflect.Value, target func() reflect.Value, depth int) bool {
+
+	changed := false
+
+	T := v.Type()
+	for i := 0; i < T.NumField(); i++ {
+
+		field := T.Field(i)
+		// unexported fields are never serialized, except for the promoted fields of embedded structs
+		if field.PkgPath != "" {
+			if field.Anonymous && field.Type.Kind() == reflect.Struct && depth < maxRedactDepth {
+				if redactFields(v.Field(i), func() reflect.Value { return target().Field(i) }, depth+1) {
+					changed = true
+				}
+			}
+			continue
+		}
+
+		var nv reflect.Value
+		if field.Tag.Get("pii") == "true" {
+			nv = maskValue(v.Field(i))
+		} else {
+			var c bool
+			if nv, c = redactValue(v.Field(i), depth+1); !c {
+				continue
+			}
+		}
+
+		target().Field(i).Set(nv)
+		changed = true
+	}
+
+	return changed
+}
@@ -0,0 +1,90 @@
+package vertex
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type piiAddress struct {
+	City   string
+	Street string `pii:"true"`
+}
+
+type piiUser struct {
+	Id        int
+	Name      string `pii:"true"`
+	Age       int    `pii:"true"`
+	Addresses []piiAddress
+	Extra     map[string]interface{}
+}
+
+type piiContact struct {
+	Email string `pii:"true"`
+	Phone string
+}
+
+type piiMember struct {
+	Name string
+	piiContact
+}
+
+func TestRedactPII(t *testing.T) {
+
+	u := &piiUser{
+		Id:        1,
+		Name:      "Alice",
+		Age:       30,
+		Addresses: []piiAddress{{City: "Tel Aviv", Street: "Rothschild 1"}},
+		Extra:     map[string]interface{}{"home": piiAddress{City: "Haifa", Street: "Herzl 2"}},
+	}
+
+	v, found := RedactPII(u)
+	assert.True(t, found)
+
+	r := v.(*piiUser)
+	assert.Equal(t, 1, r.Id)
+	assert.Equal(t, PIIMask, r.Name)
+	assert.Equal(t, 0, r.Age)
+	assert.Equal(t, "Tel Aviv", r.Addresses[0].City)
+	assert.Equal(t, PIIMask, r.Addresses[0].Street)
+	assert.Equal(t, PIIMask, r.Extra["home"].(piiAddress).Street)
+
+	// the original is untouched
+	assert.Equal(t, "Alice", u.Name)
+	assert.Equal(t, "Rothschild 1", u.Addresses[0].Street)
+	assert.Equal(t, "Herzl 2", u.Extra["home"].(piiAddress).Street)
+
+	plain := map[string]string{"foo": "bar"}
+	v, found = RedactPII(plain)
+	assert.False(t, found)
+	assert.Equal(t, plain, v)
+
+	v, found = RedactPII(nil)
+	assert.False(t, found)
+	assert.Nil(t, v)
+}
+
+func TestRedactPIIEmbedded(t *testing.T) {
+
+	// the fields of unexported embedded structs are promoted when serializing, so they are redacted too
+	m := piiMember{Name: "Bob", piiContact: piiContact{Email: "bob@example.com", Phone: "555"}}
+
+	v, found := RedactPII(m)
+	assert.True(t, found)
+
+	r := v.(piiMember)
+	assert.Equal(t, "Bob", r.Name)
+	assert.Equal(t, PIIMask, r.Email)
+	assert.Equal(t, "555", r.Phone)
+	assert.Equal(t, "bob@example.com", m.Email)
+
+	b, err := json.Marshal(v)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(b), "bob@example.com")
+
+	v, found = RedactPII(&[]piiMember{m})
+	assert.True(t, found)
+	assert.Equal(t, PIIMask, (*v.(*[]piiMember))[0].Email)
+}
@@ -2,10 +2,13 @@ package vertex
 
 import (
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"html/template"
+	"math"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/dvirsky/go-pylog/logging"
@@ -69,6 +72,123 @@ func writeError(w http.ResponseWriter, message string) {
 
 }
 
+// renderError writes the response for a failed request
+func renderError(w http.ResponseWriter, r *Request, e error) {
+
+	setErrorHeaders(w, e)
+	status, message := requestError(r, e)
+	if r != nil && r.api != nil && r.api.Problems != nil {
+		r.api.Problems.write(w, r, e, status, message)
+		return
+	}
+	if violations := Violations(e); len(violations) > 0 {
+		writeViolations(w, status, message, violations)
+		return
+	}
+	http.Error(w, message, status)
+}
+
+// validationErrorBody is the body of validation errors, listing the violations of all the invalid params
+type validationErrorBody struct {
+	Message    string      `json:"message"`
+	Violations []Violation `json:"violations"`
+}
+
+// writeViolations writes the body of a validation error as JSON, whatever the renderer, so clients can tell which
+// params to fix
+func writeViolations(w http.ResponseWriter, status int, message string, violations []Violation) {
+
+	buf, err := json.Marshal(validationErrorBody{Message: message, Violations: violations})
+	if err != nil {
+		http.Error(w, message, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(buf)
+}
+
+// setErrorHeaders sets the headers describing an error on its response
+func setErrorHeaders(w http.ResponseWriter, e error) {
+
+	if code := PublicErrorCode(e); code != "" {
+		w.Header().Set(HeaderErrorCode, code)
+	}
+
+	if ie, ok := e.(*internalError); ok && ie.Challenge != "" {
+		w.Header().Set("WWW-Authenticate", ie.Challenge)
+	}
+
+	if ie, ok := e.(*internalError); ok && ie.Upgrade != nil {
+		w.Header().Set(HeaderMinClientVersion, ie.Upgrade.MinVersion)
+		if ie.Upgrade.URL != "" {
+			w.Header().Set(HeaderUpgradeURL, ie.Upgrade.URL)
+		}
+	}
+
+	if retry := RetryAfter(e); retry > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(retry)))
+	}
+}
+
+// retryAfterSeconds rounds a retry hint up to whole seconds, so clients never retry too early
+func retryAfterSeconds(retry time.Duration) int {
+	return int(math.Ceil(retry.Seconds()))
+}
+
+// statusWriter renders a response with a non default http status. The status is written right before the body,
+// so renderers can still set headers
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.wroteHeader = true
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(w.status)
+	}
+	// the rendered body of responses that must not have one is dropped
+	if !bodyAllowed(w.status) {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// envelopeResponse wraps a response or an error in the envelope of the API, if it has one, returning the writer to
+// render it with. It returns false if the error was rendered without an envelope and there is nothing left to render
+func envelopeResponse(w http.ResponseWriter, r *Request, response interface{}, e error) (http.ResponseWriter, interface{}, bool) {
+
+	var envelope EnvelopeBuilder
+	if r.api != nil {
+		envelope = r.api.Envelope
+	}
+
+	// Dump Error if the request failed
+	if e != nil {
+		// problem details take precedence over the envelope for errors
+		if envelope == nil || r.api.Problems != nil {
+			renderError(w, r, e)
+			return w, nil, false
+		}
+		setErrorHeaders(w, e)
+		status, message := requestError(r, e)
+		return &statusWriter{ResponseWriter: w, status: status}, envelope.Failure(r, e, status, message), true
+	} else if envelope != nil {
+		response = envelope.Success(r, response)
+	}
+
+	return w, response, true
+}
+
 //serialize a response object to JSON
 func writeResponse(w http.ResponseWriter, r *Request, response interface{}, e error) (err error) {
 
@@ -76,10 +196,8 @@ func writeResponse(w http.ResponseWriter, r *Request, response interface{}, e er
 	w.Header().Set(HeaderProcessingTime, fmt.Sprintf("%.03f", time.Since(r.StartTime).Seconds()*1000))
 	w.Header().Set(HeaderRequestId, r.RequestId)
 
-	// Dump Error if the request failed
-	if e != nil {
-		code, message := httpError(e)
-		http.Error(w, message, code)
+	w, response, ok := envelopeResponse(w, r, response, e)
+	if !ok {
 		return
 	}
 
@@ -110,6 +228,39 @@ func writeResponse(w http.ResponseWriter, r *Request, response interface{}, e er
 	return
 }
 
+// XMLRenderer renders a response as XML with encoding/xml. Responses must be structs or slices, since maps cannot be
+// encoded as XML
+type XMLRenderer struct{}
+
+func (XMLRenderer) Render(v interface{}, e error, w http.ResponseWriter, r *Request) error {
+
+	w.Header().Set(HeaderProcessingTime, fmt.Sprintf("%.03f", time.Since(r.StartTime).Seconds()*1000))
+	w.Header().Set(HeaderRequestId, r.RequestId)
+
+	if e != nil {
+		renderError(w, r, e)
+		return nil
+	}
+
+	buf, err := xml.Marshal(v)
+	if err != nil {
+		logging.Error("Could not encode xml response: %s", err)
+		writeError(w, "Error sending response")
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	if _, err = w.Write([]byte(xml.Header)); err != nil {
+		return err
+	}
+	_, err = w.Write(buf)
+	return err
+}
+
+func (XMLRenderer) ContentTypes() []string {
+	return []string{"application/xml"}
+}
+
 type HTMLRenderer struct {
 	template *template.Template
 }
@@ -157,8 +308,7 @@ func (h *HTMLRenderer) Render(v interface{}, e error, w http.ResponseWriter, r *
 
 	// Dump Error if the request failed
 	if e != nil {
-		code, message := httpError(e)
-		http.Error(w, message, code)
+		renderError(w, r, e)
 		return nil
 	}
 
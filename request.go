@@ -1,7 +1,12 @@
 package vertex
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
@@ -10,16 +15,23 @@ import (
 	"time"
 
 	"github.com/dvirsky/go-pylog/logging"
+	"github.com/julienschmidt/httprouter"
 
 	"code.google.com/p/go-uuid/uuid"
 	"golang.org/x/text/language"
 )
 
-// Request wraps the standard http request object with higher level contextual data
+// Request wraps the standard http request object with higher level contextual data.
+//
+// The context of the request, returned by Context(), is canceled when the client disconnects and expires at the
+// request's deadline, so handlers pass it to downstream calls to have them canceled with the request
 type Request struct {
 	*http.Request
 	StartTime time.Time
-	Deadline  time.Time
+
+	// The deadline of the request, set with SetDeadline e.g. by the timeout middleware. Zero if it has none
+	Deadline time.Time
+
 	Locale    string
 	UserAgent string
 	RemoteIP  string
@@ -29,12 +41,25 @@ type Request struct {
 	Secure    bool
 
 	attributes map[string]interface{}
+	principal  *Principal
+	client     *Client
+	rawBody    []byte
+	route      string
+	api        *API
+	params     httprouter.Params
+	inflight   *inflightRequest
+	deferred   []func(ctx context.Context)
 }
 
 func (r *Request) String() string {
 	return fmt.Sprintf("Request/%s", r.RequestId)
 }
 
+// Route returns the full path pattern of the route handling the request, e.g. /myapi/1.0/users/:id
+func (r *Request) Route() string {
+	return r.route
+}
+
 func (r *Request) SetAttribute(key string, val interface{}) {
 	r.attributes[key] = val
 }
@@ -122,6 +147,39 @@ func (r *Request) parseLocation() {
 
 }
 
+// maxRawBody is the largest body RawBody reads, the same as the limit of http.Request.ParseForm
+const maxRawBody = 10 << 20
+
+// RawBody returns the raw body of the request, e.g. to verify its signature. The body is buffered, so it can still
+// be read or parsed after calling RawBody. The raw body of multipart requests is not available, since their form is
+// parsed when the request is created
+func (r *Request) RawBody() ([]byte, error) {
+
+	if r.rawBody != nil {
+		return r.rawBody, nil
+	}
+	if r.MultipartForm != nil {
+		return nil, errors.New("The raw body of multipart requests is not available")
+	}
+	if r.Body == nil {
+		r.rawBody = []byte{}
+		return r.rawBody, nil
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxRawBody+1))
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxRawBody {
+		return nil, InvalidRequestError("Request body too large")
+	}
+
+	r.rawBody = data
+	r.Body = ioutil.NopCloser(bytes.NewReader(data))
+	return data, nil
+}
+
 // Detect if the request is secure or not, based on either TLS info or http headers/url
 func (r *Request) parseSecure() {
 
@@ -149,6 +207,83 @@ func (r *Request) parseSecure() {
 	}
 }
 
+type requestKey struct{}
+
+// requestSlot holds the request in its context. The context is created before the request, so the request is
+// set in the slot after it
+type requestSlot struct {
+	req *Request
+}
+
+// withRequestSlot returns a context holding the request created for it, see RequestFromContext
+func withRequestSlot(ctx context.Context) (context.Context, *requestSlot) {
+	slot := &requestSlot{}
+	return context.WithValue(ctx, requestKey{}, slot), slot
+}
+
+// RequestFromContext returns the request being handled in a context, e.g. to tag database queries or outgoing calls
+// made with the context of a request. It returns nil for contexts of anything but requests
+func RequestFromContext(ctx context.Context) *Request {
+	if slot, ok := ctx.Value(requestKey{}).(*requestSlot); ok {
+		return slot.req
+	}
+	return nil
+}
+
+// SetDeadline bounds the request's context by a deadline, so downstream calls made with it are canceled once it
+// passes. Deadlines only shorten the request, a deadline after the current one is ignored. The returned func
+// releases the resources of the deadline, and should be deferred by the caller
+func (r *Request) SetDeadline(deadline time.Time) context.CancelFunc {
+
+	if !r.Deadline.IsZero() && !deadline.Before(r.Deadline) {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithDeadline(r.Context(), deadline)
+	r.Request = r.Request.WithContext(ctx)
+	r.Deadline = deadline
+	return cancel
+}
+
+// WithValue stores a request scoped value in the request's context, so it reaches code taking the context rather
+// than the request, e.g. database drivers. Keys should be of unexported types, as with context.WithValue
+func (r *Request) WithValue(key, val interface{}) {
+	r.Request = r.Request.WithContext(context.WithValue(r.Context(), key, val))
+}
+
+// Value returns a request scoped value stored with WithValue, or nil if it is not set
+func (r *Request) Value(key interface{}) interface{} {
+	return r.Context().Value(key)
+}
+
+// requestId returns the id of a request, sent by the client or a proxy in the HeaderXRequestId or HeaderRequestId
+// headers, so requests can be traced across services. Requests without a valid id get a new one
+func requestId(r *http.Request) string {
+
+	for _, h := range []string{HeaderXRequestId, HeaderRequestId} {
+		if id := r.Header.Get(h); validRequestId(id) {
+			return id
+		}
+	}
+	return uuid.New()
+}
+
+// validRequestId checks a request id sent by a client is safe to log and echo in headers
+func validRequestId(id string) bool {
+
+	if id == "" || len(id) > MaxRequestIdLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', strings.ContainsRune("-_.:+=/", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
 // NewRequest wraps a new http request with a vertex request
 func NewRequest(r *http.Request) *Request {
 	req := &Request{
@@ -156,15 +291,26 @@ func NewRequest(r *http.Request) *Request {
 		StartTime:  time.Now(),
 		Locale:     DefaultLocale,
 		UserAgent:  r.UserAgent(),
-		RequestId:  uuid.New(),
-		Callback:   r.FormValue(CallbackParam),
+		RequestId:  requestId(r),
 		attributes: make(map[string]interface{}),
 	}
 
+	// form bodies are consumed when the form is parsed, so they are buffered first
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		if _, err := req.RawBody(); err != nil {
+			logging.Warning("Could not read request body: %s", err)
+		}
+	}
+	req.Callback = r.FormValue(CallbackParam)
+
 	req.parseLocale()
 	req.parseAddr()
 	req.parseLocation()
 	req.parseSecure()
 
+	if deadline, ok := r.Context().Deadline(); ok {
+		req.Deadline = deadline
+	}
+
 	return req
 }
@@ -0,0 +1,138 @@
+package vertex
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ResourceCheckTimeout is how long a health check may take before its resource is considered unhealthy
+const ResourceCheckTimeout = 2 * time.Second
+
+// HealthCheck checks whether a resource the server depends on is healthy, returning an error if it is not.
+// Health checks run on every readiness probe, so they should be fast. Checks taking longer than
+// ResourceCheckTimeout fail, and their context is canceled so they can give up
+type HealthCheck func(ctx context.Context) error
+
+var resources = struct {
+	sync.RWMutex
+	checks map[string]HealthCheck
+}{
+	checks: map[string]HealthCheck{},
+}
+
+// RegisterResource registers a named resource the server depends on (a database, cache, queue, etc).
+//
+// The resource's health check feeds the readiness endpoint, so the server is taken out of rotation while the
+// resource is unhealthy. The check may be nil if the resource is only used for latency reporting.
+// Latencies of calls to the resource are reported with Request.ObserveResource or Request.TimeResource
+func RegisterResource(name string, check HealthCheck) {
+	resources.Lock()
+	defer resources.Unlock()
+	resources.checks[name] = check
+}
+
+// checkResources runs the health checks of all the registered resources, and returns the errors of the failing
+// ones by resource name
+func checkResources() map[string]error {
+	return checkResourcesWithin(ResourceCheckTimeout)
+}
+
+// checkResourcesWithin runs the health checks concurrently, failing the checks that do not return within a timeout.
+// The context of the checks is canceled once the timeout passes, so checks that time out do not pile up
+func checkResourcesWithin(timeout time.Duration) map[string]error {
+
+	resources.RLock()
+	checks := make(map[string]HealthCheck, len(resources.checks))
+	for name, check := range resources.checks {
+		if check != nil {
+			checks[name] = check
+		}
+	}
+	resources.RUnlock()
+
+	type result struct {
+		name string
+		err  error
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	results := make(chan result, len(checks))
+	for name, check := range checks {
+		go func(name string, check HealthCheck) {
+			results <- result{name, check(ctx)}
+		}(name, check)
+	}
+
+	ret := map[string]error{}
+	for pending := len(checks); pending > 0; pending-- {
+		select {
+		case res := <-results:
+			delete(checks, res.name)
+			if res.err != nil {
+				ret[res.name] = res.err
+			}
+		case <-ctx.Done():
+			for name := range checks {
+				ret[name] = fmt.Errorf("health check timed out after %v", timeout)
+			}
+			return ret
+		}
+	}
+	return ret
+}
+
+// formatResourceErrors formats the names of failing resources for the readiness endpoint, sorted by name. The errors
+// themselves are only logged, since the endpoint is not authenticated
+func formatResourceErrors(errs map[string]error) string {
+
+	ret := make([]string, 0, len(errs))
+	for name := range errs {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return strings.Join(ret, "\n")
+}
+
+// route => resource.calls/resource.micros => value
+var resourceLatency = newCounterMap("vertex.resource_latency")
+
+// ObserveResource reports the latency of a call to a named resource made while handling the request. Latencies
+// are broken down by route in the "vertex.resource_latency" metrics
+func (r *Request) ObserveResource(name string, latency time.Duration) {
+
+	route := r.route
+	if route == "" {
+		route = r.URL.Path
+	}
+
+	resourceLatency.Add(route, name+".calls", 1)
+	resourceLatency.Add(route, name+".micros", int64(latency/time.Microsecond))
+}
+
+// TimeResource starts timing a call to a named resource, and returns a function that reports its latency when
+// called:
+//
+//	defer r.TimeResource("db")()
+func (r *Request) TimeResource(name string) func() {
+	st := time.Now()
+	return func() {
+		r.ObserveResource(name, time.Since(st))
+	}
+}
+
+// ResourceLatency returns the number of calls made to a resource by a route and their average latency
+func ResourceLatency(route, name string) (int64, time.Duration) {
+
+	calls := resourceLatency.Value(route, name+".calls")
+	if calls == 0 {
+		return 0, 0
+	}
+
+	micros := resourceLatency.Value(route, name+".micros")
+	return calls, time.Duration(micros/calls) * time.Microsecond
+}
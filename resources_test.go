@@ -0,0 +1,116 @@
+package vertex
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResourceHealth(t *testing.T) {
+
+	var dbErr error
+	RegisterResource("test_db", func(ctx context.Context) error { return dbErr })
+	RegisterResource("test_cache", nil)
+	defer func() {
+		resources.Lock()
+		delete(resources.checks, "test_db")
+		delete(resources.checks, "test_cache")
+		resources.Unlock()
+	}()
+
+	srv := NewServer(":9951")
+	srv.registerHealthChecks()
+	srv.setReady(true)
+
+	check := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", ReadinessPath, nil)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, check().Code)
+
+	dbErr = errors.New("connection refused")
+	w := check()
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.True(t, strings.Contains(w.Body.String(), "test_db"))
+	assert.False(t, strings.Contains(w.Body.String(), "connection refused"))
+
+	dbErr = nil
+	assert.Equal(t, http.StatusOK, check().Code)
+}
+
+func TestResourceCheckTimeout(t *testing.T) {
+
+	gaveUp := make(chan struct{})
+	RegisterResource("test_hung", func(ctx context.Context) error {
+		<-ctx.Done()
+		close(gaveUp)
+		return ctx.Err()
+	})
+	RegisterResource("test_ok", func(ctx context.Context) error { return nil })
+	defer func() {
+		resources.Lock()
+		delete(resources.checks, "test_hung")
+		delete(resources.checks, "test_ok")
+		resources.Unlock()
+	}()
+
+	st := time.Now()
+	errs := checkResourcesWithin(20 * time.Millisecond)
+	assert.True(t, time.Since(st) < time.Second)
+	assert.Error(t, errs["test_hung"])
+	assert.NoError(t, errs["test_ok"])
+
+	// the check that timed out was told to give up
+	select {
+	case <-gaveUp:
+	case <-time.After(time.Second):
+		t.Error("Timed out check was not canceled")
+	}
+}
+
+func TestResourceLatency(t *testing.T) {
+
+	a := &API{
+		Name:          "resources",
+		Version:       "1.0",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{
+				Path:    "/slow",
+				Methods: GET,
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					r.ObserveResource("db", 20*time.Millisecond)
+					r.ObserveResource("db", 40*time.Millisecond)
+					r.TimeResource("cache")()
+					return "ok", nil
+				}),
+			},
+		},
+	}
+
+	srv := NewServer(":9952")
+	srv.AddAPI(a)
+
+	req, _ := http.NewRequest("GET", a.FullPath("/slow"), nil)
+	srv.Handler().ServeHTTP(httptest.NewRecorder(), req)
+
+	calls, avg := ResourceLatency(a.FullPath("/slow"), "db")
+	assert.EqualValues(t, 2, calls)
+	assert.Equal(t, 30*time.Millisecond, avg)
+
+	calls, _ = ResourceLatency(a.FullPath("/slow"), "cache")
+	assert.EqualValues(t, 1, calls)
+
+	calls, _ = ResourceLatency(a.FullPath("/slow"), "queue")
+	assert.EqualValues(t, 0, calls)
+}
@@ -0,0 +1,71 @@
+package vertex
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// responses over the soft or hard size limits of their routes, by route
+var responseSizeLimits = newCounterMap("vertex.response_size_limits")
+
+// hasSizeLimits tells if the responses of a route are checked for their size
+func (r *Route) hasSizeLimits() bool {
+	return r.MaxResponseSize > 0 || r.WarnResponseSize > 0 || r.Offload != nil
+}
+
+// renderLimited renders a response within the size limits of its route. The response is rendered to a buffer first,
+// so a response over the offloading threshold can be offloaded, and a response over the maximum size is replaced by a
+// ResponseTooLargeError, before anything is sent
+func (a *API) renderLimited(renderer Renderer, route *Route, routePath string, v interface{}, e error,
+	w http.ResponseWriter, r *Request) error {
+
+	rec := newPartRecorder()
+	if err := renderer.Render(v, e, rec, r); err != nil {
+		return err
+	}
+
+	status := rec.status
+	if sw, ok := w.(*statusWriter); ok && status == http.StatusOK {
+		status = sw.status
+	}
+
+	size := rec.body.Len()
+	if route.Offload != nil && status == http.StatusOK && size > route.Offload.Threshold {
+		if err := route.Offload.offload(rec, routePath, w, r); err == nil {
+			return nil
+		}
+	}
+
+	if route.MaxResponseSize > 0 && size > route.MaxResponseSize {
+		logging.Error("Response of %s is %d bytes, over the maximum of %d bytes", routePath, size, route.MaxResponseSize)
+		responseSizeLimits.Add(routePath, "exceeded", 1)
+
+		err := ResponseTooLargeError("Response of %d bytes is over the maximum of %d bytes", size, route.MaxResponseSize)
+		a.countError(routePath, err)
+		recordRequestLog(r.RequestId, fmt.Sprintf("Response too large: %d bytes", size))
+		return renderer.Render(nil, err, w, r)
+	}
+
+	if route.WarnResponseSize > 0 && size > route.WarnResponseSize {
+		logging.Warning("Response of %s is %d bytes, over the warning threshold of %d bytes", routePath, size,
+			route.WarnResponseSize)
+		responseSizeLimits.Add(routePath, "warned", 1)
+	}
+
+	for k, v := range rec.header {
+		w.Header()[k] = v
+	}
+	// the status of responses wrapped by handlers is written by their writer
+	if rec.status != http.StatusOK {
+		w.WriteHeader(rec.status)
+	}
+	_, err := w.Write(rec.body.Bytes())
+	return err
+}
+
+// ResponseSizeStats returns the number of responses of a route over its warning threshold, and over its maximum size
+func ResponseSizeStats(route string) (warned, exceeded int64) {
+	return responseSizeLimits.Value(route, "warned"), responseSizeLimits.Value(route, "exceeded")
+}
@@ -0,0 +1,61 @@
+package vertex
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type sizedHandler struct {
+	Size int `schema:"size"`
+}
+
+func (h sizedHandler) Handle(w http.ResponseWriter, r *Request) (interface{}, error) {
+	w.Header().Set("X-Size", "yes")
+	return strings.Repeat("x", h.Size), nil
+}
+
+func TestResponseSizeLimits(t *testing.T) {
+
+	a := &API{
+		Name:          "sized",
+		Version:       "1.0",
+		Root:          "/sized",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{Path: "/items", Description: "Items", Methods: GET, Handler: sizedHandler{}, WarnResponseSize: 500, MaxResponseSize: 1000},
+		},
+	}
+	srv := NewServer(":9989")
+	srv.AddAPI(a)
+
+	get := func(size string) *httptest.ResponseRecorder {
+		hr, _ := http.NewRequest("GET", a.FullPath("/items")+"?size="+size, nil)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, hr)
+		return w
+	}
+
+	w := get("10")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "yes", w.Header().Get("X-Size"))
+	assert.Contains(t, w.Body.String(), strings.Repeat("x", 10))
+
+	w = get("600")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), strings.Repeat("x", 600))
+
+	w = get("2000")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.NotContains(t, w.Body.String(), "xxxx")
+
+	warned, exceeded := ResponseSizeStats(a.FullPath("/items"))
+	assert.Equal(t, int64(1), warned)
+	assert.Equal(t, int64(1), exceeded)
+
+	assert.Equal(t, http.StatusInternalServerError, errorStatus(ResponseTooLargeError("too large")))
+}
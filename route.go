@@ -1,7 +1,12 @@
 package vertex
 
 import (
+	"fmt"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/dvirsky/go-pylog/logging"
 	gorilla "github.com/gorilla/schema"
@@ -23,6 +28,67 @@ type Route struct {
 	Test        Tester
 	Returns     interface{}
 	Renderer    Renderer
+
+	// If set, requests modifying the route's entity must send its current version in an If-Match header.
+	// See Request.CheckVersion
+	RequireIfMatch bool
+
+	// If set, the route accepts and enqueues requests: the bound handler is pushed to the queue, the request is
+	// answered with 202 Accepted right away, and the server's consumers process it later. The handler must
+	// implement Ingester
+	Queue Queue
+
+	// Number of concurrent consumers of the route's queue. Routes sharing a queue get the largest number of
+	// consumers among them. Defaults to 1
+	Consumers int
+
+	// The API version the route was added in, and what changed in later versions by version. They are rendered
+	// into the route's documentation and the x-since and x-changed-in extensions of its spec:
+	//
+	//	Since:     "1.1",
+	//	ChangedIn: map[string]string{"1.3": "Added the limit param", "1.4": "Results are sorted by date"},
+	Since     string
+	ChangedIn map[string]string
+
+	// Minimum versions of client apps allowed to call the route by client name, overriding the minimum versions of
+	// the API. Clients are identified by the ClientIdentifier middleware
+	MinClientVersions map[string]MinClientVersion
+
+	// An example response of the route, documented in its spec and served in mock mode. If not set, mock mode
+	// serves an example generated from the type of Returns
+	Example interface{}
+
+	// Names of sanitizers applied to all the string params of the route after binding, before the sanitizers of the
+	// params themselves. See Sanitizer
+	Sanitize []string
+
+	// Relations of the route's response clients can ask to embed with the expand param. See Expansions
+	Expand *Expansions
+
+	// Maximum size of the route's rendered responses in bytes. Larger responses are replaced by a
+	// ResponseTooLargeError, so unbounded result sets fail loudly instead of being sent. Responses over
+	// WarnResponseSize are sent, but logged. Both are counted, see ResponseSizeStats. Streams are not limited.
+	// 0 means unlimited
+	MaxResponseSize  int
+	WarnResponseSize int
+
+	// If set, large responses are offloaded to a blob store and clients are redirected to download them. See
+	// OffloadOptions
+	Offload *OffloadOptions
+
+	// If set, requests are validated and answered with the route's example instead of invoking the handler, so
+	// clients can be developed before the route is implemented. The whole server is mocked with the mock config
+	Mock bool
+
+	// Maximum time to handle a request of the route, middleware included. Once it passes the request's context is
+	// canceled and the request is answered with 504 Gateway Timeout. The handler is left running until it returns,
+	// so it should pass the request's context to its downstream calls, and must not use the request or its
+	// response once the context is done. 0 means no timeout. See TimeoutStats
+	Timeout time.Duration
+
+	// Latency objective of the route, tracked with an error budget and alerted on by its burn rate. See SLO
+	SLO *SLO
+
 	requestInfo schema.RequestInfo
 }
 
@@ -56,3 +122,58 @@ func (r *Route) parseInfo(path string) error {
 	return nil
 
 }
+
+// changelog renders the route's version annotations as a documentation paragraph, or an empty string if it has none
+func (r *Route) changelog() string {
+
+	lines := []string{}
+	if r.Since != "" {
+		lines = append(lines, fmt.Sprintf("Since version %s.", r.Since))
+	}
+
+	versions := make([]string, 0, len(r.ChangedIn))
+	for v := range r.ChangedIn {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return compareVersions(versions[i], versions[j]) < 0 })
+	for _, v := range versions {
+		lines = append(lines, fmt.Sprintf("Changed in %s: %s", v, r.ChangedIn[v]))
+	}
+
+	return strings.Join(lines, "\n\n")
+}
+
+// compareVersions compares dotted version strings, numerically where both parts are numbers, so 1.10 is after 1.9.
+// Missing parts count as zeros, so 3.2 equals 3.2.0
+func compareVersions(a, b string) int {
+
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for len(as) < len(bs) {
+		as = append(as, "0")
+	}
+	for len(bs) < len(as) {
+		bs = append(bs, "0")
+	}
+
+	for i := range as {
+		if as[i] == bs[i] {
+			continue
+		}
+		an, aerr := strconv.Atoi(as[i])
+		bn, berr := strconv.Atoi(bs[i])
+		if aerr == nil && berr == nil {
+			if an == bn {
+				continue
+			}
+			if an < bn {
+				return -1
+			}
+			return 1
+		}
+		if as[i] < bs[i] {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
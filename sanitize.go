@@ -0,0 +1,158 @@
+package vertex
+
+import (
+	"html"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/dvirsky/go-pylog/logging"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/EverythingMe/vertex/schema"
+)
+
+// Sanitizer cleans a string param after it was bound to its handler, e.g. to escape user content that APIs echo into
+// web frontends. Sanitizers are attached to params by name with the sanitize tag, in the order they run:
+//
+//	type CommentHandler struct {
+//		Text string `schema:"text" sanitize:"normalize,control,trim,html"`
+//	}
+//
+// or to all the string params of a route with Route.Sanitize. Sanitizers run after params are validated, so escaping
+// may make a param longer than its maxlen
+type Sanitizer func(string) string
+
+// Built in sanitizers
+const (
+	// Escape HTML special characters: <, >, &, ' and "
+	SanitizeHTML = "html"
+
+	// Strip control characters, except for tabs and newlines
+	SanitizeControl = "control"
+
+	// Normalize unicode to the NFC form, so equivalent strings are stored and compared the same
+	SanitizeNormalize = "normalize"
+
+	// Trim leading and trailing white space
+	SanitizeTrim = "trim"
+)
+
+var sanitizers = struct {
+	sync.Mutex
+	byName map[string]Sanitizer
+}{
+	byName: map[string]Sanitizer{
+		SanitizeHTML:      html.EscapeString,
+		SanitizeControl:   stripControl,
+		SanitizeNormalize: norm.NFC.String,
+		SanitizeTrim:      strings.TrimSpace,
+	},
+}
+
+// RegisterSanitizer registers a custom sanitizer by name, for params to be tagged with. Sanitizers must be registered
+// before the routes using them are added to a server
+func RegisterSanitizer(name string, s Sanitizer) {
+	sanitizers.Lock()
+	defer sanitizers.Unlock()
+	sanitizers.byName[name] = s
+}
+
+func getSanitizer(name string) (Sanitizer, bool) {
+	sanitizers.Lock()
+	defer sanitizers.Unlock()
+	s, ok := sanitizers.byName[name]
+	return s, ok
+}
+
+// stripControl removes control characters from a string, except for tabs and newlines
+func stripControl(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\t' && r != '\n' && r != '\r' {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// paramSanitizer is the sanitizers of a param by its struct field
+type paramSanitizer struct {
+	key        string
+	sanitizers []Sanitizer
+}
+
+// paramSanitizers resolves the sanitizers of the string params of a route: the route's sanitizers, followed by the
+// sanitizers the param is tagged with. Unknown sanitizers are logged and ignored
+func paramSanitizers(params []schema.ParamInfo, route []string) []paramSanitizer {
+
+	ret := []paramSanitizer{}
+	for _, p := range params {
+		if !isStringParam(p.Type) {
+			continue
+		}
+
+		ps := paramSanitizer{key: p.StructKey}
+		for _, name := range append(append([]string{}, route...), p.Sanitizers...) {
+			s, ok := getSanitizer(name)
+			if !ok {
+				logging.Error("Unknown sanitizer %s for param %s", name, p.Name)
+				continue
+			}
+			ps.sanitizers = append(ps.sanitizers, s)
+		}
+		if len(ps.sanitizers) > 0 {
+			ret = append(ret, ps)
+		}
+	}
+	return ret
+}
+
+// isStringParam tells if a param is a string or a slice of strings
+func isStringParam(t reflect.Type) bool {
+	if t == nil {
+		return false
+	}
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.String
+}
+
+// sanitizeParams runs the sanitizers of the params of a bound handler
+func sanitizeParams(handler interface{}, params []paramSanitizer) {
+
+	if len(params) == 0 {
+		return
+	}
+
+	v := reflect.ValueOf(handler)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return
+	}
+
+	for _, p := range params {
+		field := v.FieldByName(p.key)
+		if !field.IsValid() || !field.CanSet() {
+			continue
+		}
+
+		if field.Kind() == reflect.Slice {
+			for i := 0; i < field.Len(); i++ {
+				field.Index(i).SetString(p.sanitize(field.Index(i).String()))
+			}
+		} else {
+			field.SetString(p.sanitize(field.String()))
+		}
+	}
+}
+
+func (p paramSanitizer) sanitize(s string) string {
+	for _, sanitizer := range p.sanitizers {
+		s = sanitizer(s)
+	}
+	return s
+}
@@ -0,0 +1,72 @@
+package vertex
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type commentHandler struct {
+	Text   string   `schema:"text" sanitize:"control,trim,html"`
+	Author string   `schema:"author" sanitize:"upper"`
+	Tags   []string `schema:"tags"`
+	Rating int      `schema:"rating"`
+}
+
+func (h commentHandler) Handle(w http.ResponseWriter, r *Request) (interface{}, error) {
+	return map[string]interface{}{"text": h.Text, "author": h.Author, "tags": h.Tags}, nil
+}
+
+func TestSanitizers(t *testing.T) {
+
+	assert.Equal(t, "a\tb\nc", stripControl("a\x00\tb\n\x1bc\u0085"))
+
+	s, ok := getSanitizer(SanitizeHTML)
+	assert.True(t, ok)
+	assert.Equal(t, "&lt;b&gt;&amp;&#34;", s("<b>&\""))
+
+	s, _ = getSanitizer(SanitizeNormalize)
+	assert.Equal(t, "é", s("é"))
+
+	_, ok = getSanitizer("nope")
+	assert.False(t, ok)
+}
+
+func TestSanitizeParams(t *testing.T) {
+
+	RegisterSanitizer("upper", strings.ToUpper)
+
+	a := &API{
+		Name:          "sanitize",
+		Version:       "1.0",
+		Root:          "/sanitize",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{Path: "/comment", Description: "Comment", Methods: POST, Handler: commentHandler{}, Sanitize: []string{SanitizeTrim}},
+		},
+	}
+	srv := NewServer(":9978")
+	srv.AddAPI(a)
+
+	values := url.Values{
+		"text":   {" <script>alert(1)</script>\x00 "},
+		"author": {" bob"},
+		"tags":   {" a ", "b "},
+		"rating": {"5"},
+	}
+	hr, _ := http.NewRequest("POST", a.FullPath("/comment"), strings.NewReader(values.Encode()))
+	hr.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, hr)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, `"text":"\u0026lt;script\u0026gt;alert(1)\u0026lt;/script\u0026gt;"`)
+	assert.Contains(t, body, `"author":"BOB"`)
+	assert.Contains(t, body, `"tags":["a","b"]`)
+}
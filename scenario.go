@@ -0,0 +1,149 @@
+package vertex
+
+import "fmt"
+
+// Scenario is a tester made of steps that run in order against different routes of the API, e.g. create a user,
+// fetch it and delete it. Steps pass values to the steps after them with TestContext.Set and TestContext.Value:
+//
+//	vertex.Scenario{
+//		Name: "user lifecycle",
+//		Steps: []vertex.Step{
+//			{Name: "create", Route: "/users", Run: func(t *vertex.TestContext) {
+//				...
+//				t.Set("id", user.Id)
+//			}},
+//			{Name: "fetch", Route: "/users/{id}", Run: func(t *vertex.TestContext) {
+//				req, _ := t.NewRequest("GET", nil, vertex.Params{"id": t.Value("id")})
+//				...
+//			}},
+//		},
+//	}
+//
+// A failing step aborts the scenario, since the steps after it depend on it. Setup runs before the first step, and
+// Teardown runs after the last step even if the scenario failed, to clean up what the steps created
+type Scenario struct {
+	Name string
+
+	// The test category of the scenario. Defaults to CriticalTests
+	TestCategory string
+
+	// Timeout and retries of the whole scenario, including its setup and teardown
+	Options TestOptions
+
+	Setup    func(t *TestContext)
+	Steps    []Step
+	Teardown func(t *TestContext)
+}
+
+// Step is a single step of a scenario
+type Step struct {
+	Name string
+
+	// The route the step's requests go to. See TestContext.NewRequest
+	Route string
+
+	Run func(t *TestContext)
+}
+
+// Category returns the test category of the scenario
+func (s *Scenario) Category() string {
+	if s.TestCategory == "" {
+		return CriticalTests
+	}
+	return s.TestCategory
+}
+
+// Test runs the scenario's setup, steps and teardown
+func (s *Scenario) Test(t *TestContext) {
+
+	routePath := t.routePath
+	defer func() {
+		t.routePath = routePath
+
+		// failures are reported on the scenario, not on the route of the failing step
+		if e := recover(); e != nil {
+			if res, ok := e.(testResult); ok {
+				res.Path = routePath
+				e = res
+			}
+			panic(e)
+		}
+	}()
+
+	if s.Teardown != nil {
+		defer s.teardown(t)
+	}
+	if s.Setup != nil {
+		s.runStep(t, "setup", "", s.Setup)
+	}
+	for i, step := range s.Steps {
+		name := step.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		s.runStep(t, name, step.Route, step.Run)
+	}
+}
+
+// teardown runs the scenario's teardown. If the scenario already failed, the failure is reported and not a failure
+// of the teardown
+func (s *Scenario) teardown(t *TestContext) {
+
+	failure := recover()
+	if failure == nil {
+		s.runStep(t, "teardown", "", s.Teardown)
+		return
+	}
+
+	func() {
+		defer func() {
+			if e := recover(); e != nil {
+				t.Log("Teardown failed: %v", e)
+			}
+		}()
+		s.runStep(t, "teardown", "", s.Teardown)
+	}()
+	panic(failure)
+}
+
+// runStep runs a step on its route, prefixing its failure message with the step name
+func (s *Scenario) runStep(t *TestContext, name, route string, f func(t *TestContext)) {
+
+	defer func() {
+		if e := recover(); e != nil {
+			if res, ok := e.(testResult); ok && res.isFailure() {
+				res.Message = fmt.Sprintf("step %s: %s", name, res.Message)
+				e = res
+			}
+			panic(e)
+		}
+	}()
+
+	t.Log("Running step %s", name)
+	t.routePath = route
+	f(t)
+}
+
+// Set stores a value for the next steps of a scenario
+func (t *TestContext) Set(key string, value interface{}) {
+	if t.values == nil {
+		t.values = map[string]interface{}{}
+	}
+	t.values[key] = value
+}
+
+// Get returns a value stored by a previous step of a scenario, and false if it was not stored
+func (t *TestContext) Get(key string) (interface{}, bool) {
+	v, found := t.values[key]
+	return v, found
+}
+
+// Value returns a value stored by a previous step as a string, e.g. to use as a path param. It aborts the test if
+// the value was not stored, since the step depends on a step that did not run
+func (t *TestContext) Value(key string) string {
+	v, found := t.values[key]
+	if !found {
+		panic(newTestResult(resultFatal, fmt.Sprintf("Value %s was not set by a previous step", key), 2, t))
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,87 @@
+package vertex
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScenario(t *testing.T) {
+
+	items := map[string]bool{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/scenario/items" && r.Method == "POST":
+			id := fmt.Sprintf("%d", len(items)+1)
+			items[id] = true
+			fmt.Fprintf(w, `{"id":%q}`, id)
+		case strings.HasPrefix(r.URL.Path, "/scenario/items/") && items[strings.TrimPrefix(r.URL.Path, "/scenario/items/")]:
+			fmt.Fprint(w, `{}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	var steps []string
+	create := Step{Name: "create", Route: "/items", Run: func(ctx *TestContext) {
+		steps = append(steps, "create")
+		req, _ := ctx.NewRequest("POST", nil, nil)
+		v := map[string]string{}
+		if _, err := ctx.GetJSON(req, &v); err != nil {
+			ctx.Fail("Could not create item: %s", err)
+		}
+		ctx.Set("id", v["id"])
+	}}
+	fetch := Step{Name: "fetch", Route: "/items/{id}", Run: func(ctx *TestContext) {
+		steps = append(steps, "fetch")
+		req, _ := ctx.NewRequest("GET", nil, Params{"id": ctx.Value("id")})
+		if _, err := ctx.GetJSON(req, &map[string]string{}); err != nil {
+			ctx.Fail("Could not fetch item: %s", err)
+		}
+	}}
+	fail := Step{Name: "fail", Run: func(ctx *TestContext) {
+		steps = append(steps, "fail")
+		ctx.Fail("oops")
+	}}
+
+	sc := &Scenario{
+		Name:     "lifecycle",
+		Setup:    func(ctx *TestContext) { steps = append(steps, "setup") },
+		Teardown: func(ctx *TestContext) { steps = append(steps, "teardown") },
+	}
+	a := &API{Name: "scenario", Version: "1.0", Root: "/scenario", Scenarios: []*Scenario{sc}}
+	runner := newTestRunner(bytes.NewBuffer(nil), a, ts.URL, AllTests, TestFormatText)
+
+	sc.Steps = []Step{create, fetch}
+	res := runner.runTest(sc, sc.Name)
+	assert.Equal(t, resultPass, res.Result, res.Message)
+	assert.Equal(t, "lifecycle", res.Path)
+	assert.Equal(t, CriticalTests, res.Category)
+	assert.Equal(t, []string{"setup", "create", "fetch", "teardown"}, steps)
+
+	// a failing step aborts the scenario but the teardown still runs
+	steps = nil
+	sc.Steps = []Step{create, fail, fetch}
+	res = runner.runTest(sc, sc.Name)
+	assert.Equal(t, resultFailed, res.Result)
+	assert.Equal(t, "step fail: oops", res.Message)
+	assert.Equal(t, "lifecycle", res.Path)
+	assert.Equal(t, []string{"setup", "create", "fail", "teardown"}, steps)
+
+	// steps depending on values that were not set fail
+	steps = nil
+	sc.Steps = []Step{fetch}
+	res = runner.runTest(sc, sc.Name)
+	assert.Equal(t, resultFatal, res.Result)
+	assert.Contains(t, res.Message, "Value id was not set")
+
+	// scenarios run with the route testers
+	sc.Steps = []Step{create, fetch}
+	assert.True(t, runner.Run())
+}
@@ -0,0 +1,149 @@
+package vertex
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// DefaultQueueTimeout is how long requests wait for a free slot if the API does not set a QueueTimeout
+const DefaultQueueTimeout = 5 * time.Second
+
+// apiQueue holds the concurrency state of a single API in the scheduler
+type apiQueue struct {
+	api     *API
+	running int
+	waiting []chan struct{}
+}
+
+// scheduler limits the concurrent requests of the APIs of a server. Each API may have a quota of concurrent requests,
+// and the server as a whole may have a total capacity (Config.Server.MaxConcurrency).
+//
+// Requests over the limits wait in per API queues. When a slot frees up, the queues are served round robin, so a
+// traffic spike in one API cannot starve the other APIs of the server
+type scheduler struct {
+	mtx     sync.Mutex
+	running int
+	queues  []*apiQueue
+	next    int
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{
+		queues: make([]*apiQueue, 0),
+	}
+}
+
+// queue returns the queue of an API, creating it if needed. Must be called with the lock held
+func (s *scheduler) queue(a *API) *apiQueue {
+	for _, q := range s.queues {
+		if q.api == a {
+			return q
+		}
+	}
+	q := &apiQueue{api: a}
+	s.queues = append(s.queues, q)
+	return q
+}
+
+// hasCapacity checks whether the server has a free slot. Must be called with the lock held
+func (s *scheduler) hasCapacity() bool {
+	return Config.Server.MaxConcurrency <= 0 || s.running < Config.Server.MaxConcurrency
+}
+
+// eligible checks whether a queue can run another request within its API's quota
+func (q *apiQueue) eligible() bool {
+	return q.api.MaxConcurrency <= 0 || q.running < q.api.MaxConcurrency
+}
+
+// dispatch hands free slots to waiting requests, serving the API queues round robin. Must be called with the lock held
+func (s *scheduler) dispatch() {
+
+	for s.hasCapacity() {
+
+		granted := false
+		for i := 0; i < len(s.queues); i++ {
+			q := s.queues[(s.next+i)%len(s.queues)]
+			if len(q.waiting) == 0 || !q.eligible() {
+				continue
+			}
+
+			ch := q.waiting[0]
+			q.waiting = q.waiting[1:]
+			q.running++
+			s.running++
+			close(ch)
+
+			s.next = (s.next + i + 1) % len(s.queues)
+			granted = true
+			break
+		}
+
+		if !granted {
+			return
+		}
+	}
+}
+
+// acquire waits for a slot to handle a request of an API, and returns a function releasing the slot.
+// It fails with a TemporarilyUnavailableError if the API's queue is full or the request waited too long
+func (s *scheduler) acquire(a *API) (func(), error) {
+
+	s.mtx.Lock()
+
+	q := s.queue(a)
+	release := func() {
+		s.mtx.Lock()
+		q.running--
+		s.running--
+		s.dispatch()
+		s.mtx.Unlock()
+	}
+
+	// a free slot and nobody from this API waiting before us
+	if len(q.waiting) == 0 && q.eligible() && s.hasCapacity() {
+		q.running++
+		s.running++
+		s.mtx.Unlock()
+		return release, nil
+	}
+
+	if a.MaxQueued > 0 && len(q.waiting) >= a.MaxQueued {
+		s.mtx.Unlock()
+		logging.Warning("Request queue of API %s is full", a.Name)
+		return nil, TemporarilyUnavailableError(time.Second, "API %s is over capacity", a.Name)
+	}
+
+	ch := make(chan struct{})
+	q.waiting = append(q.waiting, ch)
+	s.mtx.Unlock()
+
+	timeout := a.QueueTimeout
+	if timeout <= 0 {
+		timeout = DefaultQueueTimeout
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-ch:
+		return release, nil
+	case <-timer.C:
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for i, c := range q.waiting {
+		if c == ch {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			logging.Warning("Request of API %s timed out waiting in queue", a.Name)
+			return nil, TemporarilyUnavailableError(time.Second, "API %s is over capacity", a.Name)
+		}
+	}
+
+	// we were granted a slot just as we timed out
+	return release, nil
+}
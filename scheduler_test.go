@@ -0,0 +1,105 @@
+package vertex
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedulerFairness(t *testing.T) {
+
+	defer func(max int) { Config.Server.MaxConcurrency = max }(Config.Server.MaxConcurrency)
+	Config.Server.MaxConcurrency = 2
+
+	s := newScheduler()
+	a := &API{Name: "a", QueueTimeout: time.Second}
+	b := &API{Name: "b", QueueTimeout: time.Second}
+
+	// a takes the whole server
+	releaseA1, err := s.acquire(a)
+	assert.NoError(t, err)
+	releaseA2, err := s.acquire(a)
+	assert.NoError(t, err)
+
+	granted := make(chan string, 10)
+	wait := func(api *API, name string) {
+		release, err := s.acquire(api)
+		if err != nil {
+			granted <- "error"
+			return
+		}
+		granted <- name
+		_ = release
+	}
+	waiting := func(api *API) int {
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		return len(s.queue(api).waiting)
+	}
+
+	// more a requests queue up before the b request
+	go wait(a, "a3")
+	for waiting(a) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	go wait(a, "a4")
+	for waiting(a) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	go wait(b, "b1")
+	for waiting(b) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// freed slots alternate between the APIs
+	releaseA1()
+	assert.Equal(t, "a3", <-granted)
+	releaseA2()
+	assert.Equal(t, "b1", <-granted)
+
+	// a4 is never granted a slot, and times out
+	assert.Equal(t, "error", <-granted)
+}
+
+func TestSchedulerQuota(t *testing.T) {
+
+	s := newScheduler()
+	a := &API{Name: "a", MaxConcurrency: 1, MaxQueued: 1, QueueTimeout: 10 * time.Millisecond}
+	b := &API{Name: "b"}
+
+	release, err := s.acquire(a)
+	assert.NoError(t, err)
+
+	// other APIs are not affected by a's quota
+	releaseB, err := s.acquire(b)
+	assert.NoError(t, err)
+	releaseB()
+
+	// a's queue holds one request, which times out
+	done := make(chan error)
+	go func() {
+		_, err := s.acquire(a)
+		done <- err
+	}()
+	for {
+		s.mtx.Lock()
+		n := len(s.queue(a).waiting)
+		s.mtx.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	_, err = s.acquire(a)
+	assert.Error(t, err)
+	assert.Equal(t, 503, errorStatus(err))
+
+	assert.Error(t, <-done)
+
+	release()
+	release, err = s.acquire(a)
+	assert.NoError(t, err)
+	release()
+}
@@ -26,6 +26,12 @@ const (
 	PatternTag    = "pattern"
 	InTag         = "in"
 	GlobalTag     = "global"
+
+	// Comma separated names of the sanitizers applied to string params after binding, e.g. sanitize:"trim,html"
+	SanitizeTag = "sanitize"
+
+	// Fields set to injected dependencies are not request params
+	InjectTag = "inject"
 )
 
 // ParamInfo represents metadata about a requests parameter
@@ -83,7 +89,9 @@ type ParamInfo struct {
 	// One-of string selection
 	Options []string
 
-	// Where is the param in. empty is query/body. should be set only to "path" in case of path params
+	// Where is the param in. empty is query/body. should be set only to "path" in case of path params, to "header"
+	// or "cookie" for params sent in a request header or cookie named by the param, or to "body" for a field bound to
+	// the JSON body of the request
 	In string
 
 	Hidden bool
@@ -91,6 +99,9 @@ type ParamInfo struct {
 	// Is this param a reference to a global definition? If so, we copy its definition to the parameters type
 	// of the generated swagger
 	Global bool
+
+	// Names of the sanitizers applied to the param after binding, in order
+	Sanitizers []string
 }
 
 func getTag(f reflect.StructField, key, def string) string {
@@ -168,6 +179,11 @@ func newParamInfo(field reflect.StructField) ParamInfo {
 	ret.MinLength, _ = intTag(field, MinLenTag, 0)
 	ret.Hidden = boolTag(field, HiddenTag, false)
 	ret.Global = boolTag(field, GlobalTag, false)
+	if sanitize := field.Tag.Get(SanitizeTag); sanitize != "" {
+		for _, name := range strings.Split(sanitize, ",") {
+			ret.Sanitizers = append(ret.Sanitizers, strings.TrimSpace(name))
+		}
+	}
 
 	ret.RawDefault = getTag(field, DefaultTag, "")
 	ret.Default, ret.HasDefault = parseDefault(getTag(field, DefaultTag, ""), field.Type.Kind())
@@ -196,9 +212,14 @@ func (r RequestInfo) ToSwagger() swagger.Method {
 		ret.Parameters = make([]swagger.Param, 0)
 	}
 	for _, p := range r.Params {
-		if !p.Hidden {
-			ret.Parameters = append(ret.Parameters, p.ToSwagger())
+		if p.Hidden {
+			continue
 		}
+		if p.In == "cookie" {
+			ret.Cookies = append(ret.Cookies, p.ToSwagger())
+			continue
+		}
+		ret.Parameters = append(ret.Parameters, p.ToSwagger())
 	}
 
 	if r.Returns != nil {
@@ -211,7 +232,7 @@ func (r RequestInfo) ToSwagger() swagger.Method {
 		}
 
 	} else {
-		ret.Responses["default"] = swagger.Response{"", jsonschema.Reflect("")}
+		ret.Responses["default"] = swagger.Response{Description: "", Schema: jsonschema.Reflect("")}
 
 	}
 
@@ -227,12 +248,12 @@ func extractParams(T reflect.Type) (ret []ParamInfo) {
 	for i := 0; i < T.NumField(); i++ {
 
 		field := T.FieldByIndex([]int{i})
-		if field.Name == "_" {
+		if field.Name == "_" || field.Tag.Get(InjectTag) != "" {
 			continue
 		}
 
-		// a struct means this is an embedded request object
-		if field.Type.Kind() == reflect.Struct {
+		// a struct means this is an embedded request object, unless it is bound to the body
+		if field.Type.Kind() == reflect.Struct && field.Tag.Get(InTag) != "body" {
 			ret = append(extractParams(field.Type), ret...)
 		} else {
 
@@ -277,7 +298,7 @@ func NewRequestInfo(T reflect.Type, pth string, description string, returnValue
 	for i := 0; i < T.NumField(); i++ {
 
 		field := T.FieldByIndex([]int{i})
-		if field.Name == "_" {
+		if field.Name == "_" || field.Tag.Get(InjectTag) != "" {
 			continue
 		}
 
@@ -316,6 +337,12 @@ func (p ParamInfo) ToSwagger() swagger.Param {
 		Global:    p.Global,
 	}
 
+	// body params are described by the schema of their type
+	if p.In == "body" {
+		ret.Schema = jsonschema.Reflect(reflect.Zero(p.Type).Interface())
+		return ret
+	}
+
 	ret.Type, ret.Items = swagger.TypeOf(p.Type, swagger.String)
 	return ret
 }
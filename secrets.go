@@ -0,0 +1,243 @@
+package vertex
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// SecretPrefix marks config values that are references to secrets rather than the values themselves. A reference
+// has the form secret:<provider>:<key>, e.g:
+//
+//	apis:
+//		myApi:
+//			db_password: secret:env:DB_PASSWORD
+//			api_key: secret:file:/run/secrets/api_key
+//			token: secret:vault:payments/stripe#token
+//
+// References are resolved by the registered SecretsProvider of that name when the configs are read, and again when
+// RefreshSecrets is called after secrets were rotated. The env and file providers are built in
+const SecretPrefix = "secret:"
+
+// SecretsProvider resolves secrets by key from a secret store
+type SecretsProvider interface {
+	Secret(key string) (string, error)
+}
+
+// SecretsProviderFunc wraps a func as a SecretsProvider
+type SecretsProviderFunc func(key string) (string, error)
+
+// Secret calls the func
+func (f SecretsProviderFunc) Secret(key string) (string, error) {
+	return f(key)
+}
+
+// envSecrets resolves secrets from environment variables
+func envSecrets(key string) (string, error) {
+	v, found := os.LookupEnv(key)
+	if !found {
+		return "", fmt.Errorf("Environment variable %s is not set", key)
+	}
+	return v, nil
+}
+
+// fileSecrets resolves secrets from files, e.g. mounted docker or kubernetes secrets. Trailing newlines are trimmed
+func fileSecrets(key string) (string, error) {
+	b, err := ioutil.ReadFile(key)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+var secretsProviders = struct {
+	sync.RWMutex
+	providers map[string]SecretsProvider
+}{
+	providers: map[string]SecretsProvider{
+		"env":  SecretsProviderFunc(envSecrets),
+		"file": SecretsProviderFunc(fileSecrets),
+	},
+}
+
+// RegisterSecretsProvider registers a secrets provider by name, for config values referencing it. It must be
+// called before the configs are read
+func RegisterSecretsProvider(name string, p SecretsProvider) {
+	secretsProviders.Lock()
+	defer secretsProviders.Unlock()
+	secretsProviders.providers[name] = p
+}
+
+// resolveSecret resolves a secret reference
+func resolveSecret(ref string) (string, error) {
+
+	parts := strings.SplitN(strings.TrimPrefix(ref, SecretPrefix), ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", fmt.Errorf("Invalid secret reference, expected secret:<provider>:<key>")
+	}
+
+	secretsProviders.RLock()
+	p, found := secretsProviders.providers[parts[0]]
+	secretsProviders.RUnlock()
+	if !found {
+		return "", fmt.Errorf("Unknown secrets provider %s", parts[0])
+	}
+
+	return p.Secret(parts[1])
+}
+
+// secretField is the latest value of a config field resolved from a secret, kept so it can be refreshed
+type secretField struct {
+	ref   string
+	value string
+}
+
+// field address => secret
+var secretFields = struct {
+	sync.RWMutex
+	fields map[uintptr]secretField
+}{
+	fields: map[uintptr]secretField{},
+}
+
+// resolveSecrets replaces all the secret references in the string fields of a config struct with their values.
+// References in maps are resolved too, but only fields of structs and slices can be refreshed
+func resolveSecrets(conf interface{}) error {
+	return walkSecrets(reflect.ValueOf(conf), "", true)
+}
+
+func walkSecrets(v reflect.Value, path string, refreshable bool) error {
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return walkSecrets(v.Elem(), path, true)
+		}
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		if e := v.Elem(); e.Kind() == reflect.Ptr || !v.CanSet() {
+			return walkSecrets(e, path, refreshable)
+		}
+		// values held by interfaces cannot be set in place, they are resolved in a copy
+		c := reflect.New(v.Elem().Type()).Elem()
+		c.Set(v.Elem())
+		if err := walkSecrets(c, path, false); err != nil {
+			return err
+		}
+		v.Set(c)
+
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath != "" {
+				// unexported
+				continue
+			}
+			if err := walkSecrets(v.Field(i), path+"."+v.Type().Field(i).Name, refreshable); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := walkSecrets(v.Index(i), fmt.Sprintf("%s[%d]", path, i), refreshable); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		// map values cannot be set in place, they are resolved in a copy
+		for _, k := range v.MapKeys() {
+			c := reflect.New(v.Type().Elem()).Elem()
+			c.Set(v.MapIndex(k))
+			if err := walkSecrets(c, fmt.Sprintf("%s[%v]", path, k), false); err != nil {
+				return err
+			}
+			v.SetMapIndex(k, c)
+		}
+
+	case reflect.String:
+		if !strings.HasPrefix(v.String(), SecretPrefix) || !v.CanSet() {
+			return nil
+		}
+
+		ref := v.String()
+		secret, err := resolveSecret(ref)
+		if err != nil {
+			return logging.Errorf("Could not resolve secret of config %s: %s", strings.TrimPrefix(path, "."), err)
+		}
+		v.SetString(secret)
+
+		if refreshable && v.CanAddr() {
+			secretFields.Lock()
+			secretFields.fields[v.Addr().Pointer()] = secretField{ref: ref, value: secret}
+			secretFields.Unlock()
+		}
+	}
+
+	return nil
+}
+
+// RefreshSecrets resolves all the secrets referenced by the configs again, e.g. on a signal after secrets were
+// rotated, and returns the number of secrets that changed.
+//
+// Configs are read concurrently by requests, so they keep the values resolved when they were read. Components
+// picking up rotated secrets read them with SecretValue
+func RefreshSecrets() (int, error) {
+
+	secretFields.RLock()
+	refs := make(map[uintptr]string, len(secretFields.fields))
+	for addr, f := range secretFields.fields {
+		refs[addr] = f.ref
+	}
+	secretFields.RUnlock()
+
+	// secrets are resolved without holding the lock, since providers may be remote
+	resolved := make(map[uintptr]string, len(refs))
+	for addr, ref := range refs {
+		secret, err := resolveSecret(ref)
+		if err != nil {
+			return 0, logging.Errorf("Could not refresh secret %s: %s", ref, err)
+		}
+		resolved[addr] = secret
+	}
+
+	changed := 0
+	secretFields.Lock()
+	for addr, secret := range resolved {
+		if f := secretFields.fields[addr]; f.value != secret {
+			f.value = secret
+			secretFields.fields[addr] = f
+			changed++
+		}
+	}
+	secretFields.Unlock()
+
+	if changed > 0 {
+		logging.Info("Refreshed %d rotated secrets", changed)
+	}
+	return changed, nil
+}
+
+// SecretValue returns the latest value of a config field resolved from a secret, refreshed by RefreshSecrets. Fields
+// that are not secrets return their own value:
+//
+//	client.SetToken(vertex.SecretValue(&conf.Token))
+func SecretValue(field *string) string {
+
+	secretFields.RLock()
+	f, found := secretFields.fields[reflect.ValueOf(field).Pointer()]
+	secretFields.RUnlock()
+
+	if !found {
+		return *field
+	}
+	return f.value
+}
@@ -0,0 +1,79 @@
+package vertex
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecrets(t *testing.T) {
+
+	vault := map[string]string{"payments#token": "tok1"}
+	RegisterSecretsProvider("vault", SecretsProviderFunc(func(key string) (string, error) {
+		return vault[key], nil
+	}))
+
+	os.Setenv("TEST_DB_PASSWORD", "hunter2")
+	defer os.Unsetenv("TEST_DB_PASSWORD")
+
+	dir, err := ioutil.TempDir("", "secrets")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "api_key")
+	assert.NoError(t, ioutil.WriteFile(keyFile, []byte("key1\n"), 0600))
+
+	conf := &struct {
+		DBPassword string
+		APIKey     string
+		Plain      string
+		Nested     struct{ Token string }
+		Tokens     []string
+		Extra      map[string]string
+		Values     map[string]interface{}
+		hidden     string
+	}{
+		DBPassword: "secret:env:TEST_DB_PASSWORD",
+		APIKey:     "secret:file:" + keyFile,
+		Plain:      "not a secret",
+		Tokens:     []string{"secret:vault:payments#token"},
+		Extra:      map[string]string{"token": "secret:vault:payments#token"},
+		Values:     map[string]interface{}{"token": "secret:vault:payments#token"},
+		hidden:     "secret:env:NOPE",
+	}
+	conf.Nested.Token = "secret:vault:payments#token"
+
+	if !assert.NoError(t, resolveSecrets(conf)) {
+		t.FailNow()
+	}
+	assert.Equal(t, "hunter2", conf.DBPassword)
+	assert.Equal(t, "key1", conf.APIKey)
+	assert.Equal(t, "not a secret", conf.Plain)
+	assert.Equal(t, "tok1", conf.Nested.Token)
+	assert.Equal(t, "tok1", conf.Tokens[0])
+
+	assert.Equal(t, "tok1", conf.Extra["token"])
+	assert.Equal(t, "tok1", conf.Values["token"])
+
+	// rotated secrets are read with SecretValue, the configs in use do not change
+	vault["payments#token"] = "tok2"
+	assert.NoError(t, ioutil.WriteFile(keyFile, []byte("key2"), 0600))
+	n, err := RefreshSecrets()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, "key1", conf.APIKey)
+	assert.Equal(t, "key2", SecretValue(&conf.APIKey))
+	assert.Equal(t, "tok2", SecretValue(&conf.Nested.Token))
+	assert.Equal(t, "tok2", SecretValue(&conf.Tokens[0]))
+	assert.Equal(t, "hunter2", SecretValue(&conf.DBPassword))
+	assert.Equal(t, "not a secret", SecretValue(&conf.Plain))
+
+	// unresolvable references fail loading
+	assert.Error(t, resolveSecrets(&struct{ S string }{"secret:env:TEST_NOT_SET"}))
+	assert.Error(t, resolveSecrets(&struct{ S string }{"secret:nope:key"}))
+	assert.Error(t, resolveSecrets(&struct{ S string }{"secret:env"}))
+}
@@ -0,0 +1,163 @@
+package vertex
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// securityMarker is the type of SecurityStage
+type securityMarker struct{}
+
+// Handle is never called - the marker is replaced by the actual security stage when the chain is built
+func (securityMarker) Handle(w http.ResponseWriter, r *Request, next HandlerFunc) (interface{}, error) {
+	return next(w, r)
+}
+
+// SecurityStage marks the position of the security validation in a middleware chain. Security is validated as a
+// stage of the chain, so middleware placed before it (e.g. logging, metrics or rate limiting) sees authentication
+// failures.
+//
+// By default the stage runs first, before all the API and route middleware, so no middleware runs for
+// unauthenticated requests. Put SecurityStage in the API or route middleware to opt in to validating security at a
+// different position - middleware placed before it must be safe to run for unauthenticated requests, which e.g. a
+// response cache is not:
+//
+//	Middleware: vertex.MiddlewareChain(
+//		middleware.NewIPRangeFilter(...),
+//		vertex.SecurityStage,
+//		middleware.NewLogger(),
+//	),
+var SecurityStage Middleware = securityMarker{}
+
+func isSecurityStage(mw Middleware) bool {
+	_, ok := mw.(securityMarker)
+	return ok
+}
+
+// route => passed/failed/failed.<reason>/micros => value
+var securityMetrics = newCounterMap("vertex.security")
+
+// securityFailureReason names the reason a security scheme rejected a request, for metrics
+func securityFailureReason(err error) string {
+	if code := PublicErrorCode(err); code != "" {
+		return code
+	}
+
+	if e, ok := err.(*internalError); ok {
+		switch e.Code {
+		case ErrMissingCredentials:
+			return "missing_credentials"
+		case ErrInvalidCredentials:
+			return "invalid_credentials"
+		case ErrForbidden:
+			return "forbidden"
+		case ErrInvalidRequest:
+			return "invalid_request"
+		case ErrTemporarilyUnavailable, ErrResourceUnavailable, ErrBackOff:
+			return "unavailable"
+		case ErrTimeout:
+			return "timeout"
+		case ErrCanceled:
+			return "canceled"
+		}
+	}
+	return "unauthorized"
+}
+
+// securityStage validates requests with a security scheme as a middleware, timing the validation and counting
+// failures by reason in the "vertex.security" metrics
+type securityStage struct {
+	scheme SecurityScheme
+	route  string
+}
+
+func (s securityStage) Handle(w http.ResponseWriter, r *Request, next HandlerFunc) (interface{}, error) {
+
+	// calls dispatched in-process with a principal were authenticated by the caller
+	if call := dispatchCallOf(r.Request); call != nil && call.principal != nil {
+		return next(w, r)
+	}
+
+	st := time.Now()
+	err := s.scheme.Validate(r)
+	securityMetrics.Add(s.route, "micros", int64(time.Since(st)/time.Microsecond))
+
+	if err != nil {
+		logging.Warning("Error validating security scheme: %s", err)
+
+		authFailure := true
+		if e, ok := err.(*internalError); ok {
+			// the scheme's error may be shared, so it is changed on a copy
+			ce := *e
+			switch ce.Code {
+			case ErrMissingCredentials:
+				if c, ok := s.scheme.(Challenger); ok && ce.Challenge == "" {
+					ce.Challenge = c.Challenge(r)
+				}
+			case ErrInvalidCredentials, ErrForbidden, ErrTooManyRequests:
+			case ErrInvalidRequest, ErrTemporarilyUnavailable, ErrResourceUnavailable, ErrBackOff, ErrTimeout, ErrCanceled:
+				// failures that are not about the credentials, e.g. an unreadable body or a store outage
+				authFailure = false
+			default:
+				ce.Code = ErrUnauthorized
+			}
+			err = &ce
+		}
+
+		reason := securityFailureReason(err)
+		securityMetrics.Add(s.route, "failed", 1)
+		securityMetrics.Add(s.route, "failed."+reason, 1)
+
+		// forbidden and throttled requests are reported when their error is rendered
+		if authFailure && reason != "forbidden" && errorStatus(err) != http.StatusTooManyRequests {
+			EmitSecurityEvent(NewSecurityEvent(r, EventAuthFailure, reason))
+		}
+		return nil, err
+	}
+
+	securityMetrics.Add(s.route, "passed", 1)
+	return next(w, r)
+}
+
+// withSecurityStage places the security stage of a scheme in a middleware list, where the list marks it with
+// SecurityStage or in front of the list. A nil scheme removes the marker
+func withSecurityStage(mws []Middleware, scheme SecurityScheme, route string) []Middleware {
+
+	ret := make([]Middleware, 0, len(mws)+1)
+	placed := false
+	for _, mw := range mws {
+		if isSecurityStage(mw) {
+			if scheme != nil && !placed {
+				ret = append(ret, securityStage{scheme: scheme, route: route})
+				placed = true
+			}
+			continue
+		}
+		ret = append(ret, mw)
+	}
+
+	if scheme != nil && !placed {
+		ret = append([]Middleware{securityStage{scheme: scheme, route: route}}, ret...)
+	}
+
+	return ret
+}
+
+// SecurityStats returns the number of requests to a route that passed and failed security validation, and the
+// average validation latency
+func SecurityStats(route string) (passed, failed int64, latency time.Duration) {
+
+	passed = securityMetrics.Value(route, "passed")
+	failed = securityMetrics.Value(route, "failed")
+	if calls := passed + failed; calls > 0 {
+		latency = time.Duration(securityMetrics.Value(route, "micros")/calls) * time.Microsecond
+	}
+	return
+}
+
+// SecurityFailures returns the number of requests to a route that failed security validation for a reason
+func SecurityFailures(route, reason string) int64 {
+	return securityMetrics.Value(route, "failed."+reason)
+}
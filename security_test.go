@@ -0,0 +1,195 @@
+package vertex
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecurityStage(t *testing.T) {
+
+	var trace []string
+	tracer := func(name string) Middleware {
+		return MiddlewareFunc(func(w http.ResponseWriter, r *Request, next HandlerFunc) (interface{}, error) {
+			trace = append(trace, name)
+			v, err := next(w, r)
+			if err != nil {
+				trace = append(trace, name+" saw error")
+			}
+			return v, err
+		})
+	}
+
+	security := SecuritySchemeFunc(func(r *Request) error {
+		trace = append(trace, "security")
+		if r.FormValue("key") != "secret" {
+			return NewErrorf("bad key")
+		}
+		return nil
+	})
+
+	handler := HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+		trace = append(trace, "handler")
+		return "ok", nil
+	})
+
+	a := &API{
+		Name:                  "security",
+		Version:               "1.0",
+		Renderer:              JSONRenderer{},
+		AllowInsecure:         true,
+		DefaultSecurityScheme: security,
+		Middleware:            []Middleware{tracer("api")},
+		Routes: Routes{
+			{
+				Path:       "/default",
+				Methods:    GET,
+				Handler:    handler,
+				Middleware: []Middleware{tracer("route")},
+			},
+			{
+				Path:       "/custom",
+				Methods:    GET,
+				Handler:    handler,
+				Middleware: []Middleware{tracer("route"), SecurityStage},
+			},
+			{
+				Path:       "/open",
+				Methods:    GET,
+				Handler:    handler,
+				Security:   NopSecurity,
+				Middleware: []Middleware{tracer("route")},
+			},
+		},
+	}
+
+	srv := NewServer(":9957")
+	srv.AddAPI(a)
+
+	do := func(path string) int {
+		trace = nil
+		req, _ := http.NewRequest("GET", a.FullPath(path), nil)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// by default security runs before all middleware, so none of it runs for unauthenticated requests
+	assert.Equal(t, http.StatusUnauthorized, do("/default?key=wrong"))
+	assert.Equal(t, []string{"security"}, trace)
+
+	assert.Equal(t, http.StatusOK, do("/default?key=secret"))
+	assert.Equal(t, []string{"security", "api", "route", "handler"}, trace)
+
+	// the marker moves it
+	assert.Equal(t, http.StatusUnauthorized, do("/custom"))
+	assert.Equal(t, []string{"api", "route", "security", "route saw error", "api saw error"}, trace)
+
+	assert.Equal(t, http.StatusOK, do("/open"))
+	assert.Equal(t, []string{"api", "route", "handler"}, trace)
+
+	passed, failed, _ := SecurityStats(a.FullPath("/default"))
+	assert.Equal(t, int64(1), passed)
+	assert.Equal(t, int64(1), failed)
+	assert.Equal(t, int64(1), SecurityFailures(a.FullPath("/custom"), "unauthorized"))
+}
+
+type challengeScheme struct{}
+
+// errNoKey is shared by all the requests without a key
+var errNoKey = MissingCredentialsError("no key")
+
+func (challengeScheme) Validate(r *Request) error {
+	switch r.FormValue("key") {
+	case "":
+		return errNoKey
+	case "unreadable":
+		return InvalidRequestError("Could not read request body")
+	case "outage":
+		return TemporarilyUnavailableError(time.Second, "Key store is down")
+	case "secret":
+		return nil
+	case "readonly":
+		return ForbiddenError("read only key")
+	}
+	return InvalidCredentialsError("bad key")
+}
+
+func (challengeScheme) Challenge(r *Request) string {
+	return `Key realm="test"`
+}
+
+func TestAuthFailures(t *testing.T) {
+
+	a := &API{
+		Name:                  "authfail",
+		Version:               "1.0",
+		Renderer:              JSONRenderer{},
+		AllowInsecure:         true,
+		DefaultSecurityScheme: challengeScheme{},
+		Routes: Routes{
+			{
+				Path:    "/foo",
+				Methods: GET,
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					return "ok", nil
+				}),
+			},
+		},
+	}
+
+	srv := NewServer(":9958")
+	srv.AddAPI(a)
+
+	do := func(key string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", a.FullPath("/foo")+"?key="+key, nil)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Key realm="test"`, w.Header().Get("WWW-Authenticate"))
+	// the challenge is set on a copy of the scheme's error
+	assert.Equal(t, "", errNoKey.(*internalError).Challenge)
+
+	// failures that are not about the credentials keep their status
+	assert.Equal(t, http.StatusBadRequest, do("unreadable").Code)
+	w = do("outage")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	w = do("wrong")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "", w.Header().Get("WWW-Authenticate"))
+
+	w = do("readonly")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "", w.Header().Get("WWW-Authenticate"))
+
+	assert.Equal(t, http.StatusOK, do("secret").Code)
+
+	route := a.FullPath("/foo")
+	assert.Equal(t, int64(1), SecurityFailures(route, "missing_credentials"))
+	assert.Equal(t, int64(1), SecurityFailures(route, "invalid_credentials"))
+	assert.Equal(t, int64(1), SecurityFailures(route, "forbidden"))
+	assert.Equal(t, int64(1), SecurityFailures(route, "invalid_request"))
+	assert.Equal(t, int64(1), SecurityFailures(route, "unavailable"))
+
+	assert.Equal(t, SecurityError, classifyError(ForbiddenError("no"), http.StatusForbidden))
+
+	// middleware can attach challenges too
+	w = httptest.NewRecorder()
+	renderError(w, nil, WithChallenge(UnauthorizedError("no"), `Basic realm="x"`))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Basic realm="x"`, w.Header().Get("WWW-Authenticate"))
+
+	// without changing a shared error
+	shared := UnauthorizedError("no")
+	WithChallenge(shared, `Basic realm="x"`)
+	assert.Equal(t, "", shared.(*internalError).Challenge)
+}
@@ -0,0 +1,258 @@
+package vertex
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/EverythingMe/vertex/schema"
+)
+
+// the size of the bodies sent to routes accepting POST, PUT or PATCH requests
+const securityProbeBodySize = 8 << 20
+
+// the header the header injection probe tries to inject into responses
+const injectedHeader = "X-Vertex-Injected"
+
+// routeProber is a tester probing a route for common security issues: handlers reachable without credentials,
+// methods the route does not declare, oversized bodies failing the server, params injected into response headers,
+// and plain http access to APIs that do not allow it.
+//
+// All the probes run, and the test fails with the issues found in the route, so the results are a report per route
+type routeProber struct {
+	route Route
+}
+
+func (p routeProber) Category() string {
+	return SecurityTests
+}
+
+func (p routeProber) Test(t *TestContext) {
+
+	info, err := schema.NewRequestInfo(reflect.TypeOf(p.route.Handler), p.route.Path, p.route.Description,
+		p.route.Returns)
+	if err != nil {
+		t.Skip()
+	}
+
+	issues := []string{}
+	for _, probe := range []func(*TestContext, []schema.ParamInfo) []string{
+		p.probeMissingAuth,
+		p.probeVerbTampering,
+		p.probeOversizedBody,
+		p.probeHeaderInjection,
+		p.probeInsecureAccess,
+	} {
+		issues = append(issues, probe(t, info.Params)...)
+	}
+
+	if len(issues) > 0 {
+		t.Fail("%d security issues: %s", len(issues), strings.Join(issues, "; "))
+	}
+}
+
+// methods returns the methods the route declares, except OPTIONS that may be answered by CORS preflight handling
+func (p routeProber) methods() []string {
+	ret := []string{}
+	for _, m := range methodFlags {
+		if p.route.Methods&m.flag == m.flag && m.flag != OPTIONS {
+			ret = append(ret, m.name)
+		}
+	}
+	return ret
+}
+
+// probeMissingAuth sends requests without the credentials of the test environment to routes with a security scheme,
+// expecting them to be rejected with a 401 or 403
+func (p routeProber) probeMissingAuth(t *TestContext, infos []schema.ParamInfo) []string {
+
+	if p.route.Security == nil && t.api.DefaultSecurityScheme == nil {
+		return nil
+	}
+
+	issues := []string{}
+	values, pathParams := paramValues(infos, validParams(infos))
+	for _, method := range p.methods() {
+		u := t.FormatUrl(pathParams)
+		if len(values) > 0 {
+			u += "?" + values.Encode()
+		}
+		req, err := http.NewRequest(method, u, nil)
+		if err != nil {
+			continue
+		}
+
+		resp, err := t.probe(req, true)
+		if err != nil {
+			issues = append(issues, fmt.Sprintf("%s without credentials: request failed: %s", method, err))
+		} else if resp.StatusCode != http.StatusUnauthorized && resp.StatusCode != http.StatusForbidden {
+			issues = append(issues, fmt.Sprintf("%s without credentials: %s", method, resp.Status))
+		}
+	}
+	return issues
+}
+
+// probeVerbTampering sends requests with the methods the route does not declare, expecting them to fail
+func (p routeProber) probeVerbTampering(t *TestContext, infos []schema.ParamInfo) []string {
+
+	issues := []string{}
+	values, pathParams := paramValues(infos, validParams(infos))
+	for _, m := range methodFlags {
+		if p.route.Methods&m.flag == m.flag || m.flag == OPTIONS {
+			continue
+		}
+		req, err := t.NewRequest(m.name, values, pathParams)
+		if err != nil {
+			continue
+		}
+
+		resp, err := t.probe(req, true)
+		if err == nil && resp.StatusCode < 300 {
+			issues = append(issues, fmt.Sprintf("undeclared method %s: %s", m.name, resp.Status))
+		}
+	}
+	return issues
+}
+
+// probeOversizedBody sends huge bodies to methods with a body, expecting them to be rejected or ignored rather
+// than failing the server
+func (p routeProber) probeOversizedBody(t *TestContext, infos []schema.ParamInfo) []string {
+
+	issues := []string{}
+	_, pathParams := paramValues(infos, validParams(infos))
+	for _, method := range p.methods() {
+		if method != "POST" && method != "PUT" && method != "PATCH" {
+			continue
+		}
+		req, err := t.NewRequest(method, nil, pathParams)
+		if err != nil {
+			continue
+		}
+		req.Body = ioutil.NopCloser(io.LimitReader(fillReader('a'), securityProbeBodySize))
+		req.ContentLength = securityProbeBodySize
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		// servers may drop the connection of an oversized request, which is fine
+		if resp, err := t.probe(req, true); err == nil && resp.StatusCode >= 500 {
+			issues = append(issues, fmt.Sprintf("%s with a %dMB body: %s", method, securityProbeBodySize>>20, resp.Status))
+		}
+	}
+	return issues
+}
+
+// probeHeaderInjection sends every param with a CRLF sequence and a header, expecting the header not to make it into
+// the response
+func (p routeProber) probeHeaderInjection(t *TestContext, infos []schema.ParamInfo) []string {
+
+	methods := p.methods()
+	if len(methods) == 0 {
+		return nil
+	}
+
+	issues := []string{}
+	for i, param := range infos {
+		if param.Hidden {
+			continue
+		}
+		params := validParams(infos)
+		params[i] = "x\r\n" + injectedHeader + ": 1"
+		values, pathParams := paramValues(infos, params)
+		req, err := t.NewRequest(methods[0], values, pathParams)
+		if err != nil {
+			continue
+		}
+
+		resp, err := t.probe(req, true)
+		if err != nil {
+			continue
+		}
+		if resp.Header.Get(injectedHeader) != "" {
+			issues = append(issues, fmt.Sprintf("header injection through %s", param.Name))
+		} else if resp.StatusCode >= 500 {
+			issues = append(issues, fmt.Sprintf("header injection through %s: %s", param.Name, resp.Status))
+		}
+	}
+	return issues
+}
+
+// probeInsecureAccess sends a plain http request to routes of APIs that do not allow insecure access, expecting it to
+// be rejected or redirected to https
+func (p routeProber) probeInsecureAccess(t *TestContext, infos []schema.ParamInfo) []string {
+
+	methods := p.methods()
+	if t.api.AllowInsecure || len(methods) == 0 {
+		return nil
+	}
+
+	values, pathParams := paramValues(infos, validParams(infos))
+	req, err := t.NewRequest(methods[0], values, pathParams)
+	if err != nil {
+		return nil
+	}
+	req.URL.Scheme = "http"
+	req.Header.Del("X-Forwarded-Proto")
+	req.Header.Del("X-Scheme")
+
+	// the server serves plain http requests from the local host
+	if host := req.URL.Hostname(); host == "localhost" || net.ParseIP(host) != nil && net.ParseIP(host).IsLoopback() {
+		return nil
+	}
+
+	// paths the insecure policy allows are served over http on purpose
+	if policy := t.api.InsecurePolicy; policy != nil && policy(NewRequest(req)) == InsecureAllow {
+		return nil
+	}
+
+	// a server not serving plain http at all cannot be accessed insecurely
+	resp, err := t.probe(req, false)
+	if err == nil && resp.StatusCode < 300 {
+		return []string{fmt.Sprintf("%s over plain http: %s", methods[0], resp.Status)}
+	}
+	return nil
+}
+
+// probeClient sends probes without following redirects, so redirects to https are seen as such
+var probeClient = &http.Client{
+	Timeout: 30 * time.Second,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// probe sends a security probe and discards the response body. Secure probes to a plain http server are marked as
+// forwarded over https, so they are not rejected for being insecure before reaching what they probe
+func (t *TestContext) probe(req *http.Request, secure bool) (*http.Response, error) {
+
+	if secure && req.URL.Scheme == "http" && !t.api.AllowInsecure {
+		req.Header.Set("X-Forwarded-Proto", "https")
+	}
+
+	exchange := newTestExchange(req)
+	start := time.Now()
+	resp, err := probeClient.Do(req)
+	if err != nil {
+		exchange.setResponse(nil, nil, err, start)
+		t.recordExchange(exchange)
+		return nil, err
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<20))
+	resp.Body.Close()
+	exchange.setResponse(resp, nil, nil, start)
+	t.recordExchange(exchange)
+	return resp, nil
+}
+
+// fillReader is an endless reader of a single byte
+type fillReader byte
+
+func (f fillReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(f)
+	}
+	return len(p), nil
+}
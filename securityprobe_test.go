@@ -0,0 +1,89 @@
+package vertex
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type probeItemHandler struct {
+	Id int `schema:"id" required:"true"`
+}
+
+func (h probeItemHandler) Handle(w http.ResponseWriter, r *Request) (interface{}, error) {
+	return h.Id, nil
+}
+
+type probeUploadHandler struct{}
+
+func (h probeUploadHandler) Handle(w http.ResponseWriter, r *Request) (interface{}, error) {
+	if r.ContentLength > 1<<20 {
+		return nil, errors.New("out of memory")
+	}
+	return nil, nil
+}
+
+func TestSecurityProbes(t *testing.T) {
+
+	scheme := SecuritySchemeFunc(func(r *Request) error {
+		if r.Header.Get("X-Token") != "secret" {
+			return UnauthorizedError("missing token")
+		}
+		return nil
+	})
+
+	a := &API{
+		Name:                  "probe",
+		Version:               "1.0",
+		Root:                  "/probe",
+		Renderer:              JSONRenderer{},
+		DefaultSecurityScheme: scheme,
+		Routes: Routes{
+			{Path: "/items/{id}", Description: "Item", Methods: GET, Handler: probeItemHandler{}},
+		},
+	}
+	srv := NewServer(":9976")
+	srv.AddAPI(a)
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	env := &TestEnvironment{Name: "probe", URL: ts.URL, Headers: map[string]string{"X-Token": "secret"}}
+
+	buf := bytes.NewBuffer(nil)
+	runner := newTestRunner(buf, a, ts.URL, SecurityTests, TestFormatText)
+	runner.setEnvironment(env)
+	assert.True(t, runner.Run(), buf.String())
+	assert.True(t, regexp.MustCompile(`- /items/{id}\s+\(category: security\)\s+\[PASS\]`).MatchString(buf.String()))
+
+	// probes only run when their category is selected
+	buf.Reset()
+	runner = newTestRunner(buf, a, ts.URL, AllTests, TestFormatText)
+	runner.setEnvironment(env)
+	runner.Run()
+	assert.NotContains(t, buf.String(), "category: security")
+
+	a.Routes = append(a.Routes,
+		Route{Path: "/open", Description: "Open", Methods: GET, Handler: probeItemHandler{}, Security: NopSecurity},
+		Route{Path: "/upload", Description: "Upload", Methods: POST, Handler: probeUploadHandler{}},
+	)
+	srv = NewServer(":9976")
+	srv.AddAPI(a)
+	ts2 := httptest.NewServer(srv.Handler())
+	defer ts2.Close()
+
+	buf.Reset()
+	env.URL = ts2.URL
+	runner = newTestRunner(buf, a, ts2.URL, SecurityTests, TestFormatText)
+	runner.setEnvironment(env)
+	assert.False(t, runner.Run())
+	out := buf.String()
+	assert.Contains(t, out, "GET without credentials: 200 OK")
+	assert.Contains(t, out, "POST with a 8MB body: 500 Internal Server Error")
+	assert.True(t, regexp.MustCompile(`- /items/{id}\s+\(category: security\)\s+\[PASS\]`).MatchString(out))
+	assert.True(t, regexp.MustCompile(`- /upload\s+\(category: security\)\s+\[FAIL\]`).MatchString(out))
+}
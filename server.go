@@ -1,10 +1,13 @@
 package vertex
 
 import (
+	"context"
 	"errors"
+	"expvar"
 	"fmt"
 	"net"
 	"net/http"
+	"os"
 	"runtime/debug"
 	"sync"
 	"time"
@@ -20,7 +23,21 @@ type Server struct {
 	apis     []*API
 	router   *httprouter.Router
 	listener net.Listener
+	srv      *http.Server
 	wg       sync.WaitGroup
+	ready    int32
+	drain    drainState
+	warmup   warmup
+
+	// closed once a graceful shutdown finished, so Run returns only after in-flight requests were handled
+	shutdownDone chan struct{}
+	shutdownOnce sync.Once
+
+	scheduler    *scheduler
+	docsSecurity SecurityScheme
+	usage        *UsageTracker
+	workers      workers
+	ingest       map[Queue]*ingestConsumer
 }
 
 type builderFunc func() *API
@@ -47,18 +64,27 @@ func Register(name string, builder func() *API, config interface{}) {
 // NewServer creates a new blank server to add APIs to
 func NewServer(addr string) *Server {
 	return &Server{
-		addr:   addr,
-		apis:   make([]*API, 0),
-		router: httprouter.New(),
+		addr:      addr,
+		apis:      make([]*API, 0),
+		router:    httprouter.New(),
+		scheduler: newScheduler(),
 	}
 }
 
 // AddAPI adds an API to the server manually. It's preferred to use Register in an init() function
 func (s *Server) AddAPI(a *API) {
+	a.scheduler = s.scheduler
+	a.serverDocsSecurity = s.docsSecurity
 	a.configure(s.router)
+	s.addIngestRoutes(a)
 
 	s.router.PanicHandler = func(w http.ResponseWriter, r *http.Request, v interface{}) {
 
+		// aborting is left to the http server, which drops the connection
+		if v == http.ErrAbortHandler {
+			panic(v)
+		}
+
 		code, msg := httpError(NewErrorf("Unhandled panic: %s\n%s", v, string(debug.Stack())))
 		http.Error(w, msg, code)
 	}
@@ -85,13 +111,45 @@ func (s *Server) Run() (err error) {
 		return errors.New("No APIs defined for server")
 	}
 
-	// Server the console swagger UI
-	s.router.ServeFiles("/console/*filepath", http.Dir(Config.Server.ConsoleFilesPath))
+	if err = checkErrorCodes(); err != nil {
+		return err
+	}
+
+	if path := Config.Server.RouteOverridesFile; path != "" {
+		if err = LoadRouteOverrides(path); err != nil {
+			return err
+		}
+		s.AddWorker("route-overrides", WatchRouteOverrides(path, DefaultOverridesInterval))
+	}
+
+	// Server the console swagger UI and the docs portal
+	console := s.docsHandler("/console", staticHandler{StaticDir{Dir: Config.Server.ConsoleFilesPath, MaxAge: time.Hour}})
+	s.router.GET("/console/*filepath", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		r.URL.Path = p.ByName("filepath")
+		console.ServeHTTP(w, r)
+	})
+	s.router.Handler("GET", PortalPath, s.docsHandler(PortalPath, http.HandlerFunc(s.portalHandler)))
+	s.router.Handler("GET", APIsPath, s.docsHandler(APIsPath, http.HandlerFunc(s.apisHandler)))
+
+	s.registerHealthChecks()
+	s.router.Handler("GET", MetricsPath, requireAdmin(expvar.Handler()))
+	s.router.Handler("GET", ProfilePath, requireAdmin(http.HandlerFunc(profileHandler)))
+	s.router.Handler("GET", InflightPath, requireAdmin(http.HandlerFunc(inflightHandler)))
+	s.router.Handler("GET", LockoutsPath, requireAdmin(http.HandlerFunc(lockoutsHandler)))
+	s.router.Handler("DELETE", LockoutsPath, requireAdmin(http.HandlerFunc(lockoutsHandler)))
+	s.router.Handler("GET", SpecDiffPath, requireAdmin(http.HandlerFunc(s.specDiffHandler)))
+	s.router.Handler("POST", SpecDiffPath, requireAdmin(http.HandlerFunc(s.specDiffHandler)))
+	s.router.Handler("GET", UsagePath, requireAdmin(http.HandlerFunc(s.usageHandler)))
+	s.router.Handler("GET", StatsPath, requireAdmin(http.HandlerFunc(s.statsHandler)))
+	s.router.Handler("GET", SLOPath, requireAdmin(http.HandlerFunc(sloHandler)))
+	for _, method := range []string{"GET", "POST", "DELETE"} {
+		s.router.Handler(method, DrainPath, requireAdmin(http.HandlerFunc(s.drainHandler)))
+	}
 
 	// Start a stoppable listener
 	var l net.Listener
 
-	if l, err = net.Listen("tcp", s.addr); err != nil {
+	if l, err = s.listen(); err != nil {
 		return fmt.Errorf("Could not listen in server: %s", err)
 	}
 
@@ -100,28 +158,104 @@ func (s *Server) Run() (err error) {
 	}
 
 	logging.Info("Starting server on %s", s.listener.Addr().String())
+	s.printBanner(os.Stdout, s.listener.Addr().String())
+
+	// warmup runs while serving, so liveness checks pass, and marks the server ready once it is done
+	ctx, cancel := context.WithCancel(context.Background())
 
 	s.wg.Add(1)
 	defer func() {
+		cancel()
+		s.setReady(false)
 		s.wg.Done()
 		// don't return an error on server stopped
-		if err == stoppableListener.StoppedError {
+		if err == stoppableListener.StoppedError || err == http.ErrServerClosed {
 			err = nil
 		}
+		// unless it was stopped by a failed warmup
+		if werr := s.warmupError(); werr != nil && err == nil {
+			err = werr
+		}
 	}()
 
-	srv := http.Server{
+	// the server may have been drained before it started
+	s.drain.Lock()
+	s.shutdownDone = make(chan struct{})
+	s.shutdownOnce = sync.Once{}
+	s.srv = &http.Server{
 		Handler:      s.router,
 		ReadTimeout:  time.Duration(Config.Server.ClientTimeout) * time.Second,
 		WriteTimeout: time.Duration(Config.Server.ClientTimeout) * time.Second, // maximum duration before timing out write of the response
 	}
-	return srv.Serve(s.listener)
+	s.applyKeepAlive()
+	s.drain.Unlock()
+
+	s.workers.start()
+	go s.warmUp(ctx)
+
+	err = s.srv.Serve(s.listener)
+	switch err {
+	case http.ErrServerClosed:
+		// Serve returns as soon as a graceful shutdown starts - wait for it to finish handling the requests in
+		// flight, so the process does not exit under them
+		<-s.shutdownDone
+	case stoppableListener.StoppedError:
+	default:
+		deferredWork.flush(time.Duration(Config.Server.ShutdownGrace) * time.Second)
+		s.workers.stop(time.Duration(Config.Server.ShutdownGrace) * time.Second)
+		closeResources()
+	}
+	return err
 
 }
 
+// listen creates the server's listening socket, or takes over the one inherited from a parent process
+// or passed to us by systemd socket activation
+func (s *Server) listen() (net.Listener, error) {
+
+	if l, err := inheritedListener(); l != nil || err != nil {
+		return l, err
+	}
+
+	if l, err := systemdListener(); l != nil || err != nil {
+		return l, err
+	}
+
+	return net.Listen("tcp", s.addr)
+}
+
+// shutdown stops accepting new connections and waits up to grace for in-flight requests to finish. Run returns once
+// it is done. When upgrading, systemd is not told we are stopping, since the upgraded process took over as the main
+// process of the service
+func (s *Server) shutdown(grace time.Duration, upgrading bool) error {
+
+	if s.srv == nil {
+		return errors.New("Server is not running")
+	}
+
+	s.setReady(false)
+	if !upgrading {
+		notifySystemd(sdStopping)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	defer s.shutdownOnce.Do(func() { close(s.shutdownDone) })
+
+	err := s.srv.Shutdown(ctx)
+	deferredWork.flush(grace)
+	s.workers.stop(grace)
+	closeResources()
+	return err
+}
+
 // Stop waits up to a second and closes the server
 func (s *Server) Stop() {
 
+	notifySystemd(sdStopping)
+
 	s.listener.(*stoppableListener.StoppableListener).Stop()
 	s.wg.Wait()
+	s.workers.stop(time.Second)
+	closeResources()
 }
@@ -0,0 +1,289 @@
+package vertex
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// Default SharedStore settings
+const (
+	DefaultStoreFailureThreshold = 5
+	DefaultStoreCooldown         = 10 * time.Second
+)
+
+// ContextStore is a store whose commands can be bound to a context, e.g. a redis client passing it to its
+// commands, so they are canceled with the request running them
+type ContextStore interface {
+	Store
+
+	// WithContext returns the store running its commands with a context
+	WithContext(ctx context.Context) Store
+}
+
+// StoreOptions configures the circuit breaker of a shared store
+type StoreOptions struct {
+	// Consecutive failed commands opening the circuit. Defaults to DefaultStoreFailureThreshold
+	FailureThreshold int
+
+	// How long commands are rejected once the circuit opens, before a single command is let through to probe the
+	// store. Defaults to DefaultStoreCooldown
+	Cooldown time.Duration
+}
+
+// SharedStore is an instrumented wrapper of the Store shared by the instances of a service, usually backed by
+// redis, for handlers and framework components alike. It is provided for injection by name:
+//
+//	vertex.NewSharedStore("redis", redisStore, vertex.StoreOptions{})
+//
+//	type GetUserHandler struct {
+//		Id    string             `schema:"id" required:"true"`
+//		Redis *vertex.SharedStore `inject:"redis"`
+//	}
+//
+//	func (h GetUserHandler) Handle(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+//		cached, found, err := h.Redis.For(r).Get("user:" + h.Id)
+//		...
+//	}
+//
+// Commands bound to a request are counted in the metrics of its route, and are not sent once the request is
+// canceled. When the store keeps failing, its circuit opens and commands fail fast with a temporarily unavailable
+// error rather than piling up on it
+type SharedStore struct {
+	name    string
+	store   Store
+	breaker *circuitBreaker
+}
+
+// name => route => commands/errors/rejected/micros => value
+var sharedStoreMetrics = newCounterMap("vertex.stores")
+
+// NewSharedStore wraps a store with a shared store, and provides it for injection by name
+func NewSharedStore(name string, store Store, opts StoreOptions) *SharedStore {
+
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = DefaultStoreFailureThreshold
+	}
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = DefaultStoreCooldown
+	}
+
+	s := &SharedStore{
+		name:    name,
+		store:   store,
+		breaker: &circuitBreaker{name: "store " + name, threshold: opts.FailureThreshold, cooldown: opts.Cooldown},
+	}
+	Provide(name, s)
+	return s
+}
+
+// For returns the store bound to a request. r may be nil for commands run outside of requests
+func (s *SharedStore) For(r *Request) Store {
+
+	if r == nil {
+		return s.bind(context.Background(), backgroundRoute)
+	}
+	return s.bind(r.Context(), r.route)
+}
+
+func (s *SharedStore) bind(ctx context.Context, route string) *boundStore {
+
+	store := s.store
+	if cs, ok := store.(ContextStore); ok {
+		store = cs.WithContext(ctx)
+	}
+	return &boundStore{shared: s, ctx: ctx, route: route, store: store}
+}
+
+// The shared store is a Store itself, running commands outside of requests, so it can be passed to framework
+// components taking a Store
+
+func (s *SharedStore) Get(key string) ([]byte, bool, error) {
+	return s.For(nil).Get(key)
+}
+
+func (s *SharedStore) Set(key string, value []byte, ttl time.Duration) error {
+	return s.For(nil).Set(key, value, ttl)
+}
+
+func (s *SharedStore) SetIfAbsent(key string, value []byte, ttl time.Duration) (bool, error) {
+	return s.For(nil).SetIfAbsent(key, value, ttl)
+}
+
+func (s *SharedStore) Incr(key string, ttl time.Duration) (int64, error) {
+	return s.For(nil).Incr(key, ttl)
+}
+
+func (s *SharedStore) Delete(key string) error {
+	return s.For(nil).Delete(key)
+}
+
+func (s *SharedStore) Keys(prefix string) ([]string, error) {
+	return s.For(nil).Keys(prefix)
+}
+
+// SharedStoreStats returns the number of commands a shared store ran for a route, the number that failed, the
+// number rejected by the circuit breaker, and their average latency
+func SharedStoreStats(name, route string) (commands, failed, rejected int64, latency time.Duration) {
+
+	key := name + " " + route
+	commands = sharedStoreMetrics.Value(key, "commands")
+	failed = sharedStoreMetrics.Value(key, "errors")
+	rejected = sharedStoreMetrics.Value(key, "rejected")
+	if commands > 0 {
+		latency = time.Duration(sharedStoreMetrics.Value(key, "micros")/commands) * time.Microsecond
+	}
+	return
+}
+
+// boundStore runs the commands of a shared store in the context of a request
+type boundStore struct {
+	shared *SharedStore
+	ctx    context.Context
+	route  string
+	store  Store
+}
+
+// do runs a command through the circuit breaker, measuring it
+func (b *boundStore) do(cmd string, f func() error) error {
+
+	if err := b.ctx.Err(); err != nil {
+		return err
+	}
+
+	key := b.shared.name + " " + b.route
+	if !b.shared.breaker.allow() {
+		sharedStoreMetrics.Add(key, "rejected", 1)
+		return TemporarilyUnavailableError(b.shared.breaker.cooldown, "Store %s is unavailable", b.shared.name)
+	}
+
+	// a probe must not keep the circuit open if its command panics
+	recorded := false
+	defer func() {
+		if !recorded {
+			b.shared.breaker.release()
+		}
+	}()
+
+	st := time.Now()
+	err := f()
+	recorded = true
+
+	// commands cut short by their request say nothing about the health of the backend
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || b.ctx.Err() != nil {
+		b.shared.breaker.release()
+	} else {
+		b.shared.breaker.record(err == nil)
+	}
+
+	sharedStoreMetrics.Add(key, "commands", 1)
+	sharedStoreMetrics.Add(key, cmd, 1)
+	sharedStoreMetrics.Add(key, "micros", int64(time.Since(st)/time.Microsecond))
+	if err != nil {
+		sharedStoreMetrics.Add(key, "errors", 1)
+		logging.Warning("Store %s failed %s in %s: %s", b.shared.name, cmd, b.route, err)
+	}
+	return err
+}
+
+func (b *boundStore) Get(key string) (value []byte, found bool, err error) {
+	err = b.do("get", func() error {
+		value, found, err = b.store.Get(key)
+		return err
+	})
+	return
+}
+
+func (b *boundStore) Set(key string, value []byte, ttl time.Duration) error {
+	return b.do("set", func() error {
+		return b.store.Set(key, value, ttl)
+	})
+}
+
+func (b *boundStore) SetIfAbsent(key string, value []byte, ttl time.Duration) (set bool, err error) {
+	err = b.do("setifabsent", func() error {
+		set, err = b.store.SetIfAbsent(key, value, ttl)
+		return err
+	})
+	return
+}
+
+func (b *boundStore) Incr(key string, ttl time.Duration) (n int64, err error) {
+	err = b.do("incr", func() error {
+		n, err = b.store.Incr(key, ttl)
+		return err
+	})
+	return
+}
+
+func (b *boundStore) Delete(key string) error {
+	return b.do("delete", func() error {
+		return b.store.Delete(key)
+	})
+}
+
+func (b *boundStore) Keys(prefix string) (keys []string, err error) {
+	err = b.do("keys", func() error {
+		keys, err = b.store.Keys(prefix)
+		return err
+	})
+	return
+}
+
+// circuitBreaker opens after consecutive failures, rejecting calls for a cooldown. After the cooldown a single
+// call probes the backend, closing the circuit if it succeeds and opening it again if it fails
+type circuitBreaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	mtx       sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// allow tells if a call may run
+func (c *circuitBreaker) allow() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.failures < c.threshold {
+		return true
+	}
+	if c.probing || time.Now().Before(c.openUntil) {
+		return false
+	}
+	c.probing = true
+	return true
+}
+
+// release ends a call without recording its result, letting another call probe the backend
+func (c *circuitBreaker) release() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.probing = false
+}
+
+// record records the result of a call
+func (c *circuitBreaker) record(ok bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.probing = false
+	if ok {
+		c.failures = 0
+		return
+	}
+	c.failures++
+	if c.failures >= c.threshold {
+		if c.failures == c.threshold {
+			logging.Error("Opening the circuit of %s after %d consecutive failures", c.name, c.failures)
+		}
+		c.openUntil = time.Now().Add(c.cooldown)
+	}
+}
@@ -0,0 +1,148 @@
+package vertex
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// failingStore fails its commands while down, and records the context it was bound to
+type failingStore struct {
+	*MemoryStore
+	down bool
+	ctx  context.Context
+}
+
+func (s *failingStore) Get(key string) ([]byte, bool, error) {
+	if s.down {
+		return nil, false, errors.New("connection refused")
+	}
+	return s.MemoryStore.Get(key)
+}
+
+func (s *failingStore) WithContext(ctx context.Context) Store {
+	s.ctx = ctx
+	return s
+}
+
+func TestSharedStore(t *testing.T) {
+
+	backend := &failingStore{MemoryStore: NewMemoryStore()}
+	s := NewSharedStore("test.redis", backend, StoreOptions{FailureThreshold: 2, Cooldown: 20 * time.Millisecond})
+
+	hr, _ := http.NewRequest("GET", "/users/1", nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	r := NewRequest(hr.WithContext(ctx))
+	r.route = "/api/users/:id"
+
+	// commands are bound to the request, and counted by its route
+	assert.NoError(t, s.For(r).Set("user:1", []byte("bob"), 0))
+	v, found, err := s.For(r).Get("user:1")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "bob", string(v))
+	assert.True(t, backend.ctx == r.Context())
+
+	// the shared store is a store itself
+	n, err := s.Incr("counter", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	commands, _, _, _ := SharedStoreStats("test.redis", backgroundRoute)
+	assert.Equal(t, int64(1), commands)
+
+	// the circuit opens after consecutive failures, failing fast
+	backend.down = true
+	for i := 0; i < 2; i++ {
+		_, _, err = s.For(r).Get("user:1")
+		assert.EqualError(t, err, "connection refused")
+	}
+	_, _, err = s.For(r).Get("user:1")
+	assert.Equal(t, http.StatusServiceUnavailable, errorStatus(err))
+	assert.Equal(t, 20*time.Millisecond, RetryAfter(err))
+
+	commands, failed, rejected, latency := SharedStoreStats("test.redis", "/api/users/:id")
+	assert.Equal(t, int64(4), commands)
+	assert.Equal(t, int64(2), failed)
+	assert.Equal(t, int64(1), rejected)
+	assert.True(t, latency >= 0)
+
+	// and closes when a probe succeeds after the cooldown
+	backend.down = false
+	time.Sleep(25 * time.Millisecond)
+	_, _, err = s.For(r).Get("user:1")
+	assert.NoError(t, err)
+	_, _, err = s.For(r).Get("user:1")
+	assert.NoError(t, err)
+
+	// commands of canceled requests are not sent
+	cancel()
+	_, _, err = s.For(r).Get("user:1")
+	assert.Equal(t, context.Canceled, err)
+
+	// shared stores are provided for injection
+	var h struct {
+		Redis *SharedStore `inject:"test.redis"`
+	}
+	assert.NoError(t, inject(&h, []injection{{field: 0, name: "test.redis"}}))
+	assert.True(t, h.Redis == s)
+}
+
+func TestCircuitBreakerProbe(t *testing.T) {
+
+	c := &circuitBreaker{name: "test", threshold: 1, cooldown: 10 * time.Millisecond}
+	assert.True(t, c.allow())
+	c.record(false)
+	assert.False(t, c.allow())
+
+	// a single probe is let through after the cooldown, and a failed probe opens the circuit again
+	time.Sleep(15 * time.Millisecond)
+	assert.True(t, c.allow())
+	assert.False(t, c.allow())
+	c.record(false)
+	assert.False(t, c.allow())
+
+	// released probes let another probe through
+	time.Sleep(15 * time.Millisecond)
+	assert.True(t, c.allow())
+	c.release()
+	assert.True(t, c.allow())
+}
+
+// ctxStore fails its commands with an error, or panics
+type ctxStore struct {
+	*MemoryStore
+	err error
+}
+
+func (s *ctxStore) Get(key string) ([]byte, bool, error) {
+	if s.err == nil {
+		panic("store panicked")
+	}
+	return nil, false, s.err
+}
+
+func TestSharedStoreBreakerErrors(t *testing.T) {
+
+	backend := &ctxStore{MemoryStore: NewMemoryStore(), err: context.Canceled}
+	s := NewSharedStore("ctxstore", backend, StoreOptions{FailureThreshold: 1, Cooldown: 10 * time.Millisecond})
+
+	// canceled and expired commands do not open the circuit
+	_, _, err := s.Get("a")
+	assert.Error(t, err)
+	backend.err = context.DeadlineExceeded
+	_, _, err = s.Get("a")
+	assert.Error(t, err)
+	assert.True(t, s.breaker.allow())
+	s.breaker.release()
+
+	// a panicking probe does not keep the circuit open
+	s.breaker.record(false)
+	time.Sleep(15 * time.Millisecond)
+	backend.err = nil
+	assert.Panics(t, func() { s.Get("a") })
+	assert.True(t, s.breaker.allow())
+}
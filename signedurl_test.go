@@ -0,0 +1,80 @@
+package vertex
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestURLSigner(t *testing.T) {
+
+	ring := NewKeyRing("links", Key{ID: "k1", Secret: testSecret("one")})
+	signer := URLSigner{KeyRing: ring}
+
+	link, err := signer.Sign("https://api.example.com/files/12?name=report.pdf", time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(link, "https://api.example.com/files/12?"))
+
+	verify := func(link string) error {
+		u, _ := url.Parse(link)
+		return signer.Verify(u)
+	}
+	assert.NoError(t, verify(link))
+
+	// the host is not signed
+	assert.NoError(t, verify(strings.Replace(link, "api.example.com", "internal:8080", 1)))
+
+	assert.Error(t, verify(strings.Replace(link, "/files/12", "/files/13", 1)))
+	assert.Error(t, verify(strings.Replace(link, "report.pdf", "secret.pdf", 1)))
+	assert.Error(t, verify(link+"&admin=true"))
+	assert.Equal(t, http.StatusUnauthorized, errorStatus(verify("https://api.example.com/files/12")))
+
+	expired, _ := signer.Sign("/files/12", -time.Minute)
+	assert.EqualError(t, verify(expired), "The URL has expired")
+
+	// links outlive rotations until their key is retired
+	assert.NoError(t, ring.Rotate(Key{ID: "k2", Secret: testSecret("two")}))
+	assert.NoError(t, verify(link))
+	assert.NoError(t, ring.Retire("k1"))
+	assert.Error(t, verify(link))
+
+	_, err = URLSigner{KeyRing: NewKeyRing("empty")}.Sign("/files/12", time.Hour)
+	assert.Error(t, err)
+}
+
+func TestURLSignerScheme(t *testing.T) {
+
+	signer := URLSigner{KeyRing: NewKeyRing("downloads", Key{ID: "k1", Secret: testSecret("one")})}
+	a := &API{
+		Name:          "signed",
+		Version:       "1.0",
+		Root:          "/signed",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{Path: "/downloads/{id}", Description: "Download", Methods: GET, Security: signer,
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					return "file", nil
+				})},
+		},
+	}
+	srv := NewServer(":9994")
+	srv.AddAPI(a)
+
+	get := func(link string) int {
+		hr, _ := http.NewRequest("GET", link, nil)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, hr)
+		return w.Code
+	}
+
+	link, _ := signer.Sign(a.FullPath("/downloads/12"), time.Hour)
+	assert.Equal(t, http.StatusOK, get(link))
+	assert.Equal(t, http.StatusUnauthorized, get(a.FullPath("/downloads/12")))
+	assert.Equal(t, http.StatusUnauthorized, get(strings.Replace(link, "/12", "/13", 1)))
+}
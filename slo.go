@@ -0,0 +1,387 @@
+package vertex
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// SLOPath is the admin endpoint reporting the error budgets and burn rates of the routes declaring an SLO:
+//
+//	GET /debug/vertex/slo
+const SLOPath = "/debug/vertex/slo"
+
+// DefaultSLOPeriod is the period error budgets are computed over, for SLOs that do not set one
+const DefaultSLOPeriod = 28 * 24 * time.Hour
+
+// SLO alert severities
+const (
+	// The budget burns fast enough to run out within days. Worth waking someone up for
+	SLOPage = "page"
+
+	// The budget burns steadily, and will run out before the period ends if nothing is done
+	SLOTicket = "ticket"
+)
+
+// SLO is a latency objective of a route: the fraction of its requests that should succeed within a latency, e.g.
+// 99% in under 300ms:
+//
+//	SLO: &vertex.SLO{Objective: 0.99, Latency: 300 * time.Millisecond},
+//
+// Requests are bad if they take longer than the latency or fail with a 5xx status. The fraction of bad requests
+// the objective allows over the period is the route's error budget. Budgets are alerted on by their burn rate - how
+// many times faster than allowed they are spent - over a long window confirmed by a short one, so alerts fire fast
+// and resolve fast:
+//
+//	page:   over 14.4 in the last hour and 5 minutes, 2% of a 28 day budget spent in an hour
+//	ticket: over 6 in the last 6 hours and 30 minutes, 5% of a 28 day budget spent in 6 hours
+//
+// See SubscribeSLOAlerts
+type SLO struct {
+	// Fraction of requests that should be good, between 0 and 1 exclusive
+	Objective float64
+
+	// Maximum latency of good requests
+	Latency time.Duration
+
+	// Period the error budget is computed over. Defaults to DefaultSLOPeriod
+	Period time.Duration
+}
+
+// sloWindows are the windows burn rates are reported over, by name
+var sloWindows = []struct {
+	name string
+	d    time.Duration
+}{
+	{"5m", 5 * time.Minute},
+	{"30m", 30 * time.Minute},
+	{"1h", time.Hour},
+	{"6h", 6 * time.Hour},
+}
+
+// sloAlertRules are the burn rates alerted on, over a long window and a short one confirming the budget still burns
+var sloAlertRules = []struct {
+	severity    string
+	long, short string
+	burnRate    float64
+}{
+	{SLOPage, "1h", "5m", 14.4},
+	{SLOTicket, "6h", "30m", 6},
+}
+
+// number of minute slots kept per route, covering the longest window
+const sloMinuteSlots = 6 * 60
+
+// SLOStatus is the state of the error budget of a route
+type SLOStatus struct {
+	Route     string  `json:"route"`
+	Objective float64 `json:"objective"`
+	Latency   float64 `json:"latency_ms"`
+	Period    string  `json:"period"`
+
+	// Requests and bad requests over the period
+	Requests int64 `json:"requests"`
+	Bad      int64 `json:"bad"`
+
+	// Fraction of good requests over the period, 1 if there were none
+	Compliance float64 `json:"compliance"`
+
+	// Fraction of the error budget left for the period. Negative once the budget is exhausted
+	BudgetRemaining float64 `json:"budget_remaining"`
+
+	// Burn rates by window
+	BurnRates map[string]float64 `json:"burn_rates"`
+
+	// Severities of the alerts firing
+	Alerts []string `json:"alerts,omitempty"`
+}
+
+// SLOAlert is raised when the error budget of a route starts burning faster than an alert rule allows, and again
+// with Resolved set once it no longer does
+type SLOAlert struct {
+	Time     time.Time `json:"time"`
+	Route    string    `json:"route"`
+	Severity string    `json:"severity"`
+	Resolved bool      `json:"resolved"`
+
+	// The burn rate over the long window of the rule
+	BurnRate float64   `json:"burn_rate"`
+	Status   SLOStatus `json:"status"`
+}
+
+// sloSlot counts the requests of a route in a minute or an hour
+type sloSlot struct {
+	at         int64
+	total, bad int64
+}
+
+// sloTracker tracks the requests of a route with an SLO
+type sloTracker struct {
+	slo     SLO
+	minutes [sloMinuteSlots]sloSlot
+	hours   []sloSlot
+
+	// the minute the alert rules were last evaluated in, and the rules firing by severity
+	evaluated int64
+	alerting  map[string]bool
+}
+
+// route => tracker
+var sloTrackers = struct {
+	sync.Mutex
+	routes map[string]*sloTracker
+}{
+	routes: map[string]*sloTracker{},
+}
+
+var sloAlertHandlers = struct {
+	sync.RWMutex
+	handlers map[*func(SLOAlert)]struct{}
+}{
+	handlers: map[*func(SLOAlert)]struct{}{},
+}
+
+func init() {
+	expvar.Publish("vertex.slo", expvar.Func(func() interface{} {
+		return SLOReport()
+	}))
+}
+
+// SubscribeSLOAlerts calls a handler for every SLO alert raised or resolved, e.g. to page someone. Alert rules are
+// evaluated once a minute as requests of a route come in, and handlers are called on their own goroutines, so slow
+// handlers do not slow down requests. Alerts are logged whether or not anyone subscribed.
+//
+// It returns a func that cancels the subscription
+func SubscribeSLOAlerts(handler func(SLOAlert)) func() {
+
+	h := &handler
+	sloAlertHandlers.Lock()
+	sloAlertHandlers.handlers[h] = struct{}{}
+	sloAlertHandlers.Unlock()
+
+	return func() {
+		sloAlertHandlers.Lock()
+		delete(sloAlertHandlers.handlers, h)
+		sloAlertHandlers.Unlock()
+	}
+}
+
+// trackSLO starts tracking the requests of a route with an SLO. Tracking an unchanged SLO again keeps its counts.
+// SLOs with an objective leaving no error budget are not tracked
+func trackSLO(route string, slo SLO) {
+
+	if slo.Objective <= 0 || slo.Objective >= 1 {
+		logging.Error("Not tracking the SLO of %s: objective %v is not between 0 and 1", route, slo.Objective)
+		return
+	}
+	if slo.Period <= 0 {
+		slo.Period = DefaultSLOPeriod
+	}
+
+	sloTrackers.Lock()
+	defer sloTrackers.Unlock()
+
+	if t, found := sloTrackers.routes[route]; found && t.slo == slo {
+		return
+	}
+	hours := int((slo.Period + time.Hour - 1) / time.Hour)
+	sloTrackers.routes[route] = &sloTracker{
+		slo:      slo,
+		hours:    make([]sloSlot, hours),
+		alerting: map[string]bool{},
+	}
+}
+
+// recordSLO counts a request of a route that finished at a given time, if the route has an SLO. The alert rules of
+// the route are evaluated before counting the first request of every minute
+func recordSLO(route string, at time.Time, latency time.Duration, status int) {
+
+	sloTrackers.Lock()
+	t, found := sloTrackers.routes[route]
+	if !found {
+		sloTrackers.Unlock()
+		return
+	}
+
+	var alerts []SLOAlert
+	minute := at.Unix() / 60
+	if minute > t.evaluated {
+		t.evaluated = minute
+		alerts = t.evaluate(route, at)
+	}
+
+	bad := latency > t.slo.Latency || status >= 500
+	t.minutes[minute%sloMinuteSlots].add(minute, bad)
+	hour := at.Unix() / 3600
+	t.hours[hour%int64(len(t.hours))].add(hour, bad)
+	sloTrackers.Unlock()
+
+	for _, alert := range alerts {
+		notifySLOAlert(alert)
+	}
+}
+
+// add counts a request in a slot, resetting it first if it counted an older minute or hour
+func (s *sloSlot) add(at int64, bad bool) {
+	if s.at != at {
+		*s = sloSlot{at: at}
+	}
+	s.total++
+	if bad {
+		s.bad++
+	}
+}
+
+// window sums the requests of the minutes of a window ending at now, the current minute included
+func (t *sloTracker) window(now time.Time, d time.Duration) (total, bad int64) {
+	last := now.Unix() / 60
+	first := last - int64(d/time.Minute) + 1
+	for _, s := range t.minutes {
+		if s.at >= first && s.at <= last {
+			total += s.total
+			bad += s.bad
+		}
+	}
+	return
+}
+
+// burnRate returns how many times faster than allowed the budget is spent with a fraction of bad requests
+func (t *sloTracker) burnRate(total, bad int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(bad) / float64(total) / (1 - t.slo.Objective)
+}
+
+// status computes the state of the route's error budget at a given time
+func (t *sloTracker) status(route string, now time.Time) SLOStatus {
+
+	ret := SLOStatus{
+		Route:           route,
+		Objective:       t.slo.Objective,
+		Latency:         float64(t.slo.Latency) / float64(time.Millisecond),
+		Period:          t.slo.Period.String(),
+		Compliance:      1,
+		BudgetRemaining: 1,
+		BurnRates:       make(map[string]float64, len(sloWindows)),
+	}
+
+	last := now.Unix() / 3600
+	first := last - int64(len(t.hours)) + 1
+	for _, s := range t.hours {
+		if s.at >= first && s.at <= last {
+			ret.Requests += s.total
+			ret.Bad += s.bad
+		}
+	}
+	if ret.Requests > 0 {
+		ret.Compliance = 1 - float64(ret.Bad)/float64(ret.Requests)
+		ret.BudgetRemaining = 1 - t.burnRate(ret.Requests, ret.Bad)
+	}
+
+	for _, w := range sloWindows {
+		ret.BurnRates[w.name] = t.burnRate(t.window(now, w.d))
+	}
+	for _, rule := range sloAlertRules {
+		if t.alerting[rule.severity] {
+			ret.Alerts = append(ret.Alerts, rule.severity)
+		}
+	}
+	return ret
+}
+
+// evaluate checks the alert rules of the route, returning the alerts raised or resolved since they were last checked
+func (t *sloTracker) evaluate(route string, now time.Time) (ret []SLOAlert) {
+
+	st := t.status(route, now)
+	for _, rule := range sloAlertRules {
+		firing := st.BurnRates[rule.long] > rule.burnRate && st.BurnRates[rule.short] > rule.burnRate
+		if firing == t.alerting[rule.severity] {
+			continue
+		}
+		t.alerting[rule.severity] = firing
+		ret = append(ret, SLOAlert{
+			Time:     now,
+			Route:    route,
+			Severity: rule.severity,
+			Resolved: !firing,
+			BurnRate: st.BurnRates[rule.long],
+		})
+	}
+
+	// the alerts carry the status they changed
+	if len(ret) > 0 {
+		st = t.status(route, now)
+		for i := range ret {
+			ret[i].Status = st
+		}
+	}
+	return
+}
+
+// notifySLOAlert logs an alert and passes it to the subscribed handlers
+func notifySLOAlert(alert SLOAlert) {
+
+	if alert.Resolved {
+		logging.Info("SLO %s alert of %s resolved, burn rate %.1f", alert.Severity, alert.Route, alert.BurnRate)
+	} else {
+		logging.Warning("SLO %s alert of %s: error budget burning %.1f times faster than allowed, %.1f%% left",
+			alert.Severity, alert.Route, alert.BurnRate, alert.Status.BudgetRemaining*100)
+	}
+
+	sloAlertHandlers.RLock()
+	defer sloAlertHandlers.RUnlock()
+	for h := range sloAlertHandlers.handlers {
+		go (*h)(alert)
+	}
+}
+
+// SLOStatusOf returns the state of the error budget of a route, and false if the route has no SLO. The route is its
+// full path in the API
+func SLOStatusOf(route string) (SLOStatus, bool) {
+
+	sloTrackers.Lock()
+	defer sloTrackers.Unlock()
+
+	t, found := sloTrackers.routes[route]
+	if !found {
+		return SLOStatus{}, false
+	}
+	return t.status(route, time.Now()), true
+}
+
+// SLOReport returns the state of the error budgets of all the routes with an SLO, those burning them fastest first
+func SLOReport() []SLOStatus {
+
+	sloTrackers.Lock()
+	now := time.Now()
+	ret := make([]SLOStatus, 0, len(sloTrackers.routes))
+	for route, t := range sloTrackers.routes {
+		ret = append(ret, t.status(route, now))
+	}
+	sloTrackers.Unlock()
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].BurnRates["1h"] != ret[j].BurnRates["1h"] {
+			return ret[i].BurnRates["1h"] > ret[j].BurnRates["1h"]
+		}
+		return ret[i].Route < ret[j].Route
+	})
+	return ret
+}
+
+// sloHandler serves the SLO report, see SLOPath
+func sloHandler(w http.ResponseWriter, r *http.Request) {
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(SLOReport()); err != nil {
+		logging.Error("Could not write SLO report: %s", err)
+	}
+}
@@ -0,0 +1,134 @@
+package vertex
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSLOBurnRates(t *testing.T) {
+
+	alerts := make(chan SLOAlert, 10)
+	defer SubscribeSLOAlerts(func(a SLOAlert) {
+		if a.Route == "/slo/burn" {
+			alerts <- a
+		}
+	})()
+
+	next := func() (ret []SLOAlert) {
+		for {
+			select {
+			case a := <-alerts:
+				ret = append(ret, a)
+			case <-time.After(50 * time.Millisecond):
+				return
+			}
+		}
+	}
+
+	trackSLO("/slo/burn", SLO{Objective: 0.99, Latency: 100 * time.Millisecond})
+	at := time.Now().Add(-3 * time.Minute)
+	record := func(at time.Time, n int, latency time.Duration, status int) {
+		for i := 0; i < n; i++ {
+			recordSLO("/slo/burn", at, latency, status)
+		}
+	}
+
+	// 10% of requests too slow burns a 1% budget 10 times faster than allowed
+	record(at, 90, 50*time.Millisecond, 200)
+	record(at, 10, 200*time.Millisecond, 200)
+	assert.Empty(t, next())
+
+	record(at.Add(time.Minute), 1, 50*time.Millisecond, 200)
+	raised := next()
+	assert.Len(t, raised, 1)
+	assert.Equal(t, SLOTicket, raised[0].Severity)
+	assert.False(t, raised[0].Resolved)
+	assert.InDelta(t, 10, raised[0].BurnRate, 0.01)
+	assert.Equal(t, []string{SLOTicket}, raised[0].Status.Alerts)
+
+	// server errors are bad too, and burn fast enough to page
+	record(at.Add(time.Minute), 10, time.Millisecond, 500)
+	record(at.Add(2*time.Minute), 1, 50*time.Millisecond, 200)
+	raised = next()
+	assert.Len(t, raised, 1)
+	assert.Equal(t, SLOPage, raised[0].Severity)
+
+	// the page resolves once the short window is clean, the ticket is still burning
+	record(at.Add(8*time.Minute), 1, 50*time.Millisecond, 200)
+	raised = next()
+	assert.Len(t, raised, 1)
+	assert.Equal(t, SLOPage, raised[0].Severity)
+	assert.True(t, raised[0].Resolved)
+
+	sloTrackers.Lock()
+	st := sloTrackers.routes["/slo/burn"].status("/slo/burn", at.Add(8*time.Minute))
+	sloTrackers.Unlock()
+	assert.Equal(t, int64(113), st.Requests)
+	assert.Equal(t, int64(20), st.Bad)
+	assert.InDelta(t, 1-20.0/113, st.Compliance, 0.0001)
+	assert.InDelta(t, 1-(20.0/113)/0.01, st.BudgetRemaining, 0.0001)
+	assert.Equal(t, 0.0, st.BurnRates["5m"])
+	assert.InDelta(t, (20.0/113)/0.01, st.BurnRates["1h"], 0.0001)
+	assert.Equal(t, []string{SLOTicket}, st.Alerts)
+	assert.Equal(t, "672h0m0s", st.Period)
+
+	// SLOs leaving no budget are not tracked
+	trackSLO("/slo/none", SLO{Objective: 1, Latency: time.Second})
+	_, found := SLOStatusOf("/slo/none")
+	assert.False(t, found)
+}
+
+func TestRouteSLO(t *testing.T) {
+
+	a := &API{
+		Name:          "slo",
+		Version:       "1.0",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{Path: "/flaky", Description: "Flaky", Methods: GET, SLO: &SLO{Objective: 0.9, Latency: time.Minute},
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					if r.FormValue("fail") != "" {
+						return nil, NewErrorf("boom")
+					}
+					return "ok", nil
+				})},
+			{Path: "/plain", Description: "Plain", Methods: GET, Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+				return "ok", nil
+			})},
+		},
+	}
+	srv := NewServer(":9936")
+	srv.AddAPI(a)
+
+	for _, path := range []string{"/flaky", "/flaky", "/flaky?fail=1", "/plain"} {
+		hr, _ := http.NewRequest("GET", a.FullPath(path), nil)
+		srv.Handler().ServeHTTP(httptest.NewRecorder(), hr)
+	}
+
+	st, found := SLOStatusOf(a.FullPath("/flaky"))
+	assert.True(t, found)
+	assert.Equal(t, int64(3), st.Requests)
+	assert.Equal(t, int64(1), st.Bad)
+	assert.Equal(t, 0.9, st.Objective)
+	assert.Equal(t, 60000.0, st.Latency)
+
+	_, found = SLOStatusOf(a.FullPath("/plain"))
+	assert.False(t, found)
+
+	hr, _ := http.NewRequest("GET", SLOPath, nil)
+	w := httptest.NewRecorder()
+	sloHandler(w, hr)
+	var report []SLOStatus
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	routes := map[string]bool{}
+	for _, st := range report {
+		routes[st.Route] = true
+	}
+	assert.True(t, routes[a.FullPath("/flaky")])
+}
@@ -0,0 +1,166 @@
+package vertex
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+
+	"github.com/EverythingMe/vertex/swagger"
+)
+
+// SpecDiffPath is the admin endpoint comparing the served swagger spec of an API with a previous version, reporting
+// breaking changes. It can be used as a deploy gate, since it fails with 409 Conflict if there are breaking changes:
+//
+//	GET  /debug/vertex/specdiff?api=myapi&url=https://prod.example.com/myapi/1.0/swagger
+//	GET  /debug/vertex/specdiff?api=myapi    (compares with the stored snapshot)
+//	POST /debug/vertex/specdiff?api=myapi    (stores the served spec as the snapshot, e.g. after a deploy)
+//
+// Snapshots are stored in the spec_snapshot_dir configured in the server config
+const SpecDiffPath = "/debug/vertex/specdiff"
+
+// how long fetching a spec from a url may take
+const specFetchTimeout = 10 * time.Second
+
+// SpecDiff is the report of the spec diff endpoint
+type SpecDiff struct {
+	API      string           `json:"api"`
+	Against  string           `json:"against"`
+	Breaking bool             `json:"breaking"`
+	Changes  []swagger.Change `json:"changes"`
+}
+
+// findAPI returns a served API by name, and optionally version
+func (s *Server) findAPI(name, version string) (*API, error) {
+
+	var ret *API
+	for _, a := range s.apis {
+		if (name == "" || a.Name == name) && (version == "" || a.Version == version) {
+			if ret != nil {
+				return nil, fmt.Errorf("Ambiguous API %s, specify the api and version", name)
+			}
+			ret = a
+		}
+	}
+	if ret == nil {
+		return nil, fmt.Errorf("No API %s", name)
+	}
+	return ret, nil
+}
+
+// snapshotPath returns the path of the stored snapshot of an API's spec
+func snapshotPath(a *API) (string, error) {
+	if Config.Server.SpecSnapshotDir == "" {
+		return "", fmt.Errorf("No spec_snapshot_dir configured")
+	}
+	return filepath.Join(Config.Server.SpecSnapshotDir, a.Name+".json"), nil
+}
+
+// storeSnapshot stores the served spec of an API as its snapshot
+func storeSnapshot(a *API, spec *swagger.API) error {
+
+	pth, err := snapshotPath(a)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// written to a temp file and renamed, so a failed write never leaves a corrupt snapshot
+	tmp := pth + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, pth)
+}
+
+// loadSpec loads a previous version of a spec from a url, or from the API's snapshot
+func loadSpec(a *API, url string) (*swagger.API, string, error) {
+
+	var data []byte
+	var against string
+
+	if url != "" {
+		against = url
+		client := http.Client{Timeout: specFetchTimeout}
+		res, err := client.Get(url)
+		if err != nil {
+			return nil, against, err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return nil, against, fmt.Errorf("Fetching spec failed with status %d", res.StatusCode)
+		}
+		if data, err = ioutil.ReadAll(res.Body); err != nil {
+			return nil, against, err
+		}
+	} else {
+		pth, err := snapshotPath(a)
+		if err != nil {
+			return nil, against, err
+		}
+		against = "snapshot"
+		if data, err = ioutil.ReadFile(pth); err != nil {
+			return nil, against, err
+		}
+	}
+
+	var ret swagger.API
+	if err := json.Unmarshal(data, &ret); err != nil {
+		return nil, against, fmt.Errorf("Invalid spec: %s", err)
+	}
+	return &ret, against, nil
+}
+
+// specDiffHandler compares the served spec of an API with a previous version, or stores a snapshot on POST
+func (s *Server) specDiffHandler(w http.ResponseWriter, r *http.Request) {
+
+	a, err := s.findAPI(r.FormValue("api"), r.FormValue("version"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	current := a.ToSwagger(r.Host)
+
+	if r.Method == "POST" {
+		if err := storeSnapshot(a, current); err != nil {
+			logging.Error("Could not store spec snapshot of %s: %s", a.Name, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		logging.Info("Stored spec snapshot of %s", a.Name)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	previous, against, err := loadSpec(a, r.FormValue("url"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Could not load spec from %s: %s", against, err), http.StatusBadGateway)
+		return
+	}
+
+	diff := SpecDiff{
+		API:     a.Name,
+		Against: against,
+		Changes: swagger.Diff(previous, current),
+	}
+	diff.Breaking = swagger.HasBreakingChanges(diff.Changes)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if diff.Breaking {
+		w.WriteHeader(http.StatusConflict)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(diff); err != nil {
+		logging.Error("Could not write spec diff: %s", err)
+	}
+}
@@ -0,0 +1,165 @@
+package vertex
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/EverythingMe/vertex/swagger"
+)
+
+func TestSpecDiff(t *testing.T) {
+
+	old := swagger.NewAPI("", "", "", "1.0", "/", nil)
+	old.Parameters["token"] = swagger.Param{Name: "token", In: "query", Type: swagger.String}
+	old.AddPath("/gone")["get"] = swagger.Method{}
+	old.AddPath("/foo")["post"] = swagger.Method{}
+	old.AddPath("/bar")["get"] = swagger.Method{
+		Parameters: []swagger.Param{
+			{Ref: "#/parameters/token"},
+			{Name: "id", In: "query", Type: swagger.Integer, Required: true},
+			{Name: "name", In: "query", Type: swagger.String},
+			{Name: "limit", In: "query", Type: swagger.Integer, Required: true},
+			{Name: "tags", In: "query", Type: swagger.Array, Items: swagger.String},
+		},
+	}
+
+	new := swagger.NewAPI("", "", "", "1.1", "/", nil)
+	new.Parameters["token"] = swagger.Param{Name: "token", In: "header", Type: swagger.String}
+	new.AddPath("/foo")["get"] = swagger.Method{}
+	new.AddPath("/baz")["get"] = swagger.Method{}
+	new.AddPath("/bar")["get"] = swagger.Method{
+		Parameters: []swagger.Param{
+			{Ref: "#/parameters/token"},
+			{Name: "id", In: "query", Type: swagger.String, Required: true},
+			{Name: "name", In: "query", Type: swagger.String, Required: true},
+			{Name: "limit", In: "query", Type: swagger.Integer},
+			{Name: "tags", In: "query", Type: swagger.Array, Items: swagger.Integer},
+			{Name: "page", In: "query", Type: swagger.Integer},
+		},
+	}
+
+	kinds := func(changes []swagger.Change) []string {
+		ret := []string{}
+		for _, c := range changes {
+			ret = append(ret, c.Path+" "+c.Method+" "+c.Param+" "+c.Kind)
+		}
+		return ret
+	}
+
+	changes := swagger.Diff(old, new)
+	assert.Equal(t, []string{
+		"/bar get id param_type_changed",
+		"/bar get limit param_optional",
+		"/bar get name param_required",
+		"/bar get page param_added",
+		"/bar get tags param_type_changed",
+		"/bar get token param_moved",
+		"/baz   path_added",
+		"/foo get  method_added",
+		"/foo post  method_removed",
+		"/gone   path_removed",
+	}, kinds(changes))
+	assert.True(t, swagger.HasBreakingChanges(changes))
+
+	for _, c := range changes {
+		breaking := !(c.Kind == swagger.PathAdded || c.Kind == swagger.MethodAdded ||
+			c.Kind == swagger.ParamOptional || c.Kind == swagger.ParamAdded)
+		assert.Equal(t, breaking, c.Breaking, c.Kind)
+	}
+
+	assert.Empty(t, swagger.Diff(old, old))
+	assert.False(t, swagger.HasBreakingChanges(swagger.Diff(old, old)))
+}
+
+type specDiffHandler struct {
+	Id int `schema:"id" required:"true"`
+}
+
+func (h specDiffHandler) Handle(w http.ResponseWriter, r *Request) (interface{}, error) {
+	return h.Id, nil
+}
+
+type specDiffHandlerV2 struct {
+	Id   int    `schema:"id" required:"true"`
+	Name string `schema:"name" required:"true"`
+}
+
+func (h specDiffHandlerV2) Handle(w http.ResponseWriter, r *Request) (interface{}, error) {
+	return h.Id, nil
+}
+
+func TestSpecDiffEndpoint(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "specdiff")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	defer func(d string) { Config.Server.SpecSnapshotDir = d }(Config.Server.SpecSnapshotDir)
+	Config.Server.SpecSnapshotDir = dir
+
+	newServer := func(h RequestHandler) (*Server, *API) {
+		a := &API{
+			Name:          "specdiff",
+			Version:       "1.0",
+			Renderer:      JSONRenderer{},
+			AllowInsecure: true,
+			Routes: Routes{
+				{Path: "/foo", Description: "Foo", Methods: GET, Handler: h},
+			},
+		}
+		srv := NewServer(":9963")
+		srv.AddAPI(a)
+		return srv, a
+	}
+
+	call := func(srv *Server, method, query string) *httptest.ResponseRecorder {
+		hr, _ := http.NewRequest(method, SpecDiffPath+query, nil)
+		w := httptest.NewRecorder()
+		srv.specDiffHandler(w, hr)
+		return w
+	}
+
+	deployed, a := newServer(specDiffHandler{})
+
+	// no snapshot yet
+	assert.Equal(t, http.StatusBadGateway, call(deployed, "GET", "?api=specdiff").Code)
+	assert.Equal(t, http.StatusNotFound, call(deployed, "GET", "?api=nope").Code)
+
+	assert.Equal(t, http.StatusNoContent, call(deployed, "POST", "?api=specdiff").Code)
+
+	w := call(deployed, "GET", "?api=specdiff")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var diff SpecDiff
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &diff))
+	assert.Equal(t, "specdiff", diff.API)
+	assert.Equal(t, "snapshot", diff.Against)
+	assert.False(t, diff.Breaking)
+	assert.Empty(t, diff.Changes)
+
+	// a new required param breaks clients of the snapshot
+	candidate, _ := newServer(specDiffHandlerV2{})
+	w = call(candidate, "GET", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &diff))
+	assert.True(t, diff.Breaking)
+	if assert.Len(t, diff.Changes, 1) {
+		assert.Equal(t, swagger.ParamAdded, diff.Changes[0].Kind)
+		assert.Equal(t, "name", diff.Changes[0].Param)
+	}
+
+	// comparing with a deployed version by url
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(a.ToSwagger(r.Host))
+	}))
+	defer remote.Close()
+	w = call(candidate, "GET", "?api=specdiff&url="+remote.URL)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &diff))
+	assert.Equal(t, remote.URL, diff.Against)
+}
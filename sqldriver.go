@@ -0,0 +1,274 @@
+package vertex
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// DefaultSlowQuery is the duration of queries logged as slow, if not set
+const DefaultSlowQuery = 500 * time.Millisecond
+
+// SQLOptions configures the instrumentation of a database driver
+type SQLOptions struct {
+	// Queries taking longer are logged as slow. Defaults to DefaultSlowQuery
+	SlowQuery time.Duration
+
+	// Tag queries with the route and id of the request running them, in an SQL comment, so they can be traced in
+	// the database's logs and process lists:
+	//
+	//	SELECT * FROM users WHERE id = ? /* route='%2Fmyapi%2Fusers',request_id='...' */
+	//
+	// Queries are tagged only when run with the context of a request, e.g. db.QueryContext(r.Context(), ...)
+	TagQueries bool
+}
+
+// route => queries/errors/slow/micros => value
+var sqlMetrics = newCounterMap("vertex.sql")
+
+// backgroundRoute is the route queries run outside of requests are counted under
+const backgroundRoute = "background"
+
+// InstrumentSQLDriver registers a database/sql driver wrapping another one, feeding the latency of queries by route
+// into metrics and logging slow queries with the id of the request running them. It returns the name of the
+// wrapping driver, to open databases with:
+//
+//	db, err := sql.Open(vertex.InstrumentSQLDriver("mysql", &mysql.MySQLDriver{}, vertex.SQLOptions{}), dsn)
+//	...
+//	rows, err := db.QueryContext(r.Context(), "SELECT ...")
+//
+// Queries are attributed to requests by their context, so handlers must use the Context variants of database/sql
+// methods with the request's context. Drivers are registered once per process, so the driver must be instrumented
+// once, e.g. in an init func
+func InstrumentSQLDriver(name string, d driver.Driver, opts SQLOptions) string {
+
+	if opts.SlowQuery <= 0 {
+		opts.SlowQuery = DefaultSlowQuery
+	}
+
+	wrapped := "vertex-" + name
+	sql.Register(wrapped, &sqlDriver{base: d, opts: opts})
+	return wrapped
+}
+
+// SQLStats returns the number of queries run while handling requests of a route, the number that failed or were
+// slow and their average latency. Queries run outside of requests are counted under the route "background"
+func SQLStats(route string) (queries, failed, slow int64, latency time.Duration) {
+
+	queries = sqlMetrics.Value(route, "queries")
+	failed = sqlMetrics.Value(route, "errors")
+	slow = sqlMetrics.Value(route, "slow")
+	if queries > 0 {
+		latency = time.Duration(sqlMetrics.Value(route, "micros")/queries) * time.Microsecond
+	}
+	return
+}
+
+type sqlDriver struct {
+	base driver.Driver
+	opts SQLOptions
+}
+
+func (d *sqlDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.base.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlConn{Conn: c, opts: d.opts}, nil
+}
+
+// tag appends the route and id of the request of a context to a query
+func (o SQLOptions) tag(ctx context.Context, query string) string {
+
+	if !o.TagQueries {
+		return query
+	}
+	r := RequestFromContext(ctx)
+	if r == nil {
+		return query
+	}
+	return fmt.Sprintf("%s /* route='%s',request_id='%s' */", query, url.QueryEscape(r.route), url.QueryEscape(r.RequestId))
+}
+
+// measure runs a query, counting it in the metrics of its request's route and logging it if it is slow
+func (o SQLOptions) measure(ctx context.Context, query string, f func() error) error {
+
+	st := time.Now()
+	err := f()
+	if err == driver.ErrSkip {
+		return err
+	}
+	elapsed := time.Since(st)
+
+	route, id := backgroundRoute, ""
+	if r := RequestFromContext(ctx); r != nil {
+		route, id = r.route, r.RequestId
+	}
+
+	sqlMetrics.Add(route, "queries", 1)
+	sqlMetrics.Add(route, "micros", int64(elapsed/time.Microsecond))
+	if err != nil {
+		sqlMetrics.Add(route, "errors", 1)
+	}
+	if elapsed >= o.SlowQuery {
+		sqlMetrics.Add(route, "slow", 1)
+		logging.Warning("Slow query in %s (request %s) took %v: %s", route, id, elapsed, query)
+		if id != "" {
+			recordRequestLog(id, fmt.Sprintf("Slow query took %v: %s", elapsed, query))
+		}
+	}
+	return err
+}
+
+// sqlConn is a connection of an instrumented driver. The optional interfaces of the wrapped connection are passed
+// through, returning driver.ErrSkip where database/sql falls back when they are not implemented
+type sqlConn struct {
+	driver.Conn
+	opts SQLOptions
+}
+
+func (c *sqlConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *sqlConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+
+	tagged := c.opts.tag(ctx, query)
+
+	var s driver.Stmt
+	var err error
+	if pc, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		s, err = pc.PrepareContext(ctx, tagged)
+	} else {
+		s, err = c.Conn.Prepare(tagged)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sqlStmt{Stmt: s, query: query, opts: c.opts}, nil
+}
+
+func (c *sqlConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+
+	if bc, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return bc.BeginTx(ctx, opts)
+	}
+	if opts.Isolation != 0 || opts.ReadOnly {
+		return nil, errors.New("Driver does not support transaction options")
+	}
+	return c.Conn.Begin()
+}
+
+func (c *sqlConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (res driver.Result, err error) {
+
+	ec, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	err = c.opts.measure(ctx, query, func() error {
+		res, err = ec.ExecContext(ctx, c.opts.tag(ctx, query), args)
+		return err
+	})
+	return
+}
+
+func (c *sqlConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (rows driver.Rows, err error) {
+
+	qc, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	err = c.opts.measure(ctx, query, func() error {
+		rows, err = qc.QueryContext(ctx, c.opts.tag(ctx, query), args)
+		return err
+	})
+	return
+}
+
+func (c *sqlConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *sqlConn) ResetSession(ctx context.Context) error {
+	if sr, ok := c.Conn.(driver.SessionResetter); ok {
+		return sr.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *sqlConn) CheckNamedValue(v *driver.NamedValue) error {
+	if nc, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(v)
+	}
+	return driver.ErrSkip
+}
+
+// sqlStmt is a prepared statement of an instrumented driver
+type sqlStmt struct {
+	driver.Stmt
+	query string
+	opts  SQLOptions
+}
+
+func (s *sqlStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (res driver.Result, err error) {
+
+	err = s.opts.measure(ctx, s.query, func() error {
+		if ec, ok := s.Stmt.(driver.StmtExecContext); ok {
+			res, err = ec.ExecContext(ctx, args)
+			return err
+		}
+		values, err := namedValues(args)
+		if err != nil {
+			return err
+		}
+		res, err = s.Stmt.Exec(values)
+		return err
+	})
+	return
+}
+
+func (s *sqlStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (rows driver.Rows, err error) {
+
+	err = s.opts.measure(ctx, s.query, func() error {
+		if qc, ok := s.Stmt.(driver.StmtQueryContext); ok {
+			rows, err = qc.QueryContext(ctx, args)
+			return err
+		}
+		values, err := namedValues(args)
+		if err != nil {
+			return err
+		}
+		rows, err = s.Stmt.Query(values)
+		return err
+	})
+	return
+}
+
+func (s *sqlStmt) CheckNamedValue(v *driver.NamedValue) error {
+	if nc, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(v)
+	}
+	return driver.ErrSkip
+}
+
+// namedValues converts the args of a query for drivers that do not support named args
+func namedValues(args []driver.NamedValue) ([]driver.Value, error) {
+
+	ret := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, errors.New("Driver does not support named args")
+		}
+		ret[i] = arg.Value
+	}
+	return ret, nil
+}
@@ -0,0 +1,144 @@
+package vertex
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSQLDriver records the queries it runs. Queries containing SLOW take 20ms, and queries containing FAIL fail
+type fakeSQLDriver struct {
+	mtx     sync.Mutex
+	queries []string
+}
+
+func (d *fakeSQLDriver) Open(name string) (driver.Conn, error) {
+	return &fakeSQLConn{d: d}, nil
+}
+
+func (d *fakeSQLDriver) run(query string) error {
+	d.mtx.Lock()
+	d.queries = append(d.queries, query)
+	d.mtx.Unlock()
+	if strings.Contains(query, "SLOW") {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if strings.Contains(query, "FAIL") {
+		return errors.New("syntax error")
+	}
+	return nil
+}
+
+func (d *fakeSQLDriver) last() string {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return d.queries[len(d.queries)-1]
+}
+
+type fakeSQLConn struct {
+	d *fakeSQLDriver
+}
+
+func (c *fakeSQLConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeSQLStmt{d: c.d, query: query}, nil
+}
+func (c *fakeSQLConn) Close() error              { return nil }
+func (c *fakeSQLConn) Begin() (driver.Tx, error) { return fakeSQLTx{}, nil }
+
+func (c *fakeSQLConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return driver.RowsAffected(1), c.d.run(query)
+}
+
+type fakeSQLStmt struct {
+	d     *fakeSQLDriver
+	query string
+}
+
+func (s *fakeSQLStmt) Close() error  { return nil }
+func (s *fakeSQLStmt) NumInput() int { return -1 }
+func (s *fakeSQLStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(1), s.d.run(s.query)
+}
+func (s *fakeSQLStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return fakeSQLRows{}, s.d.run(s.query)
+}
+
+type fakeSQLTx struct{}
+
+func (fakeSQLTx) Commit() error   { return nil }
+func (fakeSQLTx) Rollback() error { return nil }
+
+type fakeSQLRows struct{}
+
+func (fakeSQLRows) Columns() []string              { return []string{"id"} }
+func (fakeSQLRows) Close() error                   { return nil }
+func (fakeSQLRows) Next(dest []driver.Value) error { return io.EOF }
+
+func TestInstrumentSQLDriver(t *testing.T) {
+
+	fake := &fakeSQLDriver{}
+	db, err := sql.Open(InstrumentSQLDriver("fake", fake, SQLOptions{SlowQuery: 10 * time.Millisecond, TagQueries: true}), "")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	a := &API{
+		Name:          "sqltrace",
+		Version:       "1.0",
+		Root:          "/sqltrace",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{Path: "/users/:id", Description: "User", Methods: GET, Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+				assert.True(t, RequestFromContext(r.Context()) == r)
+				if _, err := db.ExecContext(r.Context(), "UPDATE users SET seen = 1"); err != nil {
+					return nil, err
+				}
+				rows, err := db.QueryContext(r.Context(), "SELECT SLOW id FROM users")
+				if err != nil {
+					return nil, err
+				}
+				rows.Close()
+				_, err = db.ExecContext(r.Context(), "FAIL")
+				return "ok", nil
+			})},
+		},
+	}
+	srv := NewServer(":9945")
+	srv.AddAPI(a)
+
+	hr, _ := http.NewRequest("GET", a.FullPath("/users/1"), nil)
+	hr.Header.Set(HeaderXRequestId, "sql-req-1")
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, hr)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// queries are tagged with the request, and measured by route
+	assert.Equal(t, "FAIL /* route='%2Fsqltrace%2Fusers%2F%3Aid',request_id='sql-req-1' */", fake.last())
+	queries, failed, slow, latency := SQLStats(a.FullPath("/users/:id"))
+	assert.Equal(t, int64(3), queries)
+	assert.Equal(t, int64(1), failed)
+	assert.Equal(t, int64(1), slow)
+	assert.True(t, latency > 0)
+
+	// slow queries show in the diagnostics of the request
+	assert.Contains(t, strings.Join(requestLog("sql-req-1"), "\n"), "SELECT SLOW id FROM users")
+
+	// queries outside of requests are not tagged
+	_, err = db.Exec("DELETE FROM sessions")
+	assert.NoError(t, err)
+	assert.Equal(t, "DELETE FROM sessions", fake.last())
+	queries, _, _, _ = SQLStats("background")
+	assert.Equal(t, int64(1), queries)
+
+	assert.Nil(t, RequestFromContext(context.Background()))
+}
@@ -0,0 +1,148 @@
+package vertex
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+	"github.com/julienschmidt/httprouter"
+)
+
+// StaticDir is a directory of static files an API serves alongside its routes, e.g. its web frontend:
+//
+//	Static: []vertex.StaticDir{
+//		{Prefix: "/app", Dir: "./frontend/dist", MaxAge: 24 * time.Hour, SPA: true},
+//	},
+//
+// Directories are served without listings, and hidden files are never served
+type StaticDir struct {
+	// The path the directory is served under, e.g. /app. Unlike routes, it is not relative to the API root
+	Prefix string
+
+	// The directory on the local machine
+	Dir string
+
+	// Files served for requests to directories, in order of preference. Defaults to index.html
+	Index []string
+
+	// How long clients may cache the files, sent in a Cache-Control header. Index files are always revalidated, so
+	// new releases of a frontend are picked up. 0 means no Cache-Control header
+	MaxAge time.Duration
+
+	// Serve the index file of the root of the directory for paths that are not found, for single page apps routing
+	// on the client
+	SPA bool
+}
+
+// pattern returns the router pattern of the directory
+func (d StaticDir) pattern() string {
+	return strings.TrimSuffix(path.Join("/", d.Prefix), "/") + "/*filepath"
+}
+
+func (d StaticDir) indexFiles() []string {
+	if len(d.Index) == 0 {
+		return []string{"index.html"}
+	}
+	return d.Index
+}
+
+// staticHandler serves the files of a static directory
+type staticHandler struct {
+	dir StaticDir
+}
+
+func (h staticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, r.URL.Path)
+}
+
+// serve serves a file by its path inside the directory
+func (h staticHandler) serve(w http.ResponseWriter, r *http.Request, name string) {
+
+	name = path.Clean("/" + name)
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	f, info, index := h.open(name)
+	if f == nil && h.dir.SPA {
+		f, info, index = h.open("/")
+	}
+	if f == nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	if index {
+		w.Header().Set("Cache-Control", "no-cache")
+	} else if h.dir.MaxAge > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(h.dir.MaxAge.Seconds())))
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// open opens a file of the directory, or the index file of a sub directory. It returns nil if there is none, and
+// whether it is an index file
+func (h staticHandler) open(name string) (*os.File, os.FileInfo, bool) {
+
+	p := filepath.Join(h.dir.Dir, filepath.FromSlash(name))
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	index := false
+	if info.IsDir() {
+		found := false
+		for _, file := range h.dir.indexFiles() {
+			if info, err = os.Stat(filepath.Join(p, file)); err == nil && !info.IsDir() {
+				p, found = filepath.Join(p, file), true
+				break
+			}
+		}
+		if !found {
+			return nil, nil, false
+		}
+		index = true
+	} else {
+		for _, file := range h.dir.indexFiles() {
+			if info.Name() == file {
+				index = true
+			}
+		}
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		logging.Error("Could not open static file %s: %s", p, err)
+		return nil, nil, false
+	}
+	return f, info, index
+}
+
+// registerStatic registers the static directories of the API on a router
+func (a *API) registerStatic(router *httprouter.Router) {
+
+	for _, dir := range a.Static {
+		if info, err := os.Stat(dir.Dir); err != nil || !info.IsDir() {
+			logging.Error("Static directory %s of %s is not a directory", dir.Dir, a.Name)
+			continue
+		}
+
+		h := staticHandler{dir}
+		logging.Info("Serving static directory %s at %s", dir.Dir, dir.pattern())
+		serve := func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			h.serve(w, r, p.ByName("filepath"))
+		}
+		router.GET(dir.pattern(), serve)
+		router.HEAD(dir.pattern(), serve)
+	}
+}
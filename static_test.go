@@ -0,0 +1,74 @@
+package vertex
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaticDirs(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "vertex-static")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "js"), 0755)
+	os.MkdirAll(filepath.Join(dir, "empty"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte("<html>app</html>"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "js", "app.js"), []byte("console.log(1)"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, ".env"), []byte("SECRET=1"), 0644)
+
+	a := &API{
+		Name:          "static",
+		Version:       "1.0",
+		Root:          "/static",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Static: []StaticDir{
+			{Prefix: "/app", Dir: dir, MaxAge: time.Hour, SPA: true},
+			{Prefix: "/files/", Dir: dir},
+			{Prefix: "/missing", Dir: filepath.Join(dir, "nope")},
+		},
+	}
+	srv := NewServer(":9988")
+	srv.AddAPI(a)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		hr, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, hr)
+		return w
+	}
+
+	w := get("/app/js/app.js")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "console.log(1)", w.Body.String())
+	assert.Equal(t, "public, max-age=3600", w.Header().Get("Cache-Control"))
+	assert.NotEmpty(t, w.Header().Get("Last-Modified"))
+
+	w = get("/app/")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "<html>app</html>", w.Body.String())
+	assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+
+	// client side routes of single page apps get the index
+	w = get("/app/users/12")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "<html>app</html>", w.Body.String())
+
+	w = get("/files/users/12")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// no listings, hidden files or escaping the directory
+	assert.Equal(t, http.StatusNotFound, get("/files/empty/").Code)
+	assert.Equal(t, http.StatusNotFound, get("/files/.env").Code)
+	assert.NotContains(t, get("/files/../../etc/passwd").Body.String(), "root:")
+
+	assert.Equal(t, http.StatusNotFound, get("/missing/index.html").Code)
+}
@@ -0,0 +1,329 @@
+package vertex
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// StatsPath is the admin endpoint reporting the traffic of every API and route of the server over a sliding window,
+// for services without a metrics stack:
+//
+//	GET /debug/vertex/stats?window=15m[&api=users]
+//
+// The window is rounded up to whole minutes, and defaults to DefaultStatsWindow. Latency percentiles are
+// approximated from a histogram, so they are accurate to about a tenth of their value
+const StatsPath = "/debug/vertex/stats"
+
+// Stats window settings
+const (
+	DefaultStatsWindow = 5 * time.Minute
+	MaxStatsWindow     = time.Hour
+)
+
+// number of one minute slots kept per route, covering MaxStatsWindow
+const statsSlots = int(MaxStatsWindow / time.Minute)
+
+// Upper bounds of the latency histogram buckets, growing by 10% from 100 microseconds to a minute. Slower requests
+// fall in an extra last bucket
+var latencyBounds = func() []time.Duration {
+	ret := []time.Duration{}
+	for d := float64(100 * time.Microsecond); d < float64(time.Minute); d *= 1.1 {
+		ret = append(ret, time.Duration(d))
+	}
+	return ret
+}()
+
+// RouteStats is the traffic of a route, or of all the routes of an API, over a window
+type RouteStats struct {
+	Method string `json:"method,omitempty"`
+	Route  string `json:"route,omitempty"`
+
+	Calls int64 `json:"calls"`
+
+	// Requests answered with a 4xx or 5xx status, and with a 5xx status alone
+	Errors       int64 `json:"errors"`
+	ServerErrors int64 `json:"server_errors"`
+
+	// Response body bytes written
+	Bytes int64 `json:"bytes"`
+
+	// Latency percentiles in milliseconds
+	P50 float64 `json:"p50_ms"`
+	P95 float64 `json:"p95_ms"`
+	P99 float64 `json:"p99_ms"`
+
+	latency []int64
+}
+
+// APIStats is the traffic of an API and its routes over a window
+type APIStats struct {
+	API     string `json:"api"`
+	Version string `json:"version"`
+	RouteStats
+	Routes []RouteStats `json:"routes"`
+}
+
+// StatsReport is the traffic of the APIs of a server over a window
+type StatsReport struct {
+	Window string     `json:"window"`
+	APIs   []APIStats `json:"apis"`
+
+	// The pod, namespace and node of the server, when running in kubernetes
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// statsSlot is the traffic of a route in one minute
+type statsSlot struct {
+	minute       int64
+	calls        int64
+	errors       int64
+	serverErrors int64
+	bytes        int64
+	latency      []int64
+}
+
+// routeStats keeps the traffic of the routes of all APIs in one minute slots by "METHOD route". Slots are reused
+// once they are older than the longest window
+var routeStats = struct {
+	sync.Mutex
+	routes map[string]*[statsSlots]*statsSlot
+}{
+	routes: map[string]*[statsSlots]*statsSlot{},
+}
+
+// recordStats counts a request of a route that finished at a given time
+func recordStats(method, route string, at time.Time, latency time.Duration, status int, bytes int64) {
+
+	minute := at.Unix() / 60
+	bucket := sort.Search(len(latencyBounds), func(i int) bool { return latencyBounds[i] >= latency })
+
+	routeStats.Lock()
+	defer routeStats.Unlock()
+
+	key := method + " " + route
+	slots, found := routeStats.routes[key]
+	if !found {
+		slots = &[statsSlots]*statsSlot{}
+		routeStats.routes[key] = slots
+	}
+
+	slot := slots[minute%int64(statsSlots)]
+	if slot == nil || slot.minute != minute {
+		slot = &statsSlot{minute: minute, latency: make([]int64, len(latencyBounds)+1)}
+		slots[minute%int64(statsSlots)] = slot
+	}
+
+	slot.calls++
+	if status >= 400 {
+		slot.errors++
+	}
+	if status >= 500 {
+		slot.serverErrors++
+	}
+	slot.bytes += bytes
+	slot.latency[bucket]++
+}
+
+// windowStats sums the traffic of a route in the minutes of the window ending at now, the current minute included
+func windowStats(method, route string, now time.Time, window time.Duration) RouteStats {
+
+	ret := RouteStats{Method: method, Route: route, latency: make([]int64, len(latencyBounds)+1)}
+	last := now.Unix() / 60
+	first := last - int64(window/time.Minute) + 1
+
+	routeStats.Lock()
+	defer routeStats.Unlock()
+
+	slots, found := routeStats.routes[method+" "+route]
+	if !found {
+		return ret
+	}
+	for _, slot := range slots {
+		if slot == nil || slot.minute < first || slot.minute > last {
+			continue
+		}
+		ret.Calls += slot.calls
+		ret.Errors += slot.errors
+		ret.ServerErrors += slot.serverErrors
+		ret.Bytes += slot.bytes
+		for i, n := range slot.latency {
+			ret.latency[i] += n
+		}
+	}
+	return ret
+}
+
+// add adds the traffic of a route to a total
+func (s *RouteStats) add(o RouteStats) {
+	s.Calls += o.Calls
+	s.Errors += o.Errors
+	s.ServerErrors += o.ServerErrors
+	s.Bytes += o.Bytes
+	if s.latency == nil {
+		s.latency = make([]int64, len(latencyBounds)+1)
+	}
+	for i, n := range o.latency {
+		s.latency[i] += n
+	}
+}
+
+// percentiles sets the latency percentiles from the histogram
+func (s *RouteStats) percentiles() {
+	s.P50 = latencyPercentile(s.latency, s.Calls, 0.5)
+	s.P95 = latencyPercentile(s.latency, s.Calls, 0.95)
+	s.P99 = latencyPercentile(s.latency, s.Calls, 0.99)
+}
+
+// latencyPercentile returns a percentile of a latency histogram in milliseconds, interpolated within its bucket
+func latencyPercentile(hist []int64, total int64, p float64) float64 {
+
+	if total == 0 {
+		return 0
+	}
+
+	rank := p * float64(total)
+	var seen int64
+	for i, n := range hist {
+		if n == 0 || float64(seen+n) < rank {
+			seen += n
+			continue
+		}
+
+		var lower, upper time.Duration
+		if i > 0 {
+			lower = latencyBounds[i-1]
+		}
+		if i < len(latencyBounds) {
+			upper = latencyBounds[i]
+		} else {
+			// slower than the histogram covers
+			return float64(lower) / float64(time.Millisecond)
+		}
+
+		d := float64(lower) + float64(upper-lower)*(rank-float64(seen))/float64(n)
+		return math.Round(d/float64(time.Microsecond)) / 1000
+	}
+	return float64(latencyBounds[len(latencyBounds)-1]) / float64(time.Millisecond)
+}
+
+// Stats returns the traffic of the server's APIs and their routes over a window ending now, including routes that
+// were not called. The window is rounded up to whole minutes, up to MaxStatsWindow
+func (s *Server) Stats(window time.Duration) StatsReport {
+	return s.stats(time.Now(), window)
+}
+
+func (s *Server) stats(now time.Time, window time.Duration) StatsReport {
+
+	window = (window + time.Minute - 1).Truncate(time.Minute)
+	if window <= 0 {
+		window = time.Minute
+	}
+	if window > MaxStatsWindow {
+		window = MaxStatsWindow
+	}
+
+	ret := StatsReport{Window: window.String(), APIs: make([]APIStats, 0, len(s.apis)), Labels: podLabels()}
+	for _, a := range s.apis {
+
+		as := APIStats{API: a.Name, Version: a.Version, Routes: []RouteStats{}}
+		for _, route := range a.Routes {
+			pth := a.FullPath(route.Path)
+			for _, m := range methodFlags {
+				if route.Methods&m.flag != m.flag {
+					continue
+				}
+				rs := windowStats(m.name, pth, now, window)
+				as.add(rs)
+				rs.percentiles()
+				as.Routes = append(as.Routes, rs)
+			}
+		}
+		as.percentiles()
+		ret.APIs = append(ret.APIs, as)
+	}
+	return ret
+}
+
+// statsHandler serves the stats report, see StatsPath
+func (s *Server) statsHandler(w http.ResponseWriter, r *http.Request) {
+
+	window := DefaultStatsWindow
+	if v := r.FormValue("window"); v != "" {
+		var err error
+		if window, err = time.ParseDuration(v); err != nil || window <= 0 {
+			http.Error(w, fmt.Sprintf("Invalid window, must be a duration up to %v", MaxStatsWindow), http.StatusBadRequest)
+			return
+		}
+	}
+
+	report := s.Stats(window)
+	if api := r.FormValue("api"); api != "" {
+		apis := []APIStats{}
+		for _, as := range report.APIs {
+			if as.API == api {
+				apis = append(apis, as)
+			}
+		}
+		report.APIs = apis
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		logging.Error("Could not write stats report: %s", err)
+	}
+}
+
+// statsWriter records the status and body size of a response for the stats
+type statsWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statsWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statsWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush lets streaming handlers flush through the writer
+func (w *statsWriter) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets websocket handlers take over the connection through the writer
+func (w *statsWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		if w.status == 0 {
+			w.status = http.StatusSwitchingProtocols
+		}
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("The response writer does not support hijacking")
+}
@@ -0,0 +1,105 @@
+package vertex
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+
+	a := &API{
+		Name:          "stats",
+		Version:       "1.0",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{Path: "/ok", Description: "OK", Methods: GET, Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+				return "ok", nil
+			})},
+			{Path: "/fail", Description: "Fail", Methods: GET, Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+				return nil, NewErrorf("boom")
+			})},
+			{Path: "/idle", Description: "Idle", Methods: POST, Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+				return nil, nil
+			})},
+		},
+	}
+	srv := NewServer(":9938")
+	srv.AddAPI(a)
+
+	var written int64
+	call := func(path string, n int) {
+		for i := 0; i < n; i++ {
+			hr, _ := http.NewRequest("GET", a.FullPath(path), nil)
+			w := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(w, hr)
+			written += int64(w.Body.Len())
+		}
+	}
+	call("/ok", 3)
+	call("/fail", 1)
+
+	// the window includes the previous minute, in case it just ended
+	report := srv.Stats(2 * time.Minute)
+	assert.Equal(t, "2m0s", report.Window)
+	assert.Len(t, report.APIs, 1)
+
+	as := report.APIs[0]
+	assert.Equal(t, "stats", as.API)
+	assert.Equal(t, int64(4), as.Calls)
+	assert.Equal(t, int64(1), as.Errors)
+	assert.Equal(t, int64(1), as.ServerErrors)
+	assert.Equal(t, written, as.Bytes)
+	assert.True(t, as.P50 > 0)
+	assert.True(t, as.P99 >= as.P50)
+
+	assert.Len(t, as.Routes, 3)
+	assert.Equal(t, "GET", as.Routes[0].Method)
+	assert.Equal(t, a.FullPath("/ok"), as.Routes[0].Route)
+	assert.Equal(t, int64(3), as.Routes[0].Calls)
+	assert.Equal(t, int64(0), as.Routes[0].Errors)
+	assert.Equal(t, int64(1), as.Routes[1].Errors)
+	assert.Equal(t, int64(0), as.Routes[2].Calls)
+	assert.Equal(t, 0.0, as.Routes[2].P50)
+
+	// old traffic slides out of the window
+	recordStats("POST", a.FullPath("/idle"), time.Now().Add(-10*time.Minute), time.Millisecond, 200, 10)
+	assert.Equal(t, int64(0), srv.Stats(5 * time.Minute).APIs[0].Routes[2].Calls)
+	assert.Equal(t, int64(1), srv.Stats(15 * time.Minute).APIs[0].Routes[2].Calls)
+	assert.Equal(t, "1h0m0s", srv.Stats(24*time.Hour).Window)
+
+	hr, _ := http.NewRequest("GET", StatsPath+"?window=90s&api=other", nil)
+	w := httptest.NewRecorder()
+	srv.statsHandler(w, hr)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, "2m0s", report.Window)
+	assert.Empty(t, report.APIs)
+
+	hr, _ = http.NewRequest("GET", StatsPath+"?window=soon", nil)
+	w = httptest.NewRecorder()
+	srv.statsHandler(w, hr)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestLatencyPercentile(t *testing.T) {
+
+	for _, d := range []time.Duration{time.Millisecond, 10 * time.Millisecond, 100 * time.Millisecond, time.Second} {
+		for i := 0; i < 25; i++ {
+			recordStats("GET", "/percentiles", time.Now(), d, 200, 0)
+		}
+	}
+	hist := windowStats("GET", "/percentiles", time.Now(), 2*time.Minute).latency
+
+	within := func(expected time.Duration, actual float64) {
+		ms := float64(expected) / float64(time.Millisecond)
+		assert.InDelta(t, ms, actual, ms/10, expected.String())
+	}
+	within(10*time.Millisecond, latencyPercentile(hist, 100, 0.5))
+	within(time.Second, latencyPercentile(hist, 100, 0.99))
+	assert.Equal(t, 0.0, latencyPercentile(hist, 0, 0.5))
+}
@@ -0,0 +1,62 @@
+package vertex
+
+import "net/http"
+
+// Response wraps a handler's response with the http status and headers to render it with, for responses other than
+// 200 OK:
+//
+//	return vertex.Created(user, "/users/"+user.Id), nil
+//
+// Statuses of responses without a body, like 204 No Content, are sent without one
+type Response struct {
+	// The http status of the response. Defaults to 200 OK
+	Status int
+
+	// Headers set on the response
+	Headers http.Header
+
+	// The actual response object
+	Body interface{}
+}
+
+// WithStatus wraps a response object with an http status
+func WithStatus(status int, v interface{}) *Response {
+	return &Response{Status: status, Body: v}
+}
+
+// Created wraps the entity a request created, rendered with 201 Created. If location is set, it is sent in the
+// Location header as the URL of the entity
+func Created(v interface{}, location string) *Response {
+	ret := WithStatus(http.StatusCreated, v)
+	if location != "" {
+		ret.Headers = http.Header{"Location": {location}}
+	}
+	return ret
+}
+
+// Accepted wraps the response of a request accepted for processing later, rendered with 202 Accepted
+func Accepted(v interface{}) *Response {
+	return WithStatus(http.StatusAccepted, v)
+}
+
+// NoContent returns a response without a body, rendered with 204 No Content
+func NoContent() *Response {
+	return WithStatus(http.StatusNoContent, nil)
+}
+
+// writeHeaders writes the headers of the response, and returns the writer to render it with
+func (r *Response) writeHeaders(w http.ResponseWriter) http.ResponseWriter {
+
+	for k, v := range r.Headers {
+		w.Header()[http.CanonicalHeaderKey(k)] = v
+	}
+	if r.Status == 0 || r.Status == http.StatusOK {
+		return w
+	}
+	return &statusWriter{ResponseWriter: w, status: r.Status}
+}
+
+// bodyAllowed tells if responses with an http status may have a body
+func bodyAllowed(status int) bool {
+	return !(status >= 100 && status < 200 || status == http.StatusNoContent || status == http.StatusNotModified)
+}
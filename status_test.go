@@ -0,0 +1,65 @@
+package vertex
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseStatus(t *testing.T) {
+
+	handler := func(ret interface{}) RequestHandler {
+		return HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+			return ret, nil
+		})
+	}
+
+	a := &API{
+		Name:          "status",
+		Version:       "1.0",
+		Root:          "/status",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{Path: "/created", Description: "Created", Methods: POST, Handler: handler(Created(map[string]string{"id": "12"}, "/users/12"))},
+			{Path: "/accepted", Description: "Accepted", Methods: POST, Handler: handler(Accepted("queued"))},
+			{Path: "/deleted", Description: "Deleted", Methods: DELETE, Handler: handler(NoContent())},
+			{Path: "/teapot", Description: "Teapot", Methods: GET, Handler: handler(&Response{
+				Status:  http.StatusTeapot,
+				Headers: http.Header{"x-tea": {"earl grey"}},
+				Body:    Versioned("tea", "3"),
+			}), WarnResponseSize: 1},
+		},
+	}
+	srv := NewServer(":9993")
+	srv.AddAPI(a)
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		hr, _ := http.NewRequest(method, a.FullPath(path), nil)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, hr)
+		return w
+	}
+
+	w := do("POST", "/created")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "/users/12", w.Header().Get("Location"))
+	assert.Equal(t, `{"id":"12"}`, w.Body.String())
+
+	w = do("POST", "/accepted")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, `"queued"`, w.Body.String())
+
+	w = do("DELETE", "/deleted")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	// wrappers compose, and statuses survive buffered rendering
+	w = do("GET", "/teapot")
+	assert.Equal(t, http.StatusTeapot, w.Code)
+	assert.Equal(t, "earl grey", w.Header().Get("X-Tea"))
+	assert.Equal(t, `"3"`, w.Header().Get("ETag"))
+	assert.Equal(t, `"tea"`, w.Body.String())
+}
@@ -0,0 +1,168 @@
+package vertex
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store is a key value store with expiration, for state that must be shared by all the instances of a service,
+// e.g. authentication lockouts or webhook deduplication. Implementations are usually backed by redis or memcache.
+// MemoryStore is a single process implementation, for tests and single instance deployments
+type Store interface {
+	// Get returns the value of a key, and false if it does not exist or has expired
+	Get(key string) ([]byte, bool, error)
+
+	// Set sets the value of a key, expiring after ttl. A ttl of 0 means the key never expires
+	Set(key string, value []byte, ttl time.Duration) error
+
+	// SetIfAbsent atomically sets the value of a key only if it does not exist, returning false if it does
+	SetIfAbsent(key string, value []byte, ttl time.Duration) (bool, error)
+
+	// Incr atomically increments the integer value of a key and returns the new value. If the key does not exist
+	// it is created with the value 1, expiring after ttl
+	Incr(key string, ttl time.Duration) (int64, error)
+
+	// Delete deletes a key
+	Delete(key string) error
+
+	// Keys returns the keys starting with a prefix
+	Keys(prefix string) ([]string, error)
+}
+
+type memoryItem struct {
+	value   []byte
+	expires time.Time
+}
+
+func (i memoryItem) expired(now time.Time) bool {
+	return !i.expires.IsZero() && !now.Before(i.expires)
+}
+
+// MemoryStore is an in-memory Store
+type MemoryStore struct {
+	mtx   sync.Mutex
+	items map[string]memoryItem
+	sets  int
+}
+
+// NewMemoryStore creates a new empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		items: map[string]memoryItem{},
+	}
+}
+
+func expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// set stores an item, occasionally purging expired items. It must be called with the lock held
+func (s *MemoryStore) set(key string, value []byte, ttl time.Duration) {
+
+	s.sets++
+	if s.sets%1000 == 0 {
+		now := time.Now()
+		for k, item := range s.items {
+			if item.expired(now) {
+				delete(s.items, k)
+			}
+		}
+	}
+
+	s.items[key] = memoryItem{value: append([]byte(nil), value...), expires: expiry(ttl)}
+}
+
+// get returns a live item. It must be called with the lock held
+func (s *MemoryStore) get(key string) (memoryItem, bool) {
+	item, found := s.items[key]
+	if found && item.expired(time.Now()) {
+		delete(s.items, key)
+		return memoryItem{}, false
+	}
+	return item, found
+}
+
+// Get returns the value of a key
+func (s *MemoryStore) Get(key string) ([]byte, bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	item, found := s.get(key)
+	if !found {
+		return nil, false, nil
+	}
+	return append([]byte(nil), item.value...), true, nil
+}
+
+// Set sets the value of a key
+func (s *MemoryStore) Set(key string, value []byte, ttl time.Duration) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.set(key, value, ttl)
+	return nil
+}
+
+// SetIfAbsent sets the value of a key if it does not exist
+func (s *MemoryStore) SetIfAbsent(key string, value []byte, ttl time.Duration) (bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, found := s.get(key); found {
+		return false, nil
+	}
+	s.set(key, value, ttl)
+	return true, nil
+}
+
+// Incr increments the integer value of a key
+func (s *MemoryStore) Incr(key string, ttl time.Duration) (int64, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	item, found := s.get(key)
+	if !found {
+		s.set(key, []byte("1"), ttl)
+		return 1, nil
+	}
+
+	n, err := strconv.ParseInt(string(item.value), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	n++
+	item.value = []byte(strconv.FormatInt(n, 10))
+	s.items[key] = item
+	return n, nil
+}
+
+// Delete deletes a key
+func (s *MemoryStore) Delete(key string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	delete(s.items, key)
+	return nil
+}
+
+// Keys returns the live keys starting with a prefix, sorted
+func (s *MemoryStore) Keys(prefix string) ([]string, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := time.Now()
+	ret := make([]string, 0)
+	for k, item := range s.items {
+		if strings.HasPrefix(k, prefix) && !item.expired(now) {
+			ret = append(ret, k)
+		}
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
@@ -0,0 +1,49 @@
+package vertex
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore(t *testing.T) {
+
+	s := NewMemoryStore()
+
+	_, found, err := s.Get("foo")
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	assert.NoError(t, s.Set("foo", []byte("bar"), 0))
+	v, found, _ := s.Get("foo")
+	assert.True(t, found)
+	assert.Equal(t, "bar", string(v))
+
+	ok, _ := s.SetIfAbsent("foo", []byte("baz"), 0)
+	assert.False(t, ok)
+	ok, _ = s.SetIfAbsent("new", []byte("baz"), 0)
+	assert.True(t, ok)
+
+	n, _ := s.Incr("counter", time.Minute)
+	assert.Equal(t, int64(1), n)
+	n, _ = s.Incr("counter", time.Minute)
+	assert.Equal(t, int64(2), n)
+	_, err = s.Incr("foo", 0)
+	assert.Error(t, err)
+
+	keys, _ := s.Keys("f")
+	assert.Equal(t, []string{"foo"}, keys)
+
+	// expiration
+	assert.NoError(t, s.Set("short", []byte("x"), 10*time.Millisecond))
+	time.Sleep(20 * time.Millisecond)
+	_, found, _ = s.Get("short")
+	assert.False(t, found)
+	ok, _ = s.SetIfAbsent("short", []byte("y"), 0)
+	assert.True(t, ok)
+
+	assert.NoError(t, s.Delete("foo"))
+	_, found, _ = s.Get("foo")
+	assert.False(t, found)
+}
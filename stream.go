@@ -0,0 +1,112 @@
+package vertex
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// Streamer is a response handlers return to write it to the client themselves, in chunks, rather than return a value
+// for the renderer. Large exports can be streamed as they are produced without buffering them in memory:
+//
+//	func (h ExportHandler) Handle(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+//		rows, err := db.Query(...)
+//		if err != nil {
+//			return nil, err
+//		}
+//		return vertex.Stream("text/csv", func(w *vertex.StreamWriter) error {
+//			defer rows.Close()
+//			for rows.Next() {
+//				...
+//				w.Flush()
+//			}
+//			return rows.Err()
+//		}), nil
+//	}
+//
+// Streams bypass the renderer and its response envelope. If a stream fails before writing anything, the error is
+// rendered like any other error. Once it wrote, the status was already sent, so the connection is aborted instead,
+// letting the client know the response is incomplete
+type Streamer interface {
+	// The content type of the stream
+	ContentType() string
+
+	// Stream writes the response
+	Stream(w *StreamWriter) error
+}
+
+type streamFunc struct {
+	contentType string
+	f           func(*StreamWriter) error
+}
+
+func (s streamFunc) ContentType() string {
+	return s.contentType
+}
+
+func (s streamFunc) Stream(w *StreamWriter) error {
+	return s.f(w)
+}
+
+// Stream wraps a function writing a response as a Streamer
+func Stream(contentType string, f func(w *StreamWriter) error) Streamer {
+	return streamFunc{contentType, f}
+}
+
+// StreamWriter writes a streamed response
+type StreamWriter struct {
+	w       http.ResponseWriter
+	written int64
+}
+
+// Header returns the headers of the response. They can be changed only before the first write
+func (s *StreamWriter) Header() http.Header {
+	return s.w.Header()
+}
+
+// Write writes a chunk of the response
+func (s *StreamWriter) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	s.written += int64(n)
+	return n, err
+}
+
+// Flush sends the data written so far to the client
+func (s *StreamWriter) Flush() {
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Written returns the number of bytes written so far
+func (s *StreamWriter) Written() int64 {
+	return s.written
+}
+
+// streamResponse streams a response returned by a handler. It returns the error of a stream failing before it wrote
+// anything, to be rendered
+func (a *API) streamResponse(w http.ResponseWriter, r *Request, s Streamer) error {
+
+	w.Header().Set(HeaderProcessingTime, fmt.Sprintf("%.03f", time.Since(r.StartTime).Seconds()*1000))
+	w.Header().Set(HeaderRequestId, r.RequestId)
+	if ct := s.ContentType(); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+
+	sw := &StreamWriter{w: w}
+	err := s.Stream(sw)
+	if err == nil {
+		return nil
+	}
+
+	a.countError(r.route, err)
+	recordRequestLog(r.RequestId, fmt.Sprintf("Stream failed: %s", err))
+	if sw.written == 0 {
+		return err
+	}
+
+	logging.Error("Stream of %s failed after %d bytes: %s", r.route, sw.written, err)
+	panic(http.ErrAbortHandler)
+}
@@ -0,0 +1,72 @@
+package vertex
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type exportHandler struct {
+	Rows   int `schema:"rows"`
+	FailAt int `schema:"fail_at" default:"-1"`
+}
+
+func (h exportHandler) Handle(w http.ResponseWriter, r *Request) (interface{}, error) {
+	return Stream("text/plain", func(w *StreamWriter) error {
+		for i := 0; i < h.Rows; i++ {
+			if i == h.FailAt {
+				return errors.New("export failed")
+			}
+			fmt.Fprintf(w, "row %d\n", i)
+			w.Flush()
+		}
+		return nil
+	}), nil
+}
+
+func TestStream(t *testing.T) {
+
+	a := &API{
+		Name:          "stream",
+		Version:       "1.0",
+		Root:          "/stream",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{Path: "/export", Description: "Export", Methods: GET, Handler: exportHandler{}},
+		},
+	}
+	srv := NewServer(":9981")
+	srv.AddAPI(a)
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + a.FullPath("/export") + "?rows=3")
+	assert.NoError(t, err)
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+	assert.NotEmpty(t, resp.Header.Get(HeaderRequestId))
+	assert.Equal(t, "row 0\nrow 1\nrow 2\n", string(b))
+
+	// a stream failing before it wrote is rendered as an error
+	resp, err = http.Get(ts.URL + a.FullPath("/export") + "?rows=3&fail_at=0")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+	// a stream failing after it wrote aborts the connection
+	resp, err = http.Get(ts.URL + a.FullPath("/export") + "?rows=3&fail_at=2")
+	assert.NoError(t, err)
+	b, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Error(t, err)
+	assert.Equal(t, "row 0\nrow 1\n", string(b))
+}
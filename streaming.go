@@ -0,0 +1,212 @@
+package vertex
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/EverythingMe/vertex/wsclient"
+)
+
+// ServerEvent is an event received from a server-sent events stream
+type ServerEvent struct {
+	ID    string
+	Event string
+	Data  string
+}
+
+// EventStream is a server-sent events stream opened by a tester
+type EventStream struct {
+	t      *TestContext
+	resp   *http.Response
+	events chan ServerEvent
+	err    error
+}
+
+// OpenEventStream performs a request to a server-sent events route and returns its stream, aborting the test if
+// the route did not respond with a stream
+func (t *TestContext) OpenEventStream(r *http.Request) *EventStream {
+
+	if t.coverage != nil {
+		t.coverage.record(r)
+	}
+	r.Header.Set("Accept", "text/event-stream")
+
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		panic(newTestResult(resultFatal, fmt.Sprintf("Could not open event stream: %s", err), 2, t))
+	}
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		resp.Body.Close()
+		panic(newTestResult(resultFailed, fmt.Sprintf("Not an event stream: %s (%s)", resp.Status,
+			resp.Header.Get("Content-Type")), 2, t))
+	}
+
+	s := &EventStream{
+		t:      t,
+		resp:   resp,
+		events: make(chan ServerEvent, 16),
+	}
+	go s.read()
+	return s
+}
+
+// read parses the events of the stream until it is closed
+func (s *EventStream) read() {
+
+	defer close(s.events)
+
+	scanner := bufio.NewScanner(s.resp.Body)
+	ev := ServerEvent{}
+	data := []string{}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if len(data) > 0 {
+				ev.Data = strings.Join(data, "\n")
+				s.events <- ev
+			}
+			ev, data = ServerEvent{}, []string{}
+			continue
+		}
+
+		field, value := line, ""
+		if i := strings.Index(line, ":"); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "id":
+			ev.ID = value
+		case "event":
+			ev.Event = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+	s.err = scanner.Err()
+}
+
+// Expect waits for the next event of the stream, failing the test if it does not arrive in time or the stream was
+// closed
+func (s *EventStream) Expect(timeout time.Duration) ServerEvent {
+
+	select {
+	case ev, ok := <-s.events:
+		if !ok {
+			panic(newTestResult(resultFailed, fmt.Sprintf("Event stream closed: %v", s.err), 2, s.t))
+		}
+		return ev
+	case <-time.After(timeout):
+		panic(newTestResult(resultFailed, fmt.Sprintf("No event received in %v", timeout), 2, s.t))
+	}
+}
+
+// Close closes the stream
+func (s *EventStream) Close() error {
+	return s.resp.Body.Close()
+}
+
+// WebSocket close codes
+const (
+	CloseNormal        = wsclient.CloseNormal
+	CloseGoingAway     = wsclient.CloseGoingAway
+	CloseProtocolError = wsclient.CloseProtocolError
+	CloseNoStatus      = wsclient.CloseNoStatus
+	CloseTooLarge      = wsclient.CloseTooLarge
+)
+
+// WebSocket is a websocket connection opened by a tester
+type WebSocket struct {
+	t    *TestContext
+	conn *wsclient.Conn
+}
+
+// DialWebSocket opens a websocket to the route we are testing now, with optional path params and headers,
+// aborting the test if the handshake fails. Messages larger than wsclient.DefaultMaxMessageSize fail the connection
+func (t *TestContext) DialWebSocket(pathParams Params, header http.Header) *WebSocket {
+
+	r, err := t.NewRequest("GET", nil, pathParams)
+	if err != nil {
+		panic(newTestResult(resultFatal, fmt.Sprintf("Could not create request: %s", err), 2, t))
+	}
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	if t.coverage != nil {
+		t.coverage.record(r)
+	}
+
+	conn, err := wsclient.Dial(r)
+	if err != nil {
+		panic(newTestResult(resultFatal, fmt.Sprintf("Could not open websocket: %s", err), 2, t))
+	}
+	return &WebSocket{t: t, conn: conn}
+}
+
+// Send sends a text message, aborting the test if it fails
+func (ws *WebSocket) Send(msg string) {
+	if err := ws.conn.WriteText([]byte(msg)); err != nil {
+		panic(newTestResult(resultFatal, fmt.Sprintf("Could not send message: %s", err), 2, ws.t))
+	}
+}
+
+// SendJSON sends a value as a JSON text message, aborting the test if it fails
+func (ws *WebSocket) SendJSON(v interface{}) {
+	b, err := json.Marshal(v)
+	if err == nil {
+		err = ws.conn.WriteText(b)
+	}
+	if err != nil {
+		panic(newTestResult(resultFatal, fmt.Sprintf("Could not send message: %s", err), 2, ws.t))
+	}
+}
+
+// Expect waits for the next message, failing the test if it does not arrive in time or the server closed the
+// connection
+func (ws *WebSocket) Expect(timeout time.Duration) string {
+	msg, err := ws.conn.ReadMessage(timeout)
+	if err != nil {
+		panic(newTestResult(resultFailed, fmt.Sprintf("No message received: %s", err), 2, ws.t))
+	}
+	return string(msg)
+}
+
+// ExpectJSON waits for the next message and decodes it as JSON into v, failing the test if it does not arrive in
+// time or cannot be decoded
+func (ws *WebSocket) ExpectJSON(timeout time.Duration, v interface{}) {
+	msg, err := ws.conn.ReadMessage(timeout)
+	if err == nil {
+		err = json.Unmarshal(msg, v)
+	}
+	if err != nil {
+		panic(newTestResult(resultFailed, fmt.Sprintf("No JSON message received: %s", err), 2, ws.t))
+	}
+}
+
+// ExpectClose waits for the server to close the connection, failing the test if it does not close it in time or
+// closes it with a different code. Messages received before the close are discarded
+func (ws *WebSocket) ExpectClose(code int, timeout time.Duration) {
+
+	deadline := time.Now().Add(timeout)
+	for {
+		_, err := ws.conn.ReadMessage(time.Until(deadline))
+		if err == wsclient.ErrClosed {
+			break
+		}
+		if err != nil {
+			panic(newTestResult(resultFailed, fmt.Sprintf("Connection not closed: %s", err), 2, ws.t))
+		}
+	}
+	if got, reason, _ := ws.conn.CloseStatus(); got != code {
+		panic(newTestResult(resultFailed, fmt.Sprintf("Expected close code %d, got %d (%s)", code, got, reason),
+			2, ws.t))
+	}
+}
+
+// Close closes the connection normally
+func (ws *WebSocket) Close() error {
+	return ws.conn.Close()
+}
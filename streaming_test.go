@@ -0,0 +1,102 @@
+package vertex
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/EverythingMe/vertex/wsclient"
+)
+
+// streamingServer serves an event stream on /stream/events, and a websocket echoing one message and closing on
+// /stream/ws
+func streamingServer() *httptest.Server {
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		switch r.URL.Path {
+		case "/stream/events":
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, ": comment\nid: 1\nevent: greeting\ndata: hello\ndata: world\n\n")
+			w.(http.Flusher).Flush()
+			fmt.Fprint(w, "data: bye\n\n")
+
+		case "/stream/ws":
+			conn, rw, _ := w.(http.Hijacker).Hijack()
+			defer conn.Close()
+			fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+				"Sec-WebSocket-Accept: %s\r\n\r\n", wsclient.AcceptKey(r.Header.Get("Sec-WebSocket-Key")))
+			rw.Flush()
+
+			wsclient.WriteFrame(conn, wsclient.OpPing, []byte("ping"), false)
+			_, _, msg, err := wsclient.ReadFrame(rw.Reader, wsclient.DefaultMaxMessageSize)
+			if err != nil {
+				return
+			}
+			wsclient.WriteFrame(conn, wsclient.OpText, append([]byte("echo: "), msg...), false)
+			wsclient.WriteFrame(conn, wsclient.OpClose, wsclient.ClosePayload(4000, "bye"), false)
+			wsclient.ReadFrame(bufio.NewReader(conn), wsclient.DefaultMaxMessageSize)
+
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestStreamingHelpers(t *testing.T) {
+
+	ts := streamingServer()
+	defer ts.Close()
+
+	a := &API{Name: "stream", Root: "/stream"}
+	runner := newTestRunner(nil, a, ts.URL, AllTests, TestFormatText)
+
+	res := runner.runTest(CriticalTest(func(ctx *TestContext) {
+		ctx.routePath = "/events"
+		req, _ := ctx.NewRequest("GET", nil, nil)
+		s := ctx.OpenEventStream(req)
+		defer s.Close()
+
+		assert.Equal(t, ServerEvent{ID: "1", Event: "greeting", Data: "hello\nworld"}, s.Expect(time.Second))
+		assert.Equal(t, ServerEvent{Data: "bye"}, s.Expect(time.Second))
+		s.Expect(time.Second)
+	}), "/events")
+	assert.Equal(t, resultFailed, res.Result)
+	assert.Contains(t, res.Message, "Event stream closed")
+
+	res = runner.runTest(CriticalTest(func(ctx *TestContext) {
+		ctx.routePath = "/ws"
+		ws := ctx.DialWebSocket(nil, nil)
+		defer ws.Close()
+
+		ws.SendJSON(map[string]int{"n": 1})
+		assert.Equal(t, `echo: {"n":1}`, ws.Expect(time.Second))
+		ws.ExpectClose(4000, time.Second)
+		_, reason, _ := ws.conn.CloseStatus()
+		assert.Equal(t, "bye", reason)
+	}), "/ws")
+	assert.Equal(t, resultPass, res.Result, res.Message)
+
+	res = runner.runTest(CriticalTest(func(ctx *TestContext) {
+		ctx.routePath = "/ws"
+		ws := ctx.DialWebSocket(nil, nil)
+		defer ws.Close()
+
+		ws.Send("hi")
+		ws.ExpectClose(CloseNormal, time.Second)
+	}), "/ws")
+	assert.Equal(t, resultFailed, res.Result)
+	assert.Equal(t, "Expected close code 1000, got 4000 (bye)", res.Message)
+
+	res = runner.runTest(CriticalTest(func(ctx *TestContext) {
+		ctx.routePath = "/nope"
+		ctx.DialWebSocket(nil, nil)
+	}), "/nope")
+	assert.Equal(t, resultFatal, res.Result)
+	assert.Contains(t, res.Message, "404")
+}
@@ -0,0 +1,157 @@
+package swagger
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Kinds of changes between two versions of an API
+const (
+	PathRemoved      = "path_removed"
+	PathAdded        = "path_added"
+	MethodRemoved    = "method_removed"
+	MethodAdded      = "method_added"
+	ParamRemoved     = "param_removed"
+	ParamAdded       = "param_added"
+	ParamRequired    = "param_required"
+	ParamOptional    = "param_optional"
+	ParamTypeChanged = "param_type_changed"
+	ParamMoved       = "param_moved"
+)
+
+// Change is a difference between two versions of an API. Breaking changes may break existing clients
+type Change struct {
+	Kind        string `json:"kind"`
+	Breaking    bool   `json:"breaking"`
+	Path        string `json:"path"`
+	Method      string `json:"method,omitempty"`
+	Param       string `json:"param,omitempty"`
+	Description string `json:"description"`
+}
+
+// Diff compares the paths and params of an old and a new version of an API, and returns the changes sorted by
+// path, method and param. Removed paths, methods and params, params that became required, new required params and
+// params that changed type or location are breaking
+func Diff(old, new *API) []Change {
+
+	ret := make([]Change, 0)
+
+	for path, oldPath := range old.Paths {
+		newPath, found := new.Paths[path]
+		if !found {
+			ret = append(ret, Change{Kind: PathRemoved, Breaking: true, Path: path,
+				Description: "Path was removed"})
+			continue
+		}
+
+		for method, oldMethod := range oldPath {
+			newMethod, found := newPath[method]
+			if !found {
+				ret = append(ret, Change{Kind: MethodRemoved, Breaking: true, Path: path, Method: method,
+					Description: "Method was removed"})
+				continue
+			}
+			ret = append(ret, diffParams(path, method, old.resolveParams(oldMethod), new.resolveParams(newMethod))...)
+		}
+
+		for method := range newPath {
+			if _, found := oldPath[method]; !found {
+				ret = append(ret, Change{Kind: MethodAdded, Path: path, Method: method, Description: "Method was added"})
+			}
+		}
+	}
+
+	for path := range new.Paths {
+		if _, found := old.Paths[path]; !found {
+			ret = append(ret, Change{Kind: PathAdded, Path: path, Description: "Path was added"})
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Path != ret[j].Path {
+			return ret[i].Path < ret[j].Path
+		}
+		if ret[i].Method != ret[j].Method {
+			return ret[i].Method < ret[j].Method
+		}
+		return ret[i].Param < ret[j].Param
+	})
+	return ret
+}
+
+// resolveParams returns the params of a method by name, resolving references to global params
+func (a *API) resolveParams(m Method) map[string]Param {
+
+	ret := make(map[string]Param, len(m.Parameters))
+	for _, p := range m.Parameters {
+		if p.Ref != "" {
+			var name string
+			if _, err := fmt.Sscanf(p.Ref, "#/parameters/%s", &name); err == nil {
+				if gp, found := a.Parameters[name]; found {
+					p = gp
+				}
+			}
+		}
+		if p.Name != "" {
+			ret[p.Name] = p
+		}
+	}
+	return ret
+}
+
+func diffParams(path, method string, old, new map[string]Param) []Change {
+
+	var ret []Change
+	change := func(kind string, breaking bool, param, format string, args ...interface{}) {
+		ret = append(ret, Change{Kind: kind, Breaking: breaking, Path: path, Method: method, Param: param,
+			Description: fmt.Sprintf(format, args...)})
+	}
+
+	for name, op := range old {
+		np, found := new[name]
+		if !found {
+			change(ParamRemoved, true, name, "Param was removed")
+			continue
+		}
+		if !op.Required && np.Required {
+			change(ParamRequired, true, name, "Param became required")
+		} else if op.Required && !np.Required {
+			change(ParamOptional, false, name, "Param became optional")
+		}
+		if op.Type != np.Type || op.Items != np.Items {
+			change(ParamTypeChanged, true, name, "Param type changed from %s to %s", typeName(op), typeName(np))
+		}
+		if op.In != np.In {
+			change(ParamMoved, true, name, "Param moved from %s to %s", op.In, np.In)
+		}
+	}
+
+	for name, np := range new {
+		if _, found := old[name]; !found {
+			if np.Required {
+				change(ParamAdded, true, name, "Required param was added")
+			} else {
+				change(ParamAdded, false, name, "Optional param was added")
+			}
+		}
+	}
+
+	return ret
+}
+
+func typeName(p Param) string {
+	if p.Type == Array {
+		return fmt.Sprintf("%s of %s", p.Type, p.Items)
+	}
+	return string(p.Type)
+}
+
+// HasBreakingChanges returns true if any of the changes is breaking
+func HasBreakingChanges(changes []Change) bool {
+	for _, c := range changes {
+		if c.Breaking {
+			return true
+		}
+	}
+	return false
+}
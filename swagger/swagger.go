@@ -1,9 +1,11 @@
 package swagger
 
 import (
-	"github.com/alecthomas/jsonschema"
-
+	"encoding/json"
 	"reflect"
+	"strings"
+
+	"github.com/alecthomas/jsonschema"
 )
 
 const SwaggerVersion = "2.0"
@@ -84,15 +86,28 @@ type Param struct {
 	In        string      `json:"in,omitempty"`
 	Global    bool        `json:"-"`
 	Ref       string      `json:"$ref,omitempty"`
+
+	// The schema of body params
+	Schema Schema `json:"schema,omitempty"`
 }
 
 // Schema is a generic jsonschema definition - TBD how we want to represent it
 type Schema *jsonschema.Schema
 
+// Header describes a response header
+type Header struct {
+	Type        Type   `json:"type"`
+	Description string `json:"description,omitempty"`
+}
+
 // Response describes a response schema
 type Response struct {
-	Description string `json:"description"`
-	Schema      Schema `json:"schema"`
+	Description string            `json:"description"`
+	Schema      Schema            `json:"schema,omitempty"`
+	Headers     map[string]Header `json:"headers,omitempty"`
+
+	// Example responses by mime type
+	Examples map[string]interface{} `json:"examples,omitempty"`
 }
 
 // Method describes an API method
@@ -103,10 +118,23 @@ type Method struct {
 	Parameters  []Param             `json:"parameters,omitempty"`
 	Responses   map[string]Response `json:"responses"`
 	Tags        []string            `json:"tags",omitempty`
+
+	// Vendor extensions documenting the API version the method was added in, and how it changed by version
+	Since     string            `json:"x-since,omitempty"`
+	ChangedIn map[string]string `json:"x-changed-in,omitempty"`
+
+	// Swagger 2.0 has no cookie params, so they are documented in a vendor extension
+	Cookies []Param `json:"x-cookies,omitempty"`
 }
 
 type Path map[string]Method
 
+// ErrorCode documents a public error code the API may return
+type ErrorCode struct {
+	Status      int    `json:"status"`
+	Description string `json:"description,omitempty"`
+}
+
 // API describes the base of the API
 type API struct {
 	SwaggerVersion string            `json:"swagger"`
@@ -119,6 +147,36 @@ type API struct {
 	Paths          map[string]Path   `json:"paths"`
 	Definitions    map[string]Schema `json:"definitions,omitempty"`
 	Parameters     map[string]Param  `json:"parameters,omitempty"`
+
+	// Vendor extension enumerating the public error codes of the API
+	ErrorCodes map[string]ErrorCode `json:"x-error-codes,omitempty"`
+
+	// Additional vendor extensions, serialized as top level x- fields
+	Extensions map[string]interface{} `json:"-"`
+}
+
+// MarshalJSON serializes the API with its vendor extensions inlined
+func (a *API) MarshalJSON() ([]byte, error) {
+
+	type plain API
+	b, err := json.Marshal((*plain)(a))
+	if err != nil || len(a.Extensions) == 0 {
+		return b, err
+	}
+
+	m := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	for k, v := range a.Extensions {
+		if !strings.HasPrefix(k, "x-") {
+			k = "x-" + k
+		}
+		if m[k], err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(m)
 }
 
 func NewAPI(host, title, description, version, basePath string, schemes []string) *API {
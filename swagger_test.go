@@ -64,6 +64,8 @@ func TestSwagger(t *testing.T) {
 				Path:        "/func/handler",
 				Description: "Test handling by a pure func",
 				Methods:     vertex.POST,
+				Since:       "0.9",
+				ChangedIn:   map[string]string{"0.10": "Returns WAT WAT", "0.9.1": "Accepts POST"},
 				Handler: vertex.HandlerFunc(func(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
 					return "WAT WAT", nil
 				}),
@@ -110,6 +112,13 @@ func TestSwagger(t *testing.T) {
 		assertEqual(t, v, v2, "Path mismatch \n%#v\n%#v")
 	}
 
+	changed := sw.Paths["/func/handler"]["post"]
+	assertEqual(t, changed.Since, "0.9")
+	assertEqual(t, changed.ChangedIn["0.10"], "Returns WAT WAT")
+	assertEqual(t, changed.Description, "Test handling by a pure func\n\nSince version 0.9.\n\n"+
+		"Changed in 0.9.1: Accepts POST\n\nChanged in 0.10: Returns WAT WAT")
+	assertEqual(t, sw.Paths["/user/{id}"]["get"].Description, "Get User Info by id or name")
+
 	//fmt.Println(sw)
 
 }
@@ -0,0 +1,223 @@
+package vertex
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// maxSwaggerHosts bounds the number of hosts the swagger document is cached for when no public host is configured,
+// since the host then comes from the request. Documents for other hosts are rendered on every request
+const maxSwaggerHosts = 16
+
+// ContentEncoder creates a compressing writer for a content encoding
+type ContentEncoder func(w io.Writer) (io.WriteCloser, error)
+
+var swaggerEncodings = struct {
+	sync.RWMutex
+	names    []string
+	encoders map[string]ContentEncoder
+}{
+	names: []string{"gzip"},
+	encoders: map[string]ContentEncoder{
+		"gzip": func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriterLevel(w, gzip.BestCompression)
+		},
+	},
+}
+
+// RegisterSwaggerEncoding adds a content encoding the swagger document is pre-compressed with. gzip is built in;
+// brotli can be added with a brotli library:
+//
+//	vertex.RegisterSwaggerEncoding("br", func(w io.Writer) (io.WriteCloser, error) {
+//		return brotli.NewWriterLevel(w, brotli.BestCompression), nil
+//	})
+//
+// When a client accepts several encodings equally, the last registered one is preferred
+func RegisterSwaggerEncoding(name string, encoder ContentEncoder) {
+	swaggerEncodings.Lock()
+	defer swaggerEncodings.Unlock()
+
+	if _, found := swaggerEncodings.encoders[name]; !found {
+		swaggerEncodings.names = append(swaggerEncodings.names, name)
+	}
+	swaggerEncodings.encoders[name] = encoder
+}
+
+// swaggerDoc is the serialized swagger document of an API for a host, with its pre-compressed variants
+type swaggerDoc struct {
+	hash     string
+	variants map[string][]byte
+}
+
+// newSwaggerDoc serializes a swagger document and compresses it with all the registered encodings
+func newSwaggerDoc(v interface{}) (*swaggerDoc, error) {
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(data)
+	doc := &swaggerDoc{
+		hash:     hex.EncodeToString(sum[:10]),
+		variants: map[string][]byte{"": data},
+	}
+
+	swaggerEncodings.RLock()
+	defer swaggerEncodings.RUnlock()
+
+	for name, encoder := range swaggerEncodings.encoders {
+		buf := bytes.NewBuffer(nil)
+		w, err := encoder(buf)
+		if err == nil {
+			if _, err = w.Write(data); err == nil {
+				err = w.Close()
+			}
+		}
+		if err != nil {
+			logging.Error("Could not compress swagger document with %s: %s", name, err)
+			continue
+		}
+		doc.variants[name] = buf.Bytes()
+	}
+
+	return doc, nil
+}
+
+// etag returns the entity tag of a variant of the document. Every encoding has its own tag, as required for strong
+// validators
+func (d *swaggerDoc) etag(encoding string) string {
+	if encoding == "" {
+		return ETag(d.hash)
+	}
+	return ETag(d.hash + "-" + encoding)
+}
+
+// negotiateEncoding picks the available encoding a client prefers by its Accept-Encoding header, or an empty
+// string for the uncompressed document
+func negotiateEncoding(header string, available map[string][]byte) string {
+
+	accepted := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			if kv := strings.SplitN(strings.TrimSpace(param), "=", 2); len(kv) == 2 && kv[0] == "q" {
+				if v, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		accepted[name] = q
+	}
+
+	swaggerEncodings.RLock()
+	defer swaggerEncodings.RUnlock()
+
+	best, bestQ := "", 0.0
+	for i := len(swaggerEncodings.names) - 1; i >= 0; i-- {
+		name := swaggerEncodings.names[i]
+		if _, found := available[name]; !found {
+			continue
+		}
+		q, found := accepted[name]
+		if !found {
+			q = accepted["*"]
+		}
+		if q > bestQ {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// serve writes the variant of the document the client prefers, or 304 if the client has it already
+func (d *swaggerDoc) serve(w http.ResponseWriter, r *Request) {
+
+	encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), d.variants)
+	etag := d.etag(encoding)
+
+	h := w.Header()
+	h.Set("Content-Type", "application/json; charset=utf-8")
+	AddVary(h, "Accept-Encoding")
+	h.Set("ETag", etag)
+	h.Set("Cache-Control", "no-cache")
+	h.Set(HeaderRequestId, r.RequestId)
+
+	for _, tag := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		if tag = strings.TrimSpace(tag); tag == etag || tag == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	body := d.variants[encoding]
+	if encoding != "" {
+		h.Set("Content-Encoding", encoding)
+	}
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.Write(body)
+}
+
+// swaggerHost returns the host the swagger document of a request is rendered for: the configured public host, or
+// the Host header of the request
+func swaggerHost(r *Request) string {
+	if Config.Server.PublicHost != "" {
+		return Config.Server.PublicHost
+	}
+	return r.Host
+}
+
+// swaggerDocs caches the serialized swagger documents of an API by host. The cache is dropped when the route
+// overrides are reloaded
+type swaggerDocs struct {
+	mtx        sync.Mutex
+	docs       map[string]*swaggerDoc
+	generation uint64
+}
+
+// get returns the cached document for a host, creating it if needed. It returns nil if the document cannot be
+// cached
+func (c *swaggerDocs) get(a *API, host string) *swaggerDoc {
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if gen := routeOverridesGeneration(); gen != c.generation {
+		c.docs = nil
+		c.generation = gen
+	}
+
+	if doc, found := c.docs[host]; found {
+		return doc
+	}
+	if len(c.docs) >= maxSwaggerHosts {
+		return nil
+	}
+
+	doc, err := newSwaggerDoc(a.ToSwagger(host))
+	if err != nil {
+		logging.Error("Could not serialize swagger document: %s", err)
+		return nil
+	}
+
+	if c.docs == nil {
+		c.docs = map[string]*swaggerDoc{}
+	}
+	c.docs[host] = doc
+	return doc
+}
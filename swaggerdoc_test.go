@@ -0,0 +1,109 @@
+package vertex
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/EverythingMe/vertex/swagger"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+
+	available := map[string][]byte{"": nil, "gzip": nil, "br": nil}
+	RegisterSwaggerEncoding("br", func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriter(w), nil
+	})
+
+	assert.Equal(t, "", negotiateEncoding("", available))
+	assert.Equal(t, "gzip", negotiateEncoding("gzip", available))
+	assert.Equal(t, "br", negotiateEncoding("gzip, deflate, br", available))
+	assert.Equal(t, "gzip", negotiateEncoding("gzip;q=1.0, br;q=0.5", available))
+	assert.Equal(t, "", negotiateEncoding("gzip;q=0, br;q=0", available))
+	assert.Equal(t, "br", negotiateEncoding("*", available))
+	assert.Equal(t, "gzip", negotiateEncoding("*, br;q=0", available))
+	assert.Equal(t, "gzip", negotiateEncoding("br, gzip", map[string][]byte{"": nil, "gzip": nil}))
+}
+
+func TestSwaggerCompression(t *testing.T) {
+
+	a := &API{
+		Name:          "swaggerzip",
+		Version:       "1.0",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{
+				Path:        "/foo",
+				Description: "Foo",
+				Methods:     GET,
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					return "foo", nil
+				}),
+			},
+		},
+	}
+	srv := NewServer(":9962")
+	srv.AddAPI(a)
+
+	get := func(encoding, etag string) *httptest.ResponseRecorder {
+		hr, _ := http.NewRequest("GET", a.FullPath("/swagger"), nil)
+		hr.Host = "example.com"
+		hr.Header.Set("Accept-Encoding", encoding)
+		hr.Header.Set("If-None-Match", etag)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, hr)
+		return w
+	}
+
+	plain := get("", "")
+	assert.Equal(t, http.StatusOK, plain.Code)
+	assert.Equal(t, "", plain.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", plain.Header().Get("Vary"))
+	var sw swagger.API
+	assert.NoError(t, json.Unmarshal(plain.Body.Bytes(), &sw))
+	assert.Equal(t, "example.com", sw.Host)
+
+	zipped := get("gzip", "")
+	assert.Equal(t, "gzip", zipped.Header().Get("Content-Encoding"))
+	assert.NotEqual(t, plain.Header().Get("ETag"), zipped.Header().Get("ETag"))
+	zr, err := gzip.NewReader(bytes.NewReader(zipped.Body.Bytes()))
+	assert.NoError(t, err)
+	data, err := ioutil.ReadAll(zr)
+	assert.NoError(t, err)
+	assert.Equal(t, plain.Body.Bytes(), data)
+
+	// clients revalidate with the tag of their variant
+	assert.Equal(t, http.StatusNotModified, get("gzip", zipped.Header().Get("ETag")).Code)
+	assert.Equal(t, http.StatusOK, get("", zipped.Header().Get("ETag")).Code)
+
+	// the configured public host is used regardless of the Host header
+	Config.Server.PublicHost = "api.example.com"
+	defer func() { Config.Server.PublicHost = "" }()
+	sw = swagger.API{}
+	assert.NoError(t, json.Unmarshal(get("", "").Body.Bytes(), &sw))
+	assert.Equal(t, "api.example.com", sw.Host)
+}
+
+func TestSwaggerDocsInvalidation(t *testing.T) {
+
+	a := &API{Name: "swaggerdocs", Version: "1.0", Renderer: JSONRenderer{}}
+	docs := &swaggerDocs{}
+
+	doc := docs.get(a, "example.com")
+	assert.NotNil(t, doc)
+	assert.True(t, doc == docs.get(a, "example.com"))
+
+	// reloading the route overrides drops the cached documents
+	routeOverrides.Lock()
+	routeOverrides.generation++
+	routeOverrides.Unlock()
+	assert.False(t, doc == docs.get(a, "example.com"))
+}
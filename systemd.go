@@ -0,0 +1,90 @@
+package vertex
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// systemd socket activation passes listening sockets starting at this fd
+const sdListenFdsStart = 3
+
+// systemdListener returns the first socket passed to us by systemd socket activation (LISTEN_FDS).
+// If we were not socket activated, it returns nil
+func systemdListener() (net.Listener, error) {
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || nfds < 1 {
+		return nil, nil
+	}
+
+	// the sockets are ours now, do not pass them on to child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	if nfds > 1 {
+		logging.Warning("Got %d sockets from systemd, using only the first one", nfds)
+	}
+
+	f := os.NewFile(uintptr(sdListenFdsStart), "systemd-listener")
+	defer f.Close()
+
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("Could not use systemd socket: %s", err)
+	}
+
+	logging.Info("Using systemd activated socket on %s", l.Addr())
+	return l, nil
+}
+
+// sdNotify sends a state notification to systemd (see sd_notify(3)), if we are running under a Type=notify unit.
+// It does nothing if NOTIFY_SOCKET is not set
+func sdNotify(state string) error {
+
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+
+	// abstract namespace sockets are prefixed with @
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("Could not connect to systemd notify socket: %s", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// notifySystemd sends a notification to systemd, logging any errors
+func notifySystemd(state string) {
+	if err := sdNotify(state); err != nil {
+		logging.Error("Error notifying systemd: %s", err)
+	}
+}
+
+// Notification states sent to systemd during the server lifecycle
+const (
+	sdReady    = "READY=1"
+	sdStopping = "STOPPING=1"
+)
+
+// sdReadyState is the READY notification, which also tells systemd our pid so upgraded processes become the main pid
+func sdReadyState() string {
+	return fmt.Sprintf("%s\nMAINPID=%d", sdReady, os.Getpid())
+}
@@ -0,0 +1,49 @@
+package vertex
+
+import (
+	"net"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSdNotify(t *testing.T) {
+
+	// no socket - nothing to do
+	os.Unsetenv("NOTIFY_SOCKET")
+	assert.NoError(t, sdNotify(sdReady))
+
+	sock := path.Join(os.TempDir(), "vertex-sdnotify.sock")
+	os.Remove(sock)
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sock, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	defer os.Remove(sock)
+
+	os.Setenv("NOTIFY_SOCKET", sock)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+
+	assert.NoError(t, sdNotify(sdStopping))
+
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, sdStopping, string(buf[:n]))
+}
+
+func TestSystemdListenerNotActivated(t *testing.T) {
+
+	os.Setenv("LISTEN_PID", "1")
+	os.Setenv("LISTEN_FDS", "1")
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+
+	// the fds are meant for another process
+	l, err := systemdListener()
+	assert.NoError(t, err)
+	assert.Nil(t, l)
+}
@@ -0,0 +1,95 @@
+package vertex
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// testers run concurrently, while Fixtures implementations need not be safe for concurrent use
+var seedMtx sync.Mutex
+
+// Fixtures manage the test data of an API, so testers create the data they need instead of assuming a pre-populated
+// environment. Testers seed data with TestContext.Seed, and the runner cleans it after all the tests ran
+type Fixtures interface {
+	// Seed creates test data of a kind, e.g. a user, and returns it
+	Seed(kind string, params Params) (interface{}, error)
+
+	// Clean removes the seeded test data
+	Clean() error
+}
+
+// TestHooks run before and after the tests of a category, e.g. to seed the database and clean its tables. Hooks of
+// AllTests run before and after all the tests. A failing setup fails the run, and the tests of its category are
+// not run
+type TestHooks struct {
+	Setup    func(t *TestContext)
+	Teardown func(t *TestContext)
+}
+
+// Seed creates test data of a kind with the API's test fixtures, aborting the test if it fails
+func (t *TestContext) Seed(kind string, params Params) interface{} {
+
+	if t.api == nil || t.api.TestFixtures == nil {
+		panic(newTestResult(resultFatal, "API has no test fixtures", 2, t))
+	}
+
+	seedMtx.Lock()
+	defer seedMtx.Unlock()
+
+	v, err := t.api.TestFixtures.Seed(kind, params)
+	if err != nil {
+		panic(newTestResult(resultFatal, fmt.Sprintf("Could not seed %s: %s", kind, err), 2, t))
+	}
+	return v
+}
+
+// testHookCategories returns the categories with hooks in the order their setups run: AllTests first
+func testHookCategories(hooks map[string]TestHooks, categories map[string]bool) []string {
+
+	ret := []string{}
+	for c := range hooks {
+		if c != AllTests && categories[c] {
+			ret = append(ret, c)
+		}
+	}
+	sort.Strings(ret)
+
+	if _, found := hooks[AllTests]; found {
+		ret = append([]string{AllTests}, ret...)
+	}
+	return ret
+}
+
+// runHook runs a setup or teardown hook, reporting it only if it failed
+func (t *testRunner) runHook(name, category string, f func(*TestContext)) bool {
+
+	if f == nil {
+		return true
+	}
+
+	res := t.runTest(testFunc{f, category}, fmt.Sprintf("%s:%s", name, category))
+	if !res.isFailure() {
+		return true
+	}
+	if err := t.formatter.format(res); err != nil {
+		logging.Error("Error running formatter: %s", err)
+	}
+	return false
+}
+
+// cleanFixtures cleans the test data of the API's fixtures, reporting a failure
+func (t *testRunner) cleanFixtures() bool {
+
+	if t.api.TestFixtures == nil {
+		return true
+	}
+
+	return t.runHook("teardown", "fixtures", func(ctx *TestContext) {
+		if err := t.api.TestFixtures.Clean(); err != nil {
+			ctx.Fatal("Could not clean test data: %s", err)
+		}
+	})
+}
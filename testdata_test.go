@@ -0,0 +1,80 @@
+package vertex
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockFixtures struct {
+	seeded []string
+	clean  bool
+}
+
+func (f *mockFixtures) Seed(kind string, params Params) (interface{}, error) {
+	if kind != "user" {
+		return nil, errors.New("unknown kind")
+	}
+	f.seeded = append(f.seeded, params["name"])
+	return len(f.seeded), nil
+}
+
+func (f *mockFixtures) Clean() error {
+	f.seeded = nil
+	f.clean = true
+	return nil
+}
+
+func TestTestHooks(t *testing.T) {
+
+	var calls []string
+	hook := func(name string) func(*TestContext) {
+		return func(*TestContext) { calls = append(calls, name) }
+	}
+
+	fixtures := &mockFixtures{}
+	var seeded interface{}
+	a := &API{
+		Name:         "hooks",
+		Root:         "/hooks",
+		TestFixtures: fixtures,
+		TestHooks: map[string]TestHooks{
+			AllTests:      {Setup: hook("setup all"), Teardown: hook("teardown all")},
+			CriticalTests: {Setup: hook("setup critical"), Teardown: hook("teardown critical")},
+			WarningTests:  {Setup: hook("setup warning"), Teardown: hook("teardown warning")},
+		},
+		Routes: Routes{
+			{Path: "/user", Methods: GET, Handler: VoidHandler{}, Test: CriticalTest(func(ctx *TestContext) {
+				calls = append(calls, "test")
+				seeded = ctx.Seed("user", Params{"name": "bob"})
+				assert.Equal(t, []string{"bob"}, fixtures.seeded)
+			})},
+		},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	assert.True(t, newTestRunner(buf, a, "http://localhost", AllTests, TestFormatText).Run(), buf.String())
+	// hooks of categories without tests do not run
+	assert.Equal(t, []string{"setup all", "setup critical", "test", "teardown critical", "teardown all"}, calls)
+	assert.Equal(t, 1, seeded)
+	assert.True(t, fixtures.clean)
+	assert.Empty(t, fixtures.seeded)
+
+	// a failing setup skips the tests of its category
+	calls = nil
+	a.TestHooks[CriticalTests] = TestHooks{Setup: func(ctx *TestContext) { ctx.Fail("no database") }}
+	buf.Reset()
+	assert.False(t, newTestRunner(buf, a, "http://localhost", AllTests, TestFormatText).Run())
+	assert.Equal(t, []string{"setup all", "teardown all"}, calls)
+	assert.Contains(t, buf.String(), "setup:critical")
+	assert.Contains(t, buf.String(), "no database")
+
+	// failing to seed aborts the test
+	a.TestHooks = nil
+	a.Routes[0].Test = CriticalTest(func(ctx *TestContext) { ctx.Seed("order", nil) })
+	buf.Reset()
+	assert.False(t, newTestRunner(buf, a, "http://localhost", AllTests, TestFormatText).Run())
+	assert.Contains(t, buf.String(), "Could not seed order: unknown kind")
+}
@@ -0,0 +1,142 @@
+package vertex
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// TestEnvironment is a target the test runner can run the tests against, e.g. a local server, staging, or a
+// production smoke test, with the credentials and headers its requests need. Environments are defined in the
+// server config:
+//
+//	server:
+//	  test_environments:
+//	    - name: staging
+//	      url: https://staging.example.com
+//	      token: secret:env:STAGING_TEST_TOKEN
+//	      headers:
+//	        X-Test-Run: "true"
+//
+// The credentials may reference secrets, see SecretPrefix
+type TestEnvironment struct {
+	Name string `yaml:"name"`
+
+	// The base URL of the server, e.g. https://staging.example.com
+	URL string `yaml:"url"`
+
+	// Basic auth credentials of requests. Optional
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+
+	// A bearer token sent in the Authorization header of requests. Optional
+	Token string `yaml:"token"`
+
+	// Extra headers of requests. Optional
+	Headers map[string]string `yaml:"headers"`
+}
+
+// testEnvironment returns the configured test environment of a name
+func testEnvironment(name string) (*TestEnvironment, error) {
+	for i := range Config.Server.TestEnvironments {
+		if env := &Config.Server.TestEnvironments[i]; env.Name == name {
+			return env, nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown test environment %s", name)
+}
+
+// apply sets the credentials and headers of the environment on a request
+func (e *TestEnvironment) apply(r *http.Request) {
+
+	for k, v := range e.Headers {
+		r.Header.Set(k, v)
+	}
+	if e.User != "" {
+		r.SetBasicAuth(e.User, e.Password)
+	}
+	if e.Token != "" {
+		r.Header.Set("Authorization", "Bearer "+e.Token)
+	}
+}
+
+// setEnvironment points the runner at an environment
+func (t *testRunner) setEnvironment(env *TestEnvironment) {
+	t.env = env
+	t.serverURL = env.URL
+	t.forwardSecure = false
+}
+
+// testBaseURL returns the base URL the API's test endpoint runs the tests against. Tests run against the local
+// listen address, unless the test request was secure - directly or behind a TLS terminating proxy, as told by
+// X-Forwarded-Proto - or the API does not allow insecure requests, and its host is a test environment. Then they run
+// over https against that host. The scheme and host can be overridden with the scheme and host params of the test
+// request.
+//
+// The local listener only serves plain http, so secure tests against it are sent over http and marked as forwarded
+// over https, as a TLS terminating proxy would. The returned forwardSecure flag tells the runner to do so.
+//
+// Since failure reports include the responses of the tested server, tests only run against the local listener and
+// the hosts of the configured test environments - never against an arbitrary host named by the test request
+func (a *API) testBaseURL(r *Request, localAddr string) (baseURL string, forwardSecure bool, err error) {
+
+	scheme := r.FormValue("scheme")
+	switch scheme {
+	case "http", "https":
+	case "":
+		scheme = "http"
+		if r.Secure || !a.AllowInsecure {
+			scheme = "https"
+		}
+	default:
+		return "", false, InvalidParamError("Invalid scheme %s", scheme)
+	}
+
+	host := r.FormValue("host")
+	if host == "" {
+		if scheme == "https" && !testHostLocal(r.Host, localAddr) && testHostAllowed(r.Host, localAddr) {
+			return fmt.Sprintf("https://%s", r.Host), false, nil
+		}
+		return fmt.Sprintf("http://%s", localAddr), scheme == "https", nil
+	} else if !testHostAllowed(host, localAddr) {
+		return "", false, InvalidParamError("Tests cannot run against %s, it is not the local server or a test environment", host)
+	}
+
+	return fmt.Sprintf("%s://%s", scheme, host), false, nil
+}
+
+// testHostLocal tells if a host is the local listener
+func testHostLocal(host, localAddr string) bool {
+
+	if host == localAddr {
+		return true
+	}
+
+	_, localPort, _ := net.SplitHostPort(localAddr)
+	if name, port, err := net.SplitHostPort(host); err == nil && port == localPort {
+		switch name {
+		case "localhost", "127.0.0.1", "::1":
+			return true
+		}
+	}
+	return false
+}
+
+// testHostAllowed tells if tests may run against a host: the local listener, or the host of a test environment
+func testHostAllowed(host, localAddr string) bool {
+
+	if host == "" {
+		return false
+	}
+	if testHostLocal(host, localAddr) {
+		return true
+	}
+
+	for _, env := range Config.Server.TestEnvironments {
+		if u, err := url.Parse(env.URL); err == nil && u.Host == host {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package vertex
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTestEnvironments(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, _ := r.BasicAuth()
+		json.NewEncoder(w).Encode(map[string]string{
+			"path":     r.URL.Path,
+			"user":     user,
+			"password": password,
+			"run":      r.Header.Get("X-Test-Run"),
+		})
+	}))
+	defer ts.Close()
+
+	defer func(envs []TestEnvironment) { Config.Server.TestEnvironments = envs }(Config.Server.TestEnvironments)
+	Config.Server.TestEnvironments = []TestEnvironment{
+		{Name: "local", URL: "http://127.0.0.1:1"},
+		{Name: "staging", URL: ts.URL, User: "tester", Password: "s3cret", Headers: map[string]string{"X-Test-Run": "1"}},
+	}
+
+	_, err := testEnvironment("prod")
+	assert.Error(t, err)
+
+	env, err := testEnvironment("staging")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	var got map[string]string
+	a := &API{
+		Name: "envs",
+		Root: "/envs",
+		Routes: Routes{
+			{Path: "/whoami", Methods: GET, Handler: VoidHandler{}, Test: CriticalTest(func(ctx *TestContext) {
+				req, _ := ctx.NewRequest("GET", nil, nil)
+				if _, err := ctx.GetJSON(req, &got); err != nil {
+					ctx.Fail("Request failed: %s", err)
+				}
+			})},
+		},
+	}
+
+	runner := newTestRunner(bytes.NewBuffer(nil), a, "http://127.0.0.1:1", AllTests, TestFormatText)
+	runner.setEnvironment(env)
+	assert.True(t, runner.Run())
+	assert.Equal(t, map[string]string{"path": "/envs/whoami", "user": "tester", "password": "s3cret", "run": "1"}, got)
+
+	// bearer tokens
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	(&TestEnvironment{Token: "abc"}).apply(req)
+	assert.Equal(t, "Bearer abc", req.Header.Get("Authorization"))
+}
+
+func TestTestEndpointRequiresAdmin(t *testing.T) {
+
+	a := &API{Name: "testauth", Version: "1.0", Renderer: JSONRenderer{}, AllowInsecure: true}
+	srv := NewServer(":9935")
+	srv.AddAPI(a)
+
+	test := func(user, password string) int {
+		hr, _ := http.NewRequest("GET", "/test/testauth/1.0/all?env=staging", nil)
+		if user != "" {
+			hr.SetBasicAuth(user, password)
+		}
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, hr)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, test("", ""))
+	assert.Equal(t, http.StatusUnauthorized, test(Config.Auth.User, "wrong"))
+	assert.NotEqual(t, http.StatusUnauthorized, test(Config.Auth.User, Config.Auth.Password))
+}
+
+func TestTestBaseURL(t *testing.T) {
+
+	a := &API{AllowInsecure: true}
+	check := func(target string, header http.Header, expected string, forwardSecure bool) {
+		hr, _ := http.NewRequest("GET", target, nil)
+		hr.Host = "api.example.com"
+		for k, v := range header {
+			hr.Header[k] = v
+		}
+		u, forward, err := a.testBaseURL(NewRequest(hr), "127.0.0.1:9944")
+		assert.NoError(t, err)
+		assert.Equal(t, expected, u, target)
+		assert.Equal(t, forwardSecure, forward, target)
+	}
+
+	defer func(envs []TestEnvironment) { Config.Server.TestEnvironments = envs }(Config.Server.TestEnvironments)
+	Config.Server.TestEnvironments = []TestEnvironment{{Name: "prod", URL: "https://api.example.com"}}
+
+	check("/test", nil, "http://127.0.0.1:9944", false)
+	check("/test", http.Header{"X-Forwarded-Proto": {"https"}}, "https://api.example.com", false)
+	check("/test?scheme=http", http.Header{"X-Forwarded-Proto": {"https"}}, "http://127.0.0.1:9944", false)
+	check("/test?scheme=https&host=localhost:9944", nil, "https://localhost:9944", false)
+
+	a.AllowInsecure = false
+	check("/test", nil, "https://api.example.com", false)
+
+	fail := func(target, host string) {
+		hr, _ := http.NewRequest("GET", target, nil)
+		hr.Host = host
+		_, _, err := a.testBaseURL(NewRequest(hr), "127.0.0.1:9944")
+		assert.Error(t, err, target)
+	}
+	fail("/test?scheme=ftp", "api.example.com")
+
+	// tests never run against hosts that are not the local server or a test environment
+	fail("/test?host=169.254.169.254", "api.example.com")
+	fail("/test?host=localhost:8443", "api.example.com")
+
+	// the local listener serves plain http, secure tests against it are forwarded over https
+	for _, host := range []string{"evil.example.com", "127.0.0.1:9944"} {
+		hr, _ := http.NewRequest("GET", "/test", nil)
+		hr.Host = host
+		u, forward, err := a.testBaseURL(NewRequest(hr), "127.0.0.1:9944")
+		assert.NoError(t, err)
+		assert.Equal(t, "http://127.0.0.1:9944", u)
+		assert.True(t, forward)
+	}
+
+	var secure bool
+	tls := &API{
+		Name: "tls",
+		Root: "/tls",
+		Routes: Routes{
+			{Path: "/secure", Methods: GET, Handler: VoidHandler{}, Test: CriticalTest(func(ctx *TestContext) {
+				req, _ := ctx.NewRequest("GET", nil, nil)
+				assert.Equal(t, "https", req.Header.Get("X-Forwarded-Proto"))
+				assert.Equal(t, "http", req.URL.Scheme)
+				secure = NewRequest(req).Secure
+			})},
+		},
+	}
+	runner := newTestRunner(bytes.NewBuffer(nil), tls, "http://127.0.0.1:9944", AllTests, TestFormatText)
+	runner.forwardSecure = true
+	assert.True(t, runner.Run())
+	assert.True(t, secure)
+}
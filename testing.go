@@ -11,6 +11,7 @@ import (
 	"os"
 	"path"
 	"runtime"
+	"sort"
 	"sync"
 	"text/tabwriter"
 	"time"
@@ -34,6 +35,9 @@ const (
 	CriticalTests = "critical"
 	WarningTests  = "warning"
 	AllTests      = "all"
+
+	// Built in security probes of every route, see routeProber. They only run when this category is selected
+	SecurityTests = "security"
 )
 
 type testFunc struct {
@@ -71,6 +75,16 @@ type TestContext struct {
 	category  string
 	messages  []string
 	startTime time.Time
+	fixtures  *fixtureSession
+	values    map[string]interface{}
+
+	exchangesMtx sync.Mutex
+	exchanges    []*testExchange
+	coverage     *testCoverage
+	env          *TestEnvironment
+
+	// requests to the local plain http listener are marked as forwarded over https
+	forwardSecure bool
 }
 
 // Log writes a message to be displayed alongside the test result ONLY if the test failed
@@ -113,14 +127,16 @@ func (t *TestContext) FormatUrl(pathParams Params) string {
 	return u
 }
 
-// NewRequest creates a new http request to the route we are testing now, with optional values for post/get, and optional path params
+// NewRequest creates a new http request to the route we are testing now, with optional values for post/get, and optional path params.
+// Requests to a test environment carry its credentials and headers, and secure requests to the local listener are
+// marked as forwarded over https
 func (t *TestContext) NewRequest(method string, values url.Values, pathParams Params) (*http.Request, error) {
 
 	var body io.Reader
 	u := t.FormatUrl(pathParams)
 
 	if values != nil && len(values) > 0 {
-		if method == "POST" {
+		if method == "POST" || method == "PUT" || method == "PATCH" {
 
 			body = bytes.NewReader([]byte(values.Encode()))
 		} else {
@@ -130,10 +146,16 @@ func (t *TestContext) NewRequest(method string, values url.Values, pathParams Pa
 
 	req, err := http.NewRequest(method, u, body)
 
-	// for POST requests we need to correctly set the content type
+	// for requests with a body we need to correctly set the content type
 	if err == nil && body != nil {
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	}
+	if err == nil && t.forwardSecure {
+		req.Header.Set("X-Forwarded-Proto", "https")
+	}
+	if err == nil && t.env != nil {
+		t.env.apply(req)
+	}
 	return req, err
 }
 
@@ -142,17 +164,38 @@ func (t *TestContext) NewRequest(method string, values url.Values, pathParams Pa
 // The raw http response is also returned for inspection
 func (t *TestContext) GetJSON(r *http.Request, v interface{}) (*http.Response, error) {
 
+	if t.coverage != nil {
+		t.coverage.record(r)
+	}
+
+	exchange := newTestExchange(r)
+	start := time.Now()
+
 	resp, err := http.DefaultClient.Do(r)
 	if err != nil {
+		exchange.setResponse(resp, nil, err, start)
+		t.recordExchange(exchange)
 		return resp, err
 	}
 
 	b, err := ioutil.ReadAll(resp.Body)
 	resp.Body.Close()
+	exchange.setResponse(resp, b, err, start)
+	t.recordExchange(exchange)
 
 	// We replace the request's body with a fake one if the caller wants to peek inside
 	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
 
+	if t.fixtures != nil {
+		diff, ferr := t.fixtures.check(resp.StatusCode, b)
+		if ferr != nil {
+			return resp, ferr
+		}
+		if diff != "" {
+			panic(newTestResult(resultFailed, "Response does not match fixture: "+diff, 2, t))
+		}
+	}
+
 	err = json.Unmarshal(b, v)
 	if err == nil && resp.StatusCode >= 400 {
 		err = fmt.Errorf("Bad HTTP response code: %s", resp.Status)
@@ -161,15 +204,37 @@ func (t *TestContext) GetJSON(r *http.Request, v interface{}) (*http.Response, e
 
 }
 
+// IgnoreFields ignores dot separated fields of the responses of the test when replaying fixtures, in addition to
+// the API's FixtureIgnore fields. It has no effect if fixtures are not replayed
+func (t *TestContext) IgnoreFields(fields ...string) {
+	if t.fixtures == nil {
+		return
+	}
+	t.fixtures.mtx.Lock()
+	defer t.fixtures.mtx.Unlock()
+	for _, f := range fields {
+		t.fixtures.ignoreField(f)
+	}
+}
+
 type testRunner struct {
 	category  string
 	serverURL string
 	api       *API
 	output    io.Writer
 	formatter resultFormatter
+	fixtures  fixtureConfig
+
+	coverage        *testCoverage
+	coverageFile    string
+	requireCoverage bool
+	env             *TestEnvironment
+	fuzz            bool
+	forwardSecure   bool
 }
 type resultFormatter interface {
 	format(testResult) error
+	summarize(testSummary) error
 }
 
 const (
@@ -198,6 +263,10 @@ func (f jsonResultFormatter) format(r testResult) error {
 	return nil
 }
 
+func (f jsonResultFormatter) summarize(s testSummary) error {
+	return f.encoder.Encode(map[string]testSummary{"summary": s})
+}
+
 type textResultFormatter struct {
 	w *tabwriter.Writer
 }
@@ -215,6 +284,12 @@ func (f textResultFormatter) format(result testResult) error {
 		return err
 	}
 
+	if result.Flaky {
+		if _, err := fmt.Fprintf(f.w, " FLAKY: passed after %d attempts\n", result.Attempts); err != nil {
+			return err
+		}
+	}
+
 	// Output log messages if we failed
 	if result.isFailure() {
 		if result.Message != "" {
@@ -222,6 +297,8 @@ func (f textResultFormatter) format(result testResult) error {
 				return err
 			}
 		}
+		formatExchanges(f.w, result.Requests)
+
 		if result.Log != nil && len(result.Log) > 0 {
 			fmt.Fprintln(f.w, "  Messages:")
 			for _, msg := range result.Log {
@@ -238,6 +315,32 @@ func (f textResultFormatter) format(result testResult) error {
 	return f.w.Flush()
 }
 
+func (f textResultFormatter) summarize(s testSummary) error {
+
+	if _, err := fmt.Fprintf(f.w, "PASS: %d\tFAIL: %d\tSKIP: %d\tFLAKY: %d\n", s.Passed, s.Failed, s.Skipped,
+		len(s.Flaky)); err != nil {
+		return err
+	}
+	for _, path := range s.Flaky {
+		if _, err := fmt.Fprintf(f.w, " FLAKY: %s\n", path); err != nil {
+			return err
+		}
+	}
+
+	if s.Coverage != nil {
+		if _, err := fmt.Fprintf(f.w, "COVERAGE: %d/%d routes\n", len(s.Coverage.Covered),
+			len(s.Coverage.Covered)+len(s.Coverage.Uncovered)); err != nil {
+			return err
+		}
+		for _, route := range s.Coverage.Uncovered {
+			if _, err := fmt.Fprintf(f.w, " UNCOVERED: %s\n", route); err != nil {
+				return err
+			}
+		}
+	}
+	return f.w.Flush()
+}
+
 func newTestRunner(output io.Writer, a *API, serverURL string, category string, format string) *testRunner {
 
 	var formatter resultFormatter
@@ -256,6 +359,7 @@ func newTestRunner(output io.Writer, a *API, serverURL string, category string,
 		api:       a,
 		output:    output,
 		formatter: formatter,
+		coverage:  newTestCoverage(),
 	}
 }
 
@@ -276,6 +380,38 @@ type testResult struct {
 	Message   string        `json:"message,omitempty"`
 	FailPoint string        `json:"failpoint,omitempty"`
 	Duration  time.Duration `json:"duration,omitempty"`
+
+	// the latest requests of failed tests
+	Requests []testExchange `json:"requests,omitempty"`
+
+	// the number of times the test ran, if it was retried, and whether it passed on a retry
+	Attempts int  `json:"attempts,omitempty"`
+	Flaky    bool `json:"flaky,omitempty"`
+}
+
+// testSummary sums up the results of a test run
+type testSummary struct {
+	Passed  int      `json:"passed"`
+	Failed  int      `json:"failed"`
+	Skipped int      `json:"skipped"`
+	Flaky   []string `json:"flaky,omitempty"`
+
+	Coverage *CoverageReport `json:"coverage,omitempty"`
+}
+
+// add counts a test result
+func (s *testSummary) add(r testResult) {
+	switch {
+	case r.isFailure():
+		s.Failed++
+	case r.Result == resultSkipped:
+		s.Skipped++
+	default:
+		s.Passed++
+	}
+	if r.Flaky {
+		s.Flaky = append(s.Flaky, r.Path)
+	}
 }
 
 func (r testResult) isFailure() bool {
@@ -293,6 +429,7 @@ func newTestResult(result, message string, depth int, ctx *TestContext) testResu
 	}
 
 	if ret.isFailure() {
+		ret.Requests = ctx.diagnostics()
 		if pc, _, line, ok := runtime.Caller(depth); ok {
 
 			f := runtime.FuncForPC(pc)
@@ -322,6 +459,18 @@ func (t *testRunner) runTest(tc Tester, path string) (res testResult) {
 		messages:  make([]string, 0),
 		category:  tc.Category(),
 		startTime: time.Now(),
+		coverage:  t.coverage,
+		env:       t.env,
+
+		forwardSecure: t.forwardSecure,
+	}
+
+	if t.fixtures.mode != FixturesOff {
+		session, err := newFixtureSession(t.fixtures, t.api, path)
+		if err != nil {
+			return newTestResult(resultFatal, err.Error(), 1, ctx)
+		}
+		ctx.fixtures = session
 	}
 
 	// recover from panics and analyze the input
@@ -344,6 +493,12 @@ func (t *testRunner) runTest(tc Tester, path string) (res testResult) {
 	tc.Test(ctx)
 	res = newTestResult(resultPass, "", 1, ctx)
 
+	if ctx.fixtures != nil {
+		if err := ctx.fixtures.save(); err != nil {
+			res = newTestResult(resultFailed, fmt.Sprintf("Fixtures: %s", err), 1, ctx)
+		}
+	}
+
 	return
 
 }
@@ -370,18 +525,16 @@ func getTestCategory(tc Tester) string {
 	return AllTests
 }
 
-// invokeTest runs a tester and prints the output
+// invokeTest runs a tester and returns its result. Tests run concurrently, so results are formatted by the caller
+// collecting them
 func (t *testRunner) invokeTest(path string, tc Tester) *testResult {
 
 	if t.shouldRun(tc) {
 
 		var result testResult
 		if tc == nil || t.shouldRun(tc) {
-			result = t.runTest(tc, path)
+			result = t.runWithRetries(tc, path)
 			logging.Info("Test result for %s: %#v", path, result)
-			if err := t.formatter.format(result); err != nil {
-				logging.Error("Error running formatter: %s", err)
-			}
 			return &result
 		}
 
@@ -392,16 +545,61 @@ func (t *testRunner) invokeTest(path string, tc Tester) *testResult {
 
 func (t *testRunner) Run() bool {
 
+	type pathTester struct {
+		path string
+		tc   Tester
+	}
+	testers := make([]pathTester, 0, len(t.api.Routes)+len(t.api.Scenarios))
+	if t.fuzz {
+		// fuzzing replaces the testers of the API
+		for _, route := range t.api.Routes {
+			testers = append(testers, pathTester{route.Path, routeFuzzer{route}})
+		}
+	} else {
+		for _, route := range t.api.Routes {
+			testers = append(testers, pathTester{route.Path, route.Test})
+		}
+		for _, sc := range t.api.Scenarios {
+			testers = append(testers, pathTester{sc.Name, sc})
+		}
+		if t.category == SecurityTests {
+			for _, route := range t.api.Routes {
+				testers = append(testers, pathTester{route.Path, routeProber{route}})
+			}
+		}
+	}
+
+	// run the setup hooks of the categories we are testing
+	categories := map[string]bool{}
+	for _, pt := range testers {
+		if t.shouldRun(pt.tc) {
+			categories[getTestCategory(pt.tc)] = true
+		}
+	}
+	hookCategories := testHookCategories(t.api.TestHooks, categories)
+
+	success := true
+	failedSetup := map[string]bool{}
+	for _, c := range hookCategories {
+		if !t.runHook("setup", c, t.api.TestHooks[c].Setup) {
+			failedSetup[c] = true
+			success = false
+		}
+	}
+
 	reschan := make(chan *testResult)
 	wg := sync.WaitGroup{}
-	for _, route := range t.api.Routes {
+	for _, pt := range testers {
+		if failedSetup[AllTests] || failedSetup[getTestCategory(pt.tc)] {
+			continue
+		}
 		wg.Add(1)
 
-		go func(route Route) {
+		go func(pt pathTester) {
 
-			reschan <- t.invokeTest(route.Path, route.Test)
+			reschan <- t.invokeTest(pt.path, pt.tc)
 			wg.Done()
-		}(route)
+		}(pt)
 
 	}
 
@@ -410,22 +608,81 @@ func (t *testRunner) Run() bool {
 		close(reschan)
 	}()
 
-	success := true
+	summary := testSummary{}
 	for res := range reschan {
 		if res == nil {
 			continue
 		}
 
+		if err := t.formatter.format(*res); err != nil {
+			logging.Error("Error running formatter: %s", err)
+		}
+		summary.add(*res)
 		if res.isFailure() {
 			success = false
 		}
 	}
 
+	// teardown in reverse order, even if the setup failed, since it may have partially succeeded
+	for i := len(hookCategories) - 1; i >= 0; i-- {
+		c := hookCategories[i]
+		if !t.runHook("teardown", c, t.api.TestHooks[c].Teardown) {
+			success = false
+		}
+	}
+	if !t.cleanFixtures() {
+		success = false
+	}
+
+	sort.Strings(summary.Flaky)
+
+	coverage := t.coverage.report(t.api)
+	summary.Coverage = &coverage
+	if t.requireCoverage && !coverage.Complete() {
+		success = false
+	}
+	if t.coverageFile != "" {
+		if err := writeCoverage(coverage, t.coverageFile); err != nil {
+			logging.Error("%s", err)
+			success = false
+		}
+	}
+	if err := t.formatter.summarize(summary); err != nil {
+		logging.Error("Error running formatter: %s", err)
+	}
+
 	return success
 
 }
 
 func RunCLITest(apiName, serverAddr, category, format string, out io.Writer) bool {
+	return RunCLITestOptions(apiName, serverAddr, CLITestOptions{Category: category, Format: format}, out)
+}
+
+// CLITestOptions configure a test run of RunCLITestOptions
+type CLITestOptions struct {
+	Category string
+	Format   string
+
+	// Record the responses of the tests as fixtures in FixturesDir, or replay them. See FixturesRecord
+	Fixtures    string
+	FixturesDir string
+
+	// Write the route coverage report as JSON to this file, and fail the run if a route was not exercised by the
+	// tests
+	CoverageFile    string
+	RequireCoverage bool
+
+	// Run the tests against a configured environment instead of the server address. See TestEnvironment
+	Environment string
+
+	// Instead of running the tests, send malformed and boundary values to the params of every route, and fail on
+	// 5xx responses. Fuzzing calls the real handlers, so it should not run against production
+	Fuzz bool
+}
+
+// RunCLITestOptions runs the tests of an API with options
+func RunCLITestOptions(apiName, serverAddr string, options CLITestOptions, out io.Writer) bool {
 
 	builder, ok := apiBuilders[apiName]
 	if !ok {
@@ -435,6 +692,18 @@ func RunCLITest(apiName, serverAddr, category, format string, out io.Writer) boo
 
 	a := builder()
 
-	tr := newTestRunner(out, a, serverAddr, category, format)
+	tr := newTestRunner(out, a, serverAddr, options.Category, options.Format)
+	tr.fixtures = fixtureConfig{mode: options.Fixtures, dir: options.FixturesDir}
+	tr.coverageFile = options.CoverageFile
+	tr.requireCoverage = options.RequireCoverage
+	tr.fuzz = options.Fuzz
+	if options.Environment != "" {
+		env, err := testEnvironment(options.Environment)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			return false
+		}
+		tr.setEnvironment(env)
+	}
 	return tr.Run()
 }
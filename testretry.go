@@ -0,0 +1,115 @@
+package vertex
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// TestOptions configure how the runner runs a tester
+type TestOptions struct {
+	// Fail the test if it runs longer than this. 0 means no timeout
+	Timeout time.Duration
+
+	// Run a failing test again up to this many times. Tests passing on a retry are reported as flaky, so transient
+	// failures of downstream services do not fail the run, but still show up
+	Retries int
+}
+
+// testOptioner is implemented by testers with options
+type testOptioner interface {
+	TestOptions() TestOptions
+}
+
+type optionedTester struct {
+	Tester
+	options TestOptions
+}
+
+func (t optionedTester) TestOptions() TestOptions {
+	return t.options
+}
+
+// WithOptions wraps a tester to run it with a timeout and retries:
+//
+//	Test: vertex.WithOptions(vertex.CriticalTest(testUser), vertex.TestOptions{Timeout: 5 * time.Second, Retries: 2})
+func WithOptions(tc Tester, options TestOptions) Tester {
+	return optionedTester{tc, options}
+}
+
+// TestOptions returns the options the scenario runs with
+func (s *Scenario) TestOptions() TestOptions {
+	return s.Options
+}
+
+// path => runs/failures/flaky => count
+var testMetrics = newCounterMap("vertex.tests")
+
+// testOptionsOf returns the options of a tester, if it has any
+func testOptionsOf(tc Tester) TestOptions {
+	if o, ok := tc.(testOptioner); ok {
+		return o.TestOptions()
+	}
+	return TestOptions{}
+}
+
+// runWithRetries runs a tester, retrying it while it fails up to its number of retries
+func (t *testRunner) runWithRetries(tc Tester, path string) testResult {
+
+	options := testOptionsOf(tc)
+
+	var res testResult
+	attempt := 1
+	for ; ; attempt++ {
+		res = t.runWithTimeout(tc, path, options.Timeout)
+		if !res.isFailure() || res.Result == resultMissing || attempt > options.Retries {
+			break
+		}
+		logging.Warning("Test %s failed on attempt %d, retrying: %s", path, attempt, res.Message)
+	}
+
+	if attempt > 1 {
+		res.Attempts = attempt
+		res.Flaky = !res.isFailure()
+	}
+
+	if tc != nil && t.api != nil {
+		key := fmt.Sprintf("%s %s", t.api.Name, path)
+		testMetrics.Add(key, "runs", 1)
+		if res.isFailure() {
+			testMetrics.Add(key, "failures", 1)
+		} else if res.Flaky {
+			testMetrics.Add(key, "flaky", 1)
+		}
+	}
+	return res
+}
+
+// runWithTimeout runs a tester, failing it if it does not finish in time. A timed out tester keeps running in the
+// background, since there is no way to stop it
+func (t *testRunner) runWithTimeout(tc Tester, path string, timeout time.Duration) testResult {
+
+	if timeout <= 0 {
+		return t.runTest(tc, path)
+	}
+
+	ch := make(chan testResult, 1)
+	start := time.Now()
+	go func() {
+		ch <- t.runTest(tc, path)
+	}()
+
+	select {
+	case res := <-ch:
+		return res
+	case <-time.After(timeout):
+		return testResult{
+			Result:   resultFatal,
+			Path:     path,
+			Category: getTestCategory(tc),
+			Message:  fmt.Sprintf("Test timed out after %v", timeout),
+			Duration: time.Since(start),
+		}
+	}
+}
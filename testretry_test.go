@@ -0,0 +1,71 @@
+package vertex
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetriesAndTimeouts(t *testing.T) {
+
+	attempts := 0
+	flaky := WithOptions(CriticalTest(func(ctx *TestContext) {
+		attempts++
+		if attempts < 2 {
+			ctx.Fail("downstream is down")
+		}
+	}), TestOptions{Retries: 2})
+
+	broken := WithOptions(CriticalTest(func(ctx *TestContext) {
+		ctx.Fail("always broken")
+	}), TestOptions{Retries: 1})
+
+	slow := WithOptions(CriticalTest(func(ctx *TestContext) {
+		time.Sleep(time.Second)
+	}), TestOptions{Timeout: 10 * time.Millisecond})
+
+	a := &API{Name: "retries", Root: "/retries"}
+	runner := newTestRunner(bytes.NewBuffer(nil), a, "http://localhost", AllTests, TestFormatText)
+
+	res := runner.runWithRetries(flaky, "/flaky")
+	assert.Equal(t, resultPass, res.Result)
+	assert.True(t, res.Flaky)
+	assert.Equal(t, 2, res.Attempts)
+	assert.Equal(t, 2, attempts)
+
+	res = runner.runWithRetries(broken, "/broken")
+	assert.Equal(t, resultFailed, res.Result)
+	assert.False(t, res.Flaky)
+	assert.Equal(t, 2, res.Attempts)
+	assert.Equal(t, int64(1), testMetrics.Value("retries /broken", "failures"))
+
+	st := time.Now()
+	res = runner.runWithRetries(slow, "/slow")
+	assert.Equal(t, resultFatal, res.Result)
+	assert.Equal(t, "Test timed out after 10ms", res.Message)
+	assert.Equal(t, CriticalTests, res.Category)
+	assert.True(t, time.Since(st) < time.Second)
+
+	// flaky tests pass the run but are listed in the summary
+	attempts = 0
+	a.Routes = Routes{{Path: "/flaky", Methods: GET, Handler: VoidHandler{}, Test: flaky}}
+	buf := bytes.NewBuffer(nil)
+	assert.True(t, newTestRunner(buf, a, "http://localhost", AllTests, TestFormatText).Run())
+	assert.Contains(t, buf.String(), "FLAKY: passed after 2 attempts")
+	assert.Contains(t, buf.String(), "FLAKY: 1")
+	assert.Contains(t, buf.String(), " FLAKY: /flaky")
+	assert.Equal(t, int64(2), testMetrics.Value("retries /flaky", "flaky"))
+
+	attempts = 0
+	buf.Reset()
+	newTestRunner(buf, a, "http://localhost", AllTests, TestFormatJson).Run()
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	var summary map[string]testSummary
+	assert.NoError(t, json.Unmarshal([]byte(lines[len(lines)-1]), &summary))
+	assert.Equal(t, 1, summary["summary"].Passed)
+	assert.Equal(t, []string{"/flaky"}, summary["summary"].Flaky)
+}
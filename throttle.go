@@ -0,0 +1,336 @@
+package vertex
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// LockoutsPath is the admin endpoint listing the current authentication lockouts. DELETE requests with throttle and
+// key params clear a lockout
+const LockoutsPath = "/debug/vertex/lockouts"
+
+// Default LoginThrottle settings
+const (
+	DefaultMaxFailures   = 5
+	DefaultFailureWindow = 15 * time.Minute
+	DefaultBaseLockout   = time.Minute
+	DefaultMaxLockout    = time.Hour
+)
+
+// how long lockout levels are remembered, so repeat offenders are locked out for longer
+const lockoutMemory = 24 * time.Hour
+
+// Lockout is a client locked out of authenticating
+type Lockout struct {
+	Throttle string    `json:"throttle"`
+	Key      string    `json:"key"`
+	Level    int64     `json:"level"`
+	Until    time.Time `json:"until"`
+}
+
+// LoginThrottle protects authentication from brute force attacks. It counts failed attempts per client ip and per
+// principal in a shared Store, and locks clients out after too many failures. Each successive lockout of the same
+// client is twice as long as the previous one.
+//
+// Login handlers use it explicitly:
+//
+//	if err := throttle.Check(r, username); err != nil {
+//		return nil, err
+//	}
+//	if !checkPassword(username, password) {
+//		throttle.Failed(r, username)
+//		return nil, vertex.InvalidCredentialsError("Wrong password")
+//	}
+//	throttle.Succeeded(r, username)
+//
+// And security schemes can be wrapped with Protect, throttling by ip
+type LoginThrottle struct {
+	// Failed attempts within the window before a client is locked out
+	MaxFailures int64
+	Window      time.Duration
+
+	// The first lockout lasts BaseLockout, and successive lockouts double up to MaxLockout
+	BaseLockout time.Duration
+	MaxLockout  time.Duration
+
+	name  string
+	store Store
+}
+
+var loginThrottles = struct {
+	sync.RWMutex
+	throttles map[string]*LoginThrottle
+}{
+	throttles: map[string]*LoginThrottle{},
+}
+
+// NewLoginThrottle creates a login throttle with the default settings, keeping its state in a store. The throttle
+// is registered by name, so its lockouts are listed in the lockouts admin endpoint
+func NewLoginThrottle(name string, store Store) *LoginThrottle {
+
+	t := &LoginThrottle{
+		MaxFailures: DefaultMaxFailures,
+		Window:      DefaultFailureWindow,
+		BaseLockout: DefaultBaseLockout,
+		MaxLockout:  DefaultMaxLockout,
+		name:        name,
+		store:       store,
+	}
+
+	loginThrottles.Lock()
+	loginThrottles.throttles[name] = t
+	loginThrottles.Unlock()
+
+	// the failures and lockouts of principals are data about them
+	AddSubjectStore("throttle."+name, t)
+
+	return t
+}
+
+func (t *LoginThrottle) storeKey(kind, key string) string {
+	return "vertex.throttle." + t.name + "." + kind + "." + key
+}
+
+// clients returns the keys a request's attempts are counted by
+func (t *LoginThrottle) clients(r *Request, principal string) []string {
+	ret := []string{"ip:" + r.RemoteIP}
+	if principal != "" {
+		ret = append(ret, "principal:"+principal)
+	}
+	return ret
+}
+
+// Check returns a TooManyRequestsError if the request's ip or the principal are locked out
+func (t *LoginThrottle) Check(r *Request, principal string) error {
+
+	for _, client := range t.clients(r, principal) {
+
+		data, found, err := t.store.Get(t.storeKey("lock", client))
+		if err != nil {
+			// failing open - an unavailable store should not lock everybody out
+			logging.Error("Could not check lockout of %s: %s", client, err)
+			continue
+		}
+		if !found {
+			continue
+		}
+
+		var l Lockout
+		if err := json.Unmarshal(data, &l); err == nil && time.Now().Before(l.Until) {
+			return TooManyRequestsError(time.Until(l.Until), "Too many failed attempts, try again later")
+		}
+	}
+	return nil
+}
+
+// Failed records a failed authentication attempt, locking out the request's ip or the principal if they failed
+// too many times
+func (t *LoginThrottle) Failed(r *Request, principal string) {
+
+	for _, client := range t.clients(r, principal) {
+
+		n, err := t.store.Incr(t.storeKey("fail", client), t.Window)
+		if err != nil {
+			logging.Error("Could not count failed attempt of %s: %s", client, err)
+			continue
+		}
+
+		if n >= t.MaxFailures {
+			t.lock(r, client)
+		}
+	}
+}
+
+// lock locks a client out, for twice as long as its previous lockout
+func (t *LoginThrottle) lock(r *Request, client string) {
+
+	level, err := t.store.Incr(t.storeKey("level", client), lockoutMemory)
+	if err != nil {
+		logging.Error("Could not lock out %s: %s", client, err)
+		return
+	}
+
+	duration := t.MaxLockout
+	if level < 32 {
+		if d := t.BaseLockout << uint(level-1); d > 0 && d < t.MaxLockout {
+			duration = d
+		}
+	}
+
+	l := Lockout{Throttle: t.name, Key: client, Level: level, Until: time.Now().Add(duration)}
+	data, _ := json.Marshal(l)
+	if err := t.store.Set(t.storeKey("lock", client), data, duration); err != nil {
+		logging.Error("Could not lock out %s: %s", client, err)
+		return
+	}
+	t.store.Delete(t.storeKey("fail", client))
+
+	e := NewSecurityEvent(r, EventLockout, "Too many failed authentication attempts")
+	e.Details = map[string]interface{}{"throttle": t.name, "client": client, "level": level, "duration": duration.String()}
+	EmitSecurityEvent(e)
+}
+
+// Succeeded clears the failed attempts of the request's ip and the principal after a successful authentication.
+// Lockout levels are kept, so clients alternating failures and successes still escalate
+func (t *LoginThrottle) Succeeded(r *Request, principal string) {
+	for _, client := range t.clients(r, principal) {
+		t.store.Delete(t.storeKey("fail", client))
+	}
+}
+
+// Lockouts returns the current lockouts of the throttle
+func (t *LoginThrottle) Lockouts() ([]Lockout, error) {
+
+	keys, err := t.store.Keys(t.storeKey("lock", ""))
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]Lockout, 0, len(keys))
+	for _, k := range keys {
+		data, found, err := t.store.Get(k)
+		if err != nil {
+			return nil, err
+		}
+		var l Lockout
+		if found && json.Unmarshal(data, &l) == nil {
+			ret = append(ret, l)
+		}
+	}
+	return ret, nil
+}
+
+// Clear clears the lockout, failures and lockout level of a client, e.g. "ip:1.2.3.4" or "principal:alice"
+func (t *LoginThrottle) Clear(client string) error {
+	for _, kind := range []string{"lock", "fail", "level"} {
+		if err := t.store.Delete(t.storeKey(kind, client)); err != nil {
+			return err
+		}
+	}
+	logging.Info("Cleared lockout of %s in %s", client, t.name)
+	return nil
+}
+
+// ExportSubject returns the lockout of a principal, if it is locked out
+func (t *LoginThrottle) ExportSubject(principalID string) (interface{}, error) {
+
+	ret := []Lockout{}
+	data, found, err := t.store.Get(t.storeKey("lock", "principal:"+principalID))
+	if err != nil {
+		return nil, err
+	}
+	var l Lockout
+	if found && json.Unmarshal(data, &l) == nil {
+		ret = append(ret, l)
+	}
+	return ret, nil
+}
+
+// PurgeSubject clears the lockout, failures and lockout level of a principal, returning the number of deleted keys
+func (t *LoginThrottle) PurgeSubject(principalID string) (int, error) {
+
+	n := 0
+	for _, kind := range []string{"lock", "fail", "level"} {
+		key := t.storeKey(kind, "principal:"+principalID)
+		_, found, err := t.store.Get(key)
+		if err != nil {
+			return n, err
+		}
+		if !found {
+			continue
+		}
+		if err := t.store.Delete(key); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// throttledScheme wraps a security scheme with a login throttle
+type throttledScheme struct {
+	SecurityScheme
+	throttle *LoginThrottle
+}
+
+func (s throttledScheme) Validate(r *Request) error {
+
+	if err := s.throttle.Check(r, ""); err != nil {
+		return err
+	}
+
+	err := s.SecurityScheme.Validate(r)
+	if err == nil {
+		s.throttle.Succeeded(r, "")
+		return nil
+	}
+
+	// requests without credentials are not attempts
+	if e, ok := err.(*internalError); !ok || e.Code != ErrMissingCredentials {
+		s.throttle.Failed(r, "")
+	}
+	return err
+}
+
+// Challenge passes the challenge of the wrapped scheme
+func (s throttledScheme) Challenge(r *Request) string {
+	if c, ok := s.SecurityScheme.(Challenger); ok {
+		return c.Challenge(r)
+	}
+	return ""
+}
+
+// Protect wraps a security scheme, locking out ips that fail its validation too many times
+func (t *LoginThrottle) Protect(scheme SecurityScheme) SecurityScheme {
+	return throttledScheme{SecurityScheme: scheme, throttle: t}
+}
+
+// lockoutsHandler lists the lockouts of all throttles, or clears a lockout on DELETE
+func lockoutsHandler(w http.ResponseWriter, r *http.Request) {
+
+	loginThrottles.RLock()
+	defer loginThrottles.RUnlock()
+
+	if r.Method == "DELETE" {
+		t, found := loginThrottles.throttles[r.FormValue("throttle")]
+		client := r.FormValue("key")
+		if !found || client == "" {
+			http.Error(w, "Unknown throttle or missing key", http.StatusBadRequest)
+			return
+		}
+		if err := t.Clear(client); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	ret := make([]Lockout, 0)
+	for _, t := range loginThrottles.throttles {
+		lockouts, err := t.Lockouts()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		ret = append(ret, lockouts...)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Throttle != ret[j].Throttle {
+			return ret[i].Throttle < ret[j].Throttle
+		}
+		return ret[i].Key < ret[j].Key
+	})
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(ret); err != nil {
+		logging.Error("Could not dump lockouts: %s", err)
+	}
+}
@@ -0,0 +1,115 @@
+package vertex
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginThrottle(t *testing.T) {
+
+	th := NewLoginThrottle("test", NewMemoryStore())
+	th.MaxFailures = 3
+
+	req := func(ip string) *Request {
+		hr, _ := http.NewRequest("POST", "/login", nil)
+		hr.RemoteAddr = ip + ":1234"
+		return NewRequest(hr)
+	}
+
+	r := req("10.0.0.1")
+	for i := 0; i < 2; i++ {
+		th.Failed(r, "alice")
+	}
+	assert.NoError(t, th.Check(r, "alice"))
+
+	// a success clears the failures
+	th.Succeeded(r, "alice")
+	th.Failed(r, "alice")
+	th.Failed(r, "alice")
+	assert.NoError(t, th.Check(r, "alice"))
+
+	// too many failures lock out both the ip and the principal
+	th.Failed(r, "alice")
+	err := th.Check(r, "alice")
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, errorStatus(err))
+	assert.True(t, RetryAfter(err) > 50*time.Second)
+	assert.Error(t, th.Check(req("10.0.0.2"), "alice"))
+	assert.Error(t, th.Check(r, "bob"))
+	assert.NoError(t, th.Check(req("10.0.0.2"), "bob"))
+
+	lockouts, err := th.Lockouts()
+	assert.NoError(t, err)
+	assert.Len(t, lockouts, 2)
+
+	// repeated lockouts are exponentially longer
+	assert.NoError(t, th.Clear("principal:alice"))
+	assert.NoError(t, th.Check(req("10.0.0.2"), "alice"))
+	for i := 0; i < 3; i++ {
+		th.Failed(r, "")
+	}
+	lockouts, _ = th.Lockouts()
+	if assert.Len(t, lockouts, 1) {
+		assert.Equal(t, "ip:10.0.0.1", lockouts[0].Key)
+		assert.Equal(t, int64(2), lockouts[0].Level)
+		assert.True(t, time.Until(lockouts[0].Until) > 110*time.Second)
+	}
+}
+
+func TestThrottledScheme(t *testing.T) {
+
+	th := NewLoginThrottle("scheme", NewMemoryStore())
+	th.MaxFailures = 2
+
+	scheme := th.Protect(SecuritySchemeFunc(func(r *Request) error {
+		switch r.FormValue("key") {
+		case "":
+			return MissingCredentialsError("no key")
+		case "good":
+			return nil
+		}
+		return InvalidCredentialsError("bad key")
+	}))
+
+	validate := func(key string) error {
+		hr, _ := http.NewRequest("GET", "/foo?key="+key, nil)
+		hr.RemoteAddr = "10.1.1.1:1234"
+		return scheme.Validate(NewRequest(hr))
+	}
+
+	// missing credentials are not counted
+	for i := 0; i < 3; i++ {
+		assert.Error(t, validate(""))
+	}
+	assert.NoError(t, validate("good"))
+
+	assert.Error(t, validate("bad"))
+	assert.Error(t, validate("bad"))
+	err := validate("good")
+	assert.Equal(t, http.StatusTooManyRequests, errorStatus(err))
+
+	// the admin endpoint lists and clears lockouts
+	hr, _ := http.NewRequest("GET", LockoutsPath, nil)
+	w := httptest.NewRecorder()
+	lockoutsHandler(w, hr)
+	var lockouts []Lockout
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &lockouts))
+	found := false
+	for _, l := range lockouts {
+		if l.Throttle == "scheme" && l.Key == "ip:10.1.1.1" {
+			found = true
+		}
+	}
+	assert.True(t, found)
+
+	hr, _ = http.NewRequest("DELETE", LockoutsPath+"?throttle=scheme&key=ip:10.1.1.1", nil)
+	w = httptest.NewRecorder()
+	lockoutsHandler(w, hr)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.NoError(t, validate("good"))
+}
@@ -0,0 +1,229 @@
+package vertex
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// route => timeouts => value
+var timeoutMetrics = newCounterMap("vertex.timeouts")
+
+// TimeoutStats returns the number of requests of a route that did not finish within the route's timeout
+func TimeoutStats(route string) int64 {
+	return timeoutMetrics.Value(route, "timeouts")
+}
+
+// timedOutChains is the number of chains still running after their request timed out
+var timedOutChains int64
+
+// handleWithTimeout runs a route's chain with a deadline. If the chain does not finish in time the request's context is
+// canceled and a TimeoutError is returned right away, rendered like any other error. The chain keeps running in the
+// background until it returns, but its writes to the response are discarded.
+//
+// The chain runs on a fork of the request, so a chain still running after the timeout does not race with rendering
+// the error. done, if not nil, is called once the chain returns, e.g. to hold its scheduler slot until then
+func handleWithTimeout(timeout time.Duration, chain *step, w http.ResponseWriter, r *Request,
+	done func()) (interface{}, error) {
+
+	cancel := r.SetDeadline(time.Now().Add(timeout))
+	defer cancel()
+
+	type result struct {
+		ret     interface{}
+		err     error
+		aborted bool
+	}
+
+	fork, slot := r.fork()
+	tw := &timeoutWriter{ResponseWriter: w, header: w.Header().Clone()}
+	finished := make(chan result, 1)
+	go func() {
+		if done != nil {
+			defer done()
+		}
+
+		// aborting panics would crash the process outside of the http server's goroutine, they are passed to it
+		defer func() {
+			if e := recover(); e != nil {
+				finished <- result{aborted: true}
+			}
+		}()
+		ret, err := handleRecovering(chain, tw, fork)
+		finished <- result{ret, err, false}
+	}()
+
+	// the request continues with the state the chain left in its fork, if it finished in time
+	join := func(res result) (interface{}, error) {
+		if res.aborted {
+			panic(http.ErrAbortHandler)
+		}
+		tw.finish()
+		fork.deferred = append(r.deferred, fork.deferred...)
+		*r = *fork
+		slot.req = r
+		return res.ret, res.err
+	}
+
+	select {
+	case res := <-finished:
+		return join(res)
+	case <-r.Context().Done():
+	}
+
+	// the chain may have finished right at the deadline
+	select {
+	case res := <-finished:
+		return join(res)
+	default:
+	}
+
+	// work the chain defers after the timeout runs once it returns. The chain counts as running until its work is
+	// handed to the pool, so the drain status never loses track of it
+	atomic.AddInt64(&timedOutChains, 1)
+	go func() {
+		defer atomic.AddInt64(&timedOutChains, -1)
+		<-finished
+		fork.runDeferred()
+	}()
+
+	err := contextError(r.Context().Err())
+	if e, ok := err.(*internalError); ok && e.Code == ErrTimeout {
+		timeoutMetrics.Add(r.route, "timeouts", 1)
+		err = TimeoutError("Request timed out after %v", timeout)
+	}
+
+	// a response the handler started cannot be replaced, so it is cut short instead
+	if tw.timeout() {
+		logging.Error("Request %s of %s failed after its response was written: %s", r.RequestId, r.route, err)
+		return nil, Hijacked
+	}
+	return nil, err
+}
+
+// fork copies a request for a chain that may outlive it. The fork has its own attributes, deferred work and http
+// request, and is the request of its context until the slot returned is set otherwise
+func (r *Request) fork() (*Request, *requestSlot) {
+
+	fork := *r
+	fork.attributes = make(map[string]interface{}, len(r.attributes))
+	for k, v := range r.attributes {
+		fork.attributes[k] = v
+	}
+	fork.deferred = nil
+
+	ctx, slot := withRequestSlot(r.Context())
+	slot.req = &fork
+	fork.Request = r.Request.Clone(ctx)
+	return &fork, slot
+}
+
+// timeoutWriter discards the writes of a handler after its request timed out, so they do not race with rendering the
+// timeout error. Handlers set headers on a copy of the response headers, passed through when they write the response
+// or finish in time
+type timeoutWriter struct {
+	http.ResponseWriter
+	header http.Header
+
+	mtx         sync.Mutex
+	timedOut    bool
+	wroteHeader bool
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+// finish passes the headers of a handler that finished in time to the response, for the renderer
+func (w *timeoutWriter) finish() {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if !w.wroteHeader {
+		w.copyHeader()
+	}
+}
+
+// copyHeader replaces the headers of the response with the handler's
+func (w *timeoutWriter) copyHeader() {
+	dst := w.ResponseWriter.Header()
+	for k := range dst {
+		if _, found := w.header[k]; !found {
+			dst.Del(k)
+		}
+	}
+	for k, v := range w.header {
+		dst[k] = v
+	}
+}
+
+// timeout stops passing writes through, returning whether the response was already started
+func (w *timeoutWriter) timeout() bool {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.timedOut = true
+	return w.wroteHeader
+}
+
+func (w *timeoutWriter) WriteHeader(status int) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.timedOut || w.wroteHeader {
+		return
+	}
+	w.writeHeader(status)
+}
+
+func (w *timeoutWriter) writeHeader(status int) {
+	w.wroteHeader = true
+	w.copyHeader()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !w.wroteHeader {
+		w.writeHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush lets streaming handlers flush until their request times out
+func (w *timeoutWriter) Flush() {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.timedOut {
+		return
+	}
+	if !w.wroteHeader {
+		w.writeHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets websocket handlers take over the connection, as long as their request did not time out
+func (w *timeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.timedOut {
+		return nil, nil, context.DeadlineExceeded
+	}
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		w.wroteHeader = true
+		w.copyHeader()
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("The response writer does not support hijacking")
+}
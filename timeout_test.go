@@ -0,0 +1,136 @@
+package vertex
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteTimeout(t *testing.T) {
+
+	finished := make(chan error, 1)
+	a := &API{
+		Name:          "timeouts",
+		Version:       "1.0",
+		Root:          "/timeouts",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{Path: "/slow", Description: "Slow", Methods: GET, Timeout: 20 * time.Millisecond, Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+				<-r.Context().Done()
+
+				// writes after the timeout are discarded
+				time.Sleep(10 * time.Millisecond)
+				w.Header().Set("X-Late", "1")
+				_, err := w.Write([]byte("late"))
+				finished <- err
+				return nil, r.Context().Err()
+			})},
+			{Path: "/fast", Description: "Fast", Methods: GET, Timeout: time.Second, Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+				deadline, ok := r.Context().Deadline()
+				assert.True(t, ok)
+				assert.Equal(t, r.Deadline, deadline)
+				w.Header().Set("X-Fast", "1")
+				return "ok", nil
+			})},
+		},
+	}
+	srv := NewServer(":9943")
+	srv.AddAPI(a)
+
+	call := func(path string) *httptest.ResponseRecorder {
+		hr, _ := http.NewRequest("GET", "http://example.com"+a.FullPath(path), nil)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, hr)
+		return w
+	}
+
+	st := time.Now()
+	w := call("/slow")
+	assert.True(t, time.Since(st) < 500*time.Millisecond)
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.NotEmpty(t, w.Header().Get(HeaderXRequestId))
+	assert.Equal(t, http.ErrHandlerTimeout, <-finished)
+	assert.Empty(t, w.Header().Get("X-Late"))
+	assert.NotContains(t, w.Body.String(), "late")
+	assert.Equal(t, int64(1), TimeoutStats(a.FullPath("/slow")))
+
+	// handlers finishing in time are rendered with their headers
+	w = call("/fast")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1", w.Header().Get("X-Fast"))
+	assert.Contains(t, w.Body.String(), "ok")
+	assert.Equal(t, int64(0), TimeoutStats(a.FullPath("/fast")))
+
+	// the timeout is documented
+	assert.Contains(t, a.ToSwagger("example.com").Paths["/slow"]["get"].Responses, "504")
+	assert.Contains(t, a.ToSwagger("example.com").Paths["/fast"]["get"].Responses, "504")
+}
+
+func TestTimeoutStartedResponse(t *testing.T) {
+
+	// a response the handler started is cut short rather than replaced
+	chain := buildChain(MiddlewareFunc(func(w http.ResponseWriter, r *Request, next HandlerFunc) (interface{}, error) {
+		w.Write([]byte("partial"))
+		<-r.Context().Done()
+		time.Sleep(10 * time.Millisecond)
+		return nil, nil
+	}))
+
+	hr, _ := http.NewRequest("GET", "/foo", nil)
+	w := httptest.NewRecorder()
+	_, err := handleWithTimeout(10*time.Millisecond, chain, w, NewRequest(hr), nil)
+	assert.True(t, IsHijacked(err))
+	assert.Equal(t, "partial", w.Body.String())
+}
+
+func TestTimeoutHoldsSlot(t *testing.T) {
+
+	returned, deferred := make(chan struct{}), make(chan struct{})
+	a := &API{
+		Name:           "timeoutslot",
+		Version:        "1.0",
+		Root:           "/timeoutslot",
+		Renderer:       JSONRenderer{},
+		AllowInsecure:  true,
+		MaxConcurrency: 1,
+		QueueTimeout:   10 * time.Millisecond,
+		Routes: Routes{
+			{Path: "/late", Description: "Late", Methods: GET, Timeout: 10 * time.Millisecond, Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+				<-r.Context().Done()
+				<-returned
+
+				// the request is the handler's own after the timeout
+				r.SetAttribute("late", true)
+				r.Defer(func(ctx context.Context) {
+					close(deferred)
+				})
+				return nil, nil
+			})},
+			{Path: "/ok", Description: "OK", Methods: GET, Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+				return "ok", nil
+			})},
+		},
+	}
+	srv := NewServer(":9932")
+	srv.AddAPI(a)
+
+	call := func(path string) int {
+		hr, _ := http.NewRequest("GET", a.FullPath(path), nil)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, hr)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusGatewayTimeout, call("/late"))
+
+	// the timed out handler holds the only slot until it returns
+	assert.Equal(t, http.StatusServiceUnavailable, call("/ok"))
+	close(returned)
+	<-deferred
+	assert.Equal(t, http.StatusOK, call("/ok"))
+}
@@ -0,0 +1,168 @@
+package vertex
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+	"github.com/hydrogen18/stoppableListener"
+)
+
+// Environment variables used to hand the listening socket over to an upgraded child process
+const (
+	envInheritFD = "VERTEX_INHERIT_FD"
+	envReadyFD   = "VERTEX_READY_FD"
+)
+
+// inheritedListener returns the listener passed down to us by a parent process during an upgrade.
+// If we were not started by an upgrade, it returns nil
+func inheritedListener() (net.Listener, error) {
+
+	fdstr := os.Getenv(envInheritFD)
+	if fdstr == "" {
+		return nil, nil
+	}
+	// do not pass the fd on to our own children
+	os.Unsetenv(envInheritFD)
+
+	fd, err := strconv.Atoi(fdstr)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid inherited listener fd '%s': %s", fdstr, err)
+	}
+
+	f := os.NewFile(uintptr(fd), "vertex-listener")
+	defer f.Close()
+
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("Could not use inherited listener: %s", err)
+	}
+
+	logging.Info("Inherited listener on %s from parent process", l.Addr())
+	return l, nil
+}
+
+// notifyUpgradeReady tells the parent process that started us during an upgrade that we are now accepting connections
+func notifyUpgradeReady() {
+
+	fdstr := os.Getenv(envReadyFD)
+	if fdstr == "" {
+		return
+	}
+	os.Unsetenv(envReadyFD)
+
+	fd, err := strconv.Atoi(fdstr)
+	if err != nil {
+		logging.Error("Invalid upgrade readiness fd '%s': %s", fdstr, err)
+		return
+	}
+
+	f := os.NewFile(uintptr(fd), "vertex-ready")
+	defer f.Close()
+
+	if _, err := f.Write([]byte("ready")); err != nil {
+		logging.Error("Could not notify parent process of readiness: %s", err)
+	}
+}
+
+// Upgrade performs a zero-downtime binary upgrade. It re-executes the current binary, handing it the server's
+// listening socket. Once the new process signals it is serving, this server stops accepting connections and waits
+// for in-flight requests to finish, so no connection is dropped during the deploy.
+//
+// If the new process fails to start or does not become ready in time, it is killed and this server keeps running
+func (s *Server) Upgrade() error {
+
+	sl, ok := s.listener.(*stoppableListener.StoppableListener)
+	if !ok || s.srv == nil {
+		return errors.New("Server is not running")
+	}
+
+	lf, err := sl.TCPListener.File()
+	if err != nil {
+		return fmt.Errorf("Could not get listener file: %s", err)
+	}
+	defer lf.Close()
+
+	rd, wr, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("Could not create readiness pipe: %s", err)
+	}
+	defer rd.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		wr.Close()
+		return fmt.Errorf("Could not find executable: %s", err)
+	}
+
+	// ExtraFiles start at fd 3 in the child
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{lf, wr}
+	cmd.Env = append(os.Environ(), envInheritFD+"=3", envReadyFD+"=4")
+
+	err = cmd.Start()
+	wr.Close()
+	if err != nil {
+		return fmt.Errorf("Could not start upgraded process: %s", err)
+	}
+
+	logging.Info("Started upgraded process %d, waiting for it to become ready", cmd.Process.Pid)
+
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 5)
+		_, err := rd.Read(buf)
+		ready <- err
+	}()
+
+	select {
+	case err = <-ready:
+	case <-time.After(time.Duration(Config.Server.UpgradeTimeout) * time.Second):
+		err = errors.New("timed out")
+	}
+
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("Upgraded process did not become ready: %s", err)
+	}
+
+	// reap the new process if it exits while we are still draining
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			logging.Error("Upgraded process %d exited: %s", cmd.Process.Pid, err)
+		}
+	}()
+
+	logging.Info("Upgraded process %d is ready, shutting down", cmd.Process.Pid)
+	return s.shutdown(time.Duration(Config.Server.ShutdownGrace)*time.Second, true)
+}
+
+// UpgradeOnSignal runs Upgrade whenever the process receives one of the given signals (SIGHUP if none are given)
+func (s *Server) UpgradeOnSignal(sigs ...os.Signal) {
+
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGHUP}
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+
+	go func() {
+		for sig := range ch {
+			logging.Info("Got signal %s, upgrading", sig)
+			if err := s.Upgrade(); err != nil {
+				logging.Error("Error upgrading server: %s", err)
+			}
+		}
+	}()
+}
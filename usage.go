@@ -0,0 +1,229 @@
+package vertex
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.google.com/p/go-uuid/uuid"
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// UsagePath is the admin endpoint reporting how much every route of the server is used, least used first, to find
+// dead endpoints:
+//
+//	GET /debug/vertex/usage?limit=20
+const UsagePath = "/debug/vertex/usage"
+
+// Default UsageTracker settings
+const (
+	DefaultUsageFlushInterval = time.Minute
+	DefaultUsageRetention     = 90 * 24 * time.Hour
+)
+
+const usageKeyPrefix = "vertex.usage:"
+
+// RouteUsage is the number of calls to a route and when it was last called
+type RouteUsage struct {
+	API        string    `json:"api,omitempty"`
+	Method     string    `json:"method"`
+	Route      string    `json:"route"`
+	Calls      int64     `json:"calls"`
+	LastCalled time.Time `json:"last_called"`
+}
+
+// usage counts the calls of the routes of all APIs by "METHOD route", since the process started
+var usage = struct {
+	sync.Mutex
+	routes map[string]*RouteUsage
+}{
+	routes: map[string]*RouteUsage{},
+}
+
+// recordUsage counts a call to a route
+func recordUsage(method, route string) {
+	usage.Lock()
+	defer usage.Unlock()
+
+	key := method + " " + route
+	u, found := usage.routes[key]
+	if !found {
+		u = &RouteUsage{Method: method, Route: route}
+		usage.routes[key] = u
+	}
+	u.Calls++
+	u.LastCalled = time.Now()
+}
+
+// processUsage returns a copy of the usage counted by this process
+func processUsage() map[string]RouteUsage {
+	usage.Lock()
+	defer usage.Unlock()
+
+	ret := make(map[string]RouteUsage, len(usage.routes))
+	for k, u := range usage.routes {
+		ret[k] = *u
+	}
+	return ret
+}
+
+// UsageTracker persists route usage to a Store shared by all the instances of a service, so usage survives
+// restarts and is aggregated across instances. Every instance periodically writes its own counts to its own keys,
+// which expire after the retention period once the instance is gone - so reports cover roughly the retention period
+type UsageTracker struct {
+	FlushInterval time.Duration
+	Retention     time.Duration
+
+	store    Store
+	instance string
+}
+
+// NewUsageTracker creates a usage tracker persisting to a store
+func NewUsageTracker(store Store) *UsageTracker {
+	return &UsageTracker{
+		FlushInterval: DefaultUsageFlushInterval,
+		Retention:     DefaultUsageRetention,
+		store:         store,
+		instance:      uuid.New(),
+	}
+}
+
+// Flush writes the usage counted by this process to the store
+func (t *UsageTracker) Flush() error {
+
+	for key, u := range processUsage() {
+		value := fmt.Sprintf("%d %d", u.Calls, u.LastCalled.UnixNano())
+		if err := t.store.Set(usageKeyPrefix+key+"|"+t.instance, []byte(value), t.Retention); err != nil {
+			return logging.Errorf("Could not persist route usage: %s", err)
+		}
+	}
+	return nil
+}
+
+// Run flushes the usage periodically until the context is canceled, and once more before returning
+func (t *UsageTracker) Run(ctx context.Context) error {
+
+	ticker := time.NewTicker(t.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			t.Flush()
+		case <-ctx.Done():
+			return t.Flush()
+		}
+	}
+}
+
+// load aggregates the usage persisted by all the instances by "METHOD route"
+func (t *UsageTracker) load() (map[string]RouteUsage, error) {
+
+	keys, err := t.store.Keys(usageKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := map[string]RouteUsage{}
+	for _, k := range keys {
+		value, found, err := t.store.Get(k)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+
+		key := strings.TrimPrefix(k, usageKeyPrefix)
+		if i := strings.LastIndex(key, "|"); i >= 0 {
+			key = key[:i]
+		}
+		parts := strings.SplitN(key, " ", 2)
+		fields := strings.Fields(string(value))
+		if len(parts) != 2 || len(fields) != 2 {
+			continue
+		}
+		calls, _ := strconv.ParseInt(fields[0], 10, 64)
+		nanos, _ := strconv.ParseInt(fields[1], 10, 64)
+
+		u := ret[key]
+		u.Method, u.Route = parts[0], parts[1]
+		u.Calls += calls
+		if last := time.Unix(0, nanos); last.After(u.LastCalled) {
+			u.LastCalled = last
+		}
+		ret[key] = u
+	}
+	return ret, nil
+}
+
+// TrackUsage persists the route usage of the server with a tracker, flushing it as a worker of the server.
+// Without a tracker the usage report only covers the current process
+func (s *Server) TrackUsage(t *UsageTracker) {
+	s.usage = t
+	s.AddWorker("usage", t)
+}
+
+// UsageReport returns the usage of all the routes of the server's APIs, including routes that were never called,
+// sorted from the least used route
+func (s *Server) UsageReport() ([]RouteUsage, error) {
+
+	counted := processUsage()
+	if s.usage != nil {
+		// the persisted usage includes this process once it is flushed
+		s.usage.Flush()
+		var err error
+		if counted, err = s.usage.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	ret := make([]RouteUsage, 0, len(counted))
+	for _, a := range s.apis {
+		for _, route := range a.Routes {
+			pth := a.FullPath(route.Path)
+			for _, m := range methodFlags {
+				if route.Methods&m.flag != m.flag {
+					continue
+				}
+				u := counted[m.name+" "+pth]
+				u.API, u.Method, u.Route = a.Name, m.name, pth
+				ret = append(ret, u)
+			}
+		}
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool {
+		if ret[i].Calls != ret[j].Calls {
+			return ret[i].Calls < ret[j].Calls
+		}
+		return ret[i].LastCalled.Before(ret[j].LastCalled)
+	})
+	return ret, nil
+}
+
+// usageHandler reports the route usage, optionally limited to the least used routes
+func (s *Server) usageHandler(w http.ResponseWriter, r *http.Request) {
+
+	report, err := s.UsageReport()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if limit, err := strconv.Atoi(r.FormValue("limit")); err == nil && limit >= 0 && limit < len(report) {
+		report = report[:limit]
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		logging.Error("Could not write usage report: %s", err)
+	}
+}
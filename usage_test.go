@@ -0,0 +1,73 @@
+package vertex
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsageReport(t *testing.T) {
+
+	handler := HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+		return "ok", nil
+	})
+	a := &API{
+		Name:          "usage",
+		Version:       "1.0",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{Path: "/busy", Description: "Busy", Methods: GET, Handler: handler},
+			{Path: "/quiet", Description: "Quiet", Methods: GET, Handler: handler},
+			{Path: "/dead", Description: "Dead", Methods: POST, Handler: handler},
+		},
+	}
+	srv := NewServer(":9966")
+	srv.AddAPI(a)
+
+	call := func(path string, n int) {
+		for i := 0; i < n; i++ {
+			hr, _ := http.NewRequest("GET", a.FullPath(path), nil)
+			srv.Handler().ServeHTTP(httptest.NewRecorder(), hr)
+		}
+	}
+	call("/busy", 3)
+	call("/quiet", 1)
+
+	routes := func(report []RouteUsage) (ret []string) {
+		for _, u := range report {
+			ret = append(ret, u.Method+" "+u.Route)
+		}
+		return
+	}
+
+	report, err := srv.UsageReport()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"POST /usage/1.0/dead", "GET /usage/1.0/quiet", "GET /usage/1.0/busy"}, routes(report))
+	assert.Equal(t, int64(0), report[0].Calls)
+	assert.True(t, report[0].LastCalled.IsZero())
+	assert.Equal(t, int64(3), report[2].Calls)
+	assert.Equal(t, "usage", report[2].API)
+	assert.False(t, report[2].LastCalled.IsZero())
+
+	// persisted usage is aggregated with the usage of other instances
+	store := NewMemoryStore()
+	other := NewUsageTracker(store)
+	srv.TrackUsage(NewUsageTracker(store))
+	assert.NoError(t, other.Flush())
+
+	report, err = srv.UsageReport()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), report[0].Calls)
+	assert.Equal(t, int64(2), report[1].Calls)
+	assert.Equal(t, int64(6), report[2].Calls)
+
+	hr, _ := http.NewRequest("GET", UsagePath+"?limit=1", nil)
+	w := httptest.NewRecorder()
+	srv.usageHandler(w, hr)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, []string{"POST /usage/1.0/dead"}, routes(report))
+}
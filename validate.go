@@ -1,14 +1,54 @@
 package vertex
 
 import (
+	"fmt"
 	"github.com/EverythingMe/vertex/schema"
 	"net/http"
 	"reflect"
 	"regexp"
+	"strings"
 
 	"github.com/dvirsky/go-pylog/logging"
 )
 
+// Violation describes a param of a request that failed validation. Validation errors carry the violations of all
+// the params of the request, and render them in their body
+type Violation struct {
+	// The name of the param
+	Field string `json:"field"`
+
+	// The constraint the param violated: required, min, max, minLength, maxLength or pattern
+	Constraint string `json:"constraint"`
+
+	// The value provided for the param, if any
+	Value interface{} `json:"value,omitempty"`
+
+	Message string `json:"message"`
+}
+
+// violationError returns the validation error of a param violating a constraint
+func violationError(name, constraint string, value interface{}, format string, args ...interface{}) error {
+
+	msg := fmt.Sprintf(format, args...)
+	code := ErrInvalidParam
+	if constraint == "required" {
+		code = ErrMissingParam
+	}
+	return &internalError{
+		Message:    msg,
+		Code:       code,
+		Violations: []Violation{{Field: name, Constraint: constraint, Value: value, Message: msg}},
+	}
+}
+
+// Violations returns the param violations of a validation error
+func Violations(err error) []Violation {
+	if e, ok := err.(*internalError); ok {
+		return e.Violations
+	}
+	return nil
+}
+
 // Param validator interface
 type validator interface {
 	Validate(v reflect.Value, r *http.Request) error
@@ -29,7 +69,7 @@ func (v *fieldValidator) Validate(field reflect.Value, r *http.Request) error {
 	if v.Required {
 
 		if _, found := r.Form[v.Name]; !found || !field.IsValid() {
-			return MissingParamError("missing required param '%s'", v.Name)
+			return violationError(v.Name, "required", nil, "missing required param '%s'", v.Name)
 		}
 
 	}
@@ -84,10 +124,10 @@ func (v *intValidator) Validate(field reflect.Value, r *http.Request) error {
 	i := field.Int()
 
 	if v.HasMin && i < int64(v.Min) {
-		return InvalidParamError("Value too small for %s", v.GetParamName())
+		return violationError(v.GetParamName(), "min", i, "Value too small for %s", v.GetParamName())
 	}
 	if v.HasMax && i > int64(v.Max) {
-		return InvalidParamError("Value too large for %s", v.GetParamName())
+		return violationError(v.GetParamName(), "max", i, "Value too large for %s", v.GetParamName())
 	}
 
 	return nil
@@ -121,15 +161,15 @@ func (v *stringValidator) Validate(field reflect.Value, r *http.Request) error {
 	s := field.String()
 
 	if v.MaxLength > 0 && len(s) > v.MaxLength {
-		return InvalidParamError("%s is too long", v.GetParamName())
+		return violationError(v.GetParamName(), "maxLength", s, "%s is too long", v.GetParamName())
 	}
 
 	if v.MinLength > 0 && len(s) < v.MinLength {
-		return InvalidParamError("%s is too short", v.GetParamName())
+		return violationError(v.GetParamName(), "minLength", s, "%s is too short", v.GetParamName())
 	}
 
 	if v.re != nil && !v.re.MatchString(s) {
-		return InvalidParamError("%s does not match regex pattern", v.GetParamName())
+		return violationError(v.GetParamName(), "pattern", s, "%s does not match regex pattern", v.GetParamName())
 	}
 
 	return nil
@@ -174,10 +214,10 @@ func (v *floatValidator) Validate(field reflect.Value, r *http.Request) error {
 
 	f := field.Float()
 	if v.HasMin && f < v.Min {
-		return InvalidParamError("Value too small for %s", v.GetParamName())
+		return violationError(v.GetParamName(), "min", f, "Value too small for %s", v.GetParamName())
 	}
 	if v.HasMax && f > v.Max {
-		return InvalidParamError("Value too large for %s", v.GetParamName())
+		return violationError(v.GetParamName(), "max", f, "Value too large for %s", v.GetParamName())
 	}
 
 	return nil
@@ -215,8 +255,71 @@ func newBoolValidator(pi schema.ParamInfo) *boolValidator {
 
 type RequestValidator struct {
 	fieldValidators []validator
+
+	// binds the JSON body of the request, if the handler has a field tagged in:"body"
+	body *bodyValidator
+
+	// the params sent in request headers and cookies
+	headers []schema.ParamInfo
+	cookies []schema.ParamInfo
 }
 
+// bindHeaders sets the values of header params to the request's form, so they are decoded and validated like any
+// other param. Header params cannot be sent in the query or the form. Slice params take the comma separated values
+// of all the headers of their name
+func (rv *RequestValidator) bindHeaders(r *http.Request) {
+
+	for _, pi := range rv.headers {
+		r.Form.Del(pi.Name)
+
+		values := r.Header.Values(pi.Name)
+		if pi.Kind != reflect.Slice {
+			if len(values) > 0 {
+				r.Form.Set(pi.Name, values[0])
+			}
+			continue
+		}
+		for _, value := range values {
+			for _, v := range strings.Split(value, ",") {
+				if v = strings.TrimSpace(v); v != "" {
+					r.Form.Add(pi.Name, v)
+				}
+			}
+		}
+	}
+}
+
+// bindCookies sets the values of cookie params to the request's form, like bindHeaders. Slice params take the comma
+// separated values of all the cookies of their name
+func (rv *RequestValidator) bindCookies(r *http.Request) {
+
+	if len(rv.cookies) == 0 {
+		return
+	}
+
+	cookies := r.Cookies()
+	for _, pi := range rv.cookies {
+		r.Form.Del(pi.Name)
+
+		for _, c := range cookies {
+			if c.Name != pi.Name {
+				continue
+			}
+			if pi.Kind != reflect.Slice {
+				r.Form.Set(pi.Name, c.Value)
+				break
+			}
+			for _, v := range strings.Split(c.Value, ",") {
+				if v = strings.TrimSpace(v); v != "" {
+					r.Form.Add(pi.Name, v)
+				}
+			}
+		}
+	}
+}
+
+// Validate validates all the params of a request. The error of a request with invalid params carries the
+// violations of all of them, not just the first
 func (rv *RequestValidator) Validate(request interface{}, r *http.Request) error {
 
 	val := reflect.ValueOf(request)
@@ -224,6 +327,8 @@ func (rv *RequestValidator) Validate(request interface{}, r *http.Request) error
 		val = val.Elem()
 	}
 
+	var errs []error
+
 	//go over all the validators
 	for _, v := range rv.fieldValidators {
 
@@ -244,12 +349,38 @@ func (rv *RequestValidator) Validate(request interface{}, r *http.Request) error
 
 		if e != nil {
 			logging.Error("Could not validate field %s: %s", v.GetParamName(), e)
-			return e
+			errs = append(errs, e)
 		}
 
 	}
 
-	return nil
+	return joinViolations(errs)
+}
+
+// joinViolations joins the validation errors of the params of a request to a single error carrying all of their
+// violations. It is a missing param error if all of the violations are missing params
+func joinViolations(errs []error) error {
+
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+
+	ret := &internalError{Code: ErrMissingParam}
+	messages := make([]string, 0, len(errs))
+	for _, e := range errs {
+		for _, violation := range Violations(e) {
+			if violation.Constraint != "required" {
+				ret.Code = ErrInvalidParam
+			}
+			messages = append(messages, violation.Message)
+			ret.Violations = append(ret.Violations, violation)
+		}
+	}
+	ret.Message = strings.Join(messages, "; ")
+	return ret
 }
 
 // Create new request validator for a request handler interface.
@@ -267,6 +398,21 @@ func NewRequestValidator(ri schema.RequestInfo) *RequestValidator {
 	//iterate over the fields and create a validator for each
 	for _, pi := range ri.Params {
 
+		if pi.In == "body" {
+			if ret.body != nil {
+				logging.Error("Only one param can be bound to the body, ignoring %s", pi.StructKey)
+			} else {
+				ret.body = newBodyValidator(pi)
+			}
+			continue
+		}
+		switch pi.In {
+		case "header":
+			ret.headers = append(ret.headers, pi)
+		case "cookie":
+			ret.cookies = append(ret.cookies, pi)
+		}
+
 		var vali validator
 		switch pi.Kind {
 		//		case reflect.Struct:
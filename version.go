@@ -0,0 +1,80 @@
+package vertex
+
+import "strings"
+
+// VersionedResponse wraps a handler's response with the version of the entity it returns. The framework renders
+// the wrapped value and emits the version as the response's ETag.
+//
+// Clients send the version back in an If-Match header when modifying the entity, and handlers check it with
+// Request.CheckVersion before applying changes:
+//
+//	if err := r.CheckVersion(current.Version); err != nil {
+//		return nil, err
+//	}
+type VersionedResponse struct {
+	// The actual response object
+	Value interface{}
+
+	// An opaque version token of the entity, e.g. a revision number or a content hash
+	Version string
+}
+
+// Versioned wraps a response object with the version of the entity it returns
+func Versioned(v interface{}, version string) *VersionedResponse {
+	return &VersionedResponse{
+		Value:   v,
+		Version: version,
+	}
+}
+
+// ETag formats an entity version as a strong ETag
+func ETag(version string) string {
+	return `"` + version + `"`
+}
+
+// isMutating returns true for http methods that modify resources
+func isMutating(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return false
+	}
+	return true
+}
+
+// IfMatch returns the entity tags sent by the client in the If-Match header
+func (r *Request) IfMatch() []string {
+
+	hdr := r.Header.Get("If-Match")
+	if hdr == "" {
+		return nil
+	}
+
+	ret := []string{}
+	for _, tag := range strings.Split(hdr, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			ret = append(ret, tag)
+		}
+	}
+	return ret
+}
+
+// CheckVersion verifies that the entity version the client sent in the If-Match header matches the current
+// version of the entity. Requests without an If-Match header pass, unless the route requires it.
+// It returns a PreconditionFailedError if the versions do not match
+func (r *Request) CheckVersion(current string) error {
+
+	tags := r.IfMatch()
+	if tags == nil {
+		return nil
+	}
+
+	etag := ETag(current)
+	for _, tag := range tags {
+		if tag == "*" || tag == etag {
+			return nil
+		}
+	}
+
+	return PreconditionFailedError("Entity version %s does not match the current version %s",
+		strings.Join(tags, ", "), etag)
+}
@@ -0,0 +1,95 @@
+package vertex
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckVersion(t *testing.T) {
+
+	hr, _ := http.NewRequest("POST", "/foo", nil)
+	r := NewRequest(hr)
+
+	assert.Nil(t, r.IfMatch())
+	assert.NoError(t, r.CheckVersion("3"))
+
+	hr.Header.Set("If-Match", `"2", "3"`)
+	assert.Equal(t, []string{`"2"`, `"3"`}, r.IfMatch())
+	assert.NoError(t, r.CheckVersion("3"))
+
+	err := r.CheckVersion("4")
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusPreconditionFailed, errorStatus(err))
+
+	hr.Header.Set("If-Match", "*")
+	assert.NoError(t, r.CheckVersion("4"))
+}
+
+func TestVersionedRoutes(t *testing.T) {
+
+	version := "1"
+	a := &API{
+		Name:          "version",
+		Version:       "1.0",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{
+				Path:           "/entity",
+				Methods:        GET | POST,
+				RequireIfMatch: true,
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					if r.Method == "POST" {
+						if err := r.CheckVersion(version); err != nil {
+							return nil, err
+						}
+						version = "2"
+					}
+					return Versioned("entity", version), nil
+				}),
+			},
+		},
+	}
+
+	srv := NewServer(":9949")
+	srv.AddAPI(a)
+
+	do := func(method, ifMatch string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, a.FullPath("/entity"), bytes.NewBuffer(nil))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("GET", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+	assert.Equal(t, `"entity"`, w.Body.String())
+
+	assert.Equal(t, http.StatusPreconditionRequired, do("POST", "").Code)
+
+	w = do("POST", `"1"`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+
+	assert.Equal(t, http.StatusPreconditionFailed, do("POST", `"1"`).Code)
+}
+
+func TestCompareVersions(t *testing.T) {
+
+	assert.Equal(t, 0, compareVersions("3.2", "3.2.0"))
+	assert.Equal(t, 0, compareVersions("3.2.0", "3.2"))
+	assert.Equal(t, 0, compareVersions("1.01", "1.1"))
+	assert.Equal(t, -1, compareVersions("3.2", "3.2.1"))
+	assert.Equal(t, 1, compareVersions("3.2.1", "3.2"))
+	assert.Equal(t, -1, compareVersions("1.9", "1.10"))
+	assert.Equal(t, 1, compareVersions("2", "1.10"))
+	assert.Equal(t, -1, compareVersions("1.0-beta", "1.0-rc"))
+}
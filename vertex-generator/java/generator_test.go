@@ -3,6 +3,8 @@ package java
 import (
 	"encoding/json"
 	"fmt"
+	"regexp"
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -34,6 +36,25 @@ func TestGenerate(t *testing.T) {
 
 	assert.True(t, len(b) > 1000)
 
+	// requests are retried when the server asks to retry them later
+	assert.Contains(t, string(b), `return retrying(() -> perform(Request.Method.GET, "/ping",`)
+
+	// by default, the delay is read from the Retry-After header and the envelope of 429 and 503 failures
+	assert.Contains(t, string(b), `((Integer) status != 429 && (Integer) status != 503)`)
+	assert.Contains(t, string(b), `parseRetryAfter(invoke(t, new Object[]{"Retry-After"}, "getHeader", "header"))`)
+	assert.Contains(t, string(b), `return seconds < 0 ? -1 : seconds * 1000;`)
+
+	// the envelope pattern is a valid Go regexp too, so it is checked against a rendered envelope
+	m := regexp.MustCompile(`Pattern.compile\("((?:[^"\\]|\\.)*)"\)`).FindStringSubmatch(string(b))
+	if assert.Len(t, m, 2) {
+		pattern, err := strconv.Unquote(`"` + m[1] + `"`)
+		assert.NoError(t, err)
+		got := regexp.MustCompile(pattern).FindStringSubmatch(`{"errorCode": 18, "errorString": "slow down", "retryAfter": 2}`)
+		if assert.Len(t, got, 2) {
+			assert.Equal(t, "2", got[1])
+		}
+	}
+
 	// TODO: Real validation of the output. Right now it's not stable enough to validate
 	fmt.Println(string(b))
 
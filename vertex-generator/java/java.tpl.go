@@ -11,9 +11,17 @@ var tpl = `
 
 package {{ .Package }};
 
+import java.util.Date;
 import java.util.HashMap;
+import java.util.Locale;
 import java.util.Map;
+import java.util.Timer;
+import java.util.TimerTask;
+import java.util.regex.Matcher;
+import java.util.regex.Pattern;
 import java.io.Serializable;
+import java.nio.charset.StandardCharsets;
+import java.text.SimpleDateFormat;
 
 import everything.me.vertex.BaseAPI;
 import everything.me.vertex.Client;
@@ -48,6 +56,120 @@ public class {{.Name}} extends BaseAPI {
         super(secure, host, "{{ .Root }}", decoder, client);
     }
 
+    /** How many times a request is retried when the server asks to retry it later */
+    public int maxRetries = 3;
+
+    /** The longest retry hint honored, in milliseconds. Requests asked to wait longer fail right away */
+    public long maxRetryAfterMillis = 60000;
+
+    private static final Timer retryTimer = new Timer("{{ .Name }}-retries", true);
+
+    /** A request attempt, performed again when the server asks to retry it later */
+    protected interface Attempt<T> {
+        CompletableFuture<T> run();
+    }
+
+    /**
+    * Returns the delay in milliseconds the server asked to wait before retrying a failed request, or -1 if it
+    * should not be retried. The server sends the delay of 429 and 503 responses in their Retry-After header, and
+    * in the retryAfter field of the standard envelope.
+    *
+    * The status, headers and body of the response are read from the failure or its causes, with the accessors http
+    * failures commonly have: getStatusCode, getStatus or code, getHeader or header, and getBody or body. Clients
+    * whose failures carry them otherwise override this method
+    **/
+    protected long retryAfterMillis(Throwable failure) {
+        for (Throwable t = failure; t != null; t = t.getCause() == t ? null : t.getCause()) {
+            Object status = invoke(t, new Object[0], "getStatusCode", "getStatus", "statusCode", "code");
+            if (!(status instanceof Integer) || ((Integer) status != 429 && (Integer) status != 503)) {
+                continue;
+            }
+            long delay = parseRetryAfter(invoke(t, new Object[]{"Retry-After"}, "getHeader", "header"));
+            if (delay < 0) {
+                delay = parseEnvelopeRetryAfter(invoke(t, new Object[0], "getBody", "body"));
+            }
+            return delay;
+        }
+        return -1;
+    }
+
+    /** Parses a Retry-After header, in seconds or as an http date, into milliseconds. Returns -1 if it is invalid */
+    static long parseRetryAfter(Object header) {
+        if (!(header instanceof String)) {
+            return -1;
+        }
+        String value = ((String) header).trim();
+        try {
+            long seconds = Long.parseLong(value);
+            return seconds < 0 ? -1 : seconds * 1000;
+        } catch (NumberFormatException e) {
+            // not in seconds, but it may be a date
+        }
+        try {
+            SimpleDateFormat format = new SimpleDateFormat("EEE, dd MMM yyyy HH:mm:ss zzz", Locale.US);
+            return Math.max(0, format.parse(value).getTime() - new Date().getTime());
+        } catch (java.text.ParseException e) {
+            return -1;
+        }
+    }
+
+    private static final Pattern envelopeRetryAfter = Pattern.compile("\"retryAfter\"\\s*:\\s*([0-9]+)");
+
+    /** Parses the retryAfter field of a standard envelope, in seconds, into milliseconds. Returns -1 if it has none */
+    static long parseEnvelopeRetryAfter(Object body) {
+        if (body instanceof byte[]) {
+            body = new String((byte[]) body, StandardCharsets.UTF_8);
+        }
+        if (!(body instanceof String)) {
+            return -1;
+        }
+        Matcher m = envelopeRetryAfter.matcher((String) body);
+        return m.find() ? Long.parseLong(m.group(1)) * 1000 : -1;
+    }
+
+    /** Calls the first of the named methods the target has, returning null if it has none of them */
+    private static Object invoke(Object target, Object[] args, String... names) {
+        Class<?>[] types = new Class<?>[args.length];
+        for (int i = 0; i < args.length; i++) {
+            types[i] = args[i].getClass();
+        }
+        for (String name : names) {
+            try {
+                return target.getClass().getMethod(name, types).invoke(target, args);
+            } catch (ReflectiveOperationException e) {
+                // not an accessor of this failure
+            }
+        }
+        return null;
+    }
+
+    /** Runs an attempt, running it again after the delay the server asked for as long as it asks to retry */
+    protected <T> CompletableFuture<T> retrying(final Attempt<T> attempt) {
+        final CompletableFuture<T> ret = new CompletableFuture<>();
+        retry(attempt, ret, 0);
+        return ret;
+    }
+
+    private <T> void retry(final Attempt<T> attempt, final CompletableFuture<T> ret, final int retries) {
+        attempt.run().whenComplete((value, failure) -> {
+            if (failure == null) {
+                ret.complete(value);
+                return;
+            }
+            long delay = retries < maxRetries ? retryAfterMillis(failure) : -1;
+            if (delay < 0 || delay > maxRetryAfterMillis) {
+                ret.completeExceptionally(failure);
+                return;
+            }
+            retryTimer.schedule(new TimerTask() {
+                @Override
+                public void run() {
+                    retry(attempt, ret, retries + 1);
+                }
+            }, delay);
+        });
+    }
+
 
     
 {{ range .Methods }}
@@ -63,10 +185,10 @@ public class {{.Name}} extends BaseAPI {
     public CompletableFuture<{{ .Returns }}> {{ .Name }}({{ renderArguments .Params }}) {
         {{ template "buildMaps" . }}\
         
-        return perform(Request.Method.{{ .HttpVerb }}, "{{.Path}}",
-                       params,
-                       pathParams,
-                       parser({{ .Returns }}.class));
+        return retrying(() -> perform(Request.Method.{{ .HttpVerb }}, "{{.Path}}",
+                                      params,
+                                      pathParams,
+                                      parser({{ .Returns }}.class)));
     }
 
 {{ end }}
@@ -82,8 +204,8 @@ public class {{.Name}} extends BaseAPI {
 {{ end }}\
 
 {{ define "buildMaps" }}
-        Map<String,Object> pathParams = new HashMap<>();
-        Request.ParamMap params = new Request.ParamMap();\
+        final Map<String,Object> pathParams = new HashMap<>();
+        final Request.ParamMap params = new Request.ParamMap();\
 {{ range .Params }}{{ if eq .In "query" "body" }}
         params.set("{{.Name}}", {{.Name}});
 {{ else if eq .In "path" }}\
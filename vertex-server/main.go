@@ -15,6 +15,7 @@ func main() {
 	logging.SetMinimalLevelByName(vertex.Config.Server.LoggingLevel)
 	srv := vertex.NewServer(vertex.Config.Server.ListenAddr)
 	srv.InitAPIs()
+	srv.UpgradeOnSignal()
 	if err := srv.Run(); err != nil {
 		panic(err)
 	}
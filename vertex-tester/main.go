@@ -18,13 +18,28 @@ func main() {
 
 	serverAddr := flag.String("server", "http://127.0.0.1:9947", "The server URL to connect to")
 	apiName := flag.String("api", "", "The API we want to test")
-	category := flag.String("category", "all", "The test category we want to run [all|critical|warning]")
+	category := flag.String("category", "all", "The test category we want to run [all|critical|warning|security]")
 	format := flag.String("format", "text", "Result Output Format [text|json]")
+	fixtures := flag.String("fixtures", "", "Record responses as fixtures or replay them [record|replay]")
+	fixturesDir := flag.String("fixtures_dir", "fixtures", "The directory of the recorded fixtures")
+	coverageFile := flag.String("coverage_out", "", "Write the route coverage report as JSON to this file")
+	requireCoverage := flag.Bool("require_coverage", false, "Fail if a route is not exercised by the tests")
+	env := flag.String("env", "", "Run the tests against a test environment of the config instead of -server")
+	fuzz := flag.Bool("fuzz", false, "Fuzz the params of all the routes instead of running the tests")
 
 	logging.SetMinimalLevel(logging.CRITICAL)
 	vertex.ReadConfigs()
 
-	success := vertex.RunCLITest(*apiName, *serverAddr, *category, *format)
+	success := vertex.RunCLITestOptions(*apiName, *serverAddr, vertex.CLITestOptions{
+		Category:        *category,
+		Format:          *format,
+		Fixtures:        *fixtures,
+		FixturesDir:     *fixturesDir,
+		CoverageFile:    *coverageFile,
+		RequireCoverage: *requireCoverage,
+		Environment:     *env,
+		Fuzz:            *fuzz,
+	}, os.Stdout)
 	if !success {
 		fmt.Fprintln(os.Stderr, "Tests Failed")
 		os.Exit(-1)
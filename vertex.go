@@ -21,8 +21,15 @@ const (
 	HeaderRequestId      = "X-Vertex-RequestId"
 	HeaderHost           = "X-Vertex-Host"
 	HeaderServerVersion  = "X-Vertex-Version"
+
+	// The common header of request ids, accepted from clients and proxies in place of a generated id, and echoed
+	// along with HeaderRequestId
+	HeaderXRequestId = "X-Request-ID"
 )
 
+// MaxRequestIdLength is the maximum length of request ids accepted from clients
+const MaxRequestIdLength = 128
+
 // RequestHandler is the interface that request handler structs should implement.
 //
 // The idea is that you define your request parameters as struct fields, and they get mapped automatically
@@ -140,12 +147,18 @@ func (VoidHandler) Handle(w http.ResponseWriter, r *Request) (interface{}, error
 	return nil, nil
 }
 
-// SecurityScheme is a special interface that validates a request and is outside the middleware chain.
-// An API has a default security scheme, and each route can override it
+// SecurityScheme is a special interface that validates a request. It runs as a stage of the middleware chain -
+// see SecurityStage. An API has a default security scheme, and each route can override it
 type SecurityScheme interface {
 	Validate(r *Request) error
 }
 
+// Challenger is implemented by security schemes that tell clients how to authenticate. The challenge is sent in a
+// WWW-Authenticate header when the scheme rejects a request for missing credentials, e.g. `Bearer realm="api"`
+type Challenger interface {
+	Challenge(r *Request) string
+}
+
 type SecuritySchemeFunc func(r *Request) error
 
 func (f SecuritySchemeFunc) Validate(r *Request) error {
@@ -161,15 +174,33 @@ type MethodFlag int
 
 // Method flag definitions
 const (
-	GET  MethodFlag = 0x01
-	POST MethodFlag = 0x02
-	PUT  MethodFlag = 0x03
+	GET     MethodFlag = 0x01
+	POST    MethodFlag = 0x02
+	PUT     MethodFlag = 0x04
+	DELETE  MethodFlag = 0x08
+	PATCH   MethodFlag = 0x10
+	OPTIONS MethodFlag = 0x20
+	HEAD    MethodFlag = 0x40
 )
 
+// methodFlags maps the method flags to http methods, in the order routes are registered and reported
+var methodFlags = []struct {
+	name string
+	flag MethodFlag
+}{
+	{"GET", GET},
+	{"POST", POST},
+	{"PUT", PUT},
+	{"DELETE", DELETE},
+	{"PATCH", PATCH},
+	{"OPTIONS", OPTIONS},
+	{"HEAD", HEAD},
+}
+
 var schemaDecoder = gorilla.NewDecoder()
 
 // Parse the user input into a request handler struct, with input validation
-func parseInput(r *http.Request, input interface{}, validator *RequestValidator) error {
+func parseInput(r *Request, input interface{}, validator *RequestValidator) error {
 
 	schemaDecoder.IgnoreUnknownKeys(true)
 
@@ -180,15 +211,31 @@ func parseInput(r *http.Request, input interface{}, validator *RequestValidator)
 	// We do not map and validate input to non-struct handlers
 	if reflect.TypeOf(input).Kind() != reflect.Func {
 
+		validator.bindHeaders(r.Request)
+		validator.bindCookies(r.Request)
 		if err := schemaDecoder.Decode(input, r.Form); err != nil {
 			return InvalidRequestError("Error decoding schema: %s", err)
 		}
 
+		var errs []error
+
+		// bind the JSON body, if the handler takes one
+		if validator.body != nil {
+			body, err := r.RawBody()
+			if err != nil {
+				return NewError(err)
+			}
+			errs = validator.body.bind(reflect.ValueOf(input).Elem(), body)
+		}
+
 		// Validate the input based on the API spec
-		if err := validator.Validate(input, r); err != nil {
+		if err := validator.Validate(input, r.Request); err != nil {
+			errs = append(errs, err)
+		}
+
+		if err := joinViolations(errs); err != nil {
 			logging.Error("Error validating http.Request!: %s", err)
 			return NewError(err)
-
 		}
 
 	}
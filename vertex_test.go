@@ -2,6 +2,7 @@ package vertex
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -496,6 +497,7 @@ func TestErrors(t *testing.T) {
 	testErr(InsecureAccessDenied("sdfsd"), ErrInsecureAccessDenied, http.StatusForbidden)
 	testErr(ResourceUnavailableError("sdfsd"), ErrResourceUnavailable, http.StatusServiceUnavailable)
 	testErr(BackOffError(0), ErrBackOff, http.StatusServiceUnavailable)
+	testErr(UpgradeRequiredError("sdfsd"), ErrUpgradeRequired, http.StatusUpgradeRequired)
 
 }
 
@@ -607,6 +609,261 @@ func TestValidation(t *testing.T) {
 	}
 }
 
+func TestValidationViolations(t *testing.T) {
+
+	ri, err := schema.NewRequestInfo(reflect.TypeOf(MockHandlerV{}), "/foo", "bar", nil)
+	assert.NoError(t, err)
+	v := NewRequestValidator(ri)
+
+	// all the invalid params are reported, not just the first
+	req, _ := http.NewRequest("GET", "http://example.com/foo?int=1000&string=watwatwat", nil)
+	req.ParseForm()
+	err = v.Validate(&MockHandlerV{Int: 1000, Float: 3.14, String: "watwatwat"}, req)
+	assert.Equal(t, ErrInvalidParam, err.(*internalError).Code)
+	assert.Equal(t, "Value too large for int; missing required param 'float'; string is too long", err.Error())
+	assert.Equal(t, []Violation{
+		{Field: "int", Constraint: "max", Value: int64(1000), Message: "Value too large for int"},
+		{Field: "float", Constraint: "required", Message: "missing required param 'float'"},
+		{Field: "string", Constraint: "maxLength", Value: "watwatwat", Message: "string is too long"},
+	}, Violations(err))
+
+	// a single violation keeps the code of its error
+	req, _ = http.NewRequest("GET", "http://example.com/foo?int=4&string=wat", nil)
+	req.ParseForm()
+	err = v.Validate(&MockHandlerV{Int: 4, String: "wat"}, req)
+	assert.Equal(t, ErrMissingParam, err.(*internalError).Code)
+	assert.Len(t, Violations(err), 1)
+}
+
+type violationsHandler struct {
+	Name  string `schema:"name" required:"true" maxlen:"4"`
+	Count int    `schema:"count" min:"1" max:"10" default:"1"`
+}
+
+func (h violationsHandler) Handle(w http.ResponseWriter, r *Request) (interface{}, error) {
+	return h.Name, nil
+}
+
+func TestValidationViolationsRendering(t *testing.T) {
+
+	a := &API{
+		Name:          "violations",
+		Version:       "1.0",
+		Root:          "/violations",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{Path: "/plain", Description: "Plain", Methods: GET, Handler: violationsHandler{}},
+			{Path: "/csv", Description: "CSV", Methods: GET, Renderer: CSVRenderer{}, Handler: violationsHandler{}},
+		},
+	}
+	srv := NewServer(":9998")
+	srv.AddAPI(a)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		hr, _ := http.NewRequest("GET", a.FullPath(path), nil)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, hr)
+		return w
+	}
+
+	for _, path := range []string{"/plain?name=toolong&count=20", "/csv?name=toolong&count=20"} {
+		w := get(path)
+		assert.Equal(t, http.StatusBadRequest, w.Code, path)
+		var body validationErrorBody
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body), path)
+		assert.Equal(t, []string{"name", "count"}, []string{body.Violations[0].Field, body.Violations[1].Field})
+		assert.Equal(t, "maxLength", body.Violations[0].Constraint)
+		assert.Equal(t, "toolong", body.Violations[0].Value)
+	}
+
+	// problems and envelopes carry the violations
+	a.Problems = &ProblemDetails{}
+	var p Problem
+	assert.NoError(t, json.Unmarshal(get("/plain?count=20").Body.Bytes(), &p))
+	assert.Len(t, p.Violations, 2)
+
+	a.Problems = nil
+	a.Envelope = StandardEnvelope{}
+	assert.Contains(t, get("/plain?name=toolong").Body.String(), `"violations":[{"field":"name","constraint":"maxLength","value":"toolong"`)
+
+	// and the shape is documented
+	sw := a.ToSwagger("example.com")
+	assert.Equal(t, "#/definitions/ValidationError", sw.Paths["/plain"]["get"].Responses["400"].Schema.Ref)
+	assert.NotNil(t, sw.Definitions["ValidationError"])
+}
+
+type headerParamsHandler struct {
+	ClientVersion string   `schema:"X-Client-Version" in:"header" required:"true" maxlen:"10" doc:"Version of the client"`
+	Tags          []string `schema:"X-Tags" in:"header"`
+	Name          string   `schema:"name"`
+}
+
+func (h headerParamsHandler) Handle(w http.ResponseWriter, r *Request) (interface{}, error) {
+	return h, nil
+}
+
+func TestHeaderParams(t *testing.T) {
+
+	a := &API{
+		Name:          "headerparams",
+		Version:       "1.0",
+		Root:          "/headerparams",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{Path: "/echo", Description: "Echo", Methods: GET, Handler: headerParamsHandler{}},
+		},
+	}
+	srv := NewServer(":9939")
+	srv.AddAPI(a)
+
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		hr, _ := http.NewRequest("GET", a.FullPath(path), nil)
+		for k, v := range header {
+			hr.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, hr)
+		return w
+	}
+
+	w := get("/echo?name=foo", http.Header{"X-Client-Version": {"1.2.3"}, "X-Tags": {"a, b", "c"}})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var h headerParamsHandler
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &h))
+	assert.Equal(t, "1.2.3", h.ClientVersion)
+	assert.Equal(t, []string{"a", "b", "c"}, h.Tags)
+	assert.Equal(t, "foo", h.Name)
+
+	// header params cannot be sent in the query
+	w = get("/echo?X-Client-Version=1.2.3", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var body validationErrorBody
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "X-Client-Version", body.Violations[0].Field)
+	assert.Equal(t, "required", body.Violations[0].Constraint)
+
+	// and are validated like any other param
+	w = get("/echo", http.Header{"X-Client-Version": {"1.2.3-beta.10"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "maxLength", body.Violations[0].Constraint)
+
+	sw := a.ToSwagger("example.com")
+	params := sw.Paths["/echo"]["get"].Parameters
+	assert.Len(t, params, 3)
+	for _, p := range params {
+		if p.Name == "X-Client-Version" || p.Name == "X-Tags" {
+			assert.Equal(t, "header", p.In, p.Name)
+		}
+	}
+}
+
+type cookieParamsHandler struct {
+	Theme string   `schema:"theme" in:"cookie" required:"true" pattern:"^(light|dark)$"`
+	Seen  []string `schema:"seen" in:"cookie"`
+}
+
+func (h cookieParamsHandler) Handle(w http.ResponseWriter, r *Request) (interface{}, error) {
+	return h, nil
+}
+
+func TestCookieParams(t *testing.T) {
+
+	a := &API{
+		Name:          "cookieparams",
+		Version:       "1.0",
+		Root:          "/cookieparams",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{Path: "/echo", Description: "Echo", Methods: GET, Handler: cookieParamsHandler{}},
+		},
+	}
+	srv := NewServer(":9937")
+	srv.AddAPI(a)
+
+	get := func(path string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		hr, _ := http.NewRequest("GET", a.FullPath(path), nil)
+		for _, c := range cookies {
+			hr.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, hr)
+		return w
+	}
+
+	w := get("/echo", &http.Cookie{Name: "theme", Value: "dark"}, &http.Cookie{Name: "seen", Value: "a,b"},
+		&http.Cookie{Name: "seen", Value: "c"}, &http.Cookie{Name: "other", Value: "x"})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var h cookieParamsHandler
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &h))
+	assert.Equal(t, "dark", h.Theme)
+	assert.Equal(t, []string{"a", "b", "c"}, h.Seen)
+
+	// cookie params cannot be sent in the query, and are validated like any other param
+	var body validationErrorBody
+	w = get("/echo?theme=dark")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "theme", body.Violations[0].Field)
+	assert.Equal(t, "required", body.Violations[0].Constraint)
+
+	w = get("/echo", &http.Cookie{Name: "theme", Value: "neon"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// swagger 2.0 has no cookie params, so they are documented in an extension
+	method := a.ToSwagger("example.com").Paths["/echo"]["get"]
+	assert.Empty(t, method.Parameters)
+	assert.Len(t, method.Cookies, 2)
+	assert.Equal(t, "cookie", method.Cookies[0].In)
+	assert.Equal(t, "^(light|dark)$", method.Cookies[0].Pattern)
+}
+
+func TestRequestId(t *testing.T) {
+
+	hr, _ := http.NewRequest("GET", "http://example.com", nil)
+	generated := NewRequest(hr).RequestId
+	assert.NotEmpty(t, generated)
+	assert.NotEqual(t, generated, NewRequest(hr).RequestId)
+
+	// ids of clients and proxies are accepted, if they are safe to log and echo
+	hr.Header.Set(HeaderRequestId, "upstream-1")
+	assert.Equal(t, "upstream-1", NewRequest(hr).RequestId)
+	hr.Header.Set(HeaderXRequestId, "abc-123_x.y")
+	assert.Equal(t, "abc-123_x.y", NewRequest(hr).RequestId)
+	hr.Header.Set(HeaderXRequestId, "bad\r\nid")
+	assert.Equal(t, "upstream-1", NewRequest(hr).RequestId)
+	hr.Header.Del(HeaderRequestId)
+	hr.Header.Set(HeaderXRequestId, strings.Repeat("a", MaxRequestIdLength+1))
+	assert.NotEqual(t, strings.Repeat("a", MaxRequestIdLength+1), NewRequest(hr).RequestId)
+
+	// responses echo the id, even when the request fails before reaching the renderer
+	a := &API{
+		Name:     "requestids",
+		Version:  "1.0",
+		Root:     "/requestids",
+		Renderer: JSONRenderer{},
+		Routes: Routes{
+			{Path: "/ok", Description: "Ok", Methods: GET, Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+				return r.RequestId, nil
+			})},
+		},
+	}
+	srv := NewServer(":9946")
+	srv.AddAPI(a)
+
+	hr, _ = http.NewRequest("GET", "http://example.com"+a.FullPath("/ok"), nil)
+	hr.RemoteAddr = "10.0.0.1:1234"
+	hr.Header.Set(HeaderXRequestId, "trace-42")
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, hr)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "trace-42", w.Header().Get(HeaderXRequestId))
+	assert.Equal(t, "trace-42", w.Header().Get(HeaderRequestId))
+}
+
 func TestRequest(t *testing.T) {
 
 	req, err := http.NewRequest("GET", "http://example.com?callback=foo", nil)
@@ -653,6 +910,28 @@ func TestRequest(t *testing.T) {
 	assert.Equal(t, "1.1.1.1", NewRequest(req).RemoteIP)
 }
 
+func TestRawBody(t *testing.T) {
+
+	// form bodies are buffered before the form is parsed
+	req, _ := http.NewRequest("POST", "/foo", strings.NewReader("foo=bar&baz=1"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r := NewRequest(req)
+	body, err := r.RawBody()
+	assert.NoError(t, err)
+	assert.Equal(t, "foo=bar&baz=1", string(body))
+	assert.Equal(t, "bar", r.FormValue("foo"))
+
+	// other bodies can still be read after RawBody
+	req, _ = http.NewRequest("POST", "/foo", strings.NewReader(`{"foo":"bar"}`))
+	req.Header.Set("Content-Type", "application/json")
+	r = NewRequest(req)
+	body, err = r.RawBody()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"foo":"bar"}`, string(body))
+	b, _ := ioutil.ReadAll(r.Body)
+	assert.Equal(t, body, b)
+}
+
 func TestRunCLIClient(t *testing.T) {
 	srv := NewServer(":9947")
 	srv.AddAPI(mockAPI)
@@ -680,3 +959,107 @@ func TestRunCLIClient(t *testing.T) {
 	}
 
 }
+
+func TestRetryHints(t *testing.T) {
+
+	hr, _ := http.NewRequest("GET", "/foo", nil)
+	r := NewRequest(hr)
+
+	check := func(err error, status int, retryAfter string) {
+		w := httptest.NewRecorder()
+		renderError(w, r, err)
+		assert.Equal(t, status, w.Code)
+		assert.Equal(t, retryAfter, w.Header().Get("Retry-After"))
+	}
+
+	check(TooManyRequestsError(1500*time.Millisecond, "slow down"), http.StatusTooManyRequests, "2")
+	check(TemporarilyUnavailableError(time.Minute, "maintenance"), http.StatusServiceUnavailable, "60")
+	check(BackOffError(10*time.Second), http.StatusServiceUnavailable, "10")
+	check(ResourceUnavailableError("nope"), http.StatusServiceUnavailable, "")
+
+	assert.Equal(t, 10*time.Second, RetryAfter(BackOffError(10*time.Second)))
+	assert.Equal(t, time.Duration(0), RetryAfter(NewErrorf("wat")))
+}
+
+type contextTestKey struct{}
+
+func TestRequestContext(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	hr, _ := http.NewRequest("GET", "/foo", nil)
+	r := NewRequest(hr.WithContext(ctx))
+	assert.True(t, r.Deadline.IsZero())
+
+	// request scoped values are visible to code taking the context
+	r.WithValue(contextTestKey{}, "bar")
+	assert.Equal(t, "bar", r.Value(contextTestKey{}))
+	assert.Equal(t, "bar", r.Context().Value(contextTestKey{}))
+	assert.Nil(t, r.Value("missing"))
+
+	// deadlines bound the context, and only shorten it
+	deadline := time.Now().Add(time.Minute)
+	defer r.SetDeadline(deadline)()
+	r.SetDeadline(deadline.Add(time.Hour))()
+	assert.Equal(t, deadline, r.Deadline)
+	d, ok := r.Context().Deadline()
+	assert.True(t, ok)
+	assert.Equal(t, deadline, d)
+	assert.Equal(t, "bar", r.Value(contextTestKey{}))
+
+	// requests inherit the deadline of their context
+	assert.Equal(t, deadline, NewRequest(r.Request).Deadline)
+
+	// the context is canceled with the client's connection
+	cancel()
+	assert.Equal(t, context.Canceled, r.Context().Err())
+
+	// failures of canceled and timed out requests are rendered as such
+	assert.Equal(t, StatusClientClosedRequest, errorStatus(r.Context().Err()))
+	assert.Equal(t, http.StatusGatewayTimeout, errorStatus(fmt.Errorf("query failed: %w", context.DeadlineExceeded)))
+	assert.Equal(t, http.StatusGatewayTimeout, errorStatus(TimeoutError("slow")))
+	status, msg := httpError(context.Canceled)
+	assert.Equal(t, StatusClientClosedRequest, status)
+	assert.Equal(t, "Client Closed Request", msg)
+}
+
+func TestRouteMethods(t *testing.T) {
+
+	a := &API{
+		Name:          "methods",
+		Version:       "1.0",
+		Root:          "/methods",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{Path: "/items", Description: "Items", Methods: PUT | DELETE | PATCH | HEAD, Handler: VoidHandler{}},
+			{Path: "/options", Description: "Options", Methods: OPTIONS, Handler: VoidHandler{}},
+		},
+	}
+	srv := NewServer(":9973")
+	srv.AddAPI(a)
+
+	call := func(method, path string) int {
+		hr, _ := http.NewRequest(method, a.FullPath(path), nil)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, hr)
+		return w.Code
+	}
+
+	for _, method := range []string{"PUT", "DELETE", "PATCH", "HEAD"} {
+		assert.Equal(t, http.StatusOK, call(method, "/items"), method)
+	}
+	assert.Equal(t, http.StatusMethodNotAllowed, call("GET", "/items"))
+	assert.Equal(t, http.StatusOK, call("OPTIONS", "/options"))
+
+	assert.Equal(t, []string{"PUT", "DELETE", "PATCH", "HEAD"}, (PUT | DELETE | PATCH | HEAD).names())
+
+	sw := a.ToSwagger("example.com")
+	for _, method := range []string{"put", "delete", "patch", "head"} {
+		_, found := sw.Paths["/items"][method]
+		assert.True(t, found, method)
+	}
+	_, found := sw.Paths["/items"]["get"]
+	assert.False(t, found)
+	_, found = sw.Paths["/options"]["options"]
+	assert.True(t, found)
+}
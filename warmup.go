@@ -0,0 +1,171 @@
+package vertex
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// DefaultWarmupTimeout bounds warmup hooks that do not set a timeout
+const DefaultWarmupTimeout = 30 * time.Second
+
+// WarmupHook prepares the server for traffic before it is marked ready, e.g. priming caches, parsing templates,
+// opening connection pools or running smoke tests:
+//
+//	srv.AddWarmup(vertex.WarmupHook{Name: "templates", Run: func(ctx context.Context) error {
+//		return renderer.Load()
+//	}})
+//
+// Hooks run one after the other in the order they were added, after the server started listening and before its
+// readiness check passes. Liveness checks pass while they run, so slow hooks do not get the process restarted
+type WarmupHook struct {
+	Name string
+
+	// Maximum time the hook may run. The context of the hook is canceled when it passes, and the hook is considered
+	// failed. Defaults to DefaultWarmupTimeout
+	Timeout time.Duration
+
+	// If set, the server shuts down if the hook fails instead of becoming ready with a cold start
+	Required bool
+
+	Run func(ctx context.Context) error
+}
+
+// warmup is the warmup state of a server
+type warmup struct {
+	sync.Mutex
+	hooks   []WarmupHook
+	current int
+	running bool
+	err     error
+}
+
+// AddWarmup adds a hook run before the server is marked ready
+func (s *Server) AddWarmup(hook WarmupHook) {
+	s.warmup.Lock()
+	defer s.warmup.Unlock()
+	s.warmup.hooks = append(s.warmup.hooks, hook)
+}
+
+// warmUp runs the warmup hooks and marks the server ready. If a required hook fails the server is shut down
+func (s *Server) warmUp(ctx context.Context) {
+
+	if err := s.runWarmups(ctx); err != nil {
+		logging.Critical("Warmup failed, shutting down: %s", err)
+		if err := s.shutdown(time.Duration(Config.Server.ShutdownGrace)*time.Second, false); err != nil {
+			logging.Error("Error shutting down server: %s", err)
+		}
+		return
+	}
+
+	// the server may have been stopped while warming up
+	if ctx.Err() != nil {
+		return
+	}
+
+	// if we were started by an upgrade, let the parent know it can go away
+	notifyUpgradeReady()
+	notifySystemd(sdReadyState())
+	s.setReady(true)
+}
+
+// runWarmups runs the warmup hooks in order, returning the error of the first required hook that failed
+func (s *Server) runWarmups(ctx context.Context) error {
+
+	s.warmup.Lock()
+	hooks := s.warmup.hooks
+	s.warmup.running = true
+	s.warmup.Unlock()
+
+	defer func() {
+		s.warmup.Lock()
+		s.warmup.running = false
+		s.warmup.Unlock()
+	}()
+
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	st := time.Now()
+	failed := 0
+	for i, hook := range hooks {
+
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		s.warmup.Lock()
+		s.warmup.current = i
+		s.warmup.Unlock()
+
+		logging.Info("Warming up (%d/%d): %s", i+1, len(hooks), hook.Name)
+		hst := time.Now()
+		if err := runWarmupHook(ctx, hook); err != nil {
+			if hook.Required {
+				err = fmt.Errorf("Required warmup %s failed: %s", hook.Name, err)
+				s.warmup.Lock()
+				s.warmup.err = err
+				s.warmup.Unlock()
+				return err
+			}
+			failed++
+			logging.Error("Warmup %s failed after %v, continuing: %s", hook.Name, time.Since(hst), err)
+			continue
+		}
+		logging.Info("Warmup %s done in %v", hook.Name, time.Since(hst))
+	}
+
+	logging.Info("Warmed up in %v, %d of %d hooks failed", time.Since(st), failed, len(hooks))
+	return nil
+}
+
+// runWarmupHook runs a hook with its timeout. A hook ignoring its context keeps running in the background, since
+// there is no way to stop it
+func runWarmupHook(ctx context.Context, hook WarmupHook) (err error) {
+
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = DefaultWarmupTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ch := make(chan error, 1)
+	go func() {
+		defer func() {
+			if e := recover(); e != nil {
+				ch <- fmt.Errorf("Panic: %v", e)
+			}
+		}()
+		ch <- hook.Run(ctx)
+	}()
+
+	select {
+	case err = <-ch:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("Timed out after %v", timeout)
+	}
+}
+
+// warmupProgress describes the hook the server is warming up with, or returns an empty string if it is not warming up
+func (s *Server) warmupProgress() string {
+	s.warmup.Lock()
+	defer s.warmup.Unlock()
+
+	if !s.warmup.running || len(s.warmup.hooks) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Warming up (%d/%d): %s", s.warmup.current+1, len(s.warmup.hooks), s.warmup.hooks[s.warmup.current].Name)
+}
+
+// warmupError returns the error of the required warmup hook that failed, if any
+func (s *Server) warmupError() error {
+	s.warmup.Lock()
+	defer s.warmup.Unlock()
+	return s.warmup.err
+}
@@ -0,0 +1,96 @@
+package vertex
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWarmup(t *testing.T) {
+
+	srv := NewServer(":9940")
+	srv.registerHealthChecks()
+
+	ready := func() (int, string) {
+		hr, _ := http.NewRequest("GET", ReadinessPath, nil)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, hr)
+		return w.Code, w.Body.String()
+	}
+
+	started, release := make(chan struct{}), make(chan struct{})
+	var mtx sync.Mutex
+	ran := []string{}
+	record := func(name string) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		ran = append(ran, name)
+	}
+	srv.AddWarmup(WarmupHook{Name: "pools", Run: func(ctx context.Context) error {
+		record("pools")
+		close(started)
+		<-release
+		return nil
+	}})
+	srv.AddWarmup(WarmupHook{Name: "cache", Run: func(ctx context.Context) error {
+		record("cache")
+		return errors.New("cache is down")
+	}})
+	srv.AddWarmup(WarmupHook{Name: "slow", Timeout: 10 * time.Millisecond, Run: func(ctx context.Context) error {
+		record("slow")
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+	srv.AddWarmup(WarmupHook{Name: "panics", Run: func(ctx context.Context) error {
+		panic("boom")
+	}})
+
+	done := make(chan struct{})
+	go func() {
+		srv.warmUp(context.Background())
+		close(done)
+	}()
+
+	// readiness fails with the warmup progress until it is done
+	<-started
+	code, body := ready()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, body, "Warming up (1/4): pools")
+
+	// failed hooks that are not required do not keep the server from becoming ready
+	close(release)
+	<-done
+	mtx.Lock()
+	assert.Equal(t, []string{"pools", "cache", "slow"}, ran)
+	mtx.Unlock()
+	code, _ = ready()
+	assert.Equal(t, http.StatusOK, code)
+	assert.NoError(t, srv.warmupError())
+	assert.Empty(t, srv.warmupProgress())
+}
+
+func TestRequiredWarmup(t *testing.T) {
+
+	srv := NewServer(":9940")
+	ran := false
+	srv.AddWarmup(WarmupHook{Name: "smoke", Required: true, Timeout: 10 * time.Millisecond, Run: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+	srv.AddWarmup(WarmupHook{Name: "after", Run: func(ctx context.Context) error {
+		ran = true
+		return nil
+	}})
+
+	err := srv.runWarmups(context.Background())
+	assert.EqualError(t, err, "Required warmup smoke failed: Timed out after 10ms")
+	assert.Equal(t, err, srv.warmupError())
+	assert.False(t, ran)
+	assert.False(t, srv.IsReady())
+}
@@ -0,0 +1,148 @@
+package vertex
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// Worker is a background task that runs for the lifetime of a server, e.g. a queue consumer
+type Worker interface {
+	// Run runs the worker until the context is canceled. It should finish its pending work before returning
+	Run(ctx context.Context) error
+}
+
+// WorkerFunc wraps a func as a Worker
+type WorkerFunc func(ctx context.Context) error
+
+// Run calls the func
+func (f WorkerFunc) Run(ctx context.Context) error {
+	return f(ctx)
+}
+
+type namedWorker struct {
+	name   string
+	worker Worker
+}
+
+// workers runs the background workers of a server
+type workers struct {
+	mtx     sync.Mutex
+	workers []namedWorker
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// add adds a worker, starting it right away if the workers are already running
+func (w *workers) add(name string, worker Worker) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	nw := namedWorker{name: name, worker: worker}
+	w.workers = append(w.workers, nw)
+	if w.cancel != nil {
+		w.run(w.ctx, nw)
+	}
+}
+
+func (w *workers) run(ctx context.Context, nw namedWorker) {
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		defer func() {
+			if e := recover(); e != nil {
+				logging.Error("Worker %s panicked: %v", nw.name, e)
+			}
+		}()
+
+		logging.Info("Starting worker %s", nw.name)
+		if err := nw.worker.Run(ctx); err != nil {
+			logging.Error("Worker %s failed: %s", nw.name, err)
+		}
+	}()
+}
+
+// start starts all the workers
+func (w *workers) start() {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if w.cancel != nil {
+		return
+	}
+
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	for _, nw := range w.workers {
+		w.run(w.ctx, nw)
+	}
+}
+
+// stop stops the workers and waits up to grace for them to finish their pending work
+func (w *workers) stop(grace time.Duration) {
+	w.mtx.Lock()
+	cancel := w.cancel
+	w.cancel = nil
+	w.mtx.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(grace):
+		logging.Warning("Workers did not stop within %v", grace)
+	}
+}
+
+// AddWorker adds a background worker to the server. Workers start when the server runs, and stop after it stops
+// serving requests, so requests in flight while the server shuts down can still hand work to them
+func (s *Server) AddWorker(name string, w Worker) {
+	s.workers.add(name, w)
+}
+
+type namedCloser struct {
+	name   string
+	closer io.Closer
+}
+
+var closers = struct {
+	sync.Mutex
+	list []namedCloser
+}{}
+
+// CloseOnShutdown registers a resource to close when the server stops, e.g. a connection pool or a producer.
+// Resources are closed after the server stopped serving requests and its workers stopped, so they can be used until
+// then, in reverse order of registration
+func CloseOnShutdown(name string, c io.Closer) {
+	closers.Lock()
+	defer closers.Unlock()
+	closers.list = append(closers.list, namedCloser{name: name, closer: c})
+}
+
+// closeResources closes the resources registered with CloseOnShutdown
+func closeResources() {
+
+	closers.Lock()
+	defer closers.Unlock()
+
+	for i := len(closers.list) - 1; i >= 0; i-- {
+		logging.Info("Closing %s", closers.list[i].name)
+		if err := closers.list[i].closer.Close(); err != nil {
+			logging.Error("Error closing %s: %s", closers.list[i].name, err)
+		}
+	}
+	closers.list = nil
+}
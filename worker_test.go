@@ -0,0 +1,76 @@
+package vertex
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkers(t *testing.T) {
+
+	var running, finished int32
+	worker := WorkerFunc(func(ctx context.Context) error {
+		atomic.AddInt32(&running, 1)
+		<-ctx.Done()
+		atomic.AddInt32(&finished, 1)
+		return nil
+	})
+
+	srv := NewServer(":9960")
+	srv.AddWorker("first", worker)
+	srv.AddWorker("panics", WorkerFunc(func(ctx context.Context) error {
+		panic("boom")
+	}))
+
+	srv.workers.start()
+	srv.workers.start()
+
+	// workers added to a running server start right away
+	srv.AddWorker("second", worker)
+
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&running) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&running))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&finished))
+
+	srv.workers.stop(time.Second)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&finished))
+
+	// workers that do not stop are abandoned after the grace period
+	srv.AddWorker("stuck", WorkerFunc(func(ctx context.Context) error {
+		time.Sleep(time.Hour)
+		return nil
+	}))
+	srv.workers.start()
+	st := time.Now()
+	srv.workers.stop(50 * time.Millisecond)
+	assert.True(t, time.Since(st) < time.Second)
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+func TestCloseOnShutdown(t *testing.T) {
+
+	var closed []string
+	CloseOnShutdown("first", closerFunc(func() error {
+		closed = append(closed, "first")
+		return nil
+	}))
+	CloseOnShutdown("second", closerFunc(func() error {
+		closed = append(closed, "second")
+		return nil
+	}))
+
+	closeResources()
+	closeResources()
+	assert.Equal(t, []string{"second", "first"}, closed)
+}
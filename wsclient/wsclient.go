@@ -0,0 +1,310 @@
+// Package wsclient is a minimal RFC 6455 websocket client, used by the vertex test runner to talk to websocket
+// routes.
+//
+// It supports what tests need - text messages, fragmented messages, pings and the close handshake - and bounds
+// the size of the messages it reads, so a misbehaving server cannot make it allocate arbitrary amounts of memory:
+//
+//	conn, err := wsclient.Dial(req)
+//	if err != nil {
+//		return err
+//	}
+//	defer conn.Close()
+//
+//	conn.WriteText([]byte("hello"))
+//	msg, err := conn.ReadMessage(time.Second)
+package wsclient
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Close codes
+const (
+	CloseNormal        = 1000
+	CloseGoingAway     = 1001
+	CloseProtocolError = 1002
+	CloseNoStatus      = 1005
+	CloseTooLarge      = 1009
+)
+
+// Frame opcodes
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xa
+)
+
+// DefaultMaxMessageSize is the size of the largest message a connection reads, unless set otherwise
+const DefaultMaxMessageSize = 1 << 20
+
+// control frames may not carry more than this
+const maxControlPayload = 125
+
+// the GUID of RFC 6455 the accept key is derived with
+const guid = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+	// ErrClosed is returned when reading from a connection the server closed
+	ErrClosed = errors.New("websocket closed")
+
+	// ErrMessageTooLarge is returned when the server sends a frame or message larger than the maximum message size
+	ErrMessageTooLarge = errors.New("websocket message too large")
+)
+
+// Conn is a client websocket connection
+type Conn struct {
+	// The size of the largest message read, DefaultMaxMessageSize by default. Larger messages fail the connection
+	MaxMessageSize int64
+
+	conn   net.Conn
+	br     *bufio.Reader
+	closed bool
+
+	// the close code and reason sent by the server, once it closed the connection
+	code   int
+	reason string
+}
+
+// Dial performs the websocket handshake of a GET request, returning the connection if the server switched protocols
+func Dial(r *http.Request) (*Conn, error) {
+
+	host := r.URL.Host
+	if r.URL.Port() == "" {
+		if r.URL.Scheme == "https" {
+			host += ":443"
+		} else {
+			host += ":80"
+		}
+	}
+
+	var conn net.Conn
+	var err error
+	if r.URL.Scheme == "https" {
+		conn, err = tls.Dial("tcp", host, &tls.Config{ServerName: r.URL.Hostname()})
+	} else {
+		conn, err = net.Dial("tcp", host)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Sec-WebSocket-Key", key)
+	r.Header.Set("Sec-WebSocket-Version", "13")
+	if err := r.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, r)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, fmt.Errorf("handshake failed: %s", resp.Status)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != AcceptKey(key) {
+		conn.Close()
+		return nil, errors.New("handshake failed: bad Sec-WebSocket-Accept")
+	}
+
+	return &Conn{MaxMessageSize: DefaultMaxMessageSize, conn: conn, br: br}, nil
+}
+
+// AcceptKey returns the Sec-WebSocket-Accept key a server answers a handshake key with
+func AcceptKey(key string) string {
+	h := sha1.Sum([]byte(key + guid))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// WriteText sends a text message
+func (c *Conn) WriteText(msg []byte) error {
+	return WriteFrame(c.conn, OpText, msg, true)
+}
+
+// ReadMessage waits for the next data message, answering pings and the server's close. It returns ErrClosed once
+// the server closed the connection, see CloseStatus
+func (c *Conn) ReadMessage(timeout time.Duration) ([]byte, error) {
+
+	if c.closed {
+		return nil, ErrClosed
+	}
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+
+	var msg []byte
+	for {
+		fin, opcode, payload, err := ReadFrame(c.br, c.MaxMessageSize-int64(len(msg)))
+		if err == ErrMessageTooLarge {
+			c.fail(CloseTooLarge, "message too large")
+			return nil, err
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case OpPing:
+			if err := WriteFrame(c.conn, OpPong, payload, true); err != nil {
+				return nil, err
+			}
+		case OpPong:
+		case OpClose:
+			c.closed = true
+			c.code = CloseNoStatus
+			if len(payload) >= 2 {
+				c.code = int(binary.BigEndian.Uint16(payload))
+				c.reason = string(payload[2:])
+			}
+			WriteFrame(c.conn, OpClose, payload, true)
+			return nil, ErrClosed
+		default:
+			msg = append(msg, payload...)
+			if fin {
+				return msg, nil
+			}
+		}
+	}
+}
+
+// CloseStatus returns the close code and reason sent by the server, and whether it closed the connection
+func (c *Conn) CloseStatus() (code int, reason string, closed bool) {
+	return c.code, c.reason, c.closed
+}
+
+// Close closes the connection normally
+func (c *Conn) Close() error {
+	if !c.closed {
+		WriteFrame(c.conn, OpClose, ClosePayload(CloseNormal, ""), true)
+	}
+	return c.conn.Close()
+}
+
+// fail closes the connection with a close code after a protocol violation of the server
+func (c *Conn) fail(code int, reason string) {
+	WriteFrame(c.conn, OpClose, ClosePayload(code, reason), true)
+	c.closed = true
+	c.code = code
+	c.reason = reason
+	c.conn.Close()
+}
+
+// ClosePayload formats the payload of a close frame
+func ClosePayload(code int, reason string) []byte {
+	b := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(b, uint16(code))
+	return append(b, reason...)
+}
+
+// ReadFrame reads a frame, unmasking its payload if it is masked. Data frames with payloads larger than max fail
+// with ErrMessageTooLarge before their payload is read
+func ReadFrame(r io.Reader, max int64) (fin bool, opcode byte, payload []byte, err error) {
+
+	head := make([]byte, 2)
+	if _, err = io.ReadFull(r, head); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	opcode = head[0] & 0x0f
+	masked := head[1]&0x80 != 0
+
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err = io.ReadFull(r, ext); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err = io.ReadFull(r, ext); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext)
+	}
+
+	if opcode >= OpClose && length > maxControlPayload {
+		err = fmt.Errorf("control frame of %d bytes", length)
+		return
+	}
+	if opcode < OpClose && (max < 0 || length > uint64(max)) {
+		err = ErrMessageTooLarge
+		return
+	}
+
+	var mask []byte
+	if masked {
+		mask = make([]byte, 4)
+		if _, err = io.ReadFull(r, mask); err != nil {
+			return
+		}
+	}
+
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return
+	}
+	for i := range mask {
+		for j := i; j < len(payload); j += 4 {
+			payload[j] ^= mask[i]
+		}
+	}
+	return
+}
+
+// WriteFrame writes a single final frame. Clients must mask their frames, servers must not
+func WriteFrame(w io.Writer, opcode byte, payload []byte, masked bool) error {
+
+	frame := []byte{0x80 | opcode}
+
+	var maskBit byte
+	if masked {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, maskBit|126, byte(n>>8), byte(n))
+	default:
+		ext := make([]byte, 8)
+		binary.BigEndian.PutUint64(ext, uint64(n))
+		frame = append(append(frame, maskBit|127), ext...)
+	}
+
+	data := payload
+	if masked {
+		mask := make([]byte, 4)
+		rand.Read(mask)
+		frame = append(frame, mask...)
+		data = make([]byte, len(payload))
+		for i := range payload {
+			data[i] = payload[i] ^ mask[i%4]
+		}
+	}
+
+	_, err := w.Write(append(frame, data...))
+	return err
+}
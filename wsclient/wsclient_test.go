@@ -0,0 +1,85 @@
+package wsclient
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrames(t *testing.T) {
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteFrame(&buf, OpText, []byte("hello"), true))
+	fin, opcode, payload, err := ReadFrame(&buf, DefaultMaxMessageSize)
+	assert.NoError(t, err)
+	assert.True(t, fin)
+	assert.Equal(t, byte(OpText), opcode)
+	assert.Equal(t, "hello", string(payload))
+
+	long := strings.Repeat("x", 70000)
+	assert.NoError(t, WriteFrame(&buf, OpBinary, []byte(long), false))
+	_, _, payload, err = ReadFrame(&buf, DefaultMaxMessageSize)
+	assert.NoError(t, err)
+	assert.Equal(t, long, string(payload))
+
+	// oversized frames are rejected before their payload is allocated
+	head := []byte{0x80 | OpText, 127, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint64(head[2:], 1<<62)
+	_, _, _, err = ReadFrame(bytes.NewReader(head), DefaultMaxMessageSize)
+	assert.Equal(t, ErrMessageTooLarge, err)
+
+	buf.Reset()
+	WriteFrame(&buf, OpPing, []byte(strings.Repeat("x", 200)), false)
+	_, _, _, err = ReadFrame(&buf, DefaultMaxMessageSize)
+	assert.Error(t, err)
+}
+
+func TestConn(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, _ := w.(http.Hijacker).Hijack()
+		defer conn.Close()
+		fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+			"Sec-WebSocket-Accept: %s\r\n\r\n", AcceptKey(r.Header.Get("Sec-WebSocket-Key")))
+		rw.Flush()
+
+		_, _, msg, err := ReadFrame(rw.Reader, DefaultMaxMessageSize)
+		if err != nil {
+			return
+		}
+		WriteFrame(conn, OpText, append([]byte("echo: "), msg...), false)
+		WriteFrame(conn, OpText, bytes.Repeat([]byte("x"), 100), false)
+		ReadFrame(bufio.NewReader(conn), DefaultMaxMessageSize)
+	}))
+	defer ts.Close()
+
+	hr, _ := http.NewRequest("GET", ts.URL+"/ws", nil)
+	conn, err := Dial(hr)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer conn.Close()
+
+	assert.NoError(t, conn.WriteText([]byte("hi")))
+	msg, err := conn.ReadMessage(time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "echo: hi", string(msg))
+
+	// messages over the maximum size fail the connection
+	conn.MaxMessageSize = 50
+	_, err = conn.ReadMessage(time.Second)
+	assert.Equal(t, ErrMessageTooLarge, err)
+	code, _, closed := conn.CloseStatus()
+	assert.True(t, closed)
+	assert.Equal(t, CloseTooLarge, code)
+	_, err = conn.ReadMessage(time.Second)
+	assert.Equal(t, ErrClosed, err)
+}